@@ -12,10 +12,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/reference/docker"
 	"github.com/distribution/reference"
 	"github.com/dustin/go-humanize"
@@ -23,14 +32,39 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/build"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/bundle"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/cache"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/cachediff"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/rule"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/chunkdict/generator"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/committer"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/compat"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter"
+	convertProvider "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/copier"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/dedup"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/fsck"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/i18n"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/lister"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/metrics"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/metrics/pushexporter"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/packer"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/progress"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/proxy"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/recompressor"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/retagger"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/retention"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/sandbox"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/seeder"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/shortname"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/transport"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/updater"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/viewer"
 )
@@ -43,8 +77,140 @@ var (
 
 var maxCacheMaxRecords uint = 200
 
+// templatePattern matches the variables in target image reference template.
+var templatePattern = regexp.MustCompile(`{{[^{}]*}}`)
+
 const defaultLogLevel = logrus.InfoLevel
 
+// configError marks err as the failure of invalid flags, arguments or
+// configuration files.
+func configError(err error) error {
+	return utils.WithExitCode(err, utils.ExitConfig)
+}
+
+// checkMismatch marks err as the failure of image failing the check.
+func checkMismatch(err error) error {
+	return utils.WithExitCode(err, utils.ExitCheckMismatch)
+}
+
+// exitFailures describes the failure types of exit codes in the error
+// message.
+var exitFailures = map[int]string{
+	utils.ExitConfig:        "invalid options or configuration",
+	utils.ExitAuth:          "authentication failed",
+	utils.ExitSourceMissing: "source not found",
+	utils.ExitBuilder:       "builder failed",
+	utils.ExitPush:          "push failed",
+	utils.ExitCheckMismatch: "check failed",
+}
+
+// actionError is the error returned by the action of command, the other
+// errors returned by app are the usage errors like unknown or missing
+// flags.
+type actionError struct {
+	err error
+}
+
+func (e *actionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *actionError) Unwrap() error {
+	return e.err
+}
+
+// markActionErrors wraps the actions of commands and their subcommands to
+// mark the errors returned.
+func markActionErrors(commands []*cli.Command) {
+	for _, command := range commands {
+		if action := command.Action; action != nil {
+			command.Action = func(c *cli.Context) error {
+				if err := action(c); err != nil {
+					return &actionError{err: err}
+				}
+				return nil
+			}
+		}
+		markActionErrors(command.Subcommands)
+	}
+}
+
+// builderPriority is the priority of the spawned nydus-image processes set
+// by the global options, and builderPriorityDir holds the wrapper applying it.
+var (
+	builderPriority    utils.PriorityOpt
+	builderPriorityDir string
+)
+
+// hasFlag returns true if command defines the flag of name.
+func hasFlag(command *cli.Command, name string) bool {
+	for _, flag := range command.Flags {
+		for _, flagName := range flag.Names() {
+			if flagName == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wrapBuilderPriority wraps the befores of commands and their subcommands
+// with `--nydus-image` to replace it by the wrapper applying the global
+// priority options to the spawned nydus-image processes. The convert and
+// proxy commands pass the priority to converter instead, which applies it
+// out of the sandbox and job cgroup.
+func wrapBuilderPriority(commands []*cli.Command) {
+	for _, command := range commands {
+		if command.Name != "convert" && command.Name != "proxy" && hasFlag(command, "nydus-image") {
+			before := command.Before
+			command.Before = func(c *cli.Context) error {
+				if before != nil {
+					if err := before(c); err != nil {
+						return err
+					}
+				}
+				if !builderPriority.Enabled() {
+					return nil
+				}
+				dir, err := os.MkdirTemp("", "nydusify-priority-")
+				if err != nil {
+					return errors.Wrap(err, "create priority wrapper directory")
+				}
+				wrapperPath, err := utils.WrapPriority(c.String("nydus-image"), builderPriority, dir)
+				if err != nil {
+					os.RemoveAll(dir)
+					return err
+				}
+				builderPriorityDir = dir
+				return c.Set("nydus-image", wrapperPath)
+			}
+		}
+		wrapBuilderPriority(command.Subcommands)
+	}
+}
+
+// run runs the app and returns the exit code classified by the failure
+// type, see the "Exit codes" section of docs/nydusify.md.
+func run(app *cli.App, args []string) int {
+	markActionErrors(app.Commands)
+	wrapBuilderPriority(app.Commands)
+	err := app.Run(args)
+	if err == nil {
+		return utils.ExitSuccess
+	}
+	code := utils.ExitConfig
+	var actionErr *actionError
+	if errors.As(err, &actionErr) {
+		code = utils.ExitCode(err)
+	}
+	if failure, ok := exitFailures[code]; ok {
+		logrus.Errorf("%s: %s", i18n.T(failure), err)
+	} else {
+		logrus.Error(err)
+	}
+	return code
+}
+
 func isPossibleValue(excepted []string, value string) bool {
 	for _, v := range excepted {
 		if value == v {
@@ -57,13 +223,13 @@ func isPossibleValue(excepted []string, value string) bool {
 // This only works for OSS backend right now
 func parseBackendConfig(backendConfigJSON, backendConfigFile string) (string, error) {
 	if backendConfigJSON != "" && backendConfigFile != "" {
-		return "", fmt.Errorf("--backend-config conflicts with --backend-config-file")
+		return "", configError(i18n.Errorf("--backend-config conflicts with --backend-config-file"))
 	}
 
 	if backendConfigFile != "" {
 		_backendConfigJSON, err := os.ReadFile(backendConfigFile)
 		if err != nil {
-			return "", errors.Wrap(err, "parse backend config file")
+			return "", configError(i18n.Wrap(err, "parse backend config file"))
 		}
 		backendConfigJSON = string(_backendConfigJSON)
 	}
@@ -75,14 +241,14 @@ func getBackendConfig(c *cli.Context, prefix string, required bool) (string, str
 	backendType := c.String(prefix + "backend-type")
 	if backendType == "" {
 		if required {
-			return "", "", errors.Errorf("backend type is empty, please specify option '--%sbackend-type'", prefix)
+			return "", "", configError(i18n.Errorf("backend type is empty, please specify option '--%sbackend-type'", prefix))
 		}
 		return "", "", nil
 	}
 
-	possibleBackendTypes := []string{"oss", "s3"}
+	possibleBackendTypes := []string{"oss", "s3", "localfs", "azblob"}
 	if !isPossibleValue(possibleBackendTypes, backendType) {
-		return "", "", fmt.Errorf("--%sbackend-type should be one of %v", prefix, possibleBackendTypes)
+		return "", "", configError(i18n.Errorf("--%sbackend-type should be one of %v", prefix, possibleBackendTypes))
 	}
 
 	backendConfig, err := parseBackendConfig(
@@ -90,8 +256,8 @@ func getBackendConfig(c *cli.Context, prefix string, required bool) (string, str
 	)
 	if err != nil {
 		return "", "", err
-	} else if (backendType == "oss" || backendType == "s3") && strings.TrimSpace(backendConfig) == "" {
-		return "", "", errors.Errorf("backend configuration is empty, please specify option '--%sbackend-config'", prefix)
+	} else if backendType != "registry" && strings.TrimSpace(backendConfig) == "" {
+		return "", "", configError(i18n.Errorf("backend configuration is empty, please specify option '--%sbackend-config'", prefix))
 	}
 
 	return backendType, backendConfig, nil
@@ -104,28 +270,100 @@ func getBackendConfig(c *cli.Context, prefix string, required bool) (string, str
 func addReferenceSuffix(source, suffix string) (string, error) {
 	named, err := docker.ParseDockerRef(source)
 	if err != nil {
-		return "", fmt.Errorf("invalid source image reference: %s", err)
+		return "", configError(i18n.Errorf("invalid source image reference: %s", err))
 	}
 	if _, ok := named.(docker.Digested); ok {
-		return "", fmt.Errorf("unsupported digested image reference: %s", named.String())
+		return "", configError(i18n.Errorf("unsupported digested image reference: %s", named.String()))
 	}
 	named = docker.TagNameOnly(named)
 	target := named.String() + suffix
 	return target, nil
 }
 
+// Render the target image reference template by the parts of source image
+// reference, like this:
+// Source: docker.io/library/nginx:1.25
+// Template: myregistry/{{namespace}}/{{name}}:{{tag}}-nydus
+// Target: myregistry/library/nginx:1.25-nydus
+func renderTargetTemplate(source, template string) (string, error) {
+	named, err := docker.ParseDockerRef(source)
+	if err != nil {
+		return "", configError(i18n.Errorf("invalid source image reference: %s", err))
+	}
+	path := docker.Path(named)
+	namespace, name := "", path
+	if idx := strings.LastIndex(path, "/"); idx >= 0 {
+		namespace, name = path[:idx], path[idx+1:]
+	}
+	repo := docker.Domain(named)
+	if namespace != "" {
+		repo += "/" + namespace
+	}
+	tag := ""
+	if tagged, ok := named.(docker.Tagged); ok {
+		tag = tagged.Tag()
+	}
+
+	vars := map[string]string{
+		"registry":  docker.Domain(named),
+		"repo":      repo,
+		"namespace": namespace,
+		"name":      name,
+		"tag":       tag,
+	}
+	var renderErr error
+	target := templatePattern.ReplaceAllStringFunc(template, func(match string) string {
+		key := strings.TrimSpace(match[2 : len(match)-2])
+		value, ok := vars[key]
+		if !ok {
+			renderErr = fmt.Errorf("unknown variable %s in target template", match)
+		} else if value == "" && renderErr == nil {
+			renderErr = fmt.Errorf("variable %s is empty for source image %s", match, named.String())
+		}
+		return value
+	})
+	if renderErr != nil {
+		return "", configError(renderErr)
+	}
+	if _, err := docker.ParseDockerRef(target); err != nil {
+		return "", configError(i18n.Errorf("invalid target image reference %s rendered from template: %s", target, err))
+	}
+	return target, nil
+}
+
 func getTargetReference(c *cli.Context) (string, error) {
 	target := c.String("target")
 	targetSuffix := c.String("target-suffix")
+	targetTemplate := c.String("target-template")
 	if target != "" && targetSuffix != "" {
-		return "", fmt.Errorf("--target conflicts with --target-suffix")
+		return "", configError(i18n.Errorf("--target conflicts with --target-suffix"))
+	}
+	if targetTemplate != "" && (target != "" || targetSuffix != "") {
+		return "", configError(i18n.Errorf("--target-template conflicts with --target and --target-suffix"))
+	}
+	if targetTemplate != "" {
+		source := c.String("source")
+		if parsed, err := transport.Parse(source); err == nil {
+			if !parsed.IsRegistry() {
+				return "", configError(i18n.Errorf("--target-template can't be used with source in %s transport", parsed.Transport))
+			}
+			source = parsed.Name
+		}
+		return renderTargetTemplate(source, targetTemplate)
 	}
 	if target == "" && targetSuffix == "" {
-		return "", fmt.Errorf("--target or --target-suffix is required")
+		return "", configError(i18n.Errorf("--target or --target-suffix is required"))
 	}
-	var err error
 	if targetSuffix != "" {
-		target, err = addReferenceSuffix(c.String("source"), targetSuffix)
+		source := c.String("source")
+		if parsed, err := transport.Parse(source); err == nil {
+			if !parsed.IsRegistry() {
+				return "", configError(i18n.Errorf("--target-suffix can't be used with source in %s transport", parsed.Transport))
+			}
+			source = parsed.Name
+		}
+		var err error
+		target, err = addReferenceSuffix(source, targetSuffix)
 		if err != nil {
 			return "", err
 		}
@@ -133,16 +371,187 @@ func getTargetReference(c *cli.Context) (string, error) {
 	return target, nil
 }
 
+// getCopyImages returns the images to copy from --sources and --source-file,
+// nil if neither is specified. The lines of source file are in the form of
+// `SOURCE [TARGET]`, the targets not specified are generated from the
+// --target-template.
+func getCopyImages(c *cli.Context) ([]copier.Image, error) {
+	sources := c.StringSlice("sources")
+	sourceFile := c.String("source-file")
+	if len(sources) == 0 && sourceFile == "" {
+		return nil, nil
+	}
+	if c.String("source") != "" || c.String("target") != "" {
+		return nil, configError(i18n.Errorf("--sources and --source-file conflict with --source and --target"))
+	}
+
+	images := []copier.Image{}
+	for _, source := range sources {
+		images = append(images, copier.Image{Source: source})
+	}
+	if sourceFile != "" {
+		data, err := os.ReadFile(sourceFile)
+		if err != nil {
+			return nil, configError(i18n.Wrap(err, "read source file"))
+		}
+		for idx, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) > 2 {
+				return nil, configError(i18n.Errorf("invalid line %d in %s, should be in the form of 'SOURCE [TARGET]'", idx+1, sourceFile))
+			}
+			image := copier.Image{Source: fields[0]}
+			if len(fields) == 2 {
+				image.Target = fields[1]
+			}
+			images = append(images, image)
+		}
+	}
+	if len(images) == 0 {
+		return nil, configError(i18n.Errorf("no image to copy in %s", sourceFile))
+	}
+
+	targetTemplate := c.String("target-template")
+	for idx := range images {
+		if images[idx].Target != "" {
+			continue
+		}
+		if targetTemplate == "" {
+			return nil, configError(i18n.Errorf("--target-template is required to copy %s without target", images[idx].Source))
+		}
+		source := images[idx].Source
+		if parsed, err := transport.Parse(source); err == nil {
+			if !parsed.IsRegistry() {
+				return nil, configError(i18n.Errorf("--target-template can't be used with source in %s transport", parsed.Transport))
+			}
+			source = parsed.Name
+		}
+		target, err := renderTargetTemplate(source, targetTemplate)
+		if err != nil {
+			return nil, err
+		}
+		images[idx].Target = target
+	}
+	return images, nil
+}
+
+// getRegistryReference returns the image reference of flag, which must be
+// an image in registry.
+func getRegistryReference(c *cli.Context, name string) (string, error) {
+	if c.String(name) == "" {
+		return "", nil
+	}
+	return transport.RegistryReference(c.String(name))
+}
+
+// shortNames is the short name config set by the global `--short-name-config`
+// option, nil if not specified.
+var shortNames *shortname.Config
+
+// imageExists returns whether the image of reference exists in registry,
+// it's used to find the short name among unqualified search registries.
+func imageExists(insecure bool) func(context.Context, string) (bool, error) {
+	return func(ctx context.Context, ref string) (bool, error) {
+		rmt, err := provider.DefaultRemote(ref, insecure)
+		if err != nil {
+			return false, err
+		}
+		if _, err = rmt.Resolve(ctx); err != nil {
+			rmt.MaybeWithHTTP(err)
+			if rmt.IsWithHTTP() {
+				_, err = rmt.Resolve(ctx)
+			}
+		}
+		if errdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
+
+// resolveShortNames replaces the registry image references of `--source`
+// and `--target` options by the short name config, the source is looked up
+// in the unqualified search registries in order, while the target to be
+// pushed is resolved with the first one.
+func resolveShortNames(c *cli.Context) error {
+	if shortNames == nil {
+		return nil
+	}
+	for _, name := range []string{"source", "target"} {
+		parsed, err := transport.Parse(c.String(name))
+		if c.String(name) == "" || err != nil || !parsed.IsRegistry() {
+			continue
+		}
+		var resolved string
+		if name == "source" {
+			resolved, err = shortNames.Resolve(c.Context, parsed.Name, imageExists(c.Bool("source-insecure")))
+		} else {
+			resolved, err = shortNames.First(parsed.Name)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "resolve --%s", name)
+		}
+		if resolved == parsed.Name {
+			continue
+		}
+		if strings.HasPrefix(c.String(name), transport.Docker+":") {
+			resolved = transport.Docker + "://" + resolved
+		}
+		if err := c.Set(name, resolved); err != nil {
+			return errors.Wrapf(err, "set --%s", name)
+		}
+	}
+	return nil
+}
+
+// parseSizeLimit parses the human readable size of flag, 0 means no limit.
+func parseSizeLimit(c *cli.Context, name string) (int64, error) {
+	if c.String(name) == "" {
+		return 0, nil
+	}
+	size, err := humanize.ParseBytes(c.String(name))
+	if err != nil {
+		return 0, configError(i18n.Wrapf(err, "invalid --%s option", name))
+	}
+	return int64(size), nil
+}
+
+// parseTenantQuotas parses the quotas in the form of `tenant=N`.
+func parseTenantQuotas(values []string) (map[string]int, error) {
+	quotas := map[string]int{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, configError(i18n.Errorf("invalid tenant quota %s, should be in the form of tenant=N", value))
+		}
+		quota, err := strconv.Atoi(parts[1])
+		if err != nil || quota < 0 {
+			return nil, configError(i18n.Errorf("invalid tenant quota %s, should be a non-negative integer", value))
+		}
+		quotas[parts[0]] = quota
+	}
+	return quotas, nil
+}
+
 func getCacheReference(c *cli.Context, target string) (string, error) {
 	cache := c.String("build-cache")
 	cacheTag := c.String("build-cache-tag")
 	if cache != "" && cacheTag != "" {
-		return "", fmt.Errorf("--build-cache conflicts with --build-cache-tag")
+		return "", configError(i18n.Errorf("--build-cache conflicts with --build-cache-tag"))
 	}
 	if cacheTag != "" {
+		if parsed, err := transport.Parse(target); err == nil {
+			if !parsed.IsRegistry() {
+				return "", configError(i18n.Errorf("--build-cache-tag can't be used with target in %s transport", parsed.Transport))
+			}
+			target = parsed.Name
+		}
 		named, err := docker.ParseDockerRef(target)
 		if err != nil {
-			return "", fmt.Errorf("invalid target image reference: %s", err)
+			return "", configError(i18n.Errorf("invalid target image reference: %s", err))
 		}
 		cache = fmt.Sprintf("%s/%s:%s", docker.Domain(named), docker.Path(named), cacheTag)
 	}
@@ -154,7 +563,7 @@ func getPrefetchPatterns(c *cli.Context) (string, error) {
 	prefetchPatterns := c.Bool("prefetch-patterns")
 
 	if len(prefetchedDir) > 0 && prefetchPatterns {
-		return "", fmt.Errorf("--prefetch-dir conflicts with --prefetch-patterns")
+		return "", configError(i18n.Errorf("--prefetch-dir conflicts with --prefetch-patterns"))
 	}
 
 	var patterns string
@@ -171,6 +580,11 @@ func getPrefetchPatterns(c *cli.Context) (string, error) {
 		patterns = prefetchedDir
 	}
 
+	// The patterns are inferred from source image by converter.
+	if len(patterns) == 0 && c.Bool("prefetch-heuristic") {
+		return "", nil
+	}
+
 	if len(patterns) == 0 {
 		patterns = "/"
 	}
@@ -178,6 +592,107 @@ func getPrefetchPatterns(c *cli.Context) (string, error) {
 	return patterns, nil
 }
 
+// loadCacheDump dumps the build cache image of ref, or reads the dump file.
+func loadCacheDump(c *cli.Context, ref string, opt cachediff.Opt) (*cachediff.Dump, error) {
+	insecure := c.Bool("insecure")
+	return cachediff.Load(context.Background(), ref, opt, func(ref string) (*remote.Remote, error) {
+		return provider.DefaultRemote(ref, insecure)
+	})
+}
+
+// getUnpackFilter loads the unpack filter rules file, nil is returned if
+// it's not specified.
+func getUnpackFilter(c *cli.Context) (utils.UnpackFilter, error) {
+	path := c.String("unpack-filter")
+	if path == "" {
+		return nil, nil
+	}
+	rules, err := utils.LoadFilterRules(path)
+	if err != nil {
+		return nil, configError(err)
+	}
+	return rules, nil
+}
+
+// getCredentialManager loads the credential rules of `--credential-config`,
+// the audit is appended to the file of `--credential-audit`. The returned
+// function closes the audit file.
+func getCredentialManager(c *cli.Context) (*converter.CredentialManager, func(), error) {
+	path, auditPath := c.String("credential-config"), c.String("credential-audit")
+	if path == "" {
+		if auditPath != "" {
+			return nil, nil, configError(i18n.Errorf("--credential-audit requires --credential-config"))
+		}
+		return nil, func() {}, nil
+	}
+	manager, err := converter.LoadCredentialManager(path)
+	if err != nil {
+		return nil, nil, configError(err)
+	}
+	if auditPath == "" {
+		return manager, func() {}, nil
+	}
+	file, err := os.OpenFile(auditPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, i18n.Wrap(err, "open credential audit file")
+	}
+	manager.SetAudit(file)
+	return manager, func() { file.Close() }, nil
+}
+
+// setRetryPolicy applies the retry flags to the registry requests.
+func setRetryPolicy(c *cli.Context) error {
+	pullRetry, pushRetry := c.Int("pull-retry"), c.Int("push-retry")
+	if pullRetry < 0 || pushRetry < 0 {
+		return configError(i18n.Errorf("--pull-retry and --push-retry can't be negative"))
+	}
+	convertProvider.LayerPullRetries = pullRetry
+	convertProvider.PullRetryPolicy = utils.NewRetryPolicy(pullRetry)
+	convertProvider.PushRetryPolicy = utils.NewRetryPolicy(pushRetry)
+	return nil
+}
+
+// setupProgress reports the progress of conversion as `--output-progress`,
+// the returned function stops reporting.
+func setupProgress(c *cli.Context) (func(), error) {
+	mode := c.String("output-progress")
+	if mode == "auto" {
+		mode = "none"
+		if progress.IsTerminal(os.Stderr) {
+			mode = "bar"
+		}
+	}
+
+	var sink progress.Sink
+	switch mode {
+	case "none":
+		return func() {}, nil
+	case "bar":
+		sink = progress.NewBarSink(os.Stderr)
+		// Print the logs above the progress bars, rather than mixing them.
+		logrus.SetOutput(sink.(io.Writer))
+	case "json":
+		sink = progress.NewJSONSink(os.Stdout)
+	default:
+		return nil, configError(i18n.Errorf("invalid --output-progress %s, should be auto, bar, json or none", mode))
+	}
+	progress.SetSink(sink)
+
+	return func() {
+		progress.SetSink(nil)
+		if err := sink.Close(); err != nil {
+			logrus.WithError(err).Warn("failed to close progress")
+		}
+		logrus.SetOutput(os.Stderr)
+	}, nil
+}
+
+// signalContext returns a context cancelled on SIGINT or SIGTERM, so that
+// the nydus-image processes started with it are killed on abort.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
 func main() {
 	logrus.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
@@ -207,6 +722,123 @@ func main() {
 			Usage:   "Set log level (panic, fatal, error, warn, info, debug, trace)",
 			EnvVars: []string{"LOG_LEVEL"},
 		},
+		&cli.BoolFlag{
+			Name:    "fips",
+			Value:   false,
+			Usage:   "Restrict hashing and TLS to FIPS 140 approved algorithms, requires nydusify to be built with GOFIPS140 or run with GODEBUG=fips140=on",
+			EnvVars: []string{"FIPS"},
+		},
+		&cli.StringFlag{
+			Name:    "lang",
+			Value:   "",
+			Usage:   "Language of error messages, like 'zh-CN', defaults to the locale of LC_ALL, LC_MESSAGES or LANG environment variable",
+			EnvVars: []string{"NYDUSIFY_LANG", "LC_ALL", "LC_MESSAGES", "LANG"},
+		},
+		&cli.IntFlag{
+			Name:    "nice",
+			Value:   0,
+			Usage:   "Set CPU nice value in range [-20, 19] for the spawned nydus-image processes",
+			EnvVars: []string{"NICE"},
+		},
+		&cli.StringFlag{
+			Name:    "ionice-class",
+			Value:   "",
+			Usage:   "Set IO scheduling class for the spawned nydus-image processes, possible values: realtime, best-effort, idle",
+			EnvVars: []string{"IONICE_CLASS"},
+		},
+		&cli.IntFlag{
+			Name:    "ionice-level",
+			Value:   4,
+			Usage:   "Set IO priority level in range [0, 7] for the realtime and best-effort IO scheduling classes",
+			EnvVars: []string{"IONICE_LEVEL"},
+		},
+		&cli.StringFlag{
+			Name:    "cgroup",
+			Value:   "",
+			Usage:   "Move the spawned nydus-image processes into an existing cgroup v2 directory, relative to /sys/fs/cgroup if not absolute",
+			EnvVars: []string{"CGROUP"},
+		},
+		&cli.StringFlag{
+			Name:    "builder-output-limit",
+			Value:   "256MiB",
+			Usage:   "Size cap of the output JSON written by nydus-image, which lists all blobs of bootstrap, '0' means no limit",
+			EnvVars: []string{"BUILDER_OUTPUT_LIMIT"},
+		},
+		&cli.IntFlag{
+			Name:    "unpack-workers",
+			Value:   8,
+			Usage:   "Number of workers writing the small files of source layers being unpacked, '0' falls back to the unpacker of containerd",
+			EnvVars: []string{"UNPACK_WORKERS"},
+		},
+		&cli.StringFlag{
+			Name:    "http-record",
+			Value:   "",
+			Usage:   "Record the HTTP traffic with registries and storage backends into a file with secrets redacted, for reproducing failures",
+			EnvVars: []string{"HTTP_RECORD"},
+		},
+		&cli.StringFlag{
+			Name:    "http-replay",
+			Value:   "",
+			Usage:   "Replay the HTTP traffic recorded by '--http-record' instead of accessing registries and storage backends",
+			EnvVars: []string{"HTTP_REPLAY"},
+		},
+		&cli.PathFlag{
+			Name:      "short-name-config",
+			Value:     "",
+			TakesFile: true,
+			Usage:     "Json file of unqualified search registries, aliases and rewriting rules to resolve the short image references like 'ubuntu:22.04' of convert, check and copy",
+			EnvVars:   []string{"SHORT_NAME_CONFIG"},
+		},
+	}
+
+	app.Before = func(c *cli.Context) error {
+		i18n.SetLanguage(c.String("lang"))
+		outputLimit, err := parseSizeLimit(c, "builder-output-limit")
+		if err != nil {
+			return err
+		}
+		build.MaxOutputSize = outputLimit
+		if c.Int("unpack-workers") < 0 {
+			return i18n.Errorf("--unpack-workers can't be negative")
+		}
+		utils.UnpackWorkers = c.Int("unpack-workers")
+		if c.String("http-record") != "" && c.String("http-replay") != "" {
+			return i18n.Errorf("--http-record and --http-replay can't be used together")
+		}
+		if path := c.String("http-record"); path != "" {
+			if err := utils.RecordHTTPTraffic(path); err != nil {
+				return err
+			}
+		}
+		if path := c.String("http-replay"); path != "" {
+			if err := utils.ReplayHTTPTraffic(path); err != nil {
+				return err
+			}
+		}
+		if path := c.String("short-name-config"); path != "" {
+			if shortNames, err = shortname.Load(path); err != nil {
+				return err
+			}
+		}
+		if c.Bool("fips") {
+			if err := utils.EnableFIPSMode(); err != nil {
+				return err
+			}
+		}
+		builderPriority = utils.PriorityOpt{
+			Nice:    c.Int("nice"),
+			IOClass: c.String("ionice-class"),
+			IOLevel: c.Int("ionice-level"),
+			Cgroup:  c.String("cgroup"),
+		}
+		return builderPriority.Validate()
+	}
+
+	app.After = func(c *cli.Context) error {
+		if builderPriorityDir != "" {
+			os.RemoveAll(builderPriorityDir)
+		}
+		return utils.CloseHTTPTraffic()
 	}
 
 	app.Commands = []*cli.Command{
@@ -217,13 +849,13 @@ func main() {
 				&cli.StringFlag{
 					Name:     "source",
 					Required: true,
-					Usage:    "Source OCI image reference",
+					Usage:    "Source OCI image reference, supports transports docker://, oci:, oci-archive:, docker-archive:, containers-storage: and dir:",
 					EnvVars:  []string{"SOURCE"},
 				},
 				&cli.StringFlag{
 					Name:     "target",
 					Required: false,
-					Usage:    "Target (Nydus) image reference",
+					Usage:    "Target (Nydus) image reference, supports the same transports as --source",
 					EnvVars:  []string{"TARGET"},
 				},
 				&cli.StringFlag{
@@ -232,6 +864,12 @@ func main() {
 					Usage:    "Generate the target image reference by adding a suffix to the source image reference, conflicts with --target",
 					EnvVars:  []string{"TARGET_SUFFIX"},
 				},
+				&cli.StringFlag{
+					Name:     "target-template",
+					Required: false,
+					Usage:    "Generate the target image reference from the template with variables of source image reference, for example: '{{repo}}/{{name}}:{{tag}}-nydus', possible variables: registry, repo, namespace, name, tag",
+					EnvVars:  []string{"TARGET_TEMPLATE"},
+				},
 				&cli.BoolFlag{
 					Name:     "source-insecure",
 					Required: false,
@@ -244,11 +882,23 @@ func main() {
 					Usage:    "Skip verifying server certs for HTTPS target registry",
 					EnvVars:  []string{"TARGET_INSECURE"},
 				},
+				&cli.BoolFlag{
+					Name:     "source-plain-http",
+					Required: false,
+					Usage:    "Access source registry by plain HTTP instead of HTTPS",
+					EnvVars:  []string{"SOURCE_PLAIN_HTTP"},
+				},
+				&cli.BoolFlag{
+					Name:     "target-plain-http",
+					Required: false,
+					Usage:    "Access target registry by plain HTTP instead of HTTPS",
+					EnvVars:  []string{"TARGET_PLAIN_HTTP"},
+				},
 
 				&cli.StringFlag{
 					Name:    "backend-type",
 					Value:   "",
-					Usage:   "Type of storage backend, possible values: 'oss', 's3'",
+					Usage:   "Type of storage backend, possible values: 'oss', 's3', 'localfs', 'azblob'",
 					EnvVars: []string{"BACKEND_TYPE"},
 				},
 				&cli.StringFlag{
@@ -269,6 +919,18 @@ func main() {
 					Value: false, Usage: "Force to push Nydus blobs even if they already exist in storage backend",
 					EnvVars: []string{"BACKEND_FORCE_PUSH"},
 				},
+				&cli.StringFlag{
+					Name:    "backend-object-layout",
+					Value:   "",
+					Usage:   "Object key prefix template of Nydus blobs in oss or s3 backend, appended to the object_prefix of backend config, possible placeholders: {registry}, {repository}, {date}, e.g. '{repository}/{date}'",
+					EnvVars: []string{"BACKEND_OBJECT_LAYOUT"},
+				},
+				&cli.BoolFlag{
+					Name:    "blob-meta",
+					Value:   false,
+					Usage:   "Push the blob meta (TOC) of Nydus blobs as '$blob_id.blob.meta' alongside the blobs to storage backend other than registry, enables lazy chunk verification on object storage backends",
+					EnvVars: []string{"BLOB_META"},
+				},
 
 				&cli.StringFlag{
 					Name:    "build-cache",
@@ -294,6 +956,12 @@ func main() {
 					Usage:    "Skip verifying server certs for HTTPS cache registry",
 					EnvVars:  []string{"BUILD_CACHE_INSECURE"},
 				},
+				&cli.BoolFlag{
+					Name:     "build-cache-plain-http",
+					Required: false,
+					Usage:    "Access cache registry by plain HTTP instead of HTTPS",
+					EnvVars:  []string{"BUILD_CACHE_PLAIN_HTTP"},
+				},
 				// The --build-cache-max-records flag represents the maximum number
 				// of layers in cache image. 200 (bootstrap + blob in one record) was
 				// chosen to make it compatible with the 127 max in graph driver of
@@ -304,6 +972,54 @@ func main() {
 					Usage:   "Maximum cache records in a cache image",
 					EnvVars: []string{"BUILD_CACHE_MAX_RECORDS"},
 				},
+				&cli.StringFlag{
+					Name:    "local-cache-dir",
+					Value:   "",
+					Usage:   "Directory of local build cache to reuse the layers converted by previous runs, e.g. ~/.nydusify/cache, only for registry backend",
+					EnvVars: []string{"LOCAL_CACHE_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "source-cache-dir",
+					Value:   "",
+					Usage:   "Directory to cache the downloaded source layers by digest, so that the conversions of many tags of the same application reuse the identical base layers, e.g. ~/.nydusify/cache",
+					EnvVars: []string{"SOURCE_CACHE_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "source-cache-max-size",
+					Value:   "20GiB",
+					Usage:   "Size cap of --source-cache-dir, the least recently used layers are evicted once exceeded, empty means no limit",
+					EnvVars: []string{"SOURCE_CACHE_MAX_SIZE"},
+				},
+				&cli.StringFlag{
+					Name:    "pushgateway-url",
+					Value:   "",
+					Usage:   "URL of Prometheus pushgateway to push the metrics of conversion to once it's done, e.g. http://pushgateway:9091",
+					EnvVars: []string{"PUSHGATEWAY_URL"},
+				},
+				&cli.StringFlag{
+					Name:    "pushgateway-job",
+					Value:   "nydusify",
+					Usage:   "Job name to group the metrics pushed to Prometheus pushgateway",
+					EnvVars: []string{"PUSHGATEWAY_JOB"},
+				},
+				&cli.StringFlag{
+					Name:    "metrics-addr",
+					Value:   "",
+					Usage:   "Address to expose the metrics of conversion at `/metrics` for Prometheus to scrape while it's running, e.g. :9090",
+					EnvVars: []string{"METRICS_ADDR"},
+				},
+				&cli.StringFlag{
+					Name:    "output-progress",
+					Value:   "auto",
+					Usage:   "Report the progress of pulling, building and uploading layers: 'bar' on stderr, 'json' line-delimited events on stdout, 'none', or 'auto' for 'bar' if stderr is a terminal",
+					EnvVars: []string{"OUTPUT_PROGRESS"},
+				},
+				&cli.StringFlag{
+					Name:    "previous-target",
+					Value:   "",
+					Usage:   "Target image converted from the previous version of source image, e.g. the previous tag, to reuse its layers for the unchanged source layers, only for registry backend",
+					EnvVars: []string{"PREVIOUS_TARGET"},
+				},
 				&cli.StringFlag{
 					Name:     "chunk-dict",
 					Required: false,
@@ -318,6 +1034,24 @@ func main() {
 					Usage:    "Skip verifying server certs for HTTPS dict registry",
 					EnvVars:  []string{"CHUNK_DICT_INSECURE"},
 				},
+				&cli.BoolFlag{
+					Name:     "chunk-dict-plain-http",
+					Required: false,
+					Usage:    "Access dict registry by plain HTTP instead of HTTPS",
+					EnvVars:  []string{"CHUNK_DICT_PLAIN_HTTP"},
+				},
+				&cli.StringFlag{
+					Name:    "dedup-db",
+					Value:   "",
+					Usage:   "URL of the dedup database shared by converters to look up the chunk dict if --chunk-dict is not specified, for examples: http://dedup.example.com/v1, redis://:password@127.0.0.1:6379/0",
+					EnvVars: []string{"DEDUP_DB"},
+				},
+				&cli.StringFlag{
+					Name:    "dedup-scope",
+					Value:   dedup.DefaultScope,
+					Usage:   "Scope in the dedup database to look up the chunk dict, the images converted in the same scope are deduplicated against the same chunk dict",
+					EnvVars: []string{"DEDUP_SCOPE"},
+				},
 
 				&cli.BoolFlag{
 					Name:    "merge-platform",
@@ -326,6 +1060,12 @@ func main() {
 					EnvVars: []string{"MERGE_PLATFORM"},
 					Aliases: []string{"multi-platform"},
 				},
+				&cli.BoolFlag{
+					Name:    "push-source-through",
+					Value:   false,
+					Usage:   "Push the source image as-is to the target repository as well, tagged with the '-oci' suffix of the target tag, for the runtimes falling back to OCI image, conflicts with --merge-platform",
+					EnvVars: []string{"PUSH_SOURCE_THROUGH"},
+				},
 				&cli.BoolFlag{
 					Name:  "all-platforms",
 					Value: false,
@@ -334,7 +1074,7 @@ func main() {
 				&cli.StringFlag{
 					Name:  "platform",
 					Value: "linux/" + runtime.GOARCH,
-					Usage: "Convert images for specific platforms, for example: 'linux/amd64,linux/arm64'",
+					Usage: "Convert images for specific platforms, for example: 'linux/amd64,linux/arm64' or 'all', the variant is matched only if specified, e.g. 'linux/arm/v7'",
 				},
 				&cli.BoolFlag{
 					Name:    "oci-ref",
@@ -367,6 +1107,12 @@ func main() {
 					Usage:       "Nydus image format version number, possible values: 5, 6",
 					EnvVars:     []string{"FS_VERSION"},
 				},
+				&cli.StringFlag{
+					Name:    "compat-fs-version",
+					Value:   "",
+					Usage:   "Also build the manifests with another RAFS version (5 or 6) from the same unpacked source layers, they're appended to the target index",
+					EnvVars: []string{"COMPAT_FS_VERSION"},
+				},
 				&cli.BoolFlag{
 					Name:    "fs-align-chunk",
 					Value:   false,
@@ -391,12 +1137,24 @@ func main() {
 					Usage:   "Read prefetch list from STDIN, please input absolute paths line by line",
 					EnvVars: []string{"PREFETCH_PATTERNS"},
 				},
+				&cli.BoolFlag{
+					Name:    "prefetch-heuristic",
+					Value:   true,
+					Usage:   "Infer the prefetch list from the entrypoint of source image (its ELF interpreter, linked libraries and common config files) if no prefetch list is specified, prefetch the whole filesystem if disabled",
+					EnvVars: []string{"PREFETCH_HEURISTIC"},
+				},
 				&cli.StringFlag{
 					Name:    "compressor",
 					Value:   "zstd",
 					Usage:   "Algorithm to compress image data blob, possible values: none, lz4_block, zstd",
 					EnvVars: []string{"COMPRESSOR"},
 				},
+				&cli.IntFlag{
+					Name:    "compression-level",
+					Value:   build.DefaultCompressionLevel,
+					Usage:   "Level to compress image data blob, only 0 (the default level of compressor) is supported by nydus-image",
+					EnvVars: []string{"COMPRESSION_LEVEL"},
+				},
 				&cli.StringFlag{
 					Name:    "fs-chunk-size",
 					Value:   "0x100000",
@@ -416,153 +1174,2591 @@ func main() {
 					Usage:   "Working directory for image conversion",
 					EnvVars: []string{"WORK_DIR"},
 				},
+				&cli.DurationFlag{
+					Name:    "work-dir-gc-age",
+					Value:   24 * time.Hour,
+					Usage:   "Remove the temp directories left by crashed runs in work directory older than the age at startup, 0 disables it",
+					EnvVars: []string{"WORK_DIR_GC_AGE"},
+				},
 				&cli.StringFlag{
-					Name:    "nydus-image",
-					Value:   "nydus-image",
-					Usage:   "Path to the nydus-image binary, default to search in PATH",
-					EnvVars: []string{"NYDUS_IMAGE"},
+					Name:    "unpack-dir",
+					Value:   "",
+					Usage:   "Directory for unpacking source layers and building blobs, for example on tmpfs, default to --work-dir",
+					EnvVars: []string{"UNPACK_DIR"},
 				},
 				&cli.StringFlag{
-					Name:    "output-json",
+					Name:    "unpack-dir-limit",
 					Value:   "",
-					Usage:   "File path to save the metrics collected during conversion in JSON format, for example: './output.json'",
-					EnvVars: []string{"OUTPUT_JSON"},
+					Usage:   "Size cap of --unpack-dir, the conversion fails once exceeded, for example: '4GiB'",
+					EnvVars: []string{"UNPACK_DIR_LIMIT"},
 				},
-			},
-			Action: func(c *cli.Context) error {
-				setupLogLevel(c)
-
-				targetRef, err := getTargetReference(c)
-				if err != nil {
-					return err
-				}
-
+				&cli.StringFlag{
+					Name:    "blob-dir",
+					Value:   "",
+					Usage:   "Directory for staging pulled and converted blobs, default to --work-dir",
+					EnvVars: []string{"BLOB_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "blob-dir-limit",
+					Value:   "",
+					Usage:   "Size cap of --blob-dir, the conversion fails once exceeded, for example: '100GiB'",
+					EnvVars: []string{"BLOB_DIR_LIMIT"},
+				},
+				&cli.BoolFlag{
+					Name:    "keep-workdir",
+					Value:   false,
+					Usage:   "Keep the temp directories of conversion on exit for debugging, with an index file 'artifacts/index.json' describing them",
+					EnvVars: []string{"KEEP_WORKDIR"},
+				},
+				&cli.BoolFlag{
+					Name:    "keep-rootfs",
+					Value:   false,
+					Usage:   "Retain the unpacked source layers built by nydus-image in the kept work directory, implies --keep-workdir",
+					EnvVars: []string{"KEEP_ROOTFS"},
+				},
+				&cli.BoolFlag{
+					Name:    "keep-bootstraps",
+					Value:   false,
+					Usage:   "Retain the per-layer and merged bootstraps in the kept work directory, implies --keep-workdir",
+					EnvVars: []string{"KEEP_BOOTSTRAPS"},
+				},
+				&cli.BoolFlag{
+					Name:    "keep-builder-output",
+					Value:   false,
+					Usage:   "Retain the output JSONs of nydus-image in the kept work directory, implies --keep-workdir",
+					EnvVars: []string{"KEEP_BUILDER_OUTPUT"},
+				},
+				&cli.StringFlag{
+					Name:    "nydus-image",
+					Value:   "nydus-image",
+					Usage:   "Path to the nydus-image binary, default to search in PATH",
+					EnvVars: []string{"NYDUS_IMAGE"},
+				},
+				&cli.StringFlag{
+					Name:    "output-json",
+					Value:   "",
+					Usage:   "File path to save the metrics collected during conversion in JSON format, for example: './output.json'",
+					EnvVars: []string{"OUTPUT_JSON"},
+				},
+				&cli.StringFlag{
+					Name:    "output-inventory",
+					Value:   "",
+					Usage:   "File path to save the inventory of produced blobs and bootstraps in JSON format, for example: './inventory.json'",
+					EnvVars: []string{"OUTPUT_INVENTORY"},
+				},
+				&cli.StringFlag{
+					Name:    "output-report",
+					Value:   "",
+					Usage:   "File path to save the conversion report with size comparison and dedup statistics in JSON format, for example: './report.json'",
+					EnvVars: []string{"OUTPUT_REPORT"},
+				},
+				&cli.StringFlag{
+					Name:    "history-dir",
+					Value:   "",
+					Usage:   "Directory to persist the conversion summary per target reference, which can be compared across rebuilds by 'nydusify history'",
+					EnvVars: []string{"HISTORY_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "bootstrap-placement",
+					Value:   converter.BootstrapPlacementLayer,
+					Usage:   "How to push nydus bootstrap, possible values: layer (image layer), artifact (separate artifact referenced by annotation), both",
+					EnvVars: []string{"BOOTSTRAP_PLACEMENT"},
+				},
+				&cli.StringFlag{
+					Name:    "bootstrap-compression",
+					Value:   converter.BootstrapCompressionGzip,
+					Usage:   "Compression of the pushed nydus bootstrap layer, possible values: gzip, zstd (requires OCI manifest), none",
+					EnvVars: []string{"BOOTSTRAP_COMPRESSION"},
+				},
+				&cli.PathFlag{
+					Name:      "manifest-profile",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json file renaming the layer media types, annotation keys and OS features in nydus manifests, for the nydus-snapshotter versions or custom runtimes expecting them",
+					EnvVars:   []string{"MANIFEST_PROFILE"},
+				},
+				&cli.PathFlag{
+					Name:      "config-mutation",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json file declaring the mutations of image config (labels, env, entrypoint, cmd) and manifest annotations of target image",
+					EnvVars:   []string{"CONFIG_MUTATION"},
+				},
+				&cli.PathFlag{
+					Name:      "policy",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json file of conversion policy mapping image name patterns to conversion options (chunk size, fs version, compressor, prefetch file, backend), which override the command options, and the allowlist and denylist of images",
+					EnvVars:   []string{"POLICY"},
+				},
+				&cli.PathFlag{
+					Name:      "publisher-config",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json file of publishers (exec command or HTTP endpoint) to notify external systems like release databases or artifact catalogs of the target image after successful conversion",
+					EnvVars:   []string{"PUBLISHER_CONFIG"},
+				},
+				&cli.PathFlag{
+					Name:      "credential-config",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json file of registry credentials scoped to hosts and operations (pull, push), which replaces the docker config, for running as a shared service",
+					EnvVars:   []string{"CREDENTIAL_CONFIG"},
+				},
+				&cli.PathFlag{
+					Name:      "credential-audit",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "File to append the audit of which credential is used to authenticate to which registry for which image in JSON lines, requires --credential-config",
+					EnvVars:   []string{"CREDENTIAL_AUDIT"},
+				},
+				&cli.PathFlag{
+					Name:      "unpack-filter",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json file of rules to filter or transform files of source layers before they're built (drop patterns, strip setuid bits, rewrite paths), to slim the image",
+					EnvVars:   []string{"UNPACK_FILTER"},
+				},
+				&cli.BoolFlag{
+					Name:    "provenance",
+					Value:   false,
+					Usage:   "Attach the SLSA provenance attestation of conversion (source digest, nydusify and builder versions, options) to target image via the Referrers API",
+					EnvVars: []string{"PROVENANCE"},
+				},
+				&cli.BoolFlag{
+					Name:    "verify-source",
+					Value:   false,
+					Usage:   "Verify the cosign signatures of source image before conversion, refuse to convert unsigned or mis-signed images",
+					EnvVars: []string{"VERIFY_SOURCE"},
+				},
+				&cli.StringFlag{
+					Name:    "verify-source-key",
+					Value:   "",
+					Usage:   "Path or KMS URI of the public key to verify source signatures",
+					EnvVars: []string{"VERIFY_SOURCE_KEY"},
+				},
+				&cli.StringFlag{
+					Name:    "verify-source-identity",
+					Value:   "",
+					Usage:   "Regexp of the certificate identity to verify keyless source signatures, requires --verify-source-issuer",
+					EnvVars: []string{"VERIFY_SOURCE_IDENTITY"},
+				},
+				&cli.StringFlag{
+					Name:    "verify-source-issuer",
+					Value:   "",
+					Usage:   "Regexp of the certificate OIDC issuer to verify keyless source signatures, requires --verify-source-identity",
+					EnvVars: []string{"VERIFY_SOURCE_ISSUER"},
+				},
+				&cli.StringFlag{
+					Name:    "cosign",
+					Value:   "cosign",
+					Usage:   "Path to the cosign binary, default to search in PATH",
+					EnvVars: []string{"COSIGN"},
+				},
+				&cli.BoolFlag{
+					Name:    "sign-target",
+					Value:   false,
+					Usage:   "Sign the pushed target image by notation (Notary Project) after conversion",
+					EnvVars: []string{"SIGN_TARGET"},
+				},
+				&cli.StringFlag{
+					Name:    "sign-key",
+					Value:   "",
+					Usage:   "Name of the signing key in notation key store",
+					EnvVars: []string{"SIGN_KEY"},
+				},
+				&cli.StringFlag{
+					Name:    "sign-key-id",
+					Value:   "",
+					Usage:   "Id of the signing key in remote KMS, requires --sign-plugin",
+					EnvVars: []string{"SIGN_KEY_ID"},
+				},
+				&cli.StringFlag{
+					Name:    "sign-plugin",
+					Value:   "",
+					Usage:   "Name of the notation plugin to access the signing key in remote KMS, requires --sign-key-id",
+					EnvVars: []string{"SIGN_PLUGIN"},
+				},
+				&cli.StringSliceFlag{
+					Name:    "sign-plugin-config",
+					Usage:   "Config of notation plugin in the form of key=value, can be specified multiple times",
+					EnvVars: []string{"SIGN_PLUGIN_CONFIG"},
+				},
+				&cli.StringFlag{
+					Name:    "signature-format",
+					Value:   "",
+					Usage:   "Envelope format of signature, possible values: jws, cose, default to the one of notation",
+					EnvVars: []string{"SIGNATURE_FORMAT"},
+				},
+				&cli.StringFlag{
+					Name:    "notation",
+					Value:   "notation",
+					Usage:   "Path to the notation binary, default to search in PATH",
+					EnvVars: []string{"NOTATION"},
+				},
+				&cli.BoolFlag{
+					Name:    "validate-source",
+					Value:   false,
+					Usage:   "Validate the tar entries of source layers before unpacking, refuse the layers with path traversal attempts, symlink escapes or entries lying about size, for converting untrusted images",
+					EnvVars: []string{"VALIDATE_SOURCE"},
+				},
+				&cli.StringFlag{
+					Name:    "validate-max-entry-size",
+					Value:   "",
+					Usage:   "Refuse the source layers containing a file larger than the size with --validate-source, e.g. 4GiB, default no limit",
+					EnvVars: []string{"VALIDATE_MAX_ENTRY_SIZE"},
+				},
+				&cli.BoolFlag{
+					Name:    "adaptive-concurrency",
+					Value:   false,
+					Usage:   "Adjust the concurrency of pulling and pushing layers at runtime based on throughput and system load, the layer builds are not covered",
+					EnvVars: []string{"ADAPTIVE_CONCURRENCY"},
+				},
+				&cli.IntFlag{
+					Name:    "max-concurrency",
+					Value:   0,
+					Usage:   "Maximum concurrency of pulling and pushing layers used by --adaptive-concurrency, default to twice the CPU count",
+					EnvVars: []string{"MAX_CONCURRENCY"},
+				},
+				&cli.IntFlag{
+					Name:    "max-concurrent-builds",
+					Value:   0,
+					Usage:   "Maximum number of layers built by nydus-image concurrently, 0 means no limit",
+					EnvVars: []string{"MAX_CONCURRENT_BUILDS"},
+				},
+				&cli.BoolFlag{
+					Name:    "overlap-push",
+					Value:   false,
+					Usage:   "Push each converted blob to target registry as soon as it's built while the next layers are still building, ignored with --backend-type",
+					EnvVars: []string{"OVERLAP_PUSH"},
+				},
+				&cli.IntFlag{
+					Name:    "pull-retry",
+					Value:   3,
+					Usage:   "Max retries of the registry requests pulling images failed by 429, 5xx or network errors, with exponential backoff honoring Retry-After, 0 disables it",
+					EnvVars: []string{"PULL_RETRY"},
+				},
+				&cli.IntFlag{
+					Name:    "push-retry",
+					Value:   3,
+					Usage:   "Max retries of the registry requests and blobs pushing images failed by 429, 5xx or network errors, with exponential backoff honoring Retry-After, 0 disables it",
+					EnvVars: []string{"PUSH_RETRY"},
+				},
+				&cli.StringFlag{
+					Name:    "digest-algorithm",
+					Value:   "sha256",
+					Usage:   "Digest algorithm for the pushed index, manifests, configs and bootstrap layers, possible values: sha256, sha384, sha512",
+					EnvVars: []string{"DIGEST_ALGORITHM"},
+				},
+				&cli.BoolFlag{
+					Name:    "verify-push",
+					Value:   false,
+					Usage:   "Fetch each pushed manifest back by digest to verify the registry stored exactly what was sent",
+					EnvVars: []string{"VERIFY_PUSH"},
+				},
+				&cli.BoolFlag{
+					Name:    "verify",
+					Value:   false,
+					Usage:   "Verify the file metadata, xattrs and data of target image against the source image after conversion, only for source and target in registry",
+					EnvVars: []string{"VERIFY"},
+				},
+				&cli.BoolFlag{
+					Name:    "verify-native",
+					Value:   false,
+					Usage:   "Read the target image by nydus-image instead of mounting it by nydusd for --verify, without FUSE",
+					EnvVars: []string{"VERIFY_NATIVE"},
+				},
+				&cli.StringFlag{
+					Name:    "verify-report",
+					Value:   "",
+					Usage:   "File path to save the discrepancies found by --verify in JSON format",
+					EnvVars: []string{"VERIFY_REPORT"},
+				},
+				&cli.StringFlag{
+					Name:    "nydusd",
+					Value:   "nydusd",
+					Usage:   "Path to the nydusd binary used by --verify, default to search in PATH",
+					EnvVars: []string{"NYDUSD"},
+				},
+				&cli.BoolFlag{
+					Name:    "verify-run",
+					Value:   false,
+					Usage:   "Run a short-lived container from the target image by containerd with nydus-snapshotter after conversion, only for target in registry",
+					EnvVars: []string{"VERIFY_RUN"},
+				},
+				&cli.StringFlag{
+					Name:    "verify-run-command",
+					Value:   "",
+					Usage:   "Command of the container run by --verify-run, which must exit with zero, in JSON array like '[\"sh\", \"-c\", \"nginx -t\"]' or whitespace separated words, default to the process of image which passes if still running at timeout",
+					EnvVars: []string{"VERIFY_RUN_COMMAND"},
+				},
+				&cli.DurationFlag{
+					Name:    "verify-run-timeout",
+					Value:   30 * time.Second,
+					Usage:   "Time to wait for the container run by --verify-run",
+					EnvVars: []string{"VERIFY_RUN_TIMEOUT"},
+				},
+				&cli.StringFlag{
+					Name:    "containerd-address",
+					Value:   "/run/containerd/containerd.sock",
+					Usage:   "Containerd address used by --verify-run",
+					EnvVars: []string{"CONTAINERD_ADDR"},
+				},
+				&cli.StringFlag{
+					Name:    "snapshotter",
+					Value:   "nydus",
+					Usage:   "Name of nydus-snapshotter in containerd used by --verify-run",
+					EnvVars: []string{"SNAPSHOTTER"},
+				},
+				&cli.BoolFlag{
+					Name:    "skip-converted",
+					Value:   false,
+					Usage:   "Skip the conversion if the target image is already converted from the same source digest with identical options, only for source and target in registry",
+					EnvVars: []string{"SKIP_CONVERTED"},
+				},
+				&cli.BoolFlag{
+					Name:    "sandbox",
+					Value:   false,
+					Usage:   "Run nydus-image with no network, a restricted seccomp profile and a read-only view of everything except the work directory",
+					EnvVars: []string{"SANDBOX"},
+				},
+				&cli.BoolFlag{
+					Name:    "keep-going",
+					Value:   false,
+					Usage:   "Continue with the rest platforms after a platform fails, the target index only references the succeeded platforms, exits non-zero with a summary of failures",
+					EnvVars: []string{"KEEP_GOING"},
+				},
+				&cli.DurationFlag{
+					Name:    "deadline",
+					Value:   0,
+					Usage:   "Time limit of conversion, e.g. 1h, 0 means no limit",
+					EnvVars: []string{"DEADLINE"},
+				},
+				&cli.StringFlag{
+					Name:    "deadline-action",
+					Value:   converter.DeadlineAbort,
+					Usage:   "Action at --deadline, possible values: abort (roll back the pushed target images), partial (push the platforms converted in time)",
+					EnvVars: []string{"DEADLINE_ACTION"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+				if err := setRetryPolicy(c); err != nil {
+					return err
+				}
+				if err := resolveShortNames(c); err != nil {
+					return err
+				}
+
+				targetRef, err := getTargetReference(c)
+				if err != nil {
+					return err
+				}
+
 				backendType, backendConfig, err := getBackendConfig(c, "", false)
 				if err != nil {
 					return err
 				}
 
-				cacheRef, err := getCacheReference(c, targetRef)
+				cacheRef, err := getCacheReference(c, targetRef)
+				if err != nil {
+					return err
+				}
+				cacheMaxRecords := c.Uint("build-cache-max-records")
+				if cacheMaxRecords < 1 {
+					return configError(i18n.Errorf("--build-cache-max-records should be greater than 0"))
+				}
+				if cacheMaxRecords > maxCacheMaxRecords {
+					return configError(i18n.Errorf("--build-cache-max-records should not be greater than %d", maxCacheMaxRecords))
+				}
+				cacheVersion := c.String("build-cache-version")
+
+				fsVersion := c.String("fs-version")
+				possibleFsVersions := []string{"5", "6"}
+				if !isPossibleValue(possibleFsVersions, fsVersion) {
+					return configError(i18n.Errorf("--fs-version should be one of %v", possibleFsVersions))
+				}
+				compatFsVersion := c.String("compat-fs-version")
+				if compatFsVersion != "" {
+					if !isPossibleValue(possibleFsVersions, compatFsVersion) {
+						return configError(i18n.Errorf("--compat-fs-version should be one of %v", possibleFsVersions))
+					}
+					if compatFsVersion == fsVersion {
+						return configError(i18n.Errorf("--compat-fs-version should be different from --fs-version"))
+					}
+				}
+
+				prefetchPatterns, err := getPrefetchPatterns(c)
+				if err != nil {
+					return err
+				}
+
+				chunkDictRef := ""
+				chunkDict := c.String("chunk-dict")
+				if chunkDict != "" {
+					_, _, chunkDictRef, err = converter.ParseChunkDictArgs(chunkDict)
+					if err != nil {
+						return configError(i18n.Wrap(err, "parse chunk dict arguments"))
+					}
+				}
+
+				bootstrapPlacement := c.String("bootstrap-placement")
+				if err := converter.ValidateBootstrapPlacement(bootstrapPlacement); err != nil {
+					return configError(err)
+				}
+				if err := converter.ValidateDeadlineAction(c.String("deadline-action")); err != nil {
+					return configError(err)
+				}
+				if err := build.ValidateCompression(c.String("compressor"), c.Int("compression-level")); err != nil {
+					return configError(err)
+				}
+				bootstrapCompression := c.String("bootstrap-compression")
+				if err := converter.ValidateBootstrapCompression(bootstrapCompression); err != nil {
+					return configError(err)
+				}
+
+				var manifestProfile *converter.ManifestProfile
+				if path := c.String("manifest-profile"); path != "" {
+					if manifestProfile, err = converter.LoadManifestProfile(path); err != nil {
+						return configError(err)
+					}
+				}
+
+				var configMutation *converter.ConfigMutation
+				if path := c.String("config-mutation"); path != "" {
+					if configMutation, err = converter.LoadConfigMutation(path); err != nil {
+						return configError(err)
+					}
+				}
+
+				var policy *converter.Policy
+				if path := c.String("policy"); path != "" {
+					if policy, err = converter.LoadPolicy(path); err != nil {
+						return configError(err)
+					}
+				}
+
+				var publishing *converter.Publishing
+				if path := c.String("publisher-config"); path != "" {
+					if publishing, err = converter.LoadPublishing(path); err != nil {
+						return configError(err)
+					}
+				}
+
+				credentials, closeAudit, err := getCredentialManager(c)
+				if err != nil {
+					return err
+				}
+				defer closeAudit()
+
+				unpackFilter, err := getUnpackFilter(c)
+				if err != nil {
+					return err
+				}
+
+				var verifySource *converter.SourceVerification
+				if c.Bool("verify-source") {
+					verifySource = &converter.SourceVerification{
+						CosignPath:            c.String("cosign"),
+						Key:                   c.String("verify-source-key"),
+						CertificateIdentity:   c.String("verify-source-identity"),
+						CertificateOIDCIssuer: c.String("verify-source-issuer"),
+					}
+					if err := verifySource.Validate(); err != nil {
+						return configError(i18n.Wrap(err, "invalid --verify-source options"))
+					}
+				}
+
+				var signTarget *converter.TargetSigning
+				if c.Bool("sign-target") {
+					signTarget = &converter.TargetSigning{
+						NotationPath:    c.String("notation"),
+						Key:             c.String("sign-key"),
+						ID:              c.String("sign-key-id"),
+						Plugin:          c.String("sign-plugin"),
+						PluginConfig:    c.StringSlice("sign-plugin-config"),
+						SignatureFormat: c.String("signature-format"),
+					}
+					if err := signTarget.Validate(); err != nil {
+						return configError(i18n.Wrap(err, "invalid --sign-target options"))
+					}
+				}
+
+				var verify *converter.Verification
+				if c.Bool("verify") {
+					verify = &converter.Verification{
+						NydusdPath: c.String("nydusd"),
+						Native:     c.Bool("verify-native"),
+						Report:     c.String("verify-report"),
+					}
+				}
+				var verifyRun *converter.RunVerification
+				if c.Bool("verify-run") {
+					command, err := converter.ParseRunCommand(c.String("verify-run-command"))
+					if err != nil {
+						return configError(i18n.Wrap(err, "invalid --verify-run-command option"))
+					}
+					verifyRun = &converter.RunVerification{
+						Address:     c.String("containerd-address"),
+						Snapshotter: c.String("snapshotter"),
+						Command:     command,
+						Timeout:     c.Duration("verify-run-timeout"),
+					}
+				}
+
+				unpackDirLimit, err := parseSizeLimit(c, "unpack-dir-limit")
+				if err != nil {
+					return err
+				}
+				blobDirLimit, err := parseSizeLimit(c, "blob-dir-limit")
+				if err != nil {
+					return err
+				}
+				sourceCacheMaxSize, err := parseSizeLimit(c, "source-cache-max-size")
+				if err != nil {
+					return err
+				}
+				keepArtifacts := []string{}
+				if c.Bool("keep-rootfs") {
+					keepArtifacts = append(keepArtifacts, converter.ArtifactRootfs)
+				}
+				if c.Bool("keep-bootstraps") {
+					keepArtifacts = append(keepArtifacts, converter.ArtifactBootstrap)
+				}
+				if c.Bool("keep-builder-output") {
+					keepArtifacts = append(keepArtifacts, converter.ArtifactBuilderOutput)
+				}
+
+				validateMaxEntrySize, err := parseSizeLimit(c, "validate-max-entry-size")
+				if err != nil {
+					return err
+				}
+				digestAlgorithm, err := utils.ParseDigestAlgorithm(c.String("digest-algorithm"))
+				if err != nil {
+					return configError(i18n.Wrap(err, "parse digest algorithm"))
+				}
+
+				docker2OCI := false
+				if c.Bool("docker-v2-format") {
+					logrus.Warn("the option `--docker-v2-format` has been deprecated, use `--oci` instead")
+					docker2OCI = false
+				} else if c.Bool("oci") {
+					docker2OCI = true
+				}
+
+				// Forcibly enable `--oci` option when `--oci-ref` be enabled.
+				if c.Bool("oci-ref") {
+					logrus.Warn("forcibly enabled `--oci` option when `--oci-ref` be enabled")
+					docker2OCI = true
+				}
+
+				opt := converter.Opt{
+					WorkDir:        c.String("work-dir"),
+					NydusImagePath: c.String("nydus-image"),
+
+					Source:         c.String("source"),
+					Target:         targetRef,
+					SourceInsecure: c.Bool("source-insecure"),
+					TargetInsecure: c.Bool("target-insecure"),
+
+					SourcePlainHTTP:    c.Bool("source-plain-http"),
+					TargetPlainHTTP:    c.Bool("target-plain-http"),
+					CachePlainHTTP:     c.Bool("build-cache-plain-http"),
+					ChunkDictPlainHTTP: c.Bool("chunk-dict-plain-http"),
+
+					BackendType:         backendType,
+					BackendConfig:       backendConfig,
+					BackendForcePush:    c.Bool("backend-force-push"),
+					BackendObjectLayout: c.String("backend-object-layout"),
+					WithBlobMeta:        c.Bool("blob-meta"),
+
+					CacheRef:        cacheRef,
+					CacheInsecure:   c.Bool("build-cache-insecure"),
+					CacheMaxRecords: cacheMaxRecords,
+					CacheVersion:    cacheVersion,
+					LocalCacheDir:   c.String("local-cache-dir"),
+					PreviousTarget:  c.String("previous-target"),
+
+					SourceCacheDir:     c.String("source-cache-dir"),
+					SourceCacheMaxSize: sourceCacheMaxSize,
+
+					ChunkDictRef:      chunkDictRef,
+					ChunkDictInsecure: c.Bool("chunk-dict-insecure"),
+					DedupDB:           c.String("dedup-db"),
+					DedupScope:        c.String("dedup-scope"),
+
+					PrefetchPatterns:  prefetchPatterns,
+					PrefetchHeuristic: c.Bool("prefetch-heuristic"),
+					MergePlatform:     c.Bool("merge-platform"),
+					Docker2OCI:        docker2OCI,
+					FsVersion:         fsVersion,
+					CompatFsVersion:   compatFsVersion,
+					FsAlignChunk:      c.Bool("backend-aligned-chunk") || c.Bool("fs-align-chunk"),
+					Compressor:        c.String("compressor"),
+					ChunkSize:         c.String("chunk-size"),
+					BatchSize:         c.String("batch-size"),
+
+					OCIRef:               c.Bool("oci-ref"),
+					WithReferrer:         c.Bool("with-referrer"),
+					PushSourceThrough:    c.Bool("push-source-through"),
+					BootstrapPlacement:   bootstrapPlacement,
+					BootstrapCompression: bootstrapCompression,
+					ManifestProfile:      manifestProfile,
+					ConfigMutation:       configMutation,
+					Policy:               policy,
+					Publishing:           publishing,
+					Credentials:          credentials,
+					Provenance:           c.Bool("provenance"),
+					NydusifyVersion:      gitVersion,
+					VerifySource:         verifySource,
+					SignTarget:           signTarget,
+					Verify:               verify,
+					VerifyRun:            verifyRun,
+					ValidateSource:       c.Bool("validate-source"),
+					ValidateMaxEntrySize: validateMaxEntrySize,
+					AllPlatforms:         c.Bool("all-platforms"),
+					Platforms:            c.String("platform"),
+
+					AdaptiveConcurrency: c.Bool("adaptive-concurrency"),
+					MaxConcurrency:      c.Int("max-concurrency"),
+					MaxConcurrentBuilds: c.Int("max-concurrent-builds"),
+					UnpackFilter:        unpackFilter,
+					OverlapPush:         c.Bool("overlap-push"),
+					VerifyPush:          c.Bool("verify-push"),
+					DigestAlgorithm:     digestAlgorithm,
+					SkipConverted:       c.Bool("skip-converted"),
+
+					WorkDirGCAge:   c.Duration("work-dir-gc-age"),
+					UnpackDir:      c.String("unpack-dir"),
+					UnpackDirLimit: unpackDirLimit,
+					BlobDir:        c.String("blob-dir"),
+					BlobDirLimit:   blobDirLimit,
+					KeepWorkDir:    c.Bool("keep-workdir"),
+					KeepArtifacts:  keepArtifacts,
+
+					Sandbox:         c.Bool("sandbox"),
+					BuilderPriority: builderPriority,
+					KeepGoing:       c.Bool("keep-going"),
+					Deadline:        c.Duration("deadline"),
+					DeadlineAction:  c.String("deadline-action"),
+
+					OutputJSON:      c.String("output-json"),
+					OutputInventory: c.String("output-inventory"),
+					OutputReport:    c.String("output-report"),
+					HistoryDir:      c.String("history-dir"),
+				}
+
+				ctx, stop := signalContext()
+				defer stop()
+
+				var exporter metrics.Exporter
+				if url := c.String("pushgateway-url"); url != "" {
+					exporter = pushexporter.New(url, c.String("pushgateway-job"))
+				}
+				addr := c.String("metrics-addr")
+				if exporter != nil || addr != "" {
+					metrics.Register(exporter)
+					defer metrics.Export()
+				}
+				if addr != "" {
+					server, err := metrics.Serve(addr)
+					if err != nil {
+						return err
+					}
+					defer server.Close()
+				}
+
+				stopProgress, err := setupProgress(c)
+				if err != nil {
+					return err
+				}
+				defer stopProgress()
+
+				return converter.Convert(ctx, opt)
+			},
+		},
+		{
+			Name:  "check",
+			Usage: "Verify nydus image format and content",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "source",
+					Required: false,
+					Usage:    "Source OCI image reference",
+					EnvVars:  []string{"SOURCE"},
+				},
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "Target (Nydus) image reference",
+					EnvVars:  []string{"TARGET"},
+				},
+				&cli.BoolFlag{
+					Name:     "source-insecure",
+					Required: false,
+					Usage:    "Skip verifying server certs for HTTPS source registry",
+					EnvVars:  []string{"SOURCE_INSECURE"},
+				},
+				&cli.BoolFlag{
+					Name:     "target-insecure",
+					Required: false,
+					Usage:    "Skip verifying server certs for HTTPS target registry",
+					EnvVars:  []string{"TARGET_INSECURE"},
+				},
+
+				&cli.StringFlag{
+					Name:    "backend-type",
+					Value:   "",
+					Usage:   "Type of storage backend, enable verification of file data in Nydus image if specified, possible values: 'oss', 's3', 'localfs', 'azblob'",
+					EnvVars: []string{"BACKEND_TYPE"},
+				},
+				&cli.StringFlag{
+					Name:    "backend-config",
+					Value:   "",
+					Usage:   "Json string for storage backend configuration",
+					EnvVars: []string{"BACKEND_CONFIG"},
+				},
+				&cli.PathFlag{
+					Name:      "backend-config-file",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json configuration file for storage backend",
+					EnvVars:   []string{"BACKEND_CONFIG_FILE"},
+				},
+
+				&cli.BoolFlag{
+					Name:    "multi-platform",
+					Value:   false,
+					Usage:   "Verify that the image contains an image index with both OCI and Nydus manifests",
+					EnvVars: []string{"MULTI_PLATFORM"},
+				},
+				&cli.StringFlag{
+					Name:  "platform",
+					Value: "linux/" + runtime.GOARCH,
+					Usage: "Specify platform identifier to choose image manifest, possible values: 'linux/amd64' and 'linux/arm64'",
+				},
+
+				&cli.StringFlag{
+					Name:    "work-dir",
+					Value:   "./output",
+					Usage:   "Working directory for image verification",
+					EnvVars: []string{"WORK_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "nydus-image",
+					Value:   "nydus-image",
+					Usage:   "Path to the nydus-image binary, default to search in PATH",
+					EnvVars: []string{"NYDUS_IMAGE"},
+				},
+				&cli.StringFlag{
+					Name:    "nydusd",
+					Value:   "nydusd",
+					Usage:   "Path to the nydusd binary, default to search in PATH",
+					EnvVars: []string{"NYDUSD"},
+				},
+				&cli.BoolFlag{
+					Name:    "native",
+					Value:   false,
+					Usage:   "Verify the filesystem by reading Nydus image in user space with nydus-image, without FUSE and nydusd",
+					EnvVars: []string{"NATIVE"},
+				},
+				&cli.PathFlag{
+					Name:      "unpack-filter",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json file of unpack filter rules applied to the source image, which should be the rules used to convert the target image",
+					EnvVars:   []string{"UNPACK_FILTER"},
+				},
+				&cli.StringFlag{
+					Name:    "output-json",
+					Value:   "",
+					Usage:   "File path to save the filesystem discrepancies between source and target image in JSON format",
+					EnvVars: []string{"OUTPUT_JSON"},
+				},
+				&cli.StringFlag{
+					Name:    "reference-container",
+					Value:   "",
+					Usage:   "Compare the filesystem of target image with a running container of the source image instead, specified by PID or in the form of <docker|podman|nerdctl|crictl>://<container id>",
+					EnvVars: []string{"REFERENCE_CONTAINER"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+				if err := resolveShortNames(c); err != nil {
+					return err
+				}
+
+				backendType, backendConfig, err := getBackendConfig(c, "", false)
+				if err != nil {
+					return err
+				}
+
+				_, arch, err := provider.ExtractOsArch(c.String("platform"))
+				if err != nil {
+					return err
+				}
+
+				source, err := getRegistryReference(c, "source")
+				if err != nil {
+					return err
+				}
+				target, err := getRegistryReference(c, "target")
+				if err != nil {
+					return err
+				}
+
+				unpackFilter, err := getUnpackFilter(c)
+				if err != nil {
+					return err
+				}
+
+				checker, err := checker.New(checker.Opt{
+					WorkDir:        c.String("work-dir"),
+					Source:         source,
+					Target:         target,
+					MultiPlatform:  c.Bool("multi-platform"),
+					SourceInsecure: c.Bool("source-insecure"),
+					TargetInsecure: c.Bool("target-insecure"),
+					NydusImagePath: c.String("nydus-image"),
+					NydusdPath:     c.String("nydusd"),
+					BackendType:    backendType,
+					BackendConfig:  backendConfig,
+					ExpectedArch:   arch,
+					Native:         c.Bool("native"),
+					UnpackFilter:   unpackFilter,
+
+					FilesystemReport:   c.String("output-json"),
+					ReferenceContainer: c.String("reference-container"),
+				})
+				if err != nil {
+					return err
+				}
+
+				return checker.Check(context.Background())
+			},
+		},
+		{
+			Name:  "fsck",
+			Usage: "Check the blobs referenced by nydus image are present and intact in storage backend",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "Target (Nydus) image reference",
+					EnvVars:  []string{"TARGET"},
+				},
+				&cli.BoolFlag{
+					Name:     "target-insecure",
+					Required: false,
+					Usage:    "Skip verifying server certs for HTTPS target registry",
+					EnvVars:  []string{"TARGET_INSECURE"},
+				},
+				&cli.StringFlag{
+					Name:    "backend-type",
+					Value:   "",
+					Usage:   "Type of storage backend holding the blobs, default to the registry of target image, possible values: 'oss', 's3', 'localfs', 'azblob'",
+					EnvVars: []string{"BACKEND_TYPE"},
+				},
+				&cli.StringFlag{
+					Name:    "backend-config",
+					Value:   "",
+					Usage:   "Json string for storage backend configuration",
+					EnvVars: []string{"BACKEND_CONFIG"},
+				},
+				&cli.PathFlag{
+					Name:      "backend-config-file",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json configuration file for storage backend",
+					EnvVars:   []string{"BACKEND_CONFIG_FILE"},
+				},
+				&cli.StringFlag{
+					Name:  "platform",
+					Value: "linux/" + runtime.GOARCH,
+					Usage: "Specify platform identifier to choose image manifest, possible values: 'linux/amd64' and 'linux/arm64'",
+				},
+				&cli.BoolFlag{
+					Name:    "deep",
+					Value:   false,
+					Usage:   "Read the whole blobs to verify their digests, otherwise only the existence and size of blobs are checked",
+					EnvVars: []string{"DEEP"},
+				},
+				&cli.IntFlag{
+					Name:    "concurrency",
+					Value:   5,
+					Usage:   "Number of blobs checked concurrently",
+					EnvVars: []string{"CONCURRENCY"},
+				},
+				&cli.StringFlag{
+					Name:    "work-dir",
+					Value:   "./output",
+					Usage:   "Working directory to save the bootstrap of image",
+					EnvVars: []string{"WORK_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "nydus-image",
+					Value:   "nydus-image",
+					Usage:   "Path to the nydus-image binary, default to search in PATH",
+					EnvVars: []string{"NYDUS_IMAGE"},
+				},
+				&cli.StringFlag{
+					Name:    "output-json",
+					Value:   "",
+					Usage:   "File path to save the check result in JSON format",
+					EnvVars: []string{"OUTPUT_JSON"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+
+				backendType, backendConfig, err := getBackendConfig(c, "", false)
+				if err != nil {
+					return err
+				}
+				_, arch, err := provider.ExtractOsArch(c.String("platform"))
+				if err != nil {
+					return err
+				}
+				target, err := getRegistryReference(c, "target")
+				if err != nil {
+					return err
+				}
+
+				result, err := fsck.Fsck(context.Background(), fsck.Opt{
+					WorkDir:        c.String("work-dir"),
+					Target:         target,
+					TargetInsecure: c.Bool("target-insecure"),
+					NydusImagePath: c.String("nydus-image"),
+					BackendType:    backendType,
+					BackendConfig:  backendConfig,
+					ExpectedArch:   arch,
+					Deep:           c.Bool("deep"),
+					Concurrency:    c.Int("concurrency"),
+					OutputJSON:     c.String("output-json"),
+				})
+				if err != nil {
+					return err
+				}
+				if err := result.Print(os.Stdout); err != nil {
+					return err
+				}
+				if !result.Healthy() {
+					return checkMismatch(i18n.Errorf("%d of %d blobs are unavailable", len(result.Blobs)-result.Summary[fsck.StatusPresent], len(result.Blobs)))
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "seed",
+			Usage: "Seed the hot files of nydus image to the nodes running nydus-snapshotter for prefetching",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "Target (Nydus) image reference",
+					EnvVars:  []string{"TARGET"},
+				},
+				&cli.BoolFlag{
+					Name:     "target-insecure",
+					Required: false,
+					Usage:    "Skip verifying server certs for HTTPS target registry",
+					EnvVars:  []string{"TARGET_INSECURE"},
+				},
+				&cli.StringFlag{
+					Name:  "platform",
+					Value: "linux/" + runtime.GOARCH,
+					Usage: "Specify platform identifier to choose image manifest, possible values: 'linux/amd64' and 'linux/arm64'",
+				},
+				&cli.StringSliceFlag{
+					Name:    "node",
+					Usage:   "System controller endpoint of nydus-snapshotter on node, unix socket like 'unix://" + seeder.DefaultSystemSocket + "' or url like 'http://10.0.0.1:8080'",
+					EnvVars: []string{"NODES"},
+				},
+				&cli.PathFlag{
+					Name:      "node-file",
+					TakesFile: true,
+					Usage:     "File listing the node endpoints, one per line",
+					EnvVars:   []string{"NODE_FILE"},
+				},
+				&cli.PathFlag{
+					Name:      "prefetch-file",
+					TakesFile: true,
+					Usage:     "File listing the hot files to prefetch, one absolute path in image per line, default to the prefetch table of image bootstrap",
+					EnvVars:   []string{"PREFETCH_FILE"},
+				},
+				&cli.StringFlag{
+					Name:    "list-dir",
+					Value:   "/run/containerd-nydus/prefetch",
+					Usage:   "Directory to save the prefetch list, which must be accessible at the same path on nodes",
+					EnvVars: []string{"LIST_DIR"},
+				},
+				&cli.IntFlag{
+					Name:    "concurrency",
+					Value:   10,
+					Usage:   "Number of nodes seeded concurrently",
+					EnvVars: []string{"CONCURRENCY"},
+				},
+				&cli.DurationFlag{
+					Name:    "timeout",
+					Value:   10 * time.Second,
+					Usage:   "Timeout of request to each node",
+					EnvVars: []string{"TIMEOUT"},
+				},
+				&cli.StringFlag{
+					Name:    "work-dir",
+					Value:   "./output",
+					Usage:   "Working directory to save the bootstrap of image",
+					EnvVars: []string{"WORK_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "nydus-image",
+					Value:   "nydus-image",
+					Usage:   "Path to the nydus-image binary, default to search in PATH",
+					EnvVars: []string{"NYDUS_IMAGE"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+
+				_, arch, err := provider.ExtractOsArch(c.String("platform"))
+				if err != nil {
+					return err
+				}
+				target, err := getRegistryReference(c, "target")
+				if err != nil {
+					return err
+				}
+				nodes := c.StringSlice("node")
+				if nodeFile := c.String("node-file"); nodeFile != "" {
+					data, err := os.ReadFile(nodeFile)
+					if err != nil {
+						return configError(i18n.Wrap(err, "read node file"))
+					}
+					for _, line := range strings.Split(string(data), "\n") {
+						if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+							nodes = append(nodes, line)
+						}
+					}
+				}
+				if len(nodes) == 0 {
+					return configError(i18n.Errorf("--node or --node-file is required"))
+				}
+				var files []string
+				if prefetchFile := c.String("prefetch-file"); prefetchFile != "" {
+					if files, err = seeder.ReadFiles(prefetchFile); err != nil {
+						return configError(err)
+					}
+					if len(files) == 0 {
+						return configError(i18n.Errorf("no file to prefetch in %s", prefetchFile))
+					}
+				}
+
+				result, err := seeder.Seed(context.Background(), seeder.Opt{
+					WorkDir:        c.String("work-dir"),
+					Target:         target,
+					TargetInsecure: c.Bool("target-insecure"),
+					NydusImagePath: c.String("nydus-image"),
+					ExpectedArch:   arch,
+					Nodes:          nodes,
+					Files:          files,
+					ListDir:        c.String("list-dir"),
+					Concurrency:    c.Int("concurrency"),
+					Timeout:        c.Duration("timeout"),
+				})
+				if result != nil {
+					if err := result.Print(os.Stdout); err != nil {
+						return err
+					}
+				}
+				return err
+			},
+		},
+		{
+			Name:  "compat",
+			Usage: "Check whether nydus image can be consumed by the specified nydusd or snapshotter version",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "image",
+					Aliases:  []string{"target"},
+					Required: true,
+					Usage:    "Nydus image reference",
+					EnvVars:  []string{"IMAGE"},
+				},
+				&cli.BoolFlag{
+					Name:     "image-insecure",
+					Required: false,
+					Usage:    "Skip verifying server certs for HTTPS image registry",
+					EnvVars:  []string{"IMAGE_INSECURE"},
+				},
+				&cli.StringFlag{
+					Name:    "nydusd-version",
+					Value:   "",
+					Usage:   "Version of nydusd expected to consume the image, for example: 'v2.2.0'",
+					EnvVars: []string{"NYDUSD_VERSION"},
+				},
+				&cli.StringFlag{
+					Name:    "snapshotter-version",
+					Value:   "",
+					Usage:   "Version of nydus snapshotter expected to consume the image, for example: 'v0.13.0'",
+					EnvVars: []string{"SNAPSHOTTER_VERSION"},
+				},
+				&cli.StringFlag{
+					Name:  "platform",
+					Value: "linux/" + runtime.GOARCH,
+					Usage: "Specify platform identifier to choose image manifest, possible values: 'linux/amd64' and 'linux/arm64'",
+				},
+				&cli.StringFlag{
+					Name:    "work-dir",
+					Value:   "./tmp",
+					Usage:   "Working directory for compatibility check",
+					EnvVars: []string{"WORK_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "nydus-image",
+					Value:   "nydus-image",
+					Usage:   "Path to the nydus-image binary, default to search in PATH",
+					EnvVars: []string{"NYDUS_IMAGE"},
+				},
+				&cli.StringFlag{
+					Name:    "output-json",
+					Value:   "",
+					Usage:   "File path to save the compatibility report in JSON format, for example: './compat.json'",
+					EnvVars: []string{"OUTPUT_JSON"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+
+				_, arch, err := provider.ExtractOsArch(c.String("platform"))
+				if err != nil {
+					return err
+				}
+
+				image, err := getRegistryReference(c, "image")
+				if err != nil {
+					return err
+				}
+
+				cpt, err := compat.New(compat.Opt{
+					WorkDir:            c.String("work-dir"),
+					Image:              image,
+					ImageInsecure:      c.Bool("image-insecure"),
+					ExpectedArch:       arch,
+					NydusImagePath:     c.String("nydus-image"),
+					NydusdVersion:      c.String("nydusd-version"),
+					SnapshotterVersion: c.String("snapshotter-version"),
+				})
+				if err != nil {
+					return err
+				}
+
+				report, err := cpt.Check(context.Background())
+				if err != nil {
+					return err
+				}
+
+				logrus.Infof("Image features: %+v", report.Features)
+				for _, component := range []struct {
+					name   string
+					report *compat.ComponentReport
+				}{{"nydusd", report.Nydusd}, {"snapshotter", report.Snapshotter}} {
+					if component.report == nil {
+						continue
+					}
+					for _, item := range component.report.Items {
+						logrus.Infof("%s %s: feature %s requires %s, satisfied: %v",
+							component.name, component.report.Version, item.Feature, item.Required, item.Satisfied)
+					}
+				}
+				logrus.Warnf("Features can't be detected from image: %v", report.Undetectable)
+
+				if outputJSON := c.String("output-json"); outputJSON != "" {
+					bytes, err := json.MarshalIndent(report, "", "  ")
+					if err != nil {
+						return errors.Wrap(err, "marshal compatibility report")
+					}
+					if err := os.WriteFile(outputJSON, bytes, 0644); err != nil {
+						return errors.Wrap(err, "write compatibility report")
+					}
+				}
+
+				if !report.Compatible {
+					return checkMismatch(i18n.Errorf("image %s is incompatible with the specified version", report.Image))
+				}
+				logrus.Infof("Image %s is compatible with the specified version", report.Image)
+
+				return nil
+			},
+		},
+		{
+			Name:  "recompress",
+			Usage: "Rewrite the blobs of nydus image with another compressor or chunk size, without pulling the source OCI image",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "source",
+					Required: true,
+					Usage:    "Source nydus image reference",
+					EnvVars:  []string{"SOURCE"},
+				},
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "Target nydus image reference",
+					EnvVars:  []string{"TARGET"},
+				},
+				&cli.BoolFlag{
+					Name:     "source-insecure",
+					Required: false,
+					Usage:    "Skip verifying server certs for HTTPS source registry",
+					EnvVars:  []string{"SOURCE_INSECURE"},
+				},
+				&cli.BoolFlag{
+					Name:     "target-insecure",
+					Required: false,
+					Usage:    "Skip verifying server certs for HTTPS target registry",
+					EnvVars:  []string{"TARGET_INSECURE"},
+				},
+				&cli.StringFlag{
+					Name:    "compressor",
+					Value:   "",
+					Usage:   "Algorithm to compress image data blob, possible values: none, lz4_block, zstd, keep the one of source image if empty",
+					EnvVars: []string{"COMPRESSOR"},
+				},
+				&cli.StringFlag{
+					Name:    "fs-chunk-size",
+					Value:   "",
+					Usage:   "size of nydus image data chunk, must be power of two and between 0x1000-0x100000, keep the one of source image if empty",
+					EnvVars: []string{"FS_CHUNK_SIZE"},
+					Aliases: []string{"chunk-size"},
+				},
+				&cli.StringFlag{
+					Name:  "platform",
+					Value: "linux/" + runtime.GOARCH,
+					Usage: "Specify platform identifier to choose image manifest, possible values: 'linux/amd64' and 'linux/arm64'",
+				},
+				&cli.StringFlag{
+					Name:    "work-dir",
+					Value:   "./tmp",
+					Usage:   "Working directory for image recompression",
+					EnvVars: []string{"WORK_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "nydus-image",
+					Value:   "nydus-image",
+					Usage:   "Path to the nydus-image binary, default to search in PATH",
+					EnvVars: []string{"NYDUS_IMAGE"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+
+				_, arch, err := provider.ExtractOsArch(c.String("platform"))
+				if err != nil {
+					return err
+				}
+
+				source, err := getRegistryReference(c, "source")
+				if err != nil {
+					return err
+				}
+				target, err := getRegistryReference(c, "target")
+				if err != nil {
+					return err
+				}
+
+				rc, err := recompressor.New(recompressor.Opt{
+					WorkDir:        c.String("work-dir"),
+					NydusImagePath: c.String("nydus-image"),
+					ExpectedArch:   arch,
+					Source:         source,
+					SourceInsecure: c.Bool("source-insecure"),
+					Target:         target,
+					TargetInsecure: c.Bool("target-insecure"),
+					Compressor:     c.String("compressor"),
+					ChunkSize:      c.String("chunk-size"),
+				})
+				if err != nil {
+					return err
+				}
+
+				return rc.Recompress(c.Context)
+			},
+		},
+		{
+			Name:  "chunkdict",
+			Usage: "Deduplicate chunk for Nydus image (experimental)",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "generate",
+					Usage: "Save chunk and blob information of Multi-image into the database (experimental)",
+					Flags: []cli.Flag{
+						&cli.StringSliceFlag{
+							Name:     "sources",
+							Required: true,
+							Usage:    "One or more Nydus image reference(Multiple images should be split by commas)",
+							EnvVars:  []string{"SOURCES"},
+						},
+						&cli.BoolFlag{
+							Name:     "source-insecure",
+							Required: false,
+							Usage:    "Skip verifying server certs for HTTPS source registry",
+							EnvVars:  []string{"SOURCE_INSECURE"},
+						},
+						&cli.StringFlag{
+							Name:    "work-dir",
+							Value:   "./output",
+							Usage:   "Working directory for generating chunkdict image",
+							EnvVars: []string{"WORK_DIR"},
+						},
+						&cli.StringFlag{
+							Name:    "nydus-image",
+							Value:   "nydus-image",
+							Usage:   "Path to the nydus-image binary, default to search in PATH",
+							EnvVars: []string{"NYDUS_IMAGE"},
+						},
+						&cli.StringFlag{
+							Name:  "platform",
+							Value: "linux/" + runtime.GOARCH,
+							Usage: "Specify platform identifier to choose image manifest, possible values: 'linux/amd64' and 'linux/arm64'",
+						},
+						&cli.BoolFlag{
+							Name:    "keep-going",
+							Value:   false,
+							Usage:   "Continue with the rest sources after a source fails, exits non-zero with a summary of failures",
+							EnvVars: []string{"KEEP_GOING"},
+						},
+						&cli.StringFlag{
+							Name:    "target",
+							Value:   "",
+							Usage:   "Push the chunk dict merged from sources as a Nydus image, used by convert with '--chunk-dict bootstrap:registry:<target>'",
+							EnvVars: []string{"TARGET"},
+						},
+						&cli.BoolFlag{
+							Name:     "target-insecure",
+							Required: false,
+							Usage:    "Skip verifying server certs for HTTPS target registry",
+							EnvVars:  []string{"TARGET_INSECURE"},
+						},
+						&cli.StringFlag{
+							Name:    "output",
+							Value:   "",
+							Usage:   "Save the bootstrap of chunk dict merged from sources to the path, used by convert with '--chunk-dict bootstrap:local:<output>'",
+							EnvVars: []string{"OUTPUT"},
+						},
+					},
+					Action: func(c *cli.Context) error {
+						setupLogLevel(c)
+
+						_, arch, err := provider.ExtractOsArch(c.String("platform"))
+						if err != nil {
+							return err
+						}
+
+						generator, err := generator.New(generator.Opt{
+							WorkDir:        c.String("work-dir"),
+							Sources:        c.StringSlice("sources"),
+							SourceInsecure: c.Bool("source-insecure"),
+							NydusImagePath: c.String("nydus-image"),
+							ExpectedArch:   arch,
+							KeepGoing:      c.Bool("keep-going"),
+							Target:         c.String("target"),
+							TargetInsecure: c.Bool("target-insecure"),
+							OutputPath:     c.String("output"),
+						})
+						if err != nil {
+							return err
+						}
+
+						return generator.Generate(context.Background())
+					},
+				},
+				{
+					Name:  "publish",
+					Usage: "Publish the chunk dict to the dedup database shared by converters (experimental)",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "chunk-dict",
+							Required: true,
+							Usage:    "Chunk dict expression of the chunk dict image in registry, for example: bootstrap:registry:localhost:5000/namespace/app:chunk_dict",
+							EnvVars:  []string{"CHUNK_DICT"},
+						},
+						&cli.StringFlag{
+							Name:     "dedup-db",
+							Required: true,
+							Usage:    "URL of the dedup database, for examples: http://dedup.example.com/v1, redis://:password@127.0.0.1:6379/0",
+							EnvVars:  []string{"DEDUP_DB"},
+						},
+						&cli.StringFlag{
+							Name:    "dedup-scope",
+							Value:   dedup.DefaultScope,
+							Usage:   "Scope in the dedup database to publish the chunk dict to",
+							EnvVars: []string{"DEDUP_SCOPE"},
+						},
+					},
+					Action: func(c *cli.Context) error {
+						setupLogLevel(c)
+
+						chunkDict := c.String("chunk-dict")
+						if _, err := converter.ParseSharedChunkDictArgs(chunkDict); err != nil {
+							return configError(i18n.Wrap(err, "parse chunk dict arguments"))
+						}
+						store, err := dedup.NewStore(c.String("dedup-db"))
+						if err != nil {
+							return err
+						}
+						if err := dedup.SetChunkDict(context.Background(), store, c.String("dedup-scope"), chunkDict); err != nil {
+							return err
+						}
+						logrus.Infof("published chunk dict %s to dedup scope %q", chunkDict, c.String("dedup-scope"))
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "cache",
+			Usage: "Manage the local build cache and debug the build cache image of conversion",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "prune",
+					Usage: "Remove the converted layers not used recently from local build cache",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "local-cache-dir",
+							Value:   cache.DefaultLocalCacheDir(),
+							Usage:   "Directory of local build cache",
+							EnvVars: []string{"LOCAL_CACHE_DIR"},
+						},
+						&cli.DurationFlag{
+							Name:    "max-age",
+							Value:   0,
+							Usage:   "Remove the layers not used for the duration, e.g. 168h, 0 means no limit",
+							EnvVars: []string{"MAX_AGE"},
+						},
+						&cli.StringFlag{
+							Name:    "max-size",
+							Value:   "0",
+							Usage:   "Remove the least recently used layers until the cache is within the size, e.g. 10GiB, 0 means no limit",
+							EnvVars: []string{"MAX_SIZE"},
+						},
+					},
+					Action: func(c *cli.Context) error {
+						setupLogLevel(c)
+
+						maxSize, err := parseSizeLimit(c, "max-size")
+						if err != nil {
+							return err
+						}
+						localCache, err := cache.NewLocalCache(c.String("local-cache-dir"))
+						if err != nil {
+							return err
+						}
+						result, err := localCache.Prune(c.Duration("max-age"), maxSize)
+						if err != nil {
+							return errors.Wrap(err, "prune local cache")
+						}
+						logrus.Infof("pruned %d records and %d layers (%s) from %s",
+							result.Records, result.Blobs, humanize.IBytes(uint64(result.Bytes)), c.String("local-cache-dir"))
+						return nil
+					},
+				},
+				{
+					Name:  "dump",
+					Usage: "Dump the records of build cache image, and report the records never hit by conversion",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "cache",
+							Required: true,
+							Usage:    "Build cache image in registry or OCI image layout in the form of oci:path[:name], e.g. myregistry/repo:cache",
+							EnvVars:  []string{"CACHE"},
+						},
+						&cli.BoolFlag{
+							Name:    "insecure",
+							Value:   false,
+							Usage:   "Skip verifying server certs for HTTPS registry",
+							EnvVars: []string{"INSECURE"},
+						},
+						&cli.StringFlag{
+							Name:    "build-cache-version",
+							Value:   "v1",
+							Usage:   "Cache version of conversion, to report the cache manifests of other versions, empty to skip checking",
+							EnvVars: []string{"BUILD_CACHE_VERSION"},
+						},
+						&cli.UintFlag{
+							Name:    "build-cache-max-records",
+							Value:   maxCacheMaxRecords,
+							Usage:   "Maximum cache records of conversion, to report the full cache manifests, 0 to skip checking",
+							EnvVars: []string{"BUILD_CACHE_MAX_RECORDS"},
+						},
+						&cli.StringFlag{
+							Name:    "output-json",
+							Value:   "",
+							Usage:   "File path to save the dump in JSON format, which can be diffed later by the subcommand diff",
+							EnvVars: []string{"OUTPUT_JSON"},
+						},
+					},
+					Action: func(c *cli.Context) error {
+						setupLogLevel(c)
+
+						dump, err := loadCacheDump(c, c.String("cache"), cachediff.Opt{
+							Version:    c.String("build-cache-version"),
+							MaxRecords: int(c.Uint("build-cache-max-records")),
+						})
+						if err != nil {
+							return err
+						}
+						if outputJSON := c.String("output-json"); outputJSON != "" {
+							if err := dump.Save(outputJSON); err != nil {
+								return err
+							}
+						}
+						return dump.Print(os.Stdout)
+					},
+				},
+				{
+					Name:  "diff",
+					Usage: "Diff the records of two build cache images or dumps, e.g. before and after a conversion",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "old",
+							Required: true,
+							Usage:    "Old build cache image, or the file saved by dump --output-json",
+							EnvVars:  []string{"OLD"},
+						},
+						&cli.StringFlag{
+							Name:     "new",
+							Required: true,
+							Usage:    "New build cache image, or the file saved by dump --output-json",
+							EnvVars:  []string{"NEW"},
+						},
+						&cli.BoolFlag{
+							Name:    "insecure",
+							Value:   false,
+							Usage:   "Skip verifying server certs for HTTPS registry",
+							EnvVars: []string{"INSECURE"},
+						},
+						&cli.StringFlag{
+							Name:    "output-json",
+							Value:   "",
+							Usage:   "File path to save the diff in JSON format",
+							EnvVars: []string{"OUTPUT_JSON"},
+						},
+					},
+					Action: func(c *cli.Context) error {
+						setupLogLevel(c)
+
+						oldDump, err := loadCacheDump(c, c.String("old"), cachediff.Opt{})
+						if err != nil {
+							return err
+						}
+						newDump, err := loadCacheDump(c, c.String("new"), cachediff.Opt{})
+						if err != nil {
+							return err
+						}
+						diff := cachediff.DiffDumps(oldDump, newDump)
+						if outputJSON := c.String("output-json"); outputJSON != "" {
+							data, err := json.MarshalIndent(diff, "", "  ")
+							if err != nil {
+								return errors.Wrap(err, "marshal cache diff")
+							}
+							if err := os.WriteFile(outputJSON, data, 0644); err != nil {
+								return errors.Wrap(err, "write cache diff")
+							}
+						}
+						return diff.Print(os.Stdout)
+					},
+				},
+			},
+		},
+		{
+			Name:  "backend",
+			Usage: "Diagnose and benchmark the storage backend of nydus blobs",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "debug",
+					Usage: "Put, check and remove a tiny test object in storage backend to diagnose its auth failures",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "backend-type",
+							Required: true,
+							Usage:    "Type of storage backend, possible values: 'oss', 's3', 'localfs', 'azblob'",
+							EnvVars:  []string{"BACKEND_TYPE"},
+						},
+						&cli.StringFlag{
+							Name:    "backend-config",
+							Value:   "",
+							Usage:   "Json string for storage backend configuration",
+							EnvVars: []string{"BACKEND_CONFIG"},
+						},
+						&cli.PathFlag{
+							Name:      "backend-config-file",
+							Value:     "",
+							TakesFile: true,
+							Usage:     "Json configuration file for storage backend",
+							EnvVars:   []string{"BACKEND_CONFIG_FILE"},
+						},
+						&cli.StringFlag{
+							Name:    "output-json",
+							Value:   "",
+							Usage:   "File path to save the debug result in JSON format",
+							EnvVars: []string{"OUTPUT_JSON"},
+						},
+					},
+					Action: func(c *cli.Context) error {
+						setupLogLevel(c)
+
+						backendType, backendConfig, err := getBackendConfig(c, "", true)
+						if err != nil {
+							return err
+						}
+						ctx, stop := signalContext()
+						defer stop()
+
+						result, err := backend.Debug(ctx, backendType, []byte(backendConfig))
+						if err != nil {
+							return err
+						}
+						if err := result.Print(os.Stdout); err != nil {
+							return err
+						}
+						if outputJSON := c.String("output-json"); outputJSON != "" {
+							data, err := json.MarshalIndent(result, "", "  ")
+							if err != nil {
+								return errors.Wrap(err, "marshal debug result")
+							}
+							if err := os.WriteFile(outputJSON, data, 0644); err != nil {
+								return errors.Wrap(err, "write debug result")
+							}
+						}
+						if result.Failed() {
+							return fmt.Errorf("requests to %s backend failed", backendType)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "benchmark",
+					Usage: "Measure the latency and throughput of ranged chunk reads from storage backend on this host",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "backend-type",
+							Required: true,
+							Usage:    "Type of storage backend, possible values: 'registry', 'oss', 's3', 'localfs', 'azblob'",
+							EnvVars:  []string{"BACKEND_TYPE"},
+						},
+						&cli.StringFlag{
+							Name:    "backend-config",
+							Value:   "",
+							Usage:   "Json string for storage backend configuration",
+							EnvVars: []string{"BACKEND_CONFIG"},
+						},
+						&cli.PathFlag{
+							Name:      "backend-config-file",
+							Value:     "",
+							TakesFile: true,
+							Usage:     "Json configuration file for storage backend",
+							EnvVars:   []string{"BACKEND_CONFIG_FILE"},
+						},
+						&cli.StringFlag{
+							Name:    "repo",
+							Value:   "",
+							Usage:   "Repository of blob for registry backend, e.g. docker.io/library/nginx",
+							EnvVars: []string{"REPO"},
+						},
+						&cli.BoolFlag{
+							Name:    "insecure",
+							Value:   false,
+							Usage:   "Skip verifying server certs for HTTPS registry",
+							EnvVars: []string{"INSECURE"},
+						},
+						&cli.StringFlag{
+							Name:    "blob",
+							Value:   "",
+							Usage:   "ID of an existing blob to read, e.g. a nydus blob digest without 'sha256:', a random test blob is put and removed afterwards if not specified, which isn't supported by registry backend",
+							EnvVars: []string{"BLOB"},
+						},
+						&cli.StringFlag{
+							Name:    "blob-size",
+							Value:   "64MiB",
+							Usage:   "Size of the random test blob",
+							EnvVars: []string{"BLOB_SIZE"},
+						},
+						&cli.StringFlag{
+							Name:    "chunk-size",
+							Value:   "1MiB",
+							Usage:   "Size of each ranged read, like the chunk size of nydus image",
+							EnvVars: []string{"CHUNK_SIZE"},
+						},
+						&cli.IntFlag{
+							Name:    "requests",
+							Value:   100,
+							Usage:   "Number of ranged reads at random chunk offsets",
+							EnvVars: []string{"REQUESTS"},
+						},
+						&cli.IntFlag{
+							Name:    "concurrency",
+							Value:   4,
+							Usage:   "Number of concurrent ranged reads",
+							EnvVars: []string{"CONCURRENCY"},
+						},
+						&cli.StringFlag{
+							Name:    "output-json",
+							Value:   "",
+							Usage:   "File path to save the benchmark result in JSON format",
+							EnvVars: []string{"OUTPUT_JSON"},
+						},
+					},
+					Action: func(c *cli.Context) error {
+						setupLogLevel(c)
+
+						var bkd backend.Backend
+						backendType := c.String("backend-type")
+						if backendType == "registry" {
+							if c.String("repo") == "" {
+								return configError(i18n.Errorf("--repo is required for registry backend"))
+							}
+							rmt, err := provider.DefaultRemote(c.String("repo"), c.Bool("insecure"))
+							if err != nil {
+								return errors.Wrap(err, "create remote")
+							}
+							if bkd, err = backend.NewBackend(backendType, nil, rmt); err != nil {
+								return err
+							}
+						} else {
+							_, backendConfig, err := getBackendConfig(c, "", true)
+							if err != nil {
+								return err
+							}
+							if bkd, err = backend.NewBackend(backendType, []byte(backendConfig), nil); err != nil {
+								return errors.Wrap(err, "create backend")
+							}
+						}
+						blobSize, err := humanize.ParseBytes(c.String("blob-size"))
+						if err != nil {
+							return configError(i18n.Wrapf(err, "invalid --blob-size %s", c.String("blob-size")))
+						}
+						chunkSize, err := humanize.ParseBytes(c.String("chunk-size"))
+						if err != nil {
+							return configError(i18n.Wrapf(err, "invalid --chunk-size %s", c.String("chunk-size")))
+						}
+						ctx, stop := signalContext()
+						defer stop()
+
+						result, err := backend.Benchmark(ctx, backendType, bkd, backend.BenchmarkOption{
+							BlobID:      c.String("blob"),
+							BlobSize:    int64(blobSize),
+							ChunkSize:   int64(chunkSize),
+							Requests:    c.Int("requests"),
+							Concurrency: c.Int("concurrency"),
+						})
+						if err != nil {
+							return err
+						}
+						if err := result.Print(os.Stdout); err != nil {
+							return err
+						}
+						if outputJSON := c.String("output-json"); outputJSON != "" {
+							data, err := json.MarshalIndent(result, "", "  ")
+							if err != nil {
+								return errors.Wrap(err, "marshal benchmark result")
+							}
+							if err := os.WriteFile(outputJSON, data, 0644); err != nil {
+								return errors.Wrap(err, "write benchmark result")
+							}
+						}
+						if result.Failed() {
+							return fmt.Errorf("%d of %d reads from %s backend failed", result.Errors, result.Requests, backendType)
+						}
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "Clean up the resources left by crashed nydusify processes",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "mounts",
+					Value:   false,
+					Usage:   "Umount the nydusd and source image mounts left by check, and stop their nydusd processes",
+					EnvVars: []string{"MOUNTS"},
+				},
+				&cli.BoolFlag{
+					Name:    "force",
+					Value:   false,
+					Usage:   "Also clean up the mounts of running nydusify processes",
+					EnvVars: []string{"FORCE"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+
+				if !c.Bool("mounts") {
+					return configError(i18n.Errorf("nothing to prune, please specify --mounts"))
+				}
+				pruned, err := tool.PruneMounts(tool.StateDir, c.Bool("force"))
+				for _, state := range pruned {
+					logrus.Infof("pruned %s mount %s created at %s", state.Kind, state.MountPath, state.CreatedAt.Format(time.RFC3339))
+				}
+				if err != nil {
+					return errors.Wrap(err, "prune mounts")
+				}
+				logrus.Infof("pruned %d mounts", len(pruned))
+				return nil
+			},
+		},
+		{
+			Name:    "mount",
+			Aliases: []string{"view"},
+			Usage:   "Mount the nydus image as a filesystem",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "Target (Nydus) image reference",
+					EnvVars:  []string{"TARGET"},
+				},
+				&cli.BoolFlag{
+					Name:     "target-insecure",
+					Required: false,
+					Usage:    "Skip verifying server certs for HTTPS target registry",
+					EnvVars:  []string{"TARGET_INSECURE"},
+				},
+
+				&cli.StringFlag{
+					Name:     "backend-type",
+					Value:    "",
+					Required: false,
+					Usage:    "Type of storage backend, possible values: 'oss', 's3', 'localfs', 'azblob'",
+					EnvVars:  []string{"BACKEND_TYPE"},
+				},
+				&cli.StringFlag{
+					Name:    "backend-config",
+					Value:   "",
+					Usage:   "Json configuration string for storage backend",
+					EnvVars: []string{"BACKEND_CONFIG"},
+				},
+				&cli.PathFlag{
+					Name:      "backend-config-file",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json configuration file for storage backend",
+					EnvVars:   []string{"BACKEND_CONFIG_FILE"},
+				},
+
+				&cli.StringFlag{
+					Name:    "mount-path",
+					Value:   "./image-fs",
+					Usage:   "Path to mount the image",
+					EnvVars: []string{"MOUNT_PATH"},
+				},
+				&cli.StringFlag{
+					Name:  "platform",
+					Value: "linux/" + runtime.GOARCH,
+					Usage: "Specify platform identifier to choose image manifest, possible values: 'linux/amd64' and 'linux/arm64'",
+				},
+
+				&cli.StringFlag{
+					Name:    "work-dir",
+					Value:   "./tmp",
+					Usage:   "Working directory for image view, will be cleaned up after viewing",
+					EnvVars: []string{"WORK_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "nydusd",
+					Value:   "nydusd",
+					Usage:   "The nydusd binary path, if unset, search in PATH environment",
+					EnvVars: []string{"NYDUSD"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+
+				target, err := getRegistryReference(c, "target")
+				if err != nil {
+					return err
+				}
+
+				backendType, backendConfig, err := getBackendConfig(c, "", false)
+				if err != nil {
+					return err
+				} else if backendConfig == "" {
+
+					backendType = "registry"
+					parsed, err := reference.ParseNormalizedNamed(target)
+					if err != nil {
+						return err
+					}
+
+					backendConfigStruct, err := rule.NewRegistryBackendConfig(parsed)
+					if err != nil {
+						return errors.Wrap(err, "parse registry backend configuration")
+					}
+
+					backendConfigStruct.SkipVerify = c.Bool("target-insecure")
+
+					bytes, err := json.Marshal(backendConfigStruct)
+					if err != nil {
+						return errors.Wrap(err, "marshal registry backend configuration")
+					}
+					backendConfig = string(bytes)
+
+				}
+
+				_, arch, err := provider.ExtractOsArch(c.String("platform"))
+				if err != nil {
+					return err
+				}
+
+				fsViewer, err := viewer.New(viewer.Opt{
+					WorkDir:        c.String("work-dir"),
+					Target:         target,
+					TargetInsecure: c.Bool("target-insecure"),
+					MountPath:      c.String("mount-path"),
+					NydusdPath:     c.String("nydusd"),
+					BackendType:    backendType,
+					BackendConfig:  backendConfig,
+					ExpectedArch:   arch,
+				})
+				if err != nil {
+					return err
+				}
+
+				return fsViewer.View(context.Background())
+			},
+		},
+		{
+			Name:    "build",
+			Aliases: []string{"pack"},
+			Usage:   "Build a Nydus filesystem from a source directory",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "source-dir",
+					Aliases:  []string{"target-dir"}, // for compatibility
+					Required: true,
+					Usage:    "Source directory to build Nydus filesystem from",
+					EnvVars:  []string{"SOURCE_DIR"},
+				},
+				&cli.StringFlag{
+					Name:     "output-dir",
+					Aliases:  []string{"o"},
+					Required: false,
+					Usage:    "Output directory for built artifacts",
+					EnvVars:  []string{"OUTPUT_DIR"},
+				},
+				&cli.StringFlag{
+					Name:     "name",
+					Aliases:  []string{"meta", "bootstrap"}, // for compatibility
+					Required: true,
+					Usage:    "Image name, which will be used as suffix for the generated Nydus image bootstrap/data blobs",
+					EnvVars:  []string{"BOOTSTRAP", "IMAGE_NAME"},
+				},
+
+				&cli.BoolFlag{
+					Name:    "backend-push",
+					Value:   false,
+					Usage:   "Push generated Nydus filesystem to storage backend",
+					EnvVars: []string{"BACKEND_PUSH"},
+				},
+				&cli.StringFlag{
+					Name:        "backend-type",
+					Value:       "oss",
+					DefaultText: "oss",
+					Usage:       "Type of storage backend, possible values: 'oss', 's3', 'localfs', 'azblob'",
+					EnvVars:     []string{"BACKEND_TYPE"},
+				},
+				&cli.StringFlag{
+					Name:    "backend-config",
+					Value:   "",
+					Usage:   "Json configuration string for storage backend",
+					EnvVars: []string{"BACKEND_CONFIG"},
+				},
+				&cli.PathFlag{
+					Name:      "backend-config-file",
+					TakesFile: true,
+					Usage:     "Json configuration file for storage backend",
+					EnvVars:   []string{"BACKEND_CONFIG_FILE"},
+				},
+
+				&cli.StringFlag{
+					Name:    "chunk-dict",
+					Usage:   "Specify a chunk dict expression for chunk deduplication, for example: bootstrap=/path/to/dict.boot",
+					EnvVars: []string{"CHUNK_DICT"},
+				},
+				&cli.StringFlag{
+					Name:    "parent-bootstrap",
+					Usage:   "Specify a parent metadata to reference data chunks",
+					EnvVars: []string{"PARENT_BOOTSTRAP"},
+				},
+				&cli.BoolFlag{
+					Name:    "compact",
+					Usage:   "Compact parent bootstrap before building the image when needed",
+					EnvVars: []string{"COMPACT"},
+				},
+				&cli.PathFlag{
+					Name:      "compact-config-file",
+					TakesFile: true,
+					Usage: "Compact configuration file, default configuration is " +
+						"{\"min_used_ratio\": 5, \"compact_blob_size\": 10485760, \"max_compact_size\": 104857600, " +
+						"\"layers_to_compact\": 32}",
+					EnvVars: []string{"COMPACT_CONFIG_FILE"},
+				},
+
+				&cli.StringFlag{
+					Name:        "fs-version",
+					Required:    false,
+					Usage:       "Nydus image format version number, possible values: 5, 6",
+					EnvVars:     []string{"FS_VERSION"},
+					Value:       "6",
+					DefaultText: "V6 nydus image format",
+				},
+				&cli.StringFlag{
+					Name:    "compressor",
+					Value:   "zstd",
+					Usage:   "Algorithm to compress image data blob, possible values: none, lz4_block, zstd",
+					EnvVars: []string{"COMPRESSOR"},
+				},
+				&cli.Float64Flag{
+					Name:    "min-compression-ratio",
+					Value:   0,
+					Usage:   "Build image data blob uncompressed if the estimated compression ratio of source directory is under it, 0 disables it",
+					EnvVars: []string{"MIN_COMPRESSION_RATIO"},
+				},
+				&cli.StringFlag{
+					Name:    "chunk-size",
+					Value:   "0x100000",
+					Usage:   "size of nydus image data chunk, must be power of two and between 0x1000-0x100000, [default: 0x100000]",
+					EnvVars: []string{"CHUNK_SIZE"},
+				},
+				&cli.BoolFlag{
+					Name:    "blob-meta",
+					Value:   false,
+					Usage:   "Generate the blob meta (TOC) artifact alongside the blob and push it to backend, enables lazy chunk verification on object storage backends",
+					EnvVars: []string{"BLOB_META"},
+				},
+				&cli.DurationFlag{
+					Name:    "build-timeout",
+					Value:   0,
+					Usage:   "Kill nydus-image if the image isn't built in the duration, 0 disables it",
+					EnvVars: []string{"BUILD_TIMEOUT"},
+				},
+				&cli.BoolFlag{
+					Name:    "streaming",
+					Value:   false,
+					Usage:   "Upload the blob to backend while it's being built instead of staging it on local disk, requires --backend-push",
+					EnvVars: []string{"STREAMING"},
+				},
+				&cli.StringFlag{
+					Name:    "blob-transfer",
+					Value:   "",
+					Usage:   "How to transfer the blob from nydus-image to backend, possible values: file (stage on local disk, default), fifo (same as --streaming), memory (buffer in memory up to --blob-memory-limit and spill the rest to a temp file, then upload after build), fifo and memory require --backend-push",
+					EnvVars: []string{"BLOB_TRANSFER"},
+				},
+				&cli.StringFlag{
+					Name:    "blob-memory-limit",
+					Value:   "256MiB",
+					Usage:   "Memory to buffer the blob for --blob-transfer memory, e.g. 64MiB",
+					EnvVars: []string{"BLOB_MEMORY_LIMIT"},
+				},
+
+				&cli.StringFlag{
+					Name:    "nydus-image",
+					Value:   "nydus-image",
+					Usage:   "Path to the nydus-image binary, default to search in PATH",
+					EnvVars: []string{"NYDUS_IMAGE"},
+				},
+			},
+			Before: func(ctx *cli.Context) error {
+				sourcePath := ctx.String("source-dir")
+				fi, err := os.Stat(sourcePath)
+				if err != nil {
+					return errors.Wrapf(err, "failed to check source directory")
+				}
+				if !fi.IsDir() {
+					return errors.Errorf("source path '%s' is not a directory", sourcePath)
+				}
+				return nil
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+
+				var (
+					p             *packer.Packer
+					res           packer.PackResult
+					backendConfig packer.BackendConfig
+					err           error
+				)
+
+				// if backend-push is specified, we should make sure backend-config-file exists
+				if c.Bool("backend-push") || c.Bool("compact") {
+					_backendType, _backendConfig, err := getBackendConfig(c, "", true)
+					if err != nil {
+						return err
+					}
+					// we can verify the _backendType in the `packer.ParseBackendConfigString` function
+					cfg, err := packer.ParseBackendConfigString(_backendType, _backendConfig)
+					if err != nil {
+						return configError(i18n.Errorf("failed to parse backend-config '%s', err = %v", _backendConfig, err))
+					}
+					backendConfig = cfg
+				}
+
+				var blobTransfer packer.BlobTransfer
+				if transfer := c.String("blob-transfer"); transfer != "" {
+					if blobTransfer, err = packer.ParseBlobTransfer(transfer); err != nil {
+						return configError(err)
+					}
+				}
+				blobMemoryLimit, err := parseSizeLimit(c, "blob-memory-limit")
+				if err != nil {
+					return err
+				}
+
+				if p, err = packer.New(packer.Opt{
+					LogLevel:       logrus.GetLevel(),
+					NydusImagePath: c.String("nydus-image"),
+					OutputDir:      c.String("output-dir"),
+					BackendConfig:  backendConfig,
+				}); err != nil {
+					return err
+				}
+
+				ctx, stop := signalContext()
+				defer stop()
+
+				if res, err = p.Pack(ctx, packer.PackRequest{
+					SourceDir:    c.String("source-dir"),
+					ImageName:    c.String("name"),
+					PushToRemote: c.Bool("backend-push"),
+					FsVersion:    c.String("fs-version"),
+					Compressor:   c.String("compressor"),
+					ChunkSize:    c.String("chunk-size"),
+					WithBlobMeta: c.Bool("blob-meta"),
+					Streaming:    c.Bool("streaming"),
+
+					BlobTransfer:    blobTransfer,
+					BlobMemoryLimit: blobMemoryLimit,
+
+					MinCompressionRatio: c.Float64("min-compression-ratio"),
+
+					ChunkDict:         c.String("chunk-dict"),
+					Parent:            c.String("parent-bootstrap"),
+					TryCompact:        c.Bool("compact"),
+					CompactConfigPath: c.String("compact-config-file"),
+					BuildTimeout:      c.Duration("build-timeout"),
+				}); err != nil {
+					return err
+				}
+				if res.BlobMeta != "" {
+					logrus.Infof("successfully built Nydus image (bootstrap:'%s', blob:'%s', blob meta:'%s')", res.Meta, res.Blob, res.BlobMeta)
+					return nil
+				}
+				logrus.Infof("successfully built Nydus image (bootstrap:'%s', blob:'%s')", res.Meta, res.Blob)
+				return nil
+			},
+		},
+		{
+			Name:  "proxy",
+			Usage: "Serve converted nydus images as a pull-through registry proxy, converting and caching on miss",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "listen",
+					Value:   ":5050",
+					Usage:   "Address to serve registry API on",
+					EnvVars: []string{"LISTEN"},
+				},
+				&cli.StringFlag{
+					Name:    "tls-cert",
+					Value:   "",
+					Usage:   "TLS certificate file to serve HTTPS, serve plain HTTP if not specified",
+					EnvVars: []string{"TLS_CERT"},
+				},
+				&cli.StringFlag{
+					Name:    "tls-key",
+					Value:   "",
+					Usage:   "TLS key file to serve HTTPS",
+					EnvVars: []string{"TLS_KEY"},
+				},
+				&cli.StringFlag{
+					Name:    "source-registry",
+					Value:   "",
+					Usage:   "Registry (with optional namespace) to pull source images, default to the `ns` query parameter sent by containerd or docker.io",
+					EnvVars: []string{"SOURCE_REGISTRY"},
+				},
+				&cli.BoolFlag{
+					Name:    "source-insecure",
+					Value:   false,
+					Usage:   "Skip verifying server certs for HTTPS source registry",
+					EnvVars: []string{"SOURCE_INSECURE"},
+				},
+				&cli.StringFlag{
+					Name:     "target-registry",
+					Required: true,
+					Usage:    "Registry (with optional namespace) to push the converted images to and serve them from",
+					EnvVars:  []string{"TARGET_REGISTRY"},
+				},
+				&cli.BoolFlag{
+					Name:    "target-insecure",
+					Value:   false,
+					Usage:   "Skip verifying server certs for HTTPS target registry",
+					EnvVars: []string{"TARGET_INSECURE"},
+				},
+				&cli.StringFlag{
+					Name:        "fs-version",
+					Value:       "6",
+					DefaultText: "V6 nydus image format",
+					Usage:       "Nydus image format version number, possible values: 5, 6",
+					EnvVars:     []string{"FS_VERSION"},
+				},
+				&cli.StringFlag{
+					Name:    "compressor",
+					Value:   "zstd",
+					Usage:   "Algorithm to compress image data blob, possible values: none, lz4_block, zstd",
+					EnvVars: []string{"COMPRESSOR"},
+				},
+				&cli.StringFlag{
+					Name:    "fs-chunk-size",
+					Value:   "0x100000",
+					Usage:   "size of nydus image data chunk, must be power of two and between 0x1000-0x100000, [default: 0x100000]",
+					EnvVars: []string{"FS_CHUNK_SIZE"},
+					Aliases: []string{"chunk-size"},
+				},
+				&cli.BoolFlag{
+					Name:    "all-platforms",
+					Value:   false,
+					Usage:   "Convert images for all platforms, conflicts with --platform",
+					EnvVars: []string{"ALL_PLATFORMS"},
+				},
+				&cli.StringFlag{
+					Name:    "platform",
+					Value:   "linux/" + runtime.GOARCH,
+					Usage:   "Convert images for specific platforms, for example: 'linux/amd64,linux/arm64' or 'all', the variant is matched only if specified, e.g. 'linux/arm/v7'",
+					EnvVars: []string{"PLATFORM"},
+				},
+				&cli.StringFlag{
+					Name:    "work-dir",
+					Value:   "./tmp",
+					Usage:   "Working directory for image conversion",
+					EnvVars: []string{"WORK_DIR"},
+				},
+				&cli.DurationFlag{
+					Name:    "work-dir-gc-age",
+					Value:   24 * time.Hour,
+					Usage:   "Remove the temp directories left by crashed runs in work directory older than the age at startup, 0 disables it",
+					EnvVars: []string{"WORK_DIR_GC_AGE"},
+				},
+				&cli.StringFlag{
+					Name:    "nydus-image",
+					Value:   "nydus-image",
+					Usage:   "Path to the nydus-image binary, default to search in PATH",
+					EnvVars: []string{"NYDUS_IMAGE"},
+				},
+				&cli.PathFlag{
+					Name:      "policy",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json file of conversion policy mapping image name patterns to conversion options (chunk size, fs version, compressor, prefetch file, backend), which override the command options, and the allowlist and denylist of images",
+					EnvVars:   []string{"POLICY"},
+				},
+				&cli.PathFlag{
+					Name:      "publisher-config",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json file of publishers (exec command or HTTP endpoint) to notify external systems like release databases or artifact catalogs of the target image after successful conversion",
+					EnvVars:   []string{"PUBLISHER_CONFIG"},
+				},
+				&cli.PathFlag{
+					Name:      "credential-config",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json file of registry credentials scoped to hosts and operations (pull, push), which replaces the docker config, for running as a shared service",
+					EnvVars:   []string{"CREDENTIAL_CONFIG"},
+				},
+				&cli.PathFlag{
+					Name:      "credential-audit",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "File to append the audit of which credential is used to authenticate to which registry for which image in JSON lines, requires --credential-config",
+					EnvVars:   []string{"CREDENTIAL_AUDIT"},
+				},
+				&cli.StringFlag{
+					Name:    "source-cache-dir",
+					Value:   "",
+					Usage:   "Directory to cache the downloaded source layers by digest, so that the conversions of many tags of the same application reuse the identical base layers, e.g. ~/.nydusify/cache",
+					EnvVars: []string{"SOURCE_CACHE_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "source-cache-max-size",
+					Value:   "20GiB",
+					Usage:   "Size cap of --source-cache-dir, the least recently used layers are evicted once exceeded, empty means no limit",
+					EnvVars: []string{"SOURCE_CACHE_MAX_SIZE"},
+				},
+				&cli.StringFlag{
+					Name:    "dedup-db",
+					Value:   "",
+					Usage:   "URL of the dedup database shared by converters to look up the chunk dict, for examples: http://dedup.example.com/v1, redis://:password@127.0.0.1:6379/0",
+					EnvVars: []string{"DEDUP_DB"},
+				},
+				&cli.StringFlag{
+					Name:    "dedup-scope",
+					Value:   dedup.DefaultScope,
+					Usage:   "Scope in the dedup database to look up the chunk dict, the images converted in the same scope are deduplicated against the same chunk dict",
+					EnvVars: []string{"DEDUP_SCOPE"},
+				},
+				&cli.StringFlag{
+					Name:    "job-state-file",
+					Value:   "",
+					Usage:   "File to persist the conversion jobs, the queued and running jobs are resumed after restart",
+					EnvVars: []string{"JOB_STATE_FILE"},
+				},
+				&cli.IntFlag{
+					Name:    "max-jobs",
+					Value:   0,
+					Usage:   "Maximum number of concurrent conversions, the rest are queued, 0 means no limit",
+					EnvVars: []string{"MAX_JOBS"},
+				},
+				&cli.StringSliceFlag{
+					Name:    "tenant-quota",
+					Usage:   "Maximum number of concurrent conversions of a tenant in the form of tenant=N, can be specified multiple times",
+					EnvVars: []string{"TENANT_QUOTA"},
+				},
+				&cli.IntFlag{
+					Name:    "default-tenant-quota",
+					Value:   0,
+					Usage:   "Maximum number of concurrent conversions of the tenants not in --tenant-quota, 0 means no limit",
+					EnvVars: []string{"DEFAULT_TENANT_QUOTA"},
+				},
+				&cli.IntFlag{
+					Name:    "max-queued-jobs",
+					Value:   0,
+					Usage:   "Maximum number of conversions waiting for a free slot, the new ones are rejected with 429 if the queue is full, 0 means no limit",
+					EnvVars: []string{"MAX_QUEUED_JOBS"},
+				},
+				&cli.Float64Flag{
+					Name:    "job-cpu",
+					Value:   0,
+					Usage:   "CPU cores limit of the nydus-image processes of each conversion, enforced by cgroup, 0 means no limit",
+					EnvVars: []string{"JOB_CPU"},
+				},
+				&cli.StringFlag{
+					Name:    "job-memory",
+					Value:   "",
+					Usage:   "Memory limit of the nydus-image processes of each conversion, enforced by cgroup, for example: '4GiB'",
+					EnvVars: []string{"JOB_MEMORY"},
+				},
+				&cli.StringFlag{
+					Name:    "job-cgroup-parent",
+					Value:   "nydusify",
+					Usage:   "Delegated cgroup v2 directory to create the cgroup of each conversion in for --job-cpu and --job-memory, relative to /sys/fs/cgroup if not absolute",
+					EnvVars: []string{"JOB_CGROUP_PARENT"},
+				},
+				&cli.StringFlag{
+					Name:    "job-disk-limit",
+					Value:   "",
+					Usage:   "Size cap of the unpacked layers and the staged blobs of each conversion respectively, the conversion fails once exceeded, for example: '50GiB'",
+					EnvVars: []string{"JOB_DISK_LIMIT"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+
+				fsVersion := c.String("fs-version")
+				possibleFsVersions := []string{"5", "6"}
+				if !isPossibleValue(possibleFsVersions, fsVersion) {
+					return configError(i18n.Errorf("--fs-version should be one of %v", possibleFsVersions))
+				}
+				for _, registry := range []string{c.String("source-registry"), c.String("target-registry")} {
+					if registry == "" {
+						continue
+					}
+					if err := proxy.ValidateRegistry(registry); err != nil {
+						return configError(err)
+					}
+				}
+
+				tenantQuotas, err := parseTenantQuotas(c.StringSlice("tenant-quota"))
 				if err != nil {
 					return err
 				}
-				cacheMaxRecords := c.Uint("build-cache-max-records")
-				if cacheMaxRecords < 1 {
-					return fmt.Errorf("--build-cache-max-records should be greater than 0")
+				jobMemory, err := parseSizeLimit(c, "job-memory")
+				if err != nil {
+					return err
 				}
-				if cacheMaxRecords > maxCacheMaxRecords {
-					return fmt.Errorf("--build-cache-max-records should not be greater than %d", maxCacheMaxRecords)
+				jobLimit := utils.CgroupLimit{CPU: c.Float64("job-cpu"), Memory: jobMemory}
+				if err := jobLimit.Validate(); err != nil {
+					return configError(err)
 				}
-				cacheVersion := c.String("build-cache-version")
-
-				fsVersion := c.String("fs-version")
-				possibleFsVersions := []string{"5", "6"}
-				if !isPossibleValue(possibleFsVersions, fsVersion) {
-					return fmt.Errorf("--fs-version should be one of %v", possibleFsVersions)
+				jobDiskLimit, err := parseSizeLimit(c, "job-disk-limit")
+				if err != nil {
+					return err
 				}
-
-				prefetchPatterns, err := getPrefetchPatterns(c)
+				sourceCacheMaxSize, err := parseSizeLimit(c, "source-cache-max-size")
 				if err != nil {
 					return err
 				}
+				// Each conversion unpacks layers in its own temp directory
+				// of unpack area, so that the size is capped per job.
+				unpackDir := ""
+				if jobDiskLimit > 0 {
+					unpackDir = filepath.Join(c.String("work-dir"), "unpack")
+				}
 
-				chunkDictRef := ""
-				chunkDict := c.String("chunk-dict")
-				if chunkDict != "" {
-					_, _, chunkDictRef, err = converter.ParseChunkDictArgs(chunkDict)
-					if err != nil {
-						return errors.Wrap(err, "parse chunk dict arguments")
+				var policy *converter.Policy
+				if path := c.String("policy"); path != "" {
+					if policy, err = converter.LoadPolicy(path); err != nil {
+						return configError(err)
 					}
 				}
 
-				docker2OCI := false
-				if c.Bool("docker-v2-format") {
-					logrus.Warn("the option `--docker-v2-format` has been deprecated, use `--oci` instead")
-					docker2OCI = false
-				} else if c.Bool("oci") {
-					docker2OCI = true
+				var publishing *converter.Publishing
+				if path := c.String("publisher-config"); path != "" {
+					if publishing, err = converter.LoadPublishing(path); err != nil {
+						return configError(err)
+					}
 				}
 
-				// Forcibly enable `--oci` option when `--oci-ref` be enabled.
-				if c.Bool("oci-ref") {
-					logrus.Warn("forcibly enabled `--oci` option when `--oci-ref` be enabled")
-					docker2OCI = true
+				credentials, closeAudit, err := getCredentialManager(c)
+				if err != nil {
+					return err
 				}
+				defer closeAudit()
 
-				opt := converter.Opt{
-					WorkDir:        c.String("work-dir"),
-					NydusImagePath: c.String("nydus-image"),
-
-					Source:         c.String("source"),
-					Target:         targetRef,
+				pxy, err := proxy.New(proxy.Opt{
+					SourceRegistry: c.String("source-registry"),
 					SourceInsecure: c.Bool("source-insecure"),
+					TargetRegistry: c.String("target-registry"),
 					TargetInsecure: c.Bool("target-insecure"),
+					Convert: converter.Opt{
+						WorkDir:         c.String("work-dir"),
+						WorkDirGCAge:    c.Duration("work-dir-gc-age"),
+						NydusImagePath:  c.String("nydus-image"),
+						CacheMaxRecords: maxCacheMaxRecords,
+						CacheVersion:    "v1",
+						FsVersion:       fsVersion,
+						Compressor:      c.String("compressor"),
+						ChunkSize:       c.String("fs-chunk-size"),
+						BatchSize:       "0",
+						AllPlatforms:    c.Bool("all-platforms"),
+						Platforms:       c.String("platform"),
+						Policy:          policy,
+						Publishing:      publishing,
+						Credentials:     credentials,
+						DedupDB:         c.String("dedup-db"),
+						DedupScope:      c.String("dedup-scope"),
 
-					BackendType:      backendType,
-					BackendConfig:    backendConfig,
-					BackendForcePush: c.Bool("backend-force-push"),
-
-					CacheRef:        cacheRef,
-					CacheInsecure:   c.Bool("build-cache-insecure"),
-					CacheMaxRecords: cacheMaxRecords,
-					CacheVersion:    cacheVersion,
-
-					ChunkDictRef:      chunkDictRef,
-					ChunkDictInsecure: c.Bool("chunk-dict-insecure"),
-
-					PrefetchPatterns: prefetchPatterns,
-					MergePlatform:    c.Bool("merge-platform"),
-					Docker2OCI:       docker2OCI,
-					FsVersion:        fsVersion,
-					FsAlignChunk:     c.Bool("backend-aligned-chunk") || c.Bool("fs-align-chunk"),
-					Compressor:       c.String("compressor"),
-					ChunkSize:        c.String("chunk-size"),
-					BatchSize:        c.String("batch-size"),
-
-					OCIRef:       c.Bool("oci-ref"),
-					WithReferrer: c.Bool("with-referrer"),
-					AllPlatforms: c.Bool("all-platforms"),
-					Platforms:    c.String("platform"),
+						SourceCacheDir:     c.String("source-cache-dir"),
+						SourceCacheMaxSize: sourceCacheMaxSize,
 
-					OutputJSON: c.String("output-json"),
+						UnpackDir:           unpackDir,
+						UnpackDirLimit:      jobDiskLimit,
+						BlobDirLimit:        jobDiskLimit,
+						BuilderLimit:        jobLimit,
+						BuilderCgroupParent: c.String("job-cgroup-parent"),
+						BuilderPriority:     builderPriority,
+					},
+					JobStateFile:       c.String("job-state-file"),
+					MaxJobs:            c.Int("max-jobs"),
+					TenantQuotas:       tenantQuotas,
+					DefaultTenantQuota: c.Int("default-tenant-quota"),
+					MaxQueuedJobs:      c.Int("max-queued-jobs"),
+				})
+				if err != nil {
+					return err
 				}
+				pxy.Resume()
 
-				return converter.Convert(context.Background(), opt)
+				logrus.Infof("serving registry proxy on %s", c.String("listen"))
+				if c.String("tls-cert") != "" {
+					return http.ListenAndServeTLS(c.String("listen"), c.String("tls-cert"), c.String("tls-key"), pxy)
+				}
+				return http.ListenAndServe(c.String("listen"), pxy)
 			},
 		},
 		{
-			Name:  "check",
-			Usage: "Verify nydus image format and content",
+			Name:  "list",
+			Usage: "List the tags in a repository and report which ones are nydus images",
 			Flags: []cli.Flag{
 				&cli.StringFlag{
-					Name:     "source",
-					Required: false,
-					Usage:    "Source OCI image reference",
-					EnvVars:  []string{"SOURCE"},
+					Name:     "repo",
+					Required: true,
+					Usage:    "Repository to list, without tag or digest, e.g. myregistry/library/nginx",
+					EnvVars:  []string{"REPO"},
+				},
+				&cli.BoolFlag{
+					Name:    "insecure",
+					Value:   false,
+					Usage:   "Skip verifying server certs for HTTPS registry",
+					EnvVars: []string{"INSECURE"},
+				},
+				&cli.IntFlag{
+					Name:    "concurrency",
+					Value:   5,
+					Usage:   "Number of tags inspected concurrently",
+					EnvVars: []string{"CONCURRENCY"},
 				},
 				&cli.StringFlag{
-					Name:     "target",
+					Name:    "output-json",
+					Value:   "",
+					Usage:   "File path to save the listing result in JSON format",
+					EnvVars: []string{"OUTPUT_JSON"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+
+				insecure := c.Bool("insecure")
+				result, err := lister.List(context.Background(), lister.Opt{
+					Repo:        c.String("repo"),
+					Concurrency: c.Int("concurrency"),
+					OutputJSON:  c.String("output-json"),
+				}, func(ref string) (*remote.Remote, error) {
+					return provider.DefaultRemote(ref, insecure)
+				})
+				if err != nil {
+					return err
+				}
+				return result.Print(os.Stdout)
+			},
+		},
+		{
+			Name:  "gc",
+			Usage: "Delete the old converted tags in a repository by retention policy per source tag, and their exclusive blobs in storage backend",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "repo",
 					Required: true,
-					Usage:    "Target (Nydus) image reference",
-					EnvVars:  []string{"TARGET"},
+					Usage:    "Repository of converted images, without tag or digest, e.g. myregistry/library/nginx",
+					EnvVars:  []string{"REPO"},
 				},
 				&cli.BoolFlag{
-					Name:     "source-insecure",
-					Required: false,
-					Usage:    "Skip verifying server certs for HTTPS source registry",
-					EnvVars:  []string{"SOURCE_INSECURE"},
+					Name:    "insecure",
+					Value:   false,
+					Usage:   "Skip verifying server certs for HTTPS registry",
+					EnvVars: []string{"INSECURE"},
 				},
-				&cli.BoolFlag{
-					Name:     "target-insecure",
-					Required: false,
-					Usage:    "Skip verifying server certs for HTTPS target registry",
-					EnvVars:  []string{"TARGET_INSECURE"},
+				&cli.StringFlag{
+					Name:    "tag-pattern",
+					Value:   retention.DefaultTagPattern,
+					Usage:   "Regexp matching the converted tags, whose first capture group is the source tag to group them by, the other tags are never deleted",
+					EnvVars: []string{"TAG_PATTERN"},
+				},
+				&cli.IntFlag{
+					Name:    "keep-last",
+					Value:   3,
+					Usage:   "Number of the newest converted tags kept per source tag, by the creation time of image config",
+					EnvVars: []string{"KEEP_LAST"},
+				},
+				&cli.DurationFlag{
+					Name:    "keep-within",
+					Value:   0,
+					Usage:   "Also keep the converted tags created within the duration, e.g. 720h",
+					EnvVars: []string{"KEEP_WITHIN"},
 				},
-
 				&cli.StringFlag{
 					Name:    "backend-type",
 					Value:   "",
-					Usage:   "Type of storage backend, enable verification of file data in Nydus image if specified, possible values: 'oss', 's3'",
+					Usage:   "Type of storage backend to delete the exclusive blobs of deleted tags from, possible values: 'oss', 's3', 'localfs', 'azblob'",
 					EnvVars: []string{"BACKEND_TYPE"},
 				},
 				&cli.StringFlag{
@@ -578,36 +3774,23 @@ func main() {
 					Usage:     "Json configuration file for storage backend",
 					EnvVars:   []string{"BACKEND_CONFIG_FILE"},
 				},
-
 				&cli.BoolFlag{
-					Name:    "multi-platform",
+					Name:    "dry-run",
 					Value:   false,
-					Usage:   "Verify that the image contains an image index with both OCI and Nydus manifests",
-					EnvVars: []string{"MULTI_PLATFORM"},
-				},
-				&cli.StringFlag{
-					Name:  "platform",
-					Value: "linux/" + runtime.GOARCH,
-					Usage: "Specify platform identifier to choose image manifest, possible values: 'linux/amd64' and 'linux/arm64'",
-				},
-
-				&cli.StringFlag{
-					Name:    "work-dir",
-					Value:   "./output",
-					Usage:   "Working directory for image verification",
-					EnvVars: []string{"WORK_DIR"},
+					Usage:   "Only print the tags and blobs to delete",
+					EnvVars: []string{"DRY_RUN"},
 				},
-				&cli.StringFlag{
-					Name:    "nydus-image",
-					Value:   "nydus-image",
-					Usage:   "Path to the nydus-image binary, default to search in PATH",
-					EnvVars: []string{"NYDUS_IMAGE"},
+				&cli.IntFlag{
+					Name:    "concurrency",
+					Value:   5,
+					Usage:   "Number of tags inspected and blobs deleted concurrently",
+					EnvVars: []string{"CONCURRENCY"},
 				},
 				&cli.StringFlag{
-					Name:    "nydusd",
-					Value:   "nydusd",
-					Usage:   "Path to the nydusd binary, default to search in PATH",
-					EnvVars: []string{"NYDUSD"},
+					Name:    "output-json",
+					Value:   "",
+					Usage:   "File path to save the retention result in JSON format",
+					EnvVars: []string{"OUTPUT_JSON"},
 				},
 			},
 			Action: func(c *cli.Context) error {
@@ -617,248 +3800,383 @@ func main() {
 				if err != nil {
 					return err
 				}
-
-				_, arch, err := provider.ExtractOsArch(c.String("platform"))
-				if err != nil {
-					return err
+				var bkd backend.Backend
+				if backendType != "" {
+					if bkd, err = backend.NewBackend(backendType, []byte(backendConfig), nil); err != nil {
+						return errors.Wrap(err, "create backend")
+					}
 				}
+				ctx, stop := signalContext()
+				defer stop()
 
-				checker, err := checker.New(checker.Opt{
-					WorkDir:        c.String("work-dir"),
-					Source:         c.String("source"),
-					Target:         c.String("target"),
-					MultiPlatform:  c.Bool("multi-platform"),
-					SourceInsecure: c.Bool("source-insecure"),
-					TargetInsecure: c.Bool("target-insecure"),
-					NydusImagePath: c.String("nydus-image"),
-					NydusdPath:     c.String("nydusd"),
-					BackendType:    backendType,
-					BackendConfig:  backendConfig,
-					ExpectedArch:   arch,
+				insecure := c.Bool("insecure")
+				result, err := retention.Run(ctx, retention.Opt{
+					Repo:        c.String("repo"),
+					TagPattern:  c.String("tag-pattern"),
+					KeepLast:    c.Int("keep-last"),
+					KeepWithin:  c.Duration("keep-within"),
+					Backend:     bkd,
+					DryRun:      c.Bool("dry-run"),
+					Concurrency: c.Int("concurrency"),
+					OutputJSON:  c.String("output-json"),
+				}, func(ref string) (*remote.Remote, error) {
+					return provider.DefaultRemote(ref, insecure)
 				})
 				if err != nil {
 					return err
 				}
-
-				return checker.Check(context.Background())
+				return result.Print(os.Stdout)
 			},
 		},
 		{
-			Name:  "chunkdict",
-			Usage: "Deduplicate chunk for Nydus image (experimental)",
-			Subcommands: []*cli.Command{
-				{
-					Name:  "generate",
-					Usage: "Save chunk and blob information of Multi-image into the database (experimental)",
-					Flags: []cli.Flag{
-						&cli.StringSliceFlag{
-							Name:     "sources",
-							Required: true,
-							Usage:    "One or more Nydus image reference(Multiple images should be split by commas)",
-							EnvVars:  []string{"SOURCES"},
-						},
-						&cli.BoolFlag{
-							Name:     "source-insecure",
-							Required: false,
-							Usage:    "Skip verifying server certs for HTTPS source registry",
-							EnvVars:  []string{"SOURCE_INSECURE"},
-						},
-						&cli.StringFlag{
-							Name:    "work-dir",
-							Value:   "./output",
-							Usage:   "Working directory for generating chunkdict image",
-							EnvVars: []string{"WORK_DIR"},
-						},
-						&cli.StringFlag{
-							Name:    "nydus-image",
-							Value:   "nydus-image",
-							Usage:   "Path to the nydus-image binary, default to search in PATH",
-							EnvVars: []string{"NYDUS_IMAGE"},
-						},
-						&cli.StringFlag{
-							Name:  "platform",
-							Value: "linux/" + runtime.GOARCH,
-							Usage: "Specify platform identifier to choose image manifest, possible values: 'linux/amd64' and 'linux/arm64'",
-						},
-					},
-					Action: func(c *cli.Context) error {
-						setupLogLevel(c)
-
-						_, arch, err := provider.ExtractOsArch(c.String("platform"))
-						if err != nil {
-							return err
-						}
+			Name:  "history",
+			Usage: "Show how size, duration and dedup of a target image evolved across conversions",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "Target (Nydus) image reference",
+					EnvVars:  []string{"TARGET"},
+				},
+				&cli.StringFlag{
+					Name:     "history-dir",
+					Required: true,
+					Usage:    "Directory of conversion summaries persisted by 'nydusify convert --history-dir'",
+					EnvVars:  []string{"HISTORY_DIR"},
+				},
+				&cli.IntFlag{
+					Name:    "limit",
+					Value:   10,
+					Usage:   "Number of latest conversions to show, 0 shows all",
+					EnvVars: []string{"LIMIT"},
+				},
+				&cli.StringFlag{
+					Name:    "output-json",
+					Value:   "",
+					Usage:   "File path to save the shown conversions in JSON format",
+					EnvVars: []string{"OUTPUT_JSON"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
 
-						generator, err := generator.New(generator.Opt{
-							WorkDir:        c.String("work-dir"),
-							Sources:        c.StringSlice("sources"),
-							SourceInsecure: c.Bool("source-insecure"),
-							NydusImagePath: c.String("nydus-image"),
-							ExpectedArch:   arch,
-						})
-						if err != nil {
-							return err
-						}
+				entries, err := converter.LoadHistory(c.String("history-dir"), c.String("target"))
+				if err != nil {
+					return err
+				}
+				if len(entries) == 0 {
+					return fmt.Errorf("no conversion history of %s", c.String("target"))
+				}
 
-						return generator.Generate(context.Background())
-					},
-				},
+				if path := c.String("output-json"); path != "" {
+					shown := entries
+					if limit := c.Int("limit"); limit > 0 && len(shown) > limit {
+						shown = shown[len(shown)-limit:]
+					}
+					data, err := json.MarshalIndent(shown, "", "  ")
+					if err != nil {
+						return errors.Wrap(err, "marshal conversion history")
+					}
+					if err := os.WriteFile(path, data, 0644); err != nil {
+						return errors.Wrap(err, "write conversion history")
+					}
+				}
+				return converter.PrintHistory(os.Stdout, entries, c.Int("limit"))
 			},
 		},
 		{
-			Name:    "mount",
-			Aliases: []string{"view"},
-			Usage:   "Mount the nydus image as a filesystem",
+			Name:  "copy",
+			Usage: "Copy images from source to target",
 			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "source",
+					Required: false,
+					Usage:    "Source image reference, supports transports docker://, oci:, oci-archive:, docker-archive:, containers-storage: and dir:",
+					EnvVars:  []string{"SOURCE"},
+				},
 				&cli.StringFlag{
 					Name:     "target",
-					Required: true,
-					Usage:    "Target (Nydus) image reference",
+					Required: false,
+					Usage:    "Target image reference, supports the same transports as --source",
 					EnvVars:  []string{"TARGET"},
 				},
+				&cli.StringFlag{
+					Name:     "target-template",
+					Required: false,
+					Usage:    "Generate the target image reference from the template with variables of source image reference, for example: '{{repo}}/{{name}}:{{tag}}-nydus', possible variables: registry, repo, namespace, name, tag",
+					EnvVars:  []string{"TARGET_TEMPLATE"},
+				},
+				&cli.StringSliceFlag{
+					Name:     "sources",
+					Required: false,
+					Usage:    "Copy multiple source images concurrently, the targets are generated by --target-template, conflicts with --source",
+					EnvVars:  []string{"SOURCES"},
+				},
+				&cli.PathFlag{
+					Name:      "source-file",
+					Required:  false,
+					TakesFile: true,
+					Usage:     "Copy the images listed in file concurrently, one 'SOURCE [TARGET]' per line, the missing targets are generated by --target-template",
+					EnvVars:   []string{"SOURCE_FILE"},
+				},
+				&cli.IntFlag{
+					Name:    "image-concurrency",
+					Value:   3,
+					Usage:   "Maximum number of images copied concurrently by --sources and --source-file",
+					EnvVars: []string{"IMAGE_CONCURRENCY"},
+				},
+				&cli.BoolFlag{
+					Name:     "source-insecure",
+					Required: false,
+					Usage:    "Skip verifying server certs for HTTPS source registry",
+					EnvVars:  []string{"SOURCE_INSECURE"},
+				},
 				&cli.BoolFlag{
 					Name:     "target-insecure",
 					Required: false,
 					Usage:    "Skip verifying server certs for HTTPS target registry",
 					EnvVars:  []string{"TARGET_INSECURE"},
 				},
+				&cli.BoolFlag{
+					Name:     "source-plain-http",
+					Required: false,
+					Usage:    "Access source registry by plain HTTP instead of HTTPS",
+					EnvVars:  []string{"SOURCE_PLAIN_HTTP"},
+				},
+				&cli.BoolFlag{
+					Name:     "target-plain-http",
+					Required: false,
+					Usage:    "Access target registry by plain HTTP instead of HTTPS",
+					EnvVars:  []string{"TARGET_PLAIN_HTTP"},
+				},
 
 				&cli.StringFlag{
-					Name:     "backend-type",
-					Value:    "",
-					Required: false,
-					Usage:    "Type of storage backend, possible values: 'oss', 's3'",
-					EnvVars:  []string{"BACKEND_TYPE"},
+					Name:    "source-backend-type",
+					Value:   "",
+					Usage:   "Type of storage backend, possible values: 'oss', 's3', 'localfs', 'azblob'",
+					EnvVars: []string{"BACKEND_TYPE"},
 				},
 				&cli.StringFlag{
-					Name:    "backend-config",
+					Name:    "source-backend-config",
 					Value:   "",
 					Usage:   "Json configuration string for storage backend",
 					EnvVars: []string{"BACKEND_CONFIG"},
 				},
 				&cli.PathFlag{
-					Name:      "backend-config-file",
+					Name:      "source-backend-config-file",
 					Value:     "",
 					TakesFile: true,
 					Usage:     "Json configuration file for storage backend",
 					EnvVars:   []string{"BACKEND_CONFIG_FILE"},
 				},
-
 				&cli.StringFlag{
-					Name:    "mount-path",
-					Value:   "./image-fs",
-					Usage:   "Path to mount the image",
-					EnvVars: []string{"MOUNT_PATH"},
+					Name:    "target-backend-type",
+					Value:   "",
+					Usage:   "Type of storage backend to upload the blobs to instead of target registry, possible values: 'oss', 's3', 'localfs', 'azblob'",
+					EnvVars: []string{"TARGET_BACKEND_TYPE"},
+				},
+				&cli.StringFlag{
+					Name:    "target-backend-config",
+					Value:   "",
+					Usage:   "Json configuration string for target storage backend",
+					EnvVars: []string{"TARGET_BACKEND_CONFIG"},
+				},
+				&cli.PathFlag{
+					Name:      "target-backend-config-file",
+					Value:     "",
+					TakesFile: true,
+					Usage:     "Json configuration file for target storage backend",
+					EnvVars:   []string{"TARGET_BACKEND_CONFIG_FILE"},
+				},
+				&cli.BoolFlag{
+					Name:    "target-backend-force-push",
+					Value:   false,
+					Usage:   "Upload the blobs even if they already exist in target storage backend",
+					EnvVars: []string{"TARGET_BACKEND_FORCE_PUSH"},
+				},
+
+				&cli.BoolFlag{
+					Name:  "all-platforms",
+					Value: false,
+					Usage: "Copy images for all platforms, conflicts with --platform",
 				},
 				&cli.StringFlag{
 					Name:  "platform",
 					Value: "linux/" + runtime.GOARCH,
-					Usage: "Specify platform identifier to choose image manifest, possible values: 'linux/amd64' and 'linux/arm64'",
+					Usage: "Copy images for specific platforms, for example: 'linux/amd64,linux/arm64' or 'all', the variant is matched only if specified, e.g. 'linux/arm/v7'",
+				},
+
+				&cli.StringFlag{
+					Name:    "digest-algorithm",
+					Value:   "sha256",
+					Usage:   "Digest algorithm for the pushed index, manifests, configs and bootstrap layers, possible values: sha256, sha384, sha512",
+					EnvVars: []string{"DIGEST_ALGORITHM"},
+				},
+				&cli.StringFlag{
+					Name:  "push-chunk-size",
+					Value: "0MB",
+					Usage: "Chunk size for pushing a blob layer in chunked",
+				},
+				&cli.BoolFlag{
+					Name:    "adaptive-concurrency",
+					Value:   false,
+					Usage:   "Adjust the concurrency of pulling and pushing layers at runtime based on throughput and system load, the layer builds are not covered",
+					EnvVars: []string{"ADAPTIVE_CONCURRENCY"},
+				},
+				&cli.IntFlag{
+					Name:    "max-concurrency",
+					Value:   0,
+					Usage:   "Maximum concurrency of pulling and pushing layers used by --adaptive-concurrency, default to twice the CPU count",
+					EnvVars: []string{"MAX_CONCURRENCY"},
 				},
 
 				&cli.StringFlag{
 					Name:    "work-dir",
 					Value:   "./tmp",
-					Usage:   "Working directory for image view, will be cleaned up after viewing",
+					Usage:   "Working directory for image copy",
 					EnvVars: []string{"WORK_DIR"},
 				},
+				&cli.DurationFlag{
+					Name:    "work-dir-gc-age",
+					Value:   24 * time.Hour,
+					Usage:   "Remove the temp directories left by crashed runs in work directory older than the age at startup, 0 disables it",
+					EnvVars: []string{"WORK_DIR_GC_AGE"},
+				},
 				&cli.StringFlag{
-					Name:    "nydusd",
-					Value:   "nydusd",
-					Usage:   "The nydusd binary path, if unset, search in PATH environment",
-					EnvVars: []string{"NYDUSD"},
+					Name:    "nydus-image",
+					Value:   "nydus-image",
+					Usage:   "Path to the nydus-image binary, default to search in PATH",
+					EnvVars: []string{"NYDUS_IMAGE"},
+				},
+				&cli.BoolFlag{
+					Name:    "keep-going",
+					Value:   false,
+					Usage:   "Continue with the rest platforms after a platform fails, the target index only references the succeeded platforms, exits non-zero with a summary of failures",
+					EnvVars: []string{"KEEP_GOING"},
+				},
+				&cli.IntFlag{
+					Name:    "pull-retry",
+					Value:   3,
+					Usage:   "Max retries of the registry requests pulling images failed by 429, 5xx or network errors, with exponential backoff honoring Retry-After, 0 disables it",
+					EnvVars: []string{"PULL_RETRY"},
+				},
+				&cli.IntFlag{
+					Name:    "push-retry",
+					Value:   3,
+					Usage:   "Max retries of the registry requests and blobs pushing images failed by 429, 5xx or network errors, with exponential backoff honoring Retry-After, 0 disables it",
+					EnvVars: []string{"PUSH_RETRY"},
 				},
 			},
 			Action: func(c *cli.Context) error {
 				setupLogLevel(c)
+				if err := setRetryPolicy(c); err != nil {
+					return err
+				}
+				if err := resolveShortNames(c); err != nil {
+					return err
+				}
 
-				backendType, backendConfig, err := getBackendConfig(c, "", false)
+				sourceBackendType, sourceBackendConfig, err := getBackendConfig(c, "source-", false)
 				if err != nil {
 					return err
-				} else if backendConfig == "" {
+				}
+				targetBackendType, targetBackendConfig, err := getBackendConfig(c, "target-", false)
+				if err != nil {
+					return err
+				}
 
-					backendType = "registry"
-					parsed, err := reference.ParseNormalizedNamed(c.String("target"))
-					if err != nil {
-						return err
-					}
+				pushChunkSize, err := humanize.ParseBytes(c.String("push-chunk-size"))
+				if err != nil {
+					return configError(i18n.Wrap(err, "invalid --push-chunk-size option"))
+				}
+				if pushChunkSize > 0 {
+					logrus.Infof("will copy layer with chunk size %s", c.String("push-chunk-size"))
+				}
 
-					backendConfigStruct, err := rule.NewRegistryBackendConfig(parsed)
-					if err != nil {
-						return errors.Wrap(err, "parse registry backend configuration")
+				images, err := getCopyImages(c)
+				if err != nil {
+					return err
+				}
+				target := ""
+				if images == nil {
+					if c.String("source") == "" {
+						return configError(i18n.Errorf("--source, --sources or --source-file is required"))
 					}
-
-					backendConfigStruct.SkipVerify = c.Bool("target-insecure")
-
-					bytes, err := json.Marshal(backendConfigStruct)
-					if err != nil {
-						return errors.Wrap(err, "marshal registry backend configuration")
+					if target, err = getTargetReference(c); err != nil {
+						return err
 					}
-					backendConfig = string(bytes)
-
 				}
 
-				_, arch, err := provider.ExtractOsArch(c.String("platform"))
+				digestAlgorithm, err := utils.ParseDigestAlgorithm(c.String("digest-algorithm"))
 				if err != nil {
-					return err
+					return configError(i18n.Wrap(err, "parse digest algorithm"))
 				}
 
-				fsViewer, err := viewer.New(viewer.Opt{
+				opt := copier.Opt{
 					WorkDir:        c.String("work-dir"),
-					Target:         c.String("target"),
+					NydusImagePath: c.String("nydus-image"),
+
+					Source:         c.String("source"),
+					Target:         target,
+					SourceInsecure: c.Bool("source-insecure"),
 					TargetInsecure: c.Bool("target-insecure"),
-					MountPath:      c.String("mount-path"),
-					NydusdPath:     c.String("nydusd"),
-					BackendType:    backendType,
-					BackendConfig:  backendConfig,
-					ExpectedArch:   arch,
-				})
-				if err != nil {
-					return err
+
+					SourcePlainHTTP: c.Bool("source-plain-http"),
+					TargetPlainHTTP: c.Bool("target-plain-http"),
+
+					SourceBackendType:   sourceBackendType,
+					SourceBackendConfig: sourceBackendConfig,
+
+					TargetBackendType:      targetBackendType,
+					TargetBackendConfig:    targetBackendConfig,
+					TargetBackendForcePush: c.Bool("target-backend-force-push"),
+
+					AllPlatforms: c.Bool("all-platforms"),
+					Platforms:    c.String("platform"),
+
+					PushChunkSize:   int64(pushChunkSize),
+					DigestAlgorithm: digestAlgorithm,
+					WorkDirGCAge:    c.Duration("work-dir-gc-age"),
+
+					AdaptiveConcurrency: c.Bool("adaptive-concurrency"),
+					MaxConcurrency:      c.Int("max-concurrency"),
+
+					KeepGoing: c.Bool("keep-going"),
+
+					Images:           images,
+					ImageConcurrency: c.Int("image-concurrency"),
 				}
 
-				return fsViewer.View(context.Background())
+				return copier.Copy(context.Background(), opt)
 			},
 		},
 		{
-			Name:    "build",
-			Aliases: []string{"pack"},
-			Usage:   "Build a Nydus filesystem from a source directory",
+			Name:  "bundle",
+			Usage: "Pack a converted image with its chunk dict images, prefetch files and blobs in storage backend into a signed archive for air-gapped networks",
 			Flags: []cli.Flag{
 				&cli.StringFlag{
-					Name:     "source-dir",
-					Aliases:  []string{"target-dir"}, // for compatibility
+					Name:     "source",
 					Required: true,
-					Usage:    "Source directory to build Nydus filesystem from",
-					EnvVars:  []string{"SOURCE_DIR"},
+					Usage:    "Converted image reference in registry, the chunk dict images referenced by its manifests are bundled too",
+					EnvVars:  []string{"SOURCE"},
 				},
-				&cli.StringFlag{
-					Name:     "output-dir",
-					Aliases:  []string{"o"},
+				&cli.BoolFlag{
+					Name:     "source-insecure",
 					Required: false,
-					Usage:    "Output directory for built artifacts",
-					EnvVars:  []string{"OUTPUT_DIR"},
-				},
-				&cli.StringFlag{
-					Name:     "name",
-					Aliases:  []string{"meta", "bootstrap"}, // for compatibility
-					Required: true,
-					Usage:    "Image name, which will be used as suffix for the generated Nydus image bootstrap/data blobs",
-					EnvVars:  []string{"BOOTSTRAP", "IMAGE_NAME"},
+					Usage:    "Skip verifying server certs for HTTPS source registry",
+					EnvVars:  []string{"SOURCE_INSECURE"},
 				},
-
 				&cli.BoolFlag{
-					Name:    "backend-push",
-					Value:   false,
-					Usage:   "Push generated Nydus filesystem to storage backend",
-					EnvVars: []string{"BACKEND_PUSH"},
+					Name:     "source-plain-http",
+					Required: false,
+					Usage:    "Access source registry by plain HTTP instead of HTTPS",
+					EnvVars:  []string{"SOURCE_PLAIN_HTTP"},
 				},
 				&cli.StringFlag{
-					Name:        "backend-type",
-					Value:       "oss",
-					DefaultText: "oss",
-					Usage:       "Type of storage backend, possible values: 'oss', 's3'",
-					EnvVars:     []string{"BACKEND_TYPE"},
+					Name:    "backend-type",
+					Value:   "",
+					Usage:   "Type of storage backend storing the blobs of image, possible values: 'oss', 's3', 'localfs', 'azblob'",
+					EnvVars: []string{"BACKEND_TYPE"},
 				},
 				&cli.StringFlag{
 					Name:    "backend-config",
@@ -868,56 +4186,40 @@ func main() {
 				},
 				&cli.PathFlag{
 					Name:      "backend-config-file",
+					Value:     "",
 					TakesFile: true,
 					Usage:     "Json configuration file for storage backend",
 					EnvVars:   []string{"BACKEND_CONFIG_FILE"},
 				},
-
-				&cli.StringFlag{
-					Name:    "chunk-dict",
-					Usage:   "Specify a chunk dict expression for chunk deduplication, for example: bootstrap=/path/to/dict.boot",
-					EnvVars: []string{"CHUNK_DICT"},
-				},
-				&cli.StringFlag{
-					Name:    "parent-bootstrap",
-					Usage:   "Specify a parent metadata to reference data chunks",
-					EnvVars: []string{"PARENT_BOOTSTRAP"},
-				},
-				&cli.BoolFlag{
-					Name:    "compact",
-					Usage:   "Compact parent bootstrap before building the image when needed",
-					EnvVars: []string{"COMPACT"},
+				&cli.StringSliceFlag{
+					Name:    "prefetch-file",
+					Usage:   "Prefetch list file to bundle, can be specified multiple times, the files are bundled by base name",
+					EnvVars: []string{"PREFETCH_FILE"},
 				},
 				&cli.PathFlag{
-					Name:      "compact-config-file",
-					TakesFile: true,
-					Usage: "Compact configuration file, default configuration is " +
-						"{\"min_used_ratio\": 5, \"compact_blob_size\": 10485760, \"max_compact_size\": 104857600, " +
-						"\"layers_to_compact\": 32}",
-					EnvVars: []string{"COMPACT_CONFIG_FILE"},
+					Name:     "output",
+					Required: true,
+					Usage:    "Path of the bundle archive",
+					EnvVars:  []string{"OUTPUT"},
 				},
-
 				&cli.StringFlag{
-					Name:        "fs-version",
-					Required:    false,
-					Usage:       "Nydus image format version number, possible values: 5, 6",
-					EnvVars:     []string{"FS_VERSION"},
-					Value:       "6",
-					DefaultText: "V6 nydus image format",
+					Name:    "sign-key",
+					Value:   "",
+					Usage:   "Path or KMS URI of the cosign private key to sign the bundle, the password of key file is read from COSIGN_PASSWORD environment",
+					EnvVars: []string{"SIGN_KEY"},
 				},
 				&cli.StringFlag{
-					Name:    "compressor",
-					Value:   "zstd",
-					Usage:   "Algorithm to compress image data blob, possible values: none, lz4_block, zstd",
-					EnvVars: []string{"COMPRESSOR"},
+					Name:    "cosign",
+					Value:   "cosign",
+					Usage:   "Path to the cosign binary, default to search in PATH",
+					EnvVars: []string{"COSIGN"},
 				},
 				&cli.StringFlag{
-					Name:    "chunk-size",
-					Value:   "0x100000",
-					Usage:   "size of nydus image data chunk, must be power of two and between 0x1000-0x100000, [default: 0x100000]",
-					EnvVars: []string{"CHUNK_SIZE"},
+					Name:    "work-dir",
+					Value:   "./tmp",
+					Usage:   "Working directory for staging the bundle",
+					EnvVars: []string{"WORK_DIR"},
 				},
-
 				&cli.StringFlag{
 					Name:    "nydus-image",
 					Value:   "nydus-image",
@@ -925,90 +4227,54 @@ func main() {
 					EnvVars: []string{"NYDUS_IMAGE"},
 				},
 			},
-			Before: func(ctx *cli.Context) error {
-				sourcePath := ctx.String("source-dir")
-				fi, err := os.Stat(sourcePath)
-				if err != nil {
-					return errors.Wrapf(err, "failed to check source directory")
-				}
-				if !fi.IsDir() {
-					return errors.Errorf("source path '%s' is not a directory", sourcePath)
-				}
-				return nil
-			},
 			Action: func(c *cli.Context) error {
 				setupLogLevel(c)
-
-				var (
-					p             *packer.Packer
-					res           packer.PackResult
-					backendConfig packer.BackendConfig
-					err           error
-				)
-
-				// if backend-push is specified, we should make sure backend-config-file exists
-				if c.Bool("backend-push") || c.Bool("compact") {
-					_backendType, _backendConfig, err := getBackendConfig(c, "", true)
-					if err != nil {
-						return err
-					}
-					// we can verify the _backendType in the `packer.ParseBackendConfigString` function
-					cfg, err := packer.ParseBackendConfigString(_backendType, _backendConfig)
-					if err != nil {
-						return errors.Errorf("failed to parse backend-config '%s', err = %v", _backendConfig, err)
-					}
-					backendConfig = cfg
-				}
-
-				if p, err = packer.New(packer.Opt{
-					LogLevel:       logrus.GetLevel(),
-					NydusImagePath: c.String("nydus-image"),
-					OutputDir:      c.String("output-dir"),
-					BackendConfig:  backendConfig,
-				}); err != nil {
+				if err := resolveShortNames(c); err != nil {
 					return err
 				}
 
-				if res, err = p.Pack(context.Background(), packer.PackRequest{
-					SourceDir:    c.String("source-dir"),
-					ImageName:    c.String("name"),
-					PushToRemote: c.Bool("backend-push"),
-					FsVersion:    c.String("fs-version"),
-					Compressor:   c.String("compressor"),
-					ChunkSize:    c.String("chunk-size"),
-
-					ChunkDict:         c.String("chunk-dict"),
-					Parent:            c.String("parent-bootstrap"),
-					TryCompact:        c.Bool("compact"),
-					CompactConfigPath: c.String("compact-config-file"),
-				}); err != nil {
+				backendType, backendConfig, err := getBackendConfig(c, "", false)
+				if err != nil {
 					return err
 				}
-				logrus.Infof("successfully built Nydus image (bootstrap:'%s', blob:'%s')", res.Meta, res.Blob)
-				return nil
+				var sign *bundle.Signing
+				if c.String("sign-key") != "" {
+					sign = &bundle.Signing{CosignPath: c.String("cosign"), Key: c.String("sign-key")}
+				} else {
+					logrus.Warnf("the bundle is not signed, specify --sign-key to sign it")
+				}
+
+				_, err = bundle.Bundle(context.Background(), bundle.Opt{
+					WorkDir:         c.String("work-dir"),
+					NydusImagePath:  c.String("nydus-image"),
+					Source:          c.String("source"),
+					SourceInsecure:  c.Bool("source-insecure"),
+					SourcePlainHTTP: c.Bool("source-plain-http"),
+					BackendType:     backendType,
+					BackendConfig:   backendConfig,
+					PrefetchFiles:   c.StringSlice("prefetch-file"),
+					Output:          c.String("output"),
+					Sign:            sign,
+				})
+				return err
 			},
 		},
 		{
-			Name:  "copy",
-			Usage: "Copy an image from source to target",
+			Name:  "unbundle",
+			Usage: "Verify a bundle archive and publish its images, blobs and prefetch files inside an isolated network",
 			Flags: []cli.Flag{
-				&cli.StringFlag{
-					Name:     "source",
-					Required: true,
-					Usage:    "Source image reference",
-					EnvVars:  []string{"SOURCE"},
+				&cli.PathFlag{
+					Name:      "input",
+					Required:  true,
+					TakesFile: true,
+					Usage:     "Path of the bundle archive",
+					EnvVars:   []string{"INPUT"},
 				},
 				&cli.StringFlag{
-					Name:     "target",
-					Required: false,
-					Usage:    "Target image reference",
-					EnvVars:  []string{"TARGET"},
-				},
-				&cli.BoolFlag{
-					Name:     "source-insecure",
-					Required: false,
-					Usage:    "Skip verifying server certs for HTTPS source registry",
-					EnvVars:  []string{"SOURCE_INSECURE"},
+					Name:     "target-registry",
+					Required: true,
+					Usage:    "Registry host with optional namespace to publish the images to, e.g. 'registry.local:5000/mirror', the images keep their repository paths and tags under it",
+					EnvVars:  []string{"TARGET_REGISTRY"},
 				},
 				&cli.BoolFlag{
 					Name:     "target-insecure",
@@ -1016,92 +4282,148 @@ func main() {
 					Usage:    "Skip verifying server certs for HTTPS target registry",
 					EnvVars:  []string{"TARGET_INSECURE"},
 				},
-
+				&cli.BoolFlag{
+					Name:     "target-plain-http",
+					Required: false,
+					Usage:    "Access target registry by plain HTTP instead of HTTPS",
+					EnvVars:  []string{"TARGET_PLAIN_HTTP"},
+				},
 				&cli.StringFlag{
-					Name:    "source-backend-type",
+					Name:    "backend-type",
 					Value:   "",
-					Usage:   "Type of storage backend, possible values: 'oss', 's3'",
+					Usage:   "Type of storage backend to upload the bundled blobs to, possible values: 'oss', 's3', 'localfs', 'azblob'",
 					EnvVars: []string{"BACKEND_TYPE"},
 				},
 				&cli.StringFlag{
-					Name:    "source-backend-config",
+					Name:    "backend-config",
 					Value:   "",
 					Usage:   "Json configuration string for storage backend",
 					EnvVars: []string{"BACKEND_CONFIG"},
 				},
 				&cli.PathFlag{
-					Name:      "source-backend-config-file",
+					Name:      "backend-config-file",
 					Value:     "",
 					TakesFile: true,
 					Usage:     "Json configuration file for storage backend",
 					EnvVars:   []string{"BACKEND_CONFIG_FILE"},
 				},
-
 				&cli.BoolFlag{
-					Name:  "all-platforms",
-					Value: false,
-					Usage: "Copy images for all platforms, conflicts with --platform",
+					Name:    "backend-force-push",
+					Value:   false,
+					Usage:   "Upload the blobs even if they already exist in storage backend",
+					EnvVars: []string{"BACKEND_FORCE_PUSH"},
+				},
+				&cli.PathFlag{
+					Name:    "prefetch-dir",
+					Value:   "./prefetch",
+					Usage:   "Directory to write the bundled prefetch files into",
+					EnvVars: []string{"PREFETCH_DIR"},
 				},
 				&cli.StringFlag{
-					Name:  "platform",
-					Value: "linux/" + runtime.GOARCH,
-					Usage: "Copy images for specific platforms, for example: 'linux/amd64,linux/arm64'",
+					Name:    "verify-key",
+					Value:   "",
+					Usage:   "Path or KMS URI of the cosign public key to verify the bundle signature before publishing anything",
+					EnvVars: []string{"VERIFY_KEY"},
+				},
+				&cli.BoolFlag{
+					Name:    "insecure-skip-verify",
+					Value:   false,
+					Usage:   "Publish the bundle without verifying its signature, the files are still verified by the digests in bundle index",
+					EnvVars: []string{"INSECURE_SKIP_VERIFY"},
 				},
-
 				&cli.StringFlag{
-					Name:  "push-chunk-size",
-					Value: "0MB",
-					Usage: "Chunk size for pushing a blob layer in chunked",
+					Name:    "cosign",
+					Value:   "cosign",
+					Usage:   "Path to the cosign binary, default to search in PATH",
+					EnvVars: []string{"COSIGN"},
 				},
-
 				&cli.StringFlag{
 					Name:    "work-dir",
 					Value:   "./tmp",
-					Usage:   "Working directory for image copy",
+					Usage:   "Working directory for extracting the bundle",
 					EnvVars: []string{"WORK_DIR"},
 				},
-				&cli.StringFlag{
-					Name:    "nydus-image",
-					Value:   "nydus-image",
-					Usage:   "Path to the nydus-image binary, default to search in PATH",
-					EnvVars: []string{"NYDUS_IMAGE"},
-				},
 			},
 			Action: func(c *cli.Context) error {
 				setupLogLevel(c)
 
-				sourceBackendType, sourceBackendConfig, err := getBackendConfig(c, "source-", false)
+				backendType, backendConfig, err := getBackendConfig(c, "", false)
 				if err != nil {
 					return err
 				}
+				var verify *bundle.Verification
+				if c.String("verify-key") != "" {
+					if c.Bool("insecure-skip-verify") {
+						return configError(i18n.Errorf("--verify-key conflicts with --insecure-skip-verify"))
+					}
+					verify = &bundle.Verification{CosignPath: c.String("cosign"), Key: c.String("verify-key")}
+				} else if !c.Bool("insecure-skip-verify") {
+					return configError(i18n.Errorf("--verify-key is required to verify the bundle signature, or specify --insecure-skip-verify to skip it"))
+				}
 
-				pushChunkSize, err := humanize.ParseBytes(c.String("push-chunk-size"))
+				_, err = bundle.Unbundle(context.Background(), bundle.UnbundleOpt{
+					WorkDir:          c.String("work-dir"),
+					Input:            c.String("input"),
+					TargetRegistry:   c.String("target-registry"),
+					TargetInsecure:   c.Bool("target-insecure"),
+					TargetPlainHTTP:  c.Bool("target-plain-http"),
+					BackendType:      backendType,
+					BackendConfig:    backendConfig,
+					BackendForcePush: c.Bool("backend-force-push"),
+					PrefetchDir:      c.String("prefetch-dir"),
+					Verify:           verify,
+				})
+				return err
+			},
+		},
+		{
+			Name:  "retag",
+			Usage: "Promote a converted image to a new tag without re-conversion, the blobs are mounted across repositories if needed",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "source",
+					Required: true,
+					Usage:    "Source image reference",
+					EnvVars:  []string{"SOURCE"},
+				},
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "Target image reference, can be in another repository or registry",
+					EnvVars:  []string{"TARGET"},
+				},
+				&cli.BoolFlag{
+					Name:     "source-insecure",
+					Required: false,
+					Usage:    "Skip verifying server certs for HTTPS source registry",
+					EnvVars:  []string{"SOURCE_INSECURE"},
+				},
+				&cli.BoolFlag{
+					Name:     "target-insecure",
+					Required: false,
+					Usage:    "Skip verifying server certs for HTTPS target registry",
+					EnvVars:  []string{"TARGET_INSECURE"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+
+				source, err := getRegistryReference(c, "source")
 				if err != nil {
-					return errors.Wrap(err, "invalid --push-chunk-size option")
+					return err
 				}
-				if pushChunkSize > 0 {
-					logrus.Infof("will copy layer with chunk size %s", c.String("push-chunk-size"))
+				target, err := getRegistryReference(c, "target")
+				if err != nil {
+					return err
 				}
 
-				opt := copier.Opt{
-					WorkDir:        c.String("work-dir"),
-					NydusImagePath: c.String("nydus-image"),
-
-					Source:         c.String("source"),
-					Target:         c.String("target"),
+				_, err = retagger.Retag(c.Context, retagger.Opt{
+					Source:         source,
 					SourceInsecure: c.Bool("source-insecure"),
+					Target:         target,
 					TargetInsecure: c.Bool("target-insecure"),
-
-					SourceBackendType:   sourceBackendType,
-					SourceBackendConfig: sourceBackendConfig,
-
-					AllPlatforms: c.Bool("all-platforms"),
-					Platforms:    c.String("platform"),
-
-					PushChunkSize: int64(pushChunkSize),
-				}
-
-				return copier.Copy(context.Background(), opt)
+				}, provider.DefaultRemote)
+				return err
 			},
 		},
 		{
@@ -1165,6 +4487,12 @@ func main() {
 					Usage:    "The external directory (for example mountpoint) in container that need to be committed",
 					EnvVars:  []string{"WITH_PATH"},
 				},
+				&cli.StringFlag{
+					Name:    "digest-algorithm",
+					Value:   "sha256",
+					Usage:   "Digest algorithm for the generated manifest, config and bootstrap layer, possible values: sha256, sha384, sha512",
+					EnvVars: []string{"DIGEST_ALGORITHM"},
+				},
 			},
 			Action: func(c *cli.Context) error {
 				setupLogLevel(c)
@@ -1187,15 +4515,24 @@ func main() {
 				}
 
 				withPaths, withoutPaths := parsePaths(c.StringSlice("with-path"))
+				digestAlgorithm, err := utils.ParseDigestAlgorithm(c.String("digest-algorithm"))
+				if err != nil {
+					return configError(i18n.Wrap(err, "parse digest algorithm"))
+				}
+				target, err := getRegistryReference(c, "target")
+				if err != nil {
+					return err
+				}
 				opt := committer.Opt{
 					WorkDir:           c.String("work-dir"),
 					NydusImagePath:    c.String("nydus-image"),
 					ContainerdAddress: c.String("containerd-address"),
 					ContainerID:       c.String("container"),
-					TargetRef:         c.String("target"),
+					TargetRef:         target,
 					SourceInsecure:    c.Bool("source-insecure"),
 					TargetInsecure:    c.Bool("target-insecure"),
 					MaximumTimes:      c.Int("maximum-times"),
+					DigestAlgorithm:   digestAlgorithm,
 					WithPaths:         withPaths,
 					WithoutPaths:      withoutPaths,
 				}
@@ -1206,15 +4543,129 @@ func main() {
 				return cm.Commit(c.Context, opt)
 			},
 		},
+		{
+			Name:  "self-update",
+			Usage: "Update nydusify to the latest release of release channel, the release is verified by its checksum and signature",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "channel-url",
+					Value:   updater.DefaultChannelURL,
+					Usage:   "URL of the latest release in the JSON format of GitHub release API, or its mirror",
+					EnvVars: []string{"CHANNEL_URL"},
+				},
+				&cli.BoolFlag{
+					Name:    "check",
+					Value:   false,
+					Usage:   "Only check whether a new version is available",
+					EnvVars: []string{"CHECK"},
+				},
+				&cli.BoolFlag{
+					Name:    "force",
+					Value:   false,
+					Usage:   "Reinstall even if the current version is the latest one",
+					EnvVars: []string{"FORCE"},
+				},
+				&cli.StringFlag{
+					Name:    "verify-key",
+					Value:   "",
+					Usage:   "Path or KMS URI of the cosign public key to verify the signature of release tarball",
+					EnvVars: []string{"VERIFY_KEY"},
+				},
+				&cli.BoolFlag{
+					Name:    "insecure-skip-verify",
+					Value:   false,
+					Usage:   "Update without verifying the release signature, the release tarball is still verified by its checksum",
+					EnvVars: []string{"INSECURE_SKIP_VERIFY"},
+				},
+				&cli.StringFlag{
+					Name:    "cosign",
+					Value:   "cosign",
+					Usage:   "Path to the cosign binary, default to search in PATH",
+					EnvVars: []string{"COSIGN"},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				setupLogLevel(c)
+
+				if c.String("verify-key") != "" && c.Bool("insecure-skip-verify") {
+					return configError(i18n.Errorf("--verify-key conflicts with --insecure-skip-verify"))
+				}
+				if c.String("verify-key") == "" && !c.Bool("insecure-skip-verify") && !c.Bool("check") {
+					return configError(i18n.Errorf("--verify-key is required to verify the release signature, or specify --insecure-skip-verify to skip it"))
+				}
+
+				result, err := updater.Update(context.Background(), updater.Opt{
+					ChannelURL:         c.String("channel-url"),
+					CurrentVersion:     gitVersion,
+					CosignPath:         c.String("cosign"),
+					VerifyKey:          c.String("verify-key"),
+					InsecureSkipVerify: c.Bool("insecure-skip-verify") || c.Bool("check"),
+					Force:              c.Bool("force"),
+					CheckOnly:          c.Bool("check"),
+				})
+				if err != nil {
+					return err
+				}
+				switch {
+				case result.Updated:
+					fmt.Printf("Updated from %s to %s\n", result.Current, result.Latest)
+				case result.Available:
+					fmt.Printf("New version %s is available, current version is %s\n", result.Latest, result.Current)
+				default:
+					fmt.Printf("Current version %s is the latest\n", result.Current)
+				}
+				return nil
+			},
+		},
+		{
+			Name:   sandbox.Command,
+			Usage:  "Run command in builder sandbox, used internally by the --sandbox option",
+			Hidden: true,
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  "writable",
+					Usage: "Path to keep writable in sandbox",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return sandbox.Exec(c.StringSlice("writable"), c.Args().Slice())
+			},
+		},
+		{
+			Name:   converter.KeepCommand,
+			Usage:  "Run builder and retain its intermediate artifacts, used internally by the --keep-* options of convert",
+			Hidden: true,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "dir",
+					Usage: "Directory to retain the artifacts",
+				},
+				&cli.StringSliceFlag{
+					Name:  "root",
+					Usage: "Directory writable by builder to retain the artifacts under",
+				},
+				&cli.StringSliceFlag{
+					Name:  "stage",
+					Usage: "Stage of artifacts to retain",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				// Exit with the code of builder as the wrapper of it.
+				err := converter.KeepExec(c.StringSlice("root"), c.String("dir"), c.StringSlice("stage"), c.Args().Slice())
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+					return utils.WithExitCode(err, exitErr.ExitCode())
+				}
+				return err
+			},
+		},
 	}
 
 	if !utils.IsSupportedArch(runtime.GOARCH) {
 		logrus.Fatal("Nydusify can only work under architecture 'amd64' and 'arm64'")
 	}
 
-	if err := app.Run(os.Args); err != nil {
-		logrus.Fatal(err)
-	}
+	os.Exit(run(app, os.Args))
 }
 
 func setupLogLevel(c *cli.Context) {
@@ -8,11 +8,25 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"github.com/urfave/cli/v2"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/copier"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/i18n"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/shortname"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
 )
 
 func TestIsPossibleValue(t *testing.T) {
@@ -234,6 +248,100 @@ func TestGetTargetReference(t *testing.T) {
 	require.Equal(t, "testTarget", target)
 }
 
+func TestResolveShortNames(t *testing.T) {
+	app := &cli.App{}
+	flagSet := flag.NewFlagSet("test", flag.PanicOnError)
+	flagSet.String("source", "docker://ubuntu:22.04", "")
+	flagSet.String("target", "ubuntu:22.04-nydus", "")
+	ctx := cli.NewContext(app, flagSet, nil)
+
+	// The references are kept without short name config.
+	require.NoError(t, resolveShortNames(ctx))
+	require.Equal(t, "docker://ubuntu:22.04", ctx.String("source"))
+
+	shortNames = &shortname.Config{UnqualifiedSearchRegistries: []string{"registry.example.com"}}
+	defer func() {
+		shortNames = nil
+	}()
+	require.NoError(t, resolveShortNames(ctx))
+	require.Equal(t, "docker://registry.example.com/ubuntu:22.04", ctx.String("source"))
+	require.Equal(t, "registry.example.com/ubuntu:22.04-nydus", ctx.String("target"))
+
+	// The local transports are kept.
+	flagSet = flag.NewFlagSet("test", flag.PanicOnError)
+	flagSet.String("source", "oci-archive:/tmp/ubuntu.tar:ubuntu:22.04", "")
+	ctx = cli.NewContext(app, flagSet, nil)
+	require.NoError(t, resolveShortNames(ctx))
+	require.Equal(t, "oci-archive:/tmp/ubuntu.tar:ubuntu:22.04", ctx.String("source"))
+
+	// The source is looked up in the search registries in order.
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	_, err := registry.PutManifest("ubuntu", "22.04", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+	})
+	require.NoError(t, err)
+	shortNames = &shortname.Config{UnqualifiedSearchRegistries: []string{"localhost:1", registry.Host()}}
+	flagSet = flag.NewFlagSet("test", flag.PanicOnError)
+	flagSet.String("source", "ubuntu:22.04", "")
+	ctx = cli.NewContext(app, flagSet, nil)
+	require.NoError(t, resolveShortNames(ctx))
+	require.Equal(t, registry.Host()+"/ubuntu:22.04", ctx.String("source"))
+	require.NoError(t, ctx.Set("source", "ubuntu:24.04"))
+	require.ErrorContains(t, resolveShortNames(ctx), registry.Host()+"/ubuntu:24.04: not found")
+}
+
+func TestGetCopyImages(t *testing.T) {
+	app := &cli.App{}
+
+	flagSet := flag.NewFlagSet("test1", flag.PanicOnError)
+	flagSet.String("source", "localhost:5000/nginx:latest", "")
+	ctx := cli.NewContext(app, flagSet, nil)
+	images, err := getCopyImages(ctx)
+	require.NoError(t, err)
+	require.Nil(t, images)
+
+	flagSet = flag.NewFlagSet("test2", flag.PanicOnError)
+	flagSet.Var(cli.NewStringSlice("localhost:5000/nginx:latest"), "sources", "")
+	flagSet.String("target", "localhost:5000/nginx:nydus", "")
+	ctx = cli.NewContext(app, flagSet, nil)
+	_, err = getCopyImages(ctx)
+	require.ErrorContains(t, err, "conflict with --source and --target")
+
+	flagSet = flag.NewFlagSet("test3", flag.PanicOnError)
+	flagSet.Var(cli.NewStringSlice("localhost:5000/nginx:latest"), "sources", "")
+	ctx = cli.NewContext(app, flagSet, nil)
+	_, err = getCopyImages(ctx)
+	require.ErrorContains(t, err, "--target-template is required")
+
+	sourceFile := filepath.Join(t.TempDir(), "images.txt")
+	require.NoError(t, os.WriteFile(sourceFile, []byte(`# images to promote
+localhost:5000/redis:7 registry.example.com/redis:7
+
+docker://localhost:5000/busybox:latest
+`), 0644))
+	flagSet = flag.NewFlagSet("test4", flag.PanicOnError)
+	flagSet.Var(cli.NewStringSlice("localhost:5000/nginx:latest"), "sources", "")
+	flagSet.String("source-file", sourceFile, "")
+	flagSet.String("target-template", "{{repo}}/{{name}}:{{tag}}-nydus", "")
+	ctx = cli.NewContext(app, flagSet, nil)
+	images, err = getCopyImages(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []copier.Image{
+		{Source: "localhost:5000/nginx:latest", Target: "localhost:5000/nginx:latest-nydus"},
+		{Source: "localhost:5000/redis:7", Target: "registry.example.com/redis:7"},
+		{Source: "docker://localhost:5000/busybox:latest", Target: "localhost:5000/busybox:latest-nydus"},
+	}, images)
+
+	require.NoError(t, os.WriteFile(sourceFile, []byte("a b c\n"), 0644))
+	flagSet = flag.NewFlagSet("test5", flag.PanicOnError)
+	flagSet.String("source-file", sourceFile, "")
+	ctx = cli.NewContext(app, flagSet, nil)
+	_, err = getCopyImages(ctx)
+	require.ErrorContains(t, err, "invalid line 1")
+}
+
 func TestGetCacheReferencet(t *testing.T) {
 	app := &cli.App{
 		Flags: []cli.Flag{
@@ -319,4 +427,142 @@ func TestGetPrefetchPatterns(t *testing.T) {
 	patterns, err = getPrefetchPatterns(ctx)
 	require.NoError(t, err)
 	require.Equal(t, "/", patterns)
+
+	flagSet = flag.NewFlagSet("test4", flag.PanicOnError)
+	flagSet.Bool("prefetch-heuristic", true, "")
+	ctx = cli.NewContext(app, flagSet, nil)
+	patterns, err = getPrefetchPatterns(ctx)
+	require.NoError(t, err)
+	require.Empty(t, patterns)
+}
+
+func TestRenderTargetTemplate(t *testing.T) {
+	target, err := renderTargetTemplate("nginx:1.25", "{{repo}}/{{name}}:{{tag}}-nydus")
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/library/nginx:1.25-nydus", target)
+
+	target, err = renderTargetTemplate("localhost:5000/team/app/web", "myregistry/{{ namespace }}/{{name}}:{{tag}}")
+	require.NoError(t, err)
+	require.Equal(t, "myregistry/team/app/web:latest", target)
+
+	target, err = renderTargetTemplate("localhost:5000/web:v1", "{{registry}}/nydus/{{name}}:{{tag}}")
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nydus/web:v1", target)
+
+	_, err = renderTargetTemplate("localhost:5000/web:v1", "{{registry}}/{{namespace}}/{{name}}")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is empty")
+
+	_, err = renderTargetTemplate("nginx", "{{repo}}/{{image}}")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown variable {{image}}")
+
+	_, err = renderTargetTemplate("nginx", "{{repo}}/{{name}}:Invalid Tag")
+	require.Error(t, err)
+}
+
+func TestParseTenantQuotas(t *testing.T) {
+	quotas, err := parseTenantQuotas([]string{"prod=4", "batch=1"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"prod": 4, "batch": 1}, quotas)
+
+	for _, value := range []string{"prod", "=1", "prod=-1", "prod=many"} {
+		_, err := parseTenantQuotas([]string{value})
+		require.Error(t, err)
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	newApp := func(actionErr error) *cli.App {
+		return &cli.App{
+			Name: "nydusify",
+			Commands: []*cli.Command{{
+				Name: "parent",
+				Subcommands: []*cli.Command{{
+					Name:  "child",
+					Flags: []cli.Flag{&cli.StringFlag{Name: "target", Required: true}},
+					Action: func(c *cli.Context) error {
+						return actionErr
+					},
+				}},
+			}},
+		}
+	}
+
+	require.Equal(t, utils.ExitSuccess, run(newApp(nil), []string{"nydusify", "parent", "child", "--target", "t"}))
+	// The usage errors are config errors.
+	require.Equal(t, utils.ExitConfig, run(newApp(nil), []string{"nydusify", "parent", "child"}))
+	require.Equal(t, utils.ExitConfig, run(newApp(nil), []string{"nydusify", "parent", "child", "--unknown"}))
+	// The errors of actions are classified.
+	require.Equal(t, utils.ExitFailure, run(newApp(errors.New("failed")), []string{"nydusify", "parent", "child", "--target", "t"}))
+	require.Equal(t, utils.ExitPush, run(newApp(errors.Wrap(utils.WithExitCode(errors.New("push"), utils.ExitPush), "convert")), []string{"nydusify", "parent", "child", "--target", "t"}))
+}
+
+func TestWrapBuilderPriority(t *testing.T) {
+	defer func() {
+		builderPriority = utils.PriorityOpt{}
+	}()
+	var nydusImage string
+	newApp := func(name string) *cli.App {
+		return &cli.App{
+			Name: "nydusify",
+			Commands: []*cli.Command{{
+				Name:  name,
+				Flags: []cli.Flag{&cli.StringFlag{Name: "nydus-image", Value: "sh"}},
+				Action: func(c *cli.Context) error {
+					nydusImage = c.String("nydus-image")
+					return nil
+				},
+			}},
+		}
+	}
+
+	require.Equal(t, utils.ExitSuccess, run(newApp("check"), []string{"nydusify", "check"}))
+	require.Equal(t, "sh", nydusImage)
+	// The priority wrapper replaces nydus-image, except for the commands
+	// applying it in converter.
+	builderPriority = utils.PriorityOpt{Cgroup: filepath.Join(t.TempDir(), "not-exist")}
+	require.Equal(t, utils.ExitConfig, run(newApp("check"), []string{"nydusify", "check"}))
+	require.Equal(t, utils.ExitSuccess, run(newApp("convert"), []string{"nydusify", "convert"}))
+	require.Equal(t, "sh", nydusImage)
+}
+
+// TestTranslatedMessages checks the messages of command line are all
+// translated in the catalogs.
+func TestTranslatedMessages(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "nydusify.go", nil, 0)
+	require.NoError(t, err)
+
+	messages := []string{}
+	for _, failure := range exitFailures {
+		messages = append(messages, failure)
+	}
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		selector, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		if pkg, ok := selector.X.(*ast.Ident); !ok || pkg.Name != "i18n" {
+			return true
+		}
+		idx := 0
+		if selector.Sel.Name == "Wrap" || selector.Sel.Name == "Wrapf" {
+			idx = 1
+		}
+		if lit, ok := call.Args[idx].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			message, err := strconv.Unquote(lit.Value)
+			require.NoError(t, err)
+			messages = append(messages, message)
+		}
+		return true
+	})
+
+	require.Greater(t, len(messages), 50)
+	for _, message := range messages {
+		require.True(t, i18n.Translated("zh-CN", message), message)
+	}
 }
@@ -30,6 +30,7 @@ require (
 	github.com/urfave/cli/v2 v2.27.1
 	golang.org/x/sync v0.6.0
 	golang.org/x/sys v0.18.0
+	golang.org/x/term v0.18.0
 	lukechampine.com/blake3 v1.2.1
 )
 
@@ -113,7 +114,6 @@ require (
 	go.opentelemetry.io/otel/trace v1.21.0 // indirect
 	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/net v0.23.0 // indirect
-	golang.org/x/term v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 	google.golang.org/genproto v0.0.0-20240123012728-ef4313101c80 // indirect
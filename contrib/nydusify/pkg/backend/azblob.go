@@ -0,0 +1,456 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const (
+	azblobAPIVersion  = "2021-08-06"
+	azblobConcurrency = 5
+)
+
+// The blobs larger than one block are uploaded by blocks in parallel, and
+// committed by a block list.
+var azblobBlockSize int64 = 100 * 1024 * 1024
+
+type AzblobConfig struct {
+	AccountName string `json:"account_name,omitempty"`
+	// AccountKey authorizes requests with shared key, or SASToken is
+	// appended to the requests, anonymous access is used if neither is
+	// specified.
+	AccountKey string `json:"account_key,omitempty"`
+	SASToken   string `json:"sas_token,omitempty"`
+	Container  string `json:"container,omitempty"`
+	// Endpoint defaults to `https://<account_name>.blob.core.windows.net`,
+	// for Azurite it's like `http://127.0.0.1:10000/devstoreaccount1`.
+	Endpoint     string `json:"endpoint,omitempty"`
+	ObjectPrefix string `json:"object_prefix,omitempty"`
+	// SkipVerify skips verifying server certs for HTTPS endpoint.
+	SkipVerify bool `json:"skip_verify,omitempty"`
+	// StorageClass is the access tier of uploaded blobs, e.g. `Cool`, and
+	// Tagging is the blob index tags in URL query form.
+	StorageClass string `json:"storage_class,omitempty"`
+	Tagging      string `json:"tagging,omitempty"`
+}
+
+// AzblobBackend uploads blobs to Azure Blob Storage as block blobs through
+// the REST API.
+type AzblobBackend struct {
+	objectPrefix string
+	accountName  string
+	accountKey   []byte
+	sasToken     url.Values
+	containerURL string
+	lifecycle    Lifecycle
+	client       *http.Client
+}
+
+func newAzblobBackend(rawConfig []byte) (*AzblobBackend, error) {
+	cfg := &AzblobConfig{}
+	if err := json.Unmarshal(rawConfig, cfg); err != nil {
+		return nil, errors.Wrap(err, "parse Azure Blob storage backend configuration")
+	}
+	if cfg.AccountName == "" || cfg.Container == "" {
+		return nil, fmt.Errorf("invalid Azure Blob configuration: missing 'account_name' or 'container'")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", cfg.AccountName)
+	}
+	if _, err := url.Parse(cfg.Endpoint); err != nil {
+		return nil, errors.Wrap(err, "invalid Azure Blob configuration: invalid 'endpoint'")
+	}
+
+	backend := &AzblobBackend{
+		objectPrefix: cfg.ObjectPrefix,
+		accountName:  cfg.AccountName,
+		containerURL: strings.TrimSuffix(cfg.Endpoint, "/") + "/" + url.PathEscape(cfg.Container),
+		client:       &http.Client{},
+	}
+	if cfg.AccountKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.AccountKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid Azure Blob configuration: 'account_key' should be base64 encoded")
+		}
+		backend.accountKey = key
+	} else if cfg.SASToken != "" {
+		token, err := url.ParseQuery(strings.TrimPrefix(cfg.SASToken, "?"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid Azure Blob configuration: invalid 'sas_token'")
+		}
+		backend.sasToken = token
+	}
+	lifecycle, err := parseLifecycle(cfg.StorageClass, cfg.Tagging)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid Azure Blob configuration")
+	}
+	backend.lifecycle = lifecycle
+	var transport http.RoundTripper = http.DefaultTransport
+	if cfg.SkipVerify {
+		transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: utils.NewTLSConfig(true),
+		}
+	}
+	backend.client.Transport = utils.WrapTransport(transport)
+
+	return backend, nil
+}
+
+func (b *AzblobBackend) blobObjectKey(blobID string) string {
+	return b.objectPrefix + blobID
+}
+
+func (b *AzblobBackend) remoteID(blobObjectKey string) string {
+	return b.containerURL + "/" + blobObjectKey
+}
+
+// sign authorizes the request with shared key, see
+// https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key.
+func (b *AzblobBackend) sign(req *http.Request) {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = fmt.Sprint(req.ContentLength)
+	}
+	lines := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead.
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+
+	msHeaders := []string{}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(msHeaders)
+	lines = append(lines, msHeaders...)
+
+	resource := "/" + b.accountName + req.URL.EscapedPath()
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	lines = append(lines, resource)
+
+	mac := hmac.New(sha256.New, b.accountKey)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", b.accountName, signature))
+}
+
+// do sends the request of blob with the query and headers, the body is
+// read from the section so that it's replayed by retries without being
+// buffered in memory. The response body should be closed by caller if no
+// error is returned.
+func (b *AzblobBackend) do(ctx context.Context, method, blobObjectKey string, query url.Values, header http.Header, body *io.SectionReader) (*http.Response, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	for name, values := range b.sasToken {
+		query[name] = values
+	}
+	target := b.remoteID(blobObjectKey)
+	if encoded := query.Encode(); encoded != "" {
+		target += "?" + encoded
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+	if body != nil {
+		req.ContentLength = body.Size()
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(body, 0, body.Size())), nil
+		}
+		req.Body, _ = req.GetBody()
+		if req.ContentLength == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azblobAPIVersion)
+	if b.accountKey != nil {
+		b.sign(req)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s %s", method, blobObjectKey)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		code := resp.Header.Get("x-ms-error-code")
+		if code == "" {
+			code = http.StatusText(resp.StatusCode)
+		}
+		return resp, errors.Errorf("%s %s: status %d, %s", method, blobObjectKey, resp.StatusCode, code)
+	}
+	return resp, nil
+}
+
+func (b *AzblobBackend) Upload(ctx context.Context, blobID, blobPath string, size int64, forcePush bool) (*ocispec.Descriptor, error) {
+	blobObjectKey := b.blobObjectKey(blobID)
+
+	desc := blobDesc(size, blobID)
+	desc.URLs = append(desc.URLs, b.remoteID(blobObjectKey))
+
+	if !forcePush {
+		if exist, err := b.existObject(ctx, blobObjectKey); err != nil {
+			return nil, errors.Wrap(err, "check object existence")
+		} else if exist {
+			logrus.Infof("skip upload because blob exists: %s", blobID)
+			return &desc, nil
+		}
+	}
+
+	start := time.Now()
+
+	blobFile, err := os.Open(blobPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open blob file")
+	}
+	defer blobFile.Close()
+
+	header := http.Header{}
+	if b.lifecycle.StorageClass != "" {
+		header.Set("x-ms-access-tier", b.lifecycle.StorageClass)
+	}
+	if len(b.lifecycle.Tags) > 0 {
+		header.Set("x-ms-tags", b.lifecycle.tagging())
+	}
+
+	if size <= azblobBlockSize {
+		section := io.NewSectionReader(blobFile, 0, size)
+		sum, err := contentMD5(section)
+		if err != nil {
+			return nil, errors.Wrap(err, "read blob file")
+		}
+		header.Set("x-ms-blob-type", "BlockBlob")
+		header.Set("Content-MD5", sum)
+		resp, err := b.do(ctx, http.MethodPut, blobObjectKey, nil, header, section)
+		if err != nil {
+			return nil, errors.Wrap(err, "upload blob to azblob backend")
+		}
+		resp.Body.Close()
+	} else if err := b.uploadBlocks(ctx, blobObjectKey, blobFile, size, header); err != nil {
+		return nil, errors.Wrap(err, "upload blob to azblob backend")
+	}
+
+	logrus.Debugf("uploaded blob %s to azblob backend, costs %s", blobObjectKey, time.Since(start))
+
+	return &desc, nil
+}
+
+// contentMD5 returns the base64 encoded MD5 of the section, which is read
+// again to be sent, the file data is likely in page cache then.
+func contentMD5(section *io.SectionReader) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(section, 0, section.Size())); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+func bytesSection(data []byte) *io.SectionReader {
+	return io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data)))
+}
+
+// uploadBlocks uploads the blob by blocks and commits them, the uncommitted
+// blocks of failed upload are garbage collected by Azure in a week.
+func (b *AzblobBackend) uploadBlocks(ctx context.Context, blobObjectKey string, blobFile *os.File, size int64, header http.Header) error {
+	count := int((size + azblobBlockSize - 1) / azblobBlockSize)
+	blockIDs := make([]string, count)
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(azblobConcurrency)
+	for idx := 0; idx < count; idx++ {
+		idx := idx
+		blockIDs[idx] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", idx)))
+		eg.Go(func() error {
+			offset := int64(idx) * azblobBlockSize
+			length := size - offset
+			if length > azblobBlockSize {
+				length = azblobBlockSize
+			}
+			section := io.NewSectionReader(blobFile, offset, length)
+			sum, err := contentMD5(section)
+			if err != nil {
+				return errors.Wrapf(err, "read block %d", idx)
+			}
+			query := url.Values{"comp": {"block"}, "blockid": {blockIDs[idx]}}
+			resp, err := b.do(egCtx, http.MethodPut, blobObjectKey, query, http.Header{"Content-MD5": {sum}}, section)
+			if err != nil {
+				return errors.Wrapf(err, "put block %d", idx)
+			}
+			resp.Body.Close()
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	blockList := struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: blockIDs}
+	data, err := xml.Marshal(blockList)
+	if err != nil {
+		return errors.Wrap(err, "marshal block list")
+	}
+	header.Set("Content-Type", "application/xml")
+	resp, err := b.do(ctx, http.MethodPut, blobObjectKey, url.Values{"comp": {"blocklist"}}, header, bytesSection(append([]byte(xml.Header), data...)))
+	if err != nil {
+		return errors.Wrap(err, "put block list")
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *AzblobBackend) Finalize(_ bool) error {
+	return nil
+}
+
+func (b *AzblobBackend) Check(blobID string) (bool, error) {
+	return b.existObject(context.TODO(), b.blobObjectKey(blobID))
+}
+
+func (b *AzblobBackend) Type() Type {
+	return Azblobbackend
+}
+
+func (b *AzblobBackend) existObject(ctx context.Context, blobObjectKey string) (bool, error) {
+	resp, err := b.do(ctx, http.MethodHead, blobObjectKey, nil, nil, nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+func (b *AzblobBackend) deleteObject(ctx context.Context, blobObjectKey string) error {
+	resp, err := b.do(ctx, http.MethodDelete, blobObjectKey, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *AzblobBackend) Reader(blobID string) (io.ReadCloser, error) {
+	resp, err := b.do(context.TODO(), http.MethodGet, b.blobObjectKey(blobID), nil, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "get blob")
+	}
+	return resp.Body, nil
+}
+
+func (b *AzblobBackend) RangeReader(blobID string, offset, size int64) (io.ReadCloser, error) {
+	header := http.Header{"x-ms-range": {fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)}}
+	resp, err := b.do(context.TODO(), http.MethodGet, b.blobObjectKey(blobID), nil, header, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get blob range [%d, %d)", offset, offset+size)
+	}
+	return resp.Body, nil
+}
+
+func (b *AzblobBackend) Size(blobID string) (int64, error) {
+	resp, err := b.do(context.TODO(), http.MethodHead, b.blobObjectKey(blobID), nil, nil, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "get blob size")
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (b *AzblobBackend) Lifecycle() Lifecycle {
+	return b.lifecycle
+}
+
+func (b *AzblobBackend) ApplyLifecycle(ctx context.Context, blobID string, _ int64) error {
+	blobObjectKey := b.blobObjectKey(blobID)
+	if b.lifecycle.StorageClass != "" {
+		header := http.Header{"x-ms-access-tier": {b.lifecycle.StorageClass}}
+		resp, err := b.do(ctx, http.MethodPut, blobObjectKey, url.Values{"comp": {"tier"}}, header, nil)
+		if err != nil {
+			return errors.Wrapf(err, "change access tier of blob %s", blobID)
+		}
+		resp.Body.Close()
+	}
+	if len(b.lifecycle.Tags) > 0 {
+		type tag struct {
+			Key   string `xml:"Key"`
+			Value string `xml:"Value"`
+		}
+		tags := struct {
+			XMLName xml.Name `xml:"Tags"`
+			Tags    []tag    `xml:"TagSet>Tag"`
+		}{}
+		for _, key := range b.lifecycle.sortedTagKeys() {
+			tags.Tags = append(tags.Tags, tag{Key: key, Value: b.lifecycle.Tags[key]})
+		}
+		data, err := xml.Marshal(tags)
+		if err != nil {
+			return errors.Wrap(err, "marshal tags")
+		}
+		header := http.Header{"Content-Type": {"application/xml"}}
+		resp, err := b.do(ctx, http.MethodPut, blobObjectKey, url.Values{"comp": {"tags"}}, header, bytesSection(append([]byte(xml.Header), data...)))
+		if err != nil {
+			return errors.Wrapf(err, "tag blob %s", blobID)
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
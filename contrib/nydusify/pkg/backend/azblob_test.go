@@ -0,0 +1,166 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestNewAzblobBackend(t *testing.T) {
+	_, err := newAzblobBackend([]byte(`{"account_name": "account"}`))
+	require.ErrorContains(t, err, "missing 'account_name' or 'container'")
+
+	_, err = newAzblobBackend([]byte(`{"account_name": "account", "container": "c", "account_key": "%%"}`))
+	require.ErrorContains(t, err, "should be base64 encoded")
+
+	backend, err := newAzblobBackend([]byte(`{"account_name": "account", "container": "c", "object_prefix": "nydus/"}`))
+	require.NoError(t, err)
+	require.Equal(t, Azblobbackend, backend.Type())
+	require.Equal(t, "https://account.blob.core.windows.net/c/nydus/blob1", RemoteID(backend, "blob1"))
+}
+
+func TestAzblobSign(t *testing.T) {
+	key := []byte("secret")
+	backend := &AzblobBackend{accountName: "account", accountKey: key}
+
+	req, err := http.NewRequest(http.MethodPut, "https://account.blob.core.windows.net/c/blob1?comp=block&blockid=MDA%3D", bytes.NewReader([]byte("data")))
+	require.NoError(t, err)
+	req.Header.Set("Content-MD5", "md5")
+	req.Header.Set("x-ms-version", azblobAPIVersion)
+	req.Header.Set("x-ms-date", "Mon, 02 Jan 2023 15:04:05 GMT")
+	backend.sign(req)
+
+	stringToSign := strings.Join([]string{
+		"PUT", "", "", "4", "md5", "", "", "", "", "", "", "",
+		"x-ms-date:Mon, 02 Jan 2023 15:04:05 GMT",
+		"x-ms-version:" + azblobAPIVersion,
+		"/account/c/blob1\nblockid:MDA=\ncomp:block",
+	}, "\n")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	require.Equal(t, "SharedKey account:"+base64.StdEncoding.EncodeToString(mac.Sum(nil)), req.Header.Get("Authorization"))
+}
+
+func TestAzblobBackendWithFakeStorage(t *testing.T) {
+	storage := testutil.NewObjectStorage()
+	defer storage.Close()
+
+	config, err := json.Marshal(AzblobConfig{
+		AccountName:  "account",
+		AccountKey:   base64.StdEncoding.EncodeToString([]byte("secret")),
+		Container:    "test",
+		Endpoint:     storage.URL(),
+		ObjectPrefix: "blobs/",
+		StorageClass: "Cool",
+		Tagging:      "team=ai",
+	})
+	require.NoError(t, err)
+	backend, err := newAzblobBackend(config)
+	require.NoError(t, err)
+
+	blobPath := filepath.Join(t.TempDir(), "blob")
+	data := []byte("nydus blob data")
+	require.NoError(t, os.WriteFile(blobPath, data, 0644))
+
+	exist, err := backend.Check("blob1")
+	require.NoError(t, err)
+	require.False(t, exist)
+
+	desc, err := backend.Upload(context.Background(), "blob1", blobPath, int64(len(data)), false)
+	require.NoError(t, err)
+	require.Equal(t, []string{storage.URL() + "/test/blobs/blob1"}, desc.URLs)
+	object, ok := storage.Object("test", "blobs/blob1")
+	require.True(t, ok)
+	require.Equal(t, data, object.Data)
+	require.Equal(t, "Cool", object.StorageClass)
+	require.Equal(t, map[string]string{"team": "ai"}, object.Tags)
+
+	exist, err = backend.Check("blob1")
+	require.NoError(t, err)
+	require.True(t, exist)
+	size, err := backend.Size("blob1")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+
+	reader, err := backend.RangeReader("blob1", 6, 4)
+	require.NoError(t, err)
+	ranged, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	require.Equal(t, []byte("blob"), ranged)
+
+	// Large blob is uploaded by blocks.
+	defer func(size int64) { azblobBlockSize = size }(azblobBlockSize)
+	azblobBlockSize = 4
+	_, err = backend.Upload(context.Background(), "blob2", blobPath, int64(len(data)), true)
+	require.NoError(t, err)
+	object, ok = storage.Object("test", "blobs/blob2")
+	require.True(t, ok)
+	require.Equal(t, data, object.Data)
+	require.Equal(t, "Cool", object.StorageClass)
+
+	reader, err = backend.Reader("blob2")
+	require.NoError(t, err)
+	read, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	require.Equal(t, data, read)
+
+	storage.PutObject("test", "blobs/blob3", data)
+	require.NoError(t, backend.ApplyLifecycle(context.Background(), "blob3", int64(len(data))))
+	object, _ = storage.Object("test", "blobs/blob3")
+	require.Equal(t, "Cool", object.StorageClass)
+	require.Equal(t, map[string]string{"team": "ai"}, object.Tags)
+
+	_, err = backend.Size("blob4")
+	require.ErrorContains(t, err, "BlobNotFound")
+}
+
+func TestAzblobUploadReplaysFileSection(t *testing.T) {
+	requests := [][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), r.ContentLength)
+		requests = append(requests, data)
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	backend, err := newAzblobBackend([]byte(`{"account_name": "account", "container": "c", "endpoint": "` + server.URL + `"}`))
+	require.NoError(t, err)
+	backend.client.Transport = &utils.RetryTransport{
+		Transport: http.DefaultTransport,
+		Policy:    utils.RetryPolicy{Retries: 1},
+	}
+
+	// The blob is streamed from file, and sent again on retry.
+	blobPath := filepath.Join(t.TempDir(), "blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("nydus blob data"), 0644))
+	_, err = backend.Upload(context.Background(), "blob1", blobPath, 15, true)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("nydus blob data"), []byte("nydus blob data")}, requests)
+}
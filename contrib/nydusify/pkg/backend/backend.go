@@ -11,7 +11,6 @@ import (
 
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
-	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -19,6 +18,10 @@ import (
 // 1. registry: complying to OCI distribution specification, push blob file
 // to registry and use the registry as a storage.
 // 2. oss: A object storage backend, which uses its SDK to transer blob file.
+// 3. s3: A object storage backend compatible with AWS S3.
+// 4. localfs: A directory in local filesystem, mostly for testing and air-gapped
+// environments.
+// 5. azblob: Azure Blob Storage, the blobs are uploaded as block blobs.
 type Backend interface {
 	// TODO: Hopefully, we can pass `Layer` struct in, thus to be able to cook both
 	// file handle and file path.
@@ -27,6 +30,9 @@ type Backend interface {
 	Check(blobID string) (bool, error)
 	Type() Type
 	Reader(blobID string) (io.ReadCloser, error)
+	// RangeReader reads the size bytes of blob from offset, so that the
+	// chunks can be fetched from backend without downloading the blob.
+	RangeReader(blobID string, offset, size int64) (io.ReadCloser, error)
 	Size(blobID string) (int64, error)
 }
 
@@ -38,10 +44,12 @@ const (
 	OssBackend Type = iota
 	RegistryBackend
 	S3backend
+	LocalFSbackend
+	Azblobbackend
 )
 
 func blobDesc(size int64, blobID string) ocispec.Descriptor {
-	blobDigest := digest.NewDigestFromEncoded(digest.SHA256, blobID)
+	blobDigest := utils.DigestFromEncoded(blobID)
 	desc := ocispec.Descriptor{
 		Digest:    blobDigest,
 		Size:      size,
@@ -72,7 +80,32 @@ func NewBackend(bt string, config []byte, remote *remote.Remote) (Backend, error
 		return newRegistryBackend(config, remote)
 	case "s3":
 		return newS3Backend(config)
+	case "localfs":
+		return newLocalFSBackend(config)
+	case "azblob":
+		return newAzblobBackend(config)
 	default:
 		return nil, fmt.Errorf("unsupported backend type %s", bt)
 	}
 }
+
+// RemoteID returns the URL of blob stored in object storage backend, it's
+// empty for registry backend.
+func RemoteID(backend Backend, blobID string) string {
+	switch b := backend.(type) {
+	case *OSSBackend:
+		return b.remoteID(blobID)
+	case *S3Backend:
+		return b.remoteID(b.blobObjectKey(blobID))
+	case *AzblobBackend:
+		return b.remoteID(b.blobObjectKey(blobID))
+	default:
+		return ""
+	}
+}
+
+// DeleteBlob removes the blob from object storage or localfs backend, the
+// blobs in registry backend are left to the garbage collection of registry.
+func DeleteBlob(ctx context.Context, backend Backend, blobID string) error {
+	return deleteObject(ctx, backend, blobID)
+}
@@ -53,6 +53,10 @@ func TestNewBackend(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, S3backend, backend.Type())
 
+	backend, err = NewBackend("localfs", []byte(`{"dir": "/tmp/blobs"}`), nil)
+	require.NoError(t, err)
+	require.Equal(t, LocalFSbackend, backend.Type())
+
 	testRegistryRemote, err := provider.DefaultRemote("test", false)
 	require.NoError(t, err)
 	backend, err = NewBackend("registry", nil, testRegistryRemote)
@@ -0,0 +1,239 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// BenchmarkOption is the option of Benchmark.
+type BenchmarkOption struct {
+	// BlobID is the existing blob to read, a random test blob of BlobSize
+	// is put and removed afterwards if it's empty, which isn't supported
+	// by registry backend.
+	BlobID   string
+	BlobSize int64
+	// ChunkSize is the size of each ranged read, the offsets are aligned
+	// to it like the chunks read by nydusd.
+	ChunkSize   int64
+	Requests    int
+	Concurrency int
+}
+
+// BenchmarkResult is the result of Benchmark, the latency of a request is
+// the duration from sending it to reading the whole chunk.
+type BenchmarkResult struct {
+	Backend     string        `json:"backend"`
+	Blob        string        `json:"blob"`
+	BlobSize    int64         `json:"blob_size"`
+	ChunkSize   int64         `json:"chunk_size"`
+	Concurrency int           `json:"concurrency"`
+	Requests    int           `json:"requests"`
+	Errors      int           `json:"errors"`
+	Error       string        `json:"error,omitempty"`
+	Duration    time.Duration `json:"duration"`
+	Bytes       int64         `json:"bytes"`
+	// Throughput is the bytes read per second by all concurrent requests.
+	Throughput float64       `json:"throughput"`
+	LatencyMin time.Duration `json:"latency_min"`
+	LatencyP50 time.Duration `json:"latency_p50"`
+	LatencyP90 time.Duration `json:"latency_p90"`
+	LatencyP99 time.Duration `json:"latency_p99"`
+	LatencyMax time.Duration `json:"latency_max"`
+}
+
+// Failed returns true if any request fails.
+func (result *BenchmarkResult) Failed() bool {
+	return result.Errors > 0
+}
+
+// Print prints the latency and throughput of requests.
+func (result *BenchmarkResult) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Backend:\t%s\n", result.Backend)
+	fmt.Fprintf(tw, "Blob:\t%s (%s)\n", result.Blob, humanize.IBytes(uint64(result.BlobSize)))
+	fmt.Fprintf(tw, "Chunk size:\t%s\n", humanize.IBytes(uint64(result.ChunkSize)))
+	fmt.Fprintf(tw, "Concurrency:\t%d\n", result.Concurrency)
+	fmt.Fprintf(tw, "Requests:\t%d (%d failed)\n", result.Requests, result.Errors)
+	if result.Error != "" {
+		fmt.Fprintf(tw, "First error:\t%s\n", result.Error)
+	}
+	fmt.Fprintf(tw, "Duration:\t%s\n", result.Duration.Round(time.Millisecond))
+	fmt.Fprintf(tw, "Throughput:\t%s/s\n", humanize.IBytes(uint64(result.Throughput)))
+	fmt.Fprintln(tw, "\nLATENCY\tMIN\tP50\tP90\tP99\tMAX")
+	fmt.Fprintf(tw, "\t%s\t%s\t%s\t%s\t%s\n",
+		result.LatencyMin.Round(time.Microsecond), result.LatencyP50.Round(time.Microsecond),
+		result.LatencyP90.Round(time.Microsecond), result.LatencyP99.Round(time.Microsecond),
+		result.LatencyMax.Round(time.Microsecond),
+	)
+	return tw.Flush()
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(latencies []time.Duration, p int) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	index := (len(latencies)*p+99)/100 - 1
+	if index < 0 {
+		index = 0
+	}
+	return latencies[index]
+}
+
+// putBenchmarkBlob puts a random test blob of size into backend, and
+// returns its ID and the function removing it.
+func putBenchmarkBlob(ctx context.Context, bkd Backend, size int64) (string, func() error, error) {
+	file, err := os.CreateTemp("", "nydusify-benchmark-")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "create test blob")
+	}
+	defer os.Remove(file.Name())
+	_, err = io.CopyN(file, rand.Reader, size)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", nil, errors.Wrap(err, "write test blob")
+	}
+
+	blobID := ".nydusify-benchmark-" + uuid.NewString()
+	if _, err := bkd.Upload(ctx, blobID, file.Name(), size, true); err != nil {
+		return "", nil, errors.Wrap(err, "put test blob")
+	}
+	return blobID, func() error {
+		return deleteObject(ctx, bkd, blobID)
+	}, nil
+}
+
+// Benchmark measures the latency and throughput of the ranged chunk reads
+// from storage backend on this host, which are the requests sent by nydusd
+// on demand, so that the backends can be compared per region.
+func Benchmark(ctx context.Context, backendType string, bkd Backend, opt BenchmarkOption) (*BenchmarkResult, error) {
+	if opt.ChunkSize <= 0 || opt.Requests <= 0 || opt.Concurrency <= 0 {
+		return nil, fmt.Errorf("invalid benchmark option, chunk size, requests and concurrency should be positive")
+	}
+
+	blobID := opt.BlobID
+	if blobID == "" {
+		if bkd.Type() == RegistryBackend {
+			return nil, errors.New("putting test blob isn't supported by registry backend, specify an existing blob")
+		}
+		if opt.BlobSize <= 0 {
+			return nil, fmt.Errorf("invalid test blob size %d", opt.BlobSize)
+		}
+		var remove func() error
+		var err error
+		if blobID, remove, err = putBenchmarkBlob(ctx, bkd, opt.BlobSize); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := remove(); err != nil {
+				logrus.WithError(err).Warnf("failed to remove test blob %s", blobID)
+			}
+		}()
+	}
+
+	blobSize, err := bkd.Size(blobID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get size of blob %s", blobID)
+	}
+	if blobSize <= 0 {
+		return nil, fmt.Errorf("blob %s is empty", blobID)
+	}
+
+	result := &BenchmarkResult{
+		Backend:     backendType,
+		Blob:        RemoteID(bkd, blobID),
+		BlobSize:    blobSize,
+		ChunkSize:   opt.ChunkSize,
+		Concurrency: opt.Concurrency,
+		Requests:    opt.Requests,
+	}
+	if localFS, ok := bkd.(*LocalFSBackend); ok {
+		result.Blob = localFS.blobPath(blobID)
+	}
+
+	// The random offsets are chosen in advance to not be measured.
+	chunks := (blobSize + opt.ChunkSize - 1) / opt.ChunkSize
+	offsets := make(chan int64, opt.Requests)
+	for i := 0; i < opt.Requests; i++ {
+		offsets <- mrand.Int63n(chunks) * opt.ChunkSize
+	}
+	close(offsets)
+
+	var mutex sync.Mutex
+	latencies := make([]time.Duration, 0, opt.Requests)
+	read := func(offset int64) (int64, error) {
+		reader, err := bkd.RangeReader(blobID, offset, min(opt.ChunkSize, blobSize-offset))
+		if err != nil {
+			return 0, err
+		}
+		defer reader.Close()
+		return io.Copy(io.Discard, reader)
+	}
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < opt.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range offsets {
+				if ctx.Err() != nil {
+					return
+				}
+				requestStart := time.Now()
+				n, err := read(offset)
+				latency := time.Since(requestStart)
+
+				mutex.Lock()
+				if err != nil {
+					result.Errors++
+					if result.Error == "" {
+						result.Error = errors.Wrapf(err, "read blob at offset %d", offset).Error()
+					}
+				} else {
+					result.Bytes += n
+					latencies = append(latencies, latency)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	result.Duration = time.Since(start)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if result.Duration > 0 {
+		result.Throughput = float64(result.Bytes) / result.Duration.Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if len(latencies) > 0 {
+		result.LatencyMin = latencies[0]
+		result.LatencyMax = latencies[len(latencies)-1]
+	}
+	result.LatencyP50 = percentile(latencies, 50)
+	result.LatencyP90 = percentile(latencies, 90)
+	result.LatencyP99 = percentile(latencies, 99)
+
+	return result, nil
+}
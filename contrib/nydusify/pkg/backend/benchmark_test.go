@@ -0,0 +1,112 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBenchmark(t *testing.T) {
+	// The test blob is put and removed.
+	dir := t.TempDir()
+	bkd, err := NewBackend("localfs", []byte(`{"dir": "`+dir+`"}`), nil)
+	require.NoError(t, err)
+	result, err := Benchmark(context.Background(), "localfs", bkd, BenchmarkOption{
+		BlobSize: 10 << 10, ChunkSize: 4 << 10, Requests: 20, Concurrency: 4,
+	})
+	require.NoError(t, err)
+	require.False(t, result.Failed())
+	require.Equal(t, int64(10<<10), result.BlobSize)
+	require.Equal(t, 20, result.Requests)
+	require.True(t, result.Bytes >= 20*(2<<10))
+	require.True(t, result.LatencyMin <= result.LatencyP50)
+	require.True(t, result.LatencyP50 <= result.LatencyP99)
+	require.True(t, result.LatencyP99 <= result.LatencyMax)
+	require.True(t, result.Throughput > 0)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	var buf bytes.Buffer
+	require.NoError(t, result.Print(&buf))
+	require.Contains(t, buf.String(), "Requests:     20 (0 failed)")
+
+	// The missing blob fails.
+	_, err = Benchmark(context.Background(), "localfs", bkd, BenchmarkOption{
+		BlobID: "missing", ChunkSize: 4 << 10, Requests: 1, Concurrency: 1,
+	})
+	require.ErrorContains(t, err, "get size of blob missing")
+
+	// The existing blob is read, and the failed reads are counted.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "blob"), make([]byte, 8<<10), 0644))
+	result, err = Benchmark(context.Background(), "localfs", bkd, BenchmarkOption{
+		BlobID: "blob", ChunkSize: 4 << 10, Requests: 4, Concurrency: 2,
+	})
+	require.NoError(t, err)
+	require.False(t, result.Failed())
+	result, err = Benchmark(context.Background(), "localfs", &failingBackend{bkd}, BenchmarkOption{
+		BlobID: "blob", ChunkSize: 4 << 10, Requests: 4, Concurrency: 2,
+	})
+	require.NoError(t, err)
+	require.True(t, result.Failed())
+	require.Equal(t, 4, result.Errors)
+	require.Contains(t, result.Error, "connection reset")
+}
+
+type failingBackend struct {
+	Backend
+}
+
+func (b *failingBackend) RangeReader(_ string, _, _ int64) (io.ReadCloser, error) {
+	return nil, errors.New("connection reset")
+}
+
+func TestBenchmarkRegistry(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	desc := registry.PutBlob("app", utils.MediaTypeNydusBlob, data)
+
+	remote, err := provider.DefaultRemote(registry.Host()+"/app:latest", false)
+	require.NoError(t, err)
+	bkd, err := NewBackend("registry", nil, remote)
+	require.NoError(t, err)
+
+	// The plain HTTP is used after the failed HTTPS request.
+	size, err := bkd.Size(desc.Digest.Encoded())
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+	reader, err := bkd.RangeReader(desc.Digest.Encoded(), 100, 16)
+	require.NoError(t, err)
+	chunk := make([]byte, 32)
+	n, _ := reader.Read(chunk)
+	require.NoError(t, reader.Close())
+	require.Equal(t, data[100:116], chunk[:n])
+
+	result, err := Benchmark(context.Background(), "registry", bkd, BenchmarkOption{
+		BlobID: desc.Digest.Encoded(), ChunkSize: 4 << 10, Requests: 8, Concurrency: 2,
+	})
+	require.NoError(t, err)
+	require.False(t, result.Failed())
+	require.Equal(t, int64(len(data)), result.BlobSize)
+	require.Equal(t, int64(8*(4<<10)), result.Bytes)
+
+	// The test blob can't be put into registry.
+	_, err = Benchmark(context.Background(), "registry", bkd, BenchmarkOption{
+		BlobSize: 1024, ChunkSize: 4 << 10, Requests: 1, Concurrency: 1,
+	})
+	require.ErrorContains(t, err, "isn't supported by registry backend")
+}
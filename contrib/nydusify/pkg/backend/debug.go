@@ -0,0 +1,260 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// maxClockSkew is the clock skew tolerated by the signatures of object
+// storages, i.e. OSS, S3 and Azure Blob Storage.
+const maxClockSkew = 15 * time.Minute
+
+// debugObjectData is the content of the test object put by Debug.
+var debugObjectData = []byte("nydusify backend debug\n")
+
+// DebugStep is a request sent to storage backend by Debug.
+type DebugStep struct {
+	// Name is `head`, `put`, `head` again to find the object put, or
+	// `delete`.
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	// Cause is the diagnosed cause of error, e.g. clock skew.
+	Cause string `json:"cause,omitempty"`
+}
+
+// DebugResult is the result of Debug.
+type DebugResult struct {
+	Backend string `json:"backend"`
+	// Object is the URL or path of test object.
+	Object string `json:"object"`
+	// ClockSkew is the local clock minus the clock of backend server, it's
+	// zero if the server time isn't known.
+	ClockSkew time.Duration `json:"clock_skew"`
+	Steps     []DebugStep   `json:"steps"`
+}
+
+// Failed returns true if any request fails.
+func (result *DebugResult) Failed() bool {
+	for _, step := range result.Steps {
+		if step.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Print prints the steps and diagnosed causes of failures.
+func (result *DebugResult) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Backend:\t%s\n", result.Backend)
+	fmt.Fprintf(tw, "Object:\t%s\n", result.Object)
+	if result.ClockSkew != 0 {
+		fmt.Fprintf(tw, "Clock skew:\t%s\n", result.ClockSkew.Round(time.Second))
+	}
+	fmt.Fprintln(tw, "\nSTEP\tDURATION\tRESULT")
+	for _, step := range result.Steps {
+		status := "ok"
+		if step.Error != "" {
+			status = "failed: " + step.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", step.Name, step.Duration.Round(time.Millisecond), status)
+	}
+	for _, step := range result.Steps {
+		if step.Cause != "" {
+			fmt.Fprintf(tw, "\n%s failed, %s\n", step.Name, step.Cause)
+		}
+	}
+	return tw.Flush()
+}
+
+// diagnoses maps the error codes of object storages to the causes, the
+// first matched one is used.
+var diagnoses = []struct {
+	patterns []string
+	cause    string
+}{
+	{
+		[]string{"RequestTimeTooSkewed", "RequestExpired", "Signature expired"},
+		"the clock of this host is skewed from the backend server, sync it with NTP",
+	},
+	{
+		[]string{"AuthorizationHeaderMalformed", "PermanentRedirect", "MovedPermanently", "StatusCode: 301", "IllegalLocationConstraintException", "must be addressed using the specified endpoint", "IncorrectEndpoint"},
+		"the bucket is in another region, check `region` or `endpoint` in backend config",
+	},
+	{
+		[]string{"NoSuchBucket", "ContainerNotFound", "ResourceNotFound"},
+		"the bucket or container doesn't exist, check `bucket_name` or `container` in backend config",
+	},
+	{
+		[]string{"InvalidAccessKeyId", "SignatureDoesNotMatch", "AuthenticationFailed", "InvalidAuthenticationInfo", "NoAuthenticationInformation"},
+		"the credentials are invalid, check the access key or account key in backend config",
+	},
+	{
+		[]string{"AccessDenied", "AuthorizationPermissionMismatch", "AuthorizationFailure", "Forbidden", "StatusCode: 403", "status 403"},
+		"the request is denied by bucket policy or the permissions of credentials",
+	},
+	{
+		[]string{"no such host", "connection refused", "i/o timeout", "dial tcp", "certificate"},
+		"the backend endpoint is unreachable, check `endpoint` in backend config and the network",
+	},
+}
+
+// diagnose returns the cause of err returned by backend, the clock skew is
+// blamed on the auth failures if it's beyond tolerance.
+func diagnose(err error, skew time.Duration) string {
+	if err == nil {
+		return ""
+	}
+	message := err.Error()
+	for _, diagnosis := range diagnoses {
+		for _, pattern := range diagnosis.patterns {
+			if !strings.Contains(message, pattern) {
+				continue
+			}
+			if skew > maxClockSkew || skew < -maxClockSkew {
+				return fmt.Sprintf("the clock of this host is skewed by %s from the backend server, sync it with NTP", skew.Round(time.Second))
+			}
+			return diagnosis.cause
+		}
+	}
+	return "unknown error, rerun with `--log-level debug` for details"
+}
+
+// serverClockSkew returns the local clock minus the `Date` header of the
+// unsigned response of url, which is returned even if the request is
+// denied.
+func serverClockSkew(ctx context.Context, url string) time.Duration {
+	if url == "" {
+		return 0
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0
+	}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			// Only the clock of server is concerned.
+			TLSClientConfig: utils.NewTLSConfig(true),
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0
+	}
+	// The `Date` header is in seconds, the skew within seconds isn't
+	// meaningful.
+	skew := time.Since(date)
+	if skew < 2*time.Second && skew > -2*time.Second {
+		return 0
+	}
+	return skew
+}
+
+// deleteObject removes the object of key put by Upload.
+func deleteObject(ctx context.Context, bkd Backend, key string) error {
+	switch b := bkd.(type) {
+	case StreamBackend:
+		return b.AbortStream(ctx, key)
+	case *AzblobBackend:
+		return b.deleteObject(ctx, b.blobObjectKey(key))
+	default:
+		return errors.Errorf("unsupported backend type %s", TypeName(bkd.Type()))
+	}
+}
+
+// Debug puts, checks and removes a tiny test object in the storage backend
+// with the signed requests used by conversion, so that the auth failures,
+// e.g. clock skew, wrong region or denied policy, are diagnosed before they
+// are reported as generic upload errors deep in conversion.
+func Debug(ctx context.Context, backendType string, config []byte) (*DebugResult, error) {
+	bkd, err := NewBackend(backendType, config, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create backend")
+	}
+	if bkd.Type() == RegistryBackend {
+		return nil, errors.New("registry backend isn't supported")
+	}
+
+	key := ".nydusify-debug-" + uuid.NewString()
+	result := &DebugResult{Backend: backendType, Object: RemoteID(bkd, key)}
+	if localFS, ok := bkd.(*LocalFSBackend); ok {
+		result.Object = localFS.blobPath(key)
+	}
+	result.ClockSkew = serverClockSkew(ctx, RemoteID(bkd, key))
+
+	file, err := os.CreateTemp("", "nydusify-debug-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create test object")
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(debugObjectData)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "write test object")
+	}
+
+	run := func(name string, fn func() error) bool {
+		start := time.Now()
+		err := fn()
+		step := DebugStep{Name: name, Duration: time.Since(start)}
+		if err != nil {
+			step.Error = err.Error()
+			step.Cause = diagnose(err, result.ClockSkew)
+		}
+		result.Steps = append(result.Steps, step)
+		return err == nil
+	}
+
+	if !run("head", func() error {
+		exist, err := bkd.Check(key)
+		if err == nil && exist {
+			return errors.Errorf("unexpected existing object %s", key)
+		}
+		return err
+	}) {
+		return result, nil
+	}
+	if !run("put", func() error {
+		_, err := bkd.Upload(ctx, key, file.Name(), int64(len(debugObjectData)), true)
+		return err
+	}) {
+		return result, nil
+	}
+	run("head", func() error {
+		exist, err := bkd.Check(key)
+		if err == nil && !exist {
+			return errors.Errorf("object %s isn't found after put", key)
+		}
+		return err
+	})
+	run("delete", func() error {
+		return deleteObject(ctx, bkd, key)
+	})
+
+	return result, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebug(t *testing.T) {
+	dir := t.TempDir()
+	result, err := Debug(context.Background(), "localfs", []byte(`{"dir": "`+dir+`"}`))
+	require.NoError(t, err)
+	require.False(t, result.Failed())
+	require.Len(t, result.Steps, 4)
+	require.Equal(t, []string{"head", "put", "head", "delete"}, []string{
+		result.Steps[0].Name, result.Steps[1].Name, result.Steps[2].Name, result.Steps[3].Name,
+	})
+	// The test object is removed.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// The auth failure is blamed on the clock skewed from server.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	config, err := json.Marshal(AzblobConfig{
+		AccountName: "account",
+		AccountKey:  base64.StdEncoding.EncodeToString([]byte("secret")),
+		Container:   "test",
+		Endpoint:    server.URL,
+	})
+	require.NoError(t, err)
+	result, err = Debug(context.Background(), "azblob", config)
+	require.NoError(t, err)
+	require.True(t, result.Failed())
+	require.True(t, result.ClockSkew > 59*time.Minute && result.ClockSkew < 61*time.Minute)
+	require.Len(t, result.Steps, 1)
+	require.Contains(t, result.Steps[0].Error, "status 403, AuthenticationFailed")
+	require.Contains(t, result.Steps[0].Cause, "the clock of this host is skewed by 1h")
+
+	var buf bytes.Buffer
+	require.NoError(t, result.Print(&buf))
+	require.Contains(t, buf.String(), "head failed, the clock of this host is skewed by 1h")
+
+	_, err = Debug(context.Background(), "unknown", nil)
+	require.Error(t, err)
+}
+
+func TestDiagnose(t *testing.T) {
+	for message, cause := range map[string]string{
+		"api error RequestTimeTooSkewed: The difference between the request time and the current time is too large": "the clock of this host is skewed",
+		"api error AuthorizationHeaderMalformed: the region 'us-east-1' is wrong; expecting 'eu-west-1'":            "the bucket is in another region",
+		"oss: service returned error: StatusCode=403, ErrorCode=AccessDenied":                                       "the request is denied",
+		"api error InvalidAccessKeyId: The AWS Access Key Id you provided does not exist in our records":            "the credentials are invalid",
+		"api error NoSuchBucket: The specified bucket does not exist":                                               "the bucket or container doesn't exist",
+		"dial tcp: lookup bucket.example.com: no such host":                                                         "the backend endpoint is unreachable",
+		"unexpected EOF": "unknown error",
+	} {
+		require.Contains(t, diagnose(errors.New(message), 0), cause, message)
+	}
+	require.Empty(t, diagnose(nil, 0))
+}
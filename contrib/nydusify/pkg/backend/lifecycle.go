@@ -0,0 +1,75 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"net/url"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Lifecycle is the storage class and tagging of uploaded blobs, so that the
+// storage cost policies of bucket are applied at conversion time. They're
+// specified by the `storage_class` and `tagging` fields of backend config.
+type Lifecycle struct {
+	// StorageClass is passed to object storage as is, e.g. `IA` for OSS,
+	// `INTELLIGENT_TIERING` for S3.
+	StorageClass string
+	// Tags are parsed from the tagging in URL query form, e.g.
+	// `team=ai&tier=cold`.
+	Tags map[string]string
+}
+
+func parseLifecycle(storageClass, tagging string) (Lifecycle, error) {
+	lifecycle := Lifecycle{StorageClass: storageClass}
+	if tagging == "" {
+		return lifecycle, nil
+	}
+	values, err := url.ParseQuery(tagging)
+	if err != nil {
+		return lifecycle, errors.Wrapf(err, "invalid tagging %s", tagging)
+	}
+	lifecycle.Tags = map[string]string{}
+	for key, value := range values {
+		if key == "" || len(value) != 1 {
+			return lifecycle, errors.Errorf("invalid tagging %s, should be in the form of k1=v1&k2=v2", tagging)
+		}
+		lifecycle.Tags[key] = value[0]
+	}
+	return lifecycle, nil
+}
+
+// IsEmpty returns true if neither storage class nor tagging is specified.
+func (lifecycle Lifecycle) IsEmpty() bool {
+	return lifecycle.StorageClass == "" && len(lifecycle.Tags) == 0
+}
+
+func (lifecycle Lifecycle) tagging() string {
+	values := url.Values{}
+	for key, value := range lifecycle.Tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+func (lifecycle Lifecycle) sortedTagKeys() []string {
+	keys := make([]string, 0, len(lifecycle.Tags))
+	for key := range lifecycle.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LifecycleBackend is the backend supporting lifecycle of blobs.
+type LifecycleBackend interface {
+	Lifecycle() Lifecycle
+	// ApplyLifecycle applies the lifecycle to the blob already uploaded,
+	// like the blobs uploaded by other tools or before the lifecycle is
+	// configured.
+	ApplyLifecycle(ctx context.Context, blobID string, size int64) error
+}
@@ -0,0 +1,54 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLifecycle(t *testing.T) {
+	lifecycle, err := parseLifecycle("", "")
+	require.NoError(t, err)
+	require.True(t, lifecycle.IsEmpty())
+
+	lifecycle, err = parseLifecycle("IA", "team=ai&tier=cold%20data")
+	require.NoError(t, err)
+	require.False(t, lifecycle.IsEmpty())
+	require.Equal(t, map[string]string{"team": "ai", "tier": "cold data"}, lifecycle.Tags)
+	require.Equal(t, "team=ai&tier=cold+data", lifecycle.tagging())
+
+	_, err = parseLifecycle("", "team=ai&team=web")
+	require.Error(t, err)
+	_, err = parseLifecycle("", "%zz")
+	require.Error(t, err)
+}
+
+func TestBackendLifecycle(t *testing.T) {
+	ossBackend, err := newOSSBackend([]byte(`{
+		"bucket_name": "test",
+		"endpoint": "region.oss.com",
+		"storage_class": "IA",
+		"tagging": "tier=cold&team=ai"
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, "IA", ossBackend.Lifecycle().StorageClass)
+	require.Equal(t, []oss.Tag{{Key: "team", Value: "ai"}, {Key: "tier", Value: "cold"}}, ossBackend.tagging().Tags)
+	require.Len(t, ossBackend.lifecycleOptions(), 2)
+	require.Empty(t, tempOSSBackend().lifecycleOptions())
+
+	s3Backend, err := newS3Backend([]byte(`{
+		"bucket_name": "test",
+		"region": "region1",
+		"storage_class": "INTELLIGENT_TIERING"
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, Lifecycle{StorageClass: "INTELLIGENT_TIERING"}, s3Backend.Lifecycle())
+
+	_, err = newS3Backend([]byte(`{"bucket_name": "test", "region": "region1", "tagging": "=cold"}`))
+	require.Error(t, err)
+}
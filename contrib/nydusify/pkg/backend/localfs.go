@@ -0,0 +1,159 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// LocalFSBackend stores the blobs as files named by blob ID in directory,
+// the config is in the same form as the localfs backend of nydusd, e.g.
+// `{"dir": "/path/to/blobs"}`.
+type LocalFSBackend struct {
+	dir string
+}
+
+func newLocalFSBackend(rawConfig []byte) (*LocalFSBackend, error) {
+	var configMap map[string]string
+	if err := json.Unmarshal(rawConfig, &configMap); err != nil {
+		return nil, errors.Wrap(err, "parse localfs storage backend configuration")
+	}
+	dir := configMap["dir"]
+	if dir == "" {
+		return nil, fmt.Errorf("no `dir` option is specified")
+	}
+	return &LocalFSBackend{dir: dir}, nil
+}
+
+func (b *LocalFSBackend) blobPath(blobID string) string {
+	return filepath.Join(b.dir, blobID)
+}
+
+func (b *LocalFSBackend) Upload(_ context.Context, blobID, blobPath string, size int64, forcePush bool) (*ocispec.Descriptor, error) {
+	desc := blobDesc(size, blobID)
+	if !forcePush {
+		if exist, err := b.Check(blobID); err != nil {
+			return nil, errors.Wrap(err, "check blob existence")
+		} else if exist {
+			logrus.Infof("skip upload because blob exists: %s", blobID)
+			return &desc, nil
+		}
+	}
+
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create localfs backend directory")
+	}
+	src, err := os.Open(blobPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open blob file")
+	}
+	defer src.Close()
+
+	// Write to temp file then rename, so that a partial blob is never seen
+	// by readers.
+	dst, err := os.CreateTemp(b.dir, ".upload-"+blobID)
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp blob file")
+	}
+	defer os.Remove(dst.Name())
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return nil, errors.Wrapf(err, "copy blob to %s", b.dir)
+	}
+	if err := dst.Close(); err != nil {
+		return nil, errors.Wrap(err, "close temp blob file")
+	}
+	if err := os.Rename(dst.Name(), b.blobPath(blobID)); err != nil {
+		return nil, errors.Wrap(err, "rename temp blob file")
+	}
+
+	return &desc, nil
+}
+
+func (b *LocalFSBackend) Finalize(_ bool) error {
+	return nil
+}
+
+func (b *LocalFSBackend) Check(blobID string) (bool, error) {
+	if _, err := os.Stat(b.blobPath(blobID)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *LocalFSBackend) Type() Type {
+	return LocalFSbackend
+}
+
+func (b *LocalFSBackend) Reader(blobID string) (io.ReadCloser, error) {
+	return os.Open(b.blobPath(blobID))
+}
+
+// sectionReadCloser reads a section of file.
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+func (b *LocalFSBackend) RangeReader(blobID string, offset, size int64) (io.ReadCloser, error) {
+	file, err := os.Open(b.blobPath(blobID))
+	if err != nil {
+		return nil, err
+	}
+	return &sectionReadCloser{
+		SectionReader: io.NewSectionReader(file, offset, size),
+		Closer:        file,
+	}, nil
+}
+
+func (b *LocalFSBackend) Size(blobID string) (int64, error) {
+	info, err := os.Stat(b.blobPath(blobID))
+	if err != nil {
+		return 0, errors.Wrap(err, "stat blob file")
+	}
+	return info.Size(), nil
+}
+
+func (b *LocalFSBackend) UploadStream(_ context.Context, key string, reader io.Reader) error {
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return errors.Wrap(err, "create localfs backend directory")
+	}
+	dst, err := os.Create(b.blobPath(key))
+	if err != nil {
+		return errors.Wrap(err, "create temp blob file")
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, reader); err != nil {
+		return errors.Wrapf(err, "copy blob stream to %s", b.dir)
+	}
+	return errors.Wrap(dst.Close(), "close temp blob file")
+}
+
+func (b *LocalFSBackend) CommitStream(_ context.Context, key, blobID string, size int64) (*ocispec.Descriptor, error) {
+	if err := os.Rename(b.blobPath(key), b.blobPath(blobID)); err != nil {
+		return nil, errors.Wrap(err, "rename temp blob file")
+	}
+	desc := blobDesc(size, blobID)
+	return &desc, nil
+}
+
+func (b *LocalFSBackend) AbortStream(_ context.Context, key string) error {
+	if err := os.Remove(b.blobPath(key)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove temp blob file")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalFSBackend(t *testing.T) {
+	_, err := newLocalFSBackend([]byte(`{}`))
+	require.ErrorContains(t, err, "no `dir` option")
+
+	dir := filepath.Join(t.TempDir(), "blobs")
+	bkd, err := newLocalFSBackend([]byte(`{"dir": "` + dir + `"}`))
+	require.NoError(t, err)
+	require.Equal(t, LocalFSbackend, bkd.Type())
+
+	blobID := "205eed24cbec29ad9cb4593a73168ef1803402370a82f7d51ce25646fc2f943a"
+	exist, err := bkd.Check(blobID)
+	require.NoError(t, err)
+	require.False(t, exist)
+
+	blobPath := filepath.Join(t.TempDir(), "blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("nydus blob data"), 0644))
+	desc, err := bkd.Upload(context.Background(), blobID, blobPath, 15, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(15), desc.Size)
+	require.Equal(t, "sha256:"+blobID, desc.Digest.String())
+
+	exist, err = bkd.Check(blobID)
+	require.NoError(t, err)
+	require.True(t, exist)
+	size, err := bkd.Size(blobID)
+	require.NoError(t, err)
+	require.Equal(t, int64(15), size)
+
+	reader, err := bkd.RangeReader(blobID, 6, 4)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "blob", string(data))
+
+	// Existing blob is kept without force push.
+	require.NoError(t, os.WriteFile(blobPath, []byte("changed"), 0644))
+	_, err = bkd.Upload(context.Background(), blobID, blobPath, 7, false)
+	require.NoError(t, err)
+	size, err = bkd.Size(blobID)
+	require.NoError(t, err)
+	require.Equal(t, int64(15), size)
+
+	_, err = bkd.Upload(context.Background(), blobID, blobPath, 7, true)
+	require.NoError(t, err)
+	reader, err = bkd.Reader(blobID)
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "changed", string(data))
+}
@@ -5,6 +5,7 @@
 package backend
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -21,12 +22,17 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
 )
 
 const (
 	// For multipart uploads, OSS has a maximum number of 10000 chunks,
 	// so we can only upload blob size of about 10000 * multipartChunkSize.
 	multipartChunkSize = 200 * 1024 * 1024 /// 200MB
+	// The storage class of object is changed by copying to itself, OSS
+	// only supports copying object smaller than 1GB in one request.
+	ossMaxCopySize = 1024 * 1024 * 1024
 )
 
 type multipartStatus struct {
@@ -42,6 +48,7 @@ type OSSBackend struct {
 	// to make it a path-like object.
 	objectPrefix string
 	bucket       *oss.Bucket
+	lifecycle    Lifecycle
 	ms           []multipartStatus
 	msMutex      sync.Mutex
 }
@@ -63,8 +70,26 @@ func newOSSBackend(rawConfig []byte) (*OSSBackend, error) {
 	if endpoint == "" || bucketName == "" {
 		return nil, fmt.Errorf("invalid OSS configuration: missing 'endpoint' or 'bucket'")
 	}
+	lifecycle, err := parseLifecycle(configMap["storage_class"], configMap["tagging"])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid OSS configuration")
+	}
+
+	options := []oss.ClientOption{}
+	if configMap["skip_verify"] == "true" {
+		options = append(options, oss.InsecureSkipVerify(true))
+	}
+	if utils.HTTPTrafficEnabled() {
+		// The custom client replaces the transport configured by SDK.
+		options = append(options, oss.HTTPClient(&http.Client{
+			Transport: utils.WrapTransport(&http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: utils.NewTLSConfig(configMap["skip_verify"] == "true"),
+			}),
+		}))
+	}
 
-	client, err := oss.New(endpoint, accessKeyID, accessKeySecret)
+	client, err := oss.New(endpoint, accessKeyID, accessKeySecret, options...)
 	if err != nil {
 		return nil, errors.Wrap(err, "Create client")
 	}
@@ -77,6 +102,7 @@ func newOSSBackend(rawConfig []byte) (*OSSBackend, error) {
 	return &OSSBackend{
 		objectPrefix: objectPrefix,
 		bucket:       bucket,
+		lifecycle:    lifecycle,
 	}, nil
 }
 
@@ -143,7 +169,7 @@ func (b *OSSBackend) Upload(_ context.Context, blobID, blobPath string, size int
 		return nil, errors.Wrap(err, "split file by part size")
 	}
 
-	imur, err := b.bucket.InitiateMultipartUpload(blobObjectKey)
+	imur, err := b.bucket.InitiateMultipartUpload(blobObjectKey, b.lifecycleOptions()...)
 	if err != nil {
 		return nil, errors.Wrap(err, "initiate multipart upload")
 	}
@@ -265,6 +291,15 @@ func (b *OSSBackend) Reader(blobID string) (io.ReadCloser, error) {
 	return rc, err
 }
 
+func (b *OSSBackend) RangeReader(blobID string, offset, size int64) (io.ReadCloser, error) {
+	blobID = b.objectPrefix + blobID
+	rc, err := b.bucket.GetObject(blobID, oss.Range(offset, offset+size-1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "get object range [%d, %d)", offset, offset+size)
+	}
+	return rc, nil
+}
+
 func (b *OSSBackend) Size(blobID string) (int64, error) {
 	blobID = b.objectPrefix + blobID
 	headers, err := b.bucket.GetObjectMeta(blobID)
@@ -282,3 +317,131 @@ func (b *OSSBackend) Size(blobID string) (int64, error) {
 func (b *OSSBackend) remoteID(blobID string) string {
 	return fmt.Sprintf("oss://%s/%s%s", b.bucket.BucketName, b.objectPrefix, blobID)
 }
+
+func (b *OSSBackend) tagging() oss.Tagging {
+	var tagging oss.Tagging
+	for _, key := range b.lifecycle.sortedTagKeys() {
+		tagging.Tags = append(tagging.Tags, oss.Tag{Key: key, Value: b.lifecycle.Tags[key]})
+	}
+	return tagging
+}
+
+func (b *OSSBackend) lifecycleOptions() []oss.Option {
+	options := []oss.Option{}
+	if b.lifecycle.StorageClass != "" {
+		options = append(options, oss.ObjectStorageClass(oss.StorageClassType(b.lifecycle.StorageClass)))
+	}
+	if len(b.lifecycle.Tags) > 0 {
+		options = append(options, oss.SetTagging(b.tagging()))
+	}
+	return options
+}
+
+func (b *OSSBackend) Lifecycle() Lifecycle {
+	return b.lifecycle
+}
+
+func (b *OSSBackend) ApplyLifecycle(_ context.Context, blobID string, size int64) error {
+	blobObjectKey := b.objectPrefix + blobID
+	if b.lifecycle.StorageClass != "" {
+		if size > ossMaxCopySize {
+			logrus.Warnf("skip changing storage class of blob %s larger than %d bytes", blobID, ossMaxCopySize)
+		} else if _, err := b.bucket.CopyObject(
+			blobObjectKey, blobObjectKey,
+			oss.ObjectStorageClass(oss.StorageClassType(b.lifecycle.StorageClass)),
+			oss.MetadataDirective(oss.MetaCopy),
+		); err != nil {
+			return errors.Wrapf(err, "change storage class of blob %s", blobID)
+		}
+	}
+	if len(b.lifecycle.Tags) > 0 {
+		if err := b.bucket.PutObjectTagging(blobObjectKey, b.tagging()); err != nil {
+			return errors.Wrapf(err, "tag blob %s", blobID)
+		}
+	}
+	return nil
+}
+
+// UploadStream uploads the stream part by part, the stream smaller than one
+// part is uploaded in one request.
+func (b *OSSBackend) UploadStream(_ context.Context, key string, reader io.Reader) error {
+	objectKey := b.objectPrefix + key
+	buf := make([]byte, streamPartSize)
+	var imur *oss.InitiateMultipartUploadResult
+	parts := []oss.UploadPart{}
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			if imur != nil {
+				if err := b.bucket.AbortMultipartUpload(*imur); err != nil {
+					logrus.WithError(err).Warn("abort multipart upload")
+				}
+			}
+			return errors.Wrap(err, "read blob stream")
+		}
+		last := err != nil
+		if imur == nil && last {
+			if err := b.bucket.PutObject(objectKey, bytes.NewReader(buf[:n])); err != nil {
+				return errors.Wrap(err, "put object")
+			}
+			return nil
+		}
+		if imur == nil {
+			result, err := b.bucket.InitiateMultipartUpload(objectKey)
+			if err != nil {
+				return errors.Wrap(err, "initiate multipart upload")
+			}
+			imur = &result
+		}
+		if n > 0 {
+			part, err := b.bucket.UploadPart(*imur, bytes.NewReader(buf[:n]), int64(n), number)
+			if err != nil {
+				if err := b.bucket.AbortMultipartUpload(*imur); err != nil {
+					logrus.WithError(err).Warn("abort multipart upload")
+				}
+				return errors.Wrap(err, "upload part")
+			}
+			parts = append(parts, part)
+		}
+		if last {
+			break
+		}
+	}
+	if _, err := b.bucket.CompleteMultipartUpload(*imur, parts); err != nil {
+		return errors.Wrap(err, "complete multipart upload")
+	}
+	return nil
+}
+
+func (b *OSSBackend) CommitStream(_ context.Context, key, blobID string, size int64) (*ocispec.Descriptor, error) {
+	objectKey := b.objectPrefix + key
+	blobObjectKey := b.objectPrefix + blobID
+	options := b.lifecycleOptions()
+	if len(b.lifecycle.Tags) > 0 {
+		options = append(options, oss.TaggingDirective(oss.TaggingReplace))
+	}
+	var err error
+	if size <= ossMaxCopySize {
+		_, err = b.bucket.CopyObject(objectKey, blobObjectKey, options...)
+	} else {
+		err = b.bucket.CopyFile(b.bucket.BucketName, objectKey, blobObjectKey, multipartChunkSize, b.lifecycleOptions()...)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "copy blob stream to %s", blobObjectKey)
+	}
+	if err := b.bucket.DeleteObject(objectKey); err != nil {
+		logrus.WithError(err).Warnf("remove temporary object %s", objectKey)
+	}
+
+	desc := blobDesc(size, blobID)
+	desc.URLs = append(desc.URLs, b.remoteID(blobID))
+	return &desc, nil
+}
+
+func (b *OSSBackend) AbortStream(_ context.Context, key string) error {
+	objectKey := b.objectPrefix + key
+	if err := b.bucket.DeleteObject(objectKey); err != nil {
+		return errors.Wrapf(err, "delete object %s", objectKey)
+	}
+	return nil
+}
@@ -5,12 +5,17 @@
 package backend
 
 import (
+	"context"
 	"encoding/json"
 	"hash/crc64"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
 )
 
 func tempOSSBackend() *OSSBackend {
@@ -135,3 +140,65 @@ func TestNewOSSBackend(t *testing.T) {
 	require.Contains(t, err.Error(), "Parse OSS storage backend configuration")
 	require.Nil(t, backend)
 }
+
+func TestOSSBackendWithFakeStorage(t *testing.T) {
+	storage := testutil.NewObjectStorage()
+	defer storage.Close()
+
+	config, err := json.Marshal(map[string]string{
+		"bucket_name":   "test",
+		"endpoint":      storage.URL(),
+		"object_prefix": "blobs/",
+		"storage_class": "IA",
+		"tagging":       "team=ai",
+	})
+	require.NoError(t, err)
+	backend, err := newOSSBackend(config)
+	require.NoError(t, err)
+
+	blobPath := filepath.Join(t.TempDir(), "blob")
+	data := []byte("nydus blob data")
+	require.NoError(t, os.WriteFile(blobPath, data, 0644))
+
+	exist, err := backend.Check("blob1")
+	require.NoError(t, err)
+	require.False(t, exist)
+
+	desc, err := backend.Upload(context.Background(), "blob1", blobPath, int64(len(data)), false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"oss://test/blobs/blob1"}, desc.URLs)
+	require.NoError(t, backend.Finalize(false))
+
+	object, ok := storage.Object("test", "blobs/blob1")
+	require.True(t, ok)
+	require.Equal(t, data, object.Data)
+	require.Equal(t, "IA", object.StorageClass)
+	require.Equal(t, map[string]string{"team": "ai"}, object.Tags)
+
+	exist, err = backend.Check("blob1")
+	require.NoError(t, err)
+	require.True(t, exist)
+	size, err := backend.Size("blob1")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+
+	reader, err := backend.RangeReader("blob1", 6, 4)
+	require.NoError(t, err)
+	ranged, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	require.Equal(t, []byte("blob"), ranged)
+
+	storage.PutObject("test", "blobs/blob2", data)
+	require.NoError(t, backend.ApplyLifecycle(context.Background(), "blob2", int64(len(data))))
+	object, _ = storage.Object("test", "blobs/blob2")
+	require.Equal(t, "IA", object.StorageClass)
+	require.Equal(t, map[string]string{"team": "ai"}, object.Tags)
+
+	// The aborted upload leaves no object.
+	_, err = backend.Upload(context.Background(), "blob3", blobPath, int64(len(data)), false)
+	require.NoError(t, err)
+	require.NoError(t, backend.Finalize(true))
+	_, ok = storage.Object("test", "blobs/blob3")
+	require.False(t, ok)
+}
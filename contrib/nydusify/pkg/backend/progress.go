@@ -0,0 +1,58 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"os"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/metrics"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/progress"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// TypeName returns the name of backend type used in command line.
+func TypeName(bt Type) string {
+	switch bt {
+	case OssBackend:
+		return "oss"
+	case RegistryBackend:
+		return "registry"
+	case S3backend:
+		return "s3"
+	case LocalFSbackend:
+		return "localfs"
+	case Azblobbackend:
+		return "azblob"
+	default:
+		return "unknown"
+	}
+}
+
+// Upload uploads the blob file to backend like `Backend.Upload`, with its
+// progress reported and the bytes pushed recorded in metrics. The size of
+// blob file is used if blobSize is 0.
+func Upload(ctx context.Context, bkd Backend, blobID, blobPath string, blobSize int64, forcePush bool) (*ocispec.Descriptor, error) {
+	size := blobSize
+	if size == 0 {
+		if info, err := os.Stat(blobPath); err == nil {
+			size = info.Size()
+		}
+	}
+
+	task := progress.Start(blobID, progress.PhaseUploading, size)
+	desc, err := bkd.Upload(ctx, blobID, blobPath, blobSize, forcePush)
+	if err != nil {
+		task.Done(err)
+		return nil, utils.WithExitCode(err, utils.ExitPush)
+	}
+	task.Add(size)
+	task.Done(nil)
+	metrics.BlobPushed(TypeName(bkd.Type()), size)
+
+	return desc, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/progress"
+)
+
+type recordSink struct {
+	mutex  sync.Mutex
+	events []progress.Event
+}
+
+func (s *recordSink) Handle(event progress.Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *recordSink) Close() error {
+	return nil
+}
+
+func TestUpload(t *testing.T) {
+	sink := &recordSink{}
+	prev := progress.SetSink(sink)
+	defer progress.SetSink(prev)
+
+	bkd, err := newLocalFSBackend([]byte(`{"dir": "` + t.TempDir() + `"}`))
+	require.NoError(t, err)
+	require.Equal(t, "localfs", TypeName(bkd.Type()))
+
+	blobID := "205eed24cbec29ad9cb4593a73168ef1803402370a82f7d51ce25646fc2f943a"
+	blobPath := filepath.Join(t.TempDir(), "blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("nydus blob data"), 0644))
+	// The size of blob file is reported if it's not specified.
+	_, err = Upload(context.Background(), bkd, blobID, blobPath, 0, false)
+	require.NoError(t, err)
+
+	_, err = Upload(context.Background(), bkd, "missing", filepath.Join(t.TempDir(), "missing"), 10, false)
+	require.Error(t, err)
+
+	require.Len(t, sink.events, 5)
+	require.Equal(t, progress.PhaseUploading, sink.events[0].Phase)
+	require.Equal(t, blobID, sink.events[0].ID)
+	require.Equal(t, int64(15), sink.events[0].Total)
+	last := sink.events[2]
+	require.True(t, last.Done)
+	require.Equal(t, int64(15), last.Current)
+	require.Empty(t, last.Error)
+	require.Equal(t, "missing", sink.events[4].ID)
+	require.True(t, sink.events[4].Done)
+	require.NotEmpty(t, sink.events[4].Error)
+}
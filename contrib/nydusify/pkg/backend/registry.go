@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 )
@@ -51,8 +52,20 @@ func (r *Registry) Reader(_ string) (io.ReadCloser, error) {
 	panic("not implemented")
 }
 
-func (r *Registry) Size(_ string) (int64, error) {
-	panic("not implemented")
+func (r *Registry) RangeReader(blobID string, offset, size int64) (io.ReadCloser, error) {
+	return r.remote.PullRange(context.Background(), utils.DigestFromEncoded(blobID), offset, size)
+}
+
+func (r *Registry) Size(blobID string) (int64, error) {
+	size, err := r.remote.BlobSize(context.Background(), utils.DigestFromEncoded(blobID))
+	if err != nil && !r.remote.IsWithHTTP() {
+		// Retry with plain HTTP for the insecure registry.
+		r.remote.MaybeWithHTTP(err)
+		if r.remote.IsWithHTTP() {
+			return r.remote.BlobSize(context.Background(), utils.DigestFromEncoded(blobID))
+		}
+	}
+	return size, err
 }
 
 func newRegistryBackend(_ []byte, remote *remote.Remote) (Backend, error) {
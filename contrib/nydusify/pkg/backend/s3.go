@@ -25,8 +25,14 @@ import (
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
 )
 
+// The storage class of object is changed by copying to itself, S3 only
+// supports copying object up to 5GB in one request.
+const s3MaxCopySize = 5 * 1024 * 1024 * 1024
+
 type S3Backend struct {
 	// objectPrefix is the path prefix of the uploaded object.
 	// For example, if the blobID which should be uploaded is "abc",
@@ -35,6 +41,7 @@ type S3Backend struct {
 	objectPrefix       string
 	bucketName         string
 	endpointWithScheme string
+	lifecycle          Lifecycle
 	client             *s3.Client
 }
 
@@ -46,6 +53,12 @@ type S3Config struct {
 	BucketName      string `json:"bucket_name,omitempty"`
 	Region          string `json:"region,omitempty"`
 	ObjectPrefix    string `json:"object_prefix,omitempty"`
+	// SkipVerify skips verifying server certs for HTTPS endpoint.
+	SkipVerify bool `json:"skip_verify,omitempty"`
+	// StorageClass and Tagging are applied to uploaded blobs, the tagging
+	// is in URL query form, e.g. `team=ai&tier=cold`.
+	StorageClass string `json:"storage_class,omitempty"`
+	Tagging      string `json:"tagging,omitempty"`
 }
 
 func newS3Backend(rawConfig []byte) (*S3Backend, error) {
@@ -64,6 +77,10 @@ func newS3Backend(rawConfig []byte) (*S3Backend, error) {
 	if cfg.BucketName == "" || cfg.Region == "" {
 		return nil, fmt.Errorf("invalid S3 configuration: missing 'bucket_name' or 'region'")
 	}
+	lifecycle, err := parseLifecycle(cfg.StorageClass, cfg.Tagging)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid S3 configuration")
+	}
 
 	s3AWSConfig, err := awscfg.LoadDefaultConfig(context.TODO())
 	if err != nil {
@@ -78,12 +95,26 @@ func newS3Backend(rawConfig []byte) (*S3Backend, error) {
 			o.Credentials = credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.AccessKeySecret, "")
 		}
 		o.UsePathStyle = true
+		httpClient := awshttp.NewBuildableClient()
+		if cfg.SkipVerify {
+			httpClient = httpClient.WithTransportOptions(func(tr *http.Transport) {
+				tr.TLSClientConfig = utils.NewTLSConfig(true)
+			})
+			o.HTTPClient = httpClient
+		}
+		if utils.HTTPTrafficEnabled() {
+			o.HTTPClient = &http.Client{
+				Transport: utils.WrapTransport(httpClient.GetTransport()),
+				Timeout:   httpClient.GetTimeout(),
+			}
+		}
 	})
 
 	return &S3Backend{
 		objectPrefix:       cfg.ObjectPrefix,
 		bucketName:         cfg.BucketName,
 		endpointWithScheme: endpointWithScheme,
+		lifecycle:          lifecycle,
 		client:             client,
 	}, nil
 }
@@ -114,12 +145,17 @@ func (b *S3Backend) Upload(ctx context.Context, blobID, blobPath string, size in
 	uploader := manager.NewUploader(b.client, func(u *manager.Uploader) {
 		u.PartSize = multipartChunkSize
 	})
-	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket:            aws.String(b.bucketName),
 		Key:               aws.String(blobObjectKey),
 		Body:              blobFile,
 		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
-	})
+		StorageClass:      types.StorageClass(b.lifecycle.StorageClass),
+	}
+	if len(b.lifecycle.Tags) > 0 {
+		input.Tagging = aws.String(b.lifecycle.tagging())
+	}
+	_, err = uploader.Upload(ctx, input)
 	if err != nil {
 		return nil, errors.Wrap(err, "upload blob to s3 backend")
 	}
@@ -169,11 +205,25 @@ func (b *S3Backend) Reader(blobID string) (io.ReadCloser, error) {
 	return output.Body, err
 }
 
-func (b *S3Backend) Size(blobID string) (int64, error) {
+func (b *S3Backend) RangeReader(blobID string, offset, size int64) (io.ReadCloser, error) {
 	objectKey := b.blobObjectKey(blobID)
-	output, err := b.client.GetObjectAttributes(context.TODO(), &s3.GetObjectAttributesInput{
+	output, err := b.client.GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: &b.bucketName,
 		Key:    &objectKey,
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get object range [%d, %d)", offset, offset+size)
+	}
+	return output.Body, nil
+}
+
+func (b *S3Backend) Size(blobID string) (int64, error) {
+	objectKey := b.blobObjectKey(blobID)
+	output, err := b.client.GetObjectAttributes(context.TODO(), &s3.GetObjectAttributesInput{
+		Bucket:           &b.bucketName,
+		Key:              &objectKey,
+		ObjectAttributes: []types.ObjectAttributes{types.ObjectAttributesObjectSize},
 	})
 	if err != nil {
 		return 0, errors.Wrap(err, "get object size")
@@ -186,3 +236,147 @@ func (b *S3Backend) remoteID(blobObjectKey string) string {
 	remoteURL.Path = path.Join(remoteURL.Path, b.bucketName, blobObjectKey)
 	return remoteURL.String()
 }
+
+func (b *S3Backend) Lifecycle() Lifecycle {
+	return b.lifecycle
+}
+
+func (b *S3Backend) ApplyLifecycle(ctx context.Context, blobID string, size int64) error {
+	objectKey := b.blobObjectKey(blobID)
+	if b.lifecycle.StorageClass != "" {
+		if size > s3MaxCopySize {
+			logrus.Warnf("skip changing storage class of blob %s larger than %d bytes", blobID, s3MaxCopySize)
+		} else if _, err := b.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:            &b.bucketName,
+			Key:               &objectKey,
+			CopySource:        aws.String(url.PathEscape(b.bucketName + "/" + objectKey)),
+			StorageClass:      types.StorageClass(b.lifecycle.StorageClass),
+			MetadataDirective: types.MetadataDirectiveCopy,
+			TaggingDirective:  types.TaggingDirectiveCopy,
+		}); err != nil {
+			return errors.Wrapf(err, "change storage class of blob %s", blobID)
+		}
+	}
+	if len(b.lifecycle.Tags) > 0 {
+		tagging := &types.Tagging{}
+		for _, key := range b.lifecycle.sortedTagKeys() {
+			tagging.TagSet = append(tagging.TagSet, types.Tag{Key: aws.String(key), Value: aws.String(b.lifecycle.Tags[key])})
+		}
+		if _, err := b.client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+			Bucket:  &b.bucketName,
+			Key:     &objectKey,
+			Tagging: tagging,
+		}); err != nil {
+			return errors.Wrapf(err, "tag blob %s", blobID)
+		}
+	}
+	return nil
+}
+
+func (b *S3Backend) UploadStream(ctx context.Context, key string, reader io.Reader) error {
+	objectKey := b.blobObjectKey(key)
+	uploader := manager.NewUploader(b.client, func(u *manager.Uploader) {
+		u.PartSize = streamPartSize
+	})
+	if _, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:            aws.String(b.bucketName),
+		Key:               aws.String(objectKey),
+		Body:              reader,
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+	}); err != nil {
+		return errors.Wrap(err, "upload blob stream to s3 backend")
+	}
+	return nil
+}
+
+func (b *S3Backend) CommitStream(ctx context.Context, key, blobID string, size int64) (*ocispec.Descriptor, error) {
+	objectKey := b.blobObjectKey(key)
+	blobObjectKey := b.blobObjectKey(blobID)
+	copySource := aws.String(url.PathEscape(b.bucketName + "/" + objectKey))
+	if size <= s3MaxCopySize {
+		input := &s3.CopyObjectInput{
+			Bucket:       &b.bucketName,
+			Key:          &blobObjectKey,
+			CopySource:   copySource,
+			StorageClass: types.StorageClass(b.lifecycle.StorageClass),
+		}
+		if len(b.lifecycle.Tags) > 0 {
+			input.Tagging = aws.String(b.lifecycle.tagging())
+			input.TaggingDirective = types.TaggingDirectiveReplace
+		}
+		if _, err := b.client.CopyObject(ctx, input); err != nil {
+			return nil, errors.Wrapf(err, "copy blob stream to %s", blobObjectKey)
+		}
+	} else if err := b.copyMultipart(ctx, copySource, blobObjectKey, size); err != nil {
+		return nil, errors.Wrapf(err, "copy blob stream to %s", blobObjectKey)
+	}
+	if err := b.AbortStream(ctx, key); err != nil {
+		logrus.WithError(err).Warnf("remove temporary object %s", objectKey)
+	}
+
+	desc := blobDesc(size, blobID)
+	desc.URLs = append(desc.URLs, b.remoteID(blobObjectKey))
+	return &desc, nil
+}
+
+// copyMultipart copies the object larger than s3MaxCopySize part by part.
+func (b *S3Backend) copyMultipart(ctx context.Context, copySource *string, objectKey string, size int64) error {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:       &b.bucketName,
+		Key:          &objectKey,
+		StorageClass: types.StorageClass(b.lifecycle.StorageClass),
+	}
+	if len(b.lifecycle.Tags) > 0 {
+		input.Tagging = aws.String(b.lifecycle.tagging())
+	}
+	upload, err := b.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return errors.Wrap(err, "create multipart upload")
+	}
+	parts := []types.CompletedPart{}
+	for offset, number := int64(0), int32(1); offset < size; offset, number = offset+multipartChunkSize, number+1 {
+		end := offset + multipartChunkSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		output, err := b.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          &b.bucketName,
+			Key:             &objectKey,
+			CopySource:      copySource,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+			PartNumber:      aws.Int32(number),
+			UploadId:        upload.UploadId,
+		})
+		if err != nil {
+			if _, abortErr := b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   &b.bucketName,
+				Key:      &objectKey,
+				UploadId: upload.UploadId,
+			}); abortErr != nil {
+				logrus.WithError(abortErr).Warn("abort multipart upload")
+			}
+			return errors.Wrapf(err, "copy part %d", number)
+		}
+		parts = append(parts, types.CompletedPart{ETag: output.CopyPartResult.ETag, PartNumber: aws.Int32(number)})
+	}
+	if _, err := b.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &b.bucketName,
+		Key:             &objectKey,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		return errors.Wrap(err, "complete multipart upload")
+	}
+	return nil
+}
+
+func (b *S3Backend) AbortStream(ctx context.Context, key string) error {
+	objectKey := b.blobObjectKey(key)
+	if _, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &b.bucketName,
+		Key:    &objectKey,
+	}); err != nil {
+		return errors.Wrapf(err, "delete object %s", objectKey)
+	}
+	return nil
+}
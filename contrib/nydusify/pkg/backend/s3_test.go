@@ -7,10 +7,15 @@ package backend
 import (
 	"context"
 	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
 )
 
 func tempS3Backend() *S3Backend {
@@ -117,3 +122,59 @@ func TestNewS3Backend(t *testing.T) {
 	require.Contains(t, err.Error(), "invalid S3 configuration: missing 'bucket_name' or 'region'")
 	require.Nil(t, backend)
 }
+
+func TestS3BackendWithFakeStorage(t *testing.T) {
+	storage := testutil.NewObjectStorage()
+	defer storage.Close()
+
+	config, err := json.Marshal(S3Config{
+		AccessKeyID:     "testAK",
+		AccessKeySecret: "testSK",
+		Endpoint:        storage.Endpoint(),
+		Scheme:          "http",
+		BucketName:      "test",
+		Region:          "region1",
+		ObjectPrefix:    "blobs/",
+		StorageClass:    "STANDARD_IA",
+		Tagging:         "team=ai",
+	})
+	require.NoError(t, err)
+	backend, err := newS3Backend(config)
+	require.NoError(t, err)
+
+	blobPath := filepath.Join(t.TempDir(), "blob")
+	data := []byte("nydus blob data")
+	require.NoError(t, os.WriteFile(blobPath, data, 0644))
+
+	exist, err := backend.Check("blob1")
+	require.NoError(t, err)
+	require.False(t, exist)
+
+	_, err = backend.Upload(context.Background(), "blob1", blobPath, int64(len(data)), false)
+	require.NoError(t, err)
+	object, ok := storage.Object("test", "blobs/blob1")
+	require.True(t, ok)
+	require.Equal(t, data, object.Data)
+	require.Equal(t, "STANDARD_IA", object.StorageClass)
+	require.Equal(t, map[string]string{"team": "ai"}, object.Tags)
+
+	exist, err = backend.Check("blob1")
+	require.NoError(t, err)
+	require.True(t, exist)
+	size, err := backend.Size("blob1")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+
+	reader, err := backend.RangeReader("blob1", 6, 4)
+	require.NoError(t, err)
+	ranged, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	require.Equal(t, []byte("blob"), ranged)
+
+	storage.PutObject("test", "blobs/blob2", data)
+	require.NoError(t, backend.ApplyLifecycle(context.Background(), "blob2", int64(len(data))))
+	object, _ = storage.Object("test", "blobs/blob2")
+	require.Equal(t, "STANDARD_IA", object.StorageClass)
+	require.Equal(t, map[string]string{"team": "ai"}, object.Tags)
+}
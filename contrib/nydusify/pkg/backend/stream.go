@@ -0,0 +1,32 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"io"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// streamPartSize is the part size of streaming upload, the parts are
+// buffered in memory as the stream has no known length.
+const streamPartSize = 16 * 1024 * 1024
+
+// StreamBackend is the backend supporting uploading blob of unknown length
+// from stream, e.g. the blob is uploaded while nydus-image is writing it.
+// As the blob ID is only known after the stream ends, the stream is
+// uploaded as a temporary object, then committed as the blob.
+type StreamBackend interface {
+	Backend
+	// UploadStream uploads the data read from reader until EOF as the
+	// temporary object named by key.
+	UploadStream(ctx context.Context, key string, reader io.Reader) error
+	// CommitStream moves the temporary object to the blob of blobID, the
+	// lifecycle of backend is applied to the blob.
+	CommitStream(ctx context.Context, key, blobID string, size int64) (*ocispec.Descriptor, error)
+	// AbortStream removes the temporary object.
+	AbortStream(ctx context.Context, key string) error
+}
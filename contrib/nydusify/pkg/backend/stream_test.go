@@ -0,0 +1,94 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+)
+
+func TestStreamBackend(t *testing.T) {
+	storage := testutil.NewObjectStorage()
+	defer storage.Close()
+
+	s3Config, err := json.Marshal(S3Config{
+		AccessKeyID:     "testAK",
+		AccessKeySecret: "testSK",
+		Endpoint:        storage.Endpoint(),
+		Scheme:          "http",
+		BucketName:      "s3",
+		Region:          "region1",
+		ObjectPrefix:    "blobs/",
+		StorageClass:    "STANDARD_IA",
+		Tagging:         "team=ai",
+	})
+	require.NoError(t, err)
+	s3Backend, err := newS3Backend(s3Config)
+	require.NoError(t, err)
+	ossConfig, err := json.Marshal(map[string]string{
+		"bucket_name":   "oss",
+		"endpoint":      storage.URL(),
+		"object_prefix": "blobs/",
+		"storage_class": "IA",
+		"tagging":       "team=ai",
+	})
+	require.NoError(t, err)
+	ossBackend, err := newOSSBackend(ossConfig)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	localfsBackend, err := newLocalFSBackend([]byte(fmt.Sprintf(`{"dir": %q}`, dir)))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	data := []byte("nydus blob data")
+	// The stream of multiple parts.
+	large := bytes.Repeat([]byte("x"), streamPartSize+1)
+	for _, bucket := range []string{"s3", "oss"} {
+		var backend StreamBackend = s3Backend
+		storageClass := "STANDARD_IA"
+		if bucket == "oss" {
+			backend, storageClass = ossBackend, "IA"
+		}
+
+		require.NoError(t, backend.UploadStream(ctx, ".stream-1", bytes.NewReader(data)))
+		desc, err := backend.CommitStream(ctx, ".stream-1", "blob1", int64(len(data)))
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), desc.Size)
+		require.Len(t, desc.URLs, 1)
+		object, ok := storage.Object(bucket, "blobs/blob1")
+		require.True(t, ok)
+		require.Equal(t, data, object.Data)
+		require.Equal(t, storageClass, object.StorageClass)
+		require.Equal(t, map[string]string{"team": "ai"}, object.Tags)
+		require.Equal(t, []string{"blobs/blob1"}, storage.Keys(bucket))
+
+		require.NoError(t, backend.UploadStream(ctx, ".stream-2", bytes.NewReader(large)))
+		object, ok = storage.Object(bucket, "blobs/.stream-2")
+		require.True(t, ok)
+		require.Equal(t, large, object.Data)
+		require.NoError(t, backend.AbortStream(ctx, ".stream-2"))
+		require.Equal(t, []string{"blobs/blob1"}, storage.Keys(bucket))
+	}
+
+	require.NoError(t, localfsBackend.UploadStream(ctx, ".stream-1", bytes.NewReader(data)))
+	_, err = localfsBackend.CommitStream(ctx, ".stream-1", "blob1", int64(len(data)))
+	require.NoError(t, err)
+	stored, err := os.ReadFile(filepath.Join(dir, "blob1"))
+	require.NoError(t, err)
+	require.Equal(t, data, stored)
+	require.NoError(t, localfsBackend.UploadStream(ctx, ".stream-2", bytes.NewReader(data)))
+	require.NoError(t, localfsBackend.AbortStream(ctx, ".stream-2"))
+	_, err = os.Stat(filepath.Join(dir, ".stream-2"))
+	require.True(t, os.IsNotExist(err))
+}
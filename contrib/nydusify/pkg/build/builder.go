@@ -5,12 +5,22 @@
 package build
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/metrics"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/progress"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
 )
 
 type BuilderOption struct {
@@ -27,8 +37,19 @@ type BuilderOption struct {
 	BlobPath     string
 	AlignedChunk bool
 	Compressor   string
-	ChunkSize    string
-	FsVersion    string
+	// CompressionLevel must be DefaultCompressionLevel, see ValidateCompression.
+	CompressionLevel int
+	// MinCompressionRatio builds the blob uncompressed if the estimated
+	// compression ratio of RootfsPath is under it, 0 disables it.
+	MinCompressionRatio float64
+	ChunkSize           string
+	FsVersion           string
+	// Features enables the builder features, for example `blob-toc`
+	// which appends the blob meta and TOC into the blob.
+	Features []string
+	// Timeout kills the builder if the layer isn't built in time, 0 means
+	// no limit.
+	Timeout time.Duration
 }
 
 type CompactOption struct {
@@ -39,16 +60,30 @@ type CompactOption struct {
 	BackendConfigPath   string
 	OutputJSONPath      string
 	CompactConfigPath   string
+	// Timeout kills the builder if the compaction isn't done in time, 0
+	// means no limit.
+	Timeout time.Duration
 }
 
 type SaveOption struct {
 	BootstrapPath string
 }
 
+type MergeOption struct {
+	// SourceBootstrapPaths are overlaid in order, the files in the later
+	// bootstraps take precedence over the same paths in former ones.
+	SourceBootstrapPaths []string
+	TargetBootstrapPath  string
+}
+
 type Builder struct {
 	binaryPath string
 	stdout     io.Writer
 	stderr     io.Writer
+
+	mutex sync.Mutex
+	// info is the detected version and features of nydus-image.
+	info *BuilderInfo
 }
 
 func NewBuilder(binaryPath string) *Builder {
@@ -59,15 +94,44 @@ func NewBuilder(binaryPath string) *Builder {
 	}
 }
 
-func (builder *Builder) run(args []string, prefetchPatterns string) error {
+// runSeq distinguishes the concurrent runs of nydus-image in progress.
+var runSeq uint64
+
+func (builder *Builder) run(ctx context.Context, args []string, prefetchPatterns string, timeout time.Duration) error {
 	logrus.Debugf("\tCommand: %s %s", builder.binaryPath, strings.Join(args[:], " "))
 
-	cmd := exec.Command(builder.binaryPath, args...)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// The builder is killed once the context is done, rather than
+	// leaking after the caller is aborted.
+	cmd := exec.CommandContext(ctx, builder.binaryPath, args...)
 	cmd.Stdout = builder.stdout
 	cmd.Stderr = builder.stderr
 	cmd.Stdin = strings.NewReader(prefetchPatterns)
 
-	if err := cmd.Run(); err != nil {
+	// The progress of nydus-image is unknown, it's only reported as running
+	// until it exits.
+	task := progress.Start(fmt.Sprintf("nydus-image %s #%d", args[0], atomic.AddUint64(&runSeq, 1)), progress.PhaseBuilding, 0)
+	start := time.Now()
+	err := cmd.Run()
+	task.Done(err)
+	metrics.BuildDuration(args[0], start)
+	if err != nil {
+		// The builder failure is classified by exit code, except being
+		// aborted by the caller.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if errors.Is(ctxErr, context.DeadlineExceeded) && timeout > 0 {
+				err = utils.WithExitCode(errors.Wrapf(ctxErr, "timeout %s", timeout), utils.ExitBuilder)
+			} else {
+				err = ctxErr
+			}
+		} else {
+			err = utils.WithExitCode(err, utils.ExitBuilder)
+		}
 		logrus.WithError(err).Errorf("fail to run %v %+v", builder.binaryPath, args)
 		return err
 	}
@@ -75,7 +139,29 @@ func (builder *Builder) run(args []string, prefetchPatterns string) error {
 	return nil
 }
 
-func (builder *Builder) Compact(option CompactOption) error {
+// Compact exec nydus-image CLI to compact bootstrap, and returns the result
+// parsed from the output JSON.
+func (builder *Builder) Compact(option CompactOption) (*CompactResult, error) {
+	return builder.CompactWithContext(context.Background(), option)
+}
+
+// CompactWithContext is Compact, which kills nydus-image once ctx is done.
+func (builder *Builder) CompactWithContext(ctx context.Context, option CompactOption) (*CompactResult, error) {
+	if err := builder.checkFeatures(ctx, option.Timeout, func(info *BuilderInfo) error {
+		if !info.Supports(FeatureCompact) {
+			return info.unsupported(FeatureCompact)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	jsonPath, cleanup, err := outputJSONPath(option.OutputJSONPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
 	args := []string{
 		"compact",
 		"--bootstrap", option.BootstrapPath,
@@ -83,7 +169,7 @@ func (builder *Builder) Compact(option CompactOption) error {
 		"--backend-type", option.BackendType,
 		"--backend-config-file", option.BackendConfigPath,
 		"--log-level", "info",
-		"--output-json", option.OutputJSONPath,
+		"--output-json", jsonPath,
 	}
 	if option.OutputBootstrapPath != "" {
 		args = append(args, "--output-bootstrap", option.OutputBootstrapPath)
@@ -91,11 +177,47 @@ func (builder *Builder) Compact(option CompactOption) error {
 	if option.ChunkDict != "" {
 		args = append(args, "--chunk-dict", option.ChunkDict)
 	}
-	return builder.run(args, "")
+	if err := builder.run(ctx, args, "", option.Timeout); err != nil {
+		return nil, err
+	}
+
+	output, err := ParseOutput(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+	return &CompactResult{Output: *output}, nil
+}
+
+// Run exec nydus-image CLI to build layer, and returns the result parsed
+// from the output JSON, the output JSON is written to a temporary file if
+// OutputJSONPath isn't specified.
+func (builder *Builder) Run(option BuilderOption) (*BuildResult, error) {
+	return builder.RunWithContext(context.Background(), option)
 }
 
-// Run exec nydus-image CLI to build layer
-func (builder *Builder) Run(option BuilderOption) error {
+// RunWithContext is Run, which kills nydus-image once ctx is done.
+func (builder *Builder) RunWithContext(ctx context.Context, option BuilderOption) (*BuildResult, error) {
+	if err := ValidateCompression(option.Compressor, option.CompressionLevel); err != nil {
+		return nil, err
+	}
+	if err := ValidateMinCompressionRatio(option.MinCompressionRatio); err != nil {
+		return nil, err
+	}
+	if err := applyMinCompressionRatio(&option); err != nil {
+		return nil, errors.Wrap(err, "estimate compression ratio")
+	}
+	if err := builder.checkFeatures(ctx, option.Timeout, func(info *BuilderInfo) error {
+		return info.CheckOption(&option)
+	}); err != nil {
+		return nil, err
+	}
+
+	jsonPath, cleanup, err := outputJSONPath(option.OutputJSONPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
 	var args []string
 	if option.ParentBootstrapPath == "" {
 		args = []string{
@@ -124,7 +246,7 @@ func (builder *Builder) Run(option BuilderOption) error {
 		"--whiteout-spec",
 		option.WhiteoutSpec,
 		"--output-json",
-		option.OutputJSONPath,
+		jsonPath,
 		"--blob",
 		option.BlobPath,
 		"--fs-version",
@@ -143,9 +265,21 @@ func (builder *Builder) Run(option BuilderOption) error {
 		args = append(args, "--chunk-size", option.ChunkSize)
 	}
 
+	for _, feature := range option.Features {
+		args = append(args, "--features", feature)
+	}
+
 	args = append(args, option.RootfsPath)
 
-	return builder.run(args, option.PrefetchPatterns)
+	if err := builder.run(ctx, args, option.PrefetchPatterns, option.Timeout); err != nil {
+		return nil, err
+	}
+
+	output, err := ParseOutput(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+	return newBuildResult(output, option.BlobPath)
 }
 
 // Save calls `nydus-image chunkdict save` to parse Nydus bootstrap
@@ -158,5 +292,19 @@ func (builder *Builder) Save(option SaveOption) error {
 		"--bootstrap",
 		option.BootstrapPath,
 	}
-	return builder.run(args, "")
+	return builder.run(context.Background(), args, "", 0)
+}
+
+// Merge calls `nydus-image merge` to merge the bootstraps into one, which
+// references the chunks of all source bootstraps.
+func (builder *Builder) Merge(ctx context.Context, option MergeOption) error {
+	args := []string{
+		"merge",
+		"--log-level",
+		"warn",
+		"--bootstrap",
+		option.TargetBootstrapPath,
+	}
+	args = append(args, option.SourceBootstrapPaths...)
+	return builder.run(ctx, args, "", 0)
 }
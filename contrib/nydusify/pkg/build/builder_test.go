@@ -0,0 +1,206 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+)
+
+func TestRunWithContext(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "nydus-image")
+	require.NoError(t, os.WriteFile(binaryPath, []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+	builder := NewBuilder(binaryPath)
+
+	start := time.Now()
+	_, err := builder.RunWithContext(context.Background(), BuilderOption{Timeout: 100 * time.Millisecond})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "timeout 100ms")
+
+	// The layer build of workflow is bounded by BuildTimeout.
+	workflow, err := NewWorkflow(WorkflowOption{
+		TargetDir:      t.TempDir(),
+		NydusImagePath: binaryPath,
+		BuildTimeout:   100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	_, err = workflow.Build(context.Background(), t.TempDir(), "oci", "", filepath.Join(t.TempDir(), "bootstrap"), false)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err = builder.CompactWithContext(ctx, CompactOption{})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	require.NoError(t, os.WriteFile(binaryPath, []byte("#!/bin/sh\nexit 0\n"), 0755))
+	_, err = builder.Run(BuilderOption{})
+	require.ErrorContains(t, err, "unmarshal output json")
+}
+
+func TestRunWithStub(t *testing.T) {
+	stub, err := testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{
+		Blobs:     []string{"blob1"},
+		Bootstrap: []byte("bootstrap"),
+		Blob:      []byte("blob"),
+	})
+	require.NoError(t, err)
+	builder := NewBuilder(stub.Path)
+
+	workDir := t.TempDir()
+	option := BuilderOption{
+		ParentBootstrapPath: filepath.Join(workDir, "parent"),
+		ChunkDict:           "bootstrap=/dict",
+		BootstrapPath:       filepath.Join(workDir, "bootstrap"),
+		RootfsPath:          filepath.Join(workDir, "rootfs"),
+		WhiteoutSpec:        "oci",
+		OutputJSONPath:      filepath.Join(workDir, "output.json"),
+		BlobPath:            filepath.Join(workDir, "blob"),
+		FsVersion:           "6",
+		Compressor:          "zstd",
+		Features:            []string{"blob-toc"},
+	}
+	result, err := builder.Run(option)
+	require.NoError(t, err)
+	require.Equal(t, "v2.2.0", result.Version)
+	require.Equal(t, "blob1", result.BlobID)
+	require.Equal(t, int64(len("blob")), result.BlobSize)
+
+	bootstrap, err := os.ReadFile(option.BootstrapPath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("bootstrap"), bootstrap)
+	output, err := os.ReadFile(option.OutputJSONPath)
+	require.NoError(t, err)
+	require.Contains(t, string(output), `"blob1"`)
+
+	calls, err := stub.Calls()
+	require.NoError(t, err)
+	require.Len(t, calls, 1)
+	require.Equal(t, []string{"create", "--parent-bootstrap", option.ParentBootstrapPath, "--chunk-dict", "bootstrap=/dict"}, calls[0][:5])
+	require.Contains(t, calls[0], "--compressor")
+	require.Equal(t, option.RootfsPath, calls[0][len(calls[0])-1])
+
+	stub, err = testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{
+		Stderr:   "invalid bootstrap",
+		ExitCode: 1,
+	})
+	require.NoError(t, err)
+	_, err = NewBuilder(stub.Path).Compact(CompactOption{BootstrapPath: option.BootstrapPath})
+	require.Error(t, err)
+}
+
+func TestValidateCompression(t *testing.T) {
+	require.NoError(t, ValidateCompression("", DefaultCompressionLevel))
+	for _, compressor := range []string{CompressorNone, CompressorLz4Block, CompressorZstd} {
+		require.NoError(t, ValidateCompression(compressor, DefaultCompressionLevel))
+	}
+	require.ErrorContains(t, ValidateCompression("gzip", DefaultCompressionLevel), "invalid compressor gzip")
+	require.ErrorContains(t, ValidateCompression(CompressorNone, 3), "invalid for compressor none")
+	require.ErrorContains(t, ValidateCompression(CompressorZstd, 3), "unsupported by nydus-image")
+
+	// The invalid options are rejected before running nydus-image.
+	builder := NewBuilder(filepath.Join(t.TempDir(), "nydus-image"))
+	_, err := builder.Run(BuilderOption{Compressor: "gzip"})
+	require.ErrorContains(t, err, "invalid compressor gzip")
+}
+
+func TestMinCompressionRatio(t *testing.T) {
+	require.NoError(t, ValidateMinCompressionRatio(0))
+	require.NoError(t, ValidateMinCompressionRatio(1.1))
+	require.ErrorContains(t, ValidateMinCompressionRatio(0.5), "invalid minimum compression ratio")
+
+	dir := t.TempDir()
+	ratio, err := EstimateCompressionRatio(dir)
+	require.NoError(t, err)
+	require.Zero(t, ratio)
+
+	text := filepath.Join(dir, "text")
+	require.NoError(t, os.WriteFile(text, bytes.Repeat([]byte("nydus "), 1<<16), 0644))
+	ratio, err = EstimateCompressionRatio(dir)
+	require.NoError(t, err)
+	require.Greater(t, ratio, 10.0)
+
+	// The random data is like the already compressed content.
+	random := make([]byte, 1<<20)
+	_, err = rand.Read(random)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(text))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lib", "app.jar"), random, 0644))
+	ratio, err = EstimateCompressionRatio(dir)
+	require.NoError(t, err)
+	require.Less(t, ratio, 1.01)
+
+	stub, err := testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{})
+	require.NoError(t, err)
+	workDir := t.TempDir()
+	option := BuilderOption{
+		BootstrapPath:       filepath.Join(workDir, "bootstrap"),
+		RootfsPath:          dir,
+		BlobPath:            filepath.Join(workDir, "blob"),
+		FsVersion:           "6",
+		Compressor:          CompressorZstd,
+		MinCompressionRatio: 1.1,
+	}
+	_, err = NewBuilder(stub.Path).Run(option)
+	require.NoError(t, err)
+	option.MinCompressionRatio = 0
+	_, err = NewBuilder(stub.Path).Run(option)
+	require.NoError(t, err)
+	calls, err := stub.Calls()
+	require.NoError(t, err)
+	require.Len(t, calls, 2)
+	require.Contains(t, strings.Join(calls[0], " "), "--compressor none")
+	require.Contains(t, strings.Join(calls[1], " "), "--compressor zstd")
+}
+
+func TestDetect(t *testing.T) {
+	info := parseBuilderInfo(" \rVersion: \tv2.1.6\nGit Commit: \tabcdef\nBuild Time: \t2023-01-01\n")
+	require.Equal(t, "v2.1.6", info.Version)
+	require.True(t, info.Supports(FeatureRafsV6))
+	require.True(t, info.Supports(FeatureCompact))
+	require.False(t, info.Supports(FeatureBlobToc))
+
+	// All features are assumed to be supported by the unknown versions.
+	info = parseBuilderInfo("Version: \tnightly-abcdef\n")
+	require.Equal(t, "nightly-abcdef", info.Version)
+	require.True(t, info.Supports(FeatureBlobToc))
+
+	info = parseBuilderInfo("Version: \tv1.1.2\n")
+	option := BuilderOption{FsVersion: "5", AlignedChunk: true}
+	require.NoError(t, info.CheckOption(&option))
+	require.False(t, option.AlignedChunk)
+	option = BuilderOption{FsVersion: "6"}
+	require.ErrorContains(t, info.CheckOption(&option), "rafs-v6 is unsupported by nydus-image v1.1.2, at least v2.0.0 is required")
+
+	stub, err := testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{Version: "v2.0.3"})
+	require.NoError(t, err)
+	builder := NewBuilder(stub.Path)
+	info, err = builder.Detect()
+	require.NoError(t, err)
+	require.Equal(t, "v2.0.3", info.Version)
+	cached, err := builder.Detect()
+	require.NoError(t, err)
+	require.True(t, info == cached)
+
+	// The unsupported options are rejected before running nydus-image.
+	_, err = builder.Run(BuilderOption{FsVersion: "6", Features: []string{"blob-toc"}})
+	require.ErrorContains(t, err, "blob-toc is unsupported")
+	_, err = builder.Compact(CompactOption{})
+	require.ErrorContains(t, err, "compact is unsupported")
+	calls, err := stub.Calls()
+	require.NoError(t, err)
+	require.Empty(t, calls)
+}
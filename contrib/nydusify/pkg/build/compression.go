@@ -0,0 +1,153 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package build
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	CompressorNone     = "none"
+	CompressorLz4Block = "lz4_block"
+	CompressorZstd     = "zstd"
+)
+
+var compressors = []string{
+	CompressorNone,
+	CompressorLz4Block,
+	CompressorZstd,
+}
+
+// DefaultCompressionLevel leaves the compression level to nydus-image.
+const DefaultCompressionLevel = 0
+
+// ValidateCompression checks the blob compressor and compression level
+// specified by user, empty compressor means the nydus-image default.
+// nydus-image compresses blobs with the fixed default level of each
+// algorithm and has no option to change it, so that a level other than
+// DefaultCompressionLevel is rejected rather than silently ignored.
+func ValidateCompression(compressor string, level int) error {
+	valid := compressor == ""
+	for _, c := range compressors {
+		if c == compressor {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid compressor %s, possible values: %v", compressor, compressors)
+	}
+	if level == DefaultCompressionLevel {
+		return nil
+	}
+	if compressor == CompressorNone {
+		return fmt.Errorf("compression level %d is invalid for compressor %s", level, compressor)
+	}
+	return fmt.Errorf("compression level %d is unsupported by nydus-image, which compresses blobs with the default level", level)
+}
+
+const (
+	// compressionSampleFileSize is the size sampled from the head of each
+	// file to estimate compression ratio.
+	compressionSampleFileSize = 64 << 10
+	// compressionSampleSize is the total size sampled from a directory.
+	compressionSampleSize = 8 << 20
+)
+
+// ValidateMinCompressionRatio checks the minimum compression ratio specified
+// by user, 0 disables it, otherwise it must be at least 1.
+func ValidateMinCompressionRatio(ratio float64) error {
+	if ratio != 0 && ratio < 1 {
+		return fmt.Errorf("invalid minimum compression ratio %v, should be 0 or at least 1", ratio)
+	}
+	return nil
+}
+
+// EstimateCompressionRatio estimates the compression ratio, i.e. the
+// uncompressed size divided by the compressed size, of the regular files in
+// dir by compressing the samples from the head of files with deflate in the
+// fastest level. It's 0 if no data is sampled, e.g. an empty directory.
+func EstimateCompressionRatio(dir string) (float64, error) {
+	counter := &countWriter{}
+	fw, err := flate.NewWriter(counter, flate.BestSpeed)
+	if err != nil {
+		return 0, err
+	}
+	var sampled int64
+	errSampled := errors.New("sampled")
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		n, err := io.Copy(fw, io.LimitReader(file, min(compressionSampleFileSize, compressionSampleSize-sampled)))
+		sampled += n
+		if err != nil {
+			return err
+		}
+		if sampled >= compressionSampleSize {
+			return errSampled
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errSampled) {
+		return 0, errors.Wrapf(err, "sample files in %s", dir)
+	}
+	if err := fw.Close(); err != nil {
+		return 0, err
+	}
+	if sampled == 0 {
+		return 0, nil
+	}
+	return float64(sampled) / float64(counter.n), nil
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (writer *countWriter) Write(p []byte) (int, error) {
+	writer.n += int64(len(p))
+	return len(p), nil
+}
+
+// applyMinCompressionRatio builds the blob uncompressed if the estimated
+// compression ratio of the files to build is under the minimum, to avoid
+// wasting CPU on recompressing the already compressed content such as jars
+// and videos. nydus-image itself only stores a chunk uncompressed if the
+// compression doesn't shrink it.
+func applyMinCompressionRatio(option *BuilderOption) error {
+	if option.MinCompressionRatio == 0 || option.Compressor == CompressorNone {
+		return nil
+	}
+	info, err := os.Stat(option.RootfsPath)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+	ratio, err := EstimateCompressionRatio(option.RootfsPath)
+	if err != nil {
+		return err
+	}
+	if ratio != 0 && ratio < option.MinCompressionRatio {
+		logrus.Infof("estimated compression ratio %.2f of %s is under %.2f, build blob uncompressed",
+			ratio, option.RootfsPath, option.MinCompressionRatio)
+		option.Compressor = CompressorNone
+	}
+	return nil
+}
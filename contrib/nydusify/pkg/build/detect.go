@@ -0,0 +1,175 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Feature is a capability of nydus-image which isn't supported by all
+// versions.
+type Feature string
+
+const (
+	// FeatureRafsV6 is `--fs-version 6` of create.
+	FeatureRafsV6 Feature = "rafs-v6"
+	// FeatureChunkDict is `--chunk-dict` of create.
+	FeatureChunkDict Feature = "chunk-dict"
+	// FeatureAlignedChunk is `--aligned-chunk` of create.
+	FeatureAlignedChunk Feature = "aligned-chunk"
+	// FeatureCompact is the compact subcommand.
+	FeatureCompact Feature = "compact"
+	// FeatureBlobToc is `--features blob-toc` of create.
+	FeatureBlobToc Feature = "blob-toc"
+)
+
+// featureVersions are the nydus-image versions introducing the features.
+var featureVersions = map[Feature]semver{
+	FeatureRafsV6:       {2, 0, 0},
+	FeatureChunkDict:    {2, 0, 0},
+	FeatureAlignedChunk: {2, 0, 0},
+	FeatureCompact:      {2, 1, 0},
+	FeatureBlobToc:      {2, 2, 0},
+}
+
+// detectTimeout bounds the time of `nydus-image --version`.
+const detectTimeout = 10 * time.Second
+
+// semver is a simplified semantic version, pre-release and build metadata
+// suffixes are ignored.
+type semver [3]int
+
+var (
+	versionPattern = regexp.MustCompile(`Version:\s*(\S+)`)
+	semverPattern  = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)`)
+)
+
+func (ver semver) less(other semver) bool {
+	for idx := range ver {
+		if ver[idx] != other[idx] {
+			return ver[idx] < other[idx]
+		}
+	}
+	return false
+}
+
+func (ver semver) String() string {
+	return fmt.Sprintf("v%d.%d.%d", ver[0], ver[1], ver[2])
+}
+
+// BuilderInfo is the version and features of nydus-image detected by
+// `nydus-image --version`.
+type BuilderInfo struct {
+	// Version is the version reported by nydus-image, e.g. `v2.2.0`.
+	Version string
+	// Features are the supported features, nil if the version isn't a
+	// semantic version, e.g. built from an untagged commit, then all
+	// features are assumed to be supported.
+	Features map[Feature]bool
+}
+
+// Supports checks whether the feature is supported by nydus-image.
+func (info *BuilderInfo) Supports(feature Feature) bool {
+	return info.Features == nil || info.Features[feature]
+}
+
+// parseBuilderInfo parses the output of `nydus-image --version`, e.g.
+// `Version: v2.2.0`, followed by the git commit, build time and so on.
+func parseBuilderInfo(output string) *BuilderInfo {
+	info := &BuilderInfo{}
+	match := versionPattern.FindStringSubmatch(output)
+	if match == nil {
+		return info
+	}
+	info.Version = match[1]
+	match = semverPattern.FindStringSubmatch(info.Version)
+	if match == nil {
+		return info
+	}
+	var ver semver
+	for idx := range ver {
+		ver[idx], _ = strconv.Atoi(match[idx+1])
+	}
+	info.Features = map[Feature]bool{}
+	for feature, since := range featureVersions {
+		info.Features[feature] = !ver.less(since)
+	}
+	return info
+}
+
+// Detect runs `nydus-image --version` to detect the version and features
+// of nydus-image, the result is cached once detected.
+func (builder *Builder) Detect() (*BuilderInfo, error) {
+	return builder.detect(context.Background())
+}
+
+func (builder *Builder) detect(ctx context.Context) (*BuilderInfo, error) {
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	if builder.info != nil {
+		return builder.info, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, detectTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, builder.binaryPath, "--version").Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "detect version of %s", builder.binaryPath)
+	}
+	builder.info = parseBuilderInfo(string(output))
+	logrus.Debugf("detected nydus-image version %q", builder.info.Version)
+	return builder.info, nil
+}
+
+func (info *BuilderInfo) unsupported(feature Feature) error {
+	return fmt.Errorf("%s is unsupported by nydus-image %s, at least %s is required",
+		feature, info.Version, featureVersions[feature])
+}
+
+// CheckOption checks the options of create against the features of
+// nydus-image, the unsupported optimization is dropped with a warning, and
+// an error is returned for the unsupported option changing the image.
+func (info *BuilderInfo) CheckOption(option *BuilderOption) error {
+	if option.FsVersion == "6" && !info.Supports(FeatureRafsV6) {
+		return info.unsupported(FeatureRafsV6)
+	}
+	if option.ChunkDict != "" && !info.Supports(FeatureChunkDict) {
+		return info.unsupported(FeatureChunkDict)
+	}
+	for _, feature := range option.Features {
+		if feature == string(FeatureBlobToc) && !info.Supports(FeatureBlobToc) {
+			return info.unsupported(FeatureBlobToc)
+		}
+	}
+	if option.AlignedChunk && !info.Supports(FeatureAlignedChunk) {
+		logrus.Warnf("%s is unsupported by nydus-image %s, ignored", FeatureAlignedChunk, info.Version)
+		option.AlignedChunk = false
+	}
+	return nil
+}
+
+// checkFeatures detects nydus-image in timeout and checks the options by
+// check, the options are used as is if nydus-image can't be detected.
+func (builder *Builder) checkFeatures(ctx context.Context, timeout time.Duration, check func(info *BuilderInfo) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	info, err := builder.detect(ctx)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to detect nydus-image, skip checking options")
+		return nil
+	}
+	return check(info)
+}
@@ -0,0 +1,227 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Trace is the performance trace of nydus-image.
+type Trace struct {
+	// ConsumedTime is the elapsed seconds of each build stage.
+	ConsumedTime map[string]float64 `json:"consumed_time,omitempty"`
+	// RegisteredEvents are the counters of build, for example
+	// `blob_compressed_size` and `dedup_chunks`.
+	RegisteredEvents map[string]interface{} `json:"registered_events,omitempty"`
+}
+
+// Output is the output JSON written by nydus-image with `--output-json`.
+type Output struct {
+	// Version is the version of nydus-image.
+	Version   string `json:"version"`
+	Bootstrap string `json:"bootstrap,omitempty"`
+	// Blobs are the blob ids (sha256 hex) in the blob table of bootstrap,
+	// ordered by blob index.
+	Blobs      []string `json:"blobs"`
+	FsVersion  string   `json:"fs_version,omitempty"`
+	Compressor string   `json:"compressor,omitempty"`
+	Trace      Trace    `json:"trace"`
+}
+
+// Counter returns the registered event of trace as integer, 0 is returned
+// if it's not registered or not a number.
+func (output *Output) Counter(name string) int64 {
+	value, ok := output.Trace.RegisteredEvents[name].(float64)
+	if !ok {
+		return 0
+	}
+	return int64(value)
+}
+
+// BuildResult is the result of `nydus-image create`.
+type BuildResult struct {
+	Output
+	// BlobID is the id of blob built by this run, and BlobSize is its size,
+	// the BlobID is empty if no blob is built, for example all the chunks
+	// are deduplicated by parent bootstrap or chunk dict.
+	BlobID   string
+	BlobSize int64
+}
+
+// CompactResult is the result of `nydus-image compact`.
+type CompactResult struct {
+	Output
+}
+
+// MaxOutputSize is the size limit in bytes of the output JSON written by
+// nydus-image, which lists all blobs of bootstrap and may be tens of MB for
+// huge images, 0 means no limit.
+var MaxOutputSize int64 = 256 << 20
+
+// ParseOutput parses the output JSON written by nydus-image with
+// `--output-json`, the file is decoded in streaming rather than read into
+// memory as a whole.
+func ParseOutput(path string) (*Output, error) {
+	blobs := []string{}
+	output, err := ParseOutputBlobs(path, func(blobID string) error {
+		blobs = append(blobs, blobID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	output.Blobs = blobs
+	return output, nil
+}
+
+// ParseOutputBlobs is ParseOutput, but the blob ids are passed to fn in
+// order of blob index instead of being collected into Output.Blobs, so that
+// the callers keep only what they need of the huge blob list, e.g. the
+// deduplicated ids. The blobs are skipped if fn is nil.
+func ParseOutputBlobs(path string, fn func(blobID string) error) (*Output, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open output json %s", path)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "stat output json %s", path)
+	}
+	if MaxOutputSize > 0 && info.Size() > MaxOutputSize {
+		return nil, errors.Errorf("output json %s is %d bytes, exceeding the limit %d bytes", path, info.Size(), MaxOutputSize)
+	}
+
+	var output Output
+	if err := decodeOutput(json.NewDecoder(bufio.NewReader(file)), &output, fn); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal output json %s", path)
+	}
+	return &output, nil
+}
+
+func decodeOutput(decoder *json.Decoder, output *Output, fn func(blobID string) error) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token.(string) {
+		case "blobs":
+			if err := decodeBlobs(decoder, fn); err != nil {
+				return errors.Wrap(err, "decode blobs")
+			}
+		case "version":
+			err = decoder.Decode(&output.Version)
+		case "bootstrap":
+			err = decoder.Decode(&output.Bootstrap)
+		case "fs_version":
+			err = decoder.Decode(&output.FsVersion)
+		case "compressor":
+			err = decoder.Decode(&output.Compressor)
+		case "trace":
+			err = decoder.Decode(&output.Trace)
+		default:
+			err = skipValue(decoder)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "decode %s", token)
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+// decodeBlobs decodes the blob id array one by one.
+func decodeBlobs(decoder *json.Decoder, fn func(blobID string) error) error {
+	if fn == nil {
+		return skipValue(decoder)
+	}
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if token != json.Delim('[') {
+		return errors.Errorf("unexpected %v, expecting array", token)
+	}
+	for decoder.More() {
+		var blobID string
+		if err := decoder.Decode(&blobID); err != nil {
+			return err
+		}
+		if err := fn(blobID); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, ']')
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return errors.Errorf("unexpected %v, expecting %s", token, delim)
+	}
+	return nil
+}
+
+// skipValue skips the next value without holding it in memory.
+func skipValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func newBuildResult(output *Output, blobPath string) (*BuildResult, error) {
+	result := BuildResult{Output: *output}
+	info, err := os.Stat(blobPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "stat blob %s", blobPath)
+	}
+	// The blob of this build is appended to the blob table, an empty blob
+	// file is left if no chunk is written.
+	if err == nil && info.Size() > 0 && len(output.Blobs) > 0 {
+		result.BlobID = output.Blobs[len(output.Blobs)-1]
+		result.BlobSize = info.Size()
+	}
+	return &result, nil
+}
+
+// outputJSONPath returns the output JSON path of option, or a temporary
+// path if it's not specified, which is removed by the returned cleanup.
+func outputJSONPath(path string) (string, func(), error) {
+	if path != "" {
+		return path, func() {}, nil
+	}
+	file, err := os.CreateTemp("", "nydus-output-*.json")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "create output json file")
+	}
+	file.Close()
+	return file.Name(), func() { os.Remove(file.Name()) }, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package build
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOutput(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "output.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{
+		"version": "2.2.0-abc",
+		"bootstrap": "/tmp/bootstrap",
+		"blobs": ["parent", "new"],
+		"trace": {
+			"consumed_time": {"total_build": 0.5},
+			"registered_events": {"blob_compressed_size": 7, "dedup_chunks": 1, "egid": "100"}
+		},
+		"fs_version": "6",
+		"compressor": "zstd"
+	}`), 0644))
+
+	output, err := ParseOutput(jsonPath)
+	require.NoError(t, err)
+	require.Equal(t, "2.2.0-abc", output.Version)
+	require.Equal(t, "6", output.FsVersion)
+	require.Equal(t, 0.5, output.Trace.ConsumedTime["total_build"])
+	require.Equal(t, int64(7), output.Counter("blob_compressed_size"))
+	require.Equal(t, int64(1), output.Counter("dedup_chunks"))
+	require.Zero(t, output.Counter("egid"))
+	require.Zero(t, output.Counter("unknown"))
+
+	// No blob is built if the blob file is empty.
+	blobPath := filepath.Join(dir, "blob")
+	require.NoError(t, os.WriteFile(blobPath, nil, 0644))
+	result, err := newBuildResult(output, blobPath)
+	require.NoError(t, err)
+	require.Empty(t, result.BlobID)
+
+	require.NoError(t, os.WriteFile(blobPath, []byte("blob"), 0644))
+	result, err = newBuildResult(output, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, "new", result.BlobID)
+	require.Equal(t, int64(4), result.BlobSize)
+
+	_, err = ParseOutput(filepath.Join(dir, "nonexistent.json"))
+	require.Error(t, err)
+}
+
+func TestParseOutputBlobs(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "output.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{
+		"unknown": {"nested": [1, {"blobs": ["ignored"]}], "empty": []},
+		"blobs": ["a", "b", "a"],
+		"fs_version": "5"
+	}`), 0644))
+
+	// The blobs are passed one by one rather than collected.
+	seen := map[string]bool{}
+	blobs := []string{}
+	output, err := ParseOutputBlobs(jsonPath, func(blobID string) error {
+		if !seen[blobID] {
+			seen[blobID] = true
+			blobs = append(blobs, blobID)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Nil(t, output.Blobs)
+	require.Equal(t, "5", output.FsVersion)
+	require.Equal(t, []string{"a", "b"}, blobs)
+
+	output, err = ParseOutputBlobs(jsonPath, nil)
+	require.NoError(t, err)
+	require.Equal(t, "5", output.FsVersion)
+
+	_, err = ParseOutputBlobs(jsonPath, func(string) error {
+		return errors.New("stop")
+	})
+	require.ErrorContains(t, err, "stop")
+
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"blobs": null}`), 0644))
+	output, err = ParseOutput(jsonPath)
+	require.NoError(t, err)
+	require.Empty(t, output.Blobs)
+
+	for _, data := range []string{`[]`, `{"blobs": "a"}`, `{"blobs": ["a"`, `{"version": 1}`} {
+		require.NoError(t, os.WriteFile(jsonPath, []byte(data), 0644))
+		_, err = ParseOutput(jsonPath)
+		require.Error(t, err, data)
+	}
+
+	// The output exceeding size limit is refused before parsing.
+	defer func(size int64) {
+		MaxOutputSize = size
+	}(MaxOutputSize)
+	MaxOutputSize = 8
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"blobs": ["a"]}`), 0644))
+	_, err = ParseOutput(jsonPath)
+	require.ErrorContains(t, err, "exceeding the limit 8 bytes")
+	MaxOutputSize = 0
+	_, err = ParseOutput(jsonPath)
+	require.NoError(t, err)
+}
@@ -5,10 +5,11 @@
 package build
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -23,6 +24,9 @@ type WorkflowOption struct {
 	FsVersion        string
 	Compressor       string
 	ChunkSize        string
+	// BuildTimeout kills the builder if a layer isn't built in time,
+	// zero means no timeout.
+	BuildTimeout time.Duration
 }
 
 type Workflow struct {
@@ -36,11 +40,6 @@ type Workflow struct {
 	lastBlobID          string
 }
 
-type debugJSON struct {
-	Version string
-	Blobs   []string
-}
-
 // Dump output json file of every layer to $workdir/bootstraps directory
 // for debug or perf analysis purpose
 func (workflow *Workflow) buildOutputJSONPath() string {
@@ -48,33 +47,25 @@ func (workflow *Workflow) buildOutputJSONPath() string {
 }
 
 // Get latest built blob from blobs directory
-func (workflow *Workflow) getLatestBlobPath() (string, error) {
-	var data debugJSON
-	jsonBytes, err := os.ReadFile(workflow.buildOutputJSONPath())
-	if err != nil {
-		return "", err
-	}
-	if err := json.Unmarshal(jsonBytes, &data); err != nil {
-		return "", err
-	}
-	blobIDs := data.Blobs
+func (workflow *Workflow) getLatestBlobPath(result *BuildResult) string {
+	blobIDs := result.Blobs
 
 	// Record builder version of current build environment for easy
 	// debugging and troubleshooting afterwards.
-	workflow.BuilderVersion = data.Version
+	workflow.BuilderVersion = result.Version
 
 	if len(blobIDs) == 0 {
-		return "", nil
+		return ""
 	}
 
 	latestBlobID := blobIDs[len(blobIDs)-1]
 	if latestBlobID != workflow.lastBlobID {
 		workflow.lastBlobID = latestBlobID
 		blobPath := filepath.Join(workflow.blobsDir, latestBlobID)
-		return blobPath, nil
+		return blobPath
 	}
 
-	return "", nil
+	return ""
 }
 
 // NewWorkflow prepare bootstrap and blobs path for layered build workflow
@@ -98,9 +89,10 @@ func NewWorkflow(option WorkflowOption) (*Workflow, error) {
 	}, nil
 }
 
-// Build nydus bootstrap and blob, returned blobPath's basename is sha256 hex string
+// Build nydus bootstrap and blob, returned blobPath's basename is sha256 hex string,
+// the builder is killed once ctx is done or the layer isn't built in BuildTimeout.
 func (workflow *Workflow) Build(
-	layerDir, whiteoutSpec, parentBootstrapPath, bootstrapPath string, alignedChunk bool,
+	ctx context.Context, layerDir, whiteoutSpec, parentBootstrapPath, bootstrapPath string, alignedChunk bool,
 ) (string, error) {
 	workflow.bootstrapPath = bootstrapPath
 
@@ -110,7 +102,7 @@ func (workflow *Workflow) Build(
 
 	blobPath := filepath.Join(workflow.blobsDir, uuid.NewString())
 
-	if err := workflow.builder.Run(BuilderOption{
+	result, err := workflow.builder.RunWithContext(ctx, BuilderOption{
 		ParentBootstrapPath: workflow.parentBootstrapPath,
 		BootstrapPath:       workflow.bootstrapPath,
 		RootfsPath:          layerDir,
@@ -123,16 +115,15 @@ func (workflow *Workflow) Build(
 		FsVersion:           workflow.FsVersion,
 		Compressor:          workflow.Compressor,
 		ChunkSize:           workflow.ChunkSize,
-	}); err != nil {
+		Timeout:             workflow.BuildTimeout,
+	})
+	if err != nil {
 		return "", errors.Wrapf(err, "build layer %s", layerDir)
 	}
 
 	workflow.parentBootstrapPath = workflow.bootstrapPath
 
-	digestedBlobPath, err := workflow.getLatestBlobPath()
-	if err != nil {
-		return "", errors.Wrap(err, "get latest blob")
-	}
+	digestedBlobPath := workflow.getLatestBlobPath(result)
 
 	logrus.Debugf("original: %s. digested: %s", blobPath, digestedBlobPath)
 
@@ -0,0 +1,495 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bundle packs a converted image with everything needed to run it,
+// i.e. its chunk dict images, prefetch files and the blobs in storage
+// backend, into one signed archive, which is carried into an air-gapped
+// network and published there by Unbundle.
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	"github.com/dustin/go-humanize"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/build"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/copier"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/transport"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const (
+	// IndexFile is the first entry of bundle archive, SignatureFile
+	// follows it if the bundle is signed.
+	IndexFile     = "bundle.json"
+	SignatureFile = IndexFile + ".sig"
+
+	indexVersion = 1
+
+	// The images are stored in an OCI image layout, so that the blobs
+	// shared by image and chunk dicts are stored once.
+	imagesDir   = "images"
+	blobsDir    = "blobs"
+	prefetchDir = "prefetch"
+
+	imageName = "image"
+)
+
+// File is a file in bundle archive, whose digest is verified on unbundle.
+type File struct {
+	Path   string        `json:"path"`
+	Digest digest.Digest `json:"digest"`
+	Size   int64         `json:"size"`
+}
+
+// Image is an image in the OCI layout of bundle, it's published to the
+// repository path of Reference under the target registry.
+type Image struct {
+	Reference string `json:"reference"`
+	// Name is the image name annotated in the OCI layout.
+	Name string `json:"name"`
+}
+
+// Index describes the content of bundle, the signature of bundle signs the
+// index, which covers the rest files by their digests.
+type Index struct {
+	Version    int       `json:"version"`
+	Created    time.Time `json:"created"`
+	Image      Image     `json:"image"`
+	ChunkDicts []Image   `json:"chunk_dicts,omitempty"`
+	// Blobs are the ids of blobs and blob meta artifacts from the storage
+	// backend, the blobs stored as image layers are in the OCI layout.
+	Blobs []string `json:"blobs,omitempty"`
+	// PrefetchFiles are the names of prefetch files in bundle.
+	PrefetchFiles []string `json:"prefetch_files,omitempty"`
+	Files         []File   `json:"files"`
+}
+
+type Opt struct {
+	WorkDir        string
+	NydusImagePath string
+
+	// Source is the converted image in registry, the chunk dict images
+	// referenced by its manifests are bundled too.
+	Source          string
+	SourceInsecure  bool
+	SourcePlainHTTP bool
+
+	// BackendType and BackendConfig specify the storage backend of Nydus
+	// blobs, the blobs referenced by bootstraps are bundled from it.
+	BackendType   string
+	BackendConfig string
+
+	// PrefetchFiles are the prefetch list files bundled by base name.
+	PrefetchFiles []string
+
+	// Output is the path of bundle archive.
+	Output string
+	// Sign signs the bundle if not nil.
+	Sign *Signing
+}
+
+func layoutReference(layoutDir, name string) string {
+	return fmt.Sprintf("%s:%s:%s", transport.OCI, layoutDir, name)
+}
+
+func readLayoutJSON(layoutDir string, desc ocispec.Descriptor, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(layoutDir, ocispec.ImageBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+	if err != nil {
+		return errors.Wrapf(err, "read %s", desc.Digest)
+	}
+	return errors.Wrapf(json.Unmarshal(data, v), "unmarshal %s", desc.Digest)
+}
+
+// layoutManifests returns the image manifests of the named image in OCI
+// layout.
+func layoutManifests(layoutDir, name string) ([]ocispec.Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(layoutDir, "index.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "read index json")
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrap(err, "unmarshal index json")
+	}
+	descs := []ocispec.Descriptor{}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[ocispec.AnnotationRefName] == name {
+			descs = append(descs, desc)
+		}
+	}
+	if len(descs) == 0 {
+		return nil, fmt.Errorf("image %s is not found in OCI layout", name)
+	}
+
+	manifests := []ocispec.Manifest{}
+	for len(descs) > 0 {
+		desc := descs[0]
+		descs = descs[1:]
+		switch {
+		case images.IsIndexType(desc.MediaType):
+			var index ocispec.Index
+			if err := readLayoutJSON(layoutDir, desc, &index); err != nil {
+				return nil, err
+			}
+			descs = append(descs, index.Manifests...)
+		case images.IsManifestType(desc.MediaType):
+			var manifest ocispec.Manifest
+			if err := readLayoutJSON(layoutDir, desc, &manifest); err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, manifest)
+		}
+	}
+	return manifests, nil
+}
+
+// copyImages copies the images from registry into the OCI layout one by
+// one, as the index of layout is updated by each image.
+func copyImages(ctx context.Context, toCopy []copier.Image, workDir string, opt copier.Opt) error {
+	opt.WorkDir = workDir
+	opt.AllPlatforms = true
+	opt.Images = toCopy
+	opt.ImageConcurrency = 1
+	return copier.Copy(ctx, opt)
+}
+
+// bootstrapBlobIDs returns the blob ids in the blob table of bootstrap
+// layer by `nydus-image check`.
+func bootstrapBlobIDs(layoutDir, workDir, nydusImagePath string, desc ocispec.Descriptor) ([]string, error) {
+	layer, err := os.Open(filepath.Join(layoutDir, ocispec.ImageBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+	if err != nil {
+		return nil, errors.Wrap(err, "open bootstrap layer")
+	}
+	defer layer.Close()
+	bootstrapPath := filepath.Join(workDir, "bootstrap")
+	if err := utils.UnpackFile(layer, utils.BootstrapFileNameInLayer, bootstrapPath); err != nil {
+		return nil, errors.Wrap(err, "unpack bootstrap layer")
+	}
+	outputPath := filepath.Join(workDir, "output.json")
+	if err := tool.NewBuilder(nydusImagePath).Check(tool.BuilderOption{
+		BootstrapPath:   bootstrapPath,
+		DebugOutputPath: outputPath,
+	}); err != nil {
+		return nil, errors.Wrap(err, "check bootstrap")
+	}
+	blobIDs := []string{}
+	if _, err := build.ParseOutputBlobs(outputPath, func(blobID string) error {
+		blobIDs = append(blobIDs, blobID)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return blobIDs, nil
+}
+
+// fetchBlob downloads the blob from backend into dir, the blob named by
+// digest is verified.
+func fetchBlob(bkd backend.Backend, blobID, dir string) error {
+	reader, err := bkd.Reader(blobID)
+	if err != nil {
+		return errors.Wrapf(err, "read blob %s", blobID)
+	}
+	defer reader.Close()
+	file, err := os.Create(filepath.Join(dir, blobID))
+	if err != nil {
+		return errors.Wrap(err, "create blob file")
+	}
+	defer file.Close()
+
+	digester := digest.SHA256.Digester()
+	size, err := io.Copy(io.MultiWriter(file, digester.Hash()), reader)
+	if err != nil {
+		return errors.Wrapf(err, "download blob %s", blobID)
+	}
+	if !strings.HasSuffix(blobID, utils.BlobMetaSuffix) && digester.Digest().Encoded() != blobID {
+		return fmt.Errorf("digest %s of blob mismatches blob id %s", digester.Digest(), blobID)
+	}
+	logrus.WithField("blob", blobID).WithField("size", humanize.Bytes(uint64(size))).Infof("bundled blob from backend")
+	return errors.Wrapf(file.Close(), "write blob %s", blobID)
+}
+
+// bundleBlobs downloads the blobs referenced by the bootstraps of manifests
+// from backend, together with their blob meta artifacts if any. The blobs
+// already stored as image layers are skipped.
+func bundleBlobs(bkd backend.Backend, manifests []ocispec.Manifest, layoutDir, stageDir, workDir, nydusImagePath string) ([]string, error) {
+	blobIDs := []string{}
+	seen := map[string]bool{}
+	for _, manifest := range manifests {
+		bootstrap := parser.FindNydusBootstrapDesc(&manifest)
+		if bootstrap == nil {
+			continue
+		}
+		ids, err := bootstrapBlobIDs(layoutDir, workDir, nydusImagePath, *bootstrap)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if _, err := os.Stat(filepath.Join(layoutDir, ocispec.ImageBlobsDir, string(digest.SHA256), id)); err == nil {
+				continue
+			}
+			blobIDs = append(blobIDs, id)
+			metaID := id + utils.BlobMetaSuffix
+			if exist, err := bkd.Check(metaID); err == nil && exist {
+				blobIDs = append(blobIDs, metaID)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(stageDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create blobs directory")
+	}
+	for _, id := range blobIDs {
+		if err := fetchBlob(bkd, id, stageDir); err != nil {
+			return nil, err
+		}
+	}
+	return blobIDs, nil
+}
+
+// bundlePrefetchFiles copies the prefetch files into dir by base name.
+func bundlePrefetchFiles(paths []string, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create prefetch directory")
+	}
+	names := []string{}
+	seen := map[string]bool{}
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if seen[name] {
+			return nil, fmt.Errorf("duplicated prefetch file name %s", name)
+		}
+		seen[name] = true
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "read prefetch file")
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return nil, errors.Wrap(err, "write prefetch file")
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// listFiles returns the files in directory with digests, in lexical order.
+func listFiles(dir string) ([]File, error) {
+	files := []File{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		digester := digest.SHA256.Digester()
+		size, err := io.Copy(digester.Hash(), file)
+		if err != nil {
+			return errors.Wrapf(err, "read %s", path)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Path: filepath.ToSlash(rel), Digest: digester.Digest(), Size: size})
+		return nil
+	})
+	return files, err
+}
+
+func addFile(tw *tar.Writer, name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	}); err != nil {
+		return errors.Wrapf(err, "write header of %s", name)
+	}
+	_, err = io.Copy(tw, file)
+	return errors.Wrapf(err, "write %s", name)
+}
+
+// writeArchive writes the index, the signature if any and the files in
+// stage directory into the bundle archive, in the order of index.
+func writeArchive(output, indexPath, sigPath, stageDir string, files []File) error {
+	archive, err := os.Create(output)
+	if err != nil {
+		return errors.Wrap(err, "create bundle archive")
+	}
+	defer archive.Close()
+
+	tw := tar.NewWriter(archive)
+	if err := addFile(tw, IndexFile, indexPath); err != nil {
+		return err
+	}
+	if sigPath != "" {
+		if err := addFile(tw, SignatureFile, sigPath); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		if err := addFile(tw, file.Path, filepath.Join(stageDir, filepath.FromSlash(file.Path))); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "close bundle archive")
+	}
+	return errors.Wrap(archive.Close(), "close bundle archive")
+}
+
+// Bundle packs the source image, its chunk dict images, the blobs in
+// storage backend and the prefetch files into the bundle archive.
+func Bundle(ctx context.Context, opt Opt) (*Index, error) {
+	source, err := transport.RegistryReference(opt.Source)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Output == "" {
+		return nil, fmt.Errorf("output path of bundle is required")
+	}
+	var bkd backend.Backend
+	if opt.BackendType != "" {
+		if opt.BackendType == "registry" {
+			return nil, fmt.Errorf("blobs in registry backend are bundled as image layers, specify other backend type")
+		}
+		if bkd, err = backend.NewBackend(opt.BackendType, []byte(opt.BackendConfig), nil); err != nil {
+			return nil, errors.Wrap(err, "create backend")
+		}
+	}
+
+	if err := os.MkdirAll(opt.WorkDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "prepare work directory")
+	}
+	tmpDir, err := utils.MkdirTemp(opt.WorkDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp directory")
+	}
+	defer os.RemoveAll(tmpDir)
+	// The OCI layout is referenced in `oci:path:name` form.
+	if strings.Contains(tmpDir, ":") {
+		return nil, fmt.Errorf("work directory %s should not contain colon", tmpDir)
+	}
+	stageDir := filepath.Join(tmpDir, "bundle")
+	layoutDir := filepath.Join(stageDir, imagesDir)
+
+	copyOpt := copier.Opt{
+		NydusImagePath:  opt.NydusImagePath,
+		SourceInsecure:  opt.SourceInsecure,
+		SourcePlainHTTP: opt.SourcePlainHTTP,
+	}
+	index := Index{
+		Version: indexVersion,
+		Created: time.Now().UTC(),
+		Image:   Image{Reference: source, Name: imageName},
+	}
+	logrus.Infof("bundling image %s", source)
+	if err := copyImages(ctx, []copier.Image{{Source: source, Target: layoutReference(layoutDir, imageName)}}, filepath.Join(tmpDir, "copy"), copyOpt); err != nil {
+		return nil, errors.Wrapf(err, "copy image %s", source)
+	}
+	manifests, err := layoutManifests(layoutDir, imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	// The chunk dict images are found by the annotation of manifests.
+	toCopy := []copier.Image{}
+	seen := map[string]bool{}
+	for _, manifest := range manifests {
+		ref := manifest.Annotations[utils.ManifestNydusChunkDictReference]
+		if ref == "" || seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		image := Image{Reference: ref, Name: fmt.Sprintf("chunk-dict-%d", len(index.ChunkDicts))}
+		index.ChunkDicts = append(index.ChunkDicts, image)
+		toCopy = append(toCopy, copier.Image{Source: ref, Target: layoutReference(layoutDir, image.Name)})
+		logrus.Infof("bundling chunk dict image %s", ref)
+	}
+	if len(toCopy) > 0 {
+		if err := copyImages(ctx, toCopy, filepath.Join(tmpDir, "copy"), copyOpt); err != nil {
+			return nil, errors.Wrap(err, "copy chunk dict images")
+		}
+		for _, image := range index.ChunkDicts {
+			dictManifests, err := layoutManifests(layoutDir, image.Name)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, dictManifests...)
+		}
+	}
+
+	if bkd != nil {
+		if index.Blobs, err = bundleBlobs(bkd, manifests, layoutDir, filepath.Join(stageDir, blobsDir), tmpDir, opt.NydusImagePath); err != nil {
+			return nil, errors.Wrap(err, "bundle blobs from backend")
+		}
+	}
+	if len(opt.PrefetchFiles) > 0 {
+		if index.PrefetchFiles, err = bundlePrefetchFiles(opt.PrefetchFiles, filepath.Join(stageDir, prefetchDir)); err != nil {
+			return nil, err
+		}
+	}
+
+	if index.Files, err = listFiles(stageDir); err != nil {
+		return nil, errors.Wrap(err, "list bundle files")
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal bundle index")
+	}
+	indexPath := filepath.Join(tmpDir, IndexFile)
+	if err := os.WriteFile(indexPath, data, 0644); err != nil {
+		return nil, errors.Wrap(err, "write bundle index")
+	}
+	sigPath := ""
+	if opt.Sign != nil {
+		sigPath = filepath.Join(tmpDir, SignatureFile)
+		logrus.Infof("signing bundle with key %s", opt.Sign.Key)
+		if err := opt.Sign.sign(ctx, indexPath, sigPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeArchive(opt.Output, indexPath, sigPath, stageDir, index.Files); err != nil {
+		os.Remove(opt.Output)
+		return nil, err
+	}
+	logrus.Infof("bundled %d chunk dict images, %d blobs and %d prefetch files with image %s into %s",
+		len(index.ChunkDicts), len(index.Blobs), len(index.PrefetchFiles), source, opt.Output)
+	return &index, nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// The fake cosign signs the file by the checksum, and only accepts the
+// signature of the private key paired with the public key.
+const cosignScript = `#!/bin/sh
+if [ "$1" = sign-blob ]; then
+	echo "signed by $5 $(cksum < "$8")" > "$7"
+	exit 0
+fi
+if [ "$(cat "$6")" = "signed by ${4%.pub}.key $(cksum < "$7")" ]; then
+	exit 0
+fi
+echo "Error: invalid signature" >&2
+exit 1
+`
+
+func putNydusImage(t *testing.T, registry *testutil.Registry, repo, tag string, layers []ocispec.Descriptor, annotations map[string]string) digest.Digest {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	data := []byte("bootstrap of " + repo)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: utils.BootstrapFileNameInLayer, Mode: 0644, Size: int64(len(data))}))
+	_, err := tw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	bootstrap := registry.PutBlob(repo, ocispec.MediaTypeImageLayerGzip, buf.Bytes())
+	bootstrap.Annotations = map[string]string{utils.LayerAnnotationNydusBootstrap: "true"}
+
+	desc, err := registry.PutManifest(repo, tag, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Config:      registry.PutBlob(repo, ocispec.MediaTypeImageConfig, []byte("{}")),
+		Layers:      append(layers, bootstrap),
+		Annotations: annotations,
+	})
+	require.NoError(t, err)
+	return desc.Digest
+}
+
+// rewriteArchive copies the archive with the data of entry replaced.
+func rewriteArchive(t *testing.T, src, dst, name string, data []byte) {
+	input, err := os.Open(src)
+	require.NoError(t, err)
+	defer input.Close()
+	output, err := os.Create(dst)
+	require.NoError(t, err)
+	defer output.Close()
+
+	tr := tar.NewReader(input)
+	tw := tar.NewWriter(output)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		if hdr.Name == name {
+			content = data
+			hdr.Size = int64(len(data))
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+}
+
+func TestBundle(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	host := registry.Host()
+	dir := t.TempDir()
+
+	layerBlob := registry.PutBlob("app", utils.MediaTypeNydusBlob, []byte("blob in registry"))
+	dictDigest := putNydusImage(t, registry, "dict", "v1", nil, nil)
+	appDigest := putNydusImage(t, registry, "app", "v1-nydus", []ocispec.Descriptor{layerBlob}, map[string]string{
+		utils.ManifestNydusChunkDictReference: host + "/dict:v1",
+	})
+
+	sourceDir := filepath.Join(dir, "source-backend")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	blobData := []byte("blob in backend")
+	blobID := digest.FromBytes(blobData).Encoded()
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, blobID), blobData, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, blobID+utils.BlobMetaSuffix), []byte("meta"), 0644))
+
+	stub, err := testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{
+		Blobs: []string{blobID, layerBlob.Digest.Encoded()},
+	})
+	require.NoError(t, err)
+	cosignPath := filepath.Join(dir, "cosign")
+	require.NoError(t, os.WriteFile(cosignPath, []byte(cosignScript), 0755))
+	prefetchPath := filepath.Join(dir, "app.prefetch")
+	require.NoError(t, os.WriteFile(prefetchPath, []byte("/usr/bin\n"), 0644))
+
+	ctx := context.Background()
+	output := filepath.Join(dir, "app.bundle.tar")
+	index, err := Bundle(ctx, Opt{
+		WorkDir:         filepath.Join(dir, "work"),
+		NydusImagePath:  stub.Path,
+		Source:          host + "/app:v1-nydus",
+		SourcePlainHTTP: true,
+		BackendType:     "localfs",
+		BackendConfig:   `{"dir": "` + sourceDir + `"}`,
+		PrefetchFiles:   []string{prefetchPath},
+		Output:          output,
+		Sign:            &Signing{CosignPath: cosignPath, Key: "cosign.key"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []Image{{Reference: host + "/dict:v1", Name: "chunk-dict-0"}}, index.ChunkDicts)
+	// The blob in registry is bundled as image layer.
+	require.Equal(t, []string{blobID, blobID + utils.BlobMetaSuffix}, index.Blobs)
+	require.Equal(t, []string{"app.prefetch"}, index.PrefetchFiles)
+
+	targetDir := filepath.Join(dir, "target-backend")
+	require.NoError(t, os.MkdirAll(targetDir, 0755))
+	opt := UnbundleOpt{
+		WorkDir:         filepath.Join(dir, "work"),
+		Input:           output,
+		TargetRegistry:  host + "/mirror",
+		TargetPlainHTTP: true,
+		BackendType:     "localfs",
+		BackendConfig:   `{"dir": "` + targetDir + `"}`,
+		PrefetchDir:     filepath.Join(dir, "prefetch"),
+		Verify:          &Verification{CosignPath: cosignPath, Key: "other.pub"},
+	}
+	_, err = Unbundle(ctx, opt)
+	require.ErrorContains(t, err, "bundle is not signed as expected: Error: invalid signature")
+
+	opt.Verify.Key = "cosign.pub"
+	_, err = Unbundle(ctx, opt)
+	require.NoError(t, err)
+	data, _, ok := registry.Manifest("mirror/app", "v1-nydus")
+	require.True(t, ok)
+	require.Equal(t, appDigest, digest.FromBytes(data))
+	data, _, ok = registry.Manifest("mirror/dict", "v1")
+	require.True(t, ok)
+	require.Equal(t, dictDigest, digest.FromBytes(data))
+	_, ok = registry.Blob("mirror/app", layerBlob.Digest)
+	require.True(t, ok)
+	data, err = os.ReadFile(filepath.Join(targetDir, blobID))
+	require.NoError(t, err)
+	require.Equal(t, blobData, data)
+	data, err = os.ReadFile(filepath.Join(targetDir, blobID+utils.BlobMetaSuffix))
+	require.NoError(t, err)
+	require.Equal(t, []byte("meta"), data)
+	data, err = os.ReadFile(filepath.Join(dir, "prefetch", "app.prefetch"))
+	require.NoError(t, err)
+	require.Equal(t, "/usr/bin\n", string(data))
+
+	// The tampered file is detected by digest.
+	tampered := filepath.Join(dir, "tampered.tar")
+	rewriteArchive(t, output, tampered, blobsDir+"/"+blobID, []byte("tampered blob"))
+	opt.Input = tampered
+	_, err = Unbundle(ctx, opt)
+	require.ErrorContains(t, err, "file blobs/"+blobID+" in bundle is corrupted")
+
+	// The tampered index is detected by signature.
+	rewriteArchive(t, output, tampered, IndexFile, []byte(`{"version": 1}`))
+	_, err = Unbundle(ctx, opt)
+	require.ErrorContains(t, err, "bundle is not signed as expected")
+
+	opt.Input = output
+	opt.BackendType = ""
+	_, err = Unbundle(ctx, opt)
+	require.ErrorContains(t, err, "bundle contains 2 blobs of storage backend, backend type is required")
+}
+
+func TestTargetReference(t *testing.T) {
+	for _, tc := range []struct {
+		ref    string
+		target string
+	}{
+		{"registry.example.com/app:v1-nydus", "registry.local:5000/app:v1-nydus"},
+		{"ubuntu", "registry.local:5000/library/ubuntu:latest"},
+		{"registry.example.com/ns/app@sha256:" + digest.FromString("app").Encoded(), "registry.local:5000/ns/app@sha256:" + digest.FromString("app").Encoded()},
+	} {
+		target, err := TargetReference("registry.local:5000/", tc.ref)
+		require.NoError(t, err)
+		require.Equal(t, tc.target, target)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultCosignPath = "cosign"
+
+// Signing signs the index of bundle by cosign with a key pair. The bundle
+// is verified in the isolated network without access to the transparency
+// log, so the signature isn't uploaded to it.
+type Signing struct {
+	// CosignPath is the path of cosign binary, default to search in PATH.
+	CosignPath string
+	// Key is the path or KMS URI of the private key, the password of key
+	// file is read by cosign from the COSIGN_PASSWORD environment.
+	Key string
+}
+
+// Verification verifies the signature of bundle index by cosign with the
+// public key of signing key pair.
+type Verification struct {
+	// CosignPath is the path of cosign binary, default to search in PATH.
+	CosignPath string
+	// Key is the path or KMS URI of the public key.
+	Key string
+}
+
+func runCosign(ctx context.Context, cosignPath string, args ...string) (string, error) {
+	if cosignPath == "" {
+		cosignPath = defaultCosignPath
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cosignPath, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return strings.TrimSpace(stderr.String()), err
+		}
+		return "", errors.Wrapf(err, "run %s", cosignPath)
+	}
+	return "", nil
+}
+
+// sign writes the signature of file into sigPath.
+func (signing *Signing) sign(ctx context.Context, path, sigPath string) error {
+	stderr, err := runCosign(ctx, signing.CosignPath,
+		"sign-blob", "--yes", "--tlog-upload=false", "--key", signing.Key, "--output-signature", sigPath, path,
+	)
+	if err != nil && stderr != "" {
+		return fmt.Errorf("failed to sign bundle: %s", stderr)
+	}
+	return err
+}
+
+// verify verifies the signature in sigPath of file.
+func (verification *Verification) verify(ctx context.Context, path, sigPath string) error {
+	stderr, err := runCosign(ctx, verification.CosignPath,
+		"verify-blob", "--insecure-ignore-tlog=true", "--key", verification.Key, "--signature", sigPath, path,
+	)
+	if err != nil && stderr != "" {
+		return fmt.Errorf("bundle is not signed as expected: %s", stderr)
+	}
+	return err
+}
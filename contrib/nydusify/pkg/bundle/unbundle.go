@@ -0,0 +1,340 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/copier"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// maxIndexSize limits the size of index read into memory.
+const maxIndexSize = 64 << 20
+
+type UnbundleOpt struct {
+	WorkDir string
+
+	// Input is the path of bundle archive.
+	Input string
+
+	// TargetRegistry is the registry host with optional namespace, e.g.
+	// `registry.local:5000/mirror`, the images are published to their
+	// repository paths and tags under it.
+	TargetRegistry  string
+	TargetInsecure  bool
+	TargetPlainHTTP bool
+
+	// BackendType and BackendConfig specify the storage backend to upload
+	// the bundled blobs, which is required if the bundle contains blobs.
+	BackendType      string
+	BackendConfig    string
+	BackendForcePush bool
+
+	// PrefetchDir is the directory to write the prefetch files into.
+	PrefetchDir string
+
+	// Verify verifies the signature of bundle before publishing anything,
+	// the unsigned bundle is refused. The signature isn't verified if nil.
+	Verify *Verification
+}
+
+// TargetReference returns the reference of image published under target
+// registry, which keeps the repository path and the tag or digest.
+func TargetReference(registry, ref string) (string, error) {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse reference %s", ref)
+	}
+	target := strings.TrimSuffix(registry, "/") + "/" + docker.Path(named)
+	if tagged, ok := named.(docker.Tagged); ok {
+		target += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(docker.Digested); ok {
+		target += "@" + digested.Digest().String()
+	}
+	if _, err := docker.ParseDockerRef(target); err != nil {
+		return "", errors.Wrapf(err, "invalid target reference %s", target)
+	}
+	return target, nil
+}
+
+func writeEntry(reader io.Reader, path string) (digest.Digest, int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", 0, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	digester := digest.SHA256.Digester()
+	size, err := io.Copy(io.MultiWriter(file, digester.Hash()), reader)
+	if err != nil {
+		return "", 0, err
+	}
+	return digester.Digest(), size, file.Close()
+}
+
+// validPath checks the file path in index is inside one of the bundle
+// directories.
+func validPath(name string) bool {
+	if name != path.Clean(name) || path.IsAbs(name) || strings.HasPrefix(name, "../") {
+		return false
+	}
+	for _, dir := range []string{imagesDir, blobsDir, prefetchDir} {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// readIndex reads the index and signature at the head of bundle archive,
+// and verifies the signature, the header of the first file entry is
+// returned.
+func readIndex(ctx context.Context, tr *tar.Reader, tmpDir string, verification *Verification) (*Index, *tar.Header, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read bundle archive")
+	}
+	if hdr.Name != IndexFile {
+		return nil, nil, fmt.Errorf("invalid bundle archive, the first entry should be %s but got %s", IndexFile, hdr.Name)
+	}
+	if hdr.Size > maxIndexSize {
+		return nil, nil, fmt.Errorf("bundle index is too large, %d bytes", hdr.Size)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read bundle index")
+	}
+	indexPath := filepath.Join(tmpDir, IndexFile)
+	if err := os.WriteFile(indexPath, data, 0644); err != nil {
+		return nil, nil, errors.Wrap(err, "write bundle index")
+	}
+
+	sigPath := ""
+	hdr, err = tr.Next()
+	if err == nil && hdr.Name == SignatureFile {
+		sigPath = filepath.Join(tmpDir, SignatureFile)
+		if _, _, err := writeEntry(io.LimitReader(tr, maxIndexSize), sigPath); err != nil {
+			return nil, nil, errors.Wrap(err, "write bundle signature")
+		}
+		hdr, err = tr.Next()
+	}
+	if err == io.EOF {
+		hdr, err = nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read bundle archive")
+	}
+
+	if verification != nil {
+		if sigPath == "" {
+			return nil, nil, fmt.Errorf("bundle is not signed")
+		}
+		if err := verification.verify(ctx, indexPath, sigPath); err != nil {
+			return nil, nil, err
+		}
+		logrus.Infof("verified signature of bundle with key %s", verification.Key)
+	} else {
+		logrus.Warnf("signature of bundle is not verified")
+	}
+
+	var index Index
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&index); err != nil {
+		return nil, nil, errors.Wrap(err, "unmarshal bundle index")
+	}
+	if index.Version != indexVersion {
+		return nil, nil, fmt.Errorf("unsupported bundle version %d", index.Version)
+	}
+	return &index, hdr, nil
+}
+
+// extract extracts the files of bundle archive into stage directory, the
+// files are verified by the digests in index.
+func extract(ctx context.Context, input, tmpDir, stageDir string, verification *Verification) (*Index, error) {
+	archive, err := os.Open(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "open bundle archive")
+	}
+	defer archive.Close()
+
+	tr := tar.NewReader(archive)
+	index, hdr, err := readIndex(ctx, tr, tmpDir, verification)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]File{}
+	for _, file := range index.Files {
+		if !validPath(file.Path) {
+			return nil, fmt.Errorf("invalid file path %s in bundle index", file.Path)
+		}
+		files[file.Path] = file
+	}
+	// The blobs and prefetch files are published by name.
+	for _, blobID := range index.Blobs {
+		if _, ok := files[blobsDir+"/"+blobID]; !ok || path.Base(blobID) != blobID {
+			return nil, fmt.Errorf("invalid blob %s in bundle index", blobID)
+		}
+	}
+	for _, name := range index.PrefetchFiles {
+		if _, ok := files[prefetchDir+"/"+name]; !ok || path.Base(name) != name {
+			return nil, fmt.Errorf("invalid prefetch file %s in bundle index", name)
+		}
+	}
+
+	for ; hdr != nil; hdr, err = tr.Next() {
+		file, ok := files[hdr.Name]
+		if !ok {
+			return nil, fmt.Errorf("unexpected entry %s in bundle archive", hdr.Name)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("entry %s in bundle archive is not a regular file", hdr.Name)
+		}
+		delete(files, hdr.Name)
+		dgst, size, err := writeEntry(tr, filepath.Join(stageDir, filepath.FromSlash(hdr.Name)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "extract %s", hdr.Name)
+		}
+		if dgst != file.Digest || size != file.Size {
+			return nil, fmt.Errorf("file %s in bundle is corrupted, expected digest %s but got %s", hdr.Name, file.Digest, dgst)
+		}
+	}
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "read bundle archive")
+	}
+	if len(files) > 0 {
+		return nil, fmt.Errorf("%d files are missing in bundle archive", len(files))
+	}
+	return index, nil
+}
+
+// uploadBlobs uploads the bundled blobs to backend, the blobs existing in
+// backend are skipped unless force push.
+func uploadBlobs(ctx context.Context, bkd backend.Backend, blobIDs []string, dir string, forcePush bool) error {
+	for _, blobID := range blobIDs {
+		if !forcePush {
+			exist, err := bkd.Check(blobID)
+			if err != nil {
+				return errors.Wrapf(err, "check blob %s in backend", blobID)
+			}
+			if exist {
+				logrus.WithField("blob", blobID).Infof("skip blob existed in backend")
+				continue
+			}
+		}
+		path := filepath.Join(dir, blobID)
+		info, err := os.Stat(path)
+		if err != nil {
+			return errors.Wrapf(err, "stat blob %s", blobID)
+		}
+		if _, err := backend.Upload(ctx, bkd, blobID, path, info.Size(), true); err != nil {
+			return errors.Wrapf(err, "upload blob %s", blobID)
+		}
+	}
+	return nil
+}
+
+// Unbundle verifies and extracts the bundle archive, then publishes its
+// content in the isolated network. The blobs are uploaded to backend
+// before the images referencing them, and the chunk dict images before the
+// image, the prefetch files are written into PrefetchDir.
+func Unbundle(ctx context.Context, opt UnbundleOpt) (*Index, error) {
+	if opt.TargetRegistry == "" {
+		return nil, fmt.Errorf("target registry is required")
+	}
+	if err := os.MkdirAll(opt.WorkDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "prepare work directory")
+	}
+	tmpDir, err := utils.MkdirTemp(opt.WorkDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp directory")
+	}
+	defer os.RemoveAll(tmpDir)
+	if strings.Contains(tmpDir, ":") {
+		return nil, fmt.Errorf("work directory %s should not contain colon", tmpDir)
+	}
+	stageDir := filepath.Join(tmpDir, "bundle")
+
+	index, err := extract(ctx, opt.Input, tmpDir, stageDir, opt.Verify)
+	if err != nil {
+		return nil, err
+	}
+	if len(index.Blobs) > 0 && opt.BackendType == "" {
+		return nil, fmt.Errorf("bundle contains %d blobs of storage backend, backend type is required", len(index.Blobs))
+	}
+	if len(index.PrefetchFiles) > 0 && opt.PrefetchDir == "" {
+		return nil, fmt.Errorf("bundle contains %d prefetch files, prefetch directory is required", len(index.PrefetchFiles))
+	}
+
+	toCopy := []copier.Image{}
+	for _, image := range append(append([]Image{}, index.ChunkDicts...), index.Image) {
+		target, err := TargetReference(opt.TargetRegistry, image.Reference)
+		if err != nil {
+			return nil, err
+		}
+		toCopy = append(toCopy, copier.Image{Source: layoutReference(filepath.Join(stageDir, imagesDir), image.Name), Target: target})
+	}
+
+	if len(index.Blobs) > 0 {
+		bkd, err := backend.NewBackend(opt.BackendType, []byte(opt.BackendConfig), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "create backend")
+		}
+		if err := uploadBlobs(ctx, bkd, index.Blobs, filepath.Join(stageDir, blobsDir), opt.BackendForcePush); err != nil {
+			return nil, err
+		}
+		logrus.Infof("uploaded %d blobs to backend", len(index.Blobs))
+	}
+
+	if err := copier.Copy(ctx, copier.Opt{
+		WorkDir:          filepath.Join(tmpDir, "copy"),
+		TargetInsecure:   opt.TargetInsecure,
+		TargetPlainHTTP:  opt.TargetPlainHTTP,
+		AllPlatforms:     true,
+		Images:           toCopy,
+		ImageConcurrency: 1,
+	}); err != nil {
+		return nil, errors.Wrap(err, "publish images")
+	}
+	for _, image := range toCopy {
+		logrus.Infof("published image %s", image.Target)
+	}
+
+	if len(index.PrefetchFiles) > 0 {
+		if err := os.MkdirAll(opt.PrefetchDir, 0755); err != nil {
+			return nil, errors.Wrap(err, "create prefetch directory")
+		}
+		for _, name := range index.PrefetchFiles {
+			data, err := os.ReadFile(filepath.Join(stageDir, prefetchDir, name))
+			if err != nil {
+				return nil, errors.Wrapf(err, "read prefetch file %s", name)
+			}
+			if err := os.WriteFile(filepath.Join(opt.PrefetchDir, name), data, 0644); err != nil {
+				return nil, errors.Wrapf(err, "write prefetch file %s", name)
+			}
+		}
+		logrus.Infof("wrote %d prefetch files into %s", len(index.PrefetchFiles), opt.PrefetchDir)
+	}
+	return index, nil
+}
@@ -45,6 +45,9 @@ type Opt struct {
 	// the backend be specified, because the blob layer will be uploaded
 	// to backend.
 	Backend backend.Backend
+	// Digest algorithm used for the config and manifest of cache image,
+	// default to sha256.
+	DigestAlgorithm digest.Algorithm
 }
 
 // Cache creates an image to store cache records in its image manifest,
@@ -182,7 +185,7 @@ func (cache *Cache) exportRecordsToLayers() []ocispec.Descriptor {
 		for _, blobID := range referenceBlobIDs {
 			// for oss backend, GetReference always return nil
 			// for registry backend, GetReference should not return nil
-			referenceRecord := cache.GetReference(digest.NewDigestFromEncoded(digest.SHA256, blobID))
+			referenceRecord := cache.GetReference(utils.DigestFromEncoded(blobID))
 			if referenceRecord != nil {
 				_, blobDesc := cache.recordToLayer(referenceRecord)
 				referenceLayers = append(referenceLayers, *blobDesc)
@@ -388,7 +391,7 @@ func (cache *Cache) Export(ctx context.Context) error {
 			DiffIDs: diffIDs,
 		},
 	}
-	configDesc, configBytes, err := utils.MarshalToDesc(config, configMediaType)
+	configDesc, configBytes, err := utils.MarshalToDescWithAlgorithm(config, configMediaType, cache.opt.DigestAlgorithm)
 	if err != nil {
 		return errors.Wrap(err, "Marshal cache config")
 	}
@@ -419,7 +422,7 @@ func (cache *Cache) Export(ctx context.Context) error {
 		},
 	}
 
-	manifestDesc, manifestBytes, err := utils.MarshalToDesc(manifest, manifest.MediaType)
+	manifestDesc, manifestBytes, err := utils.MarshalToDescWithAlgorithm(manifest, manifest.MediaType, cache.opt.DigestAlgorithm)
 	if err != nil {
 		return errors.Wrap(err, "Push cache manifest")
 	}
@@ -0,0 +1,282 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// LocalRecord records the layer converted from source layer with the build
+// options.
+type LocalRecord struct {
+	Source digest.Digest `json:"source"`
+	// Options identifies the build options of layer.
+	Options    string        `json:"options"`
+	Target     digest.Digest `json:"target"`
+	TargetSize int64         `json:"target_size"`
+	Created    time.Time     `json:"created"`
+}
+
+// LocalCache is the build cache persisted in local directory, which stores
+// the converted layers keyed by source layer digest and build options, so
+// that the layers finished by a failed conversion are reused by the re-run.
+// The directory layout is:
+//
+//	<dir>/records/<sha256 of source and options>.json
+//	<dir>/blobs/sha256/<hex of converted layer>
+//
+// The modification time of record is refreshed on hit, which is used as
+// the last used time by Prune.
+type LocalCache struct {
+	dir string
+}
+
+// DefaultLocalCacheDir returns `~/.nydusify/cache`.
+func DefaultLocalCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "nydusify", "cache")
+	}
+	return filepath.Join(home, ".nydusify", "cache")
+}
+
+// NewLocalCache opens the local cache in dir, it's created if not exist.
+func NewLocalCache(dir string) (*LocalCache, error) {
+	cache := &LocalCache{dir: dir}
+	for _, sub := range []string{cache.recordDir(), filepath.Join(dir, "blobs", "sha256")} {
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			return nil, errors.Wrap(err, "create local cache directory")
+		}
+	}
+	return cache, nil
+}
+
+func (cache *LocalCache) recordDir() string {
+	return filepath.Join(cache.dir, "records")
+}
+
+func (cache *LocalCache) recordPath(source digest.Digest, options string) string {
+	key := digest.FromString(source.String() + "\n" + options)
+	return filepath.Join(cache.recordDir(), key.Encoded()+".json")
+}
+
+// BlobPath returns the path of converted layer in cache.
+func (cache *LocalCache) BlobPath(target digest.Digest) string {
+	return filepath.Join(cache.dir, "blobs", target.Algorithm().String(), target.Encoded())
+}
+
+// Get returns the record of source layer with the build options, nil is
+// returned if it's not cached or the converted layer is missing.
+func (cache *LocalCache) Get(source digest.Digest, options string) (*LocalRecord, error) {
+	path := cache.recordPath(source, options)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "read local cache record")
+	}
+	var record LocalRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal local cache record %s", path)
+	}
+	info, err := os.Stat(cache.BlobPath(record.Target))
+	if err != nil || info.Size() != record.TargetSize {
+		return nil, nil
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return nil, errors.Wrap(err, "touch local cache record")
+	}
+	return &record, nil
+}
+
+// writeFile writes the file by renaming a temp file, so that the partial
+// file is never visible.
+func writeFile(path string, reader io.Reader) (int64, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	size, err := io.Copy(file, reader)
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err != nil {
+		return 0, err
+	}
+	return size, os.Rename(file.Name(), path)
+}
+
+// Put stores the converted layer read from reader, and records it for the
+// source layer with the build options.
+func (cache *LocalCache) Put(source digest.Digest, options string, target digest.Digest, reader io.Reader) error {
+	if err := target.Validate(); err != nil {
+		return errors.Wrap(err, "invalid converted layer digest")
+	}
+	blobPath := cache.BlobPath(target)
+	info, err := os.Stat(blobPath)
+	if err != nil {
+		verifier := target.Verifier()
+		if _, err := writeFile(blobPath, io.TeeReader(reader, verifier)); err != nil {
+			return errors.Wrap(err, "write converted layer to local cache")
+		}
+		if !verifier.Verified() {
+			os.Remove(blobPath)
+			return errors.Errorf("converted layer %s is corrupted", target)
+		}
+		if info, err = os.Stat(blobPath); err != nil {
+			return errors.Wrap(err, "stat converted layer in local cache")
+		}
+	}
+
+	data, err := json.Marshal(LocalRecord{
+		Source:     source,
+		Options:    options,
+		Target:     target,
+		TargetSize: info.Size(),
+		Created:    time.Now(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal local cache record")
+	}
+	if _, err := writeFile(cache.recordPath(source, options), bytes.NewReader(data)); err != nil {
+		return errors.Wrap(err, "write local cache record")
+	}
+	return nil
+}
+
+// PruneResult is the statistics of pruned cache.
+type PruneResult struct {
+	Records int
+	Blobs   int
+	// Bytes is the size of removed blobs.
+	Bytes int64
+}
+
+type prunedRecord struct {
+	path    string
+	target  digest.Digest
+	lastUse time.Time
+}
+
+// Prune removes the records not used for maxAge, then the least recently
+// used records until the size of blobs is within maxSize, and the blobs
+// no longer referenced. Zero maxAge or maxSize means no limit, both zero
+// removes nothing but the unreferenced blobs.
+func (cache *LocalCache) Prune(maxAge time.Duration, maxSize int64) (*PruneResult, error) {
+	result := &PruneResult{}
+	entries, err := os.ReadDir(cache.recordDir())
+	if err != nil {
+		return nil, errors.Wrap(err, "read local cache records")
+	}
+	records := []prunedRecord{}
+	now := time.Now()
+	for _, entry := range entries {
+		path := filepath.Join(cache.recordDir(), entry.Name())
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || filepath.Ext(path) != ".json" {
+			continue
+		}
+		var record LocalRecord
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &record)
+		}
+		if err != nil || (maxAge > 0 && now.Sub(info.ModTime()) > maxAge) {
+			if err := os.Remove(path); err != nil {
+				return nil, errors.Wrap(err, "remove local cache record")
+			}
+			result.Records++
+			continue
+		}
+		records = append(records, prunedRecord{path: path, target: record.Target, lastUse: info.ModTime()})
+	}
+
+	blobs, err := cache.blobSizes()
+	if err != nil {
+		return nil, err
+	}
+	referenced := map[digest.Digest]int{}
+	for _, record := range records {
+		referenced[record.target]++
+	}
+	var total int64
+	for dgst, size := range blobs {
+		if referenced[dgst] > 0 {
+			total += size
+		}
+	}
+	if maxSize > 0 && total > maxSize {
+		sort.Slice(records, func(i, j int) bool {
+			return records[i].lastUse.Before(records[j].lastUse)
+		})
+		for _, record := range records {
+			if total <= maxSize {
+				break
+			}
+			if err := os.Remove(record.path); err != nil {
+				return nil, errors.Wrap(err, "remove local cache record")
+			}
+			result.Records++
+			if referenced[record.target]--; referenced[record.target] == 0 {
+				total -= blobs[record.target]
+			}
+		}
+	}
+
+	for dgst, size := range blobs {
+		if referenced[dgst] > 0 {
+			continue
+		}
+		if err := os.Remove(cache.BlobPath(dgst)); err != nil {
+			return nil, errors.Wrap(err, "remove converted layer in local cache")
+		}
+		result.Blobs++
+		result.Bytes += size
+	}
+	return result, nil
+}
+
+func (cache *LocalCache) blobSizes() (map[digest.Digest]int64, error) {
+	dir := filepath.Join(cache.dir, "blobs", "sha256")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read local cache blobs")
+	}
+	blobs := map[digest.Digest]int64{}
+	for _, entry := range entries {
+		dgst := digest.NewDigestFromEncoded(digest.SHA256, entry.Name())
+		info, err := entry.Info()
+		if err != nil || dgst.Validate() != nil {
+			// The temp files left by crashed runs are removed as well.
+			if time.Since(modTime(info)) > time.Hour {
+				os.Remove(filepath.Join(dir, entry.Name()))
+			}
+			continue
+		}
+		blobs[dgst] = info.Size()
+	}
+	return blobs, nil
+}
+
+func modTime(info os.FileInfo) time.Time {
+	if info == nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalCache(t *testing.T) {
+	localCache, err := NewLocalCache(t.TempDir())
+	require.NoError(t, err)
+
+	source := digest.FromString("source")
+	data := []byte("converted")
+	target := digest.FromBytes(data)
+
+	record, err := localCache.Get(source, "options")
+	require.NoError(t, err)
+	require.Nil(t, record)
+
+	require.Error(t, localCache.Put(source, "options", target, bytes.NewReader([]byte("corrupted"))))
+	require.NoError(t, localCache.Put(source, "options", target, bytes.NewReader(data)))
+
+	record, err = localCache.Get(source, "options")
+	require.NoError(t, err)
+	require.Equal(t, target, record.Target)
+	require.Equal(t, int64(len(data)), record.TargetSize)
+	cached, err := os.ReadFile(localCache.BlobPath(target))
+	require.NoError(t, err)
+	require.Equal(t, data, cached)
+
+	// The layers built with different options are cached separately.
+	record, err = localCache.Get(source, "other")
+	require.NoError(t, err)
+	require.Nil(t, record)
+
+	// The record is missed if the converted layer is removed.
+	require.NoError(t, os.Remove(localCache.BlobPath(target)))
+	record, err = localCache.Get(source, "options")
+	require.NoError(t, err)
+	require.Nil(t, record)
+}
+
+func TestLocalCachePrune(t *testing.T) {
+	localCache, err := NewLocalCache(t.TempDir())
+	require.NoError(t, err)
+
+	put := func(name string, size int, lastUse time.Time) digest.Digest {
+		data := bytes.Repeat([]byte(name), size)
+		target := digest.FromBytes(data)
+		source := digest.FromString(name)
+		require.NoError(t, localCache.Put(source, "options", target, bytes.NewReader(data)))
+		path := localCache.recordPath(source, "options")
+		require.NoError(t, os.Chtimes(path, lastUse, lastUse))
+		return source
+	}
+	now := time.Now()
+	old := put("a", 100, now.Add(-48*time.Hour))
+	lru := put("b", 100, now.Add(-2*time.Hour))
+	recent := put("c", 100, now.Add(-time.Hour))
+
+	result, err := localCache.Prune(0, 0)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{}, *result)
+
+	result, err = localCache.Prune(24*time.Hour, 150)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{Records: 2, Blobs: 2, Bytes: 200}, *result)
+
+	for source, hit := range map[digest.Digest]bool{old: false, lru: false, recent: true} {
+		record, err := localCache.Get(source, "options")
+		require.NoError(t, err)
+		require.Equal(t, hit, record != nil)
+	}
+}
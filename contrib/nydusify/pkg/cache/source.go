@@ -0,0 +1,154 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// SourceCache stores the source layers downloaded by conversions in local
+// directory keyed by digest, so that the conversions of many tags of the
+// same application don't download the identical base layers again. The
+// directory layout is:
+//
+//	<dir>/sources/sha256/<hex of source layer>
+//
+// which doesn't collide with LocalCache, so they can share a directory.
+// The modification time of layer is refreshed on hit, and the least
+// recently used layers are evicted on Put to keep the cache within the
+// size cap.
+type SourceCache struct {
+	dir     string
+	maxSize int64
+	// mutex serializes the eviction in process, the concurrent processes
+	// sharing the directory are safe as the files are replaced by rename
+	// and the opened files survive the removal.
+	mutex sync.Mutex
+}
+
+// NewSourceCache opens the source layer cache in dir, it's created if not
+// exist. Zero maxSize means no limit.
+func NewSourceCache(dir string, maxSize int64) (*SourceCache, error) {
+	cache := &SourceCache{dir: dir, maxSize: maxSize}
+	if err := os.MkdirAll(filepath.Join(cache.dir, "sources", "sha256"), 0755); err != nil {
+		return nil, errors.Wrap(err, "create source cache directory")
+	}
+	return cache, nil
+}
+
+// BlobPath returns the path of source layer in cache.
+func (cache *SourceCache) BlobPath(dgst digest.Digest) string {
+	return filepath.Join(cache.dir, "sources", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// Get returns whether the source layer of size is cached, and marks it as
+// recently used.
+func (cache *SourceCache) Get(dgst digest.Digest, size int64) bool {
+	if dgst.Validate() != nil {
+		return false
+	}
+	path := cache.BlobPath(dgst)
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != size {
+		return false
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		logrus.WithError(err).Warnf("failed to touch source layer %s in cache", dgst)
+	}
+	return true
+}
+
+// Put stores the source layer read from reader, then evicts the least
+// recently used layers if the cache exceeds the size cap. The layer alone
+// exceeding the cap isn't stored, to not evict all others for it.
+func (cache *SourceCache) Put(dgst digest.Digest, reader io.Reader) error {
+	if err := dgst.Validate(); err != nil {
+		return errors.Wrap(err, "invalid source layer digest")
+	}
+	if dgst.Algorithm() != digest.SHA256 {
+		return nil
+	}
+	path := cache.BlobPath(dgst)
+	if _, err := os.Stat(path); err != nil {
+		verifier := dgst.Verifier()
+		size, err := writeFile(path, io.TeeReader(reader, verifier))
+		if err != nil {
+			return errors.Wrap(err, "write source layer to cache")
+		}
+		if !verifier.Verified() {
+			os.Remove(path)
+			return errors.Errorf("source layer %s is corrupted", dgst)
+		}
+		if cache.maxSize > 0 && size > cache.maxSize {
+			os.Remove(path)
+			logrus.Debugf("source layer %s exceeds the cache size cap", dgst)
+			return nil
+		}
+	}
+	return cache.evict()
+}
+
+type cachedSource struct {
+	path    string
+	size    int64
+	lastUse time.Time
+}
+
+func (cache *SourceCache) evict() error {
+	if cache.maxSize <= 0 {
+		return nil
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	dir := filepath.Join(cache.dir, "sources", "sha256")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "read source cache")
+	}
+	sources := []cachedSource{}
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || digest.NewDigestFromEncoded(digest.SHA256, entry.Name()).Validate() != nil {
+			// The temp files left by crashed runs are removed as well.
+			if time.Since(modTime(info)) > time.Hour {
+				os.Remove(filepath.Join(dir, entry.Name()))
+			}
+			continue
+		}
+		sources = append(sources, cachedSource{
+			path:    filepath.Join(dir, entry.Name()),
+			size:    info.Size(),
+			lastUse: info.ModTime(),
+		})
+		total += info.Size()
+	}
+
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].lastUse.Before(sources[j].lastUse)
+	})
+	for _, source := range sources {
+		if total <= cache.maxSize {
+			break
+		}
+		if err := os.Remove(source.path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "evict source layer from cache")
+		}
+		logrus.Debugf("evicted source layer %s from cache", filepath.Base(source.path))
+		total -= source.size
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cache
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceCache(t *testing.T) {
+	sourceCache, err := NewSourceCache(t.TempDir(), 250)
+	require.NoError(t, err)
+
+	put := func(name string, size int, lastUse time.Time) digest.Digest {
+		data := bytes.Repeat([]byte(name), size)
+		dgst := digest.FromBytes(data)
+		require.NoError(t, sourceCache.Put(dgst, bytes.NewReader(data)))
+		require.NoError(t, os.Chtimes(sourceCache.BlobPath(dgst), lastUse, lastUse))
+		return dgst
+	}
+	now := time.Now()
+	a := put("a", 100, now.Add(-3*time.Hour))
+	b := put("b", 100, now.Add(-2*time.Hour))
+	require.True(t, sourceCache.Get(a, 100))
+	require.False(t, sourceCache.Get(a, 99))
+	require.False(t, sourceCache.Get(digest.FromString("missing"), 100))
+
+	// The least recently used layer is evicted, a is used by the Get above.
+	c := put("c", 100, now)
+	for dgst, hit := range map[digest.Digest]bool{a: true, b: false, c: true} {
+		require.Equal(t, hit, sourceCache.Get(dgst, 100))
+	}
+
+	// The layer exceeding the cap alone isn't cached.
+	data := bytes.Repeat([]byte("d"), 300)
+	require.NoError(t, sourceCache.Put(digest.FromBytes(data), bytes.NewReader(data)))
+	require.False(t, sourceCache.Get(digest.FromBytes(data), 300))
+	require.True(t, sourceCache.Get(c, 100))
+
+	data = []byte("corrupted")
+	require.Error(t, sourceCache.Put(digest.FromString("layer"), bytes.NewReader(data)))
+	require.False(t, sourceCache.Get(digest.FromString("layer"), int64(len(data))))
+}
@@ -0,0 +1,492 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cachediff dumps the records of build cache image, and diffs the
+// dumps of two cache versions, to diagnose the corrupted cache and the
+// unexpected cache misses of conversion.
+package cachediff
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/cache"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/transport"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// RemoteFunc creates the remote of image reference.
+type RemoteFunc func(ref string) (*remote.Remote, error)
+
+const (
+	KindBlob      = "blob"
+	KindBootstrap = "bootstrap"
+)
+
+// Record maps a source layer to the target nydus layer in cache manifest.
+type Record struct {
+	// Position is the index of record in cache manifest, the new records
+	// are appended, so a smaller position means an older record.
+	Position     int           `json:"position"`
+	SourceDigest digest.Digest `json:"source_digest"`
+	TargetDigest digest.Digest `json:"target_digest"`
+	TargetSize   int64         `json:"target_size"`
+	MediaType    string        `json:"media_type"`
+	Kind         string        `json:"kind"`
+	FsVersion    string        `json:"fs_version,omitempty"`
+}
+
+// Manifest is the cache manifest of a platform.
+type Manifest struct {
+	Platform string        `json:"platform"`
+	Digest   digest.Digest `json:"digest"`
+	// Version is the cache version annotated in manifest, the manifest is
+	// ignored by conversion with another `--build-cache-version`.
+	Version string   `json:"version"`
+	Records []Record `json:"records"`
+}
+
+// Dump is the records of cache image at the time of dump, the cache image
+// itself records no time, so the dumps saved over time are diffed to see
+// when the records changed.
+type Dump struct {
+	Ref       string        `json:"ref"`
+	Digest    digest.Digest `json:"digest"`
+	Time      time.Time     `json:"time"`
+	Manifests []Manifest    `json:"manifests"`
+	// Problems are the records never hit by conversion.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Opt is the expectation of conversion using the cache, to report the
+// records which can't be hit.
+type Opt struct {
+	// Version is the `--build-cache-version` of conversion, empty to skip
+	// checking the version.
+	Version string
+	// MaxRecords is the `--build-cache-max-records` of conversion, a full
+	// cache manifest accepts no new records, 0 to skip checking.
+	MaxRecords int
+}
+
+// fetcher reads the blobs of cache image.
+type fetcher interface {
+	resolve(ctx context.Context) (*ocispec.Descriptor, error)
+	fetch(ctx context.Context, desc ocispec.Descriptor) ([]byte, error)
+}
+
+type remoteFetcher struct {
+	remote *remote.Remote
+}
+
+func (fetcher *remoteFetcher) resolve(ctx context.Context) (*ocispec.Descriptor, error) {
+	desc, err := fetcher.remote.Resolve(ctx)
+	if err != nil {
+		fetcher.remote.MaybeWithHTTP(err)
+		if !fetcher.remote.IsWithHTTP() {
+			return nil, err
+		}
+		return fetcher.remote.Resolve(ctx)
+	}
+	return desc, nil
+}
+
+func (fetcher *remoteFetcher) fetch(ctx context.Context, desc ocispec.Descriptor) ([]byte, error) {
+	reader, err := fetcher.remote.Pull(ctx, desc, true)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// layoutFetcher reads the cache image in OCI image layout directory in
+// place, without loading the nydus layers.
+type layoutFetcher struct {
+	ref *transport.Reference
+}
+
+func (fetcher *layoutFetcher) resolve(ctx context.Context) (*ocispec.Descriptor, error) {
+	data, err := os.ReadFile(filepath.Join(fetcher.ref.Path, ocispec.ImageIndexFile))
+	if err != nil {
+		return nil, err
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrap(err, "unmarshal index of image layout")
+	}
+	matched := []ocispec.Descriptor{}
+	for _, desc := range index.Manifests {
+		if fetcher.ref.Name == "" || desc.Annotations[ocispec.AnnotationRefName] == fetcher.ref.Name {
+			matched = append(matched, desc)
+		}
+	}
+	if len(matched) != 1 {
+		return nil, fmt.Errorf("found %d images in %s, specify the image name in the form of oci:path:name", len(matched), fetcher.ref.Path)
+	}
+	return &matched[0], nil
+}
+
+func (fetcher *layoutFetcher) fetch(ctx context.Context, desc ocispec.Descriptor) ([]byte, error) {
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(fetcher.ref.Path, ocispec.ImageBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+	if err != nil {
+		return nil, err
+	}
+	if digest.FromBytes(data) != desc.Digest {
+		return nil, fmt.Errorf("digest mismatch of blob %s", desc.Digest)
+	}
+	return data, nil
+}
+
+func fetchJSON(ctx context.Context, fetcher fetcher, desc ocispec.Descriptor, v interface{}) error {
+	data, err := fetcher.fetch(ctx, desc)
+	if err != nil {
+		return errors.Wrapf(err, "fetch %s", desc.Digest)
+	}
+	return errors.Wrapf(json.Unmarshal(data, v), "unmarshal %s", desc.Digest)
+}
+
+// Load dumps the cache image of ref, which is an image in registry or an
+// OCI image layout in the form of `oci:path[:name]`, or reads the dump
+// file saved before if ref is a file. The problems are checked by opt.
+func Load(ctx context.Context, ref string, opt Opt, remoteFunc RemoteFunc) (*Dump, error) {
+	if info, err := os.Stat(ref); err == nil && info.Mode().IsRegular() {
+		dump, err := ReadDump(ref)
+		if err != nil {
+			return nil, err
+		}
+		dump.Problems = dump.check(opt)
+		return dump, nil
+	}
+
+	parsed, err := transport.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	var fetcher fetcher
+	switch parsed.Transport {
+	case transport.Docker:
+		rmt, err := remoteFunc(parsed.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "create remote")
+		}
+		fetcher = &remoteFetcher{remote: rmt}
+	case transport.OCI:
+		fetcher = &layoutFetcher{ref: parsed}
+	default:
+		return nil, fmt.Errorf("unsupported cache reference %s, should be an image in registry or oci:path[:name]", ref)
+	}
+
+	desc, err := fetcher.resolve(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve cache %s", ref)
+	}
+	if desc.MediaType != ocispec.MediaTypeImageIndex && desc.MediaType != images.MediaTypeDockerSchema2ManifestList {
+		return nil, fmt.Errorf("unsupported cache image media type %s", desc.MediaType)
+	}
+	var index ocispec.Index
+	if err := fetchJSON(ctx, fetcher, *desc, &index); err != nil {
+		return nil, err
+	}
+
+	dump := &Dump{
+		Ref:       ref,
+		Digest:    desc.Digest,
+		Time:      time.Now().UTC(),
+		Manifests: []Manifest{},
+	}
+	for _, manifestDesc := range index.Manifests {
+		var manifest ocispec.Manifest
+		if err := fetchJSON(ctx, fetcher, manifestDesc, &manifest); err != nil {
+			return nil, err
+		}
+		dump.Manifests = append(dump.Manifests, inspectManifest(&manifest, manifestDesc))
+	}
+	dump.Problems = dump.check(opt)
+	return dump, nil
+}
+
+func inspectManifest(manifest *ocispec.Manifest, desc ocispec.Descriptor) Manifest {
+	result := Manifest{
+		Digest:  desc.Digest,
+		Version: manifest.Annotations[cache.LayerAnnotationCacheVersion],
+		Records: []Record{},
+	}
+	if desc.Platform != nil {
+		result.Platform = platforms.Format(*desc.Platform)
+	}
+	for idx, layer := range manifest.Layers {
+		record := Record{
+			Position:     idx,
+			SourceDigest: digest.Digest(layer.Annotations[utils.LayerAnnotationNydusSourceDigest]),
+			TargetDigest: layer.Digest,
+			TargetSize:   layer.Size,
+			MediaType:    layer.MediaType,
+			Kind:         KindBlob,
+			FsVersion:    layer.Annotations[utils.LayerAnnotationNydusFsVersion],
+		}
+		if layer.Annotations[utils.LayerAnnotationNydusBootstrap] == "true" {
+			record.Kind = KindBootstrap
+		}
+		result.Records = append(result.Records, record)
+	}
+	return result
+}
+
+// check reports the records never hit by conversion with opt, conversion
+// ignores them silently except for a warning.
+func (dump *Dump) check(opt Opt) []string {
+	problems := []string{}
+	for _, manifest := range dump.Manifests {
+		if opt.Version != "" && manifest.Version != opt.Version {
+			problems = append(problems, fmt.Sprintf("%s: version %q is not %q, all %d records are ignored", manifest.Platform, manifest.Version, opt.Version, len(manifest.Records)))
+		}
+		if opt.MaxRecords > 0 && len(manifest.Records) >= opt.MaxRecords {
+			problems = append(problems, fmt.Sprintf("%s: %d records reach the max records %d, no new records are added", manifest.Platform, len(manifest.Records), opt.MaxRecords))
+		}
+		sources := map[digest.Digest]int{}
+		for _, record := range manifest.Records {
+			if err := record.SourceDigest.Validate(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: record #%d has invalid source digest %q, it's ignored", manifest.Platform, record.Position, record.SourceDigest))
+				continue
+			}
+			if position, ok := sources[record.SourceDigest]; ok {
+				problems = append(problems, fmt.Sprintf("%s: record #%d duplicates the source %s of record #%d", manifest.Platform, record.Position, record.SourceDigest, position))
+			}
+			sources[record.SourceDigest] = record.Position
+		}
+	}
+	return problems
+}
+
+// ReadDump reads the dump file saved by Save.
+func ReadDump(path string) (*Dump, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read cache dump")
+	}
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal cache dump %s", path)
+	}
+	return &dump, nil
+}
+
+// Save saves the dump in JSON format, to be diffed later.
+func (dump *Dump) Save(path string) error {
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal cache dump")
+	}
+	return errors.Wrap(os.WriteFile(path, data, 0644), "write cache dump")
+}
+
+// Print prints the dump as table, one row per record.
+func (dump *Dump) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "cache %s (%s) dumped at %s\n\n", dump.Ref, dump.Digest, dump.Time.Format(time.RFC3339))
+	fmt.Fprintln(tw, "PLATFORM\tVERSION\tPOSITION\tKIND\tSOURCE\tTARGET\tSIZE\tFS VERSION")
+	for _, manifest := range dump.Manifests {
+		for _, record := range manifest.Records {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%d\t%s\n", manifest.Platform, manifest.Version, record.Position,
+				record.Kind, record.SourceDigest, record.TargetDigest, record.TargetSize, record.FsVersion)
+		}
+	}
+	if len(dump.Problems) > 0 {
+		fmt.Fprintln(tw)
+	}
+	for _, problem := range dump.Problems {
+		fmt.Fprintf(tw, "problem: %s\n", problem)
+	}
+	return tw.Flush()
+}
+
+const (
+	// ChangeAdded is the record only in new cache.
+	ChangeAdded = "added"
+	// ChangeRemoved is the record only in old cache, which is evicted or
+	// dropped by another version.
+	ChangeRemoved = "removed"
+	// ChangeTarget is the source mapped to another target layer, which is
+	// unexpected since a source layer is converted only once, and usually
+	// means the corrupted cache or conversions with different options
+	// sharing the cache.
+	ChangeTarget = "target"
+	// ChangeMoved is the record at another position.
+	ChangeMoved = "moved"
+	// ChangeVersion is the cache manifest of another version.
+	ChangeVersion = "version"
+	// ChangePlatform is the cache manifest only in one cache.
+	ChangePlatform = "platform"
+)
+
+// Change is a difference between two cache dumps.
+type Change struct {
+	Type         string        `json:"type"`
+	Platform     string        `json:"platform"`
+	SourceDigest digest.Digest `json:"source_digest,omitempty"`
+	Old          *Record       `json:"old,omitempty"`
+	New          *Record       `json:"new,omitempty"`
+	Message      string        `json:"message"`
+}
+
+// Diff is the differences from old cache dump to new one.
+type Diff struct {
+	OldRef  string    `json:"old_ref"`
+	OldTime time.Time `json:"old_time"`
+	NewRef  string    `json:"new_ref"`
+	NewTime time.Time `json:"new_time"`
+	Changes []Change  `json:"changes"`
+}
+
+// recordsBySource indexes the records by source digest, the later one of
+// duplicated records wins as conversion does.
+func recordsBySource(manifest *Manifest) map[digest.Digest]*Record {
+	records := map[digest.Digest]*Record{}
+	for idx := range manifest.Records {
+		records[manifest.Records[idx].SourceDigest] = &manifest.Records[idx]
+	}
+	return records
+}
+
+func diffManifest(platform string, oldManifest, newManifest *Manifest) []Change {
+	changes := []Change{}
+	if oldManifest.Version != newManifest.Version {
+		changes = append(changes, Change{
+			Type:     ChangeVersion,
+			Platform: platform,
+			Message:  fmt.Sprintf("version changed from %q to %q", oldManifest.Version, newManifest.Version),
+		})
+	}
+	oldRecords, newRecords := recordsBySource(oldManifest), recordsBySource(newManifest)
+	for _, oldRecord := range oldManifest.Records {
+		source := oldRecord.SourceDigest
+		if oldRecords[source].Position != oldRecord.Position {
+			continue
+		}
+		newRecord, ok := newRecords[source]
+		change := Change{Platform: platform, SourceDigest: source, Old: oldRecords[source], New: newRecord}
+		switch {
+		case !ok:
+			change.Type = ChangeRemoved
+			change.Message = fmt.Sprintf("record #%d removed", oldRecord.Position)
+		case newRecord.TargetDigest != oldRecord.TargetDigest:
+			change.Type = ChangeTarget
+			change.Message = fmt.Sprintf("target changed from %s to %s", oldRecord.TargetDigest, newRecord.TargetDigest)
+		case newRecord.Position != oldRecord.Position:
+			change.Type = ChangeMoved
+			change.Message = fmt.Sprintf("moved from #%d to #%d", oldRecord.Position, newRecord.Position)
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	for _, newRecord := range newManifest.Records {
+		source := newRecord.SourceDigest
+		if _, ok := oldRecords[source]; ok || newRecords[source].Position != newRecord.Position {
+			continue
+		}
+		changes = append(changes, Change{
+			Type:         ChangeAdded,
+			Platform:     platform,
+			SourceDigest: source,
+			New:          newRecords[source],
+			Message:      fmt.Sprintf("record #%d added", newRecord.Position),
+		})
+	}
+	return changes
+}
+
+// DiffDumps diffs the records of the cache manifests of the same platform.
+func DiffDumps(oldDump, newDump *Dump) *Diff {
+	diff := &Diff{
+		OldRef:  oldDump.Ref,
+		OldTime: oldDump.Time,
+		NewRef:  newDump.Ref,
+		NewTime: newDump.Time,
+		Changes: []Change{},
+	}
+	oldManifests, newManifests := map[string]*Manifest{}, map[string]*Manifest{}
+	for idx := range oldDump.Manifests {
+		oldManifests[oldDump.Manifests[idx].Platform] = &oldDump.Manifests[idx]
+	}
+	for idx := range newDump.Manifests {
+		newManifests[newDump.Manifests[idx].Platform] = &newDump.Manifests[idx]
+	}
+	platformSet := map[string]bool{}
+	for platform := range oldManifests {
+		platformSet[platform] = true
+	}
+	for platform := range newManifests {
+		platformSet[platform] = true
+	}
+	platformNames := make([]string, 0, len(platformSet))
+	for platform := range platformSet {
+		platformNames = append(platformNames, platform)
+	}
+	sort.Strings(platformNames)
+
+	for _, platform := range platformNames {
+		oldManifest, newManifest := oldManifests[platform], newManifests[platform]
+		switch {
+		case oldManifest == nil:
+			diff.Changes = append(diff.Changes, Change{
+				Type:     ChangePlatform,
+				Platform: platform,
+				Message:  fmt.Sprintf("manifest added with %d records", len(newManifest.Records)),
+			})
+		case newManifest == nil:
+			diff.Changes = append(diff.Changes, Change{
+				Type:     ChangePlatform,
+				Platform: platform,
+				Message:  fmt.Sprintf("manifest removed with %d records", len(oldManifest.Records)),
+			})
+		default:
+			diff.Changes = append(diff.Changes, diffManifest(platform, oldManifest, newManifest)...)
+		}
+	}
+	return diff
+}
+
+// Print prints the diff as table, one row per change.
+func (diff *Diff) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "--- %s dumped at %s\n", diff.OldRef, diff.OldTime.Format(time.RFC3339))
+	fmt.Fprintf(tw, "+++ %s dumped at %s\n\n", diff.NewRef, diff.NewTime.Format(time.RFC3339))
+	fmt.Fprintln(tw, "PLATFORM\tCHANGE\tSOURCE\tDETAIL")
+	counts := map[string]int{}
+	for _, change := range diff.Changes {
+		counts[change.Type]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", change.Platform, change.Type, change.SourceDigest, change.Message)
+	}
+	summary := []string{}
+	for _, changeType := range []string{ChangeAdded, ChangeRemoved, ChangeTarget, ChangeMoved, ChangeVersion, ChangePlatform} {
+		if counts[changeType] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[changeType], changeType))
+		}
+	}
+	if len(summary) == 0 {
+		summary = append(summary, "no changes")
+	}
+	fmt.Fprintf(tw, "\n%s\n", strings.Join(summary, ", "))
+	return tw.Flush()
+}
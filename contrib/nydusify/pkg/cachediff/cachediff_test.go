@@ -0,0 +1,154 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cachediff
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goharbor/acceleration-service/pkg/cache"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func writeBlob(t *testing.T, dir string, mediaType string, v interface{}) ocispec.Descriptor {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	dgst := digest.FromBytes(data)
+	blobDir := filepath.Join(dir, ocispec.ImageBlobsDir, dgst.Algorithm().String())
+	require.NoError(t, os.MkdirAll(blobDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(blobDir, dgst.Encoded()), data, 0644))
+	return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+}
+
+func cacheLayer(source, target string, bootstrap bool) ocispec.Descriptor {
+	annotations := map[string]string{
+		utils.LayerAnnotationNydusSourceDigest: source,
+		utils.LayerAnnotationNydusFsVersion:    "6",
+	}
+	mediaType := utils.MediaTypeNydusBlob
+	if bootstrap {
+		annotations[utils.LayerAnnotationNydusBootstrap] = "true"
+		mediaType = ocispec.MediaTypeImageLayerGzip
+	}
+	return ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromString(target), Size: 100, Annotations: annotations}
+}
+
+// writeCache writes the cache image of a linux/amd64 manifest with layers
+// in OCI image layout.
+func writeCache(t *testing.T, version string, layers []ocispec.Descriptor) string {
+	dir := t.TempDir()
+	manifestDesc := writeBlob(t, dir, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Layers:      layers,
+		Annotations: map[string]string{cache.LayerAnnotationCacheVersion: version},
+	})
+	manifestDesc.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	indexDesc := writeBlob(t, dir, ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifestDesc},
+	})
+	indexDesc.Annotations = map[string]string{ocispec.AnnotationRefName: "cache"}
+	data, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []ocispec.Descriptor{indexDesc},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ocispec.ImageIndexFile), data, 0644))
+	return dir
+}
+
+func TestDumpAndDiff(t *testing.T) {
+	ctx := context.Background()
+	source1, source2, source3 := digest.FromString("source1"), digest.FromString("source2"), digest.FromString("source3")
+
+	oldDir := writeCache(t, "v1", []ocispec.Descriptor{
+		cacheLayer(source1.String(), "blob1", false),
+		cacheLayer(source1.String(), "bootstrap1", true),
+		cacheLayer(source2.String(), "blob2", false),
+	})
+	oldDump, err := Load(ctx, "oci:"+oldDir+":cache", Opt{Version: "v1", MaxRecords: 4}, nil)
+	require.NoError(t, err)
+	require.Len(t, oldDump.Manifests, 1)
+	manifest := oldDump.Manifests[0]
+	require.Equal(t, "linux/amd64", manifest.Platform)
+	require.Equal(t, "v1", manifest.Version)
+	require.Equal(t, Record{
+		Position:     1,
+		SourceDigest: source1,
+		TargetDigest: digest.FromString("bootstrap1"),
+		TargetSize:   100,
+		MediaType:    ocispec.MediaTypeImageLayerGzip,
+		Kind:         KindBootstrap,
+		FsVersion:    "6",
+	}, manifest.Records[1])
+	require.Equal(t, KindBlob, manifest.Records[0].Kind)
+	require.Equal(t, []string{"linux/amd64: record #1 duplicates the source " + source1.String() + " of record #0"}, oldDump.Problems)
+
+	// The dump saved before is diffed with the cache of new version.
+	dumpPath := filepath.Join(t.TempDir(), "dump.json")
+	require.NoError(t, oldDump.Save(dumpPath))
+	newDir := writeCache(t, "v2", []ocispec.Descriptor{
+		cacheLayer(source1.String(), "bootstrap1-corrupted", true),
+		cacheLayer(source3.String(), "blob3", false),
+		cacheLayer("invalid", "blob4", false),
+		cacheLayer(source2.String(), "blob2", false),
+	})
+	oldDump, err = Load(ctx, dumpPath, Opt{Version: "v2"}, nil)
+	require.NoError(t, err)
+	require.Len(t, oldDump.Problems, 2)
+	require.Contains(t, oldDump.Problems[0], `version "v1" is not "v2"`)
+	newDump, err := Load(ctx, "oci:"+newDir, Opt{Version: "v1", MaxRecords: 4}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`linux/amd64: version "v2" is not "v1", all 4 records are ignored`,
+		"linux/amd64: 4 records reach the max records 4, no new records are added",
+		`linux/amd64: record #2 has invalid source digest "invalid", it's ignored`,
+	}, newDump.Problems)
+
+	diff := DiffDumps(oldDump, newDump)
+	types := []string{}
+	for _, change := range diff.Changes {
+		types = append(types, change.Type)
+	}
+	require.Equal(t, []string{ChangeVersion, ChangeTarget, ChangeMoved, ChangeAdded, ChangeAdded}, types)
+	require.Equal(t, source1, diff.Changes[1].SourceDigest)
+	require.Equal(t, digest.FromString("bootstrap1"), diff.Changes[1].Old.TargetDigest)
+	require.Equal(t, digest.FromString("bootstrap1-corrupted"), diff.Changes[1].New.TargetDigest)
+	require.Equal(t, "moved from #2 to #3", diff.Changes[2].Message)
+	require.Equal(t, source3, diff.Changes[3].SourceDigest)
+
+	var buf bytes.Buffer
+	require.NoError(t, diff.Print(&buf))
+	require.Contains(t, buf.String(), "2 added, 1 target, 1 moved, 1 version\n")
+	require.NoError(t, DiffDumps(newDump, newDump).Print(&buf))
+	require.Contains(t, buf.String(), "no changes\n")
+	buf.Reset()
+	require.NoError(t, newDump.Print(&buf))
+	require.Contains(t, buf.String(), "problem: linux/amd64: 4 records reach the max records 4")
+
+	emptyDump := &Dump{Ref: "empty"}
+	diff = DiffDumps(emptyDump, newDump)
+	require.Len(t, diff.Changes, 1)
+	require.Equal(t, ChangePlatform, diff.Changes[0].Type)
+	require.Equal(t, "manifest added with 4 records", diff.Changes[0].Message)
+
+	_, err = Load(ctx, "oci:"+t.TempDir(), Opt{}, nil)
+	require.ErrorContains(t, err, "resolve cache")
+	_, err = Load(ctx, "oci:"+newDir+":other", Opt{}, nil)
+	require.ErrorContains(t, err, "found 0 images")
+	_, err = Load(ctx, "docker-archive:/tmp/cache.tar", Opt{}, nil)
+	require.ErrorContains(t, err, "unsupported cache reference")
+}
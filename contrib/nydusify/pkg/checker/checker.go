@@ -34,6 +34,17 @@ type Opt struct {
 	BackendType    string
 	BackendConfig  string
 	ExpectedArch   string
+	// Native verifies the filesystem without FUSE and Nydusd.
+	Native bool
+	// UnpackFilter is applied to the source layers, which should be the
+	// filter used to convert the target image.
+	UnpackFilter utils.UnpackFilter
+	// FilesystemReport is the file to save the discrepancies of filesystem
+	// between source and Nydus image in JSON.
+	FilesystemReport string
+	// ReferenceContainer is a running container of the source image, the
+	// filesystem of Nydus image is compared with its root filesystem.
+	ReferenceContainer string
 }
 
 // Checker validates Nydus image manifest, bootstrap and mounts filesystem
@@ -80,6 +91,17 @@ func New(opt Opt) (*Checker, error) {
 // Check checks Nydus image, and outputs image information to work
 // directory, the check workflow is composed of various rules.
 func (checker *Checker) Check(ctx context.Context) error {
+	// Guarantee the nydusd and source image mounts are cleaned up on
+	// panic or signal, rather than leaving stale FUSE mounts.
+	stop := tool.HandleSignals()
+	defer stop()
+	defer func() {
+		if r := recover(); r != nil {
+			tool.Cleanup()
+			panic(r)
+		}
+	}()
+
 	if err := checker.check(ctx); err != nil {
 		if utils.RetryWithHTTP(err) {
 			if checker.sourceParser != nil {
@@ -155,18 +177,24 @@ func (checker *Checker) check(ctx context.Context) error {
 			Parsed:          targetParsed,
 			NydusImagePath:  checker.NydusImagePath,
 			BackendType:     checker.BackendType,
+			BackendConfig:   checker.BackendConfig,
 			BootstrapPath:   filepath.Join(checker.WorkDir, "nydus_bootstrap"),
 			DebugOutputPath: filepath.Join(checker.WorkDir, "nydus_bootstrap_debug.json"),
 		},
 		&rule.FilesystemRule{
-			Source:          checker.Source,
-			SourceMountPath: filepath.Join(checker.WorkDir, "fs/source_mounted"),
-			SourceParsed:    sourceParsed,
-			SourcePath:      filepath.Join(checker.WorkDir, "fs/source"),
-			SourceRemote:    sourceRemote,
-			Target:          checker.Target,
-			TargetInsecure:  checker.TargetInsecure,
-			PlainHTTP:       checker.targetParser.Remote.IsWithHTTP(),
+			Source:             checker.Source,
+			SourceMountPath:    filepath.Join(checker.WorkDir, "fs/source_mounted"),
+			SourceParsed:       sourceParsed,
+			SourcePath:         filepath.Join(checker.WorkDir, "fs/source"),
+			SourceRemote:       sourceRemote,
+			Target:             checker.Target,
+			TargetInsecure:     checker.TargetInsecure,
+			PlainHTTP:          checker.targetParser.Remote.IsWithHTTP(),
+			Native:             checker.Native,
+			NydusImagePath:     checker.NydusImagePath,
+			UnpackFilter:       checker.UnpackFilter,
+			ReportPath:         checker.FilesystemReport,
+			ReferenceContainer: checker.ReferenceContainer,
 			NydusdConfig: tool.NydusdConfig{
 				NydusdPath:     checker.NydusdPath,
 				BackendType:    checker.BackendType,
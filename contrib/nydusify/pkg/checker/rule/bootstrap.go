@@ -5,13 +5,14 @@
 package rule
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
+	"io"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/build"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
 )
@@ -23,10 +24,7 @@ type BootstrapRule struct {
 	NydusImagePath  string
 	DebugOutputPath string
 	BackendType     string
-}
-
-type bootstrapDebug struct {
-	Blobs []string `json:"blobs"`
+	BackendConfig   string
 }
 
 func (rule *BootstrapRule) Name() string {
@@ -46,11 +44,20 @@ func (rule *BootstrapRule) Validate() error {
 		return errors.Wrap(err, "invalid nydus bootstrap format")
 	}
 
+	// Parse blob list from blob table of bootstrap
+	bootstrap, err := build.ParseOutput(rule.DebugOutputPath)
+	if err != nil {
+		return errors.Wrap(err, "parse bootstrap debug json")
+	}
+
 	// For registry garbage collection, nydus puts the blobs to
 	// the layers in manifest, so here only need to check blob
 	// list consistency for registry backend.
 	if rule.BackendType != "registry" {
-		return nil
+		if rule.BackendType == "" || rule.BackendConfig == "" {
+			return nil
+		}
+		return rule.validateBackendBlobs(bootstrap.Blobs)
 	}
 
 	// Parse blob list from blob layers in Nydus manifest
@@ -62,15 +69,6 @@ func (rule *BootstrapRule) Validate() error {
 		}
 	}
 
-	// Parse blob list from blob table of bootstrap
-	var bootstrap bootstrapDebug
-	bootstrapBytes, err := os.ReadFile(rule.DebugOutputPath)
-	if err != nil {
-		return errors.Wrap(err, "read bootstrap debug json")
-	}
-	if err := json.Unmarshal(bootstrapBytes, &bootstrap); err != nil {
-		return errors.Wrap(err, "unmarshal bootstrap output JSON")
-	}
 	blobListInBootstrap := map[string]bool{}
 	lostInLayer := false
 	for _, blobID := range bootstrap.Blobs {
@@ -86,11 +84,48 @@ func (rule *BootstrapRule) Validate() error {
 
 	// The blobs recorded in blob table of bootstrap should all appear
 	// in the layers.
-	return fmt.Errorf(
+	return mismatch(fmt.Errorf(
 		"nydus blobs in the blob table of bootstrap(%d) should all appear in the layers of manifest(%d), %v != %v",
 		len(blobListInBootstrap),
 		len(blobListInLayer),
 		blobListInBootstrap,
 		blobListInLayer,
-	)
+	))
+}
+
+// validateBackendBlobs checks that the blobs in the blob table of bootstrap
+// exist in the storage backend and are readable, by reading the last byte
+// of each blob directly from backend.
+func (rule *BootstrapRule) validateBackendBlobs(blobIDs []string) error {
+	bkd, err := backend.NewBackend(rule.BackendType, []byte(rule.BackendConfig), nil)
+	if err != nil {
+		return errors.Wrap(err, "create storage backend")
+	}
+	for _, blobID := range blobIDs {
+		exist, err := bkd.Check(blobID)
+		if err != nil {
+			return errors.Wrapf(err, "check blob %s in storage backend", blobID)
+		}
+		if !exist {
+			return mismatch(fmt.Errorf("nydus blob %s in the blob table of bootstrap doesn't exist in storage backend", blobID))
+		}
+		size, err := bkd.Size(blobID)
+		if err != nil {
+			return errors.Wrapf(err, "get size of blob %s", blobID)
+		}
+		if size <= 0 {
+			return mismatch(fmt.Errorf("nydus blob %s in storage backend is empty", blobID))
+		}
+		reader, err := bkd.RangeReader(blobID, size-1, 1)
+		if err != nil {
+			return errors.Wrapf(err, "read blob %s from storage backend", blobID)
+		}
+		_, err = io.Copy(io.Discard, reader)
+		reader.Close()
+		if err != nil {
+			return errors.Wrapf(err, "read blob %s from storage backend", blobID)
+		}
+		logrus.Debugf("verified blob %s (%d bytes) in storage backend", blobID, size)
+	}
+	return nil
 }
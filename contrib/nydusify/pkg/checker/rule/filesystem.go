@@ -12,8 +12,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"reflect"
-	"syscall"
 
 	"github.com/distribution/reference"
 	dockerconfig "github.com/docker/cli/cli/config"
@@ -33,6 +31,7 @@ var WorkerCount uint = 8
 // FilesystemRule compares file metadata and data in the two mountpoints:
 // Mounted by Nydusd for Nydus image,
 // Mounted by Overlayfs for OCI image.
+// In native mode, the two images are unpacked to directories instead.
 type FilesystemRule struct {
 	NydusdConfig    tool.NydusdConfig
 	Source          string
@@ -43,6 +42,22 @@ type FilesystemRule struct {
 	Target          string
 	TargetInsecure  bool
 	PlainHTTP       bool
+
+	// Native reads the Nydus image by `nydus-image unpack` instead of
+	// mounting it by Nydusd, see native.go.
+	Native         bool
+	NydusImagePath string
+	// UnpackFilter filters the source layers as the conversion does.
+	UnpackFilter utils.UnpackFilter
+	// ReportPath is the file to save the discrepancies found in JSON.
+	ReportPath string
+	// ReferenceContainer is a running container of the source image, its
+	// root filesystem is compared with Nydus image instead of the source
+	// image unpacked from registry, see reference.go.
+	ReferenceContainer string
+
+	// excludes are the paths skipped when walking the rootfs.
+	excludes map[string]bool
 }
 
 // Node records file metadata and file data hash.
@@ -110,6 +125,12 @@ func (rule *FilesystemRule) walk(rootfs string) (map[string]Node, error) {
 			return err
 		}
 		rootfsPath = filepath.Join("/", rootfsPath)
+		if rule.excludes[rootfsPath] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 
 		var size int64
 		if !info.IsDir() {
@@ -127,8 +148,8 @@ func (rule *FilesystemRule) walk(rootfs string) (map[string]Node, error) {
 			symlink = rootfsPath
 		}
 
-		var stat syscall.Stat_t
-		if err := syscall.Lstat(path, &stat); err != nil {
+		rdev, uid, gid, err := lstatOwner(path)
+		if err != nil {
 			return errors.Wrapf(err, "lstat %s", path)
 		}
 
@@ -136,6 +157,11 @@ func (rule *FilesystemRule) walk(rootfs string) (map[string]Node, error) {
 		if err != nil {
 			logrus.Warnf("Failed to get xattr: %s", err)
 		}
+		if rule.ReferenceContainer != "" {
+			for _, name := range referenceIgnoredXattrs {
+				delete(xattrs, name)
+			}
+		}
 
 		// Calculate file data hash if the `backend-type` option be specified,
 		// this will cause that nydusd read data from backend, it's network load
@@ -151,10 +177,10 @@ func (rule *FilesystemRule) walk(rootfs string) (map[string]Node, error) {
 			Path:    rootfsPath,
 			Size:    size,
 			Mode:    mode,
-			Rdev:    stat.Rdev,
+			Rdev:    rdev,
 			Symlink: symlink,
-			UID:     stat.Uid,
-			GID:     stat.Gid,
+			UID:     uid,
+			GID:     gid,
 			Xattrs:  xattrs,
 			Hash:    hash,
 		}
@@ -181,7 +207,10 @@ func (rule *FilesystemRule) pullSourceImage() (*tool.Image, error) {
 					return errors.Wrap(err, "pull source image layers from the remote registry")
 				}
 
-				if err = utils.UnpackTargz(context.Background(), filepath.Join(rule.SourcePath, fmt.Sprintf("layer-%d", idx)), reader, true); err != nil {
+				progress := utils.NewUnpackProgress(idx+1, len(layers))
+				if err = utils.UnpackTargzWithFilter(
+					context.Background(), filepath.Join(rule.SourcePath, fmt.Sprintf("layer-%d", idx)), reader, true, progress, rule.UnpackFilter,
+				); err != nil {
 					return errors.Wrap(err, "unpack source image layers")
 				}
 
@@ -243,20 +272,18 @@ func NewRegistryBackendConfig(parsed reference.Named) (RegistryBackendConfig, er
 	return backendConfig, nil
 }
 
-func (rule *FilesystemRule) mountNydusImage() (*tool.Nydusd, error) {
-	logrus.Infof("Mounting Nydus image to %s", rule.NydusdConfig.MountPath)
-
-	if err := os.MkdirAll(rule.NydusdConfig.BlobCacheDir, 0755); err != nil {
-		return nil, errors.Wrap(err, "create blob cache directory for Nydusd")
-	}
-
-	if err := os.MkdirAll(rule.NydusdConfig.MountPath, 0755); err != nil {
-		return nil, errors.Wrap(err, "create mountpoint directory of Nydus image")
-	}
-
+// prepareBackendConfig uses the registry of target image as the storage
+// backend if no backend is specified.
+func (rule *FilesystemRule) prepareBackendConfig() error {
 	parsed, err := reference.ParseNormalizedNamed(rule.Target)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	// The blobs in Azure Blob Storage can only be uploaded and verified by
+	// nydusify, nydusd has no such storage backend to mount the image.
+	if rule.NydusdConfig.BackendType == "azblob" {
+		return errors.New("filesystem check isn't supported for azblob backend, as nydusd can't read blobs from Azure Blob Storage")
 	}
 
 	if rule.NydusdConfig.BackendType == "" {
@@ -265,7 +292,7 @@ func (rule *FilesystemRule) mountNydusImage() (*tool.Nydusd, error) {
 		if rule.NydusdConfig.BackendConfig == "" {
 			backendConfig, err := NewRegistryBackendConfig(parsed)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to parse backend configuration")
+				return errors.Wrap(err, "failed to parse backend configuration")
 			}
 
 			if rule.TargetInsecure {
@@ -278,12 +305,30 @@ func (rule *FilesystemRule) mountNydusImage() (*tool.Nydusd, error) {
 
 			bytes, err := json.Marshal(backendConfig)
 			if err != nil {
-				return nil, errors.Wrap(err, "parse registry backend config")
+				return errors.Wrap(err, "parse registry backend config")
 			}
 			rule.NydusdConfig.BackendConfig = string(bytes)
 		}
 	}
 
+	return nil
+}
+
+func (rule *FilesystemRule) mountNydusImage() (*tool.Nydusd, error) {
+	logrus.Infof("Mounting Nydus image to %s", rule.NydusdConfig.MountPath)
+
+	if err := os.MkdirAll(rule.NydusdConfig.BlobCacheDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create blob cache directory for Nydusd")
+	}
+
+	if err := os.MkdirAll(rule.NydusdConfig.MountPath, 0755); err != nil {
+		return nil, errors.Wrap(err, "create mountpoint directory of Nydus image")
+	}
+
+	if err := rule.prepareBackendConfig(); err != nil {
+		return nil, err
+	}
+
 	nydusd, err := tool.NewNydusd(rule.NydusdConfig)
 	if err != nil {
 		return nil, errors.Wrap(err, "create Nydusd daemon")
@@ -318,20 +363,24 @@ func (rule *FilesystemRule) verify() error {
 		return errors.Wrap(err, "walk rootfs of source image")
 	}
 
-	for path, sourceNode := range sourceNodes {
-		nydusNode, exist := nydusNodes[path]
-		if !exist {
-			return fmt.Errorf("File not found in Nydus image: %s", path)
+	discrepancies := compareNodes(sourceNodes, nydusNodes)
+	if rule.ReportPath != "" {
+		source := rule.Source
+		if rule.ReferenceContainer != "" {
+			source = rule.ReferenceContainer
 		}
-		delete(nydusNodes, path)
-
-		if path != "/" && !reflect.DeepEqual(sourceNode, nydusNode) {
-			return fmt.Errorf("File not match in Nydus image: %s <=> %s", sourceNode.String(), nydusNode.String())
+		report := FilesystemReport{
+			Source:        source,
+			Target:        rule.Target,
+			Files:         len(sourceNodes),
+			Discrepancies: discrepancies,
+		}
+		if err := report.dump(rule.ReportPath); err != nil {
+			return err
 		}
 	}
-
-	for path := range nydusNodes {
-		return fmt.Errorf("File not found in source image: %s", path)
+	if len(discrepancies) > 0 {
+		return mismatch(fmt.Errorf("found %d discrepancies in Nydus image, the first one: %s", len(discrepancies), discrepancies[0].String()))
 	}
 
 	return nil
@@ -339,7 +388,7 @@ func (rule *FilesystemRule) verify() error {
 
 func (rule *FilesystemRule) Validate() error {
 	// Skip filesystem validation if no source image be specified
-	if rule.Source == "" {
+	if rule.Source == "" && rule.ReferenceContainer == "" {
 		return nil
 	}
 
@@ -356,6 +405,13 @@ func (rule *FilesystemRule) Validate() error {
 		}
 	}()
 
+	if rule.ReferenceContainer != "" {
+		return rule.validateReference()
+	}
+	if rule.Native {
+		return rule.validateNative()
+	}
+
 	image, err := rule.mountSourceImage()
 	if err != nil {
 		return err
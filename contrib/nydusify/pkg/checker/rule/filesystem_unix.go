@@ -0,0 +1,19 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package rule
+
+import "syscall"
+
+// lstatOwner returns the device number and owner of file without following
+// symlink.
+func lstatOwner(path string) (uint64, uint32, uint32, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Lstat(path, &stat); err != nil {
+		return 0, 0, 0, err
+	}
+	return uint64(stat.Rdev), stat.Uid, stat.Gid, nil
+}
@@ -35,7 +35,7 @@ func (rule *ManifestRule) Validate() error {
 	// and it should consist of OCI and Nydus manifest
 	if rule.MultiPlatform {
 		if rule.TargetParsed.Index == nil {
-			return errors.New("not found image manifest list")
+			return mismatch(errors.New("not found image manifest list"))
 		}
 		foundNydusDesc := false
 		foundOCIDesc := false
@@ -52,28 +52,33 @@ func (rule *ManifestRule) Validate() error {
 			}
 		}
 		if !foundNydusDesc {
-			return errors.Errorf("not found nydus image of specified platform linux/%s", rule.ExpectedArch)
+			return mismatch(errors.Errorf("not found nydus image of specified platform linux/%s", rule.ExpectedArch))
 		}
 		if !foundOCIDesc {
-			return errors.Errorf("not found OCI image of specified platform linux/%s", rule.ExpectedArch)
+			return mismatch(errors.Errorf("not found OCI image of specified platform linux/%s", rule.ExpectedArch))
 		}
 	}
 
 	// Check manifest of Nydus
 	if rule.TargetParsed.NydusImage == nil {
-		return errors.New("invalid nydus image manifest")
+		return mismatch(errors.New("invalid nydus image manifest"))
 	}
 
 	layers := rule.TargetParsed.NydusImage.Manifest.Layers
 	for i, layer := range layers {
 		if i == len(layers)-1 {
 			if layer.Annotations[utils.LayerAnnotationNydusBootstrap] != "true" {
-				return errors.New("invalid bootstrap layer in nydus image manifest")
+				return mismatch(errors.New("invalid bootstrap layer in nydus image manifest"))
 			}
 		} else {
 			if layer.MediaType != utils.MediaTypeNydusBlob ||
 				layer.Annotations[utils.LayerAnnotationNydusBlob] != "true" {
-				return errors.New("invalid blob layer in nydus image manifest")
+				return mismatch(errors.New("invalid blob layer in nydus image manifest"))
+			}
+			if blobMeta, ok := layer.Annotations[utils.LayerAnnotationNydusBlobMeta]; ok {
+				if blobMeta != layer.Digest.Encoded()+utils.BlobMetaSuffix {
+					return mismatch(errors.Errorf("invalid blob meta %s for blob layer %s in nydus image manifest", blobMeta, layer.Digest))
+				}
 			}
 		}
 	}
@@ -103,7 +108,7 @@ func (rule *ManifestRule) Validate() error {
 			return errors.New("marshal nydus image config")
 		}
 		if !reflect.DeepEqual(ociConfig, nydusConfig) {
-			return errors.New("nydus image config should be equal with oci image config")
+			return mismatch(errors.New("nydus image config should be equal with oci image config"))
 		}
 	}
 
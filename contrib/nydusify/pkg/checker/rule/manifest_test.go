@@ -167,4 +167,10 @@ func TestManifestRuleValidate_TargetLayer(t *testing.T) {
 		},
 	}
 	require.NoError(t, rule.Validate())
+	rule.TargetParsed.NydusImage.Manifest.Layers[0].Annotations["containerd.io/snapshot/nydus-blob-meta"] = "invalid.blob.meta"
+	require.Error(t, rule.Validate())
+	require.Contains(t, rule.Validate().Error(), "invalid blob meta")
+
+	rule.TargetParsed.NydusImage.Manifest.Layers[0].Annotations["containerd.io/snapshot/nydus-blob-meta"] = "09845cce1d983b158d4865fc37c23bbfb892d4775c786e8114d3cf868975c059.blob.meta"
+	require.NoError(t, rule.Validate())
 }
@@ -0,0 +1,105 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package rule
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// applyLayer applies the (compressed) layer tar stream on the directory,
+// the whiteout files in layer remove the files from lower layers as the
+// overlay mount does.
+func applyLayer(ctx context.Context, dst string, reader io.Reader) error {
+	return utils.ApplyTargz(ctx, dst, reader)
+}
+
+// unpackSourceImage applies the source image layers one by one on
+// SourceMountPath, which takes the place of the overlay mount.
+func (rule *FilesystemRule) unpackSourceImage(ctx context.Context) error {
+	logrus.Infof("Unpacking source image to %s", rule.SourceMountPath)
+
+	for idx, layer := range rule.SourceParsed.OCIImage.Manifest.Layers {
+		reader, err := rule.SourceRemote.Pull(ctx, layer, true)
+		if err != nil {
+			return errors.Wrap(err, "pull source image layers from the remote registry")
+		}
+		err = applyLayer(ctx, rule.SourceMountPath, reader)
+		reader.Close()
+		if err != nil {
+			return errors.Wrapf(err, "apply source image layer %d", idx)
+		}
+	}
+
+	return nil
+}
+
+// unpackNydusImage reads the Nydus image by `nydus-image unpack`, which
+// parses the RAFS bootstrap and fetches the chunk data from backend in
+// user space, the output tar is unpacked to NydusdConfig.MountPath.
+func (rule *FilesystemRule) unpackNydusImage(ctx context.Context) error {
+	logrus.Infof("Unpacking Nydus image to %s", rule.NydusdConfig.MountPath)
+
+	if err := os.MkdirAll(rule.NydusdConfig.BlobCacheDir, 0755); err != nil {
+		return errors.Wrap(err, "create blob cache directory")
+	}
+
+	if err := rule.prepareBackendConfig(); err != nil {
+		return err
+	}
+
+	if err := tool.MakeConfig(rule.NydusdConfig); err != nil {
+		return errors.Wrap(err, "prepare backend configuration")
+	}
+
+	tarPath := filepath.Join(filepath.Dir(rule.NydusdConfig.MountPath), "nydus_unpacked.tar")
+	defer os.Remove(tarPath)
+
+	builder := tool.NewBuilder(rule.NydusImagePath)
+	if err := builder.Unpack(tool.UnpackOption{
+		BootstrapPath: rule.NydusdConfig.BootstrapPath,
+		ConfigPath:    rule.NydusdConfig.ConfigPath,
+		OutputPath:    tarPath,
+	}); err != nil {
+		return errors.Wrap(err, "unpack Nydus image")
+	}
+
+	file, err := os.Open(tarPath)
+	if err != nil {
+		return errors.Wrap(err, "open unpacked Nydus image")
+	}
+	defer file.Close()
+
+	if err := applyLayer(ctx, rule.NydusdConfig.MountPath, file); err != nil {
+		return errors.Wrap(err, "apply unpacked Nydus image")
+	}
+
+	return nil
+}
+
+// validateNative compares the source and Nydus image without FUSE and
+// Nydusd, it's useful in the restricted environments like CI containers.
+// The file data is always read from backend to compare the content hash.
+func (rule *FilesystemRule) validateNative() error {
+	ctx := context.Background()
+
+	if err := rule.unpackSourceImage(ctx); err != nil {
+		return errors.Wrap(err, "unpack source image")
+	}
+
+	if err := rule.unpackNydusImage(ctx); err != nil {
+		return err
+	}
+
+	return rule.verify()
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package rule
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func makeLayer(t *testing.T, files map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(content)),
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return &buf
+}
+
+func TestApplyLayer(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "rootfs")
+
+	lower := makeLayer(t, map[string]string{"foo": "foo", "bar": "bar"})
+	require.NoError(t, applyLayer(context.Background(), dst, lower))
+
+	upper := makeLayer(t, map[string]string{".wh.foo": "", "bar": "bar1"})
+	require.NoError(t, applyLayer(context.Background(), dst, upper))
+
+	_, err := os.Stat(filepath.Join(dst, "foo"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dst, ".wh.foo"))
+	require.True(t, os.IsNotExist(err))
+
+	data, err := os.ReadFile(filepath.Join(dst, "bar"))
+	require.NoError(t, err)
+	require.Equal(t, "bar1", string(data))
+}
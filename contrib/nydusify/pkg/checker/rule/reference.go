@@ -0,0 +1,52 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package rule
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
+)
+
+// referenceIgnoredXattrs are the xattrs labeled by container runtime rather
+// than from image layers.
+var referenceIgnoredXattrs = []string{"security.selinux"}
+
+// validateReference compares the Nydus image with the root filesystem of
+// a running container of the source image, which is assembled by the real
+// overlay mount of container runtime, so that the whiteout handling of
+// conversion is verified against the actual behavior rather than the
+// layers applied by nydusify. The mount points in container like `/proc`
+// and `/etc/hosts` are skipped on both sides.
+func (rule *FilesystemRule) validateReference() error {
+	container, err := tool.InspectContainer(rule.ReferenceContainer)
+	if err != nil {
+		return errors.Wrap(err, "inspect reference container")
+	}
+	logrus.Infof("Comparing Nydus image with the rootfs of container %s", rule.ReferenceContainer)
+	rule.SourceMountPath = container.Rootfs
+	rule.excludes = map[string]bool{}
+	for _, mount := range container.Mounts {
+		rule.excludes[mount] = true
+	}
+
+	if rule.Native {
+		if err := rule.unpackNydusImage(context.Background()); err != nil {
+			return err
+		}
+		return rule.verify()
+	}
+
+	nydusd, err := rule.mountNydusImage()
+	if err != nil {
+		return err
+	}
+	defer nydusd.Umount(false)
+
+	return rule.verify()
+}
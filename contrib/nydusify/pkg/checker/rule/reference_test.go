@@ -0,0 +1,47 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package rule
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
+)
+
+func TestValidateReference(t *testing.T) {
+	rule := &FilesystemRule{ReferenceContainer: "invalid"}
+	require.ErrorContains(t, rule.Validate(), "inspect reference container")
+
+	// The mount points of container are skipped on both sides.
+	container := t.TempDir()
+	nydus := t.TempDir()
+	for _, root := range []string{container, nydus} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "proc"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "etc", "hosts"), []byte(root), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "etc", "os-release"), []byte("nydus"), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(container, "proc", "cpuinfo"), []byte("cpu"), 0644))
+
+	rule = &FilesystemRule{
+		ReferenceContainer: "docker://app",
+		SourceMountPath:    container,
+		NydusdConfig:       tool.NydusdConfig{MountPath: nydus, BackendType: "localfs"},
+		ReportPath:         filepath.Join(t.TempDir(), "report.json"),
+		excludes:           map[string]bool{"/proc": true, "/etc/hosts": true},
+	}
+	require.NoError(t, rule.verify())
+
+	// The whiteout missed by conversion is caught.
+	require.NoError(t, os.WriteFile(filepath.Join(nydus, "etc", "deleted"), []byte("deleted"), 0644))
+	require.ErrorContains(t, rule.verify(), "File not found in source image: /etc/deleted")
+	data, err := os.ReadFile(rule.ReportPath)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"source": "docker://app"`)
+}
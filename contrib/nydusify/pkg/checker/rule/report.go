@@ -0,0 +1,129 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package rule
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// The kinds of discrepancy between source and Nydus image.
+const (
+	MissingInNydus  = "missing_in_nydus"
+	MissingInSource = "missing_in_source"
+	Mismatch        = "mismatch"
+)
+
+// Discrepancy is a file differing between source and Nydus image.
+type Discrepancy struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	// Fields are the mismatched attributes of file, `hash` for file data.
+	Fields []string `json:"fields,omitempty"`
+	Source string   `json:"source,omitempty"`
+	Nydus  string   `json:"nydus,omitempty"`
+}
+
+func (d *Discrepancy) String() string {
+	switch d.Kind {
+	case MissingInNydus:
+		return fmt.Sprintf("File not found in Nydus image: %s", d.Path)
+	case MissingInSource:
+		return fmt.Sprintf("File not found in source image: %s", d.Path)
+	default:
+		return fmt.Sprintf("File not match in Nydus image: %s <=> %s", d.Source, d.Nydus)
+	}
+}
+
+// FilesystemReport is the machine-readable result of filesystem
+// verification.
+type FilesystemReport struct {
+	Source        string        `json:"source"`
+	Target        string        `json:"target"`
+	Files         int           `json:"files"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// diffNodes returns the mismatched attributes of the two nodes.
+func diffNodes(source, nydus *Node) []string {
+	fields := []string{}
+	if source.Size != nydus.Size {
+		fields = append(fields, "size")
+	}
+	if source.Mode != nydus.Mode {
+		fields = append(fields, "mode")
+	}
+	if source.Rdev != nydus.Rdev {
+		fields = append(fields, "rdev")
+	}
+	if source.Symlink != nydus.Symlink {
+		fields = append(fields, "symlink")
+	}
+	if source.UID != nydus.UID {
+		fields = append(fields, "uid")
+	}
+	if source.GID != nydus.GID {
+		fields = append(fields, "gid")
+	}
+	if !reflect.DeepEqual(source.Xattrs, nydus.Xattrs) {
+		fields = append(fields, "xattrs")
+	}
+	if !bytes.Equal(source.Hash, nydus.Hash) {
+		fields = append(fields, "hash")
+	}
+	return fields
+}
+
+// compareNodes compares the files walked from source and Nydus image, the
+// discrepancies are sorted by path.
+func compareNodes(sourceNodes, nydusNodes map[string]Node) []Discrepancy {
+	discrepancies := []Discrepancy{}
+	for path, sourceNode := range sourceNodes {
+		sourceNode := sourceNode
+		nydusNode, exist := nydusNodes[path]
+		if !exist {
+			discrepancies = append(discrepancies, Discrepancy{Path: path, Kind: MissingInNydus})
+			continue
+		}
+		if path == "/" {
+			continue
+		}
+		if fields := diffNodes(&sourceNode, &nydusNode); len(fields) > 0 {
+			discrepancies = append(discrepancies, Discrepancy{
+				Path:   path,
+				Kind:   Mismatch,
+				Fields: fields,
+				Source: sourceNode.String(),
+				Nydus:  nydusNode.String(),
+			})
+		}
+	}
+	for path := range nydusNodes {
+		if _, exist := sourceNodes[path]; !exist {
+			discrepancies = append(discrepancies, Discrepancy{Path: path, Kind: MissingInSource})
+		}
+	}
+	sort.Slice(discrepancies, func(i, j int) bool {
+		return discrepancies[i].Path < discrepancies[j].Path
+	})
+	return discrepancies
+}
+
+func (report *FilesystemReport) dump(path string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal filesystem report")
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrap(err, "write filesystem report")
+	}
+	return nil
+}
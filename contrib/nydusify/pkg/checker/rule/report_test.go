@@ -0,0 +1,67 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package rule
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
+)
+
+func TestFilesystemReport(t *testing.T) {
+	source := t.TempDir()
+	nydus := t.TempDir()
+	write := func(root, name, content string, mode os.FileMode) {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), mode))
+		require.NoError(t, os.Chmod(path, mode))
+	}
+	write(source, "same", "same", 0644)
+	write(nydus, "same", "same", 0644)
+	write(source, "data", "foo", 0644)
+	write(nydus, "data", "bar", 0644)
+	write(source, "mode", "mode", 0644)
+	write(nydus, "mode", "mode", 0755)
+	write(source, "lost", "lost", 0644)
+	write(nydus, "extra", "extra", 0644)
+
+	rule := &FilesystemRule{
+		Source:          "source",
+		Target:          "target",
+		SourceMountPath: source,
+		NydusdConfig:    tool.NydusdConfig{MountPath: nydus, BackendType: "localfs"},
+		ReportPath:      filepath.Join(t.TempDir(), "report.json"),
+	}
+	err := rule.verify()
+	require.ErrorContains(t, err, "found 4 discrepancies in Nydus image, the first one: File not match in Nydus image")
+
+	data, err := os.ReadFile(rule.ReportPath)
+	require.NoError(t, err)
+	var report FilesystemReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Equal(t, "source", report.Source)
+	require.Equal(t, 5, report.Files)
+	require.Len(t, report.Discrepancies, 4)
+	require.Equal(t, Discrepancy{Path: "/data", Kind: Mismatch, Fields: []string{"hash"}}, Discrepancy{
+		Path: report.Discrepancies[0].Path, Kind: report.Discrepancies[0].Kind, Fields: report.Discrepancies[0].Fields,
+	})
+	require.Equal(t, Discrepancy{Path: "/extra", Kind: MissingInSource}, report.Discrepancies[1])
+	require.Equal(t, Discrepancy{Path: "/lost", Kind: MissingInNydus}, report.Discrepancies[2])
+	require.Equal(t, "/mode", report.Discrepancies[3].Path)
+	require.Equal(t, []string{"mode"}, report.Discrepancies[3].Fields)
+
+	// The report is saved even if no discrepancy is found.
+	rule.SourceMountPath = nydus
+	require.NoError(t, rule.verify())
+	data, err = os.ReadFile(rule.ReportPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Empty(t, report.Discrepancies)
+}
@@ -4,7 +4,14 @@
 
 package rule
 
+import "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+
 type Rule interface {
 	Validate() error
 	Name() string
 }
+
+// mismatch marks err as the failure of image not satisfying the rule.
+func mismatch(err error) error {
+	return utils.WithExitCode(err, utils.ExitCheckMismatch)
+}
@@ -15,6 +15,15 @@ type BuilderOption struct {
 	DebugOutputPath string
 }
 
+// UnpackOption is the option of `nydus-image unpack`.
+type UnpackOption struct {
+	BootstrapPath string
+	// ConfigPath is the Nydusd configuration file providing the storage
+	// backend to fetch chunk data from.
+	ConfigPath string
+	OutputPath string
+}
+
 type Builder struct {
 	binaryPath string
 	stdout     io.Writer
@@ -48,3 +57,26 @@ func (builder *Builder) Check(option BuilderOption) error {
 
 	return cmd.Run()
 }
+
+// Unpack calls `nydus-image unpack` to read the RAFS filesystem in user
+// space and write it to the specified tar file, the chunk data is fetched
+// from the backend in configuration file, without FUSE and Nydusd.
+func (builder *Builder) Unpack(option UnpackOption) error {
+	args := []string{
+		"unpack",
+		"--log-level",
+		"warn",
+		"--config",
+		option.ConfigPath,
+		"--output",
+		option.OutputPath,
+		"--bootstrap",
+		option.BootstrapPath,
+	}
+
+	cmd := exec.Command(builder.binaryPath, args...)
+	cmd.Stdout = builder.stdout
+	cmd.Stderr = builder.stderr
+
+	return cmd.Run()
+}
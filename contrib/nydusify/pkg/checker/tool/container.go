@@ -0,0 +1,112 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tool
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// containerInspectors are the commands printing the PID of container init
+// process by the runtime prefix of container reference.
+var containerInspectors = map[string][]string{
+	"docker":  {"docker", "inspect", "--format", "{{.State.Pid}}"},
+	"podman":  {"podman", "inspect", "--format", "{{.State.Pid}}"},
+	"nerdctl": {"nerdctl", "inspect", "--format", "{{.State.Pid}}"},
+	"crictl":  {"crictl", "inspect", "--output", "go-template", "--template", "{{.info.pid}}"},
+}
+
+// Container is the root filesystem of a running container seen from host.
+type Container struct {
+	// Rootfs is the root directory of container, i.e. `/proc/<pid>/root/`.
+	Rootfs string
+	// Mounts are the mount points in container except the root, like
+	// `/proc` and `/etc/hosts`, which aren't part of image.
+	Mounts []string
+}
+
+// containerPid returns the PID of container init process, the reference is
+// either a PID or in the form of `<runtime>://<container id>`, where
+// runtime is docker, podman, nerdctl or crictl.
+func containerPid(ref string) (int, error) {
+	if pid, err := strconv.Atoi(ref); err == nil {
+		return pid, nil
+	}
+	parts := strings.SplitN(ref, "://", 2)
+	inspector, ok := containerInspectors[parts[0]]
+	if len(parts) != 2 || parts[1] == "" || !ok {
+		return 0, fmt.Errorf("invalid container %s, should be a PID or in the form of <docker|podman|nerdctl|crictl>://<container id>", ref)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(inspector[0], append(inspector[1:], parts[1])...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, errors.Wrapf(err, "inspect container %s: %s", ref, strings.TrimSpace(stderr.String()))
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("container %s isn't running", ref)
+	}
+	return pid, nil
+}
+
+// parseMountInfo returns the mount points except the root in mountinfo of
+// process, which are relative to the root of process.
+func parseMountInfo(reader io.Reader) ([]string, error) {
+	mounts := []string{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		// For example:
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("invalid mountinfo line %q", scanner.Text())
+		}
+		// The space, tab, newline and backslash are escaped in octal.
+		mountPoint, err := strconv.Unquote(`"` + strings.ReplaceAll(fields[4], `"`, `\"`) + `"`)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid mount point %s", fields[4])
+		}
+		if mountPoint != "/" {
+			mounts = append(mounts, filepath.Clean(mountPoint))
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// InspectContainer returns the root filesystem of the running container,
+// see containerPid for the form of reference. Reading the root filesystem
+// of other processes requires root.
+func InspectContainer(ref string) (*Container, error) {
+	pid, err := containerPid(ref)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(fmt.Sprintf("/proc/%d/mountinfo", pid))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read mounts of container %s", ref)
+	}
+	defer file.Close()
+	mounts, err := parseMountInfo(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse mounts of container %s", ref)
+	}
+	// The trailing slash follows the `root` symlink when walking.
+	return &Container{
+		Rootfs: fmt.Sprintf("/proc/%d/root/", pid),
+		Mounts: mounts,
+	}, nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tool
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	mountInfo := `1380 1200 0:140 / / rw,relatime master:520 - overlay overlay rw,lowerdir=/l1:/l2,upperdir=/u,workdir=/w
+1381 1380 0:143 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+1385 1380 253:1 /var/lib/docker/containers/abc/hosts /etc/hosts rw,relatime - ext4 /dev/vda1 rw
+1386 1380 253:1 /data /mnt/my\040data rw,relatime - ext4 /dev/vda1 rw
+`
+	mounts, err := parseMountInfo(strings.NewReader(mountInfo))
+	require.NoError(t, err)
+	require.Equal(t, []string{"/proc", "/etc/hosts", "/mnt/my data"}, mounts)
+
+	_, err = parseMountInfo(strings.NewReader("invalid\n"))
+	require.Error(t, err)
+}
+
+func TestInspectContainer(t *testing.T) {
+	pid := os.Getpid()
+	container, err := InspectContainer(strconv.Itoa(pid))
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("/proc/%d/root/", pid), container.Rootfs)
+	require.Contains(t, container.Mounts, "/proc")
+
+	for _, ref := range []string{"abc", "unknown://abc", "docker://"} {
+		_, err = InspectContainer(ref)
+		require.ErrorContains(t, err, "invalid container")
+	}
+}
@@ -50,6 +50,7 @@ type Image struct {
 	Source     string
 	SourcePath string
 	Rootfs     string
+	stateFile  string
 }
 
 // Mount mounts rootfs of OCI image.
@@ -71,6 +72,16 @@ func (image *Image) Mount() error {
 		return errors.Wrap(err, "mount source layer")
 	}
 
+	stateFile, err := track(MountState{
+		Kind:      MountKindOverlay,
+		MountPath: image.Rootfs,
+	}, image.Umount)
+	if err != nil {
+		image.Umount()
+		return err
+	}
+	image.stateFile = stateFile
+
 	return nil
 }
 
@@ -78,6 +89,8 @@ func (image *Image) Mount() error {
 func (image *Image) Umount() error {
 	if _, err := os.Stat(image.Rootfs); err != nil {
 		if os.IsNotExist(err) {
+			untrack(image.stateFile)
+			image.stateFile = ""
 			return nil
 		}
 		return errors.Wrap(err, "stat rootfs")
@@ -86,6 +99,8 @@ func (image *Image) Umount() error {
 	if err := mount.Unmount(image.Rootfs, 0); err != nil {
 		return errors.Wrap(err, "umount rootfs")
 	}
+	untrack(image.stateFile)
+	image.stateFile = ""
 
 	if err := os.RemoveAll(image.Rootfs); err != nil {
 		return errors.Wrap(err, "remove rootfs")
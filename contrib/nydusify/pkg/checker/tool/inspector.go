@@ -14,6 +14,7 @@ import (
 
 const (
 	GetBlobs = iota
+	GetPrefetch
 )
 
 type InspectOption struct {
@@ -41,6 +42,15 @@ func (infos BlobInfoList) String() string {
 	return string(jsonBytes)
 }
 
+// PrefetchFile is the file in the prefetch table of bootstrap, a hardlinked
+// file has multiple paths.
+type PrefetchFile struct {
+	Inode uint64   `json:"inode"`
+	Path  []string `json:"path"`
+}
+
+type PrefetchFileList []PrefetchFile
+
 type Inspector struct {
 	binaryPath string
 }
@@ -71,6 +81,18 @@ func (p *Inspector) Inspect(option InspectOption) (interface{}, error) {
 			return nil, err
 		}
 		return blobs, nil
+	case GetPrefetch:
+		args = append(args, "prefetch")
+		cmd := exec.Command(p.binaryPath, args...)
+		msg, err := cmd.CombinedOutput()
+		if err != nil {
+			return nil, errors.Wrap(err, string(msg))
+		}
+		var files PrefetchFileList
+		if err = json.Unmarshal(msg, &files); err != nil {
+			return nil, err
+		}
+		return files, nil
 	}
 	return nil, fmt.Errorf("not support method %d", option.Operation)
 }
@@ -0,0 +1,153 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tool
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileInfo is a file in RAFS filesystem.
+type FileInfo struct {
+	Path string `json:"path"`
+	// Type is `file`, `dir`, `symlink`, `hardlink`, or `other` for the
+	// device, fifo and socket files.
+	Type  string `json:"type"`
+	Size  uint64 `json:"size"`
+	Mtime uint64 `json:"mtime"`
+	// Link is the target of symlink.
+	Link string `json:"link,omitempty"`
+}
+
+// inodePattern matches the inode printed by `nydus-image check --verbose`,
+// for example:
+//
+//	inode: symlink "/bin": index 2 ino 2 ... i_size 7 ... link Some("usr/bin") i_mtime 1686700000 i_mtime_nsec 0
+var inodePattern = regexp.MustCompile(`^inode: (\w*) "((?:[^"\\]|\\.)*)": index .* i_size (\d+) .* link (?:Some\("((?:[^"\\]|\\.)*)"\)|None) i_mtime (\d+) `)
+
+// unquoteRust unescapes the string escaped by Rust debug format, i.e. `\t`,
+// `\r`, `\n`, `\\`, `\"`, `\'`, `\0`, `\u{XX}`, and `\xXX` for the invalid
+// UTF-8 bytes of path.
+func unquoteRust(value string) (string, error) {
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf.WriteByte(value[i])
+			continue
+		}
+		if i++; i >= len(value) {
+			return "", errors.Errorf("invalid escape in %q", value)
+		}
+		switch value[i] {
+		case 't':
+			buf.WriteByte('\t')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'n':
+			buf.WriteByte('\n')
+		case '0':
+			buf.WriteByte(0)
+		case '\\', '"', '\'':
+			buf.WriteByte(value[i])
+		case 'x':
+			if i+3 > len(value) {
+				return "", errors.Errorf("invalid escape in %q", value)
+			}
+			code, err := strconv.ParseUint(value[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", errors.Errorf("invalid escape in %q", value)
+			}
+			buf.WriteByte(byte(code))
+			i += 2
+		case 'u':
+			end := strings.IndexByte(value[i:], '}')
+			if !strings.HasPrefix(value[i:], "u{") || end < 0 {
+				return "", errors.Errorf("invalid escape in %q", value)
+			}
+			code, err := strconv.ParseUint(value[i+2:i+end], 16, 32)
+			if err != nil {
+				return "", errors.Errorf("invalid escape in %q", value)
+			}
+			buf.WriteRune(rune(code))
+			i += end
+		default:
+			return "", errors.Errorf("invalid escape in %q", value)
+		}
+	}
+	return buf.String(), nil
+}
+
+// parseInodes parses the inodes printed by `nydus-image check --verbose`,
+// the chunk lines are skipped.
+func parseInodes(reader io.Reader) ([]FileInfo, error) {
+	files := []FileInfo{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "inode: ") {
+			continue
+		}
+		match := inodePattern.FindStringSubmatch(line)
+		if match == nil {
+			return nil, errors.Errorf("unrecognized inode %q", line)
+		}
+		path, err := unquoteRust(match[2])
+		if err != nil {
+			return nil, err
+		}
+		link, err := unquoteRust(match[4])
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.ParseUint(match[3], 10, 64)
+		mtime, _ := strconv.ParseUint(match[5], 10, 64)
+		file := FileInfo{
+			Path:  path,
+			Type:  match[1],
+			Size:  size,
+			Mtime: mtime,
+			Link:  link,
+		}
+		if file.Type == "" {
+			file.Type = "other"
+		}
+		files = append(files, file)
+	}
+	return files, errors.Wrap(scanner.Err(), "read inodes")
+}
+
+// List calls `nydus-image check --verbose` to list the files in Nydus
+// bootstrap, in the pre-order of directory tree.
+func (builder *Builder) List(bootstrapPath string) ([]FileInfo, error) {
+	args := []string{
+		"check",
+		"--log-level",
+		"warn",
+		"--verbose",
+		"--bootstrap",
+		bootstrapPath,
+	}
+
+	stdout := bytes.Buffer{}
+	cmd := exec.Command(builder.binaryPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = builder.stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrap(err, "run nydus-image check")
+	}
+
+	return parseInodes(&stdout)
+}
@@ -0,0 +1,34 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tool
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInodes(t *testing.T) {
+	output := `inode: dir "/": index 1 ino 1 real_ino 1 child_index 2 child_count 3 i_nlink 3 i_size 4096 i_blocks 8 i_name_size 0 i_symlink_size 0 has_xattr false link None i_mtime 1686700000 i_mtime_nsec 0
+inode: symlink "/bin": index 2 ino 2 real_ino 2 child_index 0 child_count 0 i_nlink 1 i_size 7 i_blocks 0 i_name_size 3 i_symlink_size 7 has_xattr false link Some("usr/bin") i_mtime 1686700001 i_mtime_nsec 0
+inode: file "/a \"b\"\u{1b}\xff": index 3 ino 3 real_ino 3 child_index 0 child_count 1 i_nlink 1 i_size 1024 i_blocks 8 i_name_size 14 i_symlink_size 0 has_xattr true link None i_mtime 1686700002 i_mtime_nsec 10
+	 chunk: file_offset 0, chunk_info ChunkInfo { blob_index 0 }
+inode:  "/null": index 4 ino 4 real_ino 4 child_index 0 child_count 0 i_nlink 1 i_size 0 i_blocks 0 i_name_size 4 i_symlink_size 0 has_xattr false link None i_mtime 1686700003 i_mtime_nsec 0
+`
+	files, err := parseInodes(strings.NewReader(output))
+	require.NoError(t, err)
+	require.Equal(t, []FileInfo{
+		{Path: "/", Type: "dir", Size: 4096, Mtime: 1686700000},
+		{Path: "/bin", Type: "symlink", Size: 7, Mtime: 1686700001, Link: "usr/bin"},
+		{Path: "/a \"b\"\x1b\xff", Type: "file", Size: 1024, Mtime: 1686700002},
+		{Path: "/null", Type: "other", Mtime: 1686700003},
+	}, files)
+
+	_, err = parseInodes(strings.NewReader(`inode: file "/a": unknown`))
+	require.Error(t, err)
+	_, err = parseInodes(strings.NewReader(`inode: file "/a\q": index 1 ino 1 i_size 0 has_xattr false link None i_mtime 0 i_mtime_nsec 0`))
+	require.Error(t, err)
+}
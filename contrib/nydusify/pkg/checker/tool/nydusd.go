@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 type NydusdConfig struct {
@@ -37,6 +38,11 @@ type NydusdConfig struct {
 // Nydusd runs nydusd binary.
 type Nydusd struct {
 	NydusdConfig
+	// cmd is the nydusd process started by Mount, which is closed by
+	// exited once the process exits.
+	cmd       *exec.Cmd
+	exited    chan struct{}
+	stateFile string
 }
 
 type daemonInfo struct {
@@ -69,7 +75,8 @@ var configTpl = `
 }
 `
 
-func makeConfig(conf NydusdConfig) error {
+// MakeConfig writes the Nydusd configuration file to conf.ConfigPath.
+func MakeConfig(conf NydusdConfig) error {
 	tpl := template.Must(template.New("").Parse(configTpl))
 
 	var ret bytes.Buffer
@@ -151,7 +158,7 @@ func checkReady(ctx context.Context, sock string) (<-chan bool, error) {
 }
 
 func NewNydusd(conf NydusdConfig) (*Nydusd, error) {
-	if err := makeConfig(conf); err != nil {
+	if err := MakeConfig(conf); err != nil {
 		return nil, errors.Wrapf(err, "failed to create configuration file for Nydusd")
 	}
 	return &Nydusd{
@@ -183,35 +190,64 @@ func (nydusd *Nydusd) Mount() error {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	runErr := make(chan error)
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "run Nydusd binary")
+	}
+	var runErr error
+	exited := make(chan struct{})
 	go func() {
-		runErr <- cmd.Run()
+		runErr = cmd.Wait()
+		close(exited)
 	}()
+	nydusd.cmd = cmd
+	nydusd.exited = exited
+
+	// Record the mount, so that it's cleaned up on signal, or by
+	// `nydusify prune --mounts` if nydusify crashes.
+	stateFile, err := track(MountState{
+		Kind:      MountKindNydusd,
+		MountPath: nydusd.MountPath,
+		PID:       cmd.Process.Pid,
+	}, func() error {
+		return nydusd.Umount(true)
+	})
+	if err != nil {
+		nydusd.stop()
+		return err
+	}
+	nydusd.stateFile = stateFile
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	ready, err := checkReady(ctx, nydusd.APISockPath)
 	if err != nil {
+		nydusd.Umount(true)
 		return errors.New("check Nydusd state")
 	}
 
 	select {
-	case err := <-runErr:
-		if err != nil {
-			return errors.Wrap(err, "run Nydusd binary")
+	case <-exited:
+		if runErr != nil {
+			nydusd.Umount(true)
+			return errors.Wrap(runErr, "run Nydusd binary")
 		}
+		return nil
 	case <-ready:
 		return nil
 	case <-time.After(30 * time.Second):
+		nydusd.Umount(true)
 		return errors.New("timeout to wait Nydusd ready")
 	}
-
-	return nil
 }
 
+// Umount umounts the mountpoint and stops the nydusd process started by
+// Mount, silent hides the output of umount.
 func (nydusd *Nydusd) Umount(silent bool) error {
-	if _, err := os.Stat(nydusd.MountPath); err == nil {
+	defer nydusd.stop()
+
+	// The stale mountpoint of exited nydusd fails stat with ENOTCONN.
+	if _, err := os.Stat(nydusd.MountPath); !os.IsNotExist(err) {
 		cmd := exec.Command("umount", nydusd.MountPath)
 
 		if !silent {
@@ -219,8 +255,27 @@ func (nydusd *Nydusd) Umount(silent bool) error {
 			cmd.Stderr = os.Stderr
 		}
 		if err := cmd.Run(); err != nil {
-			return err
+			// Fall back to fusermount and lazy umount, e.g. for
+			// unprivileged user or busy mountpoint.
+			return unmount(nydusd.MountPath)
 		}
 	}
 	return nil
 }
+
+// stop waits for nydusd exiting after umount, or kills it on timeout.
+func (nydusd *Nydusd) stop() {
+	if nydusd.cmd == nil {
+		return
+	}
+	select {
+	case <-nydusd.exited:
+	case <-time.After(killTimeout):
+		logrus.Warnf("kill Nydusd %d not exited after umount", nydusd.cmd.Process.Pid)
+		nydusd.cmd.Process.Kill()
+		<-nydusd.exited
+	}
+	nydusd.cmd = nil
+	untrack(nydusd.stateFile)
+	nydusd.stateFile = ""
+}
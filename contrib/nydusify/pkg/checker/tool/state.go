@@ -0,0 +1,205 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tool
+
+import (
+	"encoding/json"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const (
+	MountKindNydusd  = "nydusd"
+	MountKindOverlay = "overlay"
+
+	// killTimeout is the time to wait for nydusd exiting on SIGTERM before
+	// it's killed by SIGKILL.
+	killTimeout = 5 * time.Second
+)
+
+// MountState records a mount started by nydusify. It's saved in StateDir
+// until the mount is cleaned up, so that the mount survived a crash can be
+// found and cleaned up by PruneMounts.
+type MountState struct {
+	Kind      string `json:"kind"`
+	MountPath string `json:"mount_path"`
+	// PID is the nydusd process serving the mount, 0 for kernel mounts.
+	PID int `json:"pid,omitempty"`
+	// Owner is the nydusify process started the mount.
+	Owner     int       `json:"owner"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StateDir is the directory saving the states of mounts.
+var StateDir = DefaultStateDir()
+
+// DefaultStateDir returns the default directory saving the states of mounts.
+func DefaultStateDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "nydusify", "mounts")
+	}
+	return filepath.Join(home, ".nydusify", "mounts")
+}
+
+var (
+	trackedLock sync.Mutex
+	// tracked maps the state file of mounts started by current process to
+	// the function cleaning up the mount.
+	tracked = map[string]func() error{}
+)
+
+// track saves the state of mount and registers the cleanup function called
+// by Cleanup, the state file is returned.
+func track(state MountState, cleanup func() error) (string, error) {
+	mountPath, err := filepath.Abs(state.MountPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "get absolute path of %s", state.MountPath)
+	}
+	state.MountPath = mountPath
+	state.Owner = os.Getpid()
+	state.CreatedAt = time.Now()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal mount state")
+	}
+	if err := os.MkdirAll(StateDir, 0755); err != nil {
+		return "", errors.Wrap(err, "create mount state directory")
+	}
+	file := filepath.Join(StateDir, uuid.NewString()+".json")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return "", errors.Wrap(err, "save mount state")
+	}
+
+	trackedLock.Lock()
+	tracked[file] = cleanup
+	trackedLock.Unlock()
+
+	return file, nil
+}
+
+// untrack removes the state of mount once it's cleaned up.
+func untrack(file string) {
+	if file == "" {
+		return
+	}
+	trackedLock.Lock()
+	delete(tracked, file)
+	trackedLock.Unlock()
+
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).Warnf("remove mount state %s", file)
+	}
+}
+
+// Cleanup unmounts all the mounts and stops the nydusd processes started by
+// current process, which is used on panic or signal.
+func Cleanup() {
+	trackedLock.Lock()
+	cleanups := make([]func() error, 0, len(tracked))
+	for _, cleanup := range tracked {
+		cleanups = append(cleanups, cleanup)
+	}
+	trackedLock.Unlock()
+
+	for _, cleanup := range cleanups {
+		if err := cleanup(); err != nil {
+			logrus.WithError(err).Warn("clean up mount")
+		}
+	}
+}
+
+// HandleSignals cleans up the mounts and exits the process on SIGINT or
+// SIGTERM, the returned function stops handling the signals.
+func HandleSignals() func() {
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			logrus.Warnf("Received signal %s, cleaning up mounts", sig)
+			Cleanup()
+			code := 1
+			if sig, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(sig)
+			}
+			os.Exit(code)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// PruneMounts cleans up the mounts survived in the state directory dir,
+// i.e. unmounts them and stops their nydusd processes. The mounts of running
+// nydusify processes are skipped unless force is true. The pruned mounts are
+// returned.
+func PruneMounts(dir string, force bool) ([]MountState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "read mount state directory")
+	}
+
+	var pruned []MountState
+	var pruneErr error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return pruned, errors.Wrapf(err, "read mount state %s", file)
+		}
+		var state MountState
+		if err := json.Unmarshal(data, &state); err != nil {
+			logrus.WithError(err).Warnf("remove invalid mount state %s", file)
+			os.Remove(file)
+			continue
+		}
+		if !force && utils.ProcessAlive(state.Owner) {
+			logrus.Infof("skip %s mounted by running process %d", state.MountPath, state.Owner)
+			continue
+		}
+
+		if err := unmount(state.MountPath); err != nil {
+			pruneErr = err
+			continue
+		}
+		if state.PID > 0 {
+			if err := killNydusd(state.PID, state.MountPath); err != nil {
+				pruneErr = err
+				continue
+			}
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return pruned, errors.Wrapf(err, "remove mount state %s", file)
+		}
+		pruned = append(pruned, state)
+	}
+
+	return pruned, pruneErr
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tool
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// isMounted returns true if path is a mountpoint.
+func isMounted(path string) bool {
+	info, err := mount.Lookup(path)
+	return err == nil && info.Mountpoint == filepath.Clean(path)
+}
+
+// unmount unmounts path if it's a mountpoint, fusermount is used for FUSE
+// mounts, and the mount is detached lazily if it's busy, e.g. the stale
+// mount of exited nydusd.
+func unmount(path string) error {
+	if !isMounted(path) {
+		return nil
+	}
+	if err := mount.Unmount(path, 0); err != nil {
+		logrus.WithError(err).Warnf("umount %s, retrying with lazy umount", path)
+		if err := mount.Unmount(path, syscall.MNT_DETACH); err != nil {
+			return errors.Wrapf(err, "umount %s", path)
+		}
+	}
+	return nil
+}
+
+// killNydusd stops the nydusd process serving mountPath, the process isn't
+// touched if its command line doesn't contain mountPath, i.e. the pid has
+// been reused by another process.
+func killNydusd(pid int, mountPath string) error {
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || !bytes.Contains(cmdline, []byte(mountPath)) {
+		return nil
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		if err == syscall.ESRCH {
+			return nil
+		}
+		return errors.Wrapf(err, "kill nydusd %d", pid)
+	}
+	for deadline := time.Now().Add(killTimeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if !utils.ProcessAlive(pid) {
+			return nil
+		}
+	}
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return errors.Wrapf(err, "kill nydusd %d", pid)
+	}
+	return nil
+}
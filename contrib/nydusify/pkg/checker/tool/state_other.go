@@ -0,0 +1,22 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package tool
+
+// The mounts of nydusd and overlayfs are only made on Linux, so there is
+// nothing to clean up on other platforms.
+
+func isMounted(_ string) bool {
+	return false
+}
+
+func unmount(_ string) error {
+	return nil
+}
+
+func killNydusd(_ int, _ string) error {
+	return nil
+}
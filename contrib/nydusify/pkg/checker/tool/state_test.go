@@ -0,0 +1,72 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tool
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneMounts(t *testing.T) {
+	StateDir = t.TempDir()
+	defer func() {
+		StateDir = DefaultStateDir()
+	}()
+
+	// The mount of current process is cleaned up by Cleanup.
+	cleaned := 0
+	mountPath := t.TempDir()
+	file, err := track(MountState{Kind: MountKindOverlay, MountPath: mountPath}, func() error {
+		cleaned++
+		return nil
+	})
+	require.NoError(t, err)
+	Cleanup()
+	require.Equal(t, 1, cleaned)
+
+	// The mount of running process is skipped unless forced.
+	pruned, err := PruneMounts(StateDir, false)
+	require.NoError(t, err)
+	require.Empty(t, pruned)
+	_, err = os.Stat(file)
+	require.NoError(t, err)
+
+	// The mount of crashed process is pruned with its nydusd.
+	crashed := exec.Command("true")
+	require.NoError(t, crashed.Run())
+	nydusd := exec.Command("sh", "-c", "sleep 60 # "+mountPath)
+	require.NoError(t, nydusd.Start())
+	exited := make(chan error, 1)
+	go func() {
+		exited <- nydusd.Wait()
+	}()
+	data, err := json.Marshal(MountState{
+		Kind:      MountKindNydusd,
+		MountPath: mountPath,
+		PID:       nydusd.Process.Pid,
+		Owner:     crashed.ProcessState.Pid(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(StateDir, "crashed.json"), data, 0644))
+
+	pruned, err = PruneMounts(StateDir, false)
+	require.NoError(t, err)
+	require.Len(t, pruned, 1)
+	require.Equal(t, nydusd.Process.Pid, pruned[0].PID)
+	require.Error(t, <-exited)
+
+	pruned, err = PruneMounts(StateDir, true)
+	require.NoError(t, err)
+	require.Len(t, pruned, 1)
+	require.Equal(t, os.Getpid(), pruned[0].Owner)
+	entries, err := os.ReadDir(StateDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
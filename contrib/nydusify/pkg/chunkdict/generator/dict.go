@@ -0,0 +1,268 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package generator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/build"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// dictSource is a Nydus image merged into chunk dict.
+type dictSource struct {
+	parser        *parser.Parser
+	image         *parser.Image
+	bootstrapPath string
+}
+
+// dictBlob is a blob referenced by chunk dict, which is copied from the
+// source image to chunk dict image.
+type dictBlob struct {
+	desc   ocispec.Descriptor
+	source *remote.Remote
+}
+
+// buildDict merges the bootstraps of sources into the chunk dict, which
+// references the chunks of all sources, then saves it to OutputPath and pushes
+// it to Target as a Nydus image with the blobs of sources.
+func (generator *Generator) buildDict(ctx context.Context) error {
+	if len(generator.dictSources) == 0 {
+		return errors.New("no Nydus image is saved for chunk dict")
+	}
+
+	fsVersion := ""
+	bootstrapPaths := []string{}
+	blobs := []dictBlob{}
+	seen := map[digest.Digest]bool{}
+	for idx, source := range generator.dictSources {
+		bootstrapDesc := parser.FindNydusBootstrapDesc(&source.image.Manifest)
+		if bootstrapDesc == nil {
+			return errors.Errorf("bootstrap layer of %s is not found", source.parser.Remote.Ref)
+		}
+		version := bootstrapDesc.Annotations[utils.LayerAnnotationNydusFsVersion]
+		if idx > 0 && version != fsVersion {
+			return errors.Errorf("fs version %s of %s is inconsistent with %s", version, source.parser.Remote.Ref, fsVersion)
+		}
+		fsVersion = version
+		bootstrapPaths = append(bootstrapPaths, source.bootstrapPath)
+
+		for _, layer := range source.image.Manifest.Layers {
+			if layer.MediaType != utils.MediaTypeNydusBlob || seen[layer.Digest] {
+				continue
+			}
+			seen[layer.Digest] = true
+			blobs = append(blobs, dictBlob{desc: layer, source: source.parser.Remote})
+		}
+	}
+
+	dictPath := filepath.Join(generator.WorkDir, "chunk_dict.boot")
+	builder := build.NewBuilder(generator.NydusImagePath)
+	if err := builder.Merge(ctx, build.MergeOption{
+		SourceBootstrapPaths: bootstrapPaths,
+		TargetBootstrapPath:  dictPath,
+	}); err != nil {
+		return errors.Wrap(err, "merge bootstraps")
+	}
+	logrus.Infof("Merged %d bootstraps into chunk dict with %d blobs", len(bootstrapPaths), len(blobs))
+
+	if generator.OutputPath != "" {
+		if err := copyFile(dictPath, generator.OutputPath); err != nil {
+			return errors.Wrap(err, "save chunk dict")
+		}
+		logrus.Infof("Saved chunk dict to %s", generator.OutputPath)
+	}
+	if generator.Target != "" {
+		if err := generator.pushDict(ctx, dictPath, fsVersion, blobs); err != nil {
+			return errors.Wrap(err, "push chunk dict image")
+		}
+		logrus.Infof("Pushed chunk dict image to %s", generator.Target)
+	}
+
+	for _, source := range generator.dictSources {
+		if err := os.RemoveAll(filepath.Dir(source.bootstrapPath)); err != nil {
+			return errors.Wrap(err, "remove work directory")
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	reader, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	writer, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// push pushes the content opened by open, and retries with plain HTTP if
+// needed.
+func push(ctx context.Context, target *remote.Remote, desc ocispec.Descriptor, byDigest bool, open func() (io.ReadCloser, error)) error {
+	doPush := func() error {
+		reader, err := open()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		return target.Push(ctx, desc, byDigest, reader)
+	}
+	if err := doPush(); err != nil {
+		if !utils.RetryWithHTTP(err) {
+			return err
+		}
+		target.MaybeWithHTTP(err)
+		return doPush()
+	}
+	return nil
+}
+
+func pushJSON(ctx context.Context, target *remote.Remote, mediaType string, byDigest bool, v interface{}) (*ocispec.Descriptor, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "json marshal")
+	}
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	if err := push(ctx, target, desc, byDigest, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}); err != nil {
+		return nil, err
+	}
+	return &desc, nil
+}
+
+// packBootstrap packs the bootstrap into a gzip compressed layer, and
+// returns the layer descriptor and its uncompressed digest.
+func packBootstrap(bootstrapPath, layerPath, fsVersion string) (*ocispec.Descriptor, digest.Digest, error) {
+	reader, err := utils.PackTargz(bootstrapPath, utils.BootstrapFileNameInLayer, false)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "pack bootstrap")
+	}
+	defer reader.Close()
+
+	file, err := os.Create(layerPath)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "create bootstrap layer")
+	}
+	defer file.Close()
+
+	diffID := digest.SHA256.Digester()
+	layerDigester := digest.SHA256.Digester()
+	gzWriter := gzip.NewWriter(io.MultiWriter(file, layerDigester.Hash()))
+	if _, err := io.Copy(gzWriter, io.TeeReader(reader, diffID.Hash())); err != nil {
+		return nil, "", errors.Wrap(err, "compress bootstrap layer")
+	}
+	if err := gzWriter.Close(); err != nil {
+		return nil, "", errors.Wrap(err, "close gzip writer")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "stat bootstrap layer")
+	}
+
+	annotations := map[string]string{
+		utils.LayerAnnotationNydusBootstrap: "true",
+		utils.LayerAnnotationUncompressed:   diffID.Digest().String(),
+	}
+	if fsVersion != "" {
+		annotations[utils.LayerAnnotationNydusFsVersion] = fsVersion
+	}
+	return &ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageLayerGzip,
+		Digest:      layerDigester.Digest(),
+		Size:        info.Size(),
+		Annotations: annotations,
+	}, diffID.Digest(), nil
+}
+
+// pushDict pushes the chunk dict as a Nydus image, whose layers are the
+// blobs of sources and the bootstrap of chunk dict, the blobs are copied
+// from the source repositories.
+func (generator *Generator) pushDict(ctx context.Context, dictPath, fsVersion string, blobs []dictBlob) error {
+	target, err := provider.DefaultRemote(generator.Target, generator.TargetInsecure)
+	if err != nil {
+		return errors.Wrap(err, "create target remote")
+	}
+
+	layers := []ocispec.Descriptor{}
+	diffIDs := []digest.Digest{}
+	for _, blob := range blobs {
+		blob := blob
+		if err := push(ctx, target, blob.desc, true, func() (io.ReadCloser, error) {
+			return blob.source.Pull(ctx, blob.desc, true)
+		}); err != nil {
+			return errors.Wrapf(err, "copy blob %s", blob.desc.Digest)
+		}
+		layers = append(layers, blob.desc)
+		diffIDs = append(diffIDs, blob.desc.Digest)
+	}
+
+	layerPath := dictPath + ".tar.gz"
+	bootstrapDesc, diffID, err := packBootstrap(dictPath, layerPath, fsVersion)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(layerPath)
+	if err := push(ctx, target, *bootstrapDesc, true, func() (io.ReadCloser, error) {
+		return os.Open(layerPath)
+	}); err != nil {
+		return errors.Wrap(err, "push bootstrap layer")
+	}
+	layers = append(layers, *bootstrapDesc)
+	diffIDs = append(diffIDs, diffID)
+
+	config := ocispec.Image{
+		Platform: ocispec.Platform{
+			OS:           "linux",
+			Architecture: generator.ExpectedArch,
+		},
+		RootFS: ocispec.RootFS{
+			Type:    "layers",
+			DiffIDs: diffIDs,
+		},
+	}
+	configDesc, err := pushJSON(ctx, target, ocispec.MediaTypeImageConfig, true, config)
+	if err != nil {
+		return errors.Wrap(err, "push image config")
+	}
+
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    *configDesc,
+		Layers:    layers,
+	}
+	if _, err := pushJSON(ctx, target, ocispec.MediaTypeImageManifest, false, manifest); err != nil {
+		return errors.Wrap(err, "push image manifest")
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package generator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func putNydusImage(t *testing.T, registry *testutil.Registry, repo string, blobs ...string) ocispec.Descriptor {
+	bootstrapPath := filepath.Join(t.TempDir(), "bootstrap")
+	require.NoError(t, os.WriteFile(bootstrapPath, []byte(repo), 0644))
+	reader, err := utils.PackTargz(bootstrapPath, utils.BootstrapFileNameInLayer, true)
+	require.NoError(t, err)
+	bootstrapData, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	layers := []ocispec.Descriptor{}
+	for _, blob := range blobs {
+		layers = append(layers, registry.PutBlob(repo, utils.MediaTypeNydusBlob, []byte(blob)))
+	}
+	bootstrap := registry.PutBlob(repo, ocispec.MediaTypeImageLayerGzip, bootstrapData)
+	bootstrap.Annotations = map[string]string{
+		utils.LayerAnnotationNydusBootstrap: "true",
+		utils.LayerAnnotationNydusFsVersion: "6",
+	}
+	layers = append(layers, bootstrap)
+
+	config, err := json.Marshal(ocispec.Image{Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"}})
+	require.NoError(t, err)
+	desc, err := registry.PutManifest(repo, "latest", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    registry.PutBlob(repo, ocispec.MediaTypeImageConfig, config),
+		Layers:    layers,
+	})
+	require.NoError(t, err)
+	return desc
+}
+
+func TestGenerateChunkDict(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	host := registry.Host()
+
+	putNydusImage(t, registry, "library/app1", "blob1", "shared")
+	putNydusImage(t, registry, "library/app2", "shared", "blob2")
+	stub, err := testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{
+		Bootstrap: []byte("chunk dict"),
+	})
+	require.NoError(t, err)
+
+	outputPath := filepath.Join(t.TempDir(), "dict.boot")
+	generator, err := New(Opt{
+		WorkDir:        t.TempDir(),
+		Sources:        []string{host + "/library/app1:latest", host + "/library/app2:latest"},
+		NydusImagePath: stub.Path,
+		ExpectedArch:   "amd64",
+		Target:         host + "/library/dict:latest",
+		OutputPath:     outputPath,
+	})
+	require.NoError(t, err)
+	require.NoError(t, generator.Generate(context.Background()))
+
+	calls, err := stub.Calls()
+	require.NoError(t, err)
+	require.Len(t, calls, 3)
+	require.Equal(t, "merge", calls[2][0])
+	require.Len(t, calls[2], 7)
+	dict, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("chunk dict"), dict)
+
+	data, mediaType, ok := registry.Manifest("library/dict", "latest")
+	require.True(t, ok)
+	require.Equal(t, ocispec.MediaTypeImageManifest, mediaType)
+	var manifest ocispec.Manifest
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	require.Len(t, manifest.Layers, 4)
+	for idx, blob := range []string{"blob1", "shared", "blob2"} {
+		data, ok := registry.Blob("library/dict", manifest.Layers[idx].Digest)
+		require.True(t, ok)
+		require.Equal(t, []byte(blob), data)
+	}
+	bootstrap := manifest.Layers[3]
+	require.Equal(t, "true", bootstrap.Annotations[utils.LayerAnnotationNydusBootstrap])
+	require.Equal(t, "6", bootstrap.Annotations[utils.LayerAnnotationNydusFsVersion])
+
+	// The chunk dict image is parsed as a Nydus image.
+	unpackDir := t.TempDir()
+	data, ok = registry.Blob("library/dict", bootstrap.Digest)
+	require.True(t, ok)
+	require.NoError(t, utils.UnpackFile(bytes.NewReader(data), utils.BootstrapFileNameInLayer, filepath.Join(unpackDir, "bootstrap")))
+	dict, err = os.ReadFile(filepath.Join(unpackDir, "bootstrap"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("chunk dict"), dict)
+}
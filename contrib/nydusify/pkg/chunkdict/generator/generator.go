@@ -24,6 +24,16 @@ type Opt struct {
 	SourceInsecure bool
 	NydusImagePath string
 	ExpectedArch   string
+	// KeepGoing continues with the rest sources after a source fails.
+	KeepGoing bool
+	// Target is the reference to push the chunk dict image merged from
+	// sources, which is used by `--chunk-dict bootstrap:registry:<target>`
+	// of convert subcommand.
+	Target         string
+	TargetInsecure bool
+	// OutputPath is the path to save the bootstrap of chunk dict, which is
+	// used by `--chunk-dict bootstrap:local:<output>`.
+	OutputPath string
 }
 
 // Generator generates chunkdict by deduplicating multiple nydus images
@@ -31,6 +41,8 @@ type Opt struct {
 type Generator struct {
 	Opt
 	sourcesParser []*parser.Parser
+	// dictSources are the saved sources merged into chunk dict.
+	dictSources []dictSource
 }
 
 // New creates Generator instance.
@@ -59,14 +71,47 @@ func New(opt Opt) (*Generator, error) {
 
 // Generate saves multiple Nydus bootstraps into the database one by one.
 func (generator *Generator) Generate(ctx context.Context) error {
+	var batchErr *utils.BatchError
+	if generator.KeepGoing {
+		batchErr = utils.NewBatchError(len(generator.Sources))
+	}
 	for index := range generator.Sources {
-		if err := generator.save(ctx, index); err != nil {
-			if utils.RetryWithHTTP(err) {
-				generator.sourcesParser[index].Remote.MaybeWithHTTP(err)
+		if err := generator.trySave(ctx, index); err != nil {
+			if batchErr != nil {
+				batchErr.Add(generator.Sources[index], err)
+				continue
 			}
-			if err := generator.save(ctx, index); err != nil {
-				return err
+			return err
+		}
+	}
+	if generator.Target != "" || generator.OutputPath != "" {
+		if err := generator.buildDict(ctx); err != nil {
+			return errors.Wrap(err, "build chunk dict")
+		}
+	}
+	if batchErr != nil {
+		return batchErr.ErrorOrNil()
+	}
+	return nil
+}
+
+// trySave saves the Nydus image and retries with plain HTTP if needed,
+// the OCI artifact is skipped with warning.
+func (generator *Generator) trySave(ctx context.Context, index int) error {
+	if err := generator.save(ctx, index); err != nil {
+		if artifactErr, ok := utils.IsArtifactError(err); ok {
+			utils.WarnArtifact(artifactErr)
+			return nil
+		}
+		if utils.RetryWithHTTP(err) {
+			generator.sourcesParser[index].Remote.MaybeWithHTTP(err)
+		}
+		if err := generator.save(ctx, index); err != nil {
+			if artifactErr, ok := utils.IsArtifactError(err); ok {
+				utils.WarnArtifact(artifactErr)
+				return nil
 			}
+			return err
 		}
 	}
 	return nil
@@ -99,6 +144,15 @@ func (generator *Generator) save(ctx context.Context, index int) error {
 
 	logrus.Infof("Save chunk information from image %s", generator.sourcesParser[index].Remote.Ref)
 
+	// The bootstrap is kept to be merged into chunk dict.
+	if generator.Target != "" || generator.OutputPath != "" {
+		generator.dictSources = append(generator.dictSources, dictSource{
+			parser:        generator.sourcesParser[index],
+			image:         sourceParsed.NydusImage,
+			bootstrapPath: filepath.Join(folderPath, "nydus_bootstrap"),
+		})
+		return nil
+	}
 	if err := os.RemoveAll(folderPath); err != nil {
 		return errors.Wrap(err, "remove work directory")
 	}
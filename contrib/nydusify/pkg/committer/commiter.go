@@ -22,6 +22,7 @@ import (
 	"github.com/containerd/containerd/content/local"
 	"github.com/containerd/containerd/reference/docker"
 	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/build"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/committer/diff"
 	parserPkg "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
@@ -46,15 +47,20 @@ type Opt struct {
 	MaximumTimes   int
 	FsVersion      string
 	Compressor     string
+	// DigestAlgorithm is used for the bootstrap layer, config and manifest
+	// of committed image, the blob digests are always sha256 as they are
+	// referenced by blob id in bootstrap.
+	DigestAlgorithm digest.Algorithm
 
 	WithPaths    []string
 	WithoutPaths []string
 }
 
 type Committer struct {
-	workDir string
-	builder string
-	manager *Manager
+	workDir         string
+	builder         string
+	manager         *Manager
+	digestAlgorithm digest.Algorithm
 }
 
 func NewCommitter(opt Opt) (*Committer, error) {
@@ -71,10 +77,16 @@ func NewCommitter(opt Opt) (*Committer, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "new container manager")
 	}
+	digestAlgorithm := opt.DigestAlgorithm
+	if digestAlgorithm == "" {
+		digestAlgorithm = digest.Canonical
+	}
+
 	return &Committer{
-		workDir: workDir,
-		builder: opt.NydusImagePath,
-		manager: cm,
+		workDir:         workDir,
+		builder:         opt.NydusImagePath,
+		manager:         cm,
+		digestAlgorithm: digestAlgorithm,
 	}, nil
 }
 
@@ -424,7 +436,7 @@ func (cm *Committer) pushManifest(
 	}
 	defer bootstrapTarGz.Close()
 
-	digester := digest.SHA256.Digester()
+	digester := cm.digestAlgorithm.Digester()
 	gzWriter := gzip.NewWriter(io.MultiWriter(bootstrapTarGz, digester.Hash()))
 	if _, err := io.Copy(gzWriter, bootstrapTar); err != nil {
 		return errors.Wrap(err, "compress bootstrap tar to tar.gz")
@@ -494,7 +506,7 @@ func (cm *Committer) makeDesc(x interface{}, oldDesc ocispec.Descriptor) ([]byte
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "json marshal")
 	}
-	dgst := digest.SHA256.FromBytes(data)
+	dgst := cm.digestAlgorithm.FromBytes(data)
 
 	newDesc := oldDesc
 	newDesc.Size = int64(len(data))
@@ -557,7 +569,7 @@ func (cm *Committer) mergeBootstrap(
 	}
 	defer bootstrap.Close()
 
-	digester := digest.SHA256.Digester()
+	digester := cm.digestAlgorithm.Digester()
 	writer := io.MultiWriter(bootstrap, digester.Hash())
 
 	layers := []converter.Layer{}
@@ -662,11 +674,6 @@ func ValidateRef(ref string) (string, error) {
 	return named.String(), nil
 }
 
-type outputJSON struct {
-	FsVersion  string `json:"fs_version"`
-	Compressor string `json:"compressor"`
-}
-
 func (cm *Committer) obtainBootStrapInfo(ctx context.Context, BootstrapName string) (string, string, error) {
 	targetBootstrapPath := filepath.Join(cm.workDir, BootstrapName)
 	outputJSONPath := filepath.Join(cm.workDir, "output.json")
@@ -689,14 +696,10 @@ func (cm *Committer) obtainBootStrapInfo(ctx context.Context, BootstrapName stri
 		return "", "", errors.Wrap(err, "run merge command")
 	}
 
-	outputBytes, err := os.ReadFile(outputJSONPath)
-	if err != nil {
-		return "", "", errors.Wrapf(err, "read file %s", outputJSONPath)
-	}
-	var output outputJSON
-	err = json.Unmarshal(outputBytes, &output)
+	// The blob list is not concerned.
+	output, err := build.ParseOutputBlobs(outputJSONPath, nil)
 	if err != nil {
-		return "", "", errors.Wrapf(err, "unmarshal output json file %s", outputJSONPath)
+		return "", "", err
 	}
 	return output.FsVersion, strings.ToLower(output.Compressor), nil
 }
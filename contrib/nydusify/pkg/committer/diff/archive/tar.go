@@ -144,6 +144,10 @@ func (cw *ChangeWriter) HandleChange(k fs.ChangeKind, p string, f os.FileInfo, e
 		hdr.ModTime = hdr.ModTime.Truncate(time.Second)
 		hdr.AccessTime = time.Time{}
 		hdr.ChangeTime = time.Time{}
+		// uname and gname are looked up in the user database of host,
+		// the ownership is kept by uid and gid.
+		hdr.Uname = ""
+		hdr.Gname = ""
 
 		name := p
 		if strings.HasPrefix(name, string(filepath.Separator)) {
@@ -0,0 +1,20 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package diff
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Diff fails as the changes are read from the upper directory of overlayfs
+// mount, which is only supported on Linux.
+func Diff(_ context.Context, _ func(path string), _ []string, _ []string, _ io.Writer, _, _ string) error {
+	return errors.New("committing container changes is only supported on Linux")
+}
@@ -1,6 +1,7 @@
 package compactor
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
@@ -77,6 +78,13 @@ func NewCompactor(nydusImagePath, workdir, configPath string) (*Compactor, error
 }
 
 func (compactor *Compactor) Compact(bootstrapPath, chunkDict, backendType, backendConfigFile string) (string, error) {
+	return compactor.CompactWithContext(context.Background(), bootstrapPath, chunkDict, backendType, backendConfigFile)
+}
+
+// CompactWithContext is Compact, which kills nydus-image once ctx is done.
+func (compactor *Compactor) CompactWithContext(
+	ctx context.Context, bootstrapPath, chunkDict, backendType, backendConfigFile string,
+) (string, error) {
 	targetBootstrap := bootstrapPath + ".compact"
 	if err := os.Remove(targetBootstrap); err != nil && !os.IsNotExist(err) {
 		return "", errors.Wrap(err, "failed to delete old bootstrap file")
@@ -90,7 +98,7 @@ func (compactor *Compactor) Compact(bootstrapPath, chunkDict, backendType, backe
 	if err := os.Remove(outputJSONPath); err != nil && !os.IsNotExist(err) {
 		return "", errors.Wrap(err, "failed to delete old output-json file")
 	}
-	err := compactor.builder.Compact(build.CompactOption{
+	_, err := compactor.builder.CompactWithContext(ctx, build.CompactOption{
 		ChunkDict:           chunkDict,
 		BootstrapPath:       bootstrapPath,
 		OutputBootstrapPath: targetBootstrap,
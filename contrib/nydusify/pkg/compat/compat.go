@@ -0,0 +1,347 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package compat inspects the RAFS features used by a Nydus image, and
+// reports whether a given nydusd or nydus snapshotter version is able
+// to consume the image.
+package compat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/build"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const (
+	FeatureRafsV5     = "rafs-v5"
+	FeatureRafsV6     = "rafs-v6"
+	FeatureZstd       = "zstd"
+	FeatureChunkDict  = "chunk-dedup"
+	FeatureOCIRef     = "oci-ref"
+	FeatureEncryption = "encryption"
+	FeatureBatchChunk = "batch-chunk"
+)
+
+// requirement is the minimal component versions supporting a feature.
+type requirement struct {
+	feature     string
+	nydusd      string
+	snapshotter string
+}
+
+var requirements = []requirement{
+	{FeatureRafsV5, "v1.0.0", "v0.1.0"},
+	{FeatureRafsV6, "v2.0.0", "v0.2.0"},
+	{FeatureZstd, "v2.0.0", "v0.2.0"},
+	{FeatureChunkDict, "v2.0.0", "v0.2.0"},
+	{FeatureOCIRef, "v2.2.0", "v0.6.0"},
+	{FeatureBatchChunk, "v2.2.0", "v0.8.0"},
+	{FeatureEncryption, "v2.3.0", "v0.13.0"},
+}
+
+// undetectableFeatures can't be identified from the image manifest or
+// the output of `nydus-image check`.
+var undetectableFeatures = []string{FeatureBatchChunk}
+
+// Features presents the RAFS features used by a Nydus image.
+type Features struct {
+	FsVersion  string `json:"fs_version"`
+	Compressor string `json:"compressor,omitempty"`
+	ChunkDict  bool   `json:"chunk_dict"`
+	OCIRef     bool   `json:"oci_ref"`
+	Encrypted  bool   `json:"encrypted"`
+}
+
+func (features Features) names() []string {
+	names := []string{}
+	if features.FsVersion == "6" {
+		names = append(names, FeatureRafsV6)
+	} else {
+		names = append(names, FeatureRafsV5)
+	}
+	if features.Compressor == "zstd" {
+		names = append(names, FeatureZstd)
+	}
+	if features.ChunkDict {
+		names = append(names, FeatureChunkDict)
+	}
+	if features.OCIRef {
+		names = append(names, FeatureOCIRef)
+	}
+	if features.Encrypted {
+		names = append(names, FeatureEncryption)
+	}
+	return names
+}
+
+// Item is the compatibility result of a feature.
+type Item struct {
+	Feature   string `json:"feature"`
+	Required  string `json:"required"`
+	Satisfied bool   `json:"satisfied"`
+}
+
+// ComponentReport is the compatibility result of nydusd or snapshotter.
+type ComponentReport struct {
+	Version    string `json:"version"`
+	Items      []Item `json:"items"`
+	Compatible bool   `json:"compatible"`
+}
+
+// Report is the compatibility result of a Nydus image.
+type Report struct {
+	Image        string           `json:"image"`
+	Features     Features         `json:"features"`
+	Undetectable []string         `json:"undetectable"`
+	Nydusd       *ComponentReport `json:"nydusd,omitempty"`
+	Snapshotter  *ComponentReport `json:"snapshotter,omitempty"`
+	Compatible   bool             `json:"compatible"`
+}
+
+// DetectFeatures identifies the features used by Nydus image from the
+// layer annotations of image manifest. The chunk dedup is identified by
+// the bootstrap referencing blobs which aren't image layers, `blobs` is
+// the blob list in bootstrap and can be nil if unknown.
+func DetectFeatures(manifest *ocispec.Manifest, blobs []string) Features {
+	features := Features{
+		FsVersion: "5",
+	}
+
+	layerBlobs := map[string]bool{}
+	for _, layer := range manifest.Layers {
+		if layer.Annotations[utils.LayerAnnotationNydusEncryptedBlob] == "true" {
+			features.Encrypted = true
+		}
+		if layer.Annotations[utils.LayerAnnotationNydusRefLayer] != "" {
+			features.OCIRef = true
+		}
+		if layer.MediaType == utils.MediaTypeNydusBlob {
+			layerBlobs[layer.Digest.Encoded()] = true
+		}
+	}
+
+	if bootstrapDesc := parser.FindNydusBootstrapDesc(manifest); bootstrapDesc != nil {
+		if utils.GetNydusFsVersionOrDefault(bootstrapDesc.Annotations, utils.V5) == utils.V6 {
+			features.FsVersion = "6"
+		}
+		if bootstrapDesc.Annotations[utils.LayerAnnotationNydusReferenceBlobIDs] != "" {
+			features.ChunkDict = true
+		}
+	}
+
+	// The blob of OCI ref image is the original OCI layer, which is
+	// referenced by the RAFS blob id instead of layer digest.
+	if !features.OCIRef {
+		for _, blob := range blobs {
+			if !layerBlobs[blob] {
+				features.ChunkDict = true
+				break
+			}
+		}
+	}
+
+	return features
+}
+
+func evaluate(features Features, componentVersion string, required func(requirement) string) (*ComponentReport, error) {
+	ver, err := parseVersion(componentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	report := ComponentReport{
+		Version:    ver.String(),
+		Items:      []Item{},
+		Compatible: true,
+	}
+	used := map[string]bool{}
+	for _, name := range features.names() {
+		used[name] = true
+	}
+	for _, req := range requirements {
+		if !used[req.feature] {
+			continue
+		}
+		requiredVer, err := parseVersion(required(req))
+		if err != nil {
+			return nil, err
+		}
+		satisfied := !ver.less(requiredVer)
+		report.Items = append(report.Items, Item{
+			Feature:   req.feature,
+			Required:  requiredVer.String(),
+			Satisfied: satisfied,
+		})
+		if !satisfied {
+			report.Compatible = false
+		}
+	}
+
+	return &report, nil
+}
+
+// Evaluate checks the features against the specified nydusd and
+// snapshotter versions, an empty version skips the component.
+func Evaluate(features Features, nydusdVersion, snapshotterVersion string) (*Report, error) {
+	report := Report{
+		Features:     features,
+		Undetectable: undetectableFeatures,
+		Compatible:   true,
+	}
+
+	if nydusdVersion != "" {
+		nydusd, err := evaluate(features, nydusdVersion, func(req requirement) string {
+			return req.nydusd
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "evaluate nydusd version")
+		}
+		report.Nydusd = nydusd
+		report.Compatible = report.Compatible && nydusd.Compatible
+	}
+
+	if snapshotterVersion != "" {
+		snapshotter, err := evaluate(features, snapshotterVersion, func(req requirement) string {
+			return req.snapshotter
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "evaluate snapshotter version")
+		}
+		report.Snapshotter = snapshotter
+		report.Compatible = report.Compatible && snapshotter.Compatible
+	}
+
+	return &report, nil
+}
+
+// Opt defines compatibility check options.
+type Opt struct {
+	WorkDir        string
+	Image          string
+	ImageInsecure  bool
+	ExpectedArch   string
+	NydusImagePath string
+
+	NydusdVersion      string
+	SnapshotterVersion string
+}
+
+// Compat checks whether a Nydus image can be consumed by the specified
+// nydusd and snapshotter versions.
+type Compat struct {
+	Opt
+	parser *parser.Parser
+}
+
+// New creates Compat instance.
+func New(opt Opt) (*Compat, error) {
+	if opt.NydusdVersion == "" && opt.SnapshotterVersion == "" {
+		return nil, errors.New("at least one of nydusd version and snapshotter version should be specified")
+	}
+	remote, err := provider.DefaultRemote(opt.Image, opt.ImageInsecure)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create image provider")
+	}
+	imageParser, err := parser.New(remote, opt.ExpectedArch)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create image reference parser")
+	}
+	return &Compat{
+		Opt:    opt,
+		parser: imageParser,
+	}, nil
+}
+
+// Check inspects the Nydus image and outputs the compatibility report.
+func (compat *Compat) Check(ctx context.Context) (*Report, error) {
+	report, err := compat.check(ctx)
+	if err != nil {
+		if utils.RetryWithHTTP(err) {
+			compat.parser.Remote.MaybeWithHTTP(err)
+			return compat.check(ctx)
+		}
+		return nil, err
+	}
+	return report, nil
+}
+
+func (compat *Compat) check(ctx context.Context) (*Report, error) {
+	parsed, err := compat.parser.Parse(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse image reference")
+	}
+	if parsed.NydusImage == nil {
+		return nil, errors.Errorf("not a Nydus image: %s", compat.Image)
+	}
+
+	output, err := compat.inspectBootstrap(ctx, parsed.NydusImage)
+	if err != nil {
+		return nil, err
+	}
+
+	features := DetectFeatures(&parsed.NydusImage.Manifest, output.Blobs)
+	if output.FsVersion != "" {
+		features.FsVersion = output.FsVersion
+	}
+	features.Compressor = strings.ToLower(output.Compressor)
+
+	report, err := Evaluate(features, compat.NydusdVersion, compat.SnapshotterVersion)
+	if err != nil {
+		return nil, err
+	}
+	report.Image = compat.Image
+
+	return report, nil
+}
+
+// inspectBootstrap pulls the bootstrap of image and parses it by
+// `nydus-image check`.
+func (compat *Compat) inspectBootstrap(ctx context.Context, image *parser.Image) (*build.Output, error) {
+	if err := os.MkdirAll(compat.WorkDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create work directory")
+	}
+	workDir, err := os.MkdirTemp(compat.WorkDir, "nydusify-compat-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp directory")
+	}
+	defer os.RemoveAll(workDir)
+
+	logrus.Infof("Pulling Nydus bootstrap of %s", compat.Image)
+	bootstrapReader, err := compat.parser.PullNydusBootstrap(ctx, image)
+	if err != nil {
+		return nil, errors.Wrap(err, "pull Nydus bootstrap layer")
+	}
+	defer bootstrapReader.Close()
+
+	bootstrapPath := filepath.Join(workDir, "nydus_bootstrap")
+	if err := utils.UnpackFile(bootstrapReader, utils.BootstrapFileNameInLayer, bootstrapPath); err != nil {
+		return nil, errors.Wrap(err, "unpack Nydus bootstrap layer")
+	}
+
+	outputPath := filepath.Join(workDir, "nydus_output.json")
+	if err := tool.NewBuilder(compat.NydusImagePath).Check(tool.BuilderOption{
+		BootstrapPath:   bootstrapPath,
+		DebugOutputPath: outputPath,
+	}); err != nil {
+		return nil, errors.Wrap(err, "check Nydus bootstrap")
+	}
+
+	output, err := build.ParseOutput(outputPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse bootstrap check output")
+	}
+
+	return output, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package compat
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestParseVersion(t *testing.T) {
+	ver, err := parseVersion("v2.2.1-rc.1")
+	require.NoError(t, err)
+	require.Equal(t, "v2.2.1", ver.String())
+
+	ver, err = parseVersion("0.13")
+	require.NoError(t, err)
+	require.Equal(t, "v0.13.0", ver.String())
+
+	_, err = parseVersion("latest")
+	require.Error(t, err)
+	_, err = parseVersion("1.2.3.4")
+	require.Error(t, err)
+
+	v1, _ := parseVersion("v2.1.6")
+	v2, _ := parseVersion("v2.10.0")
+	require.True(t, v1.less(v2))
+	require.False(t, v2.less(v1))
+	require.False(t, v1.less(v1))
+}
+
+func TestDetectFeatures(t *testing.T) {
+	blobDigest := digest.FromString("blob")
+	manifest := ocispec.Manifest{
+		Layers: []ocispec.Descriptor{
+			{
+				MediaType: utils.MediaTypeNydusBlob,
+				Digest:    blobDigest,
+				Annotations: map[string]string{
+					utils.LayerAnnotationNydusEncryptedBlob: "true",
+				},
+			},
+			{
+				MediaType: ocispec.MediaTypeImageLayerGzip,
+				Digest:    digest.FromString("bootstrap"),
+				Annotations: map[string]string{
+					utils.LayerAnnotationNydusBootstrap: "true",
+					utils.LayerAnnotationNydusFsVersion: "6",
+				},
+			},
+		},
+	}
+
+	features := DetectFeatures(&manifest, []string{blobDigest.Encoded()})
+	require.Equal(t, Features{FsVersion: "6", Encrypted: true}, features)
+
+	features = DetectFeatures(&manifest, []string{blobDigest.Encoded(), digest.FromString("dict").Encoded()})
+	require.True(t, features.ChunkDict)
+}
+
+func TestEvaluate(t *testing.T) {
+	features := Features{FsVersion: "6", Compressor: "zstd", OCIRef: true}
+
+	report, err := Evaluate(features, "v2.1.0", "")
+	require.NoError(t, err)
+	require.False(t, report.Compatible)
+	require.Nil(t, report.Snapshotter)
+	require.Equal(t, []Item{
+		{Feature: FeatureRafsV6, Required: "v2.0.0", Satisfied: true},
+		{Feature: FeatureZstd, Required: "v2.0.0", Satisfied: true},
+		{Feature: FeatureOCIRef, Required: "v2.2.0", Satisfied: false},
+	}, report.Nydusd.Items)
+
+	report, err = Evaluate(features, "v2.2.0", "v0.6.0")
+	require.NoError(t, err)
+	require.True(t, report.Compatible)
+	require.True(t, report.Snapshotter.Compatible)
+
+	_, err = Evaluate(features, "invalid", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package compat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// version is a simplified semantic version, pre-release and build
+// metadata suffixes are ignored.
+type version [3]int
+
+func parseVersion(v string) (version, error) {
+	var ver version
+
+	s := strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if idx := strings.IndexAny(s, "-+"); idx >= 0 {
+		s = s[:idx]
+	}
+	parts := strings.Split(s, ".")
+	if s == "" || len(parts) > 3 {
+		return ver, fmt.Errorf("invalid version %s", v)
+	}
+	for idx, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil || num < 0 {
+			return ver, fmt.Errorf("invalid version %s", v)
+		}
+		ver[idx] = num
+	}
+
+	return ver, nil
+}
+
+func (ver version) less(other version) bool {
+	for idx := range ver {
+		if ver[idx] != other[idx] {
+			return ver[idx] < other[idx]
+		}
+	}
+	return false
+}
+
+func (ver version) String() string {
+	return fmt.Sprintf("v%d.%d.%d", ver[0], ver[1], ver[2])
+}
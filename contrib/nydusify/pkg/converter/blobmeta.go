@@ -0,0 +1,124 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	nydusConverter "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// blobMetaStore uploads the blob meta of layers converted by nydus-snapshotter
+// to storage backend as `$blob_id.blob.meta` once they're committed to
+// content store, alongside the blobs pushed by nydus-snapshotter.
+type blobMetaStore struct {
+	content.Store
+	backend   backend.Backend
+	forcePush bool
+	workDir   string
+}
+
+type blobMetaWriter struct {
+	content.Writer
+	store *blobMetaStore
+}
+
+func (s *blobMetaStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	writer, err := s.Store.Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return writer, nil
+		}
+	}
+	if !strings.HasPrefix(wOpts.Ref, convertedLayerRefPrefix) {
+		return writer, nil
+	}
+	return &blobMetaWriter{Writer: writer, store: s}, nil
+}
+
+func (w *blobMetaWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	err := w.Writer.Commit(ctx, size, expected, opts...)
+	if err != nil && !errdefs.IsAlreadyExists(err) {
+		return err
+	}
+	if err := w.store.upload(ctx, w.Writer.Digest()); err != nil {
+		return errors.Wrapf(err, "push blob meta of blob %s", w.Writer.Digest())
+	}
+	return err
+}
+
+func (s *blobMetaStore) upload(ctx context.Context, blob digest.Digest) error {
+	ra, err := s.Store.ReaderAt(ctx, ocispec.Descriptor{Digest: blob})
+	if err != nil {
+		return errors.Wrap(err, "open blob")
+	}
+	defer ra.Close()
+
+	file, err := os.CreateTemp(s.workDir, "blob-meta-")
+	if err != nil {
+		return errors.Wrap(err, "create blob meta file")
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := utils.UnpackEntry(ra, utils.EntryBlobMeta, file); err != nil {
+		if errors.Is(err, nydusConverter.ErrNotFound) {
+			logrus.Warnf("no blob meta in blob %s, skip pushing it", blob)
+			return nil
+		}
+		return errors.Wrap(err, "unpack blob meta from blob")
+	}
+
+	blobMetaID := blob.Encoded() + utils.BlobMetaSuffix
+	if _, err := backend.Upload(ctx, s.backend, blobMetaID, file.Name(), 0, s.forcePush); err != nil {
+		return err
+	}
+	logrus.Infof("pushed blob meta %s", blobMetaID)
+
+	return nil
+}
+
+// addBlobMetaUploader pushes the blob meta of converted layers alongside
+// their blobs, it only takes effect for the storage backend other than
+// registry, where the blob meta is read from the blob itself.
+func addBlobMetaUploader(pvd *provider.Provider, opt Opt, workDir string) error {
+	if !opt.WithBlobMeta {
+		return nil
+	}
+	if opt.BackendType == "" || opt.BackendType == "registry" {
+		logrus.Warn("blob meta is only pushed to storage backend other than registry")
+		return nil
+	}
+	bkd, err := backend.NewBackend(opt.BackendType, []byte(opt.BackendConfig), nil)
+	if err != nil {
+		return errors.Wrap(err, "create storage backend")
+	}
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return errors.Wrap(err, "create blob meta directory")
+	}
+	pvd.SetContentStore(&blobMetaStore{
+		Store:     pvd.ContentStore(),
+		backend:   bkd,
+		forcePush: opt.BackendForcePush,
+		workDir:   workDir,
+	})
+	return nil
+}
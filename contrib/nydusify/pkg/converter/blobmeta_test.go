@@ -0,0 +1,66 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestBlobMetaUploader(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	backendDir := t.TempDir()
+	opt := Opt{
+		BackendType:   "localfs",
+		BackendConfig: fmt.Sprintf(`{"dir": %q}`, backendDir),
+		WithBlobMeta:  true,
+	}
+	require.NoError(t, addBlobMetaUploader(pvd, opt, t.TempDir()))
+
+	write := func(ref string, data []byte) digest.Digest {
+		desc := ocispec.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+		require.NoError(t, content.WriteBlob(ctx, pvd.ContentStore(), ref, bytes.NewReader(data), desc))
+		return desc.Digest
+	}
+
+	// The blob meta of converted layer is pushed alongside the blob.
+	blob := write(convertedLayerRefPrefix+digest.FromString("source").String(), nydusTar(t, map[string][]byte{
+		nydusifyUtils.EntryBootstrap: []byte("bootstrap"),
+		nydusifyUtils.EntryBlobMeta:  []byte("blob meta"),
+	}))
+	data, err := os.ReadFile(filepath.Join(backendDir, blob.Encoded()+nydusifyUtils.BlobMetaSuffix))
+	require.NoError(t, err)
+	require.Equal(t, "blob meta", string(data))
+
+	// The converted layer without blob meta and other contents are skipped.
+	blob = write(convertedLayerRefPrefix+digest.FromString("other").String(), nydusTar(t, map[string][]byte{
+		nydusifyUtils.EntryBootstrap: []byte("other bootstrap"),
+	}))
+	other := write("other", nydusTar(t, map[string][]byte{
+		nydusifyUtils.EntryBlobMeta: []byte("other blob meta"),
+	}))
+	entries, err := os.ReadDir(backendDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	for _, dgst := range []digest.Digest{blob, other} {
+		_, err = os.Stat(filepath.Join(backendDir, dgst.Encoded()+nydusifyUtils.BlobMetaSuffix))
+		require.True(t, os.IsNotExist(err))
+	}
+}
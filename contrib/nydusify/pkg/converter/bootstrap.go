@@ -0,0 +1,415 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const (
+	// BootstrapPlacementLayer pushes bootstrap as the last layer of image.
+	BootstrapPlacementLayer = "layer"
+	// BootstrapPlacementArtifact pushes bootstrap as a separate artifact
+	// which refers to the image manifest, the bootstrap layer is removed
+	// from image and its digest is recorded in manifest annotation.
+	BootstrapPlacementArtifact = "artifact"
+	// BootstrapPlacementBoth keeps bootstrap layer in image and pushes
+	// the separate artifact at the same time.
+	BootstrapPlacementBoth = "both"
+)
+
+var bootstrapPlacements = []string{
+	BootstrapPlacementLayer,
+	BootstrapPlacementArtifact,
+	BootstrapPlacementBoth,
+}
+
+// ValidateBootstrapPlacement checks the placement specified by user.
+func ValidateBootstrapPlacement(placement string) error {
+	for _, p := range bootstrapPlacements {
+		if p == placement {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid bootstrap placement %s, possible values: %v", placement, bootstrapPlacements)
+}
+
+// bootstrapPlacer rewrites the converted image before it's pushed to
+// target, to place bootstrap in the way expected by nydus snapshotter.
+type bootstrapPlacer struct {
+	pvd       *provider.Provider
+	placement string
+	target    string
+	artifacts []ocispec.Descriptor
+}
+
+func newBootstrapPlacer(pvd *provider.Provider, placement, target string) (*bootstrapPlacer, error) {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	return &bootstrapPlacer{
+		pvd:       pvd,
+		placement: placement,
+		target:    named.String(),
+	}, nil
+}
+
+func (placer *bootstrapPlacer) hook() provider.PushHook {
+	return provider.PushHook{
+		BeforePush: placer.beforePush,
+		AfterPush:  placer.afterPush,
+	}
+}
+
+func (placer *bootstrapPlacer) beforePush(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+	if ref != placer.target {
+		return &desc, nil
+	}
+	// The push may be retried with plain HTTP, always start from the
+	// original image.
+	placer.artifacts = nil
+	return placer.place(ctx, desc)
+}
+
+func (placer *bootstrapPlacer) afterPush(ctx context.Context, _ ocispec.Descriptor, ref string) error {
+	if ref != placer.target || len(placer.artifacts) == 0 {
+		return nil
+	}
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return errors.Wrap(err, "parse target reference")
+	}
+	for _, artifact := range placer.artifacts {
+		// Push artifact by digest, avoid overwriting the target tag.
+		artifactRef := fmt.Sprintf("%s@%s", docker.TrimNamed(named).String(), artifact.Digest)
+		logrus.Infof("pushing bootstrap artifact %s", artifactRef)
+		if err := placer.pvd.Push(ctx, artifact, artifactRef); err != nil {
+			return errors.Wrapf(err, "push bootstrap artifact %s", artifactRef)
+		}
+	}
+	return nil
+}
+
+func (placer *bootstrapPlacer) place(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	cs := placer.pvd.ContentStore()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return nil, errors.Wrap(err, "read index json")
+		}
+		for idx := range index.Manifests {
+			newDesc, err := placer.place(ctx, index.Manifests[idx])
+			if err != nil {
+				return nil, err
+			}
+			index.Manifests[idx] = *newDesc
+		}
+		return placer.pvd.WriteJSON(ctx, index, desc)
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return nil, errors.Wrap(err, "read manifest json")
+		}
+		bootstrapDesc := parser.FindNydusBootstrapDesc(&manifest)
+		if bootstrapDesc == nil {
+			// Skip the OCI manifest in merged index.
+			return &desc, nil
+		}
+		bootstrap := *bootstrapDesc
+
+		if placer.placement == BootstrapPlacementArtifact {
+			if err := placer.removeBootstrapLayer(ctx, &manifest); err != nil {
+				return nil, err
+			}
+		}
+		if manifest.Annotations == nil {
+			manifest.Annotations = map[string]string{}
+		}
+		manifest.Annotations[nydusifyUtils.ManifestNydusBootstrap] = bootstrap.Digest.String()
+
+		newDesc, err := placer.pvd.WriteJSON(ctx, manifest, desc)
+		if err != nil {
+			return nil, errors.Wrap(err, "write manifest json")
+		}
+
+		artifactDesc, err := placer.writeArtifact(ctx, bootstrap, *newDesc)
+		if err != nil {
+			return nil, err
+		}
+		placer.artifacts = append(placer.artifacts, *artifactDesc)
+
+		return newDesc, nil
+
+	default:
+		return &desc, nil
+	}
+}
+
+// removeBootstrapLayer removes the last bootstrap layer from manifest,
+// and the corresponding diff id from image config.
+func (placer *bootstrapPlacer) removeBootstrapLayer(ctx context.Context, manifest *ocispec.Manifest) error {
+	cs := placer.pvd.ContentStore()
+
+	manifest.Layers = manifest.Layers[:len(manifest.Layers)-1]
+
+	var config ocispec.Image
+	if _, err := utils.ReadJSON(ctx, cs, &config, manifest.Config); err != nil {
+		return errors.Wrap(err, "read image config")
+	}
+	if len(config.RootFS.DiffIDs) > 0 {
+		config.RootFS.DiffIDs = config.RootFS.DiffIDs[:len(config.RootFS.DiffIDs)-1]
+	}
+	configDesc, err := placer.pvd.WriteJSON(ctx, config, manifest.Config)
+	if err != nil {
+		return errors.Wrap(err, "write image config")
+	}
+	manifest.Config = *configDesc
+
+	return nil
+}
+
+// writeArtifact writes the bootstrap artifact manifest which refers to
+// the image manifest by `subject` field into content store.
+func (placer *bootstrapPlacer) writeArtifact(ctx context.Context, bootstrap, subject ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	emptyConfig := ocispec.DescriptorEmptyJSON
+	emptyConfig.Data = nil
+	configDesc, err := placer.pvd.WriteBlob(ctx, ocispec.DescriptorEmptyJSON.Data, emptyConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "write empty config")
+	}
+
+	artifact := ocispec.Manifest{
+		Versioned: specs.Versioned{
+			SchemaVersion: 2,
+		},
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: nydusifyUtils.ArtifactTypeNydusBootstrap,
+		Config:       *configDesc,
+		Layers:       []ocispec.Descriptor{bootstrap},
+		Subject: &ocispec.Descriptor{
+			MediaType: subject.MediaType,
+			Digest:    subject.Digest,
+			Size:      subject.Size,
+		},
+	}
+
+	artifactDesc, err := placer.pvd.WriteJSON(ctx, artifact, ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: nydusifyUtils.ArtifactTypeNydusBootstrap,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "write bootstrap artifact")
+	}
+
+	return artifactDesc, nil
+}
+
+const (
+	// BootstrapCompressionGzip pushes bootstrap layer compressed by gzip,
+	// which is produced by builder.
+	BootstrapCompressionGzip = "gzip"
+	// BootstrapCompressionZstd pushes bootstrap layer compressed by zstd,
+	// which requires OCI manifest.
+	BootstrapCompressionZstd = "zstd"
+	// BootstrapCompressionNone pushes bootstrap layer uncompressed.
+	BootstrapCompressionNone = "none"
+)
+
+// ValidateBootstrapCompression checks the compression specified by user,
+// which is one of the codecs registered by RegisterCodec.
+func ValidateBootstrapCompression(compression string) error {
+	if _, err := GetCodec(compression); err != nil {
+		return errors.Wrap(err, "invalid bootstrap compression")
+	}
+	return nil
+}
+
+// bootstrapCompressor recompresses the bootstrap layer of the converted
+// image before it's pushed to target, as bootstrap download time affects
+// container cold start.
+type bootstrapCompressor struct {
+	pvd    *provider.Provider
+	codec  Codec
+	target string
+}
+
+func newBootstrapCompressor(pvd *provider.Provider, compression, target string) (*bootstrapCompressor, error) {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	codec, err := GetCodec(compression)
+	if err != nil {
+		return nil, err
+	}
+	return &bootstrapCompressor{
+		pvd:    pvd,
+		codec:  codec,
+		target: named.String(),
+	}, nil
+}
+
+func (compressor *bootstrapCompressor) hook() provider.PushHook {
+	return provider.PushHook{
+		BeforePush: compressor.beforePush,
+	}
+}
+
+func (compressor *bootstrapCompressor) beforePush(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+	if ref != compressor.target {
+		return &desc, nil
+	}
+	return compressor.compress(ctx, desc)
+}
+
+func (compressor *bootstrapCompressor) compress(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	cs := compressor.pvd.ContentStore()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return nil, errors.Wrap(err, "read index json")
+		}
+		for idx := range index.Manifests {
+			newDesc, err := compressor.compress(ctx, index.Manifests[idx])
+			if err != nil {
+				return nil, err
+			}
+			index.Manifests[idx] = *newDesc
+		}
+		return compressor.pvd.WriteJSON(ctx, index, desc)
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return nil, errors.Wrap(err, "read manifest json")
+		}
+		bootstrapDesc := parser.FindNydusBootstrapDesc(&manifest)
+		if bootstrapDesc == nil {
+			// Skip the OCI manifest in merged index.
+			return &desc, nil
+		}
+		mediaType, err := layerMediaType(desc.MediaType, compressor.codec)
+		if err != nil {
+			return nil, err
+		}
+		if bootstrapDesc.MediaType == mediaType {
+			return &desc, nil
+		}
+
+		newBootstrapDesc, err := compressor.recompress(ctx, *bootstrapDesc, mediaType)
+		if err != nil {
+			return nil, errors.Wrap(err, "recompress bootstrap layer")
+		}
+		// The diff id in image config is unchanged as the uncompressed
+		// bootstrap is the same.
+		*bootstrapDesc = *newBootstrapDesc
+
+		newDesc, err := compressor.pvd.WriteJSON(ctx, manifest, desc)
+		if err != nil {
+			return nil, errors.Wrap(err, "write manifest json")
+		}
+		return newDesc, nil
+
+	default:
+		return &desc, nil
+	}
+}
+
+func (compressor *bootstrapCompressor) recompress(ctx context.Context, desc ocispec.Descriptor, mediaType string) (*ocispec.Descriptor, error) {
+	cs := compressor.pvd.ContentStore()
+
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "open bootstrap layer")
+	}
+	defer ra.Close()
+	reader, err := DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress bootstrap layer")
+	}
+	defer reader.Close()
+
+	// The recompressed bootstrap is streamed into content store, rather
+	// than buffered in memory, as it may be hundreds of MiB for the image
+	// with millions of files.
+	cw, err := content.OpenWriter(ctx, cs, content.WithRef("bootstrap-"+compressor.codec.Name()+"-"+desc.Digest.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "open bootstrap layer writer")
+	}
+	defer cw.Close()
+	if err := cw.Truncate(0); err != nil {
+		return nil, errors.Wrap(err, "truncate bootstrap layer writer")
+	}
+
+	counter := &countWriter{writer: cw}
+	writer, err := compressor.codec.Compress(counter)
+	if err != nil {
+		return nil, errors.Wrap(err, "create compressor")
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Close()
+		return nil, errors.Wrap(err, "compress bootstrap layer")
+	}
+	if err := writer.Close(); err != nil {
+		return nil, errors.Wrap(err, "close compressor")
+	}
+
+	newDesc := ocispec.Descriptor{
+		MediaType:   mediaType,
+		Digest:      cw.Digest(),
+		Size:        counter.size,
+		Annotations: desc.Annotations,
+	}
+	if err := cw.Commit(ctx, newDesc.Size, newDesc.Digest); err != nil && !errdefs.IsAlreadyExists(err) {
+		return nil, errors.Wrap(err, "write bootstrap layer")
+	}
+
+	return &newDesc, nil
+}
+
+type countWriter struct {
+	writer io.Writer
+	size   int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func addBootstrapCompressor(pvd *provider.Provider, opt Opt, target string) error {
+	if opt.BootstrapCompression == "" || opt.BootstrapCompression == BootstrapCompressionGzip {
+		return nil
+	}
+	compressor, err := newBootstrapCompressor(pvd, opt.BootstrapCompression, target)
+	if err != nil {
+		return err
+	}
+	pvd.AddPushHook(compressor.hook())
+	return nil
+}
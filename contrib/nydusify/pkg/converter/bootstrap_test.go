@@ -0,0 +1,196 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestValidateBootstrapPlacement(t *testing.T) {
+	require.NoError(t, ValidateBootstrapPlacement(BootstrapPlacementLayer))
+	require.NoError(t, ValidateBootstrapPlacement(BootstrapPlacementArtifact))
+	require.NoError(t, ValidateBootstrapPlacement(BootstrapPlacementBoth))
+	require.Error(t, ValidateBootstrapPlacement("unknown"))
+}
+
+func TestBootstrapPlacer(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	blobDigest := digest.FromString("blob")
+	bootstrapDigest := digest.FromString("bootstrap")
+	configDesc, err := utils.WriteJSON(ctx, cs, ocispec.Image{
+		RootFS: ocispec.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{blobDigest, bootstrapDigest},
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig}, "", nil)
+	require.NoError(t, err)
+	manifestDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    *configDesc,
+		Layers: []ocispec.Descriptor{
+			{
+				MediaType: nydusifyUtils.MediaTypeNydusBlob,
+				Digest:    blobDigest,
+			},
+			{
+				MediaType: ocispec.MediaTypeImageLayerGzip,
+				Digest:    bootstrapDigest,
+				Annotations: map[string]string{
+					nydusifyUtils.LayerAnnotationNydusBootstrap: "true",
+				},
+			},
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+
+	placer, err := newBootstrapPlacer(pvd, BootstrapPlacementArtifact, "nydus/test:latest")
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/nydus/test:latest", placer.target)
+
+	// Other references are untouched.
+	newDesc, err := placer.beforePush(ctx, *manifestDesc, "docker.io/nydus/cache:latest")
+	require.NoError(t, err)
+	require.Equal(t, *manifestDesc, *newDesc)
+
+	newDesc, err = placer.beforePush(ctx, *manifestDesc, placer.target)
+	require.NoError(t, err)
+	require.NotEqual(t, manifestDesc.Digest, newDesc.Digest)
+
+	var manifest ocispec.Manifest
+	_, err = utils.ReadJSON(ctx, cs, &manifest, *newDesc)
+	require.NoError(t, err)
+	require.Len(t, manifest.Layers, 1)
+	require.Equal(t, bootstrapDigest.String(), manifest.Annotations[nydusifyUtils.ManifestNydusBootstrap])
+
+	var config ocispec.Image
+	_, err = utils.ReadJSON(ctx, cs, &config, manifest.Config)
+	require.NoError(t, err)
+	require.Equal(t, []digest.Digest{blobDigest}, config.RootFS.DiffIDs)
+
+	require.Len(t, placer.artifacts, 1)
+	var artifact ocispec.Manifest
+	_, err = utils.ReadJSON(ctx, cs, &artifact, placer.artifacts[0])
+	require.NoError(t, err)
+	require.Equal(t, nydusifyUtils.ArtifactTypeNydusBootstrap, artifact.ArtifactType)
+	require.Equal(t, bootstrapDigest, artifact.Layers[0].Digest)
+	require.Equal(t, newDesc.Digest, artifact.Subject.Digest)
+
+	// Keep bootstrap layer in both mode.
+	placer.placement = BootstrapPlacementBoth
+	newDesc, err = placer.beforePush(ctx, *manifestDesc, placer.target)
+	require.NoError(t, err)
+	_, err = utils.ReadJSON(ctx, cs, &manifest, *newDesc)
+	require.NoError(t, err)
+	require.Len(t, manifest.Layers, 2)
+	require.Len(t, placer.artifacts, 1)
+}
+
+func TestValidateBootstrapCompression(t *testing.T) {
+	require.NoError(t, ValidateBootstrapCompression(BootstrapCompressionGzip))
+	require.NoError(t, ValidateBootstrapCompression(BootstrapCompressionZstd))
+	require.NoError(t, ValidateBootstrapCompression(BootstrapCompressionNone))
+	require.Error(t, ValidateBootstrapCompression("lz4"))
+}
+
+func TestBootstrapCompressor(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	var buf bytes.Buffer
+	writer, err := compression.CompressStream(&buf, compression.Gzip)
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("bootstrap"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	bootstrapDesc := ocispec.Descriptor{
+		MediaType: images.MediaTypeDockerSchema2LayerGzip,
+		Digest:    digest.FromBytes(buf.Bytes()),
+		Size:      int64(buf.Len()),
+		Annotations: map[string]string{
+			nydusifyUtils.LayerAnnotationNydusBootstrap: "true",
+		},
+	}
+	require.NoError(t, content.WriteBlob(ctx, cs, bootstrapDesc.Digest.String(), bytes.NewReader(buf.Bytes()), bootstrapDesc))
+
+	writeManifest := func(mediaType string) *ocispec.Descriptor {
+		desc := bootstrapDesc
+		if mediaType == ocispec.MediaTypeImageManifest {
+			desc.MediaType = ocispec.MediaTypeImageLayerGzip
+		}
+		manifestDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: mediaType,
+			Layers:    []ocispec.Descriptor{desc},
+		}, ocispec.Descriptor{MediaType: mediaType}, "", nil)
+		require.NoError(t, err)
+		return manifestDesc
+	}
+	readBootstrap := func(desc ocispec.Descriptor) (ocispec.Descriptor, string) {
+		var manifest ocispec.Manifest
+		_, err := utils.ReadJSON(ctx, cs, &manifest, desc)
+		require.NoError(t, err)
+		layer := manifest.Layers[0]
+		ra, err := cs.ReaderAt(ctx, layer)
+		require.NoError(t, err)
+		defer ra.Close()
+		reader, err := compression.DecompressStream(content.NewReader(ra))
+		require.NoError(t, err)
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		return layer, string(data)
+	}
+
+	for _, tc := range []struct {
+		compression       string
+		manifestMediaType string
+		layerMediaType    string
+	}{
+		{BootstrapCompressionZstd, ocispec.MediaTypeImageManifest, ocispec.MediaTypeImageLayerZstd},
+		{BootstrapCompressionNone, ocispec.MediaTypeImageManifest, ocispec.MediaTypeImageLayer},
+		{BootstrapCompressionNone, images.MediaTypeDockerSchema2Manifest, images.MediaTypeDockerSchema2Layer},
+	} {
+		compressor, err := newBootstrapCompressor(pvd, tc.compression, "nydus/test:latest")
+		require.NoError(t, err)
+		manifestDesc := writeManifest(tc.manifestMediaType)
+		newDesc, err := compressor.beforePush(ctx, *manifestDesc, compressor.target)
+		require.NoError(t, err)
+		require.NotEqual(t, manifestDesc.Digest, newDesc.Digest)
+
+		layer, data := readBootstrap(*newDesc)
+		require.Equal(t, tc.layerMediaType, layer.MediaType)
+		require.Equal(t, "true", layer.Annotations[nydusifyUtils.LayerAnnotationNydusBootstrap])
+		require.Equal(t, "bootstrap", data)
+	}
+
+	// Zstd layer is not defined for docker manifest.
+	compressor, err := newBootstrapCompressor(pvd, BootstrapCompressionZstd, "nydus/test:latest")
+	require.NoError(t, err)
+	_, err = compressor.beforePush(ctx, *writeManifest(images.MediaTypeDockerSchema2Manifest), compressor.target)
+	require.Error(t, err)
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// buildLimitedStore bounds the layers built concurrently. The layers of a
+// manifest are converted in parallel, each streams the source layer from
+// content store into its own `nydus-image create` through a fifo, and writes
+// the built blob into the content writer of converted layer, which is held
+// until the blob is committed. So a slot is taken when the writer is opened
+// and released when it's committed or closed, rather than by the readers of
+// source layer, which are also opened by validation and prefetch inference
+// while the layer is being built and would deadlock on the slot they hold.
+// The bootstrap merge is serialized after all layers are built.
+type buildLimitedStore struct {
+	content.Store
+	sem *semaphore.Weighted
+}
+
+type limitedWriter struct {
+	content.Writer
+	once    sync.Once
+	release func()
+}
+
+func (w *limitedWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	defer w.once.Do(w.release)
+	return w.Writer.Commit(ctx, size, expected, opts...)
+}
+
+func (w *limitedWriter) Close() error {
+	w.once.Do(w.release)
+	return w.Writer.Close()
+}
+
+// isSourceLayer excludes the Nydus blobs and bootstrap, which are read by
+// merge and push rather than built.
+func isSourceLayer(desc ocispec.Descriptor) bool {
+	return images.IsLayerType(desc.MediaType) &&
+		desc.MediaType != nydusifyUtils.MediaTypeNydusBlob &&
+		desc.Annotations[nydusifyUtils.LayerAnnotationNydusBlob] == "" &&
+		desc.Annotations[nydusifyUtils.LayerAnnotationNydusBootstrap] == ""
+}
+
+func (s *buildLimitedStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.HasPrefix(wOpts.Ref, convertedLayerRefPrefix) {
+		return s.Store.Writer(ctx, opts...)
+	}
+	if err := s.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	writer, err := s.Store.Writer(ctx, opts...)
+	if err != nil {
+		s.sem.Release(1)
+		return nil, err
+	}
+	return &limitedWriter{
+		Writer:  writer,
+		release: func() { s.sem.Release(1) },
+	}, nil
+}
+
+// addBuildLimiter limits the concurrent layer builds to max, 0 means no
+// limit.
+func addBuildLimiter(pvd *provider.Provider, max int) {
+	if max <= 0 {
+		return
+	}
+	pvd.SetContentStore(&buildLimitedStore{
+		Store: pvd.ContentStore(),
+		sem:   semaphore.NewWeighted(int64(max)),
+	})
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestBuildLimitedStore(t *testing.T) {
+	ctx := context.Background()
+	base, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+	store := &buildLimitedStore{Store: base, sem: semaphore.NewWeighted(1)}
+
+	layer := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("layer"), Size: 5}
+	require.NoError(t, content.WriteBlob(ctx, base, "layer", strings.NewReader("layer"), layer))
+	ref := func(source string) content.WriterOpt {
+		return content.WithRef(convertedLayerRefPrefix + digest.FromString(source).String())
+	}
+
+	w1, err := store.Writer(ctx, ref("layer-1"))
+	require.NoError(t, err)
+
+	// The source layers and other contents aren't limited, so that they're
+	// read while the layer is being built.
+	for idx := 0; idx < 2; idx++ {
+		ra, err := store.ReaderAt(ctx, layer)
+		require.NoError(t, err)
+		defer ra.Close()
+	}
+	w, err := store.Writer(ctx, content.WithRef("other"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	// The second layer waits until the first one is committed.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = store.Writer(timeoutCtx, ref("layer-2"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, err = w1.Write([]byte("blob-1"))
+	require.NoError(t, err)
+	require.NoError(t, w1.Commit(ctx, 6, digest.FromString("blob-1")))
+	// Closing after commit doesn't release the slot twice.
+	require.NoError(t, w1.Close())
+	w2, err := store.Writer(ctx, ref("layer-2"))
+	require.NoError(t, err)
+	require.False(t, store.sem.TryAcquire(1))
+	require.NoError(t, w2.Close())
+	require.True(t, store.sem.TryAcquire(1))
+}
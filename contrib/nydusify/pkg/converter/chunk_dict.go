@@ -34,6 +34,7 @@ func isValidChunkDictSource(source string) bool {
 
 // ParseChunkDictArgs parses chunk dict args like:
 // - bootstrap:registry:$repo:$tag
+// - bootstrap:registry://$repo:$tag
 // - bootstrap:local:$path
 func ParseChunkDictArgs(args string) (format string, source string, ref string, err error) {
 	names := strings.Split(args, ":")
@@ -51,7 +52,7 @@ func ParseChunkDictArgs(args string) (format string, source string, ref string,
 		err = fmt.Errorf("invalid chunk dict source %s, should be %v", source, chunkDictSources)
 		return
 	}
-	ref = strings.Join(names[2:], ":")
+	ref = strings.TrimPrefix(strings.Join(names[2:], ":"), "//")
 	return
 }
 
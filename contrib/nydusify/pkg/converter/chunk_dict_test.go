@@ -0,0 +1,28 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChunkDictArgs(t *testing.T) {
+	for args, expected := range map[string][3]string{
+		"bootstrap:registry:localhost:5000/dict:latest":   {"bootstrap", "registry", "localhost:5000/dict:latest"},
+		"bootstrap:registry://localhost:5000/dict:latest": {"bootstrap", "registry", "localhost:5000/dict:latest"},
+		"bootstrap:local:/path/to/dict.boot":              {"bootstrap", "local", "/path/to/dict.boot"},
+	} {
+		format, source, ref, err := ParseChunkDictArgs(args)
+		require.NoError(t, err)
+		require.Equal(t, expected, [3]string{format, source, ref})
+	}
+
+	_, _, _, err := ParseChunkDictArgs("blob:registry:dict")
+	require.Error(t, err)
+	_, _, _, err = ParseChunkDictArgs("bootstrap:http:dict")
+	require.Error(t, err)
+}
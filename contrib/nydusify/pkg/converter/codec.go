@@ -0,0 +1,149 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// codecHeaderSize is the size of stream header peeked to detect the codec.
+const codecHeaderSize = 32
+
+// Codec compresses and decompresses the source layers and the pushed
+// bootstrap layer, the built-in ones are `gzip`, `zstd` and `none`.
+type Codec interface {
+	// Name is the name of codec in options, e.g. `--bootstrap-compression`.
+	Name() string
+	// MediaTypeSuffix is appended to the OCI layer media type, e.g. `+zstd`,
+	// or empty for uncompressed.
+	MediaTypeSuffix() string
+	// Detect returns whether the stream starting with header is compressed
+	// by the codec, the header may be shorter than codecHeaderSize for the
+	// tiny stream.
+	Detect(header []byte) bool
+	Decompress(reader io.Reader) (io.ReadCloser, error)
+	Compress(writer io.Writer) (io.WriteCloser, error)
+}
+
+// containerdCodec is the codec built in containerd, which decompresses gzip
+// by unpigz if available.
+type containerdCodec struct {
+	name      string
+	algorithm compression.Compression
+}
+
+func (codec *containerdCodec) Name() string {
+	return codec.name
+}
+
+func (codec *containerdCodec) MediaTypeSuffix() string {
+	if codec.algorithm == compression.Uncompressed {
+		return ""
+	}
+	return "+" + codec.name
+}
+
+func (codec *containerdCodec) Detect(header []byte) bool {
+	// The uncompressed stream is the fallback of detection.
+	return codec.algorithm != compression.Uncompressed && compression.DetectCompression(header) == codec.algorithm
+}
+
+func (codec *containerdCodec) Decompress(reader io.Reader) (io.ReadCloser, error) {
+	if codec.algorithm == compression.Uncompressed {
+		return io.NopCloser(reader), nil
+	}
+	return compression.DecompressStream(reader)
+}
+
+func (codec *containerdCodec) Compress(writer io.Writer) (io.WriteCloser, error) {
+	return compression.CompressStream(writer, codec.algorithm)
+}
+
+var (
+	codecs = map[string]Codec{}
+	// codecOrder is the order of detection, in order of registration.
+	codecOrder []Codec
+)
+
+func init() {
+	RegisterCodec(&containerdCodec{name: BootstrapCompressionGzip, algorithm: compression.Gzip})
+	RegisterCodec(&containerdCodec{name: BootstrapCompressionZstd, algorithm: compression.Zstd})
+	RegisterCodec(&containerdCodec{name: BootstrapCompressionNone, algorithm: compression.Uncompressed})
+}
+
+// RegisterCodec registers the codec, so that the programs using nydusify as
+// a package can support new compression formats of source layers and
+// bootstrap layer. The codec of the same name is replaced. It should be
+// called before conversion.
+func RegisterCodec(codec Codec) {
+	if old, ok := codecs[codec.Name()]; ok {
+		for idx := range codecOrder {
+			if codecOrder[idx] == old {
+				codecOrder = append(codecOrder[:idx], codecOrder[idx+1:]...)
+				break
+			}
+		}
+	}
+	codecs[codec.Name()] = codec
+	codecOrder = append(codecOrder, codec)
+}
+
+// GetCodec returns the registered codec of name.
+func GetCodec(name string) (Codec, error) {
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %s, possible values: %v", name, codecNames())
+	}
+	return codec, nil
+}
+
+func codecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DecompressStream decompresses the stream by the codec detected from its
+// header, the stream of unknown format is read as uncompressed.
+func DecompressStream(reader io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReaderSize(reader, codecHeaderSize)
+	header, err := buffered.Peek(codecHeaderSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	for _, codec := range codecOrder {
+		if codec.Detect(header) {
+			return codec.Decompress(buffered)
+		}
+	}
+	return io.NopCloser(buffered), nil
+}
+
+// layerMediaType returns the media type of layer compressed by codec in
+// manifest of media type, docker manifest supports only gzip and
+// uncompressed layers.
+func layerMediaType(manifestMediaType string, codec Codec) (string, error) {
+	if manifestMediaType == images.MediaTypeDockerSchema2Manifest {
+		switch codec.MediaTypeSuffix() {
+		case "":
+			return images.MediaTypeDockerSchema2Layer, nil
+		case "+gzip":
+			return images.MediaTypeDockerSchema2LayerGzip, nil
+		default:
+			return "", fmt.Errorf("%s compressed bootstrap requires OCI manifest, try the option --oci", codec.Name())
+		}
+	}
+	return ocispec.MediaTypeImageLayer + codec.MediaTypeSuffix(), nil
+}
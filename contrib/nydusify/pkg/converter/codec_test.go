@@ -0,0 +1,128 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// prefixCodec is a fake codec writing the stream after its magic header.
+type prefixCodec struct{}
+
+var prefixMagic = []byte("PFX1")
+
+func (prefixCodec) Name() string            { return "prefix" }
+func (prefixCodec) MediaTypeSuffix() string { return "+prefix" }
+func (prefixCodec) Detect(header []byte) bool {
+	return bytes.HasPrefix(header, prefixMagic)
+}
+
+func (prefixCodec) Decompress(reader io.Reader) (io.ReadCloser, error) {
+	if _, err := io.ReadFull(reader, make([]byte, len(prefixMagic))); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(reader), nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func (prefixCodec) Compress(writer io.Writer) (io.WriteCloser, error) {
+	if _, err := writer.Write(prefixMagic); err != nil {
+		return nil, err
+	}
+	return nopWriteCloser{writer}, nil
+}
+
+// registerTestCodec registers the codec, and restores the registry after
+// the test.
+func registerTestCodec(t *testing.T, codec Codec) {
+	oldCodecs := map[string]Codec{}
+	for name, codec := range codecs {
+		oldCodecs[name] = codec
+	}
+	oldOrder := append([]Codec{}, codecOrder...)
+	RegisterCodec(codec)
+	t.Cleanup(func() {
+		codecs, codecOrder = oldCodecs, oldOrder
+	})
+}
+
+func compressWith(t *testing.T, codec Codec, data string) []byte {
+	var buf bytes.Buffer
+	writer, err := codec.Compress(&buf)
+	require.NoError(t, err)
+	_, err = writer.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestCodec(t *testing.T) {
+	decompress := func(data []byte) string {
+		reader, err := DecompressStream(bytes.NewReader(data))
+		require.NoError(t, err)
+		defer reader.Close()
+		decompressed, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		return string(decompressed)
+	}
+
+	for _, name := range []string{BootstrapCompressionGzip, BootstrapCompressionZstd, BootstrapCompressionNone} {
+		codec, err := GetCodec(name)
+		require.NoError(t, err)
+		require.Equal(t, "layer data", decompress(compressWith(t, codec, "layer data")))
+	}
+	require.Equal(t, "", decompress(nil))
+	require.Equal(t, "ab", decompress([]byte("ab")))
+
+	// The unknown format is read as uncompressed until registered.
+	_, err := GetCodec("prefix")
+	require.ErrorContains(t, err, "unknown compression prefix, possible values: [gzip none zstd]")
+	require.Error(t, ValidateBootstrapCompression("prefix"))
+	data := compressWith(t, prefixCodec{}, "layer data")
+	require.Equal(t, "PFX1layer data", decompress(data))
+
+	registerTestCodec(t, prefixCodec{})
+	require.NoError(t, ValidateBootstrapCompression("prefix"))
+	require.Equal(t, "layer data", decompress(data))
+
+	gzip, err := GetCodec(BootstrapCompressionGzip)
+	require.NoError(t, err)
+	zstd, err := GetCodec(BootstrapCompressionZstd)
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		manifestMediaType string
+		codec             Codec
+		layerMediaType    string
+	}{
+		{ocispec.MediaTypeImageManifest, gzip, ocispec.MediaTypeImageLayerGzip},
+		{ocispec.MediaTypeImageManifest, zstd, ocispec.MediaTypeImageLayerZstd},
+		{ocispec.MediaTypeImageManifest, prefixCodec{}, ocispec.MediaTypeImageLayer + "+prefix"},
+		{images.MediaTypeDockerSchema2Manifest, gzip, images.MediaTypeDockerSchema2LayerGzip},
+	} {
+		mediaType, err := layerMediaType(tc.manifestMediaType, tc.codec)
+		require.NoError(t, err)
+		require.Equal(t, tc.layerMediaType, mediaType)
+	}
+	_, err = layerMediaType(images.MediaTypeDockerSchema2Manifest, prefixCodec{})
+	require.ErrorContains(t, err, "prefix compressed bootstrap requires OCI manifest")
+
+	// The built-in codec is replaceable, e.g. by the one of another library.
+	codec := &containerdCodec{name: BootstrapCompressionGzip, algorithm: compression.Gzip}
+	registerTestCodec(t, codec)
+	replaced, err := GetCodec(BootstrapCompressionGzip)
+	require.NoError(t, err)
+	require.True(t, replaced == Codec(codec))
+	require.Len(t, codecOrder, 4)
+}
@@ -0,0 +1,453 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// checkCompat checks the options which the compatible image can't be built
+// with, as its layers are built from the source layers as is and pushed
+// along with target image.
+func checkCompat(opt Opt) error {
+	if opt.CompatFsVersion == "" {
+		return nil
+	}
+	if opt.BackendType != "" && opt.BackendType != "registry" {
+		return fmt.Errorf("compatible image can't be built with %s backend", opt.BackendType)
+	}
+	if opt.OCIRef {
+		return errors.New("compatible image can't be built with OCI reference, which requires fs version 6")
+	}
+	if opt.UnpackFilter != nil {
+		return errors.New("compatible image can't be built with unpack filter")
+	}
+	return nil
+}
+
+// isCompatManifest returns whether the manifest in index of target image is
+// the compatible one built with another RAFS version.
+func isCompatManifest(desc ocispec.Descriptor) bool {
+	return desc.Annotations[nydusifyUtils.ManifestNydusCompatImage] != ""
+}
+
+// compatLayer is the layer of compatible image being built, done is closed
+// once it's built.
+type compatLayer struct {
+	done   chan struct{}
+	result *LayerResult
+	err    error
+}
+
+// compatBuilder builds the compatible image with another RAFS version along
+// with target image. The source layers read by the conversion of target
+// image are streamed into another builder at the same time, so that the
+// source layers are unpacked once for both versions. The manifests of
+// compatible image are merged from the layers before target image is pushed,
+// and appended to the index of target image.
+type compatBuilder struct {
+	ctx    context.Context
+	pvd    *provider.Provider
+	store  content.Store
+	opt    LayerOpt
+	target string
+
+	mutex  sync.Mutex
+	layers map[digest.Digest]*compatLayer
+	// merged maps the target manifests to the compatible ones, as the push
+	// may be retried with plain HTTP.
+	merged map[digest.Digest]ocispec.Descriptor
+}
+
+// compatStore streams the source layers read from it into compatBuilder.
+type compatStore struct {
+	content.Store
+	builder *compatBuilder
+}
+
+// compatReaderAt writes the data of source layer read from it into the
+// builder in order, the gaps skipped by reader and the tail left unread are
+// filled from the layer itself.
+type compatReaderAt struct {
+	content.ReaderAt
+	mutex  sync.Mutex
+	writer *io.PipeWriter
+	offset int64
+}
+
+func newCompatBuilder(ctx context.Context, pvd *provider.Provider, opt Opt, workDir string) (*compatBuilder, error) {
+	named, err := docker.ParseDockerRef(opt.Target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	return &compatBuilder{
+		ctx:   ctx,
+		pvd:   pvd,
+		store: pvd.ContentStore(),
+		opt: LayerOpt{
+			WorkDir:          workDir,
+			NydusImagePath:   opt.NydusImagePath,
+			FsVersion:        opt.CompatFsVersion,
+			FsAlignChunk:     opt.FsAlignChunk,
+			Compressor:       opt.Compressor,
+			ChunkSize:        opt.ChunkSize,
+			BatchSize:        opt.BatchSize,
+			PrefetchPatterns: opt.PrefetchPatterns,
+		},
+		target: named.String(),
+		layers: map[digest.Digest]*compatLayer{},
+		merged: map[digest.Digest]ocispec.Descriptor{},
+	}, nil
+}
+
+func (s *compatStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	ra, err := s.Store.ReaderAt(ctx, desc)
+	if err != nil || !isSourceLayer(desc) {
+		return ra, err
+	}
+	writer := s.builder.start(desc)
+	if writer == nil {
+		return ra, nil
+	}
+	return &compatReaderAt{ReaderAt: ra, writer: writer}, nil
+}
+
+func (ra *compatReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := ra.ReaderAt.ReadAt(p, off)
+	ra.feed(p[:n], off)
+	return n, err
+}
+
+func (ra *compatReaderAt) feed(p []byte, off int64) {
+	ra.mutex.Lock()
+	defer ra.mutex.Unlock()
+	if ra.writer == nil || off+int64(len(p)) <= ra.offset {
+		return
+	}
+	if off > ra.offset {
+		if err := ra.fill(off); err != nil {
+			return
+		}
+	}
+	n, err := ra.writer.Write(p[ra.offset-off:])
+	ra.offset += int64(n)
+	if err != nil {
+		// The builder has exited, its error is reported by itself.
+		ra.writer = nil
+	}
+}
+
+// fill writes the data of layer until end into builder.
+func (ra *compatReaderAt) fill(end int64) error {
+	n, err := io.Copy(ra.writer, io.NewSectionReader(ra.ReaderAt, ra.offset, end-ra.offset))
+	ra.offset += n
+	if err != nil {
+		ra.writer.CloseWithError(err)
+		ra.writer = nil
+	}
+	return err
+}
+
+func (ra *compatReaderAt) Close() error {
+	ra.mutex.Lock()
+	if ra.writer != nil {
+		if err := ra.fill(ra.Size()); err == nil {
+			ra.writer.Close()
+			ra.writer = nil
+		}
+	}
+	ra.mutex.Unlock()
+	return ra.ReaderAt.Close()
+}
+
+// start starts building the source layer, the data of layer is written into
+// the returned writer. Nil is returned if the layer is being built or built.
+func (builder *compatBuilder) start(desc ocispec.Descriptor) *io.PipeWriter {
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	if layer, ok := builder.layers[desc.Digest]; ok {
+		select {
+		case <-layer.done:
+			if layer.err == nil {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+
+	layer := &compatLayer{done: make(chan struct{})}
+	builder.layers[desc.Digest] = layer
+	reader, writer := io.Pipe()
+	go func() {
+		layer.result, layer.err = ConvertLayer(builder.ctx, desc, reader, builder.opt)
+		// Unblock the source reader if the build fails.
+		reader.CloseWithError(errors.New("compatible layer build exited"))
+		close(layer.done)
+	}()
+	return writer
+}
+
+// layer returns the built layer of source, it's built from the source layer
+// in content store if it isn't read by the conversion of target image, e.g.
+// the layer reused from build cache.
+func (builder *compatBuilder) layer(ctx context.Context, desc ocispec.Descriptor) (*LayerResult, error) {
+	builder.mutex.Lock()
+	layer := builder.layers[desc.Digest]
+	builder.mutex.Unlock()
+	if layer != nil {
+		select {
+		case <-layer.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if layer.err == nil {
+			return layer.result, nil
+		}
+		logrus.WithError(layer.err).Warnf("failed to build compatible layer of %s while it's read, build it again", desc.Digest)
+	}
+
+	ra, err := builder.store.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get reader of source layer %s", desc.Digest)
+	}
+	defer ra.Close()
+	result, err := ConvertLayer(ctx, desc, content.NewReader(ra), builder.opt)
+	if err != nil {
+		return nil, err
+	}
+
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	done := make(chan struct{})
+	close(done)
+	builder.layers[desc.Digest] = &compatLayer{done: done, result: result}
+	return result, nil
+}
+
+func (builder *compatBuilder) hook() provider.PushHook {
+	return provider.PushHook{
+		BeforePush: builder.beforePush,
+	}
+}
+
+func (builder *compatBuilder) beforePush(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+	if ref != builder.target {
+		return &desc, nil
+	}
+	return builder.append(ctx, desc)
+}
+
+// append appends the compatible manifests after the Nydus manifests of
+// target image, the manifest of single platform is wrapped into index.
+func (builder *compatBuilder) append(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	cs := builder.pvd.ContentStore()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return nil, errors.Wrap(err, "read index json")
+		}
+		manifests := index.Manifests
+		for _, manifestDesc := range manifests {
+			if isCompatManifest(manifestDesc) {
+				continue
+			}
+			compatDesc, err := builder.build(ctx, manifestDesc, manifestDesc.Platform)
+			if err != nil {
+				return nil, err
+			}
+			if compatDesc != nil {
+				index.Manifests = append(index.Manifests, *compatDesc)
+			}
+		}
+		return builder.pvd.WriteJSON(ctx, index, desc)
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return nil, errors.Wrap(err, "read manifest json")
+		}
+		var config ocispec.Image
+		if _, err := utils.ReadJSON(ctx, cs, &config, manifest.Config); err != nil {
+			return nil, errors.Wrap(err, "read image config")
+		}
+		// Mark the platform like the Nydus manifest in merged index, which
+		// is identified by parser.
+		platform := config.Platform
+		platform.OSFeatures = []string{nydusifyUtils.ManifestOSFeatureNydus}
+		compatDesc, err := builder.build(ctx, desc, &platform)
+		if err != nil || compatDesc == nil {
+			return &desc, err
+		}
+		manifestDesc := desc
+		manifestDesc.Platform = &platform
+
+		indexDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}
+		if desc.MediaType == images.MediaTypeDockerSchema2Manifest {
+			indexDesc.MediaType = images.MediaTypeDockerSchema2ManifestList
+		}
+		return builder.pvd.WriteJSON(ctx, ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: indexDesc.MediaType,
+			Manifests: []ocispec.Descriptor{manifestDesc, *compatDesc},
+		}, indexDesc)
+
+	default:
+		return &desc, nil
+	}
+}
+
+// build builds the compatible manifest of the Nydus manifest of target
+// image, from the layers of source manifest it's converted from. Its config
+// and annotations are copied from the Nydus manifest except the layers and
+// fs version. Nil is returned for the OCI manifest in merged index.
+func (builder *compatBuilder) build(ctx context.Context, desc ocispec.Descriptor, platform *ocispec.Platform) (*ocispec.Descriptor, error) {
+	builder.mutex.Lock()
+	merged, ok := builder.merged[desc.Digest]
+	builder.mutex.Unlock()
+	if ok {
+		return &merged, nil
+	}
+
+	cs := builder.pvd.ContentStore()
+	var manifest ocispec.Manifest
+	if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+		return nil, errors.Wrap(err, "read manifest json")
+	}
+	sourceDigest := digest.Digest(manifest.Annotations[annotationSourceDigest])
+	if sourceDigest == "" {
+		return nil, nil
+	}
+	var source ocispec.Manifest
+	if _, err := utils.ReadJSON(ctx, cs, &source, ocispec.Descriptor{Digest: sourceDigest}); err != nil {
+		return nil, errors.Wrapf(err, "read source manifest %s", sourceDigest)
+	}
+
+	blobs := []ocispec.Descriptor{}
+	for _, sourceLayer := range source.Layers {
+		result, err := builder.layer(ctx, sourceLayer)
+		if err != nil {
+			return nil, errors.Wrapf(err, "build compatible layer of %s", sourceLayer.Digest)
+		}
+		if err := writeFile(ctx, cs, result.BlobPath, result.Blob); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, result.Blob)
+	}
+	result, err := MergeBootstraps(ctx, blobs, MergeOpt{
+		WorkDir:          filepath.Join(builder.opt.WorkDir, desc.Digest.Encoded()),
+		NydusImagePath:   builder.opt.NydusImagePath,
+		FsVersion:        builder.opt.FsVersion,
+		PrefetchPatterns: builder.opt.PrefetchPatterns,
+		Provider:         cs,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "merge compatible bootstrap")
+	}
+	if err := writeFile(ctx, cs, result.BootstrapPath, result.Bootstrap); err != nil {
+		return nil, err
+	}
+
+	layers := []ocispec.Descriptor{}
+	rootfs := ocispec.RootFS{Type: "layers"}
+	for _, layer := range result.Layers {
+		rootfs.DiffIDs = append(rootfs.DiffIDs, digest.Digest(layer.Annotations[nydusifyUtils.LayerAnnotationUncompressed]))
+		annotations := map[string]string{}
+		for key, value := range layer.Annotations {
+			if key != nydusifyUtils.LayerAnnotationUncompressed {
+				annotations[key] = value
+			}
+		}
+		layer.Annotations = annotations
+		if layer.MediaType == ocispec.MediaTypeImageLayerGzip && manifest.MediaType == images.MediaTypeDockerSchema2Manifest {
+			layer.MediaType = images.MediaTypeDockerSchema2LayerGzip
+		}
+		layers = append(layers, layer)
+	}
+
+	// Keep the unknown fields of config preserved from source image.
+	var config map[string]json.RawMessage
+	if _, err := utils.ReadJSON(ctx, cs, &config, manifest.Config); err != nil {
+		return nil, errors.Wrap(err, "read image config")
+	}
+	if config["rootfs"], err = json.Marshal(rootfs); err != nil {
+		return nil, errors.Wrap(err, "marshal rootfs")
+	}
+	configDesc, err := builder.pvd.WriteJSON(ctx, config, manifest.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "write image config")
+	}
+
+	manifest.Config = *configDesc
+	manifest.Layers = layers
+	annotations := map[string]string{}
+	for key, value := range manifest.Annotations {
+		if key != nydusifyUtils.ManifestNydusBootstrap {
+			annotations[key] = value
+		}
+	}
+	annotations[nydusifyUtils.LayerAnnotationNydusFsVersion] = builder.opt.FsVersion
+	manifest.Annotations = annotations
+	compatDesc, err := builder.pvd.WriteJSON(ctx, manifest, ocispec.Descriptor{MediaType: desc.MediaType})
+	if err != nil {
+		return nil, errors.Wrap(err, "write manifest json")
+	}
+	compatDesc.Platform = platform
+	compatDesc.Annotations = map[string]string{
+		nydusifyUtils.ManifestNydusCompatImage: builder.opt.FsVersion,
+	}
+	logrus.Infof("built compatible manifest %s with fs version %s for %s", compatDesc.Digest, builder.opt.FsVersion, desc.Digest)
+
+	builder.mutex.Lock()
+	builder.merged[desc.Digest] = *compatDesc
+	builder.mutex.Unlock()
+	return compatDesc, nil
+}
+
+// writeFile writes the result file of builder into content store.
+func writeFile(ctx context.Context, cs content.Store, path string, desc ocispec.Descriptor) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "open built file")
+	}
+	defer file.Close()
+	if err := content.WriteBlob(ctx, cs, "compat-"+desc.Digest.String(), file, desc); err != nil {
+		return errors.Wrapf(err, "write %s into content store", desc.Digest)
+	}
+	return nil
+}
+
+// addCompatBuilder builds the compatible image along with target image, the
+// source layers are streamed into it until it's pushed.
+func addCompatBuilder(ctx context.Context, pvd *provider.Provider, opt Opt, workDir string) error {
+	builder, err := newCompatBuilder(ctx, pvd, opt, workDir)
+	if err != nil {
+		return err
+	}
+	pvd.SetContentStore(&compatStore{Store: pvd.ContentStore(), builder: builder})
+	pvd.AddPushHook(builder.hook())
+	return nil
+}
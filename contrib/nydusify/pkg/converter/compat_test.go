@@ -0,0 +1,169 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+type bytesReaderAt struct {
+	*bytes.Reader
+}
+
+func (ra *bytesReaderAt) Close() error {
+	return nil
+}
+
+func TestCompatReaderAt(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	reader, writer := io.Pipe()
+	received := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(reader)
+		received <- data
+	}()
+
+	ra := &compatReaderAt{ReaderAt: &bytesReaderAt{bytes.NewReader(data)}, writer: writer}
+	// The overlapped, skipped and unread data are written in order.
+	for _, read := range []struct{ off, size int64 }{{0, 4}, {2, 6}, {12, 4}, {0, 2}} {
+		p := make([]byte, read.size)
+		n, err := ra.ReadAt(p, read.off)
+		require.NoError(t, err)
+		require.Equal(t, data[read.off:read.off+int64(n)], p)
+	}
+	require.NoError(t, ra.Close())
+	require.Equal(t, data, <-received)
+}
+
+func TestConvertCompat(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+
+	var layer bytes.Buffer
+	gw := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "hello", Mode: 0644, Size: 5}))
+	_, err := tw.Write([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	layerDesc := registry.PutBlob("app", ocispec.MediaTypeImageLayerGzip, layer.Bytes())
+	config, err := json.Marshal(ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+		Config:   ocispec.ImageConfig{Env: []string{"FOO=bar"}},
+		RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromString("diff")}},
+	})
+	require.NoError(t, err)
+	configDesc := registry.PutBlob("app", ocispec.MediaTypeImageConfig, config)
+	source, err := registry.PutManifest("app", "v1", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	})
+	require.NoError(t, err)
+
+	bootstrap := []byte("bootstrap")
+	blob := nydusTar(t, map[string][]byte{nydusifyUtils.EntryBootstrap: bootstrap})
+	stub, err := testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{
+		Blob:      blob,
+		Bootstrap: bootstrap,
+		Blobs:     []string{digest.FromBytes(blob).Encoded()},
+	})
+	require.NoError(t, err)
+
+	opt := Opt{
+		WorkDir:         t.TempDir(),
+		NydusImagePath:  stub.Path,
+		Source:          registry.Host() + "/app:v1",
+		Target:          registry.Host() + "/app:v1-nydus",
+		SourcePlainHTTP: true,
+		TargetPlainHTTP: true,
+		FsVersion:       "6",
+		CompatFsVersion: "5",
+	}
+	require.NoError(t, Convert(context.Background(), opt))
+
+	// The manifest of single platform is wrapped into index along with the
+	// compatible manifest, no other tag is pushed.
+	data, _, ok := registry.Manifest("app", "v1-nydus")
+	require.True(t, ok)
+	var index ocispec.Index
+	require.NoError(t, json.Unmarshal(data, &index))
+	require.Equal(t, ocispec.MediaTypeImageIndex, index.MediaType)
+	require.Len(t, index.Manifests, 2)
+	_, _, ok = registry.Manifest("app", "v1-nydus-v5")
+	require.False(t, ok)
+
+	target, compat := index.Manifests[0], index.Manifests[1]
+	require.False(t, isCompatManifest(target))
+	require.Equal(t, "5", compat.Annotations[nydusifyUtils.ManifestNydusCompatImage])
+	require.Equal(t, []string{nydusifyUtils.ManifestOSFeatureNydus}, target.Platform.OSFeatures)
+	require.Equal(t, target.Platform, compat.Platform)
+
+	readManifest := func(desc ocispec.Descriptor) (ocispec.Manifest, ocispec.Image) {
+		data, _, ok := registry.Manifest("app", desc.Digest.String())
+		require.True(t, ok)
+		var manifest ocispec.Manifest
+		require.NoError(t, json.Unmarshal(data, &manifest))
+		data, ok = registry.Blob("app", manifest.Config.Digest)
+		require.True(t, ok)
+		var config ocispec.Image
+		require.NoError(t, json.Unmarshal(data, &config))
+		return manifest, config
+	}
+	targetManifest, targetConfig := readManifest(target)
+	compatManifest, compatConfig := readManifest(compat)
+	require.Equal(t, "6", targetManifest.Annotations[nydusifyUtils.LayerAnnotationNydusFsVersion])
+	require.Equal(t, "5", compatManifest.Annotations[nydusifyUtils.LayerAnnotationNydusFsVersion])
+	require.Equal(t, source.Digest.String(), compatManifest.Annotations[annotationSourceDigest])
+	require.Len(t, compatManifest.Layers, 2)
+	require.Equal(t, "5", compatManifest.Layers[1].Annotations[nydusifyUtils.LayerAnnotationNydusFsVersion])
+	require.Len(t, compatConfig.RootFS.DiffIDs, 2)
+	require.Equal(t, targetConfig.Config, compatConfig.Config)
+	require.Equal(t, targetConfig.History, compatConfig.History)
+	for _, layer := range compatManifest.Layers {
+		_, ok := registry.Blob("app", layer.Digest)
+		require.True(t, ok)
+	}
+
+	// The compatible manifests are checked by skip-converted.
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), func(string) (remote.CredentialFunc, bool, error) {
+		return func(string) (string, string, error) { return "", "", nil }, false, nil
+	}, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	opt.Compressor = ""
+	converted, reason, err := checkConverted(ctx, pvd, platforms.All, opt, opt.Source, opt.Target)
+	require.NoError(t, err)
+	require.True(t, converted, reason)
+	opt.CompatFsVersion = ""
+	converted, _, err = checkConverted(ctx, pvd, platforms.All, opt, opt.Source, opt.Target)
+	require.NoError(t, err)
+	require.True(t, converted)
+	opt.FsVersion, opt.CompatFsVersion = "5", "6"
+	converted, _, err = checkConverted(ctx, pvd, platforms.All, opt, opt.Source, opt.Target)
+	require.NoError(t, err)
+	require.False(t, converted)
+}
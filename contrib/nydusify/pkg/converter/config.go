@@ -12,6 +12,9 @@ func getConfig(opt Opt) map[string]string {
 	cfg := map[string]string{}
 
 	cfg["work_dir"] = opt.WorkDir
+	if opt.UnpackDir != "" {
+		cfg["work_dir"] = opt.UnpackDir
+	}
 	cfg["builder"] = opt.NydusImagePath
 
 	cfg["backend_type"] = opt.BackendType
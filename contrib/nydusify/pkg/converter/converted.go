@@ -0,0 +1,195 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// convertedAnnotations are the annotations compared to check whether the
+// target image is converted with identical options.
+var convertedAnnotations = []string{
+	nydusifyUtils.LayerAnnotationNydusFsVersion,
+	nydusifyUtils.ManifestNydusCompressor,
+	nydusifyUtils.ManifestNydusChunkSize,
+	nydusifyUtils.ManifestNydusBatchSize,
+	nydusifyUtils.ManifestNydusFsAlignChunk,
+	nydusifyUtils.ManifestNydusChunkDictReference,
+	nydusifyUtils.ManifestNydusChunkDictDigest,
+	nydusifyUtils.ManifestNydusUnpackFilter,
+}
+
+// resolveManifests resolves the reference and returns the image manifests
+// matching the platforms, nil if the reference doesn't exist.
+func resolveManifests(
+	ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, ref string,
+) (remotes.Fetcher, []ocispec.Descriptor, error) {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "parse reference %s", ref)
+	}
+	resolver, err := pvd.Resolver(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	name, desc, err := resolver.Resolve(ctx, named.String())
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, errors.Wrapf(err, "resolve %s", ref)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get fetcher")
+	}
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if err := fetchJSON(ctx, fetcher, desc, &index); err != nil {
+			return nil, nil, errors.Wrapf(err, "fetch index of %s", ref)
+		}
+		manifests := []ocispec.Descriptor{}
+		for _, manifest := range index.Manifests {
+			if !images.IsManifestType(manifest.MediaType) {
+				continue
+			}
+			if manifest.Platform != nil && !platformMC.Match(*manifest.Platform) {
+				continue
+			}
+			manifests = append(manifests, manifest)
+		}
+		return fetcher, manifests, nil
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		return fetcher, []ocispec.Descriptor{desc}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported media type %s of %s", desc.MediaType, ref)
+	}
+}
+
+// convertedOptions returns the option annotations expected in the Nydus
+// manifests converted with opt.
+func convertedOptions(opt Opt, chunkDictDigest string) map[string]string {
+	expected := optionAnnotations(opt, chunkDictDigest)
+	expected[nydusifyUtils.LayerAnnotationNydusFsVersion] = opt.FsVersion
+	return expected
+}
+
+// mismatchedOption returns the reason if the Nydus manifest isn't converted
+// with the expected options.
+func mismatchedOption(manifest ocispec.Manifest, desc ocispec.Descriptor, expected map[string]string) string {
+	for _, key := range convertedAnnotations {
+		if manifest.Annotations[key] != expected[key] {
+			return fmt.Sprintf(
+				"annotation %s of manifest %s is %q, but %q is expected",
+				key, desc.Digest, manifest.Annotations[key], expected[key],
+			)
+		}
+	}
+	return ""
+}
+
+// checkConverted checks whether the target image is already converted from
+// the exact source manifests with identical options, by the source digest
+// and option annotations recorded in the Nydus manifests of target. The
+// reason is returned if it's not converted.
+func checkConverted(
+	ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, opt Opt, source, target string,
+) (bool, string, error) {
+	_, sourceManifests, err := resolveManifests(ctx, pvd, platformMC, source)
+	if err != nil {
+		return false, "", errors.Wrap(err, "resolve source image")
+	}
+	if sourceManifests == nil {
+		return false, "", fmt.Errorf("source image %s not found", source)
+	}
+	fetcher, targetManifests, err := resolveManifests(ctx, pvd, platformMC, target)
+	if err != nil {
+		return false, "", errors.Wrap(err, "resolve target image")
+	}
+	if targetManifests == nil {
+		return false, fmt.Sprintf("target image %s not found", target), nil
+	}
+
+	chunkDictDigest := ""
+	if opt.ChunkDictRef != "" {
+		if chunkDictDigest, err = resolveDigest(ctx, pvd, opt.ChunkDictRef); err != nil {
+			return false, "", errors.Wrap(err, "resolve chunk dict image")
+		}
+	}
+	expected := convertedOptions(opt, chunkDictDigest)
+
+	converted := map[digest.Digest]bool{}
+	compat := map[digest.Digest]bool{}
+	for _, desc := range targetManifests {
+		if isCompatManifest(desc) {
+			if desc.Annotations[nydusifyUtils.ManifestNydusCompatImage] != opt.CompatFsVersion {
+				continue
+			}
+			var manifest ocispec.Manifest
+			if err := fetchJSON(ctx, fetcher, desc, &manifest); err != nil {
+				return false, "", errors.Wrapf(err, "fetch compatible manifest %s of target image", desc.Digest)
+			}
+			compat[digest.Digest(manifest.Annotations[annotationSourceDigest])] = true
+			continue
+		}
+		var manifest ocispec.Manifest
+		if err := fetchJSON(ctx, fetcher, desc, &manifest); err != nil {
+			return false, "", errors.Wrapf(err, "fetch manifest %s of target image", desc.Digest)
+		}
+		sourceDigest := manifest.Annotations[annotationSourceDigest]
+		if sourceDigest == "" {
+			// Skip the OCI manifest in merged index.
+			continue
+		}
+		if reason := mismatchedOption(manifest, desc, expected); reason != "" {
+			return false, reason, nil
+		}
+		converted[digest.Digest(sourceDigest)] = true
+	}
+
+	for _, desc := range sourceManifests {
+		if !converted[desc.Digest] {
+			return false, fmt.Sprintf("source manifest %s is not converted", desc.Digest), nil
+		}
+		if opt.CompatFsVersion != "" && !compat[desc.Digest] {
+			return false, fmt.Sprintf("source manifest %s is not converted with compatible fs version %s", desc.Digest, opt.CompatFsVersion), nil
+		}
+	}
+	if len(converted) != len(sourceManifests) {
+		return false, "target image is converted from other source manifests", nil
+	}
+
+	return true, "", nil
+}
+
+// alreadyConverted checks whether the target image, including the compatible
+// manifests if required, is already converted from source with identical
+// options.
+func alreadyConverted(ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, opt Opt) (bool, error) {
+	converted, reason, err := checkConverted(ctx, pvd, platformMC, opt, opt.Source, opt.Target)
+	if err != nil {
+		return false, err
+	}
+	if !converted {
+		logrus.Infof("image %s needs conversion: %s", opt.Target, reason)
+	}
+	return converted, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestCheckConverted(t *testing.T) {
+	manifests := map[string][]byte{}
+	addManifest := func(repo, tag string, manifest ocispec.Manifest) digest.Digest {
+		manifest.Versioned = specs.Versioned{SchemaVersion: 2}
+		manifest.MediaType = ocispec.MediaTypeImageManifest
+		data, err := json.Marshal(manifest)
+		require.NoError(t, err)
+		dgst := digest.FromBytes(data)
+		manifests[repo+"/manifests/"+tag] = data
+		manifests[repo+"/manifests/"+dgst.String()] = data
+		return dgst
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := manifests[strings.TrimPrefix(r.URL.Path, "/v2/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	sourceDigest := addManifest("app", "latest", ocispec.Manifest{
+		Config: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromString("config"), Size: 6},
+	})
+	addManifest("app", "latest-nydus", ocispec.Manifest{
+		Config: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromString("nydus"), Size: 5},
+		Annotations: map[string]string{
+			annotationSourceDigest:                      sourceDigest.String(),
+			nydusifyUtils.LayerAnnotationNydusFsVersion: "6",
+			nydusifyUtils.ManifestNydusCompressor:       "zstd",
+			nydusifyUtils.ManifestNydusFsAlignChunk:     "false",
+		},
+	})
+	addManifest("app", "other-nydus", ocispec.Manifest{
+		Config: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromString("other"), Size: 5},
+		Annotations: map[string]string{
+			annotationSourceDigest:                      digest.FromString("other").String(),
+			nydusifyUtils.LayerAnnotationNydusFsVersion: "6",
+			nydusifyUtils.ManifestNydusCompressor:       "zstd",
+			nydusifyUtils.ManifestNydusFsAlignChunk:     "false",
+		},
+	})
+
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), func(string) (remote.CredentialFunc, bool, error) {
+		return func(string) (string, string, error) { return "", "", nil }, false, nil
+	}, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+
+	source := host + "/app:latest"
+	opt := Opt{FsVersion: "6", Compressor: "zstd"}
+	converted, reason, err := checkConverted(ctx, pvd, platforms.All, opt, source, host+"/app:latest-nydus")
+	require.NoError(t, err)
+	require.True(t, converted, reason)
+
+	opt.ChunkSize = "0x100000"
+	converted, reason, err = checkConverted(ctx, pvd, platforms.All, opt, source, host+"/app:latest-nydus")
+	require.NoError(t, err)
+	require.False(t, converted)
+	require.Contains(t, reason, nydusifyUtils.ManifestNydusChunkSize)
+
+	opt.ChunkSize = ""
+	converted, reason, err = checkConverted(ctx, pvd, platforms.All, opt, source, host+"/app:other-nydus")
+	require.NoError(t, err)
+	require.False(t, converted)
+	require.Contains(t, reason, "is not converted")
+
+	converted, reason, err = checkConverted(ctx, pvd, platforms.All, opt, source, host+"/app:missing")
+	require.NoError(t, err)
+	require.False(t, converted)
+	require.Contains(t, reason, "not found")
+
+	_, _, err = checkConverted(ctx, pvd, platforms.All, opt, host+"/app:missing", host+"/app:latest-nydus")
+	require.ErrorContains(t, err, "source image")
+}
@@ -6,13 +6,22 @@ package converter
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/cache"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/sandbox"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
 	"github.com/goharbor/acceleration-service/pkg/converter"
-	"github.com/goharbor/acceleration-service/pkg/platformutil"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 type Opt struct {
@@ -27,6 +36,25 @@ type Opt struct {
 	SourceInsecure    bool
 	TargetInsecure    bool
 	ChunkDictInsecure bool
+	// SourceCredential and TargetCredential are used instead of the docker
+	// config to access source and target registries if not nil.
+	SourceCredential *Credential
+	TargetCredential *Credential
+	// Credentials scopes the credentials by registry hosts and operations,
+	// which replaces the docker config if not nil.
+	Credentials *CredentialManager
+
+	// DedupDB is the URL of dedup database shared by converters, it's
+	// queried for the chunk dict of DedupScope if ChunkDictRef is empty.
+	DedupDB    string
+	DedupScope string
+
+	// The plain HTTP options make the registries accessed by plain HTTP,
+	// while the insecure options skip verifying certs for HTTPS.
+	SourcePlainHTTP    bool
+	TargetPlainHTTP    bool
+	ChunkDictPlainHTTP bool
+	CachePlainHTTP     bool
 
 	CacheRef        string
 	CacheInsecure   bool
@@ -36,30 +64,242 @@ type Opt struct {
 	BackendType      string
 	BackendConfig    string
 	BackendForcePush bool
+	// BackendObjectLayout is the object key prefix template for blobs in
+	// oss and s3 backends, e.g. `{repository}/{date}`, which is appended
+	// to the `object_prefix` of backend config.
+	BackendObjectLayout string
+	// WithBlobMeta pushes the blob meta of converted layers alongside their
+	// blobs as `$blob_id.blob.meta` to storage backend other than registry.
+	WithBlobMeta bool
 
-	MergePlatform    bool
-	Docker2OCI       bool
-	FsVersion        string
+	MergePlatform bool
+	Docker2OCI    bool
+	FsVersion     string
+	// CompatFsVersion builds another image with the specified RAFS version
+	// from the source layers unpacked for target image, its manifests are
+	// appended to the index of target image.
+	CompatFsVersion  string
 	FsAlignChunk     bool
 	Compressor       string
 	ChunkSize        string
 	BatchSize        string
 	PrefetchPatterns string
-	OCIRef           bool
-	WithReferrer     bool
+	// PrefetchHeuristic infers the prefetch patterns from the entrypoint of
+	// source image if PrefetchPatterns is empty, see InferPrefetchPatterns
+	// of utils package.
+	PrefetchHeuristic bool
+	OCIRef            bool
+	WithReferrer      bool
+	// PushSourceThrough mirrors the source image with all platforms as-is
+	// to the target repository, tagged with `-oci` suffix of the target
+	// tag, for the registries that must hold both formats.
+	PushSourceThrough bool
+	// LocalCacheDir is the directory of local build cache, which stores the
+	// converted layers to be reused by later runs, for example the re-run
+	// of failed conversion, empty disables it.
+	LocalCacheDir string
+	// SourceCacheDir is the directory of source layer cache, which stores
+	// the downloaded source layers to be reused by later conversions,
+	// empty disables it. SourceCacheMaxSize is its size cap in bytes, the
+	// least recently used layers are evicted, zero means no limit.
+	SourceCacheDir     string
+	SourceCacheMaxSize int64
+	// PreviousTarget is the target image converted from the previous
+	// version of source image, e.g. the previous tag, whose layers are
+	// reused for the source layers unchanged since then, found by the
+	// source digest annotation of its Nydus manifests.
+	PreviousTarget string
+	// BootstrapPlacement specifies how to push bootstrap, possible values:
+	// layer, artifact, both, default to layer.
+	BootstrapPlacement string
+	// BootstrapCompression specifies the compression of pushed bootstrap
+	// layer, possible values: gzip, zstd, none, default to gzip.
+	BootstrapCompression string
+	// ManifestProfile renames the layer media types, annotation keys and OS
+	// features in Nydus manifests of target image.
+	ManifestProfile *ManifestProfile
+	// ConfigMutation is applied to the image config and manifest of target
+	// image before it's pushed.
+	ConfigMutation *ConfigMutation
+	// Policy overrides the conversion options by the rule matching source
+	// image name.
+	Policy *Policy
+	// Publishing notifies the external systems of target image after
+	// successful conversion.
+	Publishing *Publishing
+	// Provenance attaches the SLSA provenance attestation of conversion to
+	// target image via the Referrers API, NydusifyVersion is recorded in it.
+	Provenance      bool
+	NydusifyVersion string
+	// VerifySource verifies the cosign signatures of source image before
+	// conversion, the unsigned or mis-signed images are refused.
+	VerifySource *SourceVerification
+	// SignTarget signs the pushed target image by notation after
+	// conversion.
+	SignTarget *TargetSigning
+	// Verify verifies the filesystem of target image against the source
+	// image after conversion.
+	Verify *Verification
+	// VerifyRun runs the target image in a container after conversion.
+	VerifyRun *RunVerification
+	// ValidateSource validates the tar entries of source layers before
+	// they're unpacked, the layers with path traversal attempts, symlink
+	// escapes, or entries lying about size or larger than
+	// ValidateMaxEntrySize (0 means no limit) are refused.
+	ValidateSource       bool
+	ValidateMaxEntrySize int64
 
 	AllPlatforms bool
 	Platforms    string
 
+	// AdaptiveConcurrency adjusts the concurrency of pulling and pushing
+	// layers at runtime in range [1, MaxConcurrency]. The layer builds are
+	// bounded by MaxConcurrentBuilds independently.
+	AdaptiveConcurrency bool
+	MaxConcurrency      int
+	// MaxConcurrentBuilds bounds the layers built by `nydus-image create`
+	// concurrently, 0 means no limit.
+	MaxConcurrentBuilds int
+	// UnpackFilter filters or transforms the files of source layers before
+	// they're built, e.g. drops caches and logs, to slim the image.
+	UnpackFilter utils.UnpackFilter
+	// OverlapPush pushes each converted blob to target registry as soon as
+	// it's built, while the next layers are still building. It's ignored
+	// if blobs are pushed to storage backend.
+	OverlapPush bool
+	// VerifyPush fetches each pushed manifest back by digest to verify the
+	// registry stored exactly what was sent.
+	VerifyPush bool
+	// DigestAlgorithm digests the pushed index, manifests, configs and
+	// bootstrap layers, default to sha256.
+	DigestAlgorithm digest.Algorithm
+	// SkipConverted skips the conversion if the target image is already
+	// converted from the exact source manifests with identical options
+	// recorded in the manifest annotations.
+	SkipConverted bool
+
+	// OutputInventory is the file path to write the inventory of produced
+	// artifacts (blobs and bootstraps) in JSON format.
+	OutputInventory string
+	// OutputReport is the file path to write the conversion report, which
+	// compares the source image with the converted image.
+	OutputReport string
+	// HistoryDir is the directory to persist the conversion summaries
+	// per target reference, which are compared across rebuilds by
+	// `nydusify history`.
+	HistoryDir string
+
+	// UnpackDir is the directory for unpacking source layers and building
+	// blobs, and BlobDir is the directory for staging pulled and converted
+	// blobs, both default to the work directory. For example, put UnpackDir
+	// on tmpfs and BlobDir on a large disk.
+	UnpackDir string
+	BlobDir   string
+	// UnpackDirLimit and BlobDirLimit are the size caps in bytes of the
+	// areas, the conversion fails once exceeded, 0 means no limit.
+	UnpackDirLimit int64
+	BlobDirLimit   int64
+
+	// WorkDirGCAge removes the temp directories left by crashed runs in
+	// work directories, which are older than the age, 0 disables it.
+	WorkDirGCAge time.Duration
+	// KeepWorkDir keeps the temp directories of this conversion on exit
+	// for debugging, KeepArtifacts retains the intermediate artifacts of
+	// the stages (see ArtifactRootfs etc.) into the `artifacts` directory
+	// of it, which are removed by converter otherwise.
+	KeepWorkDir   bool
+	KeepArtifacts []string
+
+	// Sandbox runs builder with no network, a restricted seccomp profile
+	// and a read-only view of everything except the work directory.
+	Sandbox bool
+	// BuilderLimit limits the CPU and memory of the builder processes of
+	// this conversion, which run in a cgroup created under the cgroup v2
+	// directory BuilderCgroupParent.
+	BuilderLimit        utils.CgroupLimit
+	BuilderCgroupParent string
+	// BuilderPriority is the CPU and IO priority of the builder processes,
+	// which is applied out of the sandbox and before the builder is moved
+	// into the cgroup of BuilderLimit.
+	BuilderPriority utils.PriorityOpt
+	// KeepGoing converts the platforms of source image separately, and
+	// continues with the rest platforms after a platform fails, the target
+	// index only references the succeeded platforms.
+	KeepGoing bool
+	// Deadline bounds the time of conversion, 0 means no deadline. At the
+	// deadline, the conversion is aborted and the pushed target images are
+	// rolled back with DeadlineAbort (default), or the platforms converted
+	// in time are pushed as partial result with DeadlinePartial.
+	Deadline       time.Duration
+	DeadlineAction string
+
 	OutputJSON string
 }
 
-func Convert(ctx context.Context, opt Opt) error {
+func Convert(ctx context.Context, opt Opt) (err error) {
+	start := time.Now()
+	layers := 0
+	defer func() {
+		recordMetrics(opt.Source, layers, start, err)
+	}()
 	ctx = namespaces.WithNamespace(ctx, "nydusify")
-	platformMC, err := platformutil.ParsePlatforms(opt.AllPlatforms, opt.Platforms)
-	if err != nil {
+	// The options are validated before any work, the failures are config
+	// errors.
+	if err := applyPolicy(&opt); err != nil {
+		return utils.WithExitCode(err, utils.ExitConfig)
+	}
+	if err := resolveSharedChunkDict(ctx, &opt); err != nil {
 		return err
 	}
+	platformMC, err := utils.ParsePlatforms(opt.AllPlatforms, opt.Platforms)
+	if err != nil {
+		return utils.WithExitCode(err, utils.ExitConfig)
+	}
+	source, target, err := parseTransports(&opt)
+	if err != nil {
+		return utils.WithExitCode(err, utils.ExitConfig)
+	}
+	if err := applyObjectLayout(&opt, time.Now()); err != nil {
+		return utils.WithExitCode(err, utils.ExitConfig)
+	}
+	if err := checkDeadline(&opt); err != nil {
+		return utils.WithExitCode(err, utils.ExitConfig)
+	}
+	if opt.KeepGoing && opt.CacheRef != "" {
+		return utils.WithExitCode(fmt.Errorf("build cache can't be used in keep-going mode"), utils.ExitConfig)
+	}
+	if err := checkCompat(opt); err != nil {
+		return utils.WithExitCode(err, utils.ExitConfig)
+	}
+	sourceThroughRef := ""
+	if opt.PushSourceThrough {
+		if sourceThroughRef, err = checkSourceThrough(opt, target.IsRegistry()); err != nil {
+			return utils.WithExitCode(err, utils.ExitConfig)
+		}
+	}
+
+	if err := ValidateKeepArtifacts(opt.KeepArtifacts); err != nil {
+		return utils.WithExitCode(err, utils.ExitConfig)
+	}
+	if len(opt.KeepArtifacts) > 0 {
+		opt.KeepWorkDir = true
+	}
+
+	// The whole conversion is bounded by the deadline to abort, while only
+	// the conversion of platforms is bounded for partial result, and the
+	// platforms converted in time are still pushed.
+	deadlineCtx, cancelDeadline := withDeadline(ctx, opt, start)
+	defer cancelDeadline()
+	if opt.DeadlineAction == DeadlineAbort {
+		ctx = deadlineCtx
+	}
+	var rollback *deadlineRollback
+	converted := false
+	defer func() {
+		if err != nil && deadlineExceeded(deadlineCtx) && (opt.DeadlineAction == DeadlineAbort || !converted) {
+			err = abortAtDeadline(ctx, rollback, opt, err)
+		}
+	}()
 
 	if _, err := os.Stat(opt.WorkDir); err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -68,33 +308,420 @@ func Convert(ctx context.Context, opt Opt) error {
 			}
 			// We should only clean up when the work directory not exists
 			// before, otherwise it may delete user data by mistake.
-			defer os.RemoveAll(opt.WorkDir)
+			if !opt.KeepWorkDir {
+				defer os.RemoveAll(opt.WorkDir)
+			}
 		} else {
 			return errors.Wrap(err, "stat work directory")
 		}
 	}
-	tmpDir, err := os.MkdirTemp(opt.WorkDir, "nydusify-")
+	if opt.WorkDirGCAge > 0 {
+		for _, base := range []string{opt.WorkDir, opt.UnpackDir, opt.BlobDir} {
+			if base == "" {
+				continue
+			}
+			if err := utils.CleanStaleDirs(base, opt.WorkDirGCAge); err != nil {
+				logrus.WithError(err).Warnf("failed to clean stale work directories in %s", base)
+			}
+		}
+	}
+	tmpDir, err := utils.MkdirTemp(opt.WorkDir)
 	if err != nil {
 		return errors.Wrap(err, "create temp directory")
 	}
-	pvd, err := provider.New(tmpDir, hosts(opt), opt.CacheMaxRecords, opt.CacheVersion, platformMC, 0)
+	if opt.KeepWorkDir {
+		if err := utils.KeepTempDir(tmpDir); err != nil {
+			return err
+		}
+	} else {
+		defer os.RemoveAll(tmpDir)
+	}
+
+	unpackDir, cleanupUnpack, err := prepareArea(opt.UnpackDir, opt.WorkDir, opt.KeepWorkDir)
+	if err != nil {
+		return errors.Wrap(err, "prepare unpack area")
+	}
+	defer cleanupUnpack()
+	blobDir, cleanupBlob, err := prepareArea(opt.BlobDir, tmpDir, opt.KeepWorkDir)
+	if err != nil {
+		return errors.Wrap(err, "prepare blob area")
+	}
+	defer cleanupBlob()
+	opt.UnpackDir = unpackDir
+	if opt.KeepWorkDir {
+		artifactDir := filepath.Join(tmpDir, "artifacts")
+		defer func() {
+			index := keptIndex{
+				Source:    opt.Source,
+				Target:    opt.Target,
+				WorkDir:   tmpDir,
+				UnpackDir: unpackDir,
+				BlobDir:   blobDir,
+			}
+			if err != nil {
+				index.Error = err.Error()
+			}
+			if err := writeKeptIndex(artifactDir, index); err != nil {
+				logrus.WithError(err).Warn("failed to write index of kept work directory")
+			}
+			logrus.Infof("kept work directory %s, see %s", tmpDir, filepath.Join(artifactDir, keepIndexFile))
+		}()
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go watchAreas(ctx, cancel, []workArea{
+		{name: "unpack", dir: unpackDir, limit: opt.UnpackDirLimit},
+		{name: "blob", dir: blobDir, limit: opt.BlobDirLimit},
+	})
+
+	nydusImageVersion, err := checkBuilder(&opt)
+	if err != nil {
+		return err
+	}
+	// The checker runs nydus-image out of the sandbox.
+	nydusImagePath := opt.NydusImagePath
+	// The wrappers of builder run out of the sandbox, they're written into
+	// a private directory out of the writable paths of sandbox.
+	var writable []string
+	if opt.Sandbox {
+		writable = []string{opt.WorkDir, unpackDir}
+	}
+	wrapperDir, err := sandbox.PrivateDir("nydusify-builder-", writable...)
 	if err != nil {
+		return errors.Wrap(err, "prepare builder wrapper directory")
+	}
+	defer os.RemoveAll(wrapperDir)
+	if opt.Sandbox {
+		wrapperPath, err := sandbox.Wrap(opt.NydusImagePath, opt.WorkDir, unpackDir)
+		if err != nil {
+			return errors.Wrap(err, "prepare builder sandbox")
+		}
+		defer os.RemoveAll(filepath.Dir(wrapperPath))
+		opt.NydusImagePath = wrapperPath
+	}
+	if opt.BuilderLimit.Enabled() {
+		cgroup, err := utils.CreateCgroup(opt.BuilderCgroupParent, filepath.Base(tmpDir), opt.BuilderLimit)
+		if err != nil {
+			return errors.Wrap(err, "prepare builder cgroup")
+		}
+		defer func() {
+			if err := utils.RemoveCgroup(cgroup); err != nil {
+				logrus.WithError(err).Warn("failed to remove builder cgroup")
+			}
+		}()
+		wrapperPath, err := utils.WrapCgroup(opt.NydusImagePath, cgroup, wrapperDir)
+		if err != nil {
+			return errors.Wrap(err, "prepare builder cgroup")
+		}
+		opt.NydusImagePath = wrapperPath
+	}
+	if opt.BuilderPriority.Enabled() {
+		wrapperPath, err := utils.WrapPriority(opt.NydusImagePath, opt.BuilderPriority, wrapperDir)
+		if err != nil {
+			return errors.Wrap(err, "prepare builder priority")
+		}
+		opt.NydusImagePath = wrapperPath
+	}
+	if len(opt.KeepArtifacts) > 0 {
+		// The artifacts are retained out of the sandbox and cgroup.
+		wrapperPath, err := wrapKeeper(opt.NydusImagePath, wrapperDir, filepath.Join(tmpDir, "artifacts"), []string{opt.WorkDir, unpackDir}, opt.KeepArtifacts)
+		if err != nil {
+			return errors.Wrap(err, "prepare builder keeper")
+		}
+		opt.NydusImagePath = wrapperPath
+	}
+	pvd, err := provider.New(blobDir, hosts(opt), opt.CacheMaxRecords, opt.CacheVersion, platformMC, 0)
+	if err != nil {
+		return err
+	}
+	pvd.SetDigestAlgorithm(opt.DigestAlgorithm)
+	if err := setPlainHTTP(pvd, opt); err != nil {
+		return err
+	}
+	if opt.SourceCacheDir != "" {
+		sourceCache, err := cache.NewSourceCache(opt.SourceCacheDir, opt.SourceCacheMaxSize)
+		if err != nil {
+			return err
+		}
+		pvd.SetSourceCache(sourceCache)
+	}
+	// The source image is pushed through from the plain content store.
+	sourceStore := pvd.ContentStore()
+	if opt.SkipConverted && source.IsRegistry() && target.IsRegistry() {
+		converted, err := alreadyConverted(ctx, pvd, platformMC, opt)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to check whether the image is already converted")
+		} else if converted {
+			logrus.Infof("image %s is already converted from %s with identical options, skip conversion", opt.Target, opt.Source)
+			return nil
+		}
+	}
+	if opt.VerifySource != nil {
+		if err := verifySource(ctx, pvd, &opt); err != nil {
+			return err
+		}
+	}
+	addLayerValidator(pvd, platformMC, opt)
+	addUnpackFilter(pvd, opt.UnpackFilter, tmpDir)
+	addBuildProgress(pvd)
+	addBuildLimiter(pvd, opt.MaxConcurrentBuilds)
+
+	if opt.AdaptiveConcurrency {
+		limiter := utils.NewAdaptiveLimiter(1, opt.MaxConcurrency)
+		pvd.SetAdaptiveLimiter(limiter)
+		limiterCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go limiter.Run(limiterCtx, utils.AdaptiveInterval)
+	}
+
+	if err := loadSource(ctx, pvd, source, opt.Source, tmpDir); err != nil {
+		return err
+	}
+	if err := checkSourcePlatforms(ctx, pvd, platformMC, opt); err != nil {
 		return err
 	}
-	defer os.RemoveAll(tmpDir)
+	applyPrefetchHeuristic(ctx, pvd, platformMC, &opt)
+	setTargetExporter(pvd, target, opt.Target, tmpDir)
 
-	cvt, err := converter.New(
-		converter.WithProvider(pvd),
-		converter.WithDriver("nydus", getConfig(opt)),
-		converter.WithPlatform(platformMC),
-	)
+	if opt.VerifyPush && target.IsRegistry() {
+		verifier := &pushVerifier{pvd: pvd}
+		pvd.AddPushHook(verifier.hook())
+	}
+
+	var recorder *inventoryRecorder
+	if opt.OutputInventory != "" {
+		if recorder, err = addInventoryRecorder(pvd, opt); err != nil {
+			return err
+		}
+	}
+
+	if err := addLifecycleApplier(pvd, opt, opt.Target); err != nil {
+		return err
+	}
+	if opt.Deadline > 0 && target.IsRegistry() {
+		if rollback, err = newDeadlineRollback(ctx, opt, opt.Target); err != nil {
+			return err
+		}
+	}
+	var signer *targetSigner
+	if opt.SignTarget != nil && target.IsRegistry() {
+		signer = addTargetSigner(pvd, opt.Target)
+	}
+
+	var publisher *publishRecorder
+	if opt.Publishing != nil {
+		publisher = addPublishRecorder(pvd, opt.Target)
+	}
+
+	var rpt *reporter
+	if opt.OutputReport != "" || opt.HistoryDir != "" {
+		if rpt, err = newReporter(pvd, opt.Source, opt.Target); err != nil {
+			return err
+		}
+		rpt.prepare(ctx, opt.ChunkDictRef, opt.CacheRef)
+		pvd.AddPushHook(rpt.hook())
+	}
+
+	chunkDictDigest := ""
+	if opt.ChunkDictRef != "" {
+		if chunkDictDigest, err = resolveDigest(ctx, pvd, opt.ChunkDictRef); err != nil {
+			return errors.Wrap(err, "resolve chunk dict image")
+		}
+	}
+	localCache, err := addLocalCache(pvd, opt, chunkDictDigest)
 	if err != nil {
+		return errors.Wrap(err, "open local cache")
+	}
+	if opt.PreviousTarget != "" && source.IsRegistry() {
+		// The layers in local cache take precedence over the ones to be
+		// fetched from previous target.
+		if err := addDiffConversion(ctx, pvd, platformMC, opt, chunkDictDigest); err != nil {
+			logrus.WithError(err).Warn("failed to diff previous target, convert all layers")
+		}
+	}
+	if err := addSourceLayerAnnotator(pvd, opt.Target); err != nil {
+		return err
+	}
+	if localCache != nil {
+		// The layers reused from previous target are cached by the
+		// source layers annotated.
+		if err := localCache.addSeeder(pvd, opt.Target); err != nil {
+			return err
+		}
+	}
+
+	if err := addBlobMetaUploader(pvd, opt, filepath.Join(tmpDir, "blob-meta")); err != nil {
+		return err
+	}
+
+	if err := addPlatformFiller(pvd, opt.Target); err != nil {
+		return err
+	}
+	// The mutator, annotator and preserver must run before the placer, as the
+	// bootstrap artifact refers to the final manifest digest.
+	if err := addConfigMutator(pvd, opt, opt.Target); err != nil {
 		return err
 	}
+	if err := addOptionAnnotator(pvd, opt, chunkDictDigest, opt.Target); err != nil {
+		return err
+	}
+	if err := addSourcePreserver(pvd, opt.Source, opt.Target); err != nil {
+		return err
+	}
+	if err := addBootstrapCompressor(pvd, opt, opt.Target); err != nil {
+		return err
+	}
+	if err := addProfileApplier(pvd, opt, opt.Target); err != nil {
+		return err
+	}
+	if err := addBootstrapPlacer(pvd, opt, opt.Target); err != nil {
+		return err
+	}
+	if err := addProvenanceAttester(pvd, opt, chunkDictDigest); err != nil {
+		return err
+	}
+	if opt.CompatFsVersion != "" {
+		// The compatible manifests copy the final Nydus manifests.
+		if err := addCompatBuilder(deadlineCtx, pvd, opt, filepath.Join(tmpDir, "compat")); err != nil {
+			return err
+		}
+	}
+
+	if target.IsRegistry() {
+		if err := setOverlapPush(ctx, pvd, opt, opt.Target); err != nil {
+			return err
+		}
+	}
+	if rollback != nil {
+		pvd.AddPushHook(rollback.hook(opt.Target))
+	}
 
-	metric, err := cvt.Convert(ctx, opt.Source, opt.Target, opt.CacheRef)
+	var metric *converter.Metric
+	var batchErr *utils.BatchError
+	err = withSharedLayers(pvd, func() error {
+		if opt.KeepGoing {
+			var err error
+			var deadline time.Time
+			if opt.DeadlineAction == DeadlinePartial {
+				deadline, _ = deadlineCtx.Deadline()
+			}
+			metric, batchErr, err = convertPlatforms(ctx, pvd, platformMC, opt, deadline)
+			return err
+		}
+		cvt, err := converter.New(
+			converter.WithProvider(pvd),
+			converter.WithDriver("nydus", getConfig(opt)),
+			converter.WithPlatform(platformMC),
+		)
+		if err != nil {
+			return err
+		}
+		metric, err = cvt.Convert(ctx, opt.Source, opt.Target, opt.CacheRef)
+		return err
+	})
+	// The source image of registry is pulled during conversion.
+	layers = countLayers(ctx, pvd, platformMC, opt.Source)
 	if opt.OutputJSON != "" {
-		dumpMetric(metric, opt.OutputJSON)
+		dumpMetric(metric, nydusImageVersion, opt.OutputJSON)
+	}
+	if err != nil {
+		return areaError(ctx, err)
+	}
+	converted = true
+
+	if recorder != nil {
+		if err := recorder.dump(opt.OutputInventory); err != nil {
+			return err
+		}
+	}
+	if rpt != nil && opt.OutputReport != "" {
+		if err := rpt.dump(opt.OutputReport); err != nil {
+			return err
+		}
+	}
+	if batchErr != nil {
+		if opt.DeadlineAction == DeadlinePartial && deadlineExceeded(deadlineCtx) {
+			return utils.WithExitCode(errors.Wrapf(batchErr, "conversion exceeded deadline %s, pushed partial result", opt.Deadline), utils.ExitDeadline)
+		}
+		return batchErr
+	}
+	if sourceThroughRef != "" {
+		if err := pushSourceThrough(ctx, pvd, sourceStore, opt.Source, sourceThroughRef); err != nil {
+			return err
+		}
+	}
+	if rpt != nil && opt.HistoryDir != "" {
+		if err := appendHistory(opt.HistoryDir, newHistoryEntry(rpt.report, metric, start)); err != nil {
+			return errors.Wrap(err, "record conversion history")
+		}
+	}
+	if signer != nil {
+		if err := signer.sign(ctx, opt); err != nil {
+			return err
+		}
+	}
+	if opt.Verify != nil {
+		if err := verifyTarget(ctx, pvd, platformMC, opt, nydusImagePath, tmpDir); err != nil {
+			return err
+		}
+	}
+	if opt.VerifyRun != nil {
+		if err := runTarget(ctx, pvd, opt); err != nil {
+			return errors.Wrap(err, "verify target image by running container")
+		}
+	}
+	if publisher != nil {
+		if err := opt.Publishing.Publish(ctx, publisher.publication(opt, start)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addInventoryRecorder tracks the artifacts of target image pushed to
+// registry.
+func addInventoryRecorder(pvd *provider.Provider, opt Opt) (*inventoryRecorder, error) {
+	locate := func(string) string { return "" }
+	if opt.BackendType != "" {
+		bkd, err := backend.NewBackend(opt.BackendType, []byte(opt.BackendConfig), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "create storage backend")
+		}
+		locate = func(blobID string) string {
+			return backend.RemoteID(bkd, blobID)
+		}
+	}
+
+	recorder := newInventoryRecorder(pvd, locate)
+	if err := recorder.track(opt.Target); err != nil {
+		return nil, err
+	}
+	pvd.AddPushHook(recorder.hook())
+
+	return recorder, nil
+}
+
+func setOverlapPush(ctx context.Context, pvd *provider.Provider, opt Opt, target string) error {
+	if !opt.OverlapPush || opt.BackendType != "" {
+		return nil
+	}
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return errors.Wrap(err, "parse target reference")
+	}
+	pvd.SetOverlapPush(ctx, named.String())
+	return nil
+}
+
+func addBootstrapPlacer(pvd *provider.Provider, opt Opt, target string) error {
+	if opt.BootstrapPlacement == "" || opt.BootstrapPlacement == BootstrapPlacementLayer {
+		return nil
+	}
+	placer, err := newBootstrapPlacer(pvd, opt.BootstrapPlacement, target)
+	if err != nil {
+		return err
 	}
-	return err
+	pvd.AddPushHook(placer.hook())
+	return nil
 }
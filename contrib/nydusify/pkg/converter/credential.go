@@ -0,0 +1,255 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const redacted = "<redacted>"
+
+// Credential is the username and password of registry, it's redacted when
+// formatted, so that it's never leaked to logs.
+type Credential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (cred Credential) String() string {
+	return redacted
+}
+
+func (cred Credential) GoString() string {
+	return redacted
+}
+
+func (cred *Credential) credFunc() remote.CredentialFunc {
+	return func(string) (string, string, error) {
+		return cred.Username, cred.Password, nil
+	}
+}
+
+const (
+	// OperationPull reads images from registry.
+	OperationPull = "pull"
+	// OperationPush writes images to registry, it's required for the
+	// repositories of target and build cache, which are read as well.
+	OperationPush = "push"
+)
+
+// The credential names recorded in audit for the credentials not in rules.
+const (
+	auditExplicit     = "explicit"
+	auditDockerConfig = "docker-config"
+	auditAnonymous    = "anonymous"
+)
+
+// CredentialRule scopes a credential to the registry hosts and operations.
+type CredentialRule struct {
+	// Name identifies the credential in audit, the credential itself is
+	// never recorded.
+	Name string `json:"name"`
+	// Hosts are the registry hosts like `docker.io` or `localhost:5000`,
+	// `*.example.com` matches the subdomains of example.com.
+	Hosts []string `json:"hosts"`
+	// Operations are `pull` and `push`, default to both.
+	Operations []string `json:"operations,omitempty"`
+	Credential
+	// PasswordEnv is the environment variable of password, so that the
+	// rule file can be shared without secrets.
+	PasswordEnv string `json:"password_env,omitempty"`
+}
+
+func (rule *CredentialRule) match(host, operation string) bool {
+	if len(rule.Operations) > 0 {
+		allowed := false
+		for _, op := range rule.Operations {
+			allowed = allowed || op == operation
+		}
+		if !allowed {
+			return false
+		}
+	}
+	for _, pattern := range rule.Hosts {
+		if pattern == host || (strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// CredentialAudit records which credential was used to authenticate to
+// registry for the reference.
+type CredentialAudit struct {
+	Time      time.Time `json:"time"`
+	Ref       string    `json:"ref"`
+	Host      string    `json:"host"`
+	Operation string    `json:"operation"`
+	// Credential is the name of credential rule, or `explicit` for the
+	// credential of options or proxy job, `docker-config` for the docker
+	// config, and `anonymous` if no credential is used.
+	Credential string `json:"credential"`
+}
+
+// CredentialManager provides the credentials scoped by rules instead of the
+// docker config, so that a credential is never sent to other registries or
+// used for other operations when nydusify runs as a shared service. The
+// registry without matched rule is accessed anonymously, unless the docker
+// config is enabled as fallback.
+type CredentialManager struct {
+	rules        []CredentialRule
+	dockerConfig bool
+
+	mutex sync.Mutex
+	audit io.Writer
+}
+
+// NewCredentialManager creates the manager by rules, the first matched rule
+// is used. The docker config is used if dockerConfig and no rule matches.
+func NewCredentialManager(rules []CredentialRule, dockerConfig bool) (*CredentialManager, error) {
+	names := map[string]bool{}
+	for idx := range rules {
+		rule := &rules[idx]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("name of credential #%d is required", idx)
+		}
+		if names[rule.Name] || rule.Name == auditExplicit || rule.Name == auditDockerConfig || rule.Name == auditAnonymous {
+			return nil, fmt.Errorf("duplicated or reserved credential name %s", rule.Name)
+		}
+		names[rule.Name] = true
+		if len(rule.Hosts) == 0 {
+			return nil, fmt.Errorf("hosts of credential %s are required", rule.Name)
+		}
+		for _, op := range rule.Operations {
+			if op != OperationPull && op != OperationPush {
+				return nil, fmt.Errorf("invalid operation %q of credential %s, possible values: %s, %s", op, rule.Name, OperationPull, OperationPush)
+			}
+		}
+		if rule.PasswordEnv != "" {
+			if rule.Password = os.Getenv(rule.PasswordEnv); rule.Password == "" {
+				return nil, fmt.Errorf("environment variable %s of credential %s is empty", rule.PasswordEnv, rule.Name)
+			}
+		}
+	}
+	return &CredentialManager{rules: rules, dockerConfig: dockerConfig}, nil
+}
+
+// LoadCredentialManager loads the credential rules from the JSON file.
+func LoadCredentialManager(file string) (*CredentialManager, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read credential config file")
+	}
+	var config struct {
+		Credentials  []CredentialRule `json:"credentials"`
+		DockerConfig bool             `json:"docker_config"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrap(err, "unmarshal credential config file")
+	}
+	return NewCredentialManager(config.Credentials, config.DockerConfig)
+}
+
+// SetAudit writes the audit of each authentication to writer in JSON lines.
+func (manager *CredentialManager) SetAudit(writer io.Writer) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.audit = writer
+}
+
+func (manager *CredentialManager) record(entry CredentialAudit) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if manager.audit == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		_, err = manager.audit.Write(append(data, '\n'))
+	}
+	if err != nil {
+		logrus.WithError(err).Warn("failed to write credential audit")
+	}
+}
+
+// normalizeHost maps the hosts of docker hub to docker.io used in rules.
+func normalizeHost(host string) string {
+	switch host {
+	case "registry-1.docker.io", "index.docker.io":
+		return "docker.io"
+	}
+	return host
+}
+
+// CredFunc returns the credential function for the operation on ref, the
+// credential is looked up by the host being authenticated to, and cred is
+// used instead of the rules if not nil.
+func (manager *CredentialManager) CredFunc(ref, operation string, cred *Credential) remote.CredentialFunc {
+	return func(host string) (string, string, error) {
+		entry := CredentialAudit{
+			Time:       time.Now().UTC(),
+			Ref:        ref,
+			Host:       host,
+			Operation:  operation,
+			Credential: auditAnonymous,
+		}
+		username, password, err := manager.lookup(host, operation, cred, &entry.Credential)
+		if err == nil {
+			manager.record(entry)
+		}
+		return username, password, err
+	}
+}
+
+func (manager *CredentialManager) lookup(host, operation string, cred *Credential, name *string) (string, string, error) {
+	if cred != nil {
+		*name = auditExplicit
+		return cred.Username, cred.Password, nil
+	}
+	for idx := range manager.rules {
+		rule := &manager.rules[idx]
+		if rule.match(normalizeHost(host), operation) {
+			*name = rule.Name
+			return rule.Username, rule.Password, nil
+		}
+	}
+	if manager.dockerConfig {
+		username, password, err := remote.NewDockerConfigCredFunc()(host)
+		if err == nil && (username != "" || password != "") {
+			*name = auditDockerConfig
+		}
+		return username, password, err
+	}
+	return "", "", nil
+}
+
+// operation returns the operation on ref in conversion, the repositories of
+// target and build cache are pushed, while the others are only pulled.
+func operation(opt Opt, ref string) string {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return OperationPull
+	}
+	for _, pushed := range []string{opt.Target, opt.CacheRef} {
+		if pushed == "" {
+			continue
+		}
+		if pushedNamed, err := docker.ParseDockerRef(pushed); err == nil && pushedNamed.Name() == named.Name() {
+			return OperationPush
+		}
+	}
+	return OperationPull
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialManager(t *testing.T) {
+	t.Setenv("PUSH_PASSWORD", "push-secret")
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"credentials": [
+			{"name": "pusher", "hosts": ["registry.example.com"], "operations": ["push"], "username": "bot", "password_env": "PUSH_PASSWORD"},
+			{"name": "puller", "hosts": ["*.example.com", "docker.io"], "operations": ["pull"], "username": "reader", "password": "pull-secret"}
+		]
+	}`), 0600))
+	manager, err := LoadCredentialManager(path)
+	require.NoError(t, err)
+	var audit bytes.Buffer
+	manager.SetAudit(&audit)
+
+	for _, tc := range []struct {
+		host      string
+		operation string
+		username  string
+		password  string
+	}{
+		{"registry.example.com", OperationPush, "bot", "push-secret"},
+		{"registry.example.com", OperationPull, "reader", "pull-secret"},
+		{"mirror.example.com", OperationPull, "reader", "pull-secret"},
+		{"registry-1.docker.io", OperationPull, "reader", "pull-secret"},
+		// The credentials are never sent to other hosts or for other
+		// operations.
+		{"mirror.example.com", OperationPush, "", ""},
+		{"example.com", OperationPull, "", ""},
+		{"registry.example.com.evil.io", OperationPull, "", ""},
+	} {
+		username, password, err := manager.CredFunc("ref", tc.operation, nil)(tc.host)
+		require.NoError(t, err)
+		require.Equal(t, tc.username, username, "%s %s", tc.operation, tc.host)
+		require.Equal(t, tc.password, password, "%s %s", tc.operation, tc.host)
+	}
+	username, _, err := manager.CredFunc("ref", OperationPull, &Credential{Username: "job"})("registry.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "job", username)
+
+	// The audit records the credential names but never the secrets.
+	require.NotContains(t, audit.String(), "secret")
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	names := []string{}
+	for _, line := range lines {
+		var entry CredentialAudit
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		require.Equal(t, "ref", entry.Ref)
+		require.False(t, entry.Time.IsZero())
+		names = append(names, entry.Credential)
+	}
+	require.Equal(t, []string{"pusher", "puller", "puller", "puller", "anonymous", "anonymous", "anonymous", "explicit"}, names)
+	require.Equal(t, redacted, fmt.Sprintf("%v", manager.rules[0]))
+	require.Equal(t, redacted, fmt.Sprintf("%#v", manager.rules[0]))
+
+	for _, tc := range []struct {
+		rules string
+		err   string
+	}{
+		{`[{"hosts": ["docker.io"]}]`, "name of credential #0 is required"},
+		{`[{"name": "a", "hosts": ["docker.io"]}, {"name": "a", "hosts": ["quay.io"]}]`, "duplicated or reserved credential name a"},
+		{`[{"name": "anonymous", "hosts": ["docker.io"]}]`, "duplicated or reserved credential name anonymous"},
+		{`[{"name": "a"}]`, "hosts of credential a are required"},
+		{`[{"name": "a", "hosts": ["docker.io"], "operations": ["delete"]}]`, `invalid operation "delete" of credential a`},
+		{`[{"name": "a", "hosts": ["docker.io"], "password_env": "NO_SUCH_PASSWORD"}]`, "environment variable NO_SUCH_PASSWORD of credential a is empty"},
+	} {
+		var rules []CredentialRule
+		require.NoError(t, json.Unmarshal([]byte(tc.rules), &rules))
+		_, err := NewCredentialManager(rules, false)
+		require.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestCredentialOperation(t *testing.T) {
+	opt := Opt{
+		Source:   "registry.example.com/app:v1",
+		Target:   "registry.example.com/app:v1-nydus",
+		CacheRef: "registry.example.com/cache:nydus",
+	}
+	require.Equal(t, OperationPush, operation(opt, opt.Target))
+	require.Equal(t, OperationPush, operation(opt, "registry.example.com/app@sha256:"+strings.Repeat("a", 64)))
+	require.Equal(t, OperationPush, operation(opt, opt.CacheRef))
+	require.Equal(t, OperationPull, operation(opt, "registry.example.com/base:v1"))
+	require.Equal(t, OperationPull, operation(Opt{Source: opt.Source, Target: "localhost:5000/app:v1"}, opt.Source))
+
+	var audit bytes.Buffer
+	opt.Credentials, _ = NewCredentialManager([]CredentialRule{{
+		Name:       "pusher",
+		Hosts:      []string{"registry.example.com"},
+		Operations: []string{OperationPush},
+		Credential: Credential{Username: "bot"},
+	}}, false)
+	opt.Credentials.SetAudit(&audit)
+	credFunc, _, err := hosts(opt)(opt.Target)
+	require.NoError(t, err)
+	username, _, err := credFunc("registry.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "bot", username)
+	require.Contains(t, audit.String(), `"operation":"push","credential":"pusher"`)
+}
@@ -0,0 +1,305 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/reference/docker"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyProvider "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const (
+	// DeadlineAbort aborts the conversion at the deadline, and rolls back
+	// the target images pushed to registry.
+	DeadlineAbort = "abort"
+	// DeadlinePartial converts the platforms separately, the platforms not
+	// converted by the deadline fail, and the ones converted in time are
+	// still pushed as partial result.
+	DeadlinePartial = "partial"
+)
+
+// deadlineRollbackTimeout bounds the rollback, which runs after the
+// deadline.
+const deadlineRollbackTimeout = 2 * time.Minute
+
+// ValidateDeadlineAction checks the action of conversion at the deadline.
+func ValidateDeadlineAction(action string) error {
+	if action != DeadlineAbort && action != DeadlinePartial {
+		return fmt.Errorf("invalid deadline action %s, possible values: %s, %s", action, DeadlineAbort, DeadlinePartial)
+	}
+	return nil
+}
+
+// checkDeadline validates the deadline options, the partial result is
+// produced by converting the platforms separately as keep-going mode.
+func checkDeadline(opt *Opt) error {
+	if opt.Deadline <= 0 {
+		return nil
+	}
+	if opt.DeadlineAction == "" {
+		opt.DeadlineAction = DeadlineAbort
+	}
+	if err := ValidateDeadlineAction(opt.DeadlineAction); err != nil {
+		return err
+	}
+	if opt.DeadlineAction == DeadlinePartial {
+		if opt.CacheRef != "" {
+			return fmt.Errorf("build cache can't be used with partial result at deadline")
+		}
+		opt.KeepGoing = true
+	}
+	return nil
+}
+
+// withDeadline returns the context canceled at the deadline of conversion
+// started at start, or ctx itself without deadline.
+func withDeadline(ctx context.Context, opt Opt, start time.Time) (context.Context, context.CancelFunc) {
+	if opt.Deadline <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, start.Add(opt.Deadline))
+}
+
+func deadlineExceeded(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// taggedManifest is the manifest tagged before conversion.
+type taggedManifest struct {
+	desc ocispec.Descriptor
+	data []byte
+}
+
+// pushedImage is the image pushed by conversion, existed is whether its
+// manifest was in repository before the push.
+type pushedImage struct {
+	ref     string
+	desc    ocispec.Descriptor
+	existed bool
+}
+
+// deadlineRollback rolls back the target images pushed by the conversion
+// aborted at the deadline: the tags are restored to the manifests tagged
+// before conversion, and the manifests not in repository before are
+// deleted. The blobs are left to the garbage collection of registry, and
+// the blobs in storage backend to `nydusify gc`.
+type deadlineRollback struct {
+	opt Opt
+	// previous are the manifests tagged before conversion by reference,
+	// nil for the reference not tagged.
+	previous map[string]*taggedManifest
+
+	mutex    sync.Mutex
+	existing map[string]bool
+	pushed   []pushedImage
+}
+
+// newDeadlineRollback records the manifests tagged by refs before
+// conversion, which are in the repository of target.
+func newDeadlineRollback(ctx context.Context, opt Opt, refs ...string) (*deadlineRollback, error) {
+	rollback := &deadlineRollback{
+		opt:      opt,
+		previous: map[string]*taggedManifest{},
+		existing: map[string]bool{},
+	}
+	for _, ref := range refs {
+		rmt, err := rollback.remote(ref)
+		if err != nil {
+			return nil, err
+		}
+		var desc *ocispec.Descriptor
+		err = retryWithHTTP(rmt, func() error {
+			desc, err = rmt.Resolve(ctx)
+			return err
+		})
+		if errdefs.IsNotFound(err) {
+			rollback.previous[ref] = nil
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve %s for rollback at deadline", ref)
+		}
+		reader, err := rmt.Pull(ctx, *desc, true)
+		if err != nil {
+			return nil, errors.Wrapf(err, "pull %s for rollback at deadline", ref)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s for rollback at deadline", ref)
+		}
+		rollback.previous[ref] = &taggedManifest{desc: *desc, data: data}
+	}
+	return rollback, nil
+}
+
+// remote creates the remote of ref in the repository of target, which is
+// accessed with the credential and options of target.
+func (rollback *deadlineRollback) remote(ref string) (*remote.Remote, error) {
+	credFunc, insecure, err := hosts(rollback.opt)(rollback.opt.Target)
+	if err != nil {
+		return nil, err
+	}
+	rmt, err := nydusifyProvider.DefaultRemoteWithCredFunc(ref, insecure, credFunc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create remote of %s", ref)
+	}
+	if rollback.opt.TargetPlainHTTP {
+		rmt.WithHTTP()
+	}
+	return rmt, nil
+}
+
+func retryWithHTTP(rmt *remote.Remote, fn func() error) error {
+	err := fn()
+	if err != nil && !rmt.IsWithHTTP() {
+		rmt.MaybeWithHTTP(err)
+		if rmt.IsWithHTTP() {
+			err = fn()
+		}
+	}
+	return err
+}
+
+// hook tracks the images pushed to refs, it must be the last push hook
+// registered before the images are pushed, to see the final manifests.
+func (rollback *deadlineRollback) hook(refs ...string) provider.PushHook {
+	tracked := map[string]bool{}
+	for _, ref := range refs {
+		tracked[ref] = true
+	}
+	return provider.PushHook{
+		BeforePush: func(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+			if tracked[ref] {
+				existed, err := rollback.exists(ctx, ref, desc)
+				if err != nil {
+					return nil, err
+				}
+				rollback.mutex.Lock()
+				rollback.existing[ref+"@"+desc.Digest.String()] = existed
+				rollback.mutex.Unlock()
+			}
+			return &desc, nil
+		},
+		AfterPush: func(ctx context.Context, desc ocispec.Descriptor, ref string) error {
+			if tracked[ref] {
+				rollback.mutex.Lock()
+				defer rollback.mutex.Unlock()
+				rollback.pushed = append(rollback.pushed, pushedImage{
+					ref:     ref,
+					desc:    desc,
+					existed: rollback.existing[ref+"@"+desc.Digest.String()],
+				})
+			}
+			return nil
+		},
+	}
+}
+
+// exists checks whether the manifest is in the repository of ref.
+func (rollback *deadlineRollback) exists(ctx context.Context, ref string, desc ocispec.Descriptor) (bool, error) {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return false, errors.Wrapf(err, "parse reference %s", ref)
+	}
+	rmt, err := rollback.remote(named.Name() + "@" + desc.Digest.String())
+	if err != nil {
+		return false, err
+	}
+	err = retryWithHTTP(rmt, func() error {
+		_, err := rmt.Resolve(ctx)
+		return err
+	})
+	if errdefs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "check %s@%s for rollback at deadline", ref, desc.Digest)
+	}
+	return true, nil
+}
+
+// run rolls back the pushed images in reverse order, and returns the
+// number of images rolled back.
+func (rollback *deadlineRollback) run(ctx context.Context) (int, error) {
+	rollback.mutex.Lock()
+	pushed := append([]pushedImage{}, rollback.pushed...)
+	rollback.mutex.Unlock()
+
+	failed := 0
+	for idx := len(pushed) - 1; idx >= 0; idx-- {
+		if err := rollback.rollbackImage(ctx, pushed[idx]); err != nil {
+			logrus.WithError(err).Errorf("failed to roll back %s@%s", pushed[idx].ref, pushed[idx].desc.Digest)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return len(pushed) - failed, fmt.Errorf("failed to roll back %d of %d pushed images", failed, len(pushed))
+	}
+	return len(pushed), nil
+}
+
+func (rollback *deadlineRollback) rollbackImage(ctx context.Context, image pushedImage) error {
+	previous := rollback.previous[image.ref]
+	if previous != nil && previous.desc.Digest == image.desc.Digest {
+		return nil
+	}
+	rmt, err := rollback.remote(image.ref)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		if err := retryWithHTTP(rmt, func() error {
+			return rmt.Push(ctx, previous.desc, false, bytes.NewReader(previous.data))
+		}); err != nil {
+			return errors.Wrapf(err, "restore %s to %s", image.ref, previous.desc.Digest)
+		}
+		logrus.Infof("restored %s to previous %s", image.ref, previous.desc.Digest)
+	}
+	if image.existed {
+		// The manifest may be referenced by other tags.
+		if previous == nil {
+			logrus.Warnf("keep %s@%s which was in repository before conversion", image.ref, image.desc.Digest)
+		}
+		return nil
+	}
+	if err := retryWithHTTP(rmt, func() error {
+		return rmt.DeleteManifest(ctx, image.desc.Digest)
+	}); err != nil {
+		return errors.Wrapf(err, "delete %s", image.desc.Digest)
+	}
+	logrus.Infof("deleted %s@%s pushed by conversion", image.ref, image.desc.Digest)
+	return nil
+}
+
+// abortAtDeadline rolls back the pushed images of the conversion failed by
+// err after the deadline. The error isn't wrapped, so that the exit code is
+// the one of deadline rather than the interrupted operation.
+func abortAtDeadline(ctx context.Context, rollback *deadlineRollback, opt Opt, err error) error {
+	if rollback == nil {
+		return utils.WithExitCode(fmt.Errorf("conversion exceeded deadline %s: %s", opt.Deadline, err), utils.ExitDeadline)
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deadlineRollbackTimeout)
+	defer cancel()
+	rolledBack, rollbackErr := rollback.run(ctx)
+	if rollbackErr != nil {
+		return utils.WithExitCode(fmt.Errorf("conversion exceeded deadline %s, %s: %s", opt.Deadline, rollbackErr, err), utils.ExitDeadline)
+	}
+	return utils.WithExitCode(fmt.Errorf("conversion exceeded deadline %s, rolled back %d pushed images: %s", opt.Deadline, rolledBack, err), utils.ExitDeadline)
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestCheckDeadline(t *testing.T) {
+	opt := Opt{}
+	require.NoError(t, checkDeadline(&opt))
+	require.Equal(t, "", opt.DeadlineAction)
+
+	opt = Opt{Deadline: time.Hour}
+	require.NoError(t, checkDeadline(&opt))
+	require.Equal(t, DeadlineAbort, opt.DeadlineAction)
+	require.False(t, opt.KeepGoing)
+
+	// The partial result is produced by converting platforms separately.
+	opt = Opt{Deadline: time.Hour, DeadlineAction: DeadlinePartial}
+	require.NoError(t, checkDeadline(&opt))
+	require.True(t, opt.KeepGoing)
+
+	opt = Opt{Deadline: time.Hour, DeadlineAction: DeadlinePartial, CacheRef: "localhost:5000/app:cache"}
+	require.ErrorContains(t, checkDeadline(&opt), "build cache can't be used with partial result")
+	require.ErrorContains(t, ValidateDeadlineAction("retry"), "invalid deadline action retry, possible values: abort, partial")
+
+	ctx, cancel := withDeadline(context.Background(), Opt{Deadline: time.Millisecond}, time.Now().Add(-time.Second))
+	defer cancel()
+	require.True(t, deadlineExceeded(ctx))
+	ctx, cancel = withDeadline(context.Background(), Opt{}, time.Now().Add(-time.Second))
+	defer cancel()
+	require.False(t, deadlineExceeded(ctx))
+}
+
+func TestDeadlineRollback(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	ctx := context.Background()
+
+	putManifest := func(tag string, name string) ocispec.Descriptor {
+		desc, err := registry.PutManifest("app", tag, ocispec.MediaTypeImageManifest, namedManifest(name))
+		require.NoError(t, err)
+		return desc
+	}
+	previous := putManifest("v1-nydus", "previous")
+	previousData, _, ok := registry.Manifest("app", "v1-nydus")
+	require.True(t, ok)
+
+	target := registry.Host() + "/app:v1-nydus"
+	other := registry.Host() + "/app:v1-nydus-other"
+	opt := Opt{Target: target, TargetPlainHTTP: true, Deadline: time.Hour}
+	rollback, err := newDeadlineRollback(ctx, opt, target, other)
+	require.NoError(t, err)
+	require.Equal(t, previous.Digest, rollback.previous[target].desc.Digest)
+	require.Nil(t, rollback.previous[other])
+
+	hook := rollback.hook(target, other)
+	push := func(ref, tag string, name string) ocispec.Descriptor {
+		newDesc, err := hook.BeforePush(ctx, manifestDesc(t, name), ref)
+		require.NoError(t, err)
+		require.Equal(t, *newDesc, putManifest(tag, name))
+		require.NoError(t, hook.AfterPush(ctx, *newDesc, ref))
+		return *newDesc
+	}
+	converted := push(target, "v1-nydus", "converted")
+	otherConverted := push(other, "v1-nydus-other", "other")
+	// The manifest in repository before conversion is never deleted.
+	push(other, "v1-nydus-other", "previous")
+	// The images pushed to other references are not tracked.
+	require.NoError(t, hook.AfterPush(ctx, manifestDesc(t, "untracked"), registry.Host()+"/app:untracked"))
+
+	rolledBack, err := rollback.run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, rolledBack)
+	data, _, ok := registry.Manifest("app", "v1-nydus")
+	require.True(t, ok)
+	require.Equal(t, previousData, data)
+	_, _, ok = registry.Manifest("app", converted.Digest.String())
+	require.False(t, ok)
+	_, _, ok = registry.Manifest("app", otherConverted.Digest.String())
+	require.False(t, ok)
+	_, _, ok = registry.Manifest("app", previous.Digest.String())
+	require.True(t, ok)
+
+	// The interrupted operation doesn't decide the exit code.
+	err = abortAtDeadline(ctx, &deadlineRollback{opt: opt}, opt, utils.WithExitCode(fmt.Errorf("push image: context deadline exceeded"), utils.ExitPush))
+	require.Equal(t, utils.ExitDeadline, utils.ExitCode(err))
+	require.ErrorContains(t, err, "conversion exceeded deadline 1h0m0s, rolled back 0 pushed images: push image")
+	err = abortAtDeadline(ctx, nil, opt, utils.WithExitCode(fmt.Errorf("push image"), utils.ExitPush))
+	require.Equal(t, utils.ExitDeadline, utils.ExitCode(err))
+}
+
+func namedManifest(name string) ocispec.Manifest {
+	return ocispec.Manifest{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Annotations: map[string]string{"name": name},
+	}
+}
+
+// manifestDesc returns the descriptor of named manifest as registry stores,
+// without storing it.
+func manifestDesc(t *testing.T, name string) ocispec.Descriptor {
+	data, err := json.Marshal(namedManifest(name))
+	require.NoError(t, err)
+	return ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(data), Size: int64(len(data))}
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/dedup"
+)
+
+// ParseSharedChunkDictArgs parses the chunk dict args shared by dedup
+// database, only the chunk dict image in registry can be shared.
+func ParseSharedChunkDictArgs(args string) (string, error) {
+	_, source, ref, err := ParseChunkDictArgs(args)
+	if err != nil {
+		return "", err
+	}
+	if source != "registry" {
+		return "", fmt.Errorf("chunk dict source %s can't be shared, should be registry", source)
+	}
+	return ref, nil
+}
+
+// resolveSharedChunkDict looks up the chunk dict image of the dedup scope
+// from dedup database if it's not specified, so that the converters sharing
+// the database deduplicate chunks against the same chunk dict image.
+func resolveSharedChunkDict(ctx context.Context, opt *Opt) error {
+	if opt.DedupDB == "" || opt.ChunkDictRef != "" {
+		return nil
+	}
+	store, err := dedup.NewStore(opt.DedupDB)
+	if err != nil {
+		return err
+	}
+	args, err := dedup.GetChunkDict(ctx, store, opt.DedupScope)
+	if err != nil {
+		return errors.Wrap(err, "query dedup database")
+	}
+	if args == "" {
+		logrus.Infof("no chunk dict published to dedup scope %q, convert without chunk dict", opt.DedupScope)
+		return nil
+	}
+	ref, err := ParseSharedChunkDictArgs(args)
+	if err != nil {
+		return errors.Wrapf(err, "invalid chunk dict %s in dedup database", args)
+	}
+	logrus.Infof("use chunk dict %s of dedup scope %q", ref, opt.DedupScope)
+	opt.ChunkDictRef = ref
+	return nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSharedChunkDict(t *testing.T) {
+	chunkDicts := map[string]string{
+		"/nydusify/chunk-dict/default": "bootstrap:registry:localhost:5000/dict:v1",
+		"/nydusify/chunk-dict/local":   "bootstrap:local:/tmp/dict.boot",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunkDict, ok := chunkDicts[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, chunkDict)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	opt := Opt{DedupDB: server.URL}
+	require.NoError(t, resolveSharedChunkDict(ctx, &opt))
+	require.Equal(t, "localhost:5000/dict:v1", opt.ChunkDictRef)
+
+	// The specified chunk dict takes precedence.
+	opt = Opt{DedupDB: server.URL, ChunkDictRef: "localhost:5000/dict:v2"}
+	require.NoError(t, resolveSharedChunkDict(ctx, &opt))
+	require.Equal(t, "localhost:5000/dict:v2", opt.ChunkDictRef)
+
+	opt = Opt{DedupDB: server.URL, DedupScope: "missing"}
+	require.NoError(t, resolveSharedChunkDict(ctx, &opt))
+	require.Empty(t, opt.ChunkDictRef)
+
+	opt = Opt{DedupDB: server.URL, DedupScope: "local"}
+	require.Error(t, resolveSharedChunkDict(ctx, &opt))
+}
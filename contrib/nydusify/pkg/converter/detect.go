@@ -0,0 +1,35 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/build"
+)
+
+// checkBuilder detects the version of nydus-image and checks the build
+// options against its features, the unsupported optimizations are dropped
+// from opt. The detected version is returned, empty if nydus-image can't
+// be detected, then the options are used as is.
+func checkBuilder(opt *Opt) (string, error) {
+	info, err := build.NewBuilder(opt.NydusImagePath).Detect()
+	if err != nil {
+		logrus.WithError(err).Warn("failed to detect nydus-image, skip checking options")
+		return "", nil
+	}
+	for _, fsVersion := range []string{opt.FsVersion, opt.CompatFsVersion} {
+		option := build.BuilderOption{
+			FsVersion:    fsVersion,
+			ChunkDict:    opt.ChunkDictRef,
+			AlignedChunk: opt.FsAlignChunk,
+		}
+		if err := info.CheckOption(&option); err != nil {
+			return "", err
+		}
+		opt.FsAlignChunk = option.AlignedChunk
+	}
+	return info.Version, nil
+}
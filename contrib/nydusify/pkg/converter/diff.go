@@ -0,0 +1,236 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	nydusConverter "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// previousLayer is the Nydus blob layer of previous target image, which is
+// converted from a source layer.
+type previousLayer struct {
+	desc    ocispec.Descriptor
+	fetcher remotes.Fetcher
+}
+
+// diffStore reuses the layers of previous target image for the unchanged
+// source layers, in the same way as localCacheStore: the Nydus blob layer
+// is imported into content store and the source layer is labeled with its
+// digest once the source layer info is queried, so that nydus-snapshotter
+// skips building it.
+type diffStore struct {
+	content.Store
+	layers map[digest.Digest]previousLayer
+
+	mutex  sync.Mutex
+	reused map[digest.Digest]bool
+}
+
+func (s *diffStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	info, err := s.Store.Info(ctx, dgst)
+	if err != nil || info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest] != "" {
+		return info, err
+	}
+	layer, ok := s.layers[dgst]
+	if !ok {
+		return info, nil
+	}
+	if err := s.importLayer(ctx, layer); err != nil {
+		logrus.WithError(err).Warnf("failed to import layer %s of previous target", layer.desc.Digest)
+		return info, nil
+	}
+	s.mutex.Lock()
+	if !s.reused[dgst] {
+		s.reused[dgst] = true
+		logrus.Infof("reuse layer %s of previous target for layer %s", layer.desc.Digest, dgst)
+	}
+	s.mutex.Unlock()
+
+	labels := map[string]string{}
+	for key, value := range info.Labels {
+		labels[key] = value
+	}
+	labels[nydusConverter.LayerAnnotationNydusTargetDigest] = layer.desc.Digest.String()
+	info.Labels = labels
+	return info, nil
+}
+
+func (s *diffStore) importLayer(ctx context.Context, layer previousLayer) error {
+	if _, err := s.Store.Info(ctx, layer.desc.Digest); err == nil {
+		return nil
+	}
+	reader, err := layer.fetcher.Fetch(ctx, layer.desc)
+	if err != nil {
+		return errors.Wrap(err, "fetch layer")
+	}
+	defer reader.Close()
+	desc := ocispec.Descriptor{Digest: layer.desc.Digest, Size: layer.desc.Size}
+	if err := content.WriteBlob(ctx, s.Store, "previous-target-"+layer.desc.Digest.String(), reader, desc); err != nil {
+		return errors.Wrap(err, "write layer to content store")
+	}
+	return nil
+}
+
+// previousLayers maps the source layers to the Nydus blob layers of the
+// Nydus manifest converted from them. The mapping is read from the
+// `nydus-ref` annotation of layers for the OCI ref image, or from the
+// `nydus-source-digest` annotation of layers if any, otherwise the blob
+// layers are matched with the layers of source manifest in order, which
+// is done only if the counts are equal, for example no chunk dict blob is
+// referenced.
+func previousLayers(manifest, sourceManifest ocispec.Manifest, ociRef bool) (map[digest.Digest]ocispec.Descriptor, error) {
+	blobs := []ocispec.Descriptor{}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != nydusifyUtils.MediaTypeNydusBlob {
+			continue
+		}
+		if _, ok := layer.Annotations[nydusifyUtils.LayerAnnotationNydusRefLayer]; ok != ociRef {
+			return nil, fmt.Errorf("oci ref of layer %s mismatches", layer.Digest)
+		}
+		blobs = append(blobs, layer)
+	}
+
+	layers := map[digest.Digest]ocispec.Descriptor{}
+	if ociRef {
+		for _, blob := range blobs {
+			source := digest.Digest(blob.Annotations[nydusifyUtils.LayerAnnotationNydusRefLayer])
+			if source.Validate() == nil {
+				layers[source] = blob
+			}
+		}
+		return layers, nil
+	}
+	for target, source := range sourceLayers(manifest) {
+		for _, blob := range blobs {
+			if blob.Digest == target {
+				layers[source] = blob
+			}
+		}
+	}
+	if len(layers) > 0 {
+		return layers, nil
+	}
+	if len(blobs) != len(sourceManifest.Layers) {
+		return nil, fmt.Errorf("%d blob layers mismatch %d source layers", len(blobs), len(sourceManifest.Layers))
+	}
+	for idx, layer := range sourceManifest.Layers {
+		layers[layer.Digest] = blobs[idx]
+	}
+	return layers, nil
+}
+
+// diffPreviousTarget collects the layers of previous target image which
+// can be reused by the conversion, the Nydus manifests not converted with
+// identical options are skipped. The source manifest recorded in the Nydus
+// manifest is fetched from the repository of source image.
+func diffPreviousTarget(
+	ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, opt Opt, chunkDictDigest string,
+) (map[digest.Digest]previousLayer, error) {
+	fetcher, manifests, err := resolveManifests(ctx, pvd, platformMC, opt.PreviousTarget)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve previous target image")
+	}
+	if manifests == nil {
+		return nil, fmt.Errorf("previous target image %s not found", opt.PreviousTarget)
+	}
+
+	named, err := docker.ParseDockerRef(opt.Source)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse source reference")
+	}
+	resolver, err := pvd.Resolver(opt.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := convertedOptions(opt, chunkDictDigest)
+	layers := map[digest.Digest]previousLayer{}
+	for _, desc := range manifests {
+		var manifest ocispec.Manifest
+		if err := fetchJSON(ctx, fetcher, desc, &manifest); err != nil {
+			return nil, errors.Wrapf(err, "fetch manifest %s of previous target", desc.Digest)
+		}
+		sourceDigest := digest.Digest(manifest.Annotations[annotationSourceDigest])
+		if sourceDigest == "" {
+			// Skip the OCI manifest in merged index.
+			continue
+		}
+		if reason := mismatchedOption(manifest, desc, expected); reason != "" {
+			logrus.Infof("skip diffing previous target: %s", reason)
+			continue
+		}
+
+		name, sourceDesc, err := resolver.Resolve(ctx, named.Name()+"@"+sourceDigest.String())
+		if err != nil {
+			logrus.WithError(err).Warnf("skip diffing manifest %s of previous target, source manifest is unavailable", desc.Digest)
+			continue
+		}
+		sourceFetcher, err := resolver.Fetcher(ctx, name)
+		if err != nil {
+			return nil, errors.Wrap(err, "get source fetcher")
+		}
+		var sourceManifest ocispec.Manifest
+		if err := fetchJSON(ctx, sourceFetcher, sourceDesc, &sourceManifest); err != nil {
+			return nil, errors.Wrapf(err, "fetch source manifest %s", sourceDigest)
+		}
+		blobs, err := previousLayers(manifest, sourceManifest, opt.OCIRef)
+		if err != nil {
+			logrus.WithError(err).Warnf("skip diffing manifest %s of previous target", desc.Digest)
+			continue
+		}
+		for source, blob := range blobs {
+			layers[source] = previousLayer{desc: blob, fetcher: fetcher}
+		}
+	}
+	return layers, nil
+}
+
+// addDiffConversion reuses the layers of previous target image for the
+// source layers unchanged since previous conversion, which works without
+// local cache. It's skipped for the storage backend other than registry,
+// as the blobs aren't kept in the image, and for the compatible image built
+// with other options.
+func addDiffConversion(ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, opt Opt, chunkDictDigest string) error {
+	if opt.PreviousTarget == "" {
+		return nil
+	}
+	if opt.BackendType != "" && opt.BackendType != "registry" {
+		logrus.Warnf("diffing previous target is disabled for %s backend", opt.BackendType)
+		return nil
+	}
+	if opt.CompatFsVersion != "" {
+		logrus.Warn("diffing previous target is disabled with compatible fs version")
+		return nil
+	}
+	layers, err := diffPreviousTarget(ctx, pvd, platformMC, opt, chunkDictDigest)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("found %d reusable layers in previous target %s", len(layers), opt.PreviousTarget)
+	if len(layers) == 0 {
+		return nil
+	}
+	pvd.SetContentStore(&diffStore{
+		Store:  pvd.ContentStore(),
+		layers: layers,
+		reused: map[digest.Digest]bool{},
+	})
+	return nil
+}
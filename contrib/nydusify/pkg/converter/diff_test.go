@@ -0,0 +1,131 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	nydusConverter "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestDiffConversion(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	host := registry.Host()
+
+	putManifest := func(tag string, layers []ocispec.Descriptor, annotations map[string]string) ocispec.Descriptor {
+		desc, err := registry.PutManifest("app", tag, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned:   specs.Versioned{SchemaVersion: 2},
+			MediaType:   ocispec.MediaTypeImageManifest,
+			Config:      registry.PutBlob("app", ocispec.MediaTypeImageConfig, []byte(tag)),
+			Layers:      layers,
+			Annotations: annotations,
+		})
+		require.NoError(t, err)
+		return desc
+	}
+	base := registry.PutBlob("app", ocispec.MediaTypeImageLayerGzip, []byte("base layer"))
+	app1 := registry.PutBlob("app", ocispec.MediaTypeImageLayerGzip, []byte("app layer v1"))
+	app2 := registry.PutBlob("app", ocispec.MediaTypeImageLayerGzip, []byte("app layer v2"))
+	v1 := putManifest("v1", []ocispec.Descriptor{base, app1}, nil)
+	putManifest("v2", []ocispec.Descriptor{base, app2}, nil)
+
+	baseBlob := registry.PutBlob("app", nydusifyUtils.MediaTypeNydusBlob, []byte("base blob"))
+	app1Blob := registry.PutBlob("app", nydusifyUtils.MediaTypeNydusBlob, []byte("app blob v1"))
+	bootstrap := registry.PutBlob("app", ocispec.MediaTypeImageLayerGzip, []byte("bootstrap"))
+	putManifest("v1-nydus", []ocispec.Descriptor{baseBlob, app1Blob, bootstrap}, map[string]string{
+		annotationSourceDigest:                      v1.Digest.String(),
+		nydusifyUtils.LayerAnnotationNydusFsVersion: "6",
+		nydusifyUtils.ManifestNydusCompressor:       "zstd",
+		nydusifyUtils.ManifestNydusFsAlignChunk:     "false",
+	})
+
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	newStore := func(opt Opt) content.Store {
+		pvd, err := provider.New(t.TempDir(), func(string) (remote.CredentialFunc, bool, error) {
+			return func(string) (string, string, error) { return "", "", nil }, false, nil
+		}, 200, "v1", nil, 0)
+		require.NoError(t, err)
+		pvd.UsePlainHTTP()
+		require.NoError(t, addDiffConversion(ctx, pvd, platforms.All, opt, ""))
+		return pvd.ContentStore()
+	}
+	write := func(cs content.Store, data []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+		require.NoError(t, content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(data), desc))
+		return desc
+	}
+
+	// The unchanged base layer reuses the blob of previous target.
+	opt := Opt{
+		Source:         host + "/app:v2",
+		PreviousTarget: host + "/app:v1-nydus",
+		FsVersion:      "6",
+		Compressor:     "zstd",
+	}
+	cs := newStore(opt)
+	write(cs, []byte("base layer"))
+	write(cs, []byte("app layer v2"))
+	info, err := cs.Info(ctx, base.Digest)
+	require.NoError(t, err)
+	require.Equal(t, baseBlob.Digest.String(), info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest])
+	data, err := content.ReadBlob(ctx, cs, baseBlob)
+	require.NoError(t, err)
+	require.Equal(t, []byte("base blob"), data)
+	info, err = cs.Info(ctx, app2.Digest)
+	require.NoError(t, err)
+	require.Empty(t, info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest])
+
+	// Nothing is reused by the conversion with different options.
+	opt.FsVersion = "5"
+	cs = newStore(opt)
+	write(cs, []byte("base layer"))
+	info, err = cs.Info(ctx, base.Digest)
+	require.NoError(t, err)
+	require.Empty(t, info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest])
+
+	// The blob layers can't be matched with source layers by order.
+	layers, err := previousLayers(
+		ocispec.Manifest{Layers: []ocispec.Descriptor{baseBlob, bootstrap}},
+		ocispec.Manifest{Layers: []ocispec.Descriptor{base, app1}}, false,
+	)
+	require.ErrorContains(t, err, "1 blob layers mismatch 2 source layers")
+	require.Nil(t, layers)
+
+	// The OCI ref blob layers are matched by annotation.
+	refBlob := baseBlob
+	refBlob.Annotations = map[string]string{nydusifyUtils.LayerAnnotationNydusRefLayer: base.Digest.String()}
+	layers, err = previousLayers(ocispec.Manifest{Layers: []ocispec.Descriptor{refBlob, bootstrap}}, ocispec.Manifest{}, true)
+	require.NoError(t, err)
+	require.Equal(t, map[digest.Digest]ocispec.Descriptor{base.Digest: refBlob}, layers)
+	_, err = previousLayers(ocispec.Manifest{Layers: []ocispec.Descriptor{refBlob}}, ocispec.Manifest{}, false)
+	require.ErrorContains(t, err, "oci ref")
+
+	// The blob layers are matched by the annotation of source layer,
+	// regardless of the chunk dict blob.
+	sourceBlob := app1Blob
+	sourceBlob.Annotations = map[string]string{nydusifyUtils.LayerAnnotationNydusSourceDigest: app1.Digest.String()}
+	dictBlob := registry.PutBlob("dict", nydusifyUtils.MediaTypeNydusBlob, []byte("dict blob"))
+	layers, err = previousLayers(
+		ocispec.Manifest{Layers: []ocispec.Descriptor{dictBlob, sourceBlob, bootstrap}},
+		ocispec.Manifest{Layers: []ocispec.Descriptor{base, app1}}, false,
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[digest.Digest]ocispec.Descriptor{app1.Digest: sourceBlob}, layers)
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestConvertDigestAlgorithm(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+
+	var layer bytes.Buffer
+	gw := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "hello", Mode: 0644, Size: 5}))
+	_, err := tw.Write([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	layerDesc := registry.PutBlob("app", ocispec.MediaTypeImageLayerGzip, layer.Bytes())
+	config, err := json.Marshal(ocispec.Image{
+		Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromString("diff")}},
+	})
+	require.NoError(t, err)
+	configDesc := registry.PutBlob("app", ocispec.MediaTypeImageConfig, config)
+	source, err := registry.PutManifest("app", "v1", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	})
+	require.NoError(t, err)
+	// The registry digests the manifest pushed by tag by sha512 as well.
+	registry.SetDigestAlgorithm(digest.SHA512)
+
+	bootstrap := []byte("bootstrap")
+	blob := nydusTar(t, map[string][]byte{nydusifyUtils.EntryBootstrap: bootstrap})
+	stub, err := testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{
+		Blob:      blob,
+		Bootstrap: bootstrap,
+		Blobs:     []string{digest.FromBytes(blob).Encoded()},
+	})
+	require.NoError(t, err)
+
+	err = Convert(context.Background(), Opt{
+		WorkDir:         t.TempDir(),
+		NydusImagePath:  stub.Path,
+		Source:          registry.Host() + "/app:v1",
+		Target:          registry.Host() + "/app:v1-nydus",
+		SourcePlainHTTP: true,
+		TargetPlainHTTP: true,
+		FsVersion:       "6",
+		DigestAlgorithm: digest.SHA512,
+	})
+	require.NoError(t, err)
+
+	// The manifest, config and bootstrap layer are digested by sha512, while
+	// the nydus blob keeps sha256 as it's referenced by blob id.
+	data, _, ok := registry.Manifest("app", "v1-nydus")
+	require.True(t, ok)
+	target := digest.SHA512.FromBytes(data)
+	_, _, ok = registry.Manifest("app", target.String())
+	require.True(t, ok)
+	var manifest ocispec.Manifest
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	require.Equal(t, digest.SHA512, manifest.Config.Digest.Algorithm())
+	config, ok = registry.Blob("app", manifest.Config.Digest)
+	require.True(t, ok)
+	require.Equal(t, manifest.Config.Digest, digest.SHA512.FromBytes(config))
+	require.Len(t, manifest.Layers, 2)
+	require.Equal(t, digest.FromBytes(blob), manifest.Layers[0].Digest)
+	bootstrapLayer := manifest.Layers[1]
+	require.Equal(t, "true", bootstrapLayer.Annotations[nydusifyUtils.LayerAnnotationNydusBootstrap])
+	require.Equal(t, digest.SHA512, bootstrapLayer.Digest.Algorithm())
+	data, ok = registry.Blob("app", bootstrapLayer.Digest)
+	require.True(t, ok)
+	require.Equal(t, bootstrapLayer.Digest, digest.SHA512.FromBytes(data))
+
+	// The diff ID of bootstrap layer is its uncompressed digest.
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	uncompressed, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	var image ocispec.Image
+	require.NoError(t, json.Unmarshal(config, &image))
+	require.Equal(t, []digest.Digest{digest.FromBytes(blob), digest.SHA512.FromBytes(uncompressed)}, image.RootFS.DiffIDs)
+	require.NotEqual(t, source.Digest, target)
+}
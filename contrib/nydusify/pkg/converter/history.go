@@ -0,0 +1,188 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/dustin/go-humanize"
+	"github.com/goharbor/acceleration-service/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// HistoryEntry is the conversion summary persisted per target reference,
+// which is compared across rebuilds of the same image to spot regressions.
+type HistoryEntry struct {
+	Time       time.Time `json:"time"`
+	Source     string    `json:"source"`
+	Target     string    `json:"target"`
+	SourceSize int64     `json:"source_size"`
+	TargetSize int64     `json:"target_size"`
+	SizeRatio  float64   `json:"size_ratio"`
+	// NewBlobBytes is the size of blobs newly built by the conversion,
+	// and DedupRatio is the ratio of blob bytes reused from chunk dict
+	// and build cache.
+	NewBlobBytes int64   `json:"new_blob_bytes"`
+	DedupRatio   float64 `json:"dedup_ratio"`
+	// Duration is the elapsed seconds of the whole conversion.
+	Duration          float64 `json:"duration"`
+	SourcePullElapsed float64 `json:"source_pull_elapsed"`
+	ConversionElapsed float64 `json:"conversion_elapsed"`
+	TargetPushElapsed float64 `json:"target_push_elapsed"`
+}
+
+func newHistoryEntry(report Report, metric *converter.Metric, start time.Time) HistoryEntry {
+	entry := HistoryEntry{
+		Time:         start.UTC(),
+		Source:       report.Source,
+		Target:       report.Target,
+		SourceSize:   report.SourceSize,
+		TargetSize:   report.TargetSize,
+		SizeRatio:    report.SizeRatio,
+		NewBlobBytes: report.NewBlobBytes,
+		DedupRatio:   ratio(report.ChunkDictBytes+report.CacheBytes, report.ChunkDictBytes+report.CacheBytes+report.NewBlobBytes),
+		Duration:     time.Since(start).Seconds(),
+	}
+	if metric != nil {
+		entry.SourcePullElapsed = metric.SourcePullElapsed.Seconds()
+		entry.ConversionElapsed = metric.ConversionElapsed.Seconds()
+		entry.TargetPushElapsed = metric.TargetPushElapsed.Seconds()
+	}
+	return entry
+}
+
+// historyPath returns the history file of target reference, the reference
+// is normalized so that `repo:tag` and `docker.io/library/repo:tag` share
+// the same history.
+func historyPath(dir, target string) (string, error) {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse reference %s", target)
+	}
+	return filepath.Join(dir, digest.FromString(named.String()).Encoded()+".jsonl"), nil
+}
+
+// appendHistory appends the entry to the history of its target reference,
+// one JSON object per line.
+func appendHistory(dir string, entry HistoryEntry) error {
+	path, err := historyPath(dir, entry.Target)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "create history directory")
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "marshal history entry")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open history file")
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return errors.Wrapf(err, "write history to %s", path)
+	}
+	return nil
+}
+
+// LoadHistory loads the conversion history of target reference from dir,
+// in the order of conversion, nil is returned if there is no history.
+func LoadHistory(dir, target string) ([]HistoryEntry, error) {
+	path, err := historyPath(dir, target)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "open history file")
+	}
+	defer file.Close()
+
+	entries := []HistoryEntry{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal line %d of %s", line, path)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "read history file %s", path)
+	}
+	return entries, nil
+}
+
+func formatSizeChange(current, previous int64) string {
+	delta := current - previous
+	sign := "+"
+	if delta < 0 {
+		sign = "-"
+		delta = -delta
+	}
+	if previous == 0 {
+		return fmt.Sprintf("%s%s", sign, humanize.Bytes(uint64(delta)))
+	}
+	return fmt.Sprintf("%s%s (%s%.1f%%)", sign, humanize.Bytes(uint64(delta)), sign, float64(delta)/float64(previous)*100)
+}
+
+func formatDurationChange(current, previous float64) string {
+	delta := time.Duration((current - previous) * float64(time.Second)).Round(time.Second)
+	if delta < 0 {
+		return delta.String()
+	}
+	return "+" + delta.String()
+}
+
+// PrintHistory prints the latest `limit` conversions in history as table,
+// one row per conversion, the changes are compared with the previous
+// conversion. A zero limit prints all.
+func PrintHistory(w io.Writer, entries []HistoryEntry, limit int) error {
+	first := 0
+	if limit > 0 && len(entries) > limit {
+		first = len(entries) - limit
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tDURATION\tSOURCE SIZE\tTARGET SIZE\tRATIO\tDEDUP\tNEW BLOBS\tSIZE CHANGE\tDURATION CHANGE")
+	for idx := first; idx < len(entries); idx++ {
+		entry := entries[idx]
+		sizeChange, durationChange := "-", "-"
+		if idx > 0 {
+			previous := entries[idx-1]
+			sizeChange = formatSizeChange(entry.TargetSize, previous.TargetSize)
+			durationChange = formatDurationChange(entry.Duration, previous.Duration)
+		}
+		fmt.Fprintf(
+			tw, "%s\t%s\t%s\t%s\t%.2f\t%.1f%%\t%s\t%s\t%s\n",
+			entry.Time.Local().Format(time.RFC3339),
+			time.Duration(entry.Duration*float64(time.Second)).Round(time.Second),
+			humanize.Bytes(uint64(entry.SourceSize)),
+			humanize.Bytes(uint64(entry.TargetSize)),
+			entry.SizeRatio,
+			entry.DedupRatio*100,
+			humanize.Bytes(uint64(entry.NewBlobBytes)),
+			sizeChange,
+			durationChange,
+		)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goharbor/acceleration-service/pkg/converter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+
+	entries, err := LoadHistory(dir, "app:latest-nydus")
+	require.NoError(t, err)
+	require.Nil(t, entries)
+
+	start := time.Now().Add(-time.Minute)
+	entry := newHistoryEntry(Report{
+		Source:         "app:latest",
+		Target:         "app:latest-nydus",
+		SourceSize:     1000,
+		TargetSize:     800,
+		SizeRatio:      0.8,
+		ChunkDictBytes: 100,
+		CacheBytes:     200,
+		NewBlobBytes:   700,
+	}, &converter.Metric{ConversionElapsed: 30 * time.Second}, start)
+	require.Equal(t, 0.3, entry.DedupRatio)
+	require.Equal(t, float64(30), entry.ConversionElapsed)
+	require.GreaterOrEqual(t, entry.Duration, float64(60))
+	require.NoError(t, appendHistory(dir, entry))
+
+	second := entry
+	second.Target = "docker.io/library/app:latest-nydus"
+	second.TargetSize = 1000
+	second.Duration = entry.Duration + 30
+	require.NoError(t, appendHistory(dir, second))
+	require.NoError(t, appendHistory(dir, HistoryEntry{Target: "app:other-nydus"}))
+
+	// The normalized references share the same history.
+	entries, err = LoadHistory(dir, "docker.io/library/app:latest-nydus")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, int64(800), entries[0].TargetSize)
+	require.Equal(t, int64(1000), entries[1].TargetSize)
+
+	buf := bytes.Buffer{}
+	require.NoError(t, PrintHistory(&buf, entries, 0))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Contains(t, lines[1], "30.0%")
+	require.Equal(t, []string{"-", "-"}, strings.Fields(lines[1])[len(strings.Fields(lines[1]))-2:])
+	require.Contains(t, lines[2], "+200 B (+25.0%)")
+	require.Contains(t, lines[2], "+30s")
+
+	// The change is still compared with the hidden previous conversion.
+	buf.Reset()
+	require.NoError(t, PrintHistory(&buf, entries, 1))
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[1], "+200 B (+25.0%)")
+}
@@ -6,6 +6,8 @@ package converter
 
 import (
 	"github.com/goharbor/acceleration-service/pkg/remote"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
 )
 
 func hosts(opt Opt) remote.HostFunc {
@@ -15,7 +17,40 @@ func hosts(opt Opt) remote.HostFunc {
 		opt.ChunkDictRef: opt.ChunkDictInsecure,
 		opt.CacheRef:     opt.CacheInsecure,
 	}
+	credentials := map[string]*Credential{
+		opt.Source: opt.SourceCredential,
+		opt.Target: opt.TargetCredential,
+	}
 	return func(ref string) (remote.CredentialFunc, bool, error) {
+		if opt.Credentials != nil {
+			return opt.Credentials.CredFunc(ref, operation(opt, ref), credentials[ref]), maps[ref], nil
+		}
+		if cred := credentials[ref]; cred != nil {
+			return cred.credFunc(), maps[ref], nil
+		}
 		return remote.NewDockerConfigCredFunc(), maps[ref], nil
 	}
 }
+
+// setPlainHTTP makes the registries specified by plain HTTP options accessed
+// by plain HTTP, independent of the insecure options which skip verifying
+// certs for HTTPS.
+func setPlainHTTP(pvd *provider.Provider, opt Opt) error {
+	for _, endpoint := range []struct {
+		ref       string
+		plainHTTP bool
+	}{
+		{opt.Source, opt.SourcePlainHTTP},
+		{opt.Target, opt.TargetPlainHTTP},
+		{opt.ChunkDictRef, opt.ChunkDictPlainHTTP},
+		{opt.CacheRef, opt.CachePlainHTTP},
+	} {
+		if endpoint.ref == "" || !endpoint.plainHTTP {
+			continue
+		}
+		if err := pvd.UsePlainHTTPFor(endpoint.ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
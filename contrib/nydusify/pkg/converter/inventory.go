@@ -0,0 +1,209 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// InventoryArtifact is an artifact produced by conversion.
+type InventoryArtifact struct {
+	Digest digest.Digest `json:"digest"`
+	Size   int64         `json:"size"`
+	// Location is where the artifact is stored, in the form of
+	// `repo@digest` for registry, or the object URL for storage backend.
+	Location string `json:"location"`
+}
+
+// InventoryManifest lists the artifacts referenced by a Nydus manifest.
+type InventoryManifest struct {
+	Digest    digest.Digest       `json:"digest"`
+	Platform  string              `json:"platform,omitempty"`
+	Bootstrap *InventoryArtifact  `json:"bootstrap,omitempty"`
+	Blobs     []InventoryArtifact `json:"blobs"`
+}
+
+// InventoryImage is an image pushed by conversion.
+type InventoryImage struct {
+	Reference string              `json:"reference"`
+	Digest    digest.Digest       `json:"digest"`
+	Manifests []InventoryManifest `json:"manifests"`
+}
+
+// Inventory is the manifest of all artifacts produced by conversion, so
+// that downstream tooling can track storage ownership and implement
+// external GC.
+type Inventory struct {
+	Images []InventoryImage `json:"images"`
+}
+
+// inventoryRecorder collects the artifacts of images pushed to the
+// tracked references.
+type inventoryRecorder struct {
+	mutex     sync.Mutex
+	pvd       *provider.Provider
+	refs      map[string]bool
+	locate    func(blobID string) string
+	inventory Inventory
+}
+
+// newInventoryRecorder creates the recorder, locate returns the location
+// of blob stored in storage backend, or empty if it's stored in registry.
+func newInventoryRecorder(pvd *provider.Provider, locate func(blobID string) string) *inventoryRecorder {
+	return &inventoryRecorder{
+		pvd:    pvd,
+		refs:   map[string]bool{},
+		locate: locate,
+		inventory: Inventory{
+			Images: []InventoryImage{},
+		},
+	}
+}
+
+func (recorder *inventoryRecorder) track(ref string) error {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return errors.Wrap(err, "parse reference")
+	}
+	recorder.refs[named.String()] = true
+	return nil
+}
+
+func (recorder *inventoryRecorder) hook() provider.PushHook {
+	return provider.PushHook{
+		AfterPush: recorder.afterPush,
+	}
+}
+
+func (recorder *inventoryRecorder) afterPush(ctx context.Context, desc ocispec.Descriptor, ref string) error {
+	if !recorder.refs[ref] {
+		return nil
+	}
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return errors.Wrap(err, "parse reference")
+	}
+	repo := docker.TrimNamed(named).String()
+
+	image := InventoryImage{
+		Reference: ref,
+		Digest:    desc.Digest,
+		Manifests: []InventoryManifest{},
+	}
+	if err := recorder.walk(ctx, repo, desc, nil, &image); err != nil {
+		return errors.Wrapf(err, "record inventory of %s", ref)
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	// The push may be retried with plain HTTP.
+	for idx := range recorder.inventory.Images {
+		if recorder.inventory.Images[idx].Reference == ref {
+			recorder.inventory.Images[idx] = image
+			return nil
+		}
+	}
+	recorder.inventory.Images = append(recorder.inventory.Images, image)
+
+	return nil
+}
+
+func (recorder *inventoryRecorder) walk(ctx context.Context, repo string, desc ocispec.Descriptor, platform *ocispec.Platform, image *InventoryImage) error {
+	cs := recorder.pvd.ContentStore()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return errors.Wrap(err, "read index json")
+		}
+		for _, manifest := range index.Manifests {
+			if err := recorder.walk(ctx, repo, manifest, manifest.Platform, image); err != nil {
+				return err
+			}
+		}
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return errors.Wrap(err, "read manifest json")
+		}
+		bootstrapDesc := parser.FindNydusBootstrapDesc(&manifest)
+		bootstrapDigest := manifest.Annotations[nydusifyUtils.ManifestNydusBootstrap]
+		if bootstrapDesc == nil && bootstrapDigest == "" {
+			// Skip the OCI manifest in merged index.
+			return nil
+		}
+
+		item := InventoryManifest{
+			Digest: desc.Digest,
+			Blobs:  []InventoryArtifact{},
+		}
+		if platform != nil {
+			item.Platform = platforms.Format(*platform)
+		}
+		if bootstrapDesc != nil {
+			item.Bootstrap = &InventoryArtifact{
+				Digest:   bootstrapDesc.Digest,
+				Size:     bootstrapDesc.Size,
+				Location: fmt.Sprintf("%s@%s", repo, bootstrapDesc.Digest),
+			}
+		} else {
+			// The bootstrap is pushed as a separate artifact.
+			item.Bootstrap = &InventoryArtifact{
+				Digest:   digest.Digest(bootstrapDigest),
+				Location: fmt.Sprintf("%s@%s", repo, bootstrapDigest),
+			}
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != nydusifyUtils.MediaTypeNydusBlob {
+				continue
+			}
+			location := recorder.locate(layer.Digest.Encoded())
+			if location == "" {
+				location = fmt.Sprintf("%s@%s", repo, layer.Digest)
+			}
+			item.Blobs = append(item.Blobs, InventoryArtifact{
+				Digest:   layer.Digest,
+				Size:     layer.Size,
+				Location: location,
+			})
+		}
+		image.Manifests = append(image.Manifests, item)
+	}
+
+	return nil
+}
+
+// dump writes the inventory to file in JSON format.
+func (recorder *inventoryRecorder) dump(path string) error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	data, err := json.MarshalIndent(recorder.inventory, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal inventory")
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrapf(err, "write inventory to %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestInventoryRecorder(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	blobDigest := digest.FromString("blob")
+	bootstrapDigest := digest.FromString("bootstrap")
+	manifestDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{
+			{
+				MediaType: nydusifyUtils.MediaTypeNydusBlob,
+				Digest:    blobDigest,
+				Size:      100,
+			},
+			{
+				MediaType: ocispec.MediaTypeImageLayerGzip,
+				Digest:    bootstrapDigest,
+				Size:      10,
+				Annotations: map[string]string{
+					nydusifyUtils.LayerAnnotationNydusBootstrap: "true",
+				},
+			},
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	indexDesc, err := utils.WriteJSON(ctx, cs, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{
+			{
+				MediaType: manifestDesc.MediaType,
+				Digest:    manifestDesc.Digest,
+				Size:      manifestDesc.Size,
+				Platform:  &ocispec.Platform{OS: "linux", Architecture: "amd64"},
+			},
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}, "", nil)
+	require.NoError(t, err)
+
+	recorder := newInventoryRecorder(pvd, func(blobID string) string {
+		return "oss://bucket/" + blobID
+	})
+	require.NoError(t, recorder.track("nydus/test:latest"))
+
+	// Untracked references are ignored.
+	require.NoError(t, recorder.afterPush(ctx, *indexDesc, "docker.io/nydus/cache:latest"))
+	require.Empty(t, recorder.inventory.Images)
+
+	ref := "docker.io/nydus/test:latest"
+	require.NoError(t, recorder.afterPush(ctx, *indexDesc, ref))
+	// Retried push replaces the record.
+	require.NoError(t, recorder.afterPush(ctx, *indexDesc, ref))
+	require.Equal(t, Inventory{
+		Images: []InventoryImage{
+			{
+				Reference: ref,
+				Digest:    indexDesc.Digest,
+				Manifests: []InventoryManifest{
+					{
+						Digest:   manifestDesc.Digest,
+						Platform: "linux/amd64",
+						Bootstrap: &InventoryArtifact{
+							Digest:   bootstrapDigest,
+							Size:     10,
+							Location: "docker.io/nydus/test@" + bootstrapDigest.String(),
+						},
+						Blobs: []InventoryArtifact{
+							{
+								Digest:   blobDigest,
+								Size:     100,
+								Location: "oss://bucket/" + blobDigest.Encoded(),
+							},
+						},
+					},
+				},
+			},
+		},
+	}, recorder.inventory)
+
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	require.NoError(t, recorder.dump(path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var inventory Inventory
+	require.NoError(t, json.Unmarshal(data, &inventory))
+	require.Equal(t, recorder.inventory, inventory)
+}
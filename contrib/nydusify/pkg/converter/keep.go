@@ -0,0 +1,353 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/continuity/fs"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const (
+	// KeepCommand is the hidden nydusify subcommand running the builder
+	// and retaining its intermediate artifacts.
+	KeepCommand = "keep-exec"
+	// keepWrapperName is the name of builder wrapper script.
+	keepWrapperName = "nydus-image-keep"
+	// keepRecordFile records a builder invocation in its artifact
+	// directory, keepIndexFile lists all of them.
+	keepRecordFile = "record.json"
+	keepIndexFile  = "index.json"
+)
+
+const (
+	// ArtifactRootfs is the unpacked source layer built by `nydus-image
+	// create`.
+	ArtifactRootfs = "rootfs"
+	// ArtifactBootstrap is the per-layer bootstraps merged by `nydus-image
+	// merge`, and the bootstraps written by builder.
+	ArtifactBootstrap = "bootstrap"
+	// ArtifactBuilderOutput is the output JSON written by builder.
+	ArtifactBuilderOutput = "builder-output"
+)
+
+// builderBoolFlags are the flags of nydus-image used by converter without
+// a value, the other flags take the next argument as value.
+var builderBoolFlags = map[string]bool{
+	"--aligned-chunk":    true,
+	"--blob-inline-meta": true,
+	"--encrypt":          true,
+	"--inline-bootstrap": true,
+	"--oci-ref":          true,
+	"--repeatable":       true,
+}
+
+// keptArtifact is an artifact retained from a builder invocation.
+type keptArtifact struct {
+	Stage string `json:"stage"`
+	// Path is relative to the artifacts directory, Source is the path
+	// written by builder, which is removed by converter after build.
+	Path   string `json:"path"`
+	Source string `json:"source"`
+}
+
+// keptBuild records a builder invocation and the artifacts retained.
+type keptBuild struct {
+	Command   string         `json:"command"`
+	Args      []string       `json:"args"`
+	StartTime time.Time      `json:"start_time"`
+	Duration  string         `json:"duration"`
+	Error     string         `json:"error,omitempty"`
+	Artifacts []keptArtifact `json:"artifacts,omitempty"`
+}
+
+// keptIndex describes the kept work directory of a conversion.
+type keptIndex struct {
+	Source    string      `json:"source"`
+	Target    string      `json:"target"`
+	WorkDir   string      `json:"work_dir"`
+	UnpackDir string      `json:"unpack_dir"`
+	BlobDir   string      `json:"blob_dir"`
+	Error     string      `json:"error,omitempty"`
+	Builds    []keptBuild `json:"builds"`
+}
+
+// ValidateKeepArtifacts checks the stages of artifacts to keep.
+func ValidateKeepArtifacts(stages []string) error {
+	for _, stage := range stages {
+		switch stage {
+		case ArtifactRootfs, ArtifactBootstrap, ArtifactBuilderOutput:
+		default:
+			return fmt.Errorf("invalid artifact stage %s, possible values: %s, %s, %s", stage, ArtifactRootfs, ArtifactBootstrap, ArtifactBuilderOutput)
+		}
+	}
+	return nil
+}
+
+// wrapKeeper writes a wrapper script into wrapperDir, which runs the
+// builder by nydusify and retains the artifacts of stages into artifactDir,
+// the returned script path can be used in place of the builder path. The
+// artifacts and artifactDir are only retained under roots, which are the
+// directories writable by builder.
+func wrapKeeper(builderPath, wrapperDir, artifactDir string, roots, stages []string) (string, error) {
+	builder, err := exec.LookPath(builderPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "find builder %s", builderPath)
+	}
+	builder, err = filepath.Abs(builder)
+	if err != nil {
+		return "", errors.Wrap(err, "get absolute builder path")
+	}
+	artifactDir, err = filepath.Abs(artifactDir)
+	if err != nil {
+		return "", errors.Wrap(err, "get absolute artifacts directory")
+	}
+	if err := os.MkdirAll(artifactDir, 0755); err != nil {
+		return "", errors.Wrap(err, "create artifacts directory")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return "", errors.Wrap(err, "get nydusify executable")
+	}
+
+	options := "--dir " + utils.ShellQuote(artifactDir)
+	for _, root := range roots {
+		root, err = filepath.Abs(root)
+		if err != nil {
+			return "", errors.Wrap(err, "get absolute root directory")
+		}
+		options += " --root " + utils.ShellQuote(root)
+	}
+	for _, stage := range stages {
+		options += " --stage " + utils.ShellQuote(stage)
+	}
+	script := fmt.Sprintf(
+		"#!/bin/sh\nexec %s %s %s -- %s \"$@\"\n",
+		utils.ShellQuote(self), KeepCommand, options, utils.ShellQuote(builder),
+	)
+	wrapperPath := filepath.Join(wrapperDir, keepWrapperName)
+	if err := os.WriteFile(wrapperPath, []byte(script), 0755); err != nil {
+		return "", errors.Wrap(err, "write keep wrapper")
+	}
+
+	return wrapperPath, nil
+}
+
+// parseBuilderArgs returns the subcommand, flags and positional arguments
+// of nydus-image command line.
+func parseBuilderArgs(args []string) (string, map[string]string, []string) {
+	if len(args) == 0 {
+		return "", nil, nil
+	}
+	flags := map[string]string{}
+	positional := []string{}
+	for idx := 1; idx < len(args); idx++ {
+		arg := args[idx]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			flags[name] = value
+			continue
+		}
+		if builderBoolFlags[arg] || idx+1 >= len(args) {
+			flags[arg] = ""
+			continue
+		}
+		flags[arg] = args[idx+1]
+		idx++
+	}
+	return args[0], flags, positional
+}
+
+// resolveUnder resolves path under one of roots, it fails if path or any
+// of its parent directories under the root is a symlink, as they may be
+// planted by the sandboxed builder, while the roots and their parents are
+// not writable by it.
+func resolveUnder(roots []string, path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return "", err
+		}
+		if rel == "." {
+			return resolved, nil
+		}
+		for _, name := range strings.Split(rel, string(filepath.Separator)) {
+			resolved = filepath.Join(resolved, name)
+			info, err := os.Lstat(resolved)
+			if err != nil {
+				return "", err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return "", fmt.Errorf("%s is a symlink", resolved)
+			}
+		}
+		return resolved, nil
+	}
+	return "", fmt.Errorf("%s is out of %s", path, strings.Join(roots, ", "))
+}
+
+// KeepExec runs the builder command, and retains the artifacts of stages
+// into dir after it exits, whether it succeeds or not, the invocation is
+// recorded with the artifacts. The artifacts and dir are only retained
+// under roots without following symlinks.
+func KeepExec(roots []string, dir string, stages []string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing builder command to run")
+	}
+	keep := map[string]bool{}
+	for _, stage := range stages {
+		keep[stage] = true
+	}
+
+	build := keptBuild{Args: args[1:], StartTime: time.Now()}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	runErr := cmd.Run()
+	build.Duration = time.Since(build.StartTime).String()
+	if runErr != nil {
+		build.Error = runErr.Error()
+	}
+
+	command, flags, positional := parseBuilderArgs(args[1:])
+	build.Command = command
+	if command == "" {
+		command = "builder"
+	}
+	dir, err := resolveUnder(roots, dir)
+	if err != nil {
+		return errors.Wrap(err, "resolve artifacts directory")
+	}
+	buildDir, err := os.MkdirTemp(dir, command+"-")
+	if err != nil {
+		return errors.Wrap(err, "create artifact directory")
+	}
+
+	retain := func(stage, source, name string, move bool) {
+		if source == "" || !keep[stage] {
+			return
+		}
+		resolved, err := resolveUnder(roots, source)
+		if err != nil {
+			logrus.WithError(err).Warnf("failed to retain %s artifact %s", stage, source)
+			return
+		}
+		info, err := os.Lstat(resolved)
+		if err != nil {
+			logrus.WithError(err).Warnf("failed to retain %s artifact %s", stage, source)
+			return
+		}
+		target := filepath.Join(buildDir, name)
+		if info.IsDir() {
+			err = retainDir(resolved, target, move)
+		} else if info.Mode().IsRegular() {
+			err = fs.CopyFile(target, resolved)
+		} else {
+			// Skip the FIFOs of streamed blobs.
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Warnf("failed to retain %s artifact %s", stage, source)
+			return
+		}
+		rel, _ := filepath.Rel(dir, target)
+		build.Artifacts = append(build.Artifacts, keptArtifact{Stage: stage, Path: rel, Source: source})
+	}
+
+	switch command {
+	case "create":
+		// The source directory isn't used after build, it's moved instead
+		// of copied to avoid doubling the disk usage.
+		if len(positional) > 0 {
+			retain(ArtifactRootfs, positional[len(positional)-1], ArtifactRootfs, true)
+		}
+	case "merge":
+		for idx, bootstrap := range positional {
+			retain(ArtifactBootstrap, bootstrap, fmt.Sprintf("layer-%d-%s", idx, filepath.Base(bootstrap)), false)
+		}
+	}
+	retain(ArtifactBootstrap, flags["--bootstrap"], "bootstrap", false)
+	retain(ArtifactBuilderOutput, flags["--output-json"], "output.json", false)
+
+	data, err := json.MarshalIndent(build, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal build record")
+	}
+	if err := os.WriteFile(filepath.Join(buildDir, keepRecordFile), data, 0644); err != nil {
+		return errors.Wrap(err, "write build record")
+	}
+
+	return runErr
+}
+
+// retainDir moves or copies the directory, it's copied if moving across
+// filesystems.
+func retainDir(source, target string, move bool) error {
+	if move {
+		if err := os.Rename(source, target); err == nil {
+			return nil
+		}
+	}
+	if err := os.Mkdir(target, 0755); err != nil {
+		return err
+	}
+	return fs.CopyDir(target, source)
+}
+
+// writeKeptIndex writes the index of kept work directory, with the builder
+// invocations recorded in artifactDir.
+func writeKeptIndex(artifactDir string, index keptIndex) error {
+	if err := os.MkdirAll(artifactDir, 0755); err != nil {
+		return errors.Wrap(err, "create artifacts directory")
+	}
+	index.Builds = []keptBuild{}
+	records, err := filepath.Glob(filepath.Join(artifactDir, "*", keepRecordFile))
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		data, err := os.ReadFile(record)
+		if err != nil {
+			return errors.Wrap(err, "read build record")
+		}
+		var build keptBuild
+		if err := json.Unmarshal(data, &build); err != nil {
+			return errors.Wrapf(err, "unmarshal build record %s", record)
+		}
+		index.Builds = append(index.Builds, build)
+	}
+	sort.SliceStable(index.Builds, func(i, j int) bool {
+		return index.Builds[i].StartTime.Before(index.Builds[j].StartTime)
+	})
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal index")
+	}
+	return os.WriteFile(filepath.Join(artifactDir, keepIndexFile), data, 0644)
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBuilderArgs(t *testing.T) {
+	command, flags, positional := parseBuilderArgs([]string{
+		"merge", "--log-level", "warn", "--output-json", "/work/output.json", "--bootstrap", "/work/bootstrap",
+		"--chunk-dict", "bootstrap=/dict", "/work/layer1", "/work/layer2", "--blob-digests", "a,b", "--aligned-chunk",
+	})
+	require.Equal(t, "merge", command)
+	require.Equal(t, map[string]string{
+		"--log-level":     "warn",
+		"--output-json":   "/work/output.json",
+		"--bootstrap":     "/work/bootstrap",
+		"--chunk-dict":    "bootstrap=/dict",
+		"--blob-digests":  "a,b",
+		"--aligned-chunk": "",
+	}, flags)
+	require.Equal(t, []string{"/work/layer1", "/work/layer2"}, positional)
+
+	command, flags, positional = parseBuilderArgs([]string{"create", "--inline-bootstrap", "--fs-version=6", "/work/source"})
+	require.Equal(t, "create", command)
+	require.Equal(t, map[string]string{"--inline-bootstrap": "", "--fs-version": "6"}, flags)
+	require.Equal(t, []string{"/work/source"}, positional)
+
+	require.NoError(t, ValidateKeepArtifacts([]string{ArtifactRootfs, ArtifactBootstrap, ArtifactBuilderOutput}))
+	require.ErrorContains(t, ValidateKeepArtifacts([]string{"blob"}), "invalid artifact stage blob")
+}
+
+func TestKeepExec(t *testing.T) {
+	dir := t.TempDir()
+	work := t.TempDir()
+	artifactDir := filepath.Join(dir, "artifacts")
+	require.NoError(t, os.Mkdir(artifactDir, 0755))
+	roots := []string{dir, work}
+
+	// The fake builder writes bootstrap and output JSON.
+	builder := filepath.Join(dir, "nydus-image")
+	require.NoError(t, os.WriteFile(builder, []byte(`#!/bin/sh
+if [ "$1" = merge ]; then
+	echo bootstrap > `+work+`/bootstrap
+	echo '{"blobs": []}' > `+work+`/output.json
+	exit 0
+fi
+exit 3
+`), 0755))
+	source := filepath.Join(work, "source")
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "etc"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "etc", "hosts"), []byte("hosts"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(work, "layer1"), []byte("layer1"), 0644))
+
+	// The artifacts of failed build are retained too.
+	err := KeepExec(roots, artifactDir, []string{ArtifactRootfs}, []string{builder, "create", "--blob", filepath.Join(work, "blob"), source})
+	require.ErrorContains(t, err, "exit status 3")
+	_, err = os.Stat(source)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, KeepExec(roots, artifactDir, []string{ArtifactBootstrap, ArtifactBuilderOutput}, []string{
+		builder, "merge", "--output-json", filepath.Join(work, "output.json"),
+		"--bootstrap", filepath.Join(work, "bootstrap"), filepath.Join(work, "layer1"),
+	}))
+	// The builder outputs are copied as converter reads them after build.
+	require.FileExists(t, filepath.Join(work, "bootstrap"))
+
+	require.NoError(t, writeKeptIndex(artifactDir, keptIndex{Source: "source:latest", Target: "target:latest", WorkDir: dir}))
+	data, err := os.ReadFile(filepath.Join(artifactDir, keepIndexFile))
+	require.NoError(t, err)
+	var index keptIndex
+	require.NoError(t, json.Unmarshal(data, &index))
+	require.Equal(t, "target:latest", index.Target)
+	require.Len(t, index.Builds, 2)
+
+	create := index.Builds[0]
+	require.Equal(t, "create", create.Command)
+	require.Equal(t, "exit status 3", create.Error)
+	require.Len(t, create.Artifacts, 1)
+	require.Equal(t, ArtifactRootfs, create.Artifacts[0].Stage)
+	require.Equal(t, source, create.Artifacts[0].Source)
+	hosts, err := os.ReadFile(filepath.Join(artifactDir, create.Artifacts[0].Path, "etc", "hosts"))
+	require.NoError(t, err)
+	require.Equal(t, "hosts", string(hosts))
+
+	merge := index.Builds[1]
+	require.Equal(t, "merge", merge.Command)
+	require.Empty(t, merge.Error)
+	stages := map[string]string{}
+	for _, artifact := range merge.Artifacts {
+		data, err := os.ReadFile(filepath.Join(artifactDir, artifact.Path))
+		require.NoError(t, err)
+		stages[filepath.Base(artifact.Path)] = artifact.Stage + ":" + string(data)
+	}
+	require.Equal(t, map[string]string{
+		"layer-0-layer1": ArtifactBootstrap + ":layer1",
+		"bootstrap":      ArtifactBootstrap + ":bootstrap\n",
+		"output.json":    ArtifactBuilderOutput + ":{\"blobs\": []}\n",
+	}, stages)
+
+	// The symlinks planted by builder are not followed.
+	secret := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("secret"), 0600))
+	require.NoError(t, os.Symlink(secret, filepath.Join(work, "link")))
+	require.NoError(t, os.Symlink(filepath.Dir(secret), filepath.Join(work, "linkdir")))
+	linkDir := t.TempDir()
+	require.NoError(t, KeepExec([]string{linkDir, work}, linkDir, []string{ArtifactBootstrap}, []string{
+		builder, "merge", filepath.Join(work, "link"), filepath.Join(work, "linkdir", "secret"), secret,
+	}))
+	records, err := filepath.Glob(filepath.Join(linkDir, "*", keepRecordFile))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	data, err = os.ReadFile(records[0])
+	require.NoError(t, err)
+	var build keptBuild
+	require.NoError(t, json.Unmarshal(data, &build))
+	require.Empty(t, build.Artifacts)
+	require.Error(t, KeepExec([]string{work}, filepath.Join(work, "linkdir"), nil, []string{builder, "merge"}))
+}
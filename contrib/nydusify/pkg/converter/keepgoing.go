@@ -0,0 +1,172 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/converter"
+	"github.com/goharbor/acceleration-service/pkg/driver"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	accelUtils "github.com/goharbor/acceleration-service/pkg/utils"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func platformString(platform *ocispec.Platform) string {
+	if platform == nil {
+		return platforms.DefaultString()
+	}
+	return platforms.Format(*platform)
+}
+
+func withHTTPRetry(pvd *provider.Provider, fn func() error) error {
+	err := fn()
+	if err != nil && errdefs.NeedsRetryWithHTTP(err) {
+		pvd.UsePlainHTTP()
+		err = fn()
+	}
+	return err
+}
+
+// convertPlatforms converts the platforms of source image concurrently in
+// keep-going mode, the failed platforms are recorded and skipped rather
+// than aborting the conversion, the target index only references the
+// succeeded platforms in the order of source index. The returned BatchError records the failed platforms
+// even if the target image has been pushed.
+//
+// The build cache is not supported here, which is only accessible inside
+// the acceleration-service converter.
+//
+// The pull and conversion are bounded by deadline if it's not zero, the
+// platforms not converted by then fail, while the converted ones are still
+// pushed as partial result.
+func convertPlatforms(
+	ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, opt Opt, deadline time.Time,
+) (*converter.Metric, *utils.BatchError, error) {
+	var metric converter.Metric
+	cs := pvd.ContentStore()
+	convertCtx := ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		convertCtx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	logrus.Infof("pulling image %s", opt.Source)
+	start := time.Now()
+	var sourceImage *ocispec.Descriptor
+	if err := withHTTPRetry(pvd, func() error {
+		if err := pvd.Pull(convertCtx, opt.Source); err != nil {
+			return err
+		}
+		var err error
+		sourceImage, err = pvd.Image(convertCtx, opt.Source)
+		return err
+	}); err != nil {
+		return nil, nil, errors.Wrap(err, "pull image")
+	}
+	if err := accelUtils.UpdateLayerDiffID(convertCtx, cs, *sourceImage, platformMC); err != nil {
+		return nil, nil, errors.Wrap(err, "update layer diff id")
+	}
+	metric.SourcePullElapsed = time.Since(start)
+	logrus.Infof("pulled image %s, elapse %s", opt.Source, metric.SourcePullElapsed)
+
+	sourceDescs, err := accelUtils.GetManifests(ctx, cs, *sourceImage, platformMC)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get image manifests")
+	}
+
+	isIndex := sourceImage.MediaType == ocispec.MediaTypeImageIndex ||
+		sourceImage.MediaType == images.MediaTypeDockerSchema2ManifestList
+	if !isIndex {
+		// Nothing to keep going with for single manifest image.
+		sourceDescs = []ocispec.Descriptor{*sourceImage}
+	}
+
+	start = time.Now()
+	batchErr := utils.NewBatchError(len(sourceDescs))
+	// The converted manifests of each platform, which are collected in the
+	// order of source index.
+	converted := make([][]ocispec.Descriptor, len(sourceDescs))
+	var wg sync.WaitGroup
+	for idx, sourceDesc := range sourceDescs {
+		platform := platformString(sourceDesc.Platform)
+		matcher := platformMC
+		if isIndex && sourceDesc.Platform != nil {
+			matcher = platforms.OnlyStrict(*sourceDesc.Platform)
+		}
+		drv, err := driver.NewLocalDriver("nydus", getConfig(opt), matcher)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "create driver")
+		}
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			logrus.WithField("platform", platform).Infof("converting image %s", opt.Source)
+			desc, err := drv.Convert(convertCtx, pvd, opt.Source)
+			if err != nil {
+				batchErr.Add(platform, errors.Wrap(err, "convert image"))
+				return
+			}
+			if !isIndex {
+				converted[idx] = []ocispec.Descriptor{*desc}
+				return
+			}
+			// The converted image of single platform may be an index, which
+			// also contains the source manifest with `--merge-platform`.
+			manifests, err := accelUtils.GetManifests(ctx, cs, *desc, platforms.All)
+			if err != nil {
+				batchErr.Add(platform, errors.Wrap(err, "get converted manifests"))
+				return
+			}
+			converted[idx] = manifests
+		}(idx)
+	}
+	wg.Wait()
+	targetDescs := []ocispec.Descriptor{}
+	for _, manifests := range converted {
+		targetDescs = append(targetDescs, manifests...)
+	}
+	metric.ConversionElapsed = time.Since(start)
+	if batchErr.AllFailed() {
+		return nil, nil, batchErr
+	}
+
+	targetImage := &targetDescs[0]
+	if isIndex {
+		var index ocispec.Index
+		if _, err := accelUtils.ReadJSON(ctx, cs, &index, *sourceImage); err != nil {
+			return nil, nil, errors.Wrap(err, "read source manifest list")
+		}
+		index.Manifests = targetDescs
+		if targetImage, err = accelUtils.WriteJSON(ctx, cs, index, *sourceImage, opt.Target, nil); err != nil {
+			return nil, nil, errors.Wrap(err, "write target manifest list")
+		}
+	}
+
+	logrus.Infof("pushing image %s", opt.Target)
+	start = time.Now()
+	if err := withHTTPRetry(pvd, func() error {
+		return pvd.Push(ctx, *targetImage, opt.Target)
+	}); err != nil {
+		return nil, nil, errors.Wrap(err, "push image")
+	}
+	metric.TargetPushElapsed = time.Since(start)
+	logrus.Infof("pushed image %s, elapse %s", opt.Target, metric.TargetPushElapsed)
+
+	if batchErr.ErrorOrNil() == nil {
+		return &metric, nil, nil
+	}
+	return &metric, batchErr, nil
+}
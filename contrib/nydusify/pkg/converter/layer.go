@@ -0,0 +1,247 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/content/local"
+	nydusConverter "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// LayerOpt is the options of ConvertLayer, i.e. the layer-level subset of
+// Opt. The layers of one image must be converted with the same options to
+// be merged.
+type LayerOpt struct {
+	// WorkDir is the directory to build layer and save the results.
+	WorkDir        string
+	NydusImagePath string
+
+	FsVersion        string
+	FsAlignChunk     bool
+	Compressor       string
+	ChunkSize        string
+	BatchSize        string
+	PrefetchPatterns string
+	// ChunkDictPath is the bootstrap of chunk dict to deduplicate the
+	// chunks of layer against.
+	ChunkDictPath string
+	// OCIRef builds the blob referencing the chunks of source layer, which
+	// only holds the metadata of layer.
+	OCIRef bool
+	// Timeout fails the layer and kills nydus-image if the layer isn't
+	// built in time, 0 means no limit.
+	Timeout time.Duration
+}
+
+// LayerResult is the nydus layer converted by ConvertLayer. The result
+// files are left in work directory for the caller to upload and remove.
+type LayerResult struct {
+	// Blob is the nydus blob layer saved in BlobPath, which holds the blob
+	// data and the bootstrap of the layer, to be pushed as the layer of
+	// nydus image and merged into the bootstrap of image.
+	Blob     ocispec.Descriptor
+	BlobPath string
+	// Bootstrap is the bootstrap of the layer alone saved in
+	// BootstrapPath, e.g. to inspect the files of layer.
+	Bootstrap     ocispec.Descriptor
+	BootstrapPath string
+}
+
+// ConvertLayer converts exactly one source layer read from reader to nydus
+// blob layer, so that the conversion of one image can be sharded across
+// many workers by external schedulers, the converted layers are merged into
+// the bootstrap of image afterwards. The data read from reader is verified
+// against the digest of source.
+func ConvertLayer(ctx context.Context, source ocispec.Descriptor, reader io.Reader, opt LayerOpt) (result *LayerResult, retErr error) {
+	if opt.FsVersion == "" {
+		opt.FsVersion = "6"
+	}
+	if opt.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.Timeout)
+		defer cancel()
+		defer func() {
+			if retErr != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				retErr = nydusifyUtils.WithExitCode(errors.Wrapf(ctx.Err(), "build layer %s: timeout %s", source.Digest, opt.Timeout), nydusifyUtils.ExitBuilder)
+			}
+		}()
+	}
+	if err := source.Digest.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid source layer digest")
+	}
+	if err := os.MkdirAll(opt.WorkDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create work directory")
+	}
+	name := source.Digest.Encoded()
+	blobPath := filepath.Join(opt.WorkDir, name+".blob")
+	bootstrapPath := filepath.Join(opt.WorkDir, name+".boot")
+	defer func() {
+		if retErr != nil {
+			os.Remove(blobPath)
+			os.Remove(bootstrapPath)
+		}
+	}()
+
+	start := time.Now()
+	verifier := source.Digest.Verifier()
+	reader = io.TeeReader(reader, verifier)
+	// The referenced blob is built from the compressed layer.
+	tr := io.NopCloser(reader)
+	if !opt.OCIRef {
+		var err error
+		if tr, err = DecompressStream(reader); err != nil {
+			return nil, errors.Wrap(err, "decompress source layer")
+		}
+	}
+	defer tr.Close()
+
+	file, err := os.Create(blobPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "create blob file")
+	}
+	defer file.Close()
+	digester := digest.SHA256.Digester()
+	tw, err := nydusConverter.Pack(ctx, io.MultiWriter(file, digester.Hash()), nydusConverter.PackOption{
+		WorkDir:          opt.WorkDir,
+		BuilderPath:      opt.NydusImagePath,
+		FsVersion:        opt.FsVersion,
+		ChunkDictPath:    opt.ChunkDictPath,
+		PrefetchPatterns: opt.PrefetchPatterns,
+		Compressor:       opt.Compressor,
+		OCIRef:           opt.OCIRef,
+		AlignedChunk:     opt.FsAlignChunk,
+		ChunkSize:        opt.ChunkSize,
+		BatchSize:        opt.BatchSize,
+		Timeout:          builderTimeout(opt.Timeout),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "initialize pack to blob")
+	}
+	if _, err := io.Copy(tw, &contextReader{ctx: ctx, reader: tr}); err != nil {
+		tw.Close()
+		return nil, errors.Wrap(err, "pack source layer")
+	}
+	if err := tw.Close(); err != nil {
+		return nil, errors.Wrap(err, "pack source layer")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// Drain the trailing data not consumed by decompression to verify the
+	// whole layer.
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return nil, errors.Wrap(err, "read source layer")
+	}
+	if !verifier.Verified() {
+		return nil, errors.Errorf("source layer mismatches digest %s", source.Digest)
+	}
+	if err := file.Close(); err != nil {
+		return nil, errors.Wrap(err, "close blob file")
+	}
+
+	info, err := os.Stat(blobPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "stat blob file")
+	}
+	blobDigest := digester.Digest()
+	blob := ocispec.Descriptor{
+		Digest:    blobDigest,
+		Size:      info.Size(),
+		MediaType: nydusifyUtils.MediaTypeNydusBlob,
+		Annotations: map[string]string{
+			nydusifyUtils.LayerAnnotationUncompressed:      blobDigest.String(),
+			nydusifyUtils.LayerAnnotationNydusBlob:         "true",
+			nydusifyUtils.LayerAnnotationNydusSourceDigest: source.Digest.String(),
+		},
+	}
+	if opt.OCIRef {
+		blob.Annotations[nydusifyUtils.LayerAnnotationNydusRefLayer] = source.Digest.String()
+	}
+
+	bootstrap, err := unpackLayerBootstrap(blobPath, bootstrapPath)
+	if err != nil {
+		return nil, err
+	}
+	bootstrap.Annotations = map[string]string{
+		nydusifyUtils.LayerAnnotationNydusFsVersion: opt.FsVersion,
+	}
+
+	logrus.Infof("converted layer %s to blob %s, size: %d -> %d, elapsed: %s",
+		source.Digest, blobDigest, source.Size, blob.Size, time.Since(start))
+
+	return &LayerResult{
+		Blob:          blob,
+		BlobPath:      blobPath,
+		Bootstrap:     *bootstrap,
+		BootstrapPath: bootstrapPath,
+	}, nil
+}
+
+// contextReader stops reading once ctx is done, so that the source layer
+// isn't streamed into the builder after the layer build is aborted.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// builderTimeout is the timeout option of snapshotter converter, which
+// kills nydus-image if it doesn't exit in time, 0 means no limit.
+func builderTimeout(timeout time.Duration) *time.Duration {
+	if timeout <= 0 {
+		return nil
+	}
+	return &timeout
+}
+
+// unpackLayerBootstrap unpacks the bootstrap in nydus blob layer to
+// bootstrapPath.
+func unpackLayerBootstrap(blobPath, bootstrapPath string) (*ocispec.Descriptor, error) {
+	ra, err := local.OpenReader(blobPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open blob file")
+	}
+	defer ra.Close()
+
+	file, err := os.Create(bootstrapPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "create bootstrap file")
+	}
+	defer file.Close()
+	digester := digest.SHA256.Digester()
+	if err := nydusifyUtils.UnpackEntry(ra, nydusifyUtils.EntryBootstrap, io.MultiWriter(file, digester.Hash())); err != nil {
+		return nil, errors.Wrap(err, "unpack bootstrap from blob")
+	}
+	if err := file.Close(); err != nil {
+		return nil, errors.Wrap(err, "close bootstrap file")
+	}
+	info, err := os.Stat(bootstrapPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "stat bootstrap file")
+	}
+
+	return &ocispec.Descriptor{
+		Digest:    digester.Digest(),
+		Size:      info.Size(),
+		MediaType: nydusifyUtils.ArtifactTypeNydusBootstrap,
+	}, nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// nydusTar arranges the entries in nydus formatted tar stream, i.e. each
+// entry is the data followed by its tar header.
+func nydusTar(t *testing.T, entries map[string][]byte) []byte {
+	var stream bytes.Buffer
+	for name, data := range entries {
+		var header bytes.Buffer
+		tw := tar.NewWriter(&header)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0444, Size: int64(len(data))}))
+		stream.Write(data)
+		stream.Write(header.Bytes()[:512])
+	}
+	return stream.Bytes()
+}
+
+func TestConvertLayer(t *testing.T) {
+	bootstrap := []byte("layer bootstrap")
+	blob := nydusTar(t, map[string][]byte{nydusifyUtils.EntryBootstrap: bootstrap})
+	stub, err := testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{Blob: blob})
+	require.NoError(t, err)
+
+	var layer bytes.Buffer
+	gw := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "hello", Mode: 0644, Size: 5}))
+	_, err = tw.Write([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	source := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(layer.Bytes()),
+		Size:      int64(layer.Len()),
+	}
+
+	workDir := t.TempDir()
+	opt := LayerOpt{WorkDir: workDir, NydusImagePath: stub.Path, Compressor: "zstd"}
+	result, err := ConvertLayer(context.Background(), source, bytes.NewReader(layer.Bytes()), opt)
+	require.NoError(t, err)
+	require.Equal(t, ocispec.Descriptor{
+		MediaType: nydusifyUtils.MediaTypeNydusBlob,
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+		Annotations: map[string]string{
+			nydusifyUtils.LayerAnnotationUncompressed:      digest.FromBytes(blob).String(),
+			nydusifyUtils.LayerAnnotationNydusBlob:         "true",
+			nydusifyUtils.LayerAnnotationNydusSourceDigest: source.Digest.String(),
+		},
+	}, result.Blob)
+	require.Equal(t, ocispec.Descriptor{
+		MediaType:   nydusifyUtils.ArtifactTypeNydusBootstrap,
+		Digest:      digest.FromBytes(bootstrap),
+		Size:        int64(len(bootstrap)),
+		Annotations: map[string]string{nydusifyUtils.LayerAnnotationNydusFsVersion: "6"},
+	}, result.Bootstrap)
+	data, err := os.ReadFile(result.BlobPath)
+	require.NoError(t, err)
+	require.Equal(t, blob, data)
+	data, err = os.ReadFile(result.BootstrapPath)
+	require.NoError(t, err)
+	require.Equal(t, bootstrap, data)
+
+	calls, err := stub.Calls()
+	require.NoError(t, err)
+	create := calls[len(calls)-1]
+	require.Equal(t, "create", create[0])
+	require.Contains(t, create, "zstd")
+
+	// The corrupted source layer is refused, and no result is left.
+	require.NoError(t, os.RemoveAll(workDir))
+	source.Digest = digest.FromString("other")
+	_, err = ConvertLayer(context.Background(), source, bytes.NewReader(layer.Bytes()), opt)
+	require.ErrorContains(t, err, "mismatches digest")
+	entries, err := os.ReadDir(workDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	_, err = ConvertLayer(context.Background(), ocispec.Descriptor{}, bytes.NewReader(layer.Bytes()), opt)
+	require.Error(t, err)
+}
+
+// slowReader reads the data in one byte per interval.
+type slowReader struct {
+	reader   io.Reader
+	interval time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.interval)
+	return r.reader.Read(p[:1])
+}
+
+func TestConvertLayerTimeout(t *testing.T) {
+	var layer bytes.Buffer
+	gw := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "hello", Mode: 0644, Size: 5}))
+	_, err := tw.Write([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	source := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(layer.Bytes()),
+		Size:      int64(layer.Len()),
+	}
+	builderPath := filepath.Join(t.TempDir(), "nydus-image")
+	require.NoError(t, os.WriteFile(builderPath, []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+	opt := LayerOpt{WorkDir: t.TempDir(), NydusImagePath: builderPath, Timeout: 200 * time.Millisecond}
+
+	// The stuck builder is killed.
+	start := time.Now()
+	_, err = ConvertLayer(context.Background(), source, bytes.NewReader(layer.Bytes()), opt)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "timeout 200ms")
+	require.Equal(t, nydusifyUtils.ExitBuilder, nydusifyUtils.ExitCode(err))
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	// The source layer isn't streamed into builder after the timeout.
+	start = time.Now()
+	_, err = ConvertLayer(context.Background(), source, &slowReader{reader: bytes.NewReader(layer.Bytes()), interval: 50 * time.Millisecond}, opt)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,345 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// UnsafeLayerError is returned if a source layer contains the tar entry
+// which may escape the root filesystem or lies about its size.
+type UnsafeLayerError struct {
+	Layer  digest.Digest
+	Entry  string
+	Reason string
+}
+
+func (err *UnsafeLayerError) Error() string {
+	return fmt.Sprintf("security: refuse unsafe source layer %s, entry %q %s", err.Layer, err.Entry, err.Reason)
+}
+
+// escapesRoot checks if the path relative to the directory goes beyond
+// root by `..` components.
+func escapesRoot(dir, p string) bool {
+	depth := 0
+	if dir != "" && dir != "." {
+		depth = strings.Count(dir, "/") + 1
+	}
+	for _, component := range strings.Split(p, "/") {
+		switch component {
+		case "", ".":
+		case "..":
+			depth--
+			if depth < 0 {
+				return true
+			}
+		default:
+			depth++
+		}
+	}
+	return false
+}
+
+// maxSymlinkHops bounds the symlinks followed in resolving a path, as the
+// ELOOP limit of Linux.
+const maxSymlinkHops = 40
+
+// layerSymlinks maps the symlinks in the layers validated so far of an image
+// to their targets, by the cleaned paths relative to root.
+type layerSymlinks map[string]string
+
+func (symlinks layerSymlinks) clone() layerSymlinks {
+	cloned := make(layerSymlinks, len(symlinks))
+	for name, target := range symlinks {
+		cloned[name] = target
+	}
+	return cloned
+}
+
+// remove removes the symlink of name replaced or whited out, and the ones
+// under it if children is true.
+func (symlinks layerSymlinks) remove(name string, children bool) {
+	delete(symlinks, name)
+	if !children {
+		return
+	}
+	prefix := name + "/"
+	for link := range symlinks {
+		if name == "" || strings.HasPrefix(link, prefix) {
+			delete(symlinks, link)
+		}
+	}
+}
+
+// through returns the symlink in the parent directories of name, which the
+// entry of name is written through.
+func (symlinks layerSymlinks) through(name string) string {
+	dirs := []string{}
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		if _, ok := symlinks[dirs[idx]]; ok {
+			return dirs[idx]
+		}
+	}
+	return ""
+}
+
+// resolve follows the symlinks in the path p relative to the directory, the
+// absolute targets are resolved from root as in container, and returns false
+// if it goes beyond root by `..` components. The symlink loop fails to be
+// resolved by ELOOP rather than escaping root.
+func (symlinks layerSymlinks) resolve(dir, p string) bool {
+	current := []string{}
+	if dir != "" && dir != "." {
+		current = strings.Split(dir, "/")
+	}
+	if path.IsAbs(p) {
+		current = nil
+	}
+	pending := strings.Split(p, "/")
+	for hops := 0; len(pending) > 0; {
+		component := pending[0]
+		pending = pending[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if len(current) == 0 {
+				return false
+			}
+			current = current[:len(current)-1]
+			continue
+		}
+		target, ok := symlinks[path.Join(path.Join(current...), component)]
+		if !ok {
+			current = append(current, component)
+			continue
+		}
+		if hops++; hops > maxSymlinkHops {
+			return true
+		}
+		if path.IsAbs(target) {
+			current = nil
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return true
+}
+
+// validateLayerTar reads through the uncompressed tar stream of layer and
+// refuses the path traversal attempts, the symlinks escaping root, the
+// entries and hard links written through symlinks and the entries with data
+// smaller than declared size or larger than maxEntrySize (0 means no limit).
+// The symlinks of lower layers are passed in symlinks, which is updated with
+// the layer.
+func validateLayerTar(layer digest.Digest, reader io.Reader, maxEntrySize int64, symlinks layerSymlinks) error {
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "read tar of source layer %s", layer)
+		}
+		unsafe := func(reason string, args ...interface{}) error {
+			return &UnsafeLayerError{Layer: layer, Entry: hdr.Name, Reason: fmt.Sprintf(reason, args...)}
+		}
+
+		if escapesRoot("", hdr.Name) {
+			return unsafe("traverses out of root")
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		// The later entries under symlinks are written to wherever they
+		// point to.
+		if link := symlinks.through(name); link != "" {
+			return unsafe("is written through symlink %s", link)
+		}
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		if base == ".wh..wh..opq" {
+			symlinks.remove(dir, true)
+			continue
+		}
+		if strings.HasPrefix(base, ".wh.") {
+			symlinks.remove(path.Join(dir, strings.TrimPrefix(base, ".wh.")), true)
+			continue
+		}
+		symlinks.remove(name, hdr.Typeflag != tar.TypeDir)
+
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			if !symlinks.resolve(path.Dir(name), hdr.Linkname) {
+				return unsafe("links to %s out of root", hdr.Linkname)
+			}
+			symlinks[name] = hdr.Linkname
+		case tar.TypeLink:
+			if escapesRoot("", hdr.Linkname) {
+				return unsafe("hard links to %s out of root", hdr.Linkname)
+			}
+			target := strings.TrimPrefix(path.Clean("/"+hdr.Linkname), "/")
+			if link := symlinks.through(target); link != "" {
+				return unsafe("hard links to %s through symlink %s", hdr.Linkname, link)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if maxEntrySize > 0 && hdr.Size > maxEntrySize {
+				return unsafe("has size %d exceeding limit %d", hdr.Size, maxEntrySize)
+			}
+			size, err := io.Copy(io.Discard, tr)
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return errors.Wrapf(err, "read tar of source layer %s", layer)
+			}
+			if size != hdr.Size {
+				return unsafe("has %d bytes of data less than declared size %d", size, hdr.Size)
+			}
+		}
+	}
+
+	// The symlinks are resolved again with all symlinks of the layer, as
+	// they may point through the symlinks created after them.
+	names := make([]string, 0, len(symlinks))
+	for name := range symlinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !symlinks.resolve(path.Dir(name), symlinks[name]) {
+			return &UnsafeLayerError{Layer: layer, Entry: name, Reason: fmt.Sprintf("links to %s out of root", symlinks[name])}
+		}
+	}
+	return nil
+}
+
+// layerValidator validates the layers of source image after it's pulled,
+// before they're unpacked by builder.
+type layerValidator struct {
+	pvd          *provider.Provider
+	platformMC   platforms.MatchComparer
+	sources      map[string]bool
+	maxEntrySize int64
+
+	mutex sync.Mutex
+	// validated maps the chain IDs of layers validated to the symlinks in
+	// them, the layer is validated with the symlinks of its lower layers.
+	validated map[digest.Digest]layerSymlinks
+}
+
+func (validator *layerValidator) afterPull(ctx context.Context, desc ocispec.Descriptor, ref string) error {
+	if !validator.sources[ref] {
+		return nil
+	}
+	cs := validator.pvd.ContentStore()
+	children := images.FilterPlatforms(images.ChildrenHandler(cs), validator.platformMC)
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if images.IsManifestType(desc.MediaType) {
+			return nil, validator.validateManifest(ctx, cs, desc)
+		}
+		descs, err := children.Handle(ctx, desc)
+		if errdefs.IsNotFound(err) {
+			// Not pulled as the platform isn't selected.
+			return nil, nil
+		}
+		return descs, err
+	})
+	return images.Walk(ctx, handler, desc)
+}
+
+// validateManifest validates the layers of manifest from the lowest one.
+func (validator *layerValidator) validateManifest(ctx context.Context, cs content.Store, desc ocispec.Descriptor) error {
+	var manifest ocispec.Manifest
+	if err := readJSON(ctx, cs, desc, &manifest); err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "read manifest %s", desc.Digest)
+	}
+	symlinks := layerSymlinks{}
+	chainID := digest.Digest("")
+	for _, layer := range manifest.Layers {
+		// Skip the layers of Nydus image, they're not unpacked.
+		if layer.MediaType == utils.MediaTypeNydusBlob || layer.Annotations[utils.LayerAnnotationNydusBlob] != "" {
+			continue
+		}
+		if chainID == "" {
+			chainID = layer.Digest
+		} else {
+			chainID = digest.FromString(chainID.String() + " " + layer.Digest.String())
+		}
+		validator.mutex.Lock()
+		validated, ok := validator.validated[chainID]
+		validator.mutex.Unlock()
+		if ok {
+			symlinks = validated.clone()
+			continue
+		}
+		if err := validator.validate(ctx, cs, layer, symlinks); err != nil {
+			return err
+		}
+		validator.mutex.Lock()
+		validator.validated[chainID] = symlinks.clone()
+		validator.mutex.Unlock()
+	}
+	return nil
+}
+
+func (validator *layerValidator) validate(ctx context.Context, cs content.Store, desc ocispec.Descriptor, symlinks layerSymlinks) error {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return errors.Wrapf(err, "open source layer %s", desc.Digest)
+	}
+	defer ra.Close()
+	reader, err := DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return errors.Wrapf(err, "decompress source layer %s", desc.Digest)
+	}
+	defer reader.Close()
+	if err := validateLayerTar(desc.Digest, reader, validator.maxEntrySize, symlinks); err != nil {
+		return err
+	}
+	logrus.Debugf("validated source layer %s", desc.Digest)
+	return nil
+}
+
+func addLayerValidator(pvd *provider.Provider, platformMC platforms.MatchComparer, opt Opt) {
+	if !opt.ValidateSource {
+		return
+	}
+	// The source is pulled by the normalized reference, while the local
+	// source is imported by the original reference.
+	sources := map[string]bool{opt.Source: true}
+	if named, err := docker.ParseDockerRef(opt.Source); err == nil {
+		sources[named.String()] = true
+	}
+	validator := &layerValidator{
+		pvd:          pvd,
+		platformMC:   platformMC,
+		sources:      sources,
+		maxEntrySize: opt.ValidateMaxEntrySize,
+		validated:    make(map[digest.Digest]layerSymlinks),
+	}
+	pvd.AddPullHook(validator.afterPull)
+}
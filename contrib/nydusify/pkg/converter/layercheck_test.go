@@ -0,0 +1,182 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+func makeTar(t *testing.T, hdrs ...*tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range hdrs {
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Typeflag == tar.TypeReg {
+			_, err := tw.Write(bytes.Repeat([]byte("a"), int(hdr.Size)))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestValidateLayerTar(t *testing.T) {
+	layer := digest.FromString("layer")
+	safe := makeTar(t,
+		&tar.Header{Name: "usr/", Typeflag: tar.TypeDir},
+		&tar.Header{Name: "usr/bin/python3", Typeflag: tar.TypeReg, Size: 8},
+		&tar.Header{Name: "usr/bin/python", Typeflag: tar.TypeSymlink, Linkname: "/usr/bin/python3"},
+		&tar.Header{Name: "usr/lib64", Typeflag: tar.TypeSymlink, Linkname: "../lib"},
+		&tar.Header{Name: "usr/bin/py", Typeflag: tar.TypeLink, Linkname: "usr/bin/python3"},
+		// The symlink is replaced by directory.
+		&tar.Header{Name: "usr/lib64", Typeflag: tar.TypeDir},
+		&tar.Header{Name: "usr/lib64/libc.so", Typeflag: tar.TypeReg, Size: 4},
+	)
+	require.NoError(t, validateLayerTar(layer, bytes.NewReader(safe), 0, layerSymlinks{}))
+	require.NoError(t, validateLayerTar(layer, bytes.NewReader(safe), 8, layerSymlinks{}))
+	// The symlink loop doesn't escape root.
+	require.NoError(t, validateLayerTar(layer, bytes.NewReader(makeTar(t,
+		&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "b"},
+		&tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "/a"},
+	)), 0, layerSymlinks{}))
+
+	var unsafeErr *UnsafeLayerError
+	err := validateLayerTar(layer, bytes.NewReader(safe), 4, layerSymlinks{})
+	require.True(t, errors.As(err, &unsafeErr))
+	require.Equal(t, "usr/bin/python3", unsafeErr.Entry)
+
+	for _, hdrs := range [][]*tar.Header{
+		{{Name: "../etc/passwd", Typeflag: tar.TypeReg, Size: 1}},
+		{{Name: "usr/../../etc/passwd", Typeflag: tar.TypeReg, Size: 1}},
+		{{Name: "usr/lib", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}},
+		{{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../etc/passwd"}},
+		{
+			{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+			{Name: "etc/cron.d/job", Typeflag: tar.TypeReg, Size: 1},
+		},
+		// The absolute symlinks are resolved from root.
+		{{Name: "usr/lib", Typeflag: tar.TypeSymlink, Linkname: "/../etc"}},
+		{
+			{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/"},
+			{Name: "usr/lib", Typeflag: tar.TypeSymlink, Linkname: "../etc/.."},
+		},
+		// The symlink points through the symlink created after it.
+		{
+			{Name: "usr/lib", Typeflag: tar.TypeSymlink, Linkname: "../etc/.."},
+			{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/"},
+		},
+		{
+			{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/"},
+			{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "etc/passwd"},
+		},
+	} {
+		err := validateLayerTar(layer, bytes.NewReader(makeTar(t, hdrs...)), 0, layerSymlinks{})
+		require.True(t, errors.As(err, &unsafeErr), hdrs[len(hdrs)-1].Name)
+	}
+
+	// The symlinks of lower layers are tracked until they're replaced or
+	// whited out.
+	lower := makeTar(t, &tar.Header{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/"})
+	for _, c := range []struct {
+		hdrs []*tar.Header
+		safe bool
+	}{
+		{[]*tar.Header{{Name: "etc/passwd", Typeflag: tar.TypeReg, Size: 1}}, false},
+		{[]*tar.Header{{Name: "shadow", Typeflag: tar.TypeLink, Linkname: "etc/shadow"}}, false},
+		{[]*tar.Header{{Name: "usr/lib", Typeflag: tar.TypeSymlink, Linkname: "/etc/.."}}, false},
+		{[]*tar.Header{
+			{Name: ".wh.etc", Typeflag: tar.TypeReg},
+			{Name: "etc/passwd", Typeflag: tar.TypeReg, Size: 1},
+		}, true},
+		{[]*tar.Header{
+			{Name: "etc", Typeflag: tar.TypeDir},
+			{Name: "etc/passwd", Typeflag: tar.TypeReg, Size: 1},
+		}, true},
+		{[]*tar.Header{{Name: ".wh..wh..opq", Typeflag: tar.TypeReg}, {Name: "etc/passwd", Typeflag: tar.TypeReg, Size: 1}}, true},
+	} {
+		symlinks := layerSymlinks{}
+		require.NoError(t, validateLayerTar(layer, bytes.NewReader(lower), 0, symlinks))
+		err := validateLayerTar(digest.FromString("upper"), bytes.NewReader(makeTar(t, c.hdrs...)), 0, symlinks)
+		if c.safe {
+			require.NoError(t, err, c.hdrs[len(c.hdrs)-1].Name)
+		} else {
+			require.True(t, errors.As(err, &unsafeErr), c.hdrs[len(c.hdrs)-1].Name)
+		}
+	}
+
+	// The data is truncated.
+	truncated := makeTar(t, &tar.Header{Name: "file", Typeflag: tar.TypeReg, Size: 1024})
+	err = validateLayerTar(layer, bytes.NewReader(truncated[:1024]), 0, layerSymlinks{})
+	require.True(t, errors.As(err, &unsafeErr))
+}
+
+func TestLayerValidator(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	writeImage := func(layers ...[]byte) ocispec.Descriptor {
+		layerDescs := []ocispec.Descriptor{}
+		for _, layer := range layers {
+			var buf bytes.Buffer
+			gw := gzip.NewWriter(&buf)
+			_, err := gw.Write(layer)
+			require.NoError(t, err)
+			require.NoError(t, gw.Close())
+			layerDesc := ocispec.Descriptor{
+				MediaType: ocispec.MediaTypeImageLayerGzip,
+				Digest:    digest.FromBytes(buf.Bytes()),
+				Size:      int64(buf.Len()),
+			}
+			require.NoError(t, content.WriteBlob(ctx, cs, layerDesc.Digest.String(), bytes.NewReader(buf.Bytes()), layerDesc))
+			layerDescs = append(layerDescs, layerDesc)
+		}
+		desc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Layers:    layerDescs,
+		}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+		require.NoError(t, err)
+		return *desc
+	}
+
+	opt := Opt{Source: "docker.io/library/app:latest", ValidateSource: true}
+	addLayerValidator(pvd, platforms.All, opt)
+
+	pvd.AddImage(opt.Source, writeImage(makeTar(t, &tar.Header{Name: "bin/sh", Typeflag: tar.TypeReg, Size: 1})))
+	require.NoError(t, pvd.Pull(ctx, opt.Source))
+
+	unsafeDesc := writeImage(makeTar(t, &tar.Header{Name: "../../root/.ssh/authorized_keys", Typeflag: tar.TypeReg, Size: 1}))
+	pvd.AddImage("docker.io/library/cache:latest", unsafeDesc)
+	require.NoError(t, pvd.Pull(ctx, "docker.io/library/cache:latest"))
+	pvd.AddImage(opt.Source, unsafeDesc)
+	require.ErrorContains(t, pvd.Pull(ctx, opt.Source), "traverses out of root")
+
+	// The layer is validated with the symlinks of lower layers, even if it's
+	// validated in other image.
+	lower := makeTar(t, &tar.Header{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/"})
+	upper := makeTar(t, &tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg, Size: 1})
+	pvd.AddImage(opt.Source, writeImage(upper))
+	require.NoError(t, pvd.Pull(ctx, opt.Source))
+	pvd.AddImage(opt.Source, writeImage(lower, upper))
+	require.ErrorContains(t, pvd.Pull(ctx, opt.Source), "is written through symlink etc")
+}
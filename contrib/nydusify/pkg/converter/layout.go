@@ -0,0 +1,135 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// areaCheckInterval is the interval to check the size of work areas.
+var areaCheckInterval = time.Second
+
+// workArea is a directory holding one kind of temporary data, like the
+// unpacked layers or the staged blobs, with an optional size cap.
+type workArea struct {
+	name  string
+	dir   string
+	limit int64
+}
+
+// AreaLimitError means a work area exceeds its size cap.
+type AreaLimitError struct {
+	Area  string
+	Dir   string
+	Size  int64
+	Limit int64
+}
+
+func (err *AreaLimitError) Error() string {
+	return fmt.Sprintf(
+		"%s area %s uses %s, exceeds the size cap %s",
+		err.Area, err.Dir, humanize.IBytes(uint64(err.Size)), humanize.IBytes(uint64(err.Limit)),
+	)
+}
+
+// prepareArea creates a temp directory in base for this conversion, the
+// fallback directory is used if base is empty. The returned function
+// removes the created directory, unless it's kept.
+func prepareArea(base, fallback string, keep bool) (string, func(), error) {
+	if base == "" {
+		return fallback, func() {}, nil
+	}
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return "", nil, errors.Wrapf(err, "prepare directory %s", base)
+	}
+	dir, err := utils.MkdirTemp(base)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "create temp directory in %s", base)
+	}
+	if keep {
+		if err := utils.KeepTempDir(dir); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		return dir, func() {}, nil
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+// dirSize returns the total size of regular files in directory.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// The files may be removed during walking.
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// watchAreas checks the size of work areas periodically until ctx is done,
+// and cancels ctx with AreaLimitError if any area exceeds its size cap.
+func watchAreas(ctx context.Context, cancel context.CancelCauseFunc, areas []workArea) {
+	ticker := time.NewTicker(areaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, area := range areas {
+			if area.limit <= 0 {
+				continue
+			}
+			size, err := dirSize(area.dir)
+			if err != nil {
+				logrus.WithError(err).Warnf("failed to check size of %s area", area.name)
+				continue
+			}
+			if size > area.limit {
+				cancel(&AreaLimitError{Area: area.name, Dir: area.dir, Size: size, Limit: area.limit})
+				return
+			}
+		}
+	}
+}
+
+// areaError returns the AreaLimitError if ctx is canceled by watchAreas,
+// which is the root cause of err, otherwise returns err.
+func areaError(ctx context.Context, err error) error {
+	var limitErr *AreaLimitError
+	if errors.As(context.Cause(ctx), &limitErr) {
+		return limitErr
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareArea(t *testing.T) {
+	fallback := t.TempDir()
+	dir, cleanup, err := prepareArea("", fallback, false)
+	require.NoError(t, err)
+	require.Equal(t, fallback, dir)
+	cleanup()
+	require.DirExists(t, fallback)
+
+	base := filepath.Join(t.TempDir(), "unpack")
+	dir, cleanup, err = prepareArea(base, fallback, false)
+	require.NoError(t, err)
+	require.Equal(t, base, filepath.Dir(dir))
+	require.DirExists(t, dir)
+	cleanup()
+	_, err = os.Stat(dir)
+	require.True(t, os.IsNotExist(err))
+
+	dir, cleanup, err = prepareArea(base, fallback, true)
+	require.NoError(t, err)
+	cleanup()
+	require.DirExists(t, dir)
+}
+
+func TestWatchAreas(t *testing.T) {
+	areaCheckInterval = 10 * time.Millisecond
+	defer func() { areaCheckInterval = time.Second }()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "blob"), make([]byte, 100), 0644))
+	size, err := dirSize(dir)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), size)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	go watchAreas(ctx, cancel, []workArea{
+		{name: "unpack", dir: dir, limit: 0},
+		{name: "blob", dir: dir, limit: 50},
+	})
+	<-ctx.Done()
+
+	err = areaError(ctx, errors.New("context canceled"))
+	var limitErr *AreaLimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, "blob", limitErr.Area)
+	require.Equal(t, int64(100), limitErr.Size)
+
+	require.EqualError(t, areaError(context.Background(), errors.New("other")), "other")
+}
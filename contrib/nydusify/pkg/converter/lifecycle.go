@@ -0,0 +1,127 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// lifecycleApplier applies the storage class and tagging of backend config
+// to the blobs referenced by pushed images, as the blobs are uploaded by
+// the nydus driver of acceleration service, which doesn't support them.
+type lifecycleApplier struct {
+	pvd  *provider.Provider
+	bkd  backend.LifecycleBackend
+	refs map[string]bool
+
+	mutex sync.Mutex
+	// blobs are collected before push, as the later hooks may rename the
+	// annotations of blob layers.
+	blobs   map[string][]ocispec.Descriptor
+	applied map[digest.Digest]bool
+}
+
+func (applier *lifecycleApplier) hook() provider.PushHook {
+	return provider.PushHook{
+		BeforePush: applier.beforePush,
+		AfterPush:  applier.afterPush,
+	}
+}
+
+func (applier *lifecycleApplier) beforePush(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+	if !applier.refs[ref] {
+		return &desc, nil
+	}
+	cs := applier.pvd.ContentStore()
+	children := images.ChildrenHandler(cs)
+	var blobs []ocispec.Descriptor
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if desc.Annotations[nydusifyUtils.LayerAnnotationNydusBlob] == "true" {
+			blobs = append(blobs, desc)
+			return nil, nil
+		}
+		if images.IsLayerType(desc.MediaType) {
+			return nil, nil
+		}
+		descs, err := children.Handle(ctx, desc)
+		if errdefs.IsNotFound(err) {
+			return nil, nil
+		}
+		return descs, err
+	})
+	if err := images.Walk(ctx, handler, desc); err != nil {
+		return nil, errors.Wrapf(err, "collect blobs of %s", ref)
+	}
+
+	applier.mutex.Lock()
+	defer applier.mutex.Unlock()
+	applier.blobs[ref] = blobs
+	return &desc, nil
+}
+
+func (applier *lifecycleApplier) afterPush(ctx context.Context, _ ocispec.Descriptor, ref string) error {
+	if !applier.refs[ref] {
+		return nil
+	}
+	applier.mutex.Lock()
+	defer applier.mutex.Unlock()
+	for _, blob := range applier.blobs[ref] {
+		if applier.applied[blob.Digest] {
+			continue
+		}
+		if err := applier.bkd.ApplyLifecycle(ctx, blob.Digest.Hex(), blob.Size); err != nil {
+			return err
+		}
+		applier.applied[blob.Digest] = true
+		logrus.Debugf("applied lifecycle to blob %s", blob.Digest)
+	}
+	return nil
+}
+
+// addLifecycleApplier applies the lifecycle to the blobs of images pushed
+// to refs, if the storage backend is configured with lifecycle.
+func addLifecycleApplier(pvd *provider.Provider, opt Opt, refs ...string) error {
+	if opt.BackendType == "" {
+		return nil
+	}
+	bkd, err := backend.NewBackend(opt.BackendType, []byte(opt.BackendConfig), nil)
+	if err != nil {
+		return errors.Wrap(err, "create storage backend")
+	}
+	lifecycleBackend, ok := bkd.(backend.LifecycleBackend)
+	if !ok || lifecycleBackend.Lifecycle().IsEmpty() {
+		return nil
+	}
+
+	applier := &lifecycleApplier{
+		pvd:     pvd,
+		bkd:     lifecycleBackend,
+		refs:    map[string]bool{},
+		blobs:   map[string][]ocispec.Descriptor{},
+		applied: map[digest.Digest]bool{},
+	}
+	for _, ref := range refs {
+		named, err := docker.ParseDockerRef(ref)
+		if err != nil {
+			return errors.Wrap(err, "parse reference")
+		}
+		applier.refs[named.String()] = true
+	}
+	pvd.AddPushHook(applier.hook())
+	return nil
+}
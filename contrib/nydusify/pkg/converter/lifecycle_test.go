@@ -0,0 +1,79 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+type fakeLifecycleBackend struct {
+	applied []string
+}
+
+func (bkd *fakeLifecycleBackend) Lifecycle() backend.Lifecycle {
+	return backend.Lifecycle{StorageClass: "IA"}
+}
+
+func (bkd *fakeLifecycleBackend) ApplyLifecycle(_ context.Context, blobID string, _ int64) error {
+	bkd.applied = append(bkd.applied, blobID)
+	return nil
+}
+
+func TestLifecycleApplier(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+
+	blob := digest.FromString("blob")
+	desc, err := utils.WriteJSON(ctx, pvd.ContentStore(), ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{
+			{
+				MediaType:   nydusifyUtils.MediaTypeNydusBlob,
+				Digest:      blob,
+				Annotations: map[string]string{nydusifyUtils.LayerAnnotationNydusBlob: "true"},
+			},
+			{
+				MediaType:   ocispec.MediaTypeImageLayerGzip,
+				Digest:      digest.FromString("bootstrap"),
+				Annotations: map[string]string{nydusifyUtils.LayerAnnotationNydusBootstrap: "true"},
+			},
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+
+	bkd := &fakeLifecycleBackend{}
+	target := "docker.io/library/app:latest-nydus"
+	applier := &lifecycleApplier{
+		pvd:     pvd,
+		bkd:     bkd,
+		refs:    map[string]bool{target: true},
+		blobs:   map[string][]ocispec.Descriptor{},
+		applied: map[digest.Digest]bool{},
+	}
+
+	for _, ref := range []string{target, target, "docker.io/library/app:cache"} {
+		_, err := applier.beforePush(ctx, *desc, ref)
+		require.NoError(t, err)
+		require.NoError(t, applier.afterPush(ctx, *desc, ref))
+	}
+	require.Equal(t, []string{blob.Hex()}, bkd.applied)
+
+	// The registry backend doesn't support lifecycle.
+	require.NoError(t, addLifecycleApplier(pvd, Opt{}, target))
+}
@@ -0,0 +1,226 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	nydusConverter "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/cache"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+// The ref of content writer used by nydus-snapshotter to write the layer
+// converted from source layer.
+const convertedLayerRefPrefix = "convert-nydus-from-"
+
+// localCacheOptions identifies the build options of layers, the layers
+// built with different options are cached separately.
+func localCacheOptions(opt Opt, chunkDictDigest string) string {
+	options := optionAnnotations(opt, chunkDictDigest)
+	options["fs_version"] = opt.FsVersion
+	options["oci_ref"] = strconv.FormatBool(opt.OCIRef)
+	options["cache_version"] = opt.CacheVersion
+	data, _ := json.Marshal(options)
+	return digest.FromBytes(data).String()
+}
+
+// localCacheStore reuses the layers converted by previous runs. The layer
+// conversion of nydus-snapshotter skips building if the source layer is
+// labeled with the target digest in content store, so the cached layer is
+// imported into content store and the label is added once the source layer
+// info is queried. The converted layers are saved into cache once they're
+// committed to content store.
+type localCacheStore struct {
+	content.Store
+	cache   *cache.LocalCache
+	options string
+}
+
+type localCacheWriter struct {
+	content.Writer
+	store  *localCacheStore
+	source digest.Digest
+}
+
+func (s *localCacheStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	info, err := s.Store.Info(ctx, dgst)
+	if err != nil || info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest] != "" {
+		return info, err
+	}
+	record, err := s.cache.Get(dgst, s.options)
+	if err != nil {
+		logrus.WithError(err).Warnf("failed to look up local cache of layer %s", dgst)
+		return info, nil
+	}
+	if record == nil {
+		return info, nil
+	}
+	if err := s.importLayer(ctx, record); err != nil {
+		logrus.WithError(err).Warnf("failed to import cached layer of %s", dgst)
+		return info, nil
+	}
+	logrus.Infof("hit local cache for layer %s", dgst)
+
+	labels := map[string]string{}
+	for key, value := range info.Labels {
+		labels[key] = value
+	}
+	labels[nydusConverter.LayerAnnotationNydusTargetDigest] = record.Target.String()
+	info.Labels = labels
+	return info, nil
+}
+
+func (s *localCacheStore) importLayer(ctx context.Context, record *cache.LocalRecord) error {
+	if _, err := s.Store.Info(ctx, record.Target); err == nil {
+		return nil
+	}
+	file, err := os.Open(s.cache.BlobPath(record.Target))
+	if err != nil {
+		return errors.Wrap(err, "open cached layer")
+	}
+	defer file.Close()
+	desc := ocispec.Descriptor{Digest: record.Target, Size: record.TargetSize}
+	if err := content.WriteBlob(ctx, s.Store, "local-cache-"+record.Target.String(), file, desc); err != nil {
+		return errors.Wrap(err, "write cached layer to content store")
+	}
+	return nil
+}
+
+func (s *localCacheStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	writer, err := s.Store.Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return writer, nil
+		}
+	}
+	source := digest.Digest(strings.TrimPrefix(wOpts.Ref, convertedLayerRefPrefix))
+	if !strings.HasPrefix(wOpts.Ref, convertedLayerRefPrefix) || source.Validate() != nil {
+		return writer, nil
+	}
+	return &localCacheWriter{Writer: writer, store: s, source: source}, nil
+}
+
+func (w *localCacheWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	// The layer already in content store is cached as well.
+	err := w.Writer.Commit(ctx, size, expected, opts...)
+	if err != nil && !errdefs.IsAlreadyExists(err) {
+		return err
+	}
+	if err := w.store.save(ctx, w.source, w.Writer.Digest()); err != nil {
+		logrus.WithError(err).Warnf("failed to save converted layer of %s to local cache", w.source)
+	}
+	return err
+}
+
+func (s *localCacheStore) save(ctx context.Context, source, target digest.Digest) error {
+	ra, err := s.Store.ReaderAt(ctx, ocispec.Descriptor{Digest: target})
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	defer ra.Close()
+	return s.cache.Put(source, s.options, target, content.NewReader(ra))
+}
+
+// addLocalCache reuses the layers converted by previous runs with the same
+// options from the local cache in dir. It's skipped for the storage backend
+// other than registry, as the blobs are pushed to backend after the layer
+// is cached, and for the compatible image built with other options.
+func addLocalCache(pvd *provider.Provider, opt Opt, chunkDictDigest string) (*localCacheStore, error) {
+	if opt.LocalCacheDir == "" {
+		return nil, nil
+	}
+	if opt.BackendType != "" && opt.BackendType != "registry" {
+		logrus.Warnf("local cache is disabled for %s backend", opt.BackendType)
+		return nil, nil
+	}
+	if opt.CompatFsVersion != "" {
+		logrus.Warn("local cache is disabled with compatible fs version")
+		return nil, nil
+	}
+	localCache, err := cache.NewLocalCache(opt.LocalCacheDir)
+	if err != nil {
+		return nil, err
+	}
+	store := &localCacheStore{
+		Store:   pvd.ContentStore(),
+		cache:   localCache,
+		options: localCacheOptions(opt, chunkDictDigest),
+	}
+	pvd.SetContentStore(store)
+	return store, nil
+}
+
+// seed saves the Nydus blob layers of manifest into cache by the source
+// layers in their annotations, for the layers not built by conversion,
+// e.g. reused from previous target.
+func (s *localCacheStore) seed(ctx context.Context, cs content.Store, desc ocispec.Descriptor) error {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return errors.Wrap(err, "read index json")
+		}
+		for _, manifestDesc := range index.Manifests {
+			if err := s.seed(ctx, cs, manifestDesc); err != nil {
+				return err
+			}
+		}
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return errors.Wrap(err, "read manifest json")
+		}
+		for target, source := range sourceLayers(manifest) {
+			record, err := s.cache.Get(source, s.options)
+			if err != nil || record != nil {
+				continue
+			}
+			if err := s.save(ctx, source, target); err != nil {
+				logrus.WithError(err).Warnf("failed to save layer %s of %s to local cache", target, source)
+			}
+		}
+	}
+	return nil
+}
+
+// addSeeder caches the layers of image pushed to target by their source
+// layers, it must be added after the source layer annotator.
+func (s *localCacheStore) addSeeder(pvd *provider.Provider, target string) error {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return errors.Wrap(err, "parse target reference")
+	}
+	pvd.AddPushHook(provider.PushHook{
+		BeforePush: func(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+			if ref != named.String() {
+				return &desc, nil
+			}
+			return &desc, s.seed(ctx, pvd.ContentStore(), desc)
+		},
+	})
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	nydusConverter "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+func TestLocalCacheStore(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	cacheDir := t.TempDir()
+	opt := Opt{LocalCacheDir: cacheDir, FsVersion: "6"}
+
+	newStore := func() content.Store {
+		pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+		require.NoError(t, err)
+		_, err = addLocalCache(pvd, opt, "")
+		require.NoError(t, err)
+		return pvd.ContentStore()
+	}
+	write := func(cs content.Store, ref string, data []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+		require.NoError(t, content.WriteBlob(ctx, cs, ref, bytes.NewReader(data), desc))
+		return desc
+	}
+
+	// The layer converted by the first run is saved into cache.
+	cs := newStore()
+	source := write(cs, "source", []byte("source layer"))
+	info, err := cs.Info(ctx, source.Digest)
+	require.NoError(t, err)
+	require.Empty(t, info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest])
+	target := write(cs, convertedLayerRefPrefix+source.Digest.String(), []byte("nydus layer"))
+
+	// The second run imports the cached layer and labels the source layer.
+	cs = newStore()
+	write(cs, "source", []byte("source layer"))
+	info, err = cs.Info(ctx, source.Digest)
+	require.NoError(t, err)
+	require.Equal(t, target.Digest.String(), info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest])
+	data, err := content.ReadBlob(ctx, cs, target)
+	require.NoError(t, err)
+	require.Equal(t, []byte("nydus layer"), data)
+
+	// Nothing is reused by the conversion with different options.
+	opt.FsVersion = "5"
+	cs = newStore()
+	write(cs, "source", []byte("source layer"))
+	info, err = cs.Info(ctx, source.Digest)
+	require.NoError(t, err)
+	require.Empty(t, info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest])
+}
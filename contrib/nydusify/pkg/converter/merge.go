@@ -0,0 +1,234 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	nydusConverter "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// MergeOpt is the options of MergeBootstraps, which must match the
+// LayerOpt the layers are converted with.
+type MergeOpt struct {
+	// WorkDir is the directory to merge bootstrap and save the result.
+	WorkDir        string
+	NydusImagePath string
+
+	FsVersion        string
+	PrefetchPatterns string
+	// ChunkDictPath is the bootstrap of chunk dict which the layers are
+	// deduplicated against, and ChunkDictBlobs are the blob layers of chunk
+	// dict image, which are referenced by the merged bootstrap if the
+	// chunks in them are reused.
+	ChunkDictPath  string
+	ChunkDictBlobs []ocispec.Descriptor
+	OCIRef         bool
+	// Timeout kills nydus-image if the bootstraps aren't merged in time, 0
+	// means no limit.
+	Timeout time.Duration
+
+	// Provider provides the nydus blob layers produced by ConvertLayer,
+	// e.g. a local content store they're written into. Only the bootstraps
+	// at the tail of blob layers are read.
+	Provider content.Provider
+	// Config is the image config of source image, the manifest and config
+	// of nydus image are assembled from it if it's specified.
+	Config *ocispec.Image
+}
+
+// MergeResult is the nydus image assembled by MergeBootstraps.
+type MergeResult struct {
+	// Bootstrap is the bootstrap layer compressed by gzip saved in
+	// BootstrapPath, to be pushed along with the blob layers.
+	Bootstrap     ocispec.Descriptor
+	BootstrapPath string
+	// Layers are the layers of nydus image in order, i.e. the blob layers
+	// referenced by the merged bootstrap followed by the bootstrap layer,
+	// the blob layers entirely deduplicated by chunk dict are excluded.
+	Layers []ocispec.Descriptor
+	// Manifest and Config are the manifest and config of nydus image if
+	// MergeOpt.Config is specified, the Config is referenced by Manifest.
+	Manifest *ocispec.Manifest
+	Config   []byte
+}
+
+// MergeBootstraps merges the bootstraps of the nydus blob layers converted
+// by ConvertLayer elsewhere into the bootstrap of image, in the order of
+// layers from bottom to top, which completes the scatter/gather conversion
+// of image sharded by layer.
+func MergeBootstraps(ctx context.Context, layers []ocispec.Descriptor, opt MergeOpt) (result *MergeResult, retErr error) {
+	if len(layers) == 0 {
+		return nil, errors.New("no layer to merge")
+	}
+	if opt.Provider == nil {
+		return nil, errors.New("no provider of layers")
+	}
+	if opt.FsVersion == "" {
+		opt.FsVersion = "6"
+	}
+	if err := os.MkdirAll(opt.WorkDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create work directory")
+	}
+
+	start := time.Now()
+	blobs := map[digest.Digest]ocispec.Descriptor{}
+	for _, desc := range opt.ChunkDictBlobs {
+		blobs[desc.Digest] = desc
+	}
+	mergeLayers := []nydusConverter.Layer{}
+	for _, desc := range layers {
+		if !nydusConverter.IsNydusBlob(desc) {
+			return nil, errors.Errorf("layer %s isn't nydus blob layer", desc.Digest)
+		}
+		ra, err := opt.Provider.ReaderAt(ctx, desc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get reader of layer %s", desc.Digest)
+		}
+		defer ra.Close()
+		layer := nydusConverter.Layer{Digest: desc.Digest, ReaderAt: ra}
+		if opt.OCIRef {
+			original, err := digest.Parse(desc.Annotations[nydusifyUtils.LayerAnnotationNydusRefLayer])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid source digest of referenced layer %s", desc.Digest)
+			}
+			layer.OriginalDigest = &original
+		}
+		mergeLayers = append(mergeLayers, layer)
+		blobs[desc.Digest] = desc
+	}
+
+	bootstrapPath := filepath.Join(opt.WorkDir, "bootstrap.tar.gz")
+	file, err := os.Create(bootstrapPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "create bootstrap layer")
+	}
+	defer file.Close()
+	defer func() {
+		if retErr != nil {
+			os.Remove(bootstrapPath)
+		}
+	}()
+
+	compressed := digest.SHA256.Digester()
+	uncompressed := digest.SHA256.Digester()
+	gw := gzip.NewWriter(io.MultiWriter(file, compressed.Hash()))
+	blobDigests, err := nydusConverter.Merge(ctx, mergeLayers, io.MultiWriter(gw, uncompressed.Hash()), nydusConverter.MergeOption{
+		WorkDir:          opt.WorkDir,
+		BuilderPath:      opt.NydusImagePath,
+		FsVersion:        opt.FsVersion,
+		ChunkDictPath:    opt.ChunkDictPath,
+		PrefetchPatterns: opt.PrefetchPatterns,
+		WithTar:          true,
+		OCIRef:           opt.OCIRef,
+		Timeout:          builderTimeout(opt.Timeout),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "merge bootstraps")
+	}
+	if err := gw.Close(); err != nil {
+		return nil, errors.Wrap(err, "compress bootstrap layer")
+	}
+	if err := file.Close(); err != nil {
+		return nil, errors.Wrap(err, "close bootstrap layer")
+	}
+	info, err := os.Stat(bootstrapPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "stat bootstrap layer")
+	}
+
+	result = &MergeResult{
+		Bootstrap: ocispec.Descriptor{
+			Digest:    compressed.Digest(),
+			Size:      info.Size(),
+			MediaType: ocispec.MediaTypeImageLayerGzip,
+			Annotations: map[string]string{
+				nydusifyUtils.LayerAnnotationUncompressed:   uncompressed.Digest().String(),
+				nydusifyUtils.LayerAnnotationNydusFsVersion: opt.FsVersion,
+				nydusifyUtils.LayerAnnotationNydusBootstrap: "true",
+			},
+		},
+		BootstrapPath: bootstrapPath,
+	}
+	// The referenced blobs keep the layers one-to-one.
+	if opt.OCIRef {
+		blobDigests = nil
+		for _, layer := range mergeLayers {
+			blobDigests = append(blobDigests, layer.Digest)
+		}
+	}
+	for _, blobDigest := range blobDigests {
+		blob, ok := blobs[blobDigest]
+		if !ok {
+			return nil, errors.Errorf("blob %s referenced by bootstrap isn't in layers or chunk dict blobs", blobDigest)
+		}
+		result.Layers = append(result.Layers, blob)
+	}
+	result.Layers = append(result.Layers, result.Bootstrap)
+
+	if opt.Config != nil {
+		if err := result.assemble(*opt.Config); err != nil {
+			return nil, err
+		}
+	}
+
+	logrus.Infof("merged bootstraps of %d layers into %s, elapsed: %s", len(layers), result.Bootstrap.Digest, time.Since(start))
+
+	return result, nil
+}
+
+// assemble builds the manifest and config of nydus image from the config
+// of source image like nydus-snapshotter, i.e. the diff ids are replaced by
+// the merged layers, and the history of bootstrap layer is appended.
+func (result *MergeResult) assemble(config ocispec.Image) error {
+	config.RootFS = ocispec.RootFS{Type: "layers"}
+	layers := []ocispec.Descriptor{}
+	for _, layer := range result.Layers {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, digest.Digest(layer.Annotations[nydusifyUtils.LayerAnnotationUncompressed]))
+		annotations := map[string]string{}
+		for key, value := range layer.Annotations {
+			if key != nydusifyUtils.LayerAnnotationUncompressed {
+				annotations[key] = value
+			}
+		}
+		layer.Annotations = annotations
+		layers = append(layers, layer)
+	}
+	config.History = append(config.History, ocispec.History{
+		CreatedBy: "Nydus Converter",
+		Comment:   "Nydus Bootstrap Layer",
+	})
+	data, err := json.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "marshal image config")
+	}
+
+	result.Config = data
+	result.Manifest = &ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(data),
+			Size:      int64(len(data)),
+		},
+		Layers: layers,
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestMergeBootstraps(t *testing.T) {
+	ctx := context.Background()
+	store, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+	layers := []ocispec.Descriptor{}
+	for _, name := range []string{"lower", "upper"} {
+		data := nydusTar(t, map[string][]byte{nydusifyUtils.EntryBootstrap: []byte(name)})
+		desc := ocispec.Descriptor{
+			MediaType: nydusifyUtils.MediaTypeNydusBlob,
+			Digest:    digest.FromBytes(data),
+			Size:      int64(len(data)),
+			Annotations: map[string]string{
+				nydusifyUtils.LayerAnnotationUncompressed: digest.FromBytes(data).String(),
+				nydusifyUtils.LayerAnnotationNydusBlob:    "true",
+			},
+		}
+		require.NoError(t, content.WriteBlob(ctx, store, name, bytes.NewReader(data), desc))
+		layers = append(layers, desc)
+	}
+	dictBlob := ocispec.Descriptor{
+		MediaType: nydusifyUtils.MediaTypeNydusBlob,
+		Digest:    digest.FromString("dict"),
+		Size:      4,
+		Annotations: map[string]string{
+			nydusifyUtils.LayerAnnotationUncompressed: digest.FromString("dict").String(),
+		},
+	}
+
+	// The chunks of lower layer are entirely deduplicated by chunk dict.
+	bootstrap := []byte("merged bootstrap")
+	stub, err := testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{
+		Bootstrap: bootstrap,
+		Blobs:     []string{dictBlob.Digest.Encoded(), layers[1].Digest.Encoded()},
+	})
+	require.NoError(t, err)
+	opt := MergeOpt{
+		WorkDir:        t.TempDir(),
+		NydusImagePath: stub.Path,
+		ChunkDictPath:  "/path/to/dict",
+		Provider:       store,
+		Config: &ocispec.Image{
+			Config:  ocispec.ImageConfig{Cmd: []string{"sh"}},
+			History: []ocispec.History{{CreatedBy: "ADD rootfs"}},
+		},
+	}
+	_, err = MergeBootstraps(ctx, layers, opt)
+	require.ErrorContains(t, err, "isn't in layers or chunk dict blobs")
+
+	opt.ChunkDictBlobs = []ocispec.Descriptor{dictBlob}
+	result, err := MergeBootstraps(ctx, layers, opt)
+	require.NoError(t, err)
+
+	file, err := os.Open(result.BootstrapPath)
+	require.NoError(t, err)
+	defer file.Close()
+	gr, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	// The bootstrap is in `image` directory of tar.
+	header, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, byte(tar.TypeDir), header.Typeflag)
+	header, err = tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, nydusifyUtils.BootstrapFileNameInLayer, header.Name)
+	data, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	require.Equal(t, bootstrap, data)
+	require.Equal(t, "true", result.Bootstrap.Annotations[nydusifyUtils.LayerAnnotationNydusBootstrap])
+	require.Equal(t, "6", result.Bootstrap.Annotations[nydusifyUtils.LayerAnnotationNydusFsVersion])
+	require.Equal(t, []ocispec.Descriptor{dictBlob, layers[1], result.Bootstrap}, result.Layers)
+
+	// The manifest and config are assembled from source config.
+	var config ocispec.Image
+	require.NoError(t, json.Unmarshal(result.Config, &config))
+	require.Equal(t, []string{"sh"}, config.Config.Cmd)
+	require.Equal(t, []digest.Digest{
+		dictBlob.Digest, layers[1].Digest, digest.Digest(result.Bootstrap.Annotations[nydusifyUtils.LayerAnnotationUncompressed]),
+	}, config.RootFS.DiffIDs)
+	require.Len(t, config.History, 2)
+	require.Equal(t, digest.FromBytes(result.Config), result.Manifest.Config.Digest)
+	require.Len(t, result.Manifest.Layers, 3)
+	for _, layer := range result.Manifest.Layers {
+		require.NotContains(t, layer.Annotations, nydusifyUtils.LayerAnnotationUncompressed)
+	}
+
+	calls, err := stub.Calls()
+	require.NoError(t, err)
+	merge := calls[len(calls)-1]
+	require.Equal(t, "merge", merge[0])
+	require.Contains(t, merge, "bootstrap=/path/to/dict")
+
+	_, err = MergeBootstraps(ctx, []ocispec.Descriptor{{MediaType: ocispec.MediaTypeImageLayerGzip}}, opt)
+	require.ErrorContains(t, err, "isn't nydus blob layer")
+}
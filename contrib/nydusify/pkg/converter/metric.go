@@ -5,14 +5,29 @@
 package converter
 
 import (
+	"context"
 	"encoding/json"
 	"os"
+	"time"
 
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
 	"github.com/goharbor/acceleration-service/pkg/converter"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/metrics"
 )
 
-func dumpMetric(metric *converter.Metric, path string) error {
+// metricOutput is the output JSON of conversion.
+type metricOutput struct {
+	*converter.Metric
+	// NydusImageVersion is the detected version of nydus-image.
+	NydusImageVersion string `json:",omitempty"`
+}
+
+func dumpMetric(metric *converter.Metric, nydusImageVersion, path string) error {
 	file, err := os.Create(path)
 	if err != nil {
 		return errors.Wrap(err, "Create file for metric")
@@ -20,8 +35,46 @@ func dumpMetric(metric *converter.Metric, path string) error {
 	defer file.Close()
 
 	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(metric); err != nil {
+	if err := encoder.Encode(metricOutput{Metric: metric, NydusImageVersion: nydusImageVersion}); err != nil {
 		return errors.Wrap(err, "Encode JSON from metric")
 	}
 	return nil
 }
+
+// countLayers returns the count of layers of source image with the
+// platforms, 0 if the image can't be walked.
+func countLayers(ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, ref string) int {
+	desc, err := pvd.Image(ctx, ref)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if images.IsLayerType(desc.MediaType) {
+			count++
+		}
+		return nil, nil
+	})
+	children := images.FilterPlatforms(images.ChildrenHandler(pvd.ContentStore()), platformMC)
+	if err := images.Walk(ctx, images.Handlers(handler, children), *desc); err != nil {
+		return 0
+	}
+	return count
+}
+
+// recordMetrics records the result of conversion in metrics, the failure
+// reason is coarse to limit the cardinality of label.
+func recordMetrics(source string, layers int, start time.Time, err error) {
+	if err == nil {
+		metrics.ConversionDuration(source, layers, start)
+		metrics.ConversionSuccessCount(source)
+		return
+	}
+	reason := "error"
+	if errors.Is(err, context.Canceled) {
+		reason = "canceled"
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		reason = "timeout"
+	}
+	metrics.ConversionFailureCount(source, reason)
+}
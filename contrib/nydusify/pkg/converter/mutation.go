@@ -0,0 +1,191 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+// ConfigMutation declares the mutations applied to the converted image
+// before it's pushed, for example to stamp provenance metadata.
+type ConfigMutation struct {
+	// Labels are added to image config, existing labels are overwritten.
+	Labels map[string]string `json:"labels,omitempty"`
+	// RemoveLabels are removed from image config.
+	RemoveLabels []string `json:"remove_labels,omitempty"`
+	// Env sets the environment variables in image config.
+	Env map[string]string `json:"env,omitempty"`
+	// Entrypoint replaces the entrypoint in image config if not empty.
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	// Cmd replaces the cmd in image config if not empty.
+	Cmd []string `json:"cmd,omitempty"`
+	// Annotations are added to image manifest.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// LoadConfigMutation reads the mutation from a JSON file.
+func LoadConfigMutation(path string) (*ConfigMutation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read config mutation file %s", path)
+	}
+	var mutation ConfigMutation
+	if err := json.Unmarshal(data, &mutation); err != nil {
+		return nil, errors.Wrapf(err, "parse config mutation file %s", path)
+	}
+	return &mutation, nil
+}
+
+// apply mutates the image config.
+func (mutation *ConfigMutation) apply(config *ocispec.ImageConfig) {
+	if len(mutation.Labels) > 0 && config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	for key, value := range mutation.Labels {
+		config.Labels[key] = value
+	}
+	for _, key := range mutation.RemoveLabels {
+		delete(config.Labels, key)
+	}
+
+	if len(mutation.Env) > 0 {
+		set := map[string]bool{}
+		for idx, env := range config.Env {
+			key := strings.SplitN(env, "=", 2)[0]
+			if value, ok := mutation.Env[key]; ok {
+				config.Env[idx] = key + "=" + value
+				set[key] = true
+			}
+		}
+		// Append new variables in a stable order to get reproducible config.
+		keys := make([]string, 0, len(mutation.Env))
+		for key := range mutation.Env {
+			if !set[key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			config.Env = append(config.Env, key+"="+mutation.Env[key])
+		}
+	}
+
+	if len(mutation.Entrypoint) > 0 {
+		config.Entrypoint = mutation.Entrypoint
+	}
+	if len(mutation.Cmd) > 0 {
+		config.Cmd = mutation.Cmd
+	}
+}
+
+// configMutator applies the mutation to the converted image before it's
+// pushed to target.
+type configMutator struct {
+	pvd      *provider.Provider
+	mutation *ConfigMutation
+	target   string
+}
+
+func newConfigMutator(pvd *provider.Provider, mutation *ConfigMutation, target string) (*configMutator, error) {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	return &configMutator{
+		pvd:      pvd,
+		mutation: mutation,
+		target:   named.String(),
+	}, nil
+}
+
+func (mutator *configMutator) hook() provider.PushHook {
+	return provider.PushHook{
+		BeforePush: mutator.beforePush,
+	}
+}
+
+func (mutator *configMutator) beforePush(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+	if ref != mutator.target {
+		return &desc, nil
+	}
+	return mutator.mutate(ctx, desc)
+}
+
+func (mutator *configMutator) mutate(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	cs := mutator.pvd.ContentStore()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return nil, errors.Wrap(err, "read index json")
+		}
+		for idx := range index.Manifests {
+			newDesc, err := mutator.mutate(ctx, index.Manifests[idx])
+			if err != nil {
+				return nil, err
+			}
+			index.Manifests[idx] = *newDesc
+		}
+		return mutator.pvd.WriteJSON(ctx, index, desc)
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return nil, errors.Wrap(err, "read manifest json")
+		}
+
+		var config ocispec.Image
+		if _, err := utils.ReadJSON(ctx, cs, &config, manifest.Config); err != nil {
+			return nil, errors.Wrap(err, "read image config")
+		}
+		mutator.mutation.apply(&config.Config)
+		configDesc, err := mutator.pvd.WriteJSON(ctx, config, manifest.Config)
+		if err != nil {
+			return nil, errors.Wrap(err, "write image config")
+		}
+		manifest.Config = *configDesc
+
+		if len(mutator.mutation.Annotations) > 0 && manifest.Annotations == nil {
+			manifest.Annotations = map[string]string{}
+		}
+		for key, value := range mutator.mutation.Annotations {
+			manifest.Annotations[key] = value
+		}
+
+		newDesc, err := mutator.pvd.WriteJSON(ctx, manifest, desc)
+		if err != nil {
+			return nil, errors.Wrap(err, "write manifest json")
+		}
+		return newDesc, nil
+
+	default:
+		return &desc, nil
+	}
+}
+
+func addConfigMutator(pvd *provider.Provider, opt Opt, target string) error {
+	if opt.ConfigMutation == nil {
+		return nil
+	}
+	mutator, err := newConfigMutator(pvd, opt.ConfigMutation, target)
+	if err != nil {
+		return err
+	}
+	pvd.AddPushHook(mutator.hook())
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+func TestLoadConfigMutation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mutation.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"labels": {"org.opencontainers.image.source": "https://example.com/repo"},
+		"remove_labels": ["maintainer"],
+		"env": {"FOO": "bar"},
+		"annotations": {"com.example.converted-by": "nydusify"}
+	}`), 0644))
+	mutation, err := LoadConfigMutation(path)
+	require.NoError(t, err)
+	require.Equal(t, &ConfigMutation{
+		Labels:       map[string]string{"org.opencontainers.image.source": "https://example.com/repo"},
+		RemoveLabels: []string{"maintainer"},
+		Env:          map[string]string{"FOO": "bar"},
+		Annotations:  map[string]string{"com.example.converted-by": "nydusify"},
+	}, mutation)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{`), 0644))
+	_, err = LoadConfigMutation(path)
+	require.Error(t, err)
+}
+
+func TestConfigMutator(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	configDesc, err := utils.WriteJSON(ctx, cs, ocispec.Image{
+		Config: ocispec.ImageConfig{
+			Env:        []string{"PATH=/usr/bin", "FOO=foo"},
+			Entrypoint: []string{"/bin/sh"},
+			Labels: map[string]string{
+				"maintainer": "someone",
+				"version":    "1",
+			},
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig}, "", nil)
+	require.NoError(t, err)
+	manifestDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    *configDesc,
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	indexDesc, err := utils.WriteJSON(ctx, cs, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{*manifestDesc},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}, "", nil)
+	require.NoError(t, err)
+
+	mutator, err := newConfigMutator(pvd, &ConfigMutation{
+		Labels:       map[string]string{"version": "2", "source": "repo"},
+		RemoveLabels: []string{"maintainer"},
+		Env:          map[string]string{"FOO": "bar", "B": "b", "A": "a"},
+		Cmd:          []string{"-c", "true"},
+		Annotations:  map[string]string{"converted-by": "nydusify"},
+	}, "nydus/test:latest")
+	require.NoError(t, err)
+
+	// Other references are ignored.
+	newDesc, err := mutator.beforePush(ctx, *indexDesc, "docker.io/nydus/cache:latest")
+	require.NoError(t, err)
+	require.Equal(t, *indexDesc, *newDesc)
+
+	newDesc, err = mutator.beforePush(ctx, *indexDesc, "docker.io/nydus/test:latest")
+	require.NoError(t, err)
+	require.NotEqual(t, indexDesc.Digest, newDesc.Digest)
+
+	var index ocispec.Index
+	_, err = utils.ReadJSON(ctx, cs, &index, *newDesc)
+	require.NoError(t, err)
+	var manifest ocispec.Manifest
+	_, err = utils.ReadJSON(ctx, cs, &manifest, index.Manifests[0])
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"converted-by": "nydusify"}, manifest.Annotations)
+	var config ocispec.Image
+	_, err = utils.ReadJSON(ctx, cs, &config, manifest.Config)
+	require.NoError(t, err)
+	require.Equal(t, ocispec.ImageConfig{
+		Env:        []string{"PATH=/usr/bin", "FOO=bar", "A=a", "B=b"},
+		Entrypoint: []string{"/bin/sh"},
+		Cmd:        []string{"-c", "true"},
+		Labels: map[string]string{
+			"version": "2",
+			"source":  "repo",
+		},
+	}, config.Config)
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/pkg/errors"
+)
+
+var objectLayoutPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// resolveObjectLayout expands the placeholders in object key layout for
+// the target image, possible placeholders:
+// - {registry}: the registry host of target, e.g. `docker.io`;
+// - {repository}: the repository path of target, e.g. `library/nginx`;
+// - {date}: the date of conversion in UTC, e.g. `2023/06/01`.
+func resolveObjectLayout(layout, target string, now time.Time) (string, error) {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return "", errors.Wrap(err, "parse target reference")
+	}
+	values := map[string]string{
+		"{registry}":   docker.Domain(named),
+		"{repository}": docker.Path(named),
+		"{date}":       now.UTC().Format("2006/01/02"),
+	}
+	var unknown error
+	prefix := objectLayoutPlaceholder.ReplaceAllStringFunc(layout, func(placeholder string) string {
+		value, ok := values[placeholder]
+		if !ok {
+			unknown = fmt.Errorf("unknown placeholder %s in object layout, possible placeholders: {registry}, {repository}, {date}", placeholder)
+		}
+		return value
+	})
+	if unknown != nil {
+		return "", unknown
+	}
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix, nil
+}
+
+// applyObjectLayout appends the object key prefix resolved from object
+// layout to the `object_prefix` of backend config, the blobs are still
+// named by digest under the prefix, so nydusd reads them with the same
+// prefix as recorded in the blob URLs.
+func applyObjectLayout(opt *Opt, now time.Time) error {
+	if opt.BackendObjectLayout == "" {
+		return nil
+	}
+	if opt.BackendType != "oss" && opt.BackendType != "s3" {
+		return fmt.Errorf("object layout is only supported by oss and s3 backends")
+	}
+	prefix, err := resolveObjectLayout(opt.BackendObjectLayout, opt.Target, now)
+	if err != nil {
+		return err
+	}
+
+	config := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(opt.BackendConfig), &config); err != nil {
+		return errors.Wrap(err, "parse backend config")
+	}
+	objectPrefix, _ := config["object_prefix"].(string)
+	config["object_prefix"] = objectPrefix + prefix
+	data, err := json.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "marshal backend config")
+	}
+	opt.BackendConfig = string(data)
+	return nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveObjectLayout(t *testing.T) {
+	now := time.Date(2023, 6, 1, 23, 0, 0, 0, time.FixedZone("UTC-8", -8*3600))
+
+	prefix, err := resolveObjectLayout("{repository}/{date}", "nginx:latest", now)
+	require.NoError(t, err)
+	require.Equal(t, "library/nginx/2023/06/02/", prefix)
+
+	prefix, err = resolveObjectLayout("/nydus/{registry}/{repository}/", "localhost:5000/app/web@sha256:"+"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", now)
+	require.NoError(t, err)
+	require.Equal(t, "nydus/localhost:5000/app/web/", prefix)
+
+	_, err = resolveObjectLayout("{tag}", "nginx:latest", now)
+	require.ErrorContains(t, err, "unknown placeholder {tag}")
+}
+
+func TestApplyObjectLayout(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	opt := Opt{
+		Target:              "localhost:5000/app:latest",
+		BackendType:         "s3",
+		BackendConfig:       `{"bucket_name":"nydus","object_prefix":"blobs/","region":"us-east-1"}`,
+		BackendObjectLayout: "{repository}/{date}",
+	}
+	require.NoError(t, applyObjectLayout(&opt, now))
+	require.Equal(t, `{"bucket_name":"nydus","object_prefix":"blobs/app/2023/06/01/","region":"us-east-1"}`, opt.BackendConfig)
+
+	opt = Opt{Target: "localhost:5000/app:latest", BackendObjectLayout: "{repository}"}
+	require.Error(t, applyObjectLayout(&opt, now))
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// optionAnnotations returns the annotations recording the conversion
+// options, the fs version and builder version are recorded by
+// acceleration-service driver.
+func optionAnnotations(opt Opt, chunkDictDigest string) map[string]string {
+	annotations := map[string]string{
+		nydusifyUtils.ManifestNydusFsAlignChunk: strconv.FormatBool(opt.FsAlignChunk),
+	}
+	if opt.Compressor != "" {
+		annotations[nydusifyUtils.ManifestNydusCompressor] = opt.Compressor
+	}
+	if opt.ChunkSize != "" {
+		annotations[nydusifyUtils.ManifestNydusChunkSize] = opt.ChunkSize
+	}
+	if opt.BatchSize != "" {
+		annotations[nydusifyUtils.ManifestNydusBatchSize] = opt.BatchSize
+	}
+	if opt.ChunkDictRef != "" {
+		annotations[nydusifyUtils.ManifestNydusChunkDictReference] = opt.ChunkDictRef
+	}
+	if chunkDictDigest != "" {
+		annotations[nydusifyUtils.ManifestNydusChunkDictDigest] = chunkDictDigest
+	}
+	if opt.UnpackFilter != nil {
+		if dgst := filterDigest(opt.UnpackFilter); dgst != "" {
+			annotations[nydusifyUtils.ManifestNydusUnpackFilter] = dgst
+		}
+	}
+	return annotations
+}
+
+// resolveDigest returns the manifest (index) digest of the reference.
+func resolveDigest(ctx context.Context, pvd *provider.Provider, ref string) (string, error) {
+	resolver, err := pvd.Resolver(ref)
+	if err != nil {
+		return "", err
+	}
+	_, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "resolve %s", ref)
+	}
+	return desc.Digest.String(), nil
+}
+
+// optionAnnotator records the conversion options in the annotations of
+// Nydus manifests before the image is pushed to target.
+type optionAnnotator struct {
+	pvd         *provider.Provider
+	annotations map[string]string
+	target      string
+}
+
+func newOptionAnnotator(pvd *provider.Provider, annotations map[string]string, target string) (*optionAnnotator, error) {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	return &optionAnnotator{
+		pvd:         pvd,
+		annotations: annotations,
+		target:      named.String(),
+	}, nil
+}
+
+func (annotator *optionAnnotator) hook() provider.PushHook {
+	return provider.PushHook{
+		BeforePush: annotator.beforePush,
+	}
+}
+
+func (annotator *optionAnnotator) beforePush(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+	if ref != annotator.target {
+		return &desc, nil
+	}
+	return annotator.annotate(ctx, desc)
+}
+
+func (annotator *optionAnnotator) annotate(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	cs := annotator.pvd.ContentStore()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return nil, errors.Wrap(err, "read index json")
+		}
+		for idx := range index.Manifests {
+			newDesc, err := annotator.annotate(ctx, index.Manifests[idx])
+			if err != nil {
+				return nil, err
+			}
+			index.Manifests[idx] = *newDesc
+		}
+		return annotator.pvd.WriteJSON(ctx, index, desc)
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return nil, errors.Wrap(err, "read manifest json")
+		}
+		if manifest.Annotations[annotationSourceDigest] == "" {
+			// Skip the OCI manifest in merged index.
+			return &desc, nil
+		}
+		for key, value := range annotator.annotations {
+			manifest.Annotations[key] = value
+		}
+		newDesc, err := annotator.pvd.WriteJSON(ctx, manifest, desc)
+		if err != nil {
+			return nil, errors.Wrap(err, "write manifest json")
+		}
+		return newDesc, nil
+
+	default:
+		return &desc, nil
+	}
+}
+
+func addOptionAnnotator(pvd *provider.Provider, opt Opt, chunkDictDigest, target string) error {
+	annotator, err := newOptionAnnotator(pvd, optionAnnotations(opt, chunkDictDigest), target)
+	if err != nil {
+		return err
+	}
+	pvd.AddPushHook(annotator.hook())
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestOptionAnnotations(t *testing.T) {
+	require.Equal(t, map[string]string{
+		nydusifyUtils.ManifestNydusFsAlignChunk: "false",
+	}, optionAnnotations(Opt{}, ""))
+
+	require.Equal(t, map[string]string{
+		nydusifyUtils.ManifestNydusFsAlignChunk:       "true",
+		nydusifyUtils.ManifestNydusCompressor:         "zstd",
+		nydusifyUtils.ManifestNydusChunkSize:          "0x100000",
+		nydusifyUtils.ManifestNydusBatchSize:          "0",
+		nydusifyUtils.ManifestNydusChunkDictReference: "nydus/dict:latest",
+		nydusifyUtils.ManifestNydusChunkDictDigest:    digest.FromString("dict").String(),
+	}, optionAnnotations(Opt{
+		FsAlignChunk: true,
+		Compressor:   "zstd",
+		ChunkSize:    "0x100000",
+		BatchSize:    "0",
+		ChunkDictRef: "nydus/dict:latest",
+	}, digest.FromString("dict").String()))
+}
+
+func TestOptionAnnotator(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	ociDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	nydusDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Annotations: map[string]string{
+			annotationSourceDigest: ociDesc.Digest.String(),
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	indexDesc, err := utils.WriteJSON(ctx, cs, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{*ociDesc, *nydusDesc},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}, "", nil)
+	require.NoError(t, err)
+
+	annotator, err := newOptionAnnotator(pvd, map[string]string{
+		nydusifyUtils.ManifestNydusCompressor: "zstd",
+	}, "nydus/test:latest")
+	require.NoError(t, err)
+
+	// Other references are ignored.
+	newDesc, err := annotator.beforePush(ctx, *indexDesc, "docker.io/nydus/cache:latest")
+	require.NoError(t, err)
+	require.Equal(t, *indexDesc, *newDesc)
+
+	newDesc, err = annotator.beforePush(ctx, *indexDesc, "docker.io/nydus/test:latest")
+	require.NoError(t, err)
+
+	var index ocispec.Index
+	_, err = utils.ReadJSON(ctx, cs, &index, *newDesc)
+	require.NoError(t, err)
+	// The OCI manifest in merged index is unchanged.
+	require.Equal(t, *ociDesc, index.Manifests[0])
+	var manifest ocispec.Manifest
+	_, err = utils.ReadJSON(ctx, cs, &manifest, index.Manifests[1])
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		annotationSourceDigest:                ociDesc.Digest.String(),
+		nydusifyUtils.ManifestNydusCompressor: "zstd",
+	}, manifest.Annotations)
+}
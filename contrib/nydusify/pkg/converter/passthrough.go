@@ -0,0 +1,63 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+// sourceThroughSuffix is the tag suffix of the source image pushed through
+// to the target repository.
+const sourceThroughSuffix = "oci"
+
+// sourceThroughReference returns the reference of the source image pushed
+// through to the target repository, it's tagged with the suffix of the
+// target tag, for example `repo:tag` -> `repo:tag-oci`.
+func sourceThroughReference(target string) (string, error) {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return "", errors.Wrap(err, "parse target reference")
+	}
+	tagged, ok := named.(docker.Tagged)
+	if !ok {
+		return "", fmt.Errorf("target reference %s should be tagged to push source image through", target)
+	}
+	return fmt.Sprintf("%s:%s-%s", docker.TrimNamed(named).String(), tagged.Tag(), sourceThroughSuffix), nil
+}
+
+// checkSourceThrough validates the options of pushing source image through
+// and returns the reference to push to.
+func checkSourceThrough(opt Opt, targetIsRegistry bool) (string, error) {
+	if !targetIsRegistry {
+		return "", errors.New("source image can only be pushed through to target registry")
+	}
+	if opt.MergePlatform {
+		return "", errors.New("source image is already pushed with merged platforms, `--push-source-through` is redundant")
+	}
+	return sourceThroughReference(opt.Target)
+}
+
+// pushSourceThrough mirrors the source image with all platforms as-is to
+// the target repository, for the registries that must hold both formats
+// for the runtimes falling back to OCI image.
+func pushSourceThrough(ctx context.Context, pvd *provider.Provider, store content.Store, source, ref string) error {
+	start := time.Now()
+	logrus.Infof("pushing source image %s through to %s", source, ref)
+	desc, err := pvd.Mirror(ctx, store, source, ref)
+	if err != nil {
+		return errors.Wrap(err, "push source image through")
+	}
+	logrus.Infof("pushed source image %s through to %s@%s, elapsed: %s", source, ref, desc.Digest, time.Since(start))
+	return nil
+}
@@ -0,0 +1,30 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSourceThrough(t *testing.T) {
+	ref, err := checkSourceThrough(Opt{Target: "nydus/test:latest-nydus"}, true)
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/nydus/test:latest-nydus-oci", ref)
+
+	ref, err = checkSourceThrough(Opt{Target: "localhost:5000/nydus/test"}, true)
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nydus/test:latest-oci", ref)
+
+	_, err = checkSourceThrough(Opt{Target: "nydus/test@sha256:aec98c9e3dce739877b8f5fe1cddd339de1db2b36c20995d76f6265056dbdb08"}, true)
+	require.ErrorContains(t, err, "should be tagged")
+
+	_, err = checkSourceThrough(Opt{Target: "nydus/test:latest", MergePlatform: true}, true)
+	require.ErrorContains(t, err, "redundant")
+
+	_, err = checkSourceThrough(Opt{Target: "nydus/test:latest"}, false)
+	require.ErrorContains(t, err, "target registry")
+}
@@ -0,0 +1,232 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// platformFiller propagates the platform fields from image configs into
+// the index of converted image before it's pushed to target, the source
+// index may miss some of them, for example the variant or os version, so
+// that the nydus manifests can't be selected correctly by runtime.
+type platformFiller struct {
+	pvd    *provider.Provider
+	target string
+}
+
+func newPlatformFiller(pvd *provider.Provider, target string) (*platformFiller, error) {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	return &platformFiller{
+		pvd:    pvd,
+		target: named.String(),
+	}, nil
+}
+
+func (filler *platformFiller) hook() provider.PushHook {
+	return provider.PushHook{
+		BeforePush: filler.beforePush,
+	}
+}
+
+func (filler *platformFiller) beforePush(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+	if ref != filler.target || !images.IsIndexType(desc.MediaType) {
+		return &desc, nil
+	}
+	return filler.fill(ctx, desc)
+}
+
+func (filler *platformFiller) fill(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	cs := filler.pvd.ContentStore()
+
+	var index ocispec.Index
+	if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+		return nil, errors.Wrap(err, "read index json")
+	}
+
+	changed := false
+	for idx, manifestDesc := range index.Manifests {
+		if !images.IsManifestType(manifestDesc.MediaType) {
+			continue
+		}
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, manifestDesc); err != nil {
+			return nil, errors.Wrap(err, "read manifest json")
+		}
+		// Skip the artifacts referring to image, e.g. nydus bootstrap.
+		if !images.IsConfigType(manifest.Config.MediaType) {
+			continue
+		}
+		var config ocispec.Image
+		if _, err := utils.ReadJSON(ctx, cs, &config, manifest.Config); err != nil {
+			return nil, errors.Wrap(err, "read image config")
+		}
+
+		platform := fillPlatform(manifestDesc.Platform, config)
+		if platform != nil && !reflect.DeepEqual(platform, manifestDesc.Platform) {
+			index.Manifests[idx].Platform = platform
+			changed = true
+		}
+	}
+	if !changed {
+		return &desc, nil
+	}
+
+	newDesc, err := filler.pvd.WriteJSON(ctx, index, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "write index json")
+	}
+	return newDesc, nil
+}
+
+// fillPlatform fills the missing fields of platform from image config, the
+// existing fields are kept, and the os features are merged, for example
+// the `nydus.remoteimage.v1` feature set by conversion.
+func fillPlatform(platform *ocispec.Platform, config ocispec.Image) *ocispec.Platform {
+	if config.OS == "" || config.Architecture == "" {
+		return platform
+	}
+
+	filled := ocispec.Platform{}
+	if platform != nil {
+		filled = *platform
+		filled.OSFeatures = append([]string{}, platform.OSFeatures...)
+	}
+	if filled.OS == "" {
+		filled.OS = config.OS
+	}
+	if filled.Architecture == "" {
+		filled.Architecture = config.Architecture
+	}
+	// Don't take the variant of another architecture.
+	if filled.Variant == "" && filled.Architecture == config.Architecture {
+		filled.Variant = config.Variant
+	}
+	if filled.OSVersion == "" && filled.OS == config.OS {
+		filled.OSVersion = config.OSVersion
+	}
+	for _, feature := range config.OSFeatures {
+		found := false
+		for _, existing := range filled.OSFeatures {
+			if existing == feature {
+				found = true
+				break
+			}
+		}
+		if !found {
+			filled.OSFeatures = append(filled.OSFeatures, feature)
+		}
+	}
+	if len(filled.OSFeatures) == 0 {
+		filled.OSFeatures = nil
+	}
+
+	return &filled
+}
+
+func addPlatformFiller(pvd *provider.Provider, target string) error {
+	filler, err := newPlatformFiller(pvd, target)
+	if err != nil {
+		return err
+	}
+	pvd.AddPushHook(filler.hook())
+	return nil
+}
+
+// sourceIndex returns the index of source image, nil if the source image
+// is a single manifest. The source image is read from content store if
+// it's loaded from local transport, or fetched from registry.
+func sourceIndex(ctx context.Context, pvd *provider.Provider, ref string) (*ocispec.Index, error) {
+	var index ocispec.Index
+	if desc, err := pvd.Image(ctx, ref); err == nil {
+		if !images.IsIndexType(desc.MediaType) {
+			return nil, nil
+		}
+		if _, err := utils.ReadJSON(ctx, pvd.ContentStore(), &index, *desc); err != nil {
+			return nil, errors.Wrap(err, "read source index")
+		}
+		return &index, nil
+	}
+
+	resolver, err := pvd.Resolver(ref)
+	if err != nil {
+		return nil, err
+	}
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve %s", ref)
+	}
+	if !images.IsIndexType(desc.MediaType) {
+		return nil, nil
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get fetcher")
+	}
+	if err := fetchJSON(ctx, fetcher, desc, &index); err != nil {
+		return nil, errors.Wrap(err, "fetch source index")
+	}
+	return &index, nil
+}
+
+// checkSourcePlatforms checks the platforms selected from source index
+// before conversion, rather than pushing an empty target index if none is
+// matched. The selected platforms missing in source index are warned.
+func checkSourcePlatforms(ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, opt Opt) error {
+	index, err := sourceIndex(ctx, pvd, opt.Source)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to check platforms of source image")
+		return nil
+	}
+	if index == nil {
+		return nil
+	}
+
+	available := []ocispec.Platform{}
+	selected := []string{}
+	for _, manifest := range index.Manifests {
+		if manifest.Platform == nil || !images.IsManifestType(manifest.MediaType) {
+			continue
+		}
+		available = append(available, *manifest.Platform)
+		if platformMC.Match(*manifest.Platform) {
+			selected = append(selected, platforms.Format(*manifest.Platform))
+		}
+	}
+	unmatched, err := nydusifyUtils.UnmatchedPlatforms(opt.AllPlatforms, opt.Platforms, available)
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		formatted := []string{}
+		for _, platform := range available {
+			formatted = append(formatted, platforms.Format(platform))
+		}
+		return fmt.Errorf("no platform %s is found in source index, available platforms: %s",
+			strings.Join(unmatched, ","), strings.Join(formatted, ","))
+	}
+	if len(unmatched) > 0 {
+		logrus.Warnf("platforms %s are not found in source index", strings.Join(unmatched, ","))
+	}
+	logrus.Infof("converting platforms %s of source index", strings.Join(selected, ","))
+	return nil
+}
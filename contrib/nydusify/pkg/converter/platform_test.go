@@ -0,0 +1,161 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestFillPlatform(t *testing.T) {
+	config := ocispec.Image{
+		Platform: ocispec.Platform{
+			OS:           "linux",
+			Architecture: "arm",
+			Variant:      "v7",
+		},
+	}
+	require.Equal(t, &ocispec.Platform{
+		OS:           "linux",
+		Architecture: "arm",
+		Variant:      "v7",
+		OSFeatures:   []string{"nydus.remoteimage.v1"},
+	}, fillPlatform(&ocispec.Platform{
+		OS:           "linux",
+		Architecture: "arm",
+		OSFeatures:   []string{"nydus.remoteimage.v1"},
+	}, config))
+	require.Equal(t, &ocispec.Platform{
+		OS:           "linux",
+		Architecture: "arm",
+		Variant:      "v7",
+	}, fillPlatform(nil, config))
+
+	// The existing fields are kept.
+	platform := &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	require.Equal(t, platform, fillPlatform(platform, config))
+
+	// The config without platform is ignored.
+	require.Nil(t, fillPlatform(nil, ocispec.Image{}))
+}
+
+func TestPlatformFiller(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	configDesc, err := utils.WriteJSON(ctx, cs, ocispec.Image{
+		Platform: ocispec.Platform{
+			OS:           "windows",
+			Architecture: "amd64",
+			OSVersion:    "10.0.17763.5122",
+			OSFeatures:   []string{"win32k"},
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig}, "", nil)
+	require.NoError(t, err)
+	manifestDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    *configDesc,
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	manifestDesc.Platform = &ocispec.Platform{
+		OS:           "windows",
+		Architecture: "amd64",
+		OSFeatures:   []string{"nydus.remoteimage.v1"},
+	}
+	indexDesc, err := utils.WriteJSON(ctx, cs, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{*manifestDesc},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}, "", nil)
+	require.NoError(t, err)
+
+	filler, err := newPlatformFiller(pvd, "nydus/test:latest")
+	require.NoError(t, err)
+
+	// Other references and manifests are ignored.
+	newDesc, err := filler.beforePush(ctx, *indexDesc, "docker.io/nydus/cache:latest")
+	require.NoError(t, err)
+	require.Equal(t, *indexDesc, *newDesc)
+	newDesc, err = filler.beforePush(ctx, *manifestDesc, "docker.io/nydus/test:latest")
+	require.NoError(t, err)
+	require.Equal(t, *manifestDesc, *newDesc)
+
+	newDesc, err = filler.beforePush(ctx, *indexDesc, "docker.io/nydus/test:latest")
+	require.NoError(t, err)
+	require.NotEqual(t, indexDesc.Digest, newDesc.Digest)
+
+	var index ocispec.Index
+	_, err = utils.ReadJSON(ctx, cs, &index, *newDesc)
+	require.NoError(t, err)
+	require.Equal(t, &ocispec.Platform{
+		OS:           "windows",
+		Architecture: "amd64",
+		OSVersion:    "10.0.17763.5122",
+		OSFeatures:   []string{"nydus.remoteimage.v1", "win32k"},
+	}, index.Manifests[0].Platform)
+
+	// The index is not rewritten if nothing changed.
+	filledDesc, err := filler.beforePush(ctx, *newDesc, "docker.io/nydus/test:latest")
+	require.NoError(t, err)
+	require.Equal(t, newDesc.Digest, filledDesc.Digest)
+}
+
+func TestCheckSourcePlatforms(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	manifests := []ocispec.Descriptor{}
+	for _, platform := range []ocispec.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64"},
+		// The attestation manifest of buildx.
+		{OS: "unknown", Architecture: "unknown"},
+	} {
+		desc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+		}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+		require.NoError(t, err)
+		platform := platform
+		desc.Platform = &platform
+		manifests = append(manifests, *desc)
+	}
+	indexDesc, err := utils.WriteJSON(ctx, cs, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: manifests,
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}, "", nil)
+	require.NoError(t, err)
+	pvd.AddImage("localhost/app:latest", *indexDesc)
+
+	check := func(opt Opt) error {
+		opt.Source = "localhost/app:latest"
+		platformMC, err := nydusifyUtils.ParsePlatforms(opt.AllPlatforms, opt.Platforms)
+		require.NoError(t, err)
+		return checkSourcePlatforms(ctx, pvd, platformMC, opt)
+	}
+	require.NoError(t, check(Opt{AllPlatforms: true}))
+	require.NoError(t, check(Opt{Platforms: "linux/arm64,linux/s390x"}))
+	require.ErrorContains(t, check(Opt{Platforms: "linux/s390x,linux/ppc64le"}),
+		"no platform linux/s390x,linux/ppc64le is found in source index, available platforms: linux/amd64,linux/arm64,unknown/unknown")
+
+	// The single manifest isn't checked.
+	pvd.AddImage("localhost/app:latest", manifests[0])
+	require.NoError(t, check(Opt{Platforms: "linux/s390x"}))
+}
@@ -0,0 +1,262 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/transport"
+)
+
+// Policy maps the image name patterns to conversion options, so that the
+// fleet-wide conversion standards are declared in one place rather than
+// in the options of each conversion.
+type Policy struct {
+	// Allow and Deny are the image name patterns in the syntax of
+	// PolicyRule.Pattern, the images matching Deny are refused to be
+	// converted or pushed, and only the images matching Allow are accepted
+	// if it's not empty, so that a shared conversion service can't be used
+	// to mirror unauthorized images.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	// Rules are matched in order, the first matched rule is applied.
+	Rules []PolicyRule `json:"rules"`
+}
+
+// ErrDenied is returned if the image is refused by the allowlist or
+// denylist of policy.
+var ErrDenied = errors.New("denied by policy")
+
+// PolicyRule declares the conversion options for the images matching the
+// pattern, the empty options are left unchanged.
+type PolicyRule struct {
+	// Pattern matches the full or familiar image name without tag and
+	// digest, e.g. `docker.io/library/nginx` or `nginx`, in the syntax of
+	// path.Match, and the trailing `/**` matches all repositories under the
+	// namespace.
+	Pattern string `json:"pattern"`
+
+	ChunkSize  string `json:"chunk_size,omitempty"`
+	FsVersion  string `json:"fs_version,omitempty"`
+	Compressor string `json:"compressor,omitempty"`
+	// PrefetchFile is the file of prefetch patterns, the relative path is
+	// resolved against the directory of policy file.
+	PrefetchFile string `json:"prefetch_file,omitempty"`
+	// BackendType and BackendConfigFile specify the storage backend to push
+	// blobs, the relative path is resolved against the directory of policy
+	// file.
+	BackendType       string `json:"backend_type,omitempty"`
+	BackendConfigFile string `json:"backend_config_file,omitempty"`
+
+	prefetchPatterns string
+	backendConfig    string
+}
+
+// LoadPolicy loads the conversion policy from json file, the referenced
+// prefetch and backend config files are loaded as well.
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read policy file")
+	}
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, errors.Wrap(err, "unmarshal policy file")
+	}
+
+	resolve := func(name string) string {
+		if filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(filepath.Dir(file), name)
+	}
+	for _, list := range []struct {
+		name     string
+		patterns []string
+	}{{"allow", policy.Allow}, {"deny", policy.Deny}} {
+		for _, pattern := range list.patterns {
+			if !validPattern(pattern) {
+				return nil, fmt.Errorf("invalid %s pattern %q of policy", list.name, pattern)
+			}
+		}
+	}
+	for idx := range policy.Rules {
+		rule := &policy.Rules[idx]
+		if !validPattern(rule.Pattern) {
+			return nil, fmt.Errorf("invalid pattern %q of policy rule %d", rule.Pattern, idx)
+		}
+		if rule.FsVersion != "" && rule.FsVersion != "5" && rule.FsVersion != "6" {
+			return nil, fmt.Errorf("invalid fs version %q of policy rule %d", rule.FsVersion, idx)
+		}
+		if rule.PrefetchFile != "" {
+			patterns, err := os.ReadFile(resolve(rule.PrefetchFile))
+			if err != nil {
+				return nil, errors.Wrapf(err, "read prefetch file of policy rule %d", idx)
+			}
+			rule.prefetchPatterns = string(patterns)
+		}
+		if rule.BackendConfigFile != "" {
+			if rule.BackendType == "" {
+				return nil, fmt.Errorf("backend type of policy rule %d is required with backend config", idx)
+			}
+			config, err := os.ReadFile(resolve(rule.BackendConfigFile))
+			if err != nil {
+				return nil, errors.Wrapf(err, "read backend config file of policy rule %d", idx)
+			}
+			rule.backendConfig = string(config)
+		} else if rule.BackendType != "" {
+			return nil, fmt.Errorf("backend config of policy rule %d is required with backend type", idx)
+		}
+	}
+
+	return &policy, nil
+}
+
+func validPattern(pattern string) bool {
+	_, err := path.Match(strings.TrimSuffix(pattern, "/**"), "")
+	return err == nil && pattern != ""
+}
+
+// matchPattern matches the full or familiar image name with pattern.
+func matchPattern(pattern, name, familiarName string) bool {
+	for _, n := range []string{name, familiarName} {
+		if prefix := strings.TrimSuffix(pattern, "/**"); prefix != pattern {
+			if n == prefix || strings.HasPrefix(n, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, n); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *PolicyRule) match(name, familiarName string) bool {
+	return matchPattern(rule.Pattern, name, familiarName)
+}
+
+func (rule *PolicyRule) apply(opt *Opt) {
+	if rule.ChunkSize != "" {
+		opt.ChunkSize = rule.ChunkSize
+	}
+	if rule.FsVersion != "" {
+		opt.FsVersion = rule.FsVersion
+	}
+	if rule.Compressor != "" {
+		opt.Compressor = rule.Compressor
+	}
+	if rule.PrefetchFile != "" {
+		opt.PrefetchPatterns = rule.prefetchPatterns
+	}
+	if rule.BackendType != "" {
+		opt.BackendType = rule.BackendType
+		opt.BackendConfig = rule.backendConfig
+	}
+}
+
+// imageName parses the image name of reference, nil is returned if the
+// image in local transport has no name.
+func imageName(ref string) (docker.Named, error) {
+	parsed, err := transport.Parse(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse reference")
+	}
+	if parsed.Name == "" {
+		return nil, nil
+	}
+	named, err := docker.ParseDockerRef(parsed.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse image name %s", parsed.Name)
+	}
+	return named, nil
+}
+
+// Permit returns ErrDenied if the image of reference matches the denylist,
+// or doesn't match the allowlist if it's not empty. The image in local
+// transport without name is always permitted.
+func (policy *Policy) Permit(ref string) error {
+	if policy == nil || (len(policy.Allow) == 0 && len(policy.Deny) == 0) {
+		return nil
+	}
+	named, err := imageName(ref)
+	if err != nil {
+		return err
+	}
+	if named == nil {
+		return nil
+	}
+	name, familiarName := named.Name(), docker.FamiliarName(named)
+	for _, pattern := range policy.Deny {
+		if matchPattern(pattern, name, familiarName) {
+			return errors.Wrapf(ErrDenied, "image %s matches denied pattern %s", name, pattern)
+		}
+	}
+	if len(policy.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range policy.Allow {
+		if matchPattern(pattern, name, familiarName) {
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrDenied, "image %s matches no allowed pattern", name)
+}
+
+// Match returns the first rule matching the source image, or nil if no
+// rule matched or the source image has no name.
+func (policy *Policy) Match(source string) (*PolicyRule, error) {
+	named, err := imageName(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse source")
+	}
+	if named == nil {
+		return nil, nil
+	}
+	for idx := range policy.Rules {
+		if policy.Rules[idx].match(named.Name(), docker.FamiliarName(named)) {
+			return &policy.Rules[idx], nil
+		}
+	}
+	return nil, nil
+}
+
+// applyPolicy refuses the source, target and cache images denied by policy,
+// and overrides the conversion options by the policy rule matching source
+// image.
+func applyPolicy(opt *Opt) error {
+	if opt.Policy == nil {
+		return nil
+	}
+	// The images pushed along with target are in the target repository.
+	for _, ref := range []string{opt.Source, opt.Target, opt.CacheRef} {
+		if ref == "" {
+			continue
+		}
+		if err := opt.Policy.Permit(ref); err != nil {
+			return err
+		}
+	}
+	rule, err := opt.Policy.Match(opt.Source)
+	if err != nil {
+		return errors.Wrap(err, "match policy")
+	}
+	if rule == nil {
+		return nil
+	}
+	logrus.Infof("applying policy rule %s to %s", rule.Pattern, opt.Source)
+	rule.apply(opt)
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "prefetch"), []byte("/usr/bin\n/lib\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "oss.json"), []byte(`{"bucket_name": "nydus"}`), 0644))
+	path := filepath.Join(dir, "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"rules": [
+			{"pattern": "docker.io/library/*", "fs_version": "6", "prefetch_file": "prefetch"},
+			{"pattern": "myregistry.com/ai/**", "chunk_size": "0x400000", "backend_type": "oss", "backend_config_file": "oss.json"},
+			{"pattern": "*/*/*", "compressor": "lz4_block"}
+		]
+	}`), 0644))
+	policy, err := LoadPolicy(path)
+	require.NoError(t, err)
+
+	// The first matched rule is applied.
+	opt := Opt{Source: "nginx:latest", FsVersion: "5", ChunkSize: "0x100000"}
+	require.NoError(t, applyPolicy(&Opt{Source: "nginx:latest"}))
+	opt.Policy = policy
+	require.NoError(t, applyPolicy(&opt))
+	require.Equal(t, "6", opt.FsVersion)
+	require.Equal(t, "0x100000", opt.ChunkSize)
+	require.Equal(t, "/usr/bin\n/lib\n", opt.PrefetchPatterns)
+	require.Empty(t, opt.Compressor)
+
+	opt = Opt{Source: "myregistry.com/ai/models/llama@sha256:" + strings.Repeat("0", 64), Policy: policy}
+	require.NoError(t, applyPolicy(&opt))
+	require.Equal(t, "0x400000", opt.ChunkSize)
+	require.Equal(t, "oss", opt.BackendType)
+	require.Equal(t, `{"bucket_name": "nydus"}`, opt.BackendConfig)
+
+	opt = Opt{Source: "docker-archive:/tmp/image.tar:myregistry.com/team/app:v1", Policy: policy}
+	require.NoError(t, applyPolicy(&opt))
+	require.Equal(t, "lz4_block", opt.Compressor)
+
+	// The local source without name and unmatched source are unchanged.
+	for _, source := range []string{"oci:/tmp/layout", "myregistry.com/app:v1"} {
+		opt = Opt{Source: source, Policy: policy}
+		require.NoError(t, applyPolicy(&opt))
+		require.Equal(t, Opt{Source: source, Policy: policy}, opt)
+	}
+
+	for _, content := range []string{
+		`{`,
+		`{"rules": [{"pattern": "[", "fs_version": "6"}]}`,
+		`{"rules": [{"pattern": "*", "fs_version": "7"}]}`,
+		`{"rules": [{"pattern": "*", "backend_type": "oss"}]}`,
+		`{"rules": [{"pattern": "*", "prefetch_file": "missing"}]}`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		_, err = LoadPolicy(path)
+		require.Error(t, err, content)
+	}
+}
+
+func TestPolicyPermit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"allow": ["docker.io/library/*", "myregistry.com/**"],
+		"deny": ["myregistry.com/secret/**"]
+	}`), 0644))
+	policy, err := LoadPolicy(path)
+	require.NoError(t, err)
+
+	for _, ref := range []string{
+		"nginx:latest",
+		"docker.io/library/busybox@sha256:" + strings.Repeat("0", 64),
+		"myregistry.com/team/app:v1",
+		"docker-archive:/tmp/image.tar:myregistry.com/team/app:v1",
+		// The local image without name is always permitted.
+		"oci:/tmp/layout",
+	} {
+		require.NoError(t, policy.Permit(ref), ref)
+	}
+	for _, ref := range []string{"myregistry.com/secret/app:v1", "quay.io/org/app:v1", "docker.io/org/app:v1"} {
+		err := policy.Permit(ref)
+		require.ErrorIs(t, err, ErrDenied, ref)
+	}
+	require.NoError(t, (*Policy)(nil).Permit("quay.io/org/app:v1"))
+
+	// The source, target and cache images are all checked.
+	opt := Opt{Source: "nginx:latest", Target: "myregistry.com/team/nginx:latest-nydus", Policy: policy}
+	require.NoError(t, applyPolicy(&opt))
+	opt.CacheRef = "myregistry.com/secret/cache:latest"
+	require.ErrorContains(t, applyPolicy(&opt), "matches denied pattern myregistry.com/secret/**")
+	opt = Opt{Source: "nginx:latest", Target: "quay.io/org/nginx:latest-nydus", Policy: policy}
+	require.ErrorContains(t, applyPolicy(&opt), "matches no allowed pattern")
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"deny": ["["]}`), 0644))
+	_, err = LoadPolicy(path)
+	require.ErrorContains(t, err, "invalid deny pattern")
+}
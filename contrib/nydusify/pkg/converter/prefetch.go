@@ -0,0 +1,142 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/platforms"
+	accelUtils "github.com/goharbor/acceleration-service/pkg/utils"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// defaultPrefetchPatterns prefetches the whole filesystem, which is used if
+// nothing is inferred.
+const defaultPrefetchPatterns = "/"
+
+type decompressedReader struct {
+	io.ReadCloser
+	ra content.ReaderAt
+}
+
+func (reader *decompressedReader) Close() error {
+	reader.ReadCloser.Close()
+	return reader.ra.Close()
+}
+
+func layerOpener(ctx context.Context, cs content.Store, desc ocispec.Descriptor) nydusifyUtils.LayerOpener {
+	return func() (io.ReadCloser, error) {
+		ra, err := cs.ReaderAt(ctx, desc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "open layer %s", desc.Digest)
+		}
+		reader, err := DecompressStream(content.NewReader(ra))
+		if err != nil {
+			ra.Close()
+			return nil, errors.Wrapf(err, "decompress layer %s", desc.Digest)
+		}
+		return &decompressedReader{ReadCloser: reader, ra: ra}, nil
+	}
+}
+
+// inferManifestPrefetch infers the prefetch patterns of the image manifest,
+// nothing is inferred for the image already in Nydus format.
+func inferManifestPrefetch(ctx context.Context, cs content.Store, desc ocispec.Descriptor) ([]string, error) {
+	var manifest ocispec.Manifest
+	if err := readJSON(ctx, cs, desc, &manifest); err != nil {
+		return nil, errors.Wrap(err, "read manifest")
+	}
+	var config ocispec.Image
+	if err := readJSON(ctx, cs, manifest.Config, &config); err != nil {
+		return nil, errors.Wrap(err, "read image config")
+	}
+	layers := []nydusifyUtils.LayerOpener{}
+	for _, layer := range manifest.Layers {
+		if !isSourceLayer(layer) {
+			return nil, nil
+		}
+		layers = append(layers, layerOpener(ctx, cs, layer))
+	}
+	return nydusifyUtils.InferPrefetchPatterns(config.Config, layers)
+}
+
+func readJSON(ctx context.Context, cs content.Store, desc ocispec.Descriptor, v interface{}) error {
+	data, err := content.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// inferPrefetchPatterns pulls the source image and infers the prefetch
+// patterns by heuristics, the patterns of all platforms are merged as the
+// prefetch patterns are shared by the platforms.
+func inferPrefetchPatterns(ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, ref string) (string, error) {
+	var image *ocispec.Descriptor
+	if err := withHTTPRetry(pvd, func() error {
+		if err := pvd.Pull(ctx, ref); err != nil {
+			return err
+		}
+		var err error
+		image, err = pvd.Image(ctx, ref)
+		return err
+	}); err != nil {
+		return "", errors.Wrap(err, "pull image")
+	}
+	// The image is converted from the content store without pulling again.
+	pvd.AddImage(ref, *image)
+
+	cs := pvd.ContentStore()
+	manifests, err := accelUtils.GetManifests(ctx, cs, *image, platformMC)
+	if err != nil {
+		return "", errors.Wrap(err, "get image manifests")
+	}
+	merged := map[string]bool{}
+	for _, manifest := range manifests {
+		paths, err := inferManifestPrefetch(ctx, cs, manifest)
+		if err != nil {
+			return "", errors.Wrapf(err, "infer prefetch patterns of manifest %s", manifest.Digest)
+		}
+		for _, p := range paths {
+			merged[p] = true
+		}
+	}
+
+	paths := make([]string, 0, len(merged))
+	for p := range merged {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, "\n"), nil
+}
+
+// applyPrefetchHeuristic infers the prefetch patterns if they're neither
+// specified by option nor by policy, the whole filesystem is prefetched if
+// the inference fails or nothing is inferred.
+func applyPrefetchHeuristic(ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, opt *Opt) {
+	if !opt.PrefetchHeuristic || opt.PrefetchPatterns != "" {
+		return
+	}
+	patterns, err := inferPrefetchPatterns(ctx, pvd, platformMC, opt.Source)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to infer prefetch patterns, prefetch the whole filesystem")
+	}
+	if patterns == "" {
+		patterns = defaultPrefetchPatterns
+	} else {
+		logrus.Infof("inferred %d prefetch patterns for %s", strings.Count(patterns, "\n")+1, opt.Source)
+	}
+	opt.PrefetchPatterns = patterns
+}
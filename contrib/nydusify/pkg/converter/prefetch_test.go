@@ -0,0 +1,82 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+func TestApplyPrefetchHeuristic(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	write := func(mediaType string, data []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+		require.NoError(t, content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(data), desc))
+		return desc
+	}
+	writeJSON := func(mediaType string, v interface{}) ocispec.Descriptor {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return write(mediaType, data)
+	}
+
+	layerData := &bytes.Buffer{}
+	gw := gzip.NewWriter(layerData)
+	tw := tar.NewWriter(gw)
+	for name, data := range map[string]string{
+		"bin/sh":         "not elf",
+		"usr/bin/server": "#!/bin/sh\nexec true\n",
+		"etc/passwd":     "root:x:0:0",
+		"var/lib/data":   "data",
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(data))}))
+		_, err := tw.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	layer := write(ocispec.MediaTypeImageLayerGzip, layerData.Bytes())
+	config := writeJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+		Config: ocispec.ImageConfig{Entrypoint: []string{"server"}},
+	})
+	manifest := writeJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	pvd.AddImage("localhost/app:latest", manifest)
+
+	opt := Opt{Source: "localhost/app:latest", PrefetchPatterns: "/etc", PrefetchHeuristic: true}
+	applyPrefetchHeuristic(ctx, pvd, platforms.All, &opt)
+	require.Equal(t, "/etc", opt.PrefetchPatterns)
+
+	opt.PrefetchPatterns = ""
+	applyPrefetchHeuristic(ctx, pvd, platforms.All, &opt)
+	require.Equal(t, "/bin/sh\n/etc/passwd\n/usr/bin/server", opt.PrefetchPatterns)
+
+	// The whole filesystem is prefetched if the inference fails.
+	opt = Opt{Source: "localhost/missing:latest", PrefetchHeuristic: true}
+	pvd.AddImage(opt.Source, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("missing")})
+	applyPrefetchHeuristic(ctx, pvd, platforms.All, &opt)
+	require.Equal(t, defaultPrefetchPatterns, opt.PrefetchPatterns)
+}
@@ -0,0 +1,264 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+// skippedConfigFields are the fields of source image config not restored
+// to the converted config, they describe the legacy build container and
+// the parent image rather than the container to run.
+var skippedConfigFields = map[string]bool{
+	"container":        true,
+	"container_config": true,
+	"image":            true,
+}
+
+// sourcePreserver restores the annotations and config fields of source
+// image dropped by conversion before the image is pushed to target, for
+// example the annotations of image index consumed by Kata Containers and
+// confidential containers, or the Docker fields like `Healthcheck` not
+// modeled by OCI image config. The existing values of converted image
+// are never overwritten, so that the mutation and Nydus annotations take
+// precedence.
+type sourcePreserver struct {
+	pvd    *provider.Provider
+	source string
+	target string
+}
+
+func newSourcePreserver(pvd *provider.Provider, source, target string) (*sourcePreserver, error) {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	return &sourcePreserver{
+		pvd:    pvd,
+		source: source,
+		target: named.String(),
+	}, nil
+}
+
+func (preserver *sourcePreserver) hook() provider.PushHook {
+	return provider.PushHook{
+		BeforePush: preserver.beforePush,
+	}
+}
+
+func (preserver *sourcePreserver) beforePush(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+	if ref != preserver.target {
+		return &desc, nil
+	}
+	return preserver.preserve(ctx, desc)
+}
+
+func (preserver *sourcePreserver) preserve(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	cs := preserver.pvd.ContentStore()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return nil, errors.Wrap(err, "read index json")
+		}
+		changed := false
+		source, err := sourceIndex(ctx, preserver.pvd, preserver.source)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to preserve annotations of source index")
+		} else if source != nil && mergeAnnotations(&index.Annotations, source.Annotations) {
+			changed = true
+		}
+		for idx := range index.Manifests {
+			newDesc, err := preserver.preserve(ctx, index.Manifests[idx])
+			if err != nil {
+				return nil, err
+			}
+			if newDesc.Digest != index.Manifests[idx].Digest {
+				index.Manifests[idx] = *newDesc
+				changed = true
+			}
+		}
+		if !changed {
+			return &desc, nil
+		}
+		return preserver.pvd.WriteJSON(ctx, index, desc)
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return nil, errors.Wrap(err, "read manifest json")
+		}
+		sourceDigest := manifest.Annotations[annotationSourceDigest]
+		if sourceDigest == "" {
+			// Skip the OCI manifest in merged index.
+			return &desc, nil
+		}
+		sourceDesc, err := localDescriptor(ctx, cs, digest.Digest(sourceDigest))
+		if err != nil {
+			logrus.WithError(err).Warnf("failed to preserve annotations and config of source manifest %s", sourceDigest)
+			return &desc, nil
+		}
+		var sourceManifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &sourceManifest, *sourceDesc); err != nil {
+			return nil, errors.Wrap(err, "read source manifest json")
+		}
+
+		changed := mergeAnnotations(&manifest.Annotations, sourceManifest.Annotations)
+		configDesc, err := preserver.preserveConfig(ctx, manifest.Config, sourceManifest.Config)
+		if err != nil {
+			return nil, err
+		}
+		if configDesc.Digest != manifest.Config.Digest {
+			manifest.Config = *configDesc
+			changed = true
+		}
+		if !changed {
+			return &desc, nil
+		}
+		newDesc, err := preserver.pvd.WriteJSON(ctx, manifest, desc)
+		if err != nil {
+			return nil, errors.Wrap(err, "write manifest json")
+		}
+		return newDesc, nil
+
+	default:
+		return &desc, nil
+	}
+}
+
+// preserveConfig restores the fields of source config unknown to OCI
+// image config, both at the top level and in the `config` object.
+func (preserver *sourcePreserver) preserveConfig(ctx context.Context, desc, sourceDesc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	cs := preserver.pvd.ContentStore()
+
+	var config, sourceConfig map[string]json.RawMessage
+	if _, err := utils.ReadJSON(ctx, cs, &config, desc); err != nil {
+		return nil, errors.Wrap(err, "read image config")
+	}
+	if _, err := utils.ReadJSON(ctx, cs, &sourceConfig, sourceDesc); err != nil {
+		return nil, errors.Wrap(err, "read source image config")
+	}
+
+	changed := mergeUnknownFields(config, sourceConfig, reflect.TypeOf(ocispec.Image{}))
+
+	var runConfig, sourceRunConfig map[string]json.RawMessage
+	if len(config["config"]) > 0 && len(sourceConfig["config"]) > 0 {
+		if err := json.Unmarshal(config["config"], &runConfig); err != nil {
+			return nil, errors.Wrap(err, "parse image config")
+		}
+		if err := json.Unmarshal(sourceConfig["config"], &sourceRunConfig); err != nil {
+			return nil, errors.Wrap(err, "parse source image config")
+		}
+		if runConfig != nil && mergeUnknownFields(runConfig, sourceRunConfig, reflect.TypeOf(ocispec.ImageConfig{})) {
+			data, err := json.Marshal(runConfig)
+			if err != nil {
+				return nil, errors.Wrap(err, "marshal image config")
+			}
+			config["config"] = data
+			changed = true
+		}
+	}
+
+	if !changed {
+		return &desc, nil
+	}
+	newDesc, err := preserver.pvd.WriteJSON(ctx, config, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "write image config")
+	}
+	return newDesc, nil
+}
+
+// mergeUnknownFields copies the fields of source missing in target and
+// not modeled by the struct type, returns whether target is changed. The
+// fields are matched case-insensitively as encoding/json does.
+func mergeUnknownFields(target, source map[string]json.RawMessage, typ reflect.Type) bool {
+	known := jsonFields(typ)
+	existing := map[string]bool{}
+	for key := range target {
+		existing[strings.ToLower(key)] = true
+	}
+	changed := false
+	for key, value := range source {
+		lower := strings.ToLower(key)
+		if known[lower] || existing[lower] || skippedConfigFields[lower] {
+			continue
+		}
+		target[key] = value
+		changed = true
+	}
+	return changed
+}
+
+// jsonFields returns the lower-cased JSON names of the struct fields,
+// including the ones of embedded structs.
+func jsonFields(typ reflect.Type) map[string]bool {
+	fields := map[string]bool{}
+	for idx := 0; idx < typ.NumField(); idx++ {
+		field := typ.Field(idx)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embedded := range jsonFields(field.Type) {
+				fields[embedded] = true
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = true
+	}
+	return fields
+}
+
+// mergeAnnotations copies the annotations of source missing in target,
+// returns whether target is changed.
+func mergeAnnotations(target *map[string]string, source map[string]string) bool {
+	changed := false
+	for key, value := range source {
+		if _, ok := (*target)[key]; ok {
+			continue
+		}
+		if *target == nil {
+			*target = map[string]string{}
+		}
+		(*target)[key] = value
+		changed = true
+	}
+	return changed
+}
+
+// localDescriptor returns the descriptor of the blob in content store.
+func localDescriptor(ctx context.Context, cs content.Store, dgst digest.Digest) (*ocispec.Descriptor, error) {
+	info, err := cs.Info(ctx, dgst)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get info of %s", dgst)
+	}
+	return &ocispec.Descriptor{Digest: info.Digest, Size: info.Size}, nil
+}
+
+func addSourcePreserver(pvd *provider.Provider, source, target string) error {
+	preserver, err := newSourcePreserver(pvd, source, target)
+	if err != nil {
+		return err
+	}
+	pvd.AddPushHook(preserver.hook())
+	return nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+func writeRawJSON(t *testing.T, ctx context.Context, cs content.Store, data string, mediaType string) ocispec.Descriptor {
+	var v map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(data), &v))
+	desc, err := utils.WriteJSON(ctx, cs, v, ocispec.Descriptor{MediaType: mediaType}, "", nil)
+	require.NoError(t, err)
+	return *desc
+}
+
+func requireJSONEqual(t *testing.T, expected, actual string) {
+	var expectedValue, actualValue interface{}
+	require.NoError(t, json.Unmarshal([]byte(expected), &expectedValue))
+	require.NoError(t, json.Unmarshal([]byte(actual), &actualValue))
+	require.Equal(t, expectedValue, actualValue)
+}
+
+func TestMergeUnknownFields(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		target   string
+		source   string
+		typ      reflect.Type
+		expected string
+		changed  bool
+	}{
+		{
+			name:     "docker run config fields",
+			target:   `{"Env": ["A=a"]}`,
+			source:   `{"Env": ["A=b"], "Healthcheck": {"Test": ["CMD", "true"]}, "Shell": ["/bin/bash", "-c"]}`,
+			typ:      reflect.TypeOf(ocispec.ImageConfig{}),
+			expected: `{"Env": ["A=a"], "Healthcheck": {"Test": ["CMD", "true"]}, "Shell": ["/bin/bash", "-c"]}`,
+			changed:  true,
+		},
+		{
+			name:     "labels removed by mutation",
+			target:   `{"Env": ["A=a"]}`,
+			source:   `{"Labels": {"io.katacontainers.pod": "true"}, "Volumes": {"/data": {}}}`,
+			typ:      reflect.TypeOf(ocispec.ImageConfig{}),
+			expected: `{"Env": ["A=a"]}`,
+		},
+		{
+			name:     "legacy parent image",
+			target:   `{}`,
+			source:   `{"Image": "sha256:parent", "OnBuild": ["RUN true"]}`,
+			typ:      reflect.TypeOf(ocispec.ImageConfig{}),
+			expected: `{"OnBuild": ["RUN true"]}`,
+			changed:  true,
+		},
+		{
+			name:     "custom top level fields",
+			target:   `{"architecture": "amd64", "os": "linux"}`,
+			source:   `{"architecture": "arm64", "os.features": ["sgx"], "container_config": {}, "io.confidentialcontainers.policy": "strict"}`,
+			typ:      reflect.TypeOf(ocispec.Image{}),
+			expected: `{"architecture": "amd64", "os": "linux", "io.confidentialcontainers.policy": "strict"}`,
+			changed:  true,
+		},
+		{
+			name:     "existing field in other case",
+			target:   `{"healthcheck": {"Test": ["NONE"]}}`,
+			source:   `{"Healthcheck": {"Test": ["CMD", "true"]}}`,
+			typ:      reflect.TypeOf(ocispec.ImageConfig{}),
+			expected: `{"healthcheck": {"Test": ["NONE"]}}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var target, source, expected map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal([]byte(tc.target), &target))
+			require.NoError(t, json.Unmarshal([]byte(tc.source), &source))
+			require.NoError(t, json.Unmarshal([]byte(tc.expected), &expected))
+			require.Equal(t, tc.changed, mergeUnknownFields(target, source, tc.typ))
+			require.Equal(t, len(expected), len(target))
+			for key, value := range expected {
+				requireJSONEqual(t, string(value), string(target[key]))
+			}
+		})
+	}
+}
+
+func TestSourcePreserver(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	sourceConfig := writeRawJSON(t, ctx, cs, `{
+		"architecture": "amd64",
+		"os": "linux",
+		"config": {"Env": ["PATH=/usr/bin"], "Healthcheck": {"Test": ["CMD", "true"]}},
+		"rootfs": {"type": "layers", "diff_ids": []}
+	}`, ocispec.MediaTypeImageConfig)
+	sourceManifest, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    sourceConfig,
+		Annotations: map[string]string{
+			"io.katacontainers.config.hypervisor.default_memory": "4096",
+			"org.opencontainers.image.title":                     "source",
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	sourceIndexDesc, err := utils.WriteJSON(ctx, cs, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{*sourceManifest},
+		Annotations: map[string]string{
+			"io.confidentialcontainers.image.encrypted": "false",
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}, "", nil)
+	require.NoError(t, err)
+	pvd.AddImage("source:latest", *sourceIndexDesc)
+
+	// The unknown fields are dropped by the round trip of conversion.
+	config := writeRawJSON(t, ctx, cs, `{
+		"architecture": "amd64",
+		"os": "linux",
+		"config": {"Env": ["PATH=/usr/bin"]},
+		"rootfs": {"type": "layers", "diff_ids": []}
+	}`, ocispec.MediaTypeImageConfig)
+	nydusManifest, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Annotations: map[string]string{
+			annotationSourceDigest:           sourceManifest.Digest.String(),
+			"org.opencontainers.image.title": "converted",
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	targetIndex, err := utils.WriteJSON(ctx, cs, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{*sourceManifest, *nydusManifest},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}, "", nil)
+	require.NoError(t, err)
+
+	preserver, err := newSourcePreserver(pvd, "source:latest", "nydus/test:latest")
+	require.NoError(t, err)
+
+	// Other references are ignored.
+	newDesc, err := preserver.beforePush(ctx, *targetIndex, "docker.io/nydus/cache:latest")
+	require.NoError(t, err)
+	require.Equal(t, *targetIndex, *newDesc)
+
+	newDesc, err = preserver.beforePush(ctx, *targetIndex, "docker.io/nydus/test:latest")
+	require.NoError(t, err)
+	var index ocispec.Index
+	_, err = utils.ReadJSON(ctx, cs, &index, *newDesc)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"io.confidentialcontainers.image.encrypted": "false",
+	}, index.Annotations)
+	// The OCI manifest in merged index is unchanged.
+	require.Equal(t, *sourceManifest, index.Manifests[0])
+
+	var manifest ocispec.Manifest
+	_, err = utils.ReadJSON(ctx, cs, &manifest, index.Manifests[1])
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		annotationSourceDigest:                               sourceManifest.Digest.String(),
+		"io.katacontainers.config.hypervisor.default_memory": "4096",
+		"org.opencontainers.image.title":                     "converted",
+	}, manifest.Annotations)
+	data, err := content.ReadBlob(ctx, cs, manifest.Config)
+	require.NoError(t, err)
+	requireJSONEqual(t, `{
+		"architecture": "amd64",
+		"os": "linux",
+		"config": {"Env": ["PATH=/usr/bin"], "Healthcheck": {"Test": ["CMD", "true"]}},
+		"rootfs": {"type": "layers", "diff_ids": []}
+	}`, string(data))
+
+	// Nothing is rewritten if all are preserved.
+	preservedDesc, err := preserver.beforePush(ctx, *newDesc, "docker.io/nydus/test:latest")
+	require.NoError(t, err)
+	require.Equal(t, *newDesc, *preservedDesc)
+}
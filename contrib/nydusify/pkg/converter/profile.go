@@ -0,0 +1,200 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// ManifestProfile renames the layer media types, annotation keys and OS
+// features used in Nydus manifests, so that the target image is compatible
+// with the nydus-snapshotter version or custom runtime expecting them.
+type ManifestProfile struct {
+	// LayerMediaTypes maps the default media types of Nydus layers to the
+	// expected ones, e.g. `application/vnd.oci.image.layer.nydus.blob.v1`.
+	LayerMediaTypes map[string]string `json:"layer_media_types,omitempty"`
+	// Annotations maps the default annotation keys of Nydus layers, Nydus
+	// manifests and their index entries to the expected ones.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// OSFeatures maps the default OS features of Nydus manifests in index,
+	// e.g. `nydus.remoteimage.v1`.
+	OSFeatures map[string]string `json:"os_features,omitempty"`
+}
+
+// LoadManifestProfile loads the manifest profile from json file.
+func LoadManifestProfile(path string) (*ManifestProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read manifest profile")
+	}
+	var profile ManifestProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, errors.Wrap(err, "unmarshal manifest profile")
+	}
+	for _, mapping := range []map[string]string{profile.LayerMediaTypes, profile.Annotations, profile.OSFeatures} {
+		for from, to := range mapping {
+			if from == "" || to == "" {
+				return nil, fmt.Errorf("invalid mapping %q to %q in manifest profile", from, to)
+			}
+		}
+	}
+	return &profile, nil
+}
+
+func rename(value string, mapping map[string]string) string {
+	if renamed, ok := mapping[value]; ok {
+		return renamed
+	}
+	return value
+}
+
+func (profile *ManifestProfile) renameAnnotations(annotations map[string]string) map[string]string {
+	if len(annotations) == 0 || len(profile.Annotations) == 0 {
+		return annotations
+	}
+	renamed := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		renamed[rename(key, profile.Annotations)] = value
+	}
+	return renamed
+}
+
+// profileApplier applies the manifest profile to the converted image
+// before it's pushed to target.
+type profileApplier struct {
+	pvd     *provider.Provider
+	profile *ManifestProfile
+	target  string
+}
+
+func newProfileApplier(pvd *provider.Provider, profile *ManifestProfile, target string) (*profileApplier, error) {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	return &profileApplier{
+		pvd:     pvd,
+		profile: profile,
+		target:  named.String(),
+	}, nil
+}
+
+func (applier *profileApplier) hook() provider.PushHook {
+	return provider.PushHook{
+		BeforePush: applier.beforePush,
+	}
+}
+
+func (applier *profileApplier) beforePush(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+	if ref != applier.target {
+		return &desc, nil
+	}
+	newDesc, _, err := applier.apply(ctx, desc)
+	return newDesc, err
+}
+
+// apply returns the new descriptor, and whether it's a Nydus manifest or
+// an index containing Nydus manifests.
+func (applier *profileApplier) apply(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, bool, error) {
+	cs := applier.pvd.ContentStore()
+	profile := applier.profile
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return nil, false, errors.Wrap(err, "read index json")
+		}
+		found := false
+		for idx := range index.Manifests {
+			newDesc, nydus, err := applier.apply(ctx, index.Manifests[idx])
+			if err != nil {
+				return nil, false, err
+			}
+			if !nydus {
+				continue
+			}
+			found = true
+			newDesc.Annotations = profile.renameAnnotations(newDesc.Annotations)
+			if newDesc.Platform != nil {
+				platform := *newDesc.Platform
+				platform.OSFeatures = nil
+				for _, feature := range newDesc.Platform.OSFeatures {
+					platform.OSFeatures = append(platform.OSFeatures, rename(feature, profile.OSFeatures))
+				}
+				newDesc.Platform = &platform
+			}
+			index.Manifests[idx] = *newDesc
+		}
+		if !found {
+			return &desc, false, nil
+		}
+		newDesc, err := applier.pvd.WriteJSON(ctx, index, desc)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "write index json")
+		}
+		return newDesc, true, nil
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return nil, false, errors.Wrap(err, "read manifest json")
+		}
+		if parser.FindNydusBootstrapDesc(&manifest) == nil {
+			// Skip the OCI manifest in merged index.
+			return &desc, false, nil
+		}
+		manifest.Annotations = profile.renameAnnotations(manifest.Annotations)
+		for idx := range manifest.Layers {
+			layer := &manifest.Layers[idx]
+			layer.MediaType = rename(layer.MediaType, profile.LayerMediaTypes)
+			layer.Annotations = profile.renameAnnotations(layer.Annotations)
+		}
+		newDesc, err := applier.pvd.WriteJSON(ctx, manifest, desc)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "write manifest json")
+		}
+		return newDesc, true, nil
+
+	default:
+		return &desc, false, nil
+	}
+}
+
+func addProfileApplier(pvd *provider.Provider, opt Opt, target string) error {
+	if opt.ManifestProfile == nil {
+		return nil
+	}
+	// The bootstrap placer looks up the bootstrap layer by the default
+	// annotation and media types after the profile is applied.
+	if opt.BootstrapPlacement != "" && opt.BootstrapPlacement != BootstrapPlacementLayer {
+		_, renamed := opt.ManifestProfile.Annotations[nydusifyUtils.LayerAnnotationNydusBootstrap]
+		for mediaType := range opt.ManifestProfile.LayerMediaTypes {
+			renamed = renamed || mediaType != nydusifyUtils.MediaTypeNydusBlob
+		}
+		if renamed {
+			return fmt.Errorf("bootstrap layer can't be renamed with bootstrap placement %s", opt.BootstrapPlacement)
+		}
+	}
+	applier, err := newProfileApplier(pvd, opt.ManifestProfile, target)
+	if err != nil {
+		return err
+	}
+	pvd.AddPushHook(applier.hook())
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestLoadManifestProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"layer_media_types": {"application/vnd.oci.image.layer.nydus.blob.v1": "application/vnd.example.nydus.blob"},
+		"annotations": {"containerd.io/snapshot/nydus-blob": "io.example/nydus-blob"},
+		"os_features": {"nydus.remoteimage.v1": "example.nydus"}
+	}`), 0644))
+	profile, err := LoadManifestProfile(path)
+	require.NoError(t, err)
+	require.Equal(t, "io.example/nydus-blob", profile.Annotations[nydusifyUtils.LayerAnnotationNydusBlob])
+
+	for _, content := range []string{`{`, `{"annotations": {"containerd.io/snapshot/nydus-blob": ""}}`} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		_, err = LoadManifestProfile(path)
+		require.Error(t, err)
+	}
+}
+
+func TestProfileApplier(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	nydusDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{
+			{
+				MediaType: nydusifyUtils.MediaTypeNydusBlob,
+				Digest:    digest.FromString("blob"),
+				Annotations: map[string]string{
+					nydusifyUtils.LayerAnnotationNydusBlob: "true",
+				},
+			},
+			{
+				MediaType: ocispec.MediaTypeImageLayerGzip,
+				Digest:    digest.FromString("bootstrap"),
+				Annotations: map[string]string{
+					nydusifyUtils.LayerAnnotationNydusBootstrap: "true",
+				},
+			},
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	nydusDesc.Platform = &ocispec.Platform{
+		OS:           "linux",
+		Architecture: "amd64",
+		OSFeatures:   []string{nydusifyUtils.ManifestOSFeatureNydus},
+	}
+	ociDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{{
+			MediaType: ocispec.MediaTypeImageLayerGzip,
+			Digest:    digest.FromString("layer"),
+		}},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	indexDesc, err := utils.WriteJSON(ctx, cs, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{*ociDesc, *nydusDesc},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}, "", nil)
+	require.NoError(t, err)
+
+	profile := &ManifestProfile{
+		LayerMediaTypes: map[string]string{nydusifyUtils.MediaTypeNydusBlob: "application/vnd.example.nydus.blob"},
+		Annotations:     map[string]string{nydusifyUtils.LayerAnnotationNydusBlob: "io.example/nydus-blob"},
+		OSFeatures:      map[string]string{nydusifyUtils.ManifestOSFeatureNydus: "example.nydus"},
+	}
+	applier, err := newProfileApplier(pvd, profile, "nydus/test:latest")
+	require.NoError(t, err)
+
+	newDesc, err := applier.beforePush(ctx, *indexDesc, "docker.io/nydus/cache:latest")
+	require.NoError(t, err)
+	require.Equal(t, *indexDesc, *newDesc)
+
+	newDesc, err = applier.beforePush(ctx, *indexDesc, applier.target)
+	require.NoError(t, err)
+	var index ocispec.Index
+	_, err = utils.ReadJSON(ctx, cs, &index, *newDesc)
+	require.NoError(t, err)
+	// The OCI manifest is untouched.
+	require.Equal(t, ociDesc.Digest, index.Manifests[0].Digest)
+	require.Equal(t, []string{"example.nydus"}, index.Manifests[1].Platform.OSFeatures)
+
+	var manifest ocispec.Manifest
+	_, err = utils.ReadJSON(ctx, cs, &manifest, index.Manifests[1])
+	require.NoError(t, err)
+	require.Equal(t, "application/vnd.example.nydus.blob", manifest.Layers[0].MediaType)
+	require.Equal(t, map[string]string{"io.example/nydus-blob": "true"}, manifest.Layers[0].Annotations)
+	require.Equal(t, ocispec.MediaTypeImageLayerGzip, manifest.Layers[1].MediaType)
+	require.Equal(t, "true", manifest.Layers[1].Annotations[nydusifyUtils.LayerAnnotationNydusBootstrap])
+
+	// The bootstrap layer can't be renamed if it's placed as artifact.
+	profile.Annotations[nydusifyUtils.LayerAnnotationNydusBootstrap] = "io.example/nydus-bootstrap"
+	require.Error(t, addProfileApplier(pvd, Opt{ManifestProfile: profile, BootstrapPlacement: BootstrapPlacementArtifact}, "nydus/test:latest"))
+	require.NoError(t, addProfileApplier(pvd, Opt{ManifestProfile: profile, BootstrapPlacement: BootstrapPlacementLayer}, "nydus/test:latest"))
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/metrics"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/progress"
+)
+
+// buildProgressStore reports the progress of layers being built, by the
+// bytes of source layer streamed into builder, and records the layers built
+// in metrics once they're committed to content store.
+type buildProgressStore struct {
+	content.Store
+}
+
+type buildProgressReaderAt struct {
+	content.ReaderAt
+	task *progress.Task
+}
+
+type buildProgressWriter struct {
+	content.Writer
+	start time.Time
+}
+
+func (s *buildProgressStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	ra, err := s.Store.ReaderAt(ctx, desc)
+	if err != nil || !isSourceLayer(desc) {
+		return ra, err
+	}
+	task := progress.Start(desc.Digest.String(), progress.PhaseBuilding, desc.Size)
+	if task == nil {
+		return ra, nil
+	}
+	return &buildProgressReaderAt{ReaderAt: ra, task: task}, nil
+}
+
+func (ra *buildProgressReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := ra.ReaderAt.ReadAt(p, off)
+	ra.task.Add(int64(n))
+	return n, err
+}
+
+func (ra *buildProgressReaderAt) Close() error {
+	ra.task.Done(nil)
+	return ra.ReaderAt.Close()
+}
+
+func (s *buildProgressStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	writer, err := s.Store.Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return writer, nil
+		}
+	}
+	if !strings.HasPrefix(wOpts.Ref, convertedLayerRefPrefix) {
+		return writer, nil
+	}
+	return &buildProgressWriter{Writer: writer, start: time.Now()}, nil
+}
+
+func (w *buildProgressWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	err := w.Writer.Commit(ctx, size, expected, opts...)
+	if err == nil || errdefs.IsAlreadyExists(err) {
+		metrics.LayerConverted()
+		metrics.BuildDuration("create", w.start)
+	}
+	return err
+}
+
+// addBuildProgress tracks the layers being built, it must be added before
+// the build limiter, so that the layers waiting for a build slot aren't
+// reported as building.
+func addBuildProgress(pvd *provider.Provider) {
+	pvd.SetContentStore(&buildProgressStore{Store: pvd.ContentStore()})
+}
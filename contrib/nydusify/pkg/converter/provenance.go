@@ -0,0 +1,316 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const (
+	// MediaTypeInToto is the media type of in-toto attestation statement,
+	// which is used as artifact type of the provenance attestation.
+	MediaTypeInToto = "application/vnd.in-toto+json"
+
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v1"
+	// provenanceBuildType identifies the conversion by nydusify.
+	provenanceBuildType = "https://github.com/dragonflyoss/nydus/contrib/nydusify/convert@v1"
+
+	// annotationPredicateType records the predicate type of attestation
+	// layer, in the same way as buildkit.
+	annotationPredicateType = "in-toto.io/predicate-type"
+	// annotationBuilderVersion is recorded in Nydus manifests by the
+	// acceleration-service driver.
+	annotationBuilderVersion = "containerd.io/snapshot/nydus-builder-version"
+)
+
+type provenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []provenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     provenancePredicate `json:"predicate"`
+}
+
+type provenanceSubject struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+type provenancePredicate struct {
+	BuildDefinition provenanceBuildDefinition `json:"buildDefinition"`
+	RunDetails      provenanceRunDetails      `json:"runDetails"`
+}
+
+type provenanceBuildDefinition struct {
+	BuildType            string              `json:"buildType"`
+	ExternalParameters   map[string]string   `json:"externalParameters"`
+	ResolvedDependencies []provenanceSubject `json:"resolvedDependencies"`
+}
+
+type provenanceRunDetails struct {
+	Builder  provenanceBuilder  `json:"builder"`
+	Metadata provenanceMetadata `json:"metadata"`
+}
+
+type provenanceBuilder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version"`
+}
+
+type provenanceMetadata struct {
+	StartedOn  time.Time `json:"startedOn"`
+	FinishedOn time.Time `json:"finishedOn"`
+}
+
+// provenanceParameters returns the conversion options recorded in the
+// provenance, the backend config is omitted as it may contain secrets.
+func provenanceParameters(opt Opt, chunkDictDigest string) map[string]string {
+	params := map[string]string{
+		"source":         opt.Source,
+		"target":         opt.Target,
+		"fs_version":     opt.FsVersion,
+		"oci":            strconv.FormatBool(opt.Docker2OCI),
+		"oci_ref":        strconv.FormatBool(opt.OCIRef),
+		"merge_platform": strconv.FormatBool(opt.MergePlatform),
+	}
+	for key, value := range optionAnnotations(opt, chunkDictDigest) {
+		params[key] = value
+	}
+	if opt.AllPlatforms {
+		params["platforms"] = nydusifyUtils.AllPlatforms
+	} else if opt.Platforms != "" {
+		params["platforms"] = opt.Platforms
+	}
+	if opt.PrefetchPatterns != "" {
+		params["prefetch_patterns"] = opt.PrefetchPatterns
+	}
+	if opt.BackendType != "" {
+		params["backend_type"] = opt.BackendType
+	}
+	if opt.BootstrapPlacement != "" {
+		params["bootstrap_placement"] = opt.BootstrapPlacement
+	}
+	return params
+}
+
+// provenanceAttester attaches the SLSA provenance attestation to the target
+// image after it's pushed, the attestation refers to the image by `subject`
+// field, so that it can be discovered by the Referrers API.
+type provenanceAttester struct {
+	pvd       *provider.Provider
+	source    string
+	target    string
+	version   string
+	params    map[string]string
+	startedOn time.Time
+}
+
+func newProvenanceAttester(pvd *provider.Provider, opt Opt, chunkDictDigest string) (*provenanceAttester, error) {
+	named, err := docker.ParseDockerRef(opt.Target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	return &provenanceAttester{
+		pvd:       pvd,
+		source:    opt.Source,
+		target:    named.String(),
+		version:   opt.NydusifyVersion,
+		params:    provenanceParameters(opt, chunkDictDigest),
+		startedOn: time.Now().UTC(),
+	}, nil
+}
+
+func (attester *provenanceAttester) hook() provider.PushHook {
+	return provider.PushHook{
+		AfterPush: attester.afterPush,
+	}
+}
+
+func (attester *provenanceAttester) afterPush(ctx context.Context, desc ocispec.Descriptor, ref string) error {
+	if ref != attester.target {
+		return nil
+	}
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return errors.Wrap(err, "parse target reference")
+	}
+
+	statement, err := attester.statement(ctx, docker.TrimNamed(named).String(), desc)
+	if err != nil {
+		return err
+	}
+	artifactDesc, err := attester.writeArtifact(ctx, statement, desc)
+	if err != nil {
+		return err
+	}
+
+	// Push artifact by digest, avoid overwriting the target tag.
+	artifactRef := fmt.Sprintf("%s@%s", docker.TrimNamed(named).String(), artifactDesc.Digest)
+	logrus.Infof("pushing provenance attestation %s", artifactRef)
+	if err := attester.pvd.Push(ctx, *artifactDesc, artifactRef); err != nil {
+		return errors.Wrapf(err, "push provenance attestation %s", artifactRef)
+	}
+	return nil
+}
+
+func digestSet(dgst digest.Digest) map[string]string {
+	return map[string]string{dgst.Algorithm().String(): dgst.Encoded()}
+}
+
+func (attester *provenanceAttester) statement(ctx context.Context, name string, desc ocispec.Descriptor) (*provenanceStatement, error) {
+	sourceDesc, err := attester.pvd.Image(ctx, attester.source)
+	if err != nil {
+		return nil, errors.Wrap(err, "get source image")
+	}
+
+	versions := map[string]string{}
+	if attester.version != "" {
+		versions["nydusify"] = attester.version
+	}
+	if version, err := attester.builderVersion(ctx, desc); err != nil {
+		return nil, err
+	} else if version != "" {
+		versions["nydus-image"] = version
+	}
+
+	return &provenanceStatement{
+		Type: inTotoStatementType,
+		Subject: []provenanceSubject{{
+			Name:   name,
+			Digest: digestSet(desc.Digest),
+		}},
+		PredicateType: slsaProvenanceType,
+		Predicate: provenancePredicate{
+			BuildDefinition: provenanceBuildDefinition{
+				BuildType:          provenanceBuildType,
+				ExternalParameters: attester.params,
+				ResolvedDependencies: []provenanceSubject{{
+					URI:    "pkg:docker/" + attester.source,
+					Digest: digestSet(sourceDesc.Digest),
+				}},
+			},
+			RunDetails: provenanceRunDetails{
+				Builder: provenanceBuilder{
+					ID:      "nydusify",
+					Version: versions,
+				},
+				Metadata: provenanceMetadata{
+					StartedOn:  attester.startedOn,
+					FinishedOn: time.Now().UTC(),
+				},
+			},
+		},
+	}, nil
+}
+
+// builderVersion returns the builder version recorded in the first Nydus
+// manifest of target image.
+func (attester *provenanceAttester) builderVersion(ctx context.Context, desc ocispec.Descriptor) (string, error) {
+	cs := attester.pvd.ContentStore()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return "", errors.Wrap(err, "read index json")
+		}
+		for _, manifestDesc := range index.Manifests {
+			version, err := attester.builderVersion(ctx, manifestDesc)
+			if err != nil || version != "" {
+				return version, err
+			}
+		}
+		return "", nil
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return "", errors.Wrap(err, "read manifest json")
+		}
+		return manifest.Annotations[annotationBuilderVersion], nil
+
+	default:
+		return "", nil
+	}
+}
+
+// writeArtifact writes the attestation manifest which refers to the target
+// image by `subject` field into content store.
+func (attester *provenanceAttester) writeArtifact(ctx context.Context, statement *provenanceStatement, subject ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	data, err := json.Marshal(statement)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal provenance statement")
+	}
+	statementDesc, err := attester.pvd.WriteBlob(ctx, data, ocispec.Descriptor{
+		MediaType: MediaTypeInToto,
+		Annotations: map[string]string{
+			annotationPredicateType: slsaProvenanceType,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "write provenance statement")
+	}
+
+	emptyConfig := ocispec.DescriptorEmptyJSON
+	emptyConfig.Data = nil
+	configDesc, err := attester.pvd.WriteBlob(ctx, ocispec.DescriptorEmptyJSON.Data, emptyConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "write empty config")
+	}
+
+	artifact := ocispec.Manifest{
+		Versioned: specs.Versioned{
+			SchemaVersion: 2,
+		},
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: MediaTypeInToto,
+		Config:       *configDesc,
+		Layers:       []ocispec.Descriptor{*statementDesc},
+		Subject: &ocispec.Descriptor{
+			MediaType: subject.MediaType,
+			Digest:    subject.Digest,
+			Size:      subject.Size,
+		},
+	}
+
+	artifactDesc, err := attester.pvd.WriteJSON(ctx, artifact, ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: MediaTypeInToto,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "write provenance attestation")
+	}
+
+	return artifactDesc, nil
+}
+
+func addProvenanceAttester(pvd *provider.Provider, opt Opt, chunkDictDigest string) error {
+	if !opt.Provenance {
+		return nil
+	}
+	attester, err := newProvenanceAttester(pvd, opt, chunkDictDigest)
+	if err != nil {
+		return err
+	}
+	pvd.AddPushHook(attester.hook())
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+func TestProvenanceAttester(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	sourceDigest := digest.FromString("source")
+	pvd.AddImage("docker.io/library/nginx:latest", ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Digest:    sourceDigest,
+	})
+
+	manifestDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Annotations: map[string]string{
+			annotationBuilderVersion: "v2.2.0",
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	indexDesc, err := utils.WriteJSON(ctx, cs, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{*manifestDesc},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}, "", nil)
+	require.NoError(t, err)
+
+	attester, err := newProvenanceAttester(pvd, Opt{
+		Source:          "docker.io/library/nginx:latest",
+		Target:          "nginx:latest-nydus",
+		FsVersion:       "6",
+		ChunkSize:       "0x100000",
+		BackendType:     "oss",
+		BackendConfig:   `{"access_key_secret": "secret"}`,
+		AllPlatforms:    true,
+		NydusifyVersion: "v2.2.1",
+	}, "")
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/library/nginx:latest-nydus", attester.target)
+
+	statement, err := attester.statement(ctx, "docker.io/library/nginx", *indexDesc)
+	require.NoError(t, err)
+	require.Equal(t, inTotoStatementType, statement.Type)
+	require.Equal(t, slsaProvenanceType, statement.PredicateType)
+	require.Equal(t, []provenanceSubject{{
+		Name:   "docker.io/library/nginx",
+		Digest: map[string]string{"sha256": indexDesc.Digest.Encoded()},
+	}}, statement.Subject)
+	require.Equal(t, []provenanceSubject{{
+		URI:    "pkg:docker/docker.io/library/nginx:latest",
+		Digest: map[string]string{"sha256": sourceDigest.Encoded()},
+	}}, statement.Predicate.BuildDefinition.ResolvedDependencies)
+	require.Equal(t, map[string]string{
+		"nydusify":    "v2.2.1",
+		"nydus-image": "v2.2.0",
+	}, statement.Predicate.RunDetails.Builder.Version)
+	params := statement.Predicate.BuildDefinition.ExternalParameters
+	require.Equal(t, "6", params["fs_version"])
+	require.Equal(t, "all", params["platforms"])
+	require.Equal(t, "oss", params["backend_type"])
+	for _, value := range params {
+		require.NotContains(t, value, "secret")
+	}
+
+	artifactDesc, err := attester.writeArtifact(ctx, statement, *indexDesc)
+	require.NoError(t, err)
+	require.Equal(t, MediaTypeInToto, artifactDesc.ArtifactType)
+	var artifact ocispec.Manifest
+	_, err = utils.ReadJSON(ctx, cs, &artifact, *artifactDesc)
+	require.NoError(t, err)
+	require.Equal(t, indexDesc.Digest, artifact.Subject.Digest)
+	require.Len(t, artifact.Layers, 1)
+	require.Equal(t, slsaProvenanceType, artifact.Layers[0].Annotations[annotationPredicateType])
+
+	data, err := content.ReadBlob(ctx, cs, artifact.Layers[0])
+	require.NoError(t, err)
+	var stored provenanceStatement
+	require.NoError(t, json.Unmarshal(data, &stored))
+	require.Equal(t, statement.Subject, stored.Subject)
+}
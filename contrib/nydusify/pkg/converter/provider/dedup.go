@@ -0,0 +1,148 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	reference "github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// upload is an in-flight blob upload, err is set before done is closed.
+type upload struct {
+	done chan struct{}
+	err  error
+}
+
+// uploadGroup coordinates the blob uploads of concurrent conversions in the
+// process, for example the jobs of registry proxy sharing base layers, so
+// that a blob is uploaded to a repository exactly once at a time.
+type uploadGroup struct {
+	mutex   sync.Mutex
+	uploads map[string]*upload
+}
+
+var inflightUploads = &uploadGroup{uploads: map[string]*upload{}}
+
+// start returns nil if the caller becomes the uploader of key, otherwise
+// returns the in-flight upload to wait for.
+func (group *uploadGroup) start(key string) *upload {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if inflight, ok := group.uploads[key]; ok {
+		return inflight
+	}
+	group.uploads[key] = &upload{done: make(chan struct{})}
+	return nil
+}
+
+func (group *uploadGroup) finish(key string, err error) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if inflight, ok := group.uploads[key]; ok {
+		inflight.err = err
+		close(inflight.done)
+		delete(group.uploads, key)
+	}
+}
+
+// dedupResolver wraps the pushers to deduplicate the blob uploads.
+type dedupResolver struct {
+	remotes.Resolver
+	group *uploadGroup
+}
+
+func (resolver *dedupResolver) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	pusher, err := resolver.Resolver.Pusher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	named, err := reference.ParseDockerRef(ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse reference %s", ref)
+	}
+	return &dedupPusher{Pusher: pusher, repo: named.Name(), group: resolver.group}, nil
+}
+
+type dedupPusher struct {
+	remotes.Pusher
+	repo  string
+	group *uploadGroup
+}
+
+// Push waits for the in-flight upload of the same blob to the repository,
+// and returns ErrAlreadyExists if it succeeded, which is treated as pushed
+// by containerd, or uploads the blob if the in-flight upload failed.
+func (pusher *dedupPusher) Push(ctx context.Context, desc ocispec.Descriptor) (content.Writer, error) {
+	if images.IsManifestType(desc.MediaType) || images.IsIndexType(desc.MediaType) {
+		return pusher.Pusher.Push(ctx, desc)
+	}
+
+	key := pusher.repo + "@" + desc.Digest.String()
+	for {
+		inflight := pusher.group.start(key)
+		if inflight == nil {
+			break
+		}
+		logrus.Debugf("waiting for in-flight upload of blob %s", key)
+		select {
+		case <-inflight.done:
+			if inflight.err == nil {
+				return nil, errors.Wrapf(errdefs.ErrAlreadyExists, "blob %s uploaded by another job", key)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	writer, err := pusher.Pusher.Push(ctx, desc)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			pusher.group.finish(key, nil)
+		} else {
+			pusher.group.finish(key, err)
+		}
+		return nil, err
+	}
+	return &dedupWriter{Writer: writer, key: key, group: pusher.group}, nil
+}
+
+// dedupWriter finishes the in-flight upload on commit or close.
+type dedupWriter struct {
+	content.Writer
+	key   string
+	group *uploadGroup
+	once  sync.Once
+}
+
+func (writer *dedupWriter) finish(err error) {
+	writer.once.Do(func() {
+		writer.group.finish(writer.key, err)
+	})
+}
+
+func (writer *dedupWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	err := writer.Writer.Commit(ctx, size, expected, opts...)
+	if errdefs.IsAlreadyExists(err) {
+		writer.finish(nil)
+	} else {
+		writer.finish(err)
+	}
+	return err
+}
+
+func (writer *dedupWriter) Close() error {
+	writer.finish(errors.New("upload aborted"))
+	return writer.Writer.Close()
+}
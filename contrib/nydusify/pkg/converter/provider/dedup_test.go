@@ -0,0 +1,96 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeWriter struct {
+	content.Writer
+	commit chan error
+}
+
+func (writer *fakeWriter) Commit(context.Context, int64, digest.Digest, ...content.Opt) error {
+	return <-writer.commit
+}
+
+func (writer *fakeWriter) Close() error {
+	return nil
+}
+
+type fakePusher struct {
+	pushes atomic.Int32
+	commit chan error
+}
+
+func (pusher *fakePusher) Push(context.Context, ocispec.Descriptor) (content.Writer, error) {
+	pusher.pushes.Add(1)
+	return &fakeWriter{commit: pusher.commit}, nil
+}
+
+func TestDedupPusher(t *testing.T) {
+	ctx := context.Background()
+	group := &uploadGroup{uploads: map[string]*upload{}}
+	inner := &fakePusher{commit: make(chan error, 1)}
+	pusher := &dedupPusher{Pusher: inner, repo: "docker.io/library/nginx", group: group}
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromString("layer"),
+	}
+
+	writer, err := pusher.Push(ctx, desc)
+	require.NoError(t, err)
+
+	// The concurrent upload of the same blob waits for the in-flight one.
+	result := make(chan error, 1)
+	go func() {
+		_, err := pusher.Push(ctx, desc)
+		result <- err
+	}()
+	select {
+	case <-result:
+		t.Fatal("upload should wait for the in-flight one")
+	case <-time.After(50 * time.Millisecond):
+	}
+	inner.commit <- nil
+	require.NoError(t, writer.Commit(ctx, 0, desc.Digest))
+	require.True(t, errdefs.IsAlreadyExists(<-result))
+	require.Equal(t, int32(1), inner.pushes.Load())
+
+	// The blob is uploaded again if the in-flight upload failed.
+	writer, err = pusher.Push(ctx, desc)
+	require.NoError(t, err)
+	go func() {
+		writer, err := pusher.Push(ctx, desc)
+		if err == nil {
+			inner.commit <- nil
+			err = writer.Commit(ctx, 0, desc.Digest)
+		}
+		result <- err
+	}()
+	inner.commit <- fmt.Errorf("network error")
+	require.Error(t, writer.Commit(ctx, 0, desc.Digest))
+	require.NoError(t, <-result)
+	require.Equal(t, int32(3), inner.pushes.Load())
+
+	// The manifests are not deduplicated.
+	manifest := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("manifest")}
+	_, err = pusher.Push(ctx, manifest)
+	require.NoError(t, err)
+	_, err = pusher.Push(ctx, manifest)
+	require.NoError(t, err)
+	require.Equal(t, int32(5), inner.pushes.Load())
+}
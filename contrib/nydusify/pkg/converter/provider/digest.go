@@ -0,0 +1,311 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// digestStore keeps the objects digested by other algorithms than sha256,
+// which isn't supported by the local content store, as their sha256
+// digested content, and reads them by either digest.
+type digestStore struct {
+	content.Store
+	mutex sync.RWMutex
+	// canonical maps the digests of other algorithms to sha256 digests.
+	canonical map[digest.Digest]digest.Digest
+}
+
+func newDigestStore(store content.Store) *digestStore {
+	return &digestStore{Store: store, canonical: map[digest.Digest]digest.Digest{}}
+}
+
+// alias makes the content of canonical digest readable by dgst.
+func (store *digestStore) alias(dgst, canonical digest.Digest) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.canonical[dgst] = store.resolve(canonical)
+}
+
+func (store *digestStore) resolve(dgst digest.Digest) digest.Digest {
+	if canonical, ok := store.canonical[dgst]; ok {
+		return canonical
+	}
+	return dgst
+}
+
+func (store *digestStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	store.mutex.RLock()
+	canonical := store.resolve(dgst)
+	store.mutex.RUnlock()
+	info, err := store.Store.Info(ctx, canonical)
+	if err != nil {
+		return info, err
+	}
+	info.Digest = dgst
+	return info, nil
+}
+
+func (store *digestStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	store.mutex.RLock()
+	desc.Digest = store.resolve(desc.Digest)
+	store.mutex.RUnlock()
+	return store.Store.ReaderAt(ctx, desc)
+}
+
+// SetDigestAlgorithm makes the index, manifests, configs and bootstrap
+// layers of the pushed images digested by alg. The nydus blobs keep using
+// sha256 as they are referenced by blob ID in bootstrap.
+func (pvd *Provider) SetDigestAlgorithm(alg digest.Algorithm) {
+	pvd.digestAlgorithm = alg
+}
+
+// DigestAlgorithm returns the digest algorithm of the pushed images,
+// default to sha256.
+func (pvd *Provider) DigestAlgorithm() digest.Algorithm {
+	if pvd.digestAlgorithm == "" {
+		return digest.Canonical
+	}
+	return pvd.digestAlgorithm
+}
+
+// WriteBlob writes data into content store as the new content of oldDesc,
+// which is digested by the digest algorithm of provider.
+func (pvd *Provider) WriteBlob(ctx context.Context, data []byte, oldDesc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	newDesc := oldDesc
+	newDesc.Digest = digest.FromBytes(data)
+	newDesc.Size = int64(len(data))
+	if err := content.WriteBlob(ctx, pvd.store, newDesc.Digest.String(), bytes.NewReader(data), newDesc); err != nil {
+		return nil, err
+	}
+	if alg := pvd.DigestAlgorithm(); alg != digest.Canonical {
+		canonical := newDesc.Digest
+		newDesc.Digest = alg.FromBytes(data)
+		pvd.digests.alias(newDesc.Digest, canonical)
+	}
+	return &newDesc, nil
+}
+
+// WriteJSON is the WriteJSON of acceleration-service utils, which digests
+// the json by the digest algorithm of provider.
+func (pvd *Provider) WriteJSON(ctx context.Context, x interface{}, oldDesc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	data, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return pvd.WriteBlob(ctx, data, oldDesc)
+}
+
+// redigester digests the image in content store again by the digest
+// algorithm of provider, the unchanged objects keep their content.
+type redigester struct {
+	pvd *Provider
+	alg digest.Algorithm
+	// digests maps the old digests to the new ones.
+	digests map[digest.Digest]digest.Digest
+}
+
+// Redigest digests the image of desc by the digest algorithm of provider
+// as Push does, so that the artifacts refer to the digests pushed, it's a
+// no-op for sha256 or the image already digested by the algorithm.
+func (pvd *Provider) Redigest(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	alg := pvd.DigestAlgorithm()
+	if alg == digest.Canonical {
+		return &desc, nil
+	}
+	rd := &redigester{pvd: pvd, alg: alg, digests: map[digest.Digest]digest.Digest{}}
+	newDesc, err := rd.image(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "digest image %s by %s", desc.Digest, alg)
+	}
+	return newDesc, nil
+}
+
+func (rd *redigester) image(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if err := rd.readJSON(ctx, desc, &index); err != nil {
+			return nil, errors.Wrap(err, "read index json")
+		}
+		changed := false
+		for idx := range index.Manifests {
+			newDesc, err := rd.image(ctx, index.Manifests[idx])
+			if err != nil {
+				return nil, err
+			}
+			changed = changed || newDesc.Digest != index.Manifests[idx].Digest
+			index.Manifests[idx] = *newDesc
+		}
+		return rd.write(ctx, desc, index, changed)
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if err := rd.readJSON(ctx, desc, &manifest); err != nil {
+			return nil, errors.Wrap(err, "read manifest json")
+		}
+		changed, err := rd.manifest(ctx, &manifest)
+		if err != nil {
+			return nil, err
+		}
+		return rd.write(ctx, desc, manifest, changed)
+
+	default:
+		return &desc, nil
+	}
+}
+
+// manifest digests the config and bootstrap layers of manifest, and
+// returns whether the manifest is changed.
+func (rd *redigester) manifest(ctx context.Context, manifest *ocispec.Manifest) (bool, error) {
+	changed := false
+	diffIDs := map[digest.Digest]digest.Digest{}
+	for idx, layer := range manifest.Layers {
+		if layer.Annotations[utils.LayerAnnotationNydusBootstrap] != "true" || layer.Digest.Algorithm() == rd.alg {
+			continue
+		}
+		newLayer, err := rd.blob(ctx, layer)
+		if err != nil {
+			return false, errors.Wrapf(err, "digest bootstrap layer %s", layer.Digest)
+		}
+		oldDiffID, diffID, err := rd.diffID(ctx, layer)
+		if err != nil {
+			return false, errors.Wrapf(err, "digest uncompressed bootstrap layer %s", layer.Digest)
+		}
+		diffIDs[oldDiffID] = diffID
+		if _, ok := layer.Annotations[utils.LayerAnnotationUncompressed]; ok {
+			newLayer.Annotations = copyAnnotations(layer.Annotations)
+			newLayer.Annotations[utils.LayerAnnotationUncompressed] = diffID.String()
+		}
+		manifest.Layers[idx] = *newLayer
+		changed = true
+	}
+	if bootstrap, ok := manifest.Annotations[utils.ManifestNydusBootstrap]; ok {
+		if newDigest, ok := rd.digests[digest.Digest(bootstrap)]; ok {
+			manifest.Annotations = copyAnnotations(manifest.Annotations)
+			manifest.Annotations[utils.ManifestNydusBootstrap] = newDigest.String()
+			changed = true
+		}
+	}
+
+	config := manifest.Config
+	if len(diffIDs) > 0 && images.IsConfigType(config.MediaType) {
+		var image ocispec.Image
+		if err := rd.readJSON(ctx, config, &image); err != nil {
+			return false, errors.Wrap(err, "read image config")
+		}
+		for idx, diffID := range image.RootFS.DiffIDs {
+			if newDiffID, ok := diffIDs[diffID]; ok {
+				image.RootFS.DiffIDs[idx] = newDiffID
+			}
+		}
+		newConfig, err := rd.pvd.WriteJSON(ctx, image, config)
+		if err != nil {
+			return false, errors.Wrap(err, "write image config")
+		}
+		manifest.Config = *newConfig
+		return true, nil
+	}
+	if config.Digest.Algorithm() != rd.alg {
+		newConfig, err := rd.blob(ctx, config)
+		if err != nil {
+			return false, errors.Wrapf(err, "digest config %s", config.Digest)
+		}
+		manifest.Config = *newConfig
+		changed = true
+	}
+	return changed, nil
+}
+
+// write writes the changed index or manifest x of desc, the unchanged one
+// digested by other algorithm is digested again without changing content.
+func (rd *redigester) write(ctx context.Context, desc ocispec.Descriptor, x interface{}, changed bool) (*ocispec.Descriptor, error) {
+	if !changed {
+		if desc.Digest.Algorithm() == rd.alg {
+			return &desc, nil
+		}
+		return rd.blob(ctx, desc)
+	}
+	newDesc, err := rd.pvd.WriteJSON(ctx, x, desc)
+	if err != nil {
+		return nil, err
+	}
+	rd.digests[desc.Digest] = newDesc.Digest
+	return newDesc, nil
+}
+
+func (rd *redigester) readJSON(ctx context.Context, desc ocispec.Descriptor, x interface{}) error {
+	data, err := content.ReadBlob(ctx, rd.pvd.store, desc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, x)
+}
+
+// blob digests the blob of desc again without changing its content.
+func (rd *redigester) blob(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	if newDigest, ok := rd.digests[desc.Digest]; ok {
+		newDesc := desc
+		newDesc.Digest = newDigest
+		return &newDesc, nil
+	}
+	ra, err := rd.pvd.store.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer ra.Close()
+
+	digester := rd.alg.Digester()
+	if _, err := io.Copy(digester.Hash(), content.NewReader(ra)); err != nil {
+		return nil, err
+	}
+	newDesc := desc
+	newDesc.Digest = digester.Digest()
+	rd.pvd.digests.alias(newDesc.Digest, desc.Digest)
+	rd.digests[desc.Digest] = newDesc.Digest
+	return &newDesc, nil
+}
+
+// diffID digests the uncompressed content of layer by the algorithm of
+// layer digest and the new algorithm, which are the diff IDs in config.
+func (rd *redigester) diffID(ctx context.Context, layer ocispec.Descriptor) (digest.Digest, digest.Digest, error) {
+	ra, err := rd.pvd.store.ReaderAt(ctx, layer)
+	if err != nil {
+		return "", "", err
+	}
+	defer ra.Close()
+	reader, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return "", "", err
+	}
+	defer reader.Close()
+	oldDigester := layer.Digest.Algorithm().Digester()
+	digester := rd.alg.Digester()
+	if _, err := io.Copy(io.MultiWriter(oldDigester.Hash(), digester.Hash()), reader); err != nil {
+		return "", "", err
+	}
+	return oldDigester.Digest(), digester.Digest(), nil
+}
+
+func copyAnnotations(annotations map[string]string) map[string]string {
+	copied := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestPushDigestAlgorithm(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	registry.SetDigestAlgorithm(digest.SHA512)
+
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := New(t.TempDir(), func(string) (remote.CredentialFunc, bool, error) {
+		return func(string) (string, string, error) { return "", "", nil }, false, nil
+	}, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	cs := pvd.ContentStore()
+	writeBlob := func(data []byte, mediaType string) ocispec.Descriptor {
+		desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+		require.NoError(t, content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(data), desc))
+		return desc
+	}
+
+	// The image is written by sha256 as acceleration-service does.
+	var bootstrap bytes.Buffer
+	gw := gzip.NewWriter(&bootstrap)
+	_, err = gw.Write([]byte("bootstrap"))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	blobLayer := writeBlob([]byte("blob"), utils.MediaTypeNydusBlob)
+	bootstrapLayer := writeBlob(bootstrap.Bytes(), ocispec.MediaTypeImageLayerGzip)
+	bootstrapLayer.Annotations = map[string]string{utils.LayerAnnotationNydusBootstrap: "true"}
+	config, err := json.Marshal(ocispec.Image{RootFS: ocispec.RootFS{
+		Type:    "layers",
+		DiffIDs: []digest.Digest{blobLayer.Digest, digest.FromString("bootstrap")},
+	}})
+	require.NoError(t, err)
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeBlob(config, ocispec.MediaTypeImageConfig),
+		Layers:    []ocispec.Descriptor{blobLayer, bootstrapLayer},
+	})
+	require.NoError(t, err)
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{writeBlob(manifest, ocispec.MediaTypeImageManifest)},
+	})
+	require.NoError(t, err)
+	indexDesc := writeBlob(index, ocispec.MediaTypeImageIndex)
+
+	var pushed ocispec.Descriptor
+	pvd.AddPushHook(PushHook{AfterPush: func(_ context.Context, desc ocispec.Descriptor, _ string) error {
+		pushed = desc
+		return nil
+	}})
+	pvd.SetDigestAlgorithm(digest.SHA512)
+	require.NoError(t, pvd.Push(ctx, indexDesc, registry.Host()+"/app:v1"))
+	require.Equal(t, digest.SHA512, pushed.Digest.Algorithm())
+
+	// The pushed objects are readable by the new digests.
+	data, err := content.ReadBlob(ctx, cs, pushed)
+	require.NoError(t, err)
+	var pushedIndex ocispec.Index
+	require.NoError(t, json.Unmarshal(data, &pushedIndex))
+	data, _, ok := registry.Manifest("app", "v1")
+	require.True(t, ok)
+	require.Equal(t, pushed.Digest, digest.SHA512.FromBytes(data))
+	require.Len(t, pushedIndex.Manifests, 1)
+	require.Equal(t, digest.SHA512, pushedIndex.Manifests[0].Digest.Algorithm())
+	data, _, ok = registry.Manifest("app", pushedIndex.Manifests[0].Digest.String())
+	require.True(t, ok)
+	var pushedManifest ocispec.Manifest
+	require.NoError(t, json.Unmarshal(data, &pushedManifest))
+	require.Equal(t, blobLayer.Digest, pushedManifest.Layers[0].Digest)
+	require.Equal(t, digest.SHA512.FromBytes(bootstrap.Bytes()), pushedManifest.Layers[1].Digest)
+	data, ok = registry.Blob("app", pushedManifest.Config.Digest)
+	require.True(t, ok)
+	var pushedConfig ocispec.Image
+	require.NoError(t, json.Unmarshal(data, &pushedConfig))
+	require.Equal(t, []digest.Digest{blobLayer.Digest, digest.SHA512.FromString("bootstrap")}, pushedConfig.RootFS.DiffIDs)
+
+	// The image already digested by the algorithm is pushed as-is.
+	require.NoError(t, pvd.Push(ctx, pushed, registry.Host()+"/app:v2"))
+	data, _, ok = registry.Manifest("app", "v2")
+	require.True(t, ok)
+	require.Equal(t, pushed.Digest, digest.SHA512.FromBytes(data))
+}
@@ -0,0 +1,33 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Exporter writes the image to somewhere other than remote registry.
+type Exporter func(ctx context.Context, desc ocispec.Descriptor) error
+
+// AddImage adds the image already in content store as the reference, the
+// later pull of the reference only calls the pull hooks, so that the image
+// loaded from local transport can be converted without pushing it to
+// registry first.
+func (pvd *Provider) AddImage(ref string, desc ocispec.Descriptor) {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	pvd.images[ref] = &desc
+	pvd.imported[ref] = true
+}
+
+// SetExporter makes the push of the reference call exporter instead of
+// pushing to remote registry, the push hooks are still called.
+func (pvd *Provider) SetExporter(ref string, exporter Exporter) {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	pvd.exporters[ref] = exporter
+}
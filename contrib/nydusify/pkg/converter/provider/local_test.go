@@ -0,0 +1,48 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalImage(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+
+	ref := "localhost/test:latest"
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("manifest"),
+	}
+	pvd.AddImage(ref, desc)
+	image, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, desc, *image)
+	// The added image is not pulled from registry.
+	require.NoError(t, pvd.Pull(ctx, ref))
+
+	var exported []ocispec.Descriptor
+	pvd.AddPushHook(PushHook{
+		BeforePush: func(_ context.Context, desc ocispec.Descriptor, _ string) (*ocispec.Descriptor, error) {
+			desc.Size = 1
+			return &desc, nil
+		},
+	})
+	pvd.SetExporter(ref, func(_ context.Context, desc ocispec.Descriptor) error {
+		exported = append(exported, desc)
+		return nil
+	})
+	require.NoError(t, pvd.Push(ctx, desc, ref))
+	require.Len(t, exported, 1)
+	require.Equal(t, int64(1), exported[0].Size)
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Mirror pushes the image of source reference to target reference as-is
+// with all platforms, the content missing in store is pulled from source
+// first. The store should be the plain content store without the wrappers
+// transforming source layers, and the push hooks and exporters aren't
+// called, so the mirrored image is byte-for-byte identical to source.
+func (pvd *Provider) Mirror(ctx context.Context, store content.Store, source, target string) (*ocispec.Descriptor, error) {
+	pvd.mutex.Lock()
+	mirror := &Provider{
+		images:         map[string]*ocispec.Descriptor{},
+		imported:       map[string]bool{},
+		exporters:      map[string]Exporter{},
+		store:          store,
+		hosts:          pvd.hosts,
+		platformMC:     platforms.All,
+		chunkSize:      pvd.chunkSize,
+		limiter:        pvd.limiter,
+		usePlainHTTP:   pvd.usePlainHTTP,
+		plainHTTPHosts: pvd.plainHTTPHosts,
+	}
+	desc, imported := pvd.images[source], pvd.imported[source]
+	pvd.mutex.Unlock()
+
+	// The image loaded from local transport is already in store.
+	if !imported {
+		if err := mirror.pull(ctx, source); err != nil {
+			return nil, errors.Wrapf(err, "pull source image %s", source)
+		}
+		desc = mirror.images[source]
+	}
+	if err := mirror.pushRemote(ctx, *desc, target); err != nil {
+		return nil, errors.Wrapf(err, "push source image to %s", target)
+	}
+
+	return desc, nil
+}
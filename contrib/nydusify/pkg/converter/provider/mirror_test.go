@@ -0,0 +1,84 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+)
+
+func TestMirror(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	host := registry.Host()
+
+	layers := map[string]ocispec.Descriptor{}
+	manifests := []ocispec.Descriptor{}
+	for _, arch := range []string{"amd64", "arm64"} {
+		layers[arch] = registry.PutBlob("app", ocispec.MediaTypeImageLayerGzip, []byte("layer "+arch))
+		desc, err := registry.PutManifest("app", "", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    registry.PutBlob("app", ocispec.MediaTypeImageConfig, []byte("config "+arch)),
+			Layers:    []ocispec.Descriptor{layers[arch]},
+		})
+		require.NoError(t, err)
+		desc.Platform = &ocispec.Platform{OS: "linux", Architecture: arch}
+		manifests = append(manifests, desc)
+	}
+	index, err := registry.PutManifest("app", "v1", ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: manifests,
+	})
+	require.NoError(t, err)
+
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := New(t.TempDir(), func(string) (remote.CredentialFunc, bool, error) {
+		return func(string) (string, string, error) { return "", "", nil }, false, nil
+	}, 200, "v1", platforms.Only(platforms.MustParse("linux/amd64")), 0)
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	store := pvd.ContentStore()
+	pushed := false
+	pvd.AddPushHook(PushHook{AfterPush: func(context.Context, ocispec.Descriptor, string) error {
+		pushed = true
+		return nil
+	}})
+
+	// Only the layer of matched platform is pulled for conversion.
+	source := host + "/app:v1"
+	require.NoError(t, pvd.Pull(ctx, source))
+	_, err = store.Info(ctx, layers["arm64"].Digest)
+	require.Error(t, err)
+
+	// All platforms are mirrored as-is without calling the push hooks.
+	desc, err := pvd.Mirror(ctx, store, source, host+"/mirror:v1-oci")
+	require.NoError(t, err)
+	require.Equal(t, index.Digest, desc.Digest)
+	require.False(t, pushed)
+	_, mediaType, ok := registry.Manifest("mirror", "v1-oci")
+	require.True(t, ok)
+	require.Equal(t, ocispec.MediaTypeImageIndex, mediaType)
+	for _, manifest := range manifests {
+		_, _, ok := registry.Manifest("mirror", manifest.Digest.String())
+		require.True(t, ok)
+	}
+	for _, layer := range layers {
+		data, ok := registry.Blob("mirror", layer.Digest)
+		require.True(t, ok)
+		require.Equal(t, layer.Digest, digest.FromBytes(data))
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+)
+
+// convertedBlobRefPrefix is the writer ref prefix used by nydus-snapshotter
+// converter to write the Nydus blob converted from a layer.
+const convertedBlobRefPrefix = "convert-nydus-from-"
+
+// overlapPusher pushes the converted Nydus blobs to target registry as soon
+// as they're built, while the next layers are still building.
+type overlapPusher struct {
+	mutex  sync.Mutex
+	ctx    context.Context
+	target string
+	sem    *semaphore.Weighted
+	wg     sync.WaitGroup
+}
+
+// overlapStore wraps the content store to start pushing the converted
+// Nydus blob once it's committed.
+type overlapStore struct {
+	content.Store
+	pvd *Provider
+}
+
+func (store *overlapStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	writer, err := store.Store.Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.HasPrefix(wOpts.Ref, convertedBlobRefPrefix) {
+		return writer, nil
+	}
+	return &overlapWriter{Writer: writer, pvd: store.pvd}, nil
+}
+
+type overlapWriter struct {
+	content.Writer
+	pvd *Provider
+}
+
+func (writer *overlapWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	if err := writer.Writer.Commit(ctx, size, expected, opts...); err != nil {
+		return err
+	}
+	writer.pvd.pushEarly(writer.Writer.Digest())
+	return nil
+}
+
+// SetOverlapPush makes the converted Nydus blobs pushed to target as soon
+// as they're built, empty target disables it. The blobs are skipped by the
+// later image push as they already exist in registry.
+func (pvd *Provider) SetOverlapPush(ctx context.Context, target string) {
+	pvd.overlap.mutex.Lock()
+	defer pvd.overlap.mutex.Unlock()
+	pvd.overlap.ctx = ctx
+	pvd.overlap.target = target
+}
+
+func (pvd *Provider) pushEarly(dgst digest.Digest) {
+	pvd.overlap.mutex.Lock()
+	ctx, target := pvd.overlap.ctx, pvd.overlap.target
+	if target == "" {
+		pvd.overlap.mutex.Unlock()
+		return
+	}
+	sem := pvd.overlap.sem
+	if pvd.limiter != nil {
+		sem = pvd.limiter.Semaphore()
+	} else if sem == nil {
+		sem = semaphore.NewWeighted(int64(LayerConcurrentLimit))
+		pvd.overlap.sem = sem
+	}
+	pvd.overlap.wg.Add(1)
+	pvd.overlap.mutex.Unlock()
+
+	go func() {
+		defer pvd.overlap.wg.Done()
+		if err := sem.Acquire(ctx, 1); err != nil {
+			return
+		}
+		defer sem.Release(1)
+		// The failure is not fatal, the blob will be pushed again with image.
+		if err := pvd.pushBlob(ctx, dgst, target); err != nil {
+			logrus.WithError(err).Warnf("failed to push blob %s early", dgst)
+		}
+	}()
+}
+
+// waitOverlap waits for the in-flight early pushes.
+func (pvd *Provider) waitOverlap() {
+	pvd.overlap.wg.Wait()
+}
+
+func (pvd *Provider) pushBlob(ctx context.Context, dgst digest.Digest, ref string) error {
+	info, err := pvd.store.Info(ctx, dgst)
+	if err != nil {
+		return errors.Wrap(err, "get blob info")
+	}
+	desc := ocispec.Descriptor{
+		MediaType: utils.MediaTypeNydusBlob,
+		Digest:    dgst,
+		Size:      info.Size,
+	}
+
+	resolver, err := pvd.Resolver(ref)
+	if err != nil {
+		return err
+	}
+	pusher, err := resolver.Pusher(ctx, ref)
+	if err != nil {
+		return errors.Wrap(err, "create pusher")
+	}
+	writer, err := pusher.Push(ctx, desc)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return nil
+		}
+		return errors.Wrap(err, "push blob")
+	}
+	defer writer.Close()
+
+	ra, err := pvd.store.ReaderAt(ctx, desc)
+	if err != nil {
+		return errors.Wrap(err, "get blob reader")
+	}
+	defer ra.Close()
+
+	logrus.Debugf("pushing blob %s early to %s", dgst, ref)
+	return content.Copy(ctx, writer, io.NewSectionReader(ra, 0, ra.Size()), desc.Size, desc.Digest)
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverlapPush(t *testing.T) {
+	var mutex sync.Mutex
+	requested := []string{}
+	// The fake registry reports all blobs exist.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.Method+" "+r.URL.Path)
+		mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := New(t.TempDir(), func(string) (remote.CredentialFunc, bool, error) {
+		return func(string) (string, string, error) { return "", "", nil }, false, nil
+	}, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	cs := pvd.ContentStore()
+
+	writeBlob := func(ref, data string) digest.Digest {
+		desc := ocispec.Descriptor{Digest: digest.FromString(data), Size: int64(len(data))}
+		require.NoError(t, content.WriteBlob(ctx, cs, ref, bytes.NewReader([]byte(data)), desc))
+		return desc.Digest
+	}
+
+	// Disabled by default.
+	writeBlob(convertedBlobRefPrefix+"disabled", "disabled")
+	pvd.waitOverlap()
+	require.Empty(t, requested)
+
+	pvd.SetOverlapPush(ctx, host+"/nydus/test:latest")
+	writeBlob("fetch-source-layer", "source")
+	blobDigest := writeBlob(convertedBlobRefPrefix+"layer", "blob")
+	pvd.waitOverlap()
+	require.Equal(t, []string{"HEAD /v2/nydus/test/blobs/" + blobDigest.String()}, requested)
+}
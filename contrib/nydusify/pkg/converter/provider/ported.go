@@ -24,7 +24,7 @@ import (
 
 // Ported from containerd project, copyright The containerd Authors.
 // github.com/containerd/containerd/blob/main/pull.go
-func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteContext, ref string, limit int) (images.Image, error) {
+func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteContext, ref string, limit int, limiter *semaphore.Weighted) (images.Image, error) {
 	name, desc, err := rCtx.Resolver.Resolve(ctx, ref)
 	if err != nil {
 		return images.Image{}, fmt.Errorf("failed to resolve reference %q: %w", ref, err)
@@ -40,7 +40,6 @@ func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteCont
 
 		isConvertible bool
 		converterFunc func(context.Context, ocispec.Descriptor) (ocispec.Descriptor, error)
-		limiter       *semaphore.Weighted
 	)
 
 	// nolint:staticcheck
@@ -89,7 +88,7 @@ func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteCont
 		}
 
 		handlers := append(rCtx.BaseHandlers,
-			remotes.FetchHandler(store, fetcher),
+			sharedFetchHandler(resumableFetchHandler(store, fetcher, LayerPullRetries, LayerPullRetryInterval)),
 			convertibleHandler,
 			childrenHandler,
 			appendDistSrcLabelHandler,
@@ -106,7 +105,7 @@ func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteCont
 		handler = rCtx.HandlerWrapper(handler)
 	}
 
-	if rCtx.MaxConcurrentDownloads > 0 {
+	if limiter == nil && rCtx.MaxConcurrentDownloads > 0 {
 		limiter = semaphore.NewWeighted(int64(rCtx.MaxConcurrentDownloads))
 	}
 
@@ -129,7 +128,7 @@ func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteCont
 
 // Ported from containerd project, copyright The containerd Authors.
 // github.com/containerd/containerd/blob/main/client.go
-func push(ctx context.Context, store content.Store, pushCtx *containerd.RemoteContext, desc ocispec.Descriptor, ref string) error {
+func push(ctx context.Context, store content.Store, pushCtx *containerd.RemoteContext, desc ocispec.Descriptor, ref string, limiter *semaphore.Weighted) error {
 	if pushCtx.PlatformMatcher == nil {
 		if len(pushCtx.Platforms) > 0 {
 			var ps []ocispec.Platform
@@ -170,8 +169,7 @@ func push(ctx context.Context, store content.Store, pushCtx *containerd.RemoteCo
 		wrapper = pushCtx.HandlerWrapper
 	}
 
-	var limiter *semaphore.Weighted
-	if pushCtx.MaxConcurrentUploadedLayers > 0 {
+	if limiter == nil && pushCtx.MaxConcurrentUploadedLayers > 0 {
 		limiter = semaphore.NewWeighted(int64(pushCtx.MaxConcurrentUploadedLayers))
 	}
 
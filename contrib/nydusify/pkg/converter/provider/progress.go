@@ -0,0 +1,119 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/metrics"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/progress"
+)
+
+var errIncomplete = errors.New("incomplete")
+
+// pullProgressStore reports the progress of layers downloaded into content
+// store.
+type pullProgressStore struct {
+	content.Store
+}
+
+type progressWriter struct {
+	content.Writer
+	task *progress.Task
+}
+
+func (s *pullProgressStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	writer, err := s.Store.Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return writer, nil
+		}
+	}
+	if !images.IsLayerType(wOpts.Desc.MediaType) {
+		return writer, nil
+	}
+	task := progress.Start(wOpts.Desc.Digest.String(), progress.PhasePulling, wOpts.Desc.Size)
+	if task == nil {
+		return writer, nil
+	}
+	// The interrupted download is resumed from offset.
+	if status, err := writer.Status(); err == nil {
+		task.Add(status.Offset)
+	}
+	return &progressWriter{Writer: writer, task: task}, nil
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.task.Add(int64(n))
+	return n, err
+}
+
+func (w *progressWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	err := w.Writer.Commit(ctx, size, expected, opts...)
+	if err != nil && !errdefs.IsAlreadyExists(err) {
+		w.task.Done(err)
+	} else {
+		w.task.Done(nil)
+	}
+	return err
+}
+
+func (w *progressWriter) Close() error {
+	w.task.Done(errIncomplete)
+	return w.Writer.Close()
+}
+
+// pushProgressStore reports the progress of layers uploaded to registry,
+// the pusher only reads the layers not existing in registry.
+type pushProgressStore struct {
+	content.Store
+}
+
+type progressReaderAt struct {
+	content.ReaderAt
+	task *progress.Task
+	read int64
+}
+
+func (s *pushProgressStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	ra, err := s.Store.ReaderAt(ctx, desc)
+	if err != nil || !images.IsLayerType(desc.MediaType) {
+		return ra, err
+	}
+	return &progressReaderAt{
+		ReaderAt: ra,
+		task:     progress.Start(desc.Digest.String(), progress.PhaseUploading, desc.Size),
+	}, nil
+}
+
+func (ra *progressReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := ra.ReaderAt.ReadAt(p, off)
+	atomic.AddInt64(&ra.read, int64(n))
+	ra.task.Add(int64(n))
+	return n, err
+}
+
+func (ra *progressReaderAt) Close() error {
+	if atomic.LoadInt64(&ra.read) >= ra.Size() {
+		metrics.BlobPushed("registry", ra.Size())
+		ra.task.Done(nil)
+	} else {
+		ra.task.Done(errIncomplete)
+	}
+	return ra.ReaderAt.Close()
+}
@@ -17,27 +17,71 @@ import (
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/platforms"
+	reference "github.com/containerd/containerd/reference/docker"
 	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
+	nydusifyCache "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/cache"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
 	"github.com/goharbor/acceleration-service/pkg/cache"
 	accelcontent "github.com/goharbor/acceleration-service/pkg/content"
 	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
 )
 
 var LayerConcurrentLimit = 5
 
+// PullRetryPolicy retries the requests reading registry, i.e. GET and HEAD,
+// the blob download interrupted after response is retried by
+// LayerPullRetries.
+var PullRetryPolicy = utils.RetryPolicy{}
+
+// PushRetryPolicy retries the requests writing registry, and the push of
+// each blob or manifest.
+var PushRetryPolicy = utils.RetryPolicy{}
+
+// PushHook is called around pushing an image to remote registry.
+type PushHook struct {
+	// BeforePush can mutate the image in content store and returns the
+	// descriptor of new image to be pushed.
+	BeforePush func(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error)
+	// AfterPush is called after the image has been pushed.
+	AfterPush func(ctx context.Context, desc ocispec.Descriptor, ref string) error
+}
+
+// PullHook is called after an image has been pulled or imported into
+// content store, it can refuse the image by returning error.
+type PullHook func(ctx context.Context, desc ocispec.Descriptor, ref string) error
+
 type Provider struct {
 	mutex        sync.Mutex
 	usePlainHTTP bool
 	images       map[string]*ocispec.Descriptor
+	imported     map[string]bool
+	exporters    map[string]Exporter
 	store        content.Store
 	hosts        remote.HostFunc
 	platformMC   platforms.MatchComparer
 	cacheSize    int
 	cacheVersion string
 	chunkSize    int64
+	pushHooks    []PushHook
+	pullHooks    []PullHook
+	limiter      *utils.AdaptiveLimiter
+	overlap      overlapPusher
+	sourceCache  *nydusifyCache.SourceCache
+	// digestAlgorithm digests the pushed images, see SetDigestAlgorithm,
+	// the objects digested by it are kept in digests.
+	digestAlgorithm digest.Algorithm
+	digests         *digestStore
+
+	// plainHTTPHosts are the registry hosts accessed by plain HTTP.
+	plainHTTPHosts map[string]bool
 }
 
 func New(root string, hosts remote.HostFunc, cacheSize uint, cacheVersion string, platformMC platforms.MatchComparer, chunkSize int64) (*Provider, error) {
@@ -50,20 +94,46 @@ func New(root string, hosts remote.HostFunc, cacheSize uint, cacheVersion string
 		return nil, err
 	}
 
-	return &Provider{
+	pvd := &Provider{
 		images:       make(map[string]*ocispec.Descriptor),
-		store:        store,
+		imported:     make(map[string]bool),
+		exporters:    make(map[string]Exporter),
 		hosts:        hosts,
 		cacheSize:    int(cacheSize),
 		platformMC:   platformMC,
 		cacheVersion: cacheVersion,
 		chunkSize:    chunkSize,
-	}, nil
+
+		plainHTTPHosts: make(map[string]bool),
+	}
+	pvd.digests = newDigestStore(store)
+	pvd.store = &overlapStore{Store: pvd.digests, pvd: pvd}
+
+	return pvd, nil
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+// retryTransport retries the requests by PullRetryPolicy or PushRetryPolicy
+// according to the method.
+func retryTransport(transport http.RoundTripper) http.RoundTripper {
+	pull := &utils.RetryTransport{Transport: transport, Policy: PullRetryPolicy}
+	push := &utils.RetryTransport{Transport: transport, Policy: PushRetryPolicy}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			return pull.RoundTrip(req)
+		}
+		return push.RoundTrip(req)
+	})
 }
 
 func newDefaultClient(skipTLSVerify bool) *http.Client {
 	return &http.Client{
-		Transport: &http.Transport{
+		Transport: retryTransport(utils.WrapTransport(&http.Transport{
 			Proxy: http.ProxyFromEnvironment,
 			DialContext: (&net.Dialer{
 				Timeout:   30 * time.Second,
@@ -76,14 +146,12 @@ func newDefaultClient(skipTLSVerify bool) *http.Client {
 			ExpectContinueTimeout: 5 * time.Second,
 			DisableKeepAlives:     true,
 			TLSNextProto:          make(map[string]func(authority string, c *tls.Conn) http.RoundTripper),
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: skipTLSVerify,
-			},
-		},
+			TLSClientConfig:       utils.NewTLSConfig(skipTLSVerify),
+		})),
 	}
 }
 
-func newResolver(insecure, plainHTTP bool, credFunc remote.CredentialFunc, chunkSize int64) remotes.Resolver {
+func newResolver(insecure bool, plainHTTP func(host string) bool, credFunc remote.CredentialFunc, chunkSize int64) remotes.Resolver {
 	registryHosts := docker.ConfigureDefaultRegistries(
 		docker.WithAuthorizer(
 			docker.NewDockerAuthorizer(
@@ -92,8 +160,8 @@ func newResolver(insecure, plainHTTP bool, credFunc remote.CredentialFunc, chunk
 			),
 		),
 		docker.WithClient(newDefaultClient(insecure)),
-		docker.WithPlainHTTP(func(_ string) (bool, error) {
-			return plainHTTP, nil
+		docker.WithPlainHTTP(func(host string) (bool, error) {
+			return plainHTTP(host), nil
 		}),
 		docker.WithChunkSize(chunkSize),
 	)
@@ -107,15 +175,60 @@ func (pvd *Provider) UsePlainHTTP() {
 	pvd.usePlainHTTP = true
 }
 
+// UsePlainHTTPFor makes the registry of the reference accessed by plain
+// HTTP, the other registries are not affected.
+func (pvd *Provider) UsePlainHTTPFor(ref string) error {
+	named, err := reference.ParseDockerRef(ref)
+	if err != nil {
+		return errors.Wrapf(err, "parse reference %s", ref)
+	}
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	pvd.plainHTTPHosts[reference.Domain(named)] = true
+	return nil
+}
+
+func (pvd *Provider) plainHTTP(host string) bool {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	return pvd.usePlainHTTP || pvd.plainHTTPHosts[host]
+}
+
 func (pvd *Provider) Resolver(ref string) (remotes.Resolver, error) {
 	credFunc, insecure, err := pvd.hosts(ref)
 	if err != nil {
 		return nil, err
 	}
-	return newResolver(insecure, pvd.usePlainHTTP, credFunc, pvd.chunkSize), nil
+	return &dedupResolver{
+		Resolver: newResolver(insecure, pvd.plainHTTP, credFunc, pvd.chunkSize),
+		group:    inflightUploads,
+	}, nil
 }
 
 func (pvd *Provider) Pull(ctx context.Context, ref string) error {
+	pvd.mutex.Lock()
+	imported := pvd.imported[ref]
+	pvd.mutex.Unlock()
+	if !imported {
+		if err := pvd.pull(ctx, ref); err != nil {
+			return err
+		}
+	}
+
+	desc, err := pvd.Image(ctx, ref)
+	if err != nil {
+		return err
+	}
+	for _, hook := range pvd.pullHooks {
+		if err := hook(ctx, *desc, ref); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pvd *Provider) pull(ctx context.Context, ref string) error {
 	resolver, err := pvd.Resolver(ref)
 	if err != nil {
 		return err
@@ -126,8 +239,23 @@ func (pvd *Provider) Pull(ctx context.Context, ref string) error {
 		MaxConcurrentDownloads: LayerConcurrentLimit,
 	}
 
-	img, err := fetch(ctx, pvd.store, rc, ref, 0)
+	var sem *semaphore.Weighted
+	if pvd.limiter != nil {
+		sem = pvd.limiter.Semaphore()
+		rc.HandlerWrapper = pvd.observeHandler
+	}
+
+	store := pvd.store
+	pvd.mutex.Lock()
+	if pvd.sourceCache != nil {
+		store = &sourceCacheStore{Store: store, cache: pvd.sourceCache}
+	}
+	pvd.mutex.Unlock()
+	img, err := fetch(ctx, &pullProgressStore{store}, rc, ref, 0, sem)
 	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return utils.WithExitCode(err, utils.ExitSourceMissing)
+		}
 		return err
 	}
 
@@ -139,6 +267,54 @@ func (pvd *Provider) Pull(ctx context.Context, ref string) error {
 }
 
 func (pvd *Provider) Push(ctx context.Context, desc ocispec.Descriptor, ref string) error {
+	// Avoid pushing the same blob concurrently.
+	pvd.waitOverlap()
+
+	// The image is digested again after each hook, so that the hooks see
+	// and refer to the final digests.
+	newDesc, err := pvd.Redigest(ctx, desc)
+	if err != nil {
+		return err
+	}
+	desc = *newDesc
+	for _, hook := range pvd.pushHooks {
+		if hook.BeforePush == nil {
+			continue
+		}
+		newDesc, err := hook.BeforePush(ctx, desc, ref)
+		if err != nil {
+			return err
+		}
+		if newDesc, err = pvd.Redigest(ctx, *newDesc); err != nil {
+			return err
+		}
+		desc = *newDesc
+	}
+
+	pvd.mutex.Lock()
+	exporter := pvd.exporters[ref]
+	pvd.mutex.Unlock()
+	if exporter != nil {
+		if err := exporter(ctx, desc); err != nil {
+			return utils.WithExitCode(err, utils.ExitPush)
+		}
+	} else if err := pvd.pushRemote(ctx, desc, ref); err != nil {
+		return utils.WithExitCode(err, utils.ExitPush)
+	}
+
+	for _, hook := range pvd.pushHooks {
+		if hook.AfterPush == nil {
+			continue
+		}
+		if err := hook.AfterPush(ctx, desc, ref); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pvd *Provider) pushRemote(ctx context.Context, desc ocispec.Descriptor, ref string) error {
 	resolver, err := pvd.Resolver(ref)
 	if err != nil {
 		return err
@@ -149,7 +325,72 @@ func (pvd *Provider) Push(ctx context.Context, desc ocispec.Descriptor, ref stri
 		MaxConcurrentUploadedLayers: LayerConcurrentLimit,
 	}
 
-	return push(ctx, pvd.store, rc, desc, ref)
+	rc.HandlerWrapper = retryPushHandler
+	var sem *semaphore.Weighted
+	if pvd.limiter != nil {
+		sem = pvd.limiter.Semaphore()
+		rc.HandlerWrapper = func(handler images.Handler) images.Handler {
+			return pvd.observeHandler(retryPushHandler(handler))
+		}
+	}
+
+	return push(ctx, &pushProgressStore{pvd.store}, rc, desc, ref, sem)
+}
+
+// SetAdaptiveLimiter makes the concurrency of pulling and pushing layers
+// adjusted by the adaptive limiter instead of LayerConcurrentLimit.
+func (pvd *Provider) SetAdaptiveLimiter(limiter *utils.AdaptiveLimiter) {
+	pvd.limiter = limiter
+}
+
+// AdaptiveLimiter returns the adaptive limiter, nil if not set.
+func (pvd *Provider) AdaptiveLimiter() *utils.AdaptiveLimiter {
+	return pvd.limiter
+}
+
+// retryPushHandler retries the push of each blob or manifest failed by
+// transient errors by PushRetryPolicy. The blobs already pushed are skipped
+// by the existence check of pusher, so the retry only pushes the failed
+// blob again.
+func retryPushHandler(handler images.Handler) images.Handler {
+	return images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		for attempt := 0; ; attempt++ {
+			children, err := handler.Handle(ctx, desc)
+			if err == nil || attempt >= PushRetryPolicy.Retries || !retryableError(err) {
+				return children, err
+			}
+			delay := PushRetryPolicy.Delay(attempt, nil)
+			logrus.WithFields(logrus.Fields{
+				"digest":  desc.Digest,
+				"attempt": attempt + 1,
+				"delay":   delay,
+			}).WithError(err).Warn("push failed, retrying")
+			if err := utils.SleepContext(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
+	})
+}
+
+// observeHandler reports the size of transferred layers to limiter.
+func (pvd *Provider) observeHandler(handler images.Handler) images.Handler {
+	return images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		children, err := handler.Handle(ctx, desc)
+		if err == nil && images.IsLayerType(desc.MediaType) {
+			pvd.limiter.Observe(desc.Size)
+		}
+		return children, err
+	})
+}
+
+// AddPushHook registers a hook called around pushing image.
+func (pvd *Provider) AddPushHook(hook PushHook) {
+	pvd.pushHooks = append(pvd.pushHooks, hook)
+}
+
+// AddPullHook registers a hook called after pulling image.
+func (pvd *Provider) AddPullHook(hook PullHook) {
+	pvd.pullHooks = append(pvd.pullHooks, hook)
 }
 
 func (pvd *Provider) Image(_ context.Context, ref string) (*ocispec.Descriptor, error) {
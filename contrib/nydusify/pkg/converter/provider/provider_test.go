@@ -0,0 +1,71 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/containerd/containerd/images"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestUsePlainHTTPFor(t *testing.T) {
+	pvd, err := New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, pvd.UsePlainHTTPFor("localhost:5000/app:latest"))
+	require.True(t, pvd.plainHTTP("localhost:5000"))
+	require.False(t, pvd.plainHTTP("docker.io"))
+	require.Error(t, pvd.UsePlainHTTPFor("Invalid"))
+
+	pvd.UsePlainHTTP()
+	require.True(t, pvd.plainHTTP("docker.io"))
+}
+
+func TestRetryPushHandler(t *testing.T) {
+	defer func(policy utils.RetryPolicy) {
+		PushRetryPolicy = policy
+	}(PushRetryPolicy)
+	PushRetryPolicy = utils.RetryPolicy{Retries: 2}
+
+	calls := 0
+	errs := []error{
+		remoteserrors.ErrUnexpectedStatus{StatusCode: http.StatusServiceUnavailable},
+		io.ErrUnexpectedEOF,
+		remoteserrors.ErrUnexpectedStatus{StatusCode: http.StatusBadGateway},
+	}
+	handler := retryPushHandler(images.HandlerFunc(func(context.Context, ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		calls++
+		if calls <= len(errs) {
+			return nil, errs[calls-1]
+		}
+		return nil, nil
+	}))
+
+	// The push fails after all retries are used up.
+	_, err := handler.Handle(context.Background(), ocispec.Descriptor{})
+	require.Equal(t, errs[2], err)
+	require.Equal(t, 3, calls)
+
+	// The client error isn't retried.
+	calls = 0
+	errs = []error{remoteserrors.ErrUnexpectedStatus{StatusCode: http.StatusUnauthorized}}
+	_, err = handler.Handle(context.Background(), ocispec.Descriptor{})
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+
+	calls = 0
+	errs = []error{io.ErrUnexpectedEOF}
+	_, err = handler.Handle(context.Background(), ocispec.Descriptor{})
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+}
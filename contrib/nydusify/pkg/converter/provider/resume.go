@@ -0,0 +1,91 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/dustin/go-humanize"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// LayerPullRetries is the number of retries after a blob download is
+// interrupted, 0 disables the retry.
+var LayerPullRetries = 3
+
+// LayerPullRetryInterval is the interval before the first retry, which is
+// doubled for each following retry.
+var LayerPullRetryInterval = time.Second
+
+// retryableError returns true if the download or push may succeed by
+// retrying, the client errors like not found or unauthorized are not
+// retried.
+func retryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errdefs.IsNotFound(err) || errdefs.IsInvalidArgument(err) || errdefs.IsAlreadyExists(err) {
+		return false
+	}
+	var statusErr remoteserrors.ErrUnexpectedStatus
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError ||
+			statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// resumableFetchHandler is like remotes.FetchHandler, but retries the blob
+// download after it's aborted, e.g. the connection is reset, or the
+// registry fails the reconnection after the stream is cut. The partially downloaded data is kept in the
+// ingest of content store, it's verified by digesting again when the writer
+// is reopened, then the retry resumes from the offset by HTTP Range request
+// rather than restarting the blob. The ingest is discarded if the blob
+// doesn't match the digest in the end, so that the next retry restarts.
+func resumableFetchHandler(store content.Store, fetcher remotes.Fetcher, retries int, interval time.Duration) images.HandlerFunc {
+	fetch := remotes.FetchHandler(store, fetcher)
+	return func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		ref := remotes.MakeRefKey(ctx, desc)
+		for attempt := 0; ; attempt++ {
+			children, err := fetch(ctx, desc)
+			if err == nil || attempt >= retries || !retryableError(err) {
+				return children, err
+			}
+
+			fields := logrus.Fields{
+				"digest":  desc.Digest,
+				"attempt": attempt + 1,
+			}
+			if errdefs.IsFailedPrecondition(err) {
+				if abortErr := store.Abort(ctx, ref); abortErr != nil && !errdefs.IsNotFound(abortErr) {
+					return nil, errors.Wrapf(abortErr, "discard corrupted download of %s", desc.Digest)
+				}
+				logrus.WithFields(fields).WithError(err).Warn("blob download corrupted, restarting")
+			} else if status, statusErr := store.Status(ctx, ref); statusErr == nil && status.Offset > 0 {
+				logrus.WithFields(fields).WithError(err).Warnf(
+					"blob download interrupted, resuming from %s of %s",
+					humanize.Bytes(uint64(status.Offset)), humanize.Bytes(uint64(desc.Size)),
+				)
+			} else {
+				logrus.WithFields(fields).WithError(err).Warn("blob download interrupted, retrying")
+			}
+
+			select {
+			case <-time.After(interval << attempt):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes/docker"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyRegistry serves a blob, the first request is interrupted after
+// sending half of the blob, and the reconnection fails.
+type flakyRegistry struct {
+	blob []byte
+
+	mutex  sync.Mutex
+	ranges []string
+}
+
+func (registry *flakyRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.URL.Path, "/blobs/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	registry.mutex.Lock()
+	requests := len(registry.ranges)
+	registry.ranges = append(registry.ranges, r.Header.Get("Range"))
+	registry.mutex.Unlock()
+
+	switch requests {
+	case 0:
+		w.Header().Set("Content-Length", fmt.Sprint(len(registry.blob)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(registry.blob[:len(registry.blob)/2])
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+		return
+	case 1:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var offset int
+	fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset)
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(registry.blob)-1, len(registry.blob)))
+	w.Header().Set("Content-Length", fmt.Sprint(len(registry.blob)-offset))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(registry.blob[offset:])
+}
+
+func TestResumableFetchHandler(t *testing.T) {
+	blob := bytes.Repeat([]byte("nydus"), 64<<10)
+	registry := &flakyRegistry{blob: blob}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	resolver := docker.NewResolver(docker.ResolverOptions{
+		Hosts: docker.ConfigureDefaultRegistries(docker.WithPlainHTTP(docker.MatchAllHosts)),
+	})
+	fetcher, err := resolver.Fetcher(context.Background(), strings.TrimPrefix(server.URL, "http://")+"/app:latest")
+	require.NoError(t, err)
+	store, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+	}
+	handler := resumableFetchHandler(store, fetcher, 1, time.Millisecond)
+	_, err = handler(context.Background(), desc)
+	require.NoError(t, err)
+	resume := fmt.Sprintf("bytes=%d-", len(blob)/2)
+	require.Equal(t, []string{"", resume, resume}, registry.ranges)
+
+	data, err := content.ReadBlob(context.Background(), store, desc)
+	require.NoError(t, err)
+	require.Equal(t, blob, data)
+}
+
+func TestRetryableFetchError(t *testing.T) {
+	require.False(t, retryableError(nil))
+	require.False(t, retryableError(context.Canceled))
+	require.False(t, retryableError(errdefs.ErrNotFound))
+	require.False(t, retryableError(remoteserrors.ErrUnexpectedStatus{StatusCode: http.StatusUnauthorized}))
+	require.True(t, retryableError(remoteserrors.ErrUnexpectedStatus{StatusCode: http.StatusBadGateway}))
+	require.True(t, retryableError(io.ErrUnexpectedEOF))
+	require.True(t, retryableError(fmt.Errorf("commit: %w", errdefs.ErrFailedPrecondition)))
+}
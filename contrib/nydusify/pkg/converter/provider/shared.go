@@ -0,0 +1,36 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+// sharedFetchHandler downloads the blob referenced by multiple descriptors
+// in an image once, e.g. the identical layers of different platforms which
+// are dispatched concurrently. The duplicate fetches wait for the in-flight
+// one instead of polling for the lock of content ingest.
+func sharedFetchHandler(fetch images.HandlerFunc) images.HandlerFunc {
+	var group singleflight.Group
+	return func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		children, err, shared := group.Do(desc.Digest.String(), func() (interface{}, error) {
+			return fetch(ctx, desc)
+		})
+		if shared {
+			logrus.WithField("digest", desc.Digest).Debug("shared blob download")
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The children are discovered by the following children handler.
+		result, _ := children.([]ocispec.Descriptor)
+		return result, nil
+	}
+}
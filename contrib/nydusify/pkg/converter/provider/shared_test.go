@@ -0,0 +1,53 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedFetchHandler(t *testing.T) {
+	var fetched int32
+	handler := sharedFetchHandler(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		atomic.AddInt32(&fetched, 1)
+		time.Sleep(100 * time.Millisecond)
+		if desc.Size < 0 {
+			return nil, errors.New("fetch failed")
+		}
+		return nil, nil
+	})
+
+	// The identical layers of platforms are fetched once.
+	layer := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("layer"), Size: 5}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := handler(context.Background(), layer)
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+
+	// The blob is fetched again once the in-flight fetch is done.
+	_, err := handler(context.Background(), layer)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&fetched))
+
+	layer.Size = -1
+	_, err = handler(context.Background(), layer)
+	require.Error(t, err)
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"os"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/cache"
+)
+
+// sourceCacheStore imports the source layers from the source layer cache
+// instead of downloading them, and saves the downloaded layers into the
+// cache. The fetch of layer is skipped if it already exists in content
+// store, so the cached layer is imported when its writer is opened.
+type sourceCacheStore struct {
+	content.Store
+	cache *cache.SourceCache
+}
+
+type sourceCacheWriter struct {
+	content.Writer
+	store *sourceCacheStore
+	desc  ocispec.Descriptor
+}
+
+func (s *sourceCacheStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return s.Store.Writer(ctx, opts...)
+		}
+	}
+	desc := wOpts.Desc
+	if !images.IsLayerType(desc.MediaType) || desc.Digest.Validate() != nil {
+		return s.Store.Writer(ctx, opts...)
+	}
+	if s.cache.Get(desc.Digest, desc.Size) {
+		err := s.importLayer(ctx, desc)
+		if err == nil {
+			logrus.Infof("hit source cache for layer %s", desc.Digest)
+			return nil, errors.Wrapf(errdefs.ErrAlreadyExists, "layer %s imported from source cache", desc.Digest)
+		}
+		logrus.WithError(err).Warnf("failed to import layer %s from source cache", desc.Digest)
+	}
+
+	writer, err := s.Store.Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &sourceCacheWriter{Writer: writer, store: s, desc: desc}, nil
+}
+
+func (s *sourceCacheStore) importLayer(ctx context.Context, desc ocispec.Descriptor) error {
+	file, err := os.Open(s.cache.BlobPath(desc.Digest))
+	if err != nil {
+		return errors.Wrap(err, "open cached layer")
+	}
+	defer file.Close()
+	if err := content.WriteBlob(ctx, s.Store, "source-cache-"+desc.Digest.String(), file, desc); err != nil {
+		return errors.Wrap(err, "write cached layer to content store")
+	}
+	return nil
+}
+
+func (w *sourceCacheWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	if err := w.Writer.Commit(ctx, size, expected, opts...); err != nil {
+		return err
+	}
+	if err := w.store.save(ctx, w.desc); err != nil {
+		logrus.WithError(err).Warnf("failed to save layer %s to source cache", w.desc.Digest)
+	}
+	return nil
+}
+
+func (s *sourceCacheStore) save(ctx context.Context, desc ocispec.Descriptor) error {
+	ra, err := s.Store.ReaderAt(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer ra.Close()
+	return s.cache.Put(desc.Digest, content.NewReader(ra))
+}
+
+// SetSourceCache caches the source layers pulled by the provider in the
+// source layer cache, and imports the cached ones instead of downloading.
+func (pvd *Provider) SetSourceCache(sourceCache *cache.SourceCache) {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	pvd.sourceCache = sourceCache
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/cache"
+)
+
+type countingFetcher struct {
+	blobs   map[digest.Digest][]byte
+	fetches int32
+}
+
+func (fetcher *countingFetcher) Fetch(_ context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	atomic.AddInt32(&fetcher.fetches, 1)
+	return io.NopCloser(bytes.NewReader(fetcher.blobs[desc.Digest])), nil
+}
+
+func TestSourceCacheStore(t *testing.T) {
+	layer := bytes.Repeat([]byte("layer"), 1024)
+	config := []byte("{}")
+	layerDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+	configDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))}
+	fetcher := &countingFetcher{blobs: map[digest.Digest][]byte{layerDesc.Digest: layer, configDesc.Digest: config}}
+
+	sourceCache, err := cache.NewSourceCache(t.TempDir(), 0)
+	require.NoError(t, err)
+	ctx := context.Background()
+	pull := func() content.Store {
+		store, err := local.NewStore(t.TempDir())
+		require.NoError(t, err)
+		fetch := remotes.FetchHandler(&sourceCacheStore{Store: store, cache: sourceCache}, fetcher)
+		for _, desc := range []ocispec.Descriptor{configDesc, layerDesc} {
+			_, err := fetch(ctx, desc)
+			require.NoError(t, err)
+		}
+		return store
+	}
+
+	// The downloaded layer is cached, but not the config.
+	pull()
+	require.Equal(t, int32(2), fetcher.fetches)
+	require.True(t, sourceCache.Get(layerDesc.Digest, layerDesc.Size))
+	require.False(t, sourceCache.Get(configDesc.Digest, configDesc.Size))
+
+	// The cached layer is imported by the conversion with another content
+	// store instead of downloading.
+	store := pull()
+	require.Equal(t, int32(3), fetcher.fetches)
+	data, err := content.ReadBlob(ctx, store, layerDesc)
+	require.NoError(t, err)
+	require.Equal(t, layer, data)
+}
@@ -0,0 +1,311 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const defaultPublishTimeout = time.Minute
+
+// Publication is the result of successful conversion passed to publishers.
+type Publication struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// TargetDigest and MediaType are of the root manifest or index pushed
+	// to target, which includes the compatible manifests if any.
+	TargetDigest string `json:"target_digest,omitempty"`
+	MediaType    string `json:"media_type,omitempty"`
+
+	Time time.Time `json:"time"`
+	// Duration is the elapsed seconds of the whole conversion.
+	Duration float64 `json:"duration"`
+}
+
+// Publisher publishes the converted target image to external systems, like
+// updating a release database, registering in an artifact catalog or
+// posting a chat message.
+type Publisher interface {
+	Publish(ctx context.Context, pub Publication) error
+}
+
+// PublisherConfig configures a publisher in the publisher config file.
+type PublisherConfig struct {
+	// Name identifies the publisher in logs, defaults to its type.
+	Name string `json:"name,omitempty"`
+	// Type is `exec`, `http` or the one registered by RegisterPublisher.
+	Type string `json:"type"`
+	// Required fails the conversion if the publisher fails, otherwise the
+	// failure is only logged as the image has been pushed.
+	Required bool `json:"required,omitempty"`
+	// Timeout is the duration like `30s`, defaults to 1 minute.
+	Timeout string `json:"timeout,omitempty"`
+
+	// Command is the command line of exec publisher, which reads the
+	// publication JSON from STDIN.
+	Command []string `json:"command,omitempty"`
+	// URL is the endpoint of http publisher, which receives the
+	// publication JSON by POST request. The environment variables like
+	// `${TOKEN}` in URL and Headers are expanded.
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Retries is the max retries of http publisher on the transient
+	// errors.
+	Retries int `json:"retries,omitempty"`
+
+	// Options are the options of publisher registered by
+	// RegisterPublisher.
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// PublisherFactory creates the publisher by its config.
+type PublisherFactory func(config PublisherConfig) (Publisher, error)
+
+var publisherFactories = map[string]PublisherFactory{
+	"exec": newExecPublisher,
+	"http": newHTTPPublisher,
+}
+
+// RegisterPublisher registers the factory of publisher type, so that the
+// programs using nydusify as a package can add their own publishers. It
+// should be called before loading the publisher config.
+func RegisterPublisher(typ string, factory PublisherFactory) {
+	publisherFactories[typ] = factory
+}
+
+type configuredPublisher struct {
+	config    PublisherConfig
+	timeout   time.Duration
+	publisher Publisher
+}
+
+// Publishing is the publishers invoked in order after successful
+// conversion.
+type Publishing struct {
+	publishers []configuredPublisher
+}
+
+// NewPublishing creates the publishers by configs.
+func NewPublishing(configs []PublisherConfig) (*Publishing, error) {
+	publishing := &Publishing{}
+	for idx, config := range configs {
+		if config.Name == "" {
+			config.Name = fmt.Sprintf("%s#%d", config.Type, idx)
+		}
+		factory, ok := publisherFactories[config.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q of publisher %s", config.Type, config.Name)
+		}
+		timeout := defaultPublishTimeout
+		if config.Timeout != "" {
+			var err error
+			if timeout, err = time.ParseDuration(config.Timeout); err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q of publisher %s", config.Timeout, config.Name)
+			}
+		}
+		publisher, err := factory(config)
+		if err != nil {
+			return nil, errors.Wrapf(err, "create publisher %s", config.Name)
+		}
+		publishing.publishers = append(publishing.publishers, configuredPublisher{
+			config:    config,
+			timeout:   timeout,
+			publisher: publisher,
+		})
+	}
+	return publishing, nil
+}
+
+// LoadPublishing loads the publishers from json file in the form of
+// `{"publishers": [PublisherConfig...]}`.
+func LoadPublishing(file string) (*Publishing, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read publisher config file")
+	}
+	var config struct {
+		Publishers []PublisherConfig `json:"publishers"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrap(err, "unmarshal publisher config file")
+	}
+	return NewPublishing(config.Publishers)
+}
+
+// Publish invokes all publishers in order, the failures of required
+// publishers are returned after all are invoked.
+func (publishing *Publishing) Publish(ctx context.Context, pub Publication) error {
+	var requiredErr error
+	for _, configured := range publishing.publishers {
+		name := configured.config.Name
+		publishCtx, cancel := context.WithTimeout(ctx, configured.timeout)
+		err := configured.publisher.Publish(publishCtx, pub)
+		cancel()
+		if err == nil {
+			logrus.Infof("published %s by %s", pub.Target, name)
+			continue
+		}
+		if !configured.config.Required {
+			logrus.WithError(err).Warnf("failed to publish %s by %s", pub.Target, name)
+			continue
+		}
+		logrus.WithError(err).Errorf("failed to publish %s by required %s", pub.Target, name)
+		if requiredErr == nil {
+			requiredErr = errors.Wrapf(err, "publish by %s", name)
+		}
+	}
+	return requiredErr
+}
+
+// publishRecorder records the root descriptors pushed to the references.
+type publishRecorder struct {
+	mutex  sync.Mutex
+	refs   map[string]bool
+	pushed map[string]ocispec.Descriptor
+}
+
+func addPublishRecorder(pvd *provider.Provider, refs ...string) *publishRecorder {
+	recorder := &publishRecorder{
+		refs:   map[string]bool{},
+		pushed: map[string]ocispec.Descriptor{},
+	}
+	for _, ref := range refs {
+		recorder.refs[ref] = true
+	}
+	pvd.AddPushHook(provider.PushHook{
+		AfterPush: func(_ context.Context, desc ocispec.Descriptor, ref string) error {
+			if recorder.refs[ref] {
+				recorder.mutex.Lock()
+				recorder.pushed[ref] = desc
+				recorder.mutex.Unlock()
+			}
+			return nil
+		},
+	})
+	return recorder
+}
+
+func (recorder *publishRecorder) publication(opt Opt, start time.Time) Publication {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	pub := Publication{
+		Source:   opt.Source,
+		Target:   opt.Target,
+		Time:     start.UTC(),
+		Duration: time.Since(start).Seconds(),
+	}
+	if desc, ok := recorder.pushed[opt.Target]; ok {
+		pub.TargetDigest = desc.Digest.String()
+		pub.MediaType = desc.MediaType
+	}
+	return pub
+}
+
+// execPublisher runs the command with the publication JSON in STDIN, and
+// the main fields in environment variables.
+type execPublisher struct {
+	command []string
+}
+
+func newExecPublisher(config PublisherConfig) (Publisher, error) {
+	if len(config.Command) == 0 {
+		return nil, errors.New("command is required by exec publisher")
+	}
+	return &execPublisher{command: config.Command}, nil
+}
+
+func (publisher *execPublisher) Publish(ctx context.Context, pub Publication) error {
+	data, err := json.Marshal(pub)
+	if err != nil {
+		return errors.Wrap(err, "marshal publication")
+	}
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, publisher.command[0], publisher.command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.Env = append(os.Environ(),
+		"NYDUSIFY_PUBLISH_SOURCE="+pub.Source,
+		"NYDUSIFY_PUBLISH_TARGET="+pub.Target,
+		"NYDUSIFY_PUBLISH_TARGET_DIGEST="+pub.TargetDigest,
+	)
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "run %s: %s", publisher.command[0], strings.TrimSpace(output.String()))
+	}
+	logrus.Debugf("output of publisher %s: %s", publisher.command[0], output.String())
+	return nil
+}
+
+// httpPublisher posts the publication JSON to the URL.
+type httpPublisher struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTPPublisher(config PublisherConfig) (Publisher, error) {
+	if config.URL == "" {
+		return nil, errors.New("url is required by http publisher")
+	}
+	if config.Retries < 0 {
+		return nil, errors.New("retries of http publisher can't be negative")
+	}
+	headers := map[string]string{}
+	for key, value := range config.Headers {
+		headers[key] = os.ExpandEnv(value)
+	}
+	return &httpPublisher{
+		url:     os.ExpandEnv(config.URL),
+		headers: headers,
+		client: &http.Client{
+			Transport: &utils.RetryTransport{
+				Transport: http.DefaultTransport,
+				Policy:    utils.NewRetryPolicy(config.Retries),
+			},
+		},
+	}, nil
+}
+
+func (publisher *httpPublisher) Publish(ctx context.Context, pub Publication) error {
+	data, err := json.Marshal(pub)
+	if err != nil {
+		return errors.Wrap(err, "marshal publication")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, publisher.url, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range publisher.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := publisher.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "post publication")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("post publication: status %s, %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+type funcPublisher func(ctx context.Context, pub Publication) error
+
+func (publisher funcPublisher) Publish(ctx context.Context, pub Publication) error {
+	return publisher(ctx, pub)
+}
+
+func TestLoadPublishing(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "publishers.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"publishers": [
+		{"type": "exec", "command": ["true"], "timeout": "10s"},
+		{"name": "catalog", "type": "http", "url": "http://catalog/api", "required": true}
+	]}`), 0644))
+	publishing, err := LoadPublishing(file)
+	require.NoError(t, err)
+	require.Len(t, publishing.publishers, 2)
+	require.Equal(t, "exec#0", publishing.publishers[0].config.Name)
+	require.Equal(t, 10*time.Second, publishing.publishers[0].timeout)
+	require.Equal(t, defaultPublishTimeout, publishing.publishers[1].timeout)
+
+	for config, expected := range map[string]string{
+		`{"type": "chat"}`: `unknown type "chat" of publisher chat#0`,
+		`{"type": "exec"}`: "command is required by exec publisher",
+		`{"type": "http"}`: "url is required by http publisher",
+		`{"type": "http", "url": "u", "retries": -1}`:           "retries of http publisher can't be negative",
+		`{"type": "exec", "command": ["true"], "timeout": "1"}`: `invalid timeout "1" of publisher exec#0`,
+	} {
+		var publisherConfig PublisherConfig
+		require.NoError(t, json.Unmarshal([]byte(config), &publisherConfig))
+		_, err := NewPublishing([]PublisherConfig{publisherConfig})
+		require.ErrorContains(t, err, expected)
+	}
+
+	// The publisher registered by package users.
+	RegisterPublisher("chat", func(config PublisherConfig) (Publisher, error) {
+		var options struct {
+			Channel string `json:"channel"`
+		}
+		if err := json.Unmarshal(config.Options, &options); err != nil {
+			return nil, err
+		}
+		return funcPublisher(func(context.Context, Publication) error { return nil }), nil
+	})
+	defer delete(publisherFactories, "chat")
+	_, err = NewPublishing([]PublisherConfig{{Type: "chat", Options: json.RawMessage(`{"channel": "release"}`)}})
+	require.NoError(t, err)
+}
+
+func TestPublish(t *testing.T) {
+	dir := t.TempDir()
+	pub := Publication{Source: "nginx:latest", Target: "nginx:nydus", TargetDigest: digest.FromString("target").String()}
+
+	// The exec publisher reads the publication from STDIN and environment.
+	output := filepath.Join(dir, "output")
+	execPublisher, err := newExecPublisher(PublisherConfig{Command: []string{
+		"sh", "-c", `cat > ` + output + ` && echo "$NYDUSIFY_PUBLISH_TARGET@$NYDUSIFY_PUBLISH_TARGET_DIGEST" >> ` + output,
+	}})
+	require.NoError(t, err)
+	require.NoError(t, execPublisher.Publish(context.Background(), pub))
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"target":"nginx:nydus"`)
+	require.Contains(t, string(data), "nginx:nydus@"+pub.TargetDigest)
+
+	failedPublisher, err := newExecPublisher(PublisherConfig{Command: []string{"sh", "-c", "echo denied >&2; exit 1"}})
+	require.NoError(t, err)
+	require.ErrorContains(t, failedPublisher.Publish(context.Background(), pub), "run sh: denied")
+
+	// The http publisher posts the publication, and retries on the
+	// transient errors.
+	requests := 0
+	var received Publication
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, "invalid token")
+			return
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+	t.Setenv("PUBLISH_TOKEN", "secret")
+	httpPublisher, err := newHTTPPublisher(PublisherConfig{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer ${PUBLISH_TOKEN}"},
+		Retries: 1,
+	})
+	require.NoError(t, err)
+	require.NoError(t, httpPublisher.Publish(context.Background(), pub))
+	require.Equal(t, 2, requests)
+	require.Equal(t, pub, received)
+
+	t.Setenv("PUBLISH_TOKEN", "invalid")
+	httpPublisher, err = newHTTPPublisher(PublisherConfig{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer ${PUBLISH_TOKEN}"}})
+	require.NoError(t, err)
+	require.ErrorContains(t, httpPublisher.Publish(context.Background(), pub), "status 401 Unauthorized, invalid token")
+
+	// Only the failures of required publishers fail the conversion.
+	invoked := []string{}
+	publisher := func(name string, err error) configuredPublisher {
+		return configuredPublisher{
+			config:  PublisherConfig{Name: name, Required: name == "required"},
+			timeout: time.Minute,
+			publisher: funcPublisher(func(context.Context, Publication) error {
+				invoked = append(invoked, name)
+				return err
+			}),
+		}
+	}
+	publishing := &Publishing{publishers: []configuredPublisher{
+		publisher("optional", io.EOF), publisher("required", io.ErrUnexpectedEOF), publisher("last", nil),
+	}}
+	require.EqualError(t, publishing.Publish(context.Background(), pub), "publish by required: unexpected EOF")
+	require.Equal(t, []string{"optional", "required", "last"}, invoked)
+	publishing.publishers = publishing.publishers[:1]
+	require.NoError(t, publishing.Publish(context.Background(), pub))
+}
+
+func TestPublishRecorder(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	recorder := addPublishRecorder(pvd, "nginx:nydus")
+
+	push := func(ref string, desc ocispec.Descriptor) {
+		pvd.SetExporter(ref, func(context.Context, ocispec.Descriptor) error { return nil })
+		require.NoError(t, pvd.Push(ctx, desc, ref))
+	}
+	target := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: digest.FromString("target")}
+	push("nginx:nydus", target)
+	push("nginx:cache", ocispec.Descriptor{Digest: digest.FromString("cache")})
+
+	start := time.Now()
+	pub := recorder.publication(Opt{Source: "nginx:latest", Target: "nginx:nydus"}, start)
+	require.Equal(t, Publication{
+		Source:       "nginx:latest",
+		Target:       "nginx:nydus",
+		TargetDigest: target.Digest.String(),
+		MediaType:    ocispec.MediaTypeImageIndex,
+		Time:         start.UTC(),
+		Duration:     pub.Duration,
+	}, pub)
+}
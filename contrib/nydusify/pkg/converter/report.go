@@ -0,0 +1,335 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// annotationSourceDigest is appended to the Nydus manifest by
+// acceleration-service to indicate the source manifest digest.
+const annotationSourceDigest = "containerd.io/snapshot/nydus-source-digest"
+
+// LayerReport compares a source layer with the converted Nydus blob.
+type LayerReport struct {
+	SourceDigest digest.Digest `json:"source_digest"`
+	SourceSize   int64         `json:"source_size"`
+	TargetDigest digest.Digest `json:"target_digest"`
+	TargetSize   int64         `json:"target_size"`
+	// CompressionRatio is the target size divided by the source size.
+	CompressionRatio float64 `json:"compression_ratio"`
+}
+
+// ManifestReport is the conversion statistics of a platform.
+type ManifestReport struct {
+	Platform       string        `json:"platform,omitempty"`
+	SourceDigest   digest.Digest `json:"source_digest"`
+	TargetDigest   digest.Digest `json:"target_digest"`
+	SourceSize     int64         `json:"source_size"`
+	TargetSize     int64         `json:"target_size"`
+	BootstrapSize  int64         `json:"bootstrap_size"`
+	ChunkDictBytes int64         `json:"chunk_dict_bytes"`
+	CacheBytes     int64         `json:"cache_bytes"`
+	NewBlobBytes   int64         `json:"new_blob_bytes"`
+	// Layers is empty if the converted blobs can't be matched with source
+	// layers one by one, for example some layers have no file data.
+	Layers []LayerReport `json:"layers"`
+}
+
+// Report is the conversion report comparing the source image with the
+// converted Nydus image, all sizes are in bytes.
+type Report struct {
+	Source     string  `json:"source"`
+	Target     string  `json:"target"`
+	SourceSize int64   `json:"source_size"`
+	TargetSize int64   `json:"target_size"`
+	SizeRatio  float64 `json:"size_ratio"`
+	// ChunkDictBytes is the size of blobs referenced from chunk dict.
+	ChunkDictBytes int64 `json:"chunk_dict_bytes"`
+	// CacheBytes is the size of blobs reused from build cache.
+	CacheBytes int64 `json:"cache_bytes"`
+	// NewBlobBytes is the size of blobs newly built by this conversion.
+	NewBlobBytes int64 `json:"new_blob_bytes"`
+	// EstimatedPullSavings is the bytes saved on pulling before container
+	// start, only the bootstrap needs to be pulled as blob data is lazily
+	// loaded on demand.
+	EstimatedPullSavings int64            `json:"estimated_pull_savings"`
+	Manifests            []ManifestReport `json:"manifests"`
+}
+
+func ratio(target, source int64) float64 {
+	if source == 0 {
+		return 0
+	}
+	return float64(target) / float64(source)
+}
+
+// reporter collects the conversion statistics when target image is pushed.
+type reporter struct {
+	mutex     sync.Mutex
+	pvd       *provider.Provider
+	target    string
+	chunkDict map[digest.Digest]bool
+	cache     map[digest.Digest]bool
+	report    Report
+}
+
+func newReporter(pvd *provider.Provider, source, target string) (*reporter, error) {
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	return &reporter{
+		pvd:       pvd,
+		target:    named.String(),
+		chunkDict: map[digest.Digest]bool{},
+		cache:     map[digest.Digest]bool{},
+		report: Report{
+			Source:    source,
+			Target:    target,
+			Manifests: []ManifestReport{},
+		},
+	}, nil
+}
+
+// prepare collects the blobs in chunk dict and build cache image, it must
+// be called before conversion as the cache image is updated after that.
+func (rpt *reporter) prepare(ctx context.Context, chunkDictRef, cacheRef string) {
+	for ref, blobs := range map[string]map[digest.Digest]bool{
+		chunkDictRef: rpt.chunkDict,
+		cacheRef:     rpt.cache,
+	} {
+		if ref == "" {
+			continue
+		}
+		if err := rpt.fetchBlobs(ctx, ref, blobs); err != nil {
+			logrus.Warnf("failed to fetch blobs of %s for conversion report: %s", ref, err)
+		}
+	}
+}
+
+func (rpt *reporter) fetchBlobs(ctx context.Context, ref string, blobs map[digest.Digest]bool) error {
+	resolver, err := rpt.pvd.Resolver(ref)
+	if err != nil {
+		return err
+	}
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrap(err, "resolve reference")
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return errors.Wrap(err, "get fetcher")
+	}
+	return fetchBlobs(ctx, fetcher, desc, blobs)
+}
+
+func fetchJSON(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, res interface{}) error {
+	reader, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, res)
+}
+
+func fetchBlobs(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, blobs map[digest.Digest]bool) error {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if err := fetchJSON(ctx, fetcher, desc, &index); err != nil {
+			return errors.Wrap(err, "fetch index")
+		}
+		for _, manifest := range index.Manifests {
+			if err := fetchBlobs(ctx, fetcher, manifest, blobs); err != nil {
+				return err
+			}
+		}
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if err := fetchJSON(ctx, fetcher, desc, &manifest); err != nil {
+			return errors.Wrap(err, "fetch manifest")
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType == nydusifyUtils.MediaTypeNydusBlob {
+				blobs[layer.Digest] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (rpt *reporter) hook() provider.PushHook {
+	return provider.PushHook{
+		AfterPush: rpt.afterPush,
+	}
+}
+
+func (rpt *reporter) afterPush(ctx context.Context, desc ocispec.Descriptor, ref string) error {
+	if ref != rpt.target {
+		return nil
+	}
+
+	rpt.mutex.Lock()
+	defer rpt.mutex.Unlock()
+
+	// The push may be retried with plain HTTP.
+	rpt.report.Manifests = []ManifestReport{}
+	if err := rpt.walk(ctx, desc, nil); err != nil {
+		return errors.Wrap(err, "collect conversion report")
+	}
+
+	report := &rpt.report
+	report.SourceSize, report.TargetSize = 0, 0
+	report.ChunkDictBytes, report.CacheBytes, report.NewBlobBytes = 0, 0, 0
+	var bootstrapSize int64
+	for _, manifest := range report.Manifests {
+		report.SourceSize += manifest.SourceSize
+		report.TargetSize += manifest.TargetSize
+		report.ChunkDictBytes += manifest.ChunkDictBytes
+		report.CacheBytes += manifest.CacheBytes
+		report.NewBlobBytes += manifest.NewBlobBytes
+		bootstrapSize += manifest.BootstrapSize
+	}
+	report.SizeRatio = ratio(report.TargetSize, report.SourceSize)
+	report.EstimatedPullSavings = report.SourceSize - bootstrapSize
+	if report.EstimatedPullSavings < 0 {
+		report.EstimatedPullSavings = 0
+	}
+
+	return nil
+}
+
+func (rpt *reporter) walk(ctx context.Context, desc ocispec.Descriptor, platform *ocispec.Platform) error {
+	cs := rpt.pvd.ContentStore()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return errors.Wrap(err, "read index json")
+		}
+		for _, manifest := range index.Manifests {
+			if isCompatManifest(manifest) {
+				continue
+			}
+			if err := rpt.walk(ctx, manifest, manifest.Platform); err != nil {
+				return err
+			}
+		}
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return errors.Wrap(err, "read manifest json")
+		}
+		sourceDigest := digest.Digest(manifest.Annotations[annotationSourceDigest])
+		if sourceDigest == "" {
+			// Skip the OCI manifest in merged index.
+			return nil
+		}
+		var source ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &source, ocispec.Descriptor{Digest: sourceDigest}); err != nil {
+			return errors.Wrapf(err, "read source manifest %s", sourceDigest)
+		}
+		item := rpt.compare(source, manifest)
+		item.SourceDigest = sourceDigest
+		item.TargetDigest = desc.Digest
+		if platform != nil {
+			item.Platform = platforms.Format(*platform)
+		}
+		rpt.report.Manifests = append(rpt.report.Manifests, item)
+	}
+
+	return nil
+}
+
+func (rpt *reporter) compare(source, target ocispec.Manifest) ManifestReport {
+	item := ManifestReport{
+		Layers: []LayerReport{},
+	}
+	for _, layer := range source.Layers {
+		item.SourceSize += layer.Size
+	}
+
+	if bootstrapDesc := parser.FindNydusBootstrapDesc(&target); bootstrapDesc != nil {
+		item.BootstrapSize = bootstrapDesc.Size
+	}
+
+	// The blobs referenced from chunk dict aren't converted from source
+	// layers, others are in the same order with source layers.
+	converted := []ocispec.Descriptor{}
+	for _, layer := range target.Layers {
+		item.TargetSize += layer.Size
+		if layer.MediaType != nydusifyUtils.MediaTypeNydusBlob {
+			continue
+		}
+		switch {
+		case rpt.chunkDict[layer.Digest]:
+			item.ChunkDictBytes += layer.Size
+			continue
+		case rpt.cache[layer.Digest]:
+			item.CacheBytes += layer.Size
+		default:
+			item.NewBlobBytes += layer.Size
+		}
+		converted = append(converted, layer)
+	}
+
+	if len(converted) == len(source.Layers) {
+		for idx, layer := range source.Layers {
+			item.Layers = append(item.Layers, LayerReport{
+				SourceDigest:     layer.Digest,
+				SourceSize:       layer.Size,
+				TargetDigest:     converted[idx].Digest,
+				TargetSize:       converted[idx].Size,
+				CompressionRatio: ratio(converted[idx].Size, layer.Size),
+			})
+		}
+	}
+
+	return item
+}
+
+// dump writes the report to file in JSON format.
+func (rpt *reporter) dump(path string) error {
+	rpt.mutex.Lock()
+	defer rpt.mutex.Unlock()
+
+	data, err := json.MarshalIndent(rpt.report, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal conversion report")
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrapf(err, "write conversion report to %s", path)
+	}
+	return nil
+}
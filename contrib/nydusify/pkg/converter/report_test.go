@@ -0,0 +1,129 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestReporter(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	layer1 := digest.FromString("layer1")
+	layer2 := digest.FromString("layer2")
+	sourceDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{
+			{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: layer1, Size: 1000},
+			{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: layer2, Size: 500},
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+
+	dictBlob := digest.FromString("dict")
+	cacheBlob := digest.FromString("cache")
+	newBlob := digest.FromString("new")
+	bootstrap := digest.FromString("bootstrap")
+	targetDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{
+			{MediaType: nydusifyUtils.MediaTypeNydusBlob, Digest: dictBlob, Size: 300},
+			{MediaType: nydusifyUtils.MediaTypeNydusBlob, Digest: cacheBlob, Size: 800},
+			{MediaType: nydusifyUtils.MediaTypeNydusBlob, Digest: newBlob, Size: 250},
+			{
+				MediaType: ocispec.MediaTypeImageLayerGzip,
+				Digest:    bootstrap,
+				Size:      50,
+				Annotations: map[string]string{
+					nydusifyUtils.LayerAnnotationNydusBootstrap: "true",
+				},
+			},
+		},
+		Annotations: map[string]string{
+			annotationSourceDigest: sourceDesc.Digest.String(),
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+
+	rpt, err := newReporter(pvd, "nydus/test:latest", "nydus/test:nydus")
+	require.NoError(t, err)
+	rpt.chunkDict[dictBlob] = true
+	rpt.cache[cacheBlob] = true
+
+	// Other references are ignored.
+	require.NoError(t, rpt.afterPush(ctx, *targetDesc, "docker.io/nydus/cache:latest"))
+	require.Empty(t, rpt.report.Manifests)
+
+	ref := "docker.io/nydus/test:nydus"
+	require.NoError(t, rpt.afterPush(ctx, *targetDesc, ref))
+	// Retried push replaces the report.
+	require.NoError(t, rpt.afterPush(ctx, *targetDesc, ref))
+	require.Equal(t, Report{
+		Source:               "nydus/test:latest",
+		Target:               "nydus/test:nydus",
+		SourceSize:           1500,
+		TargetSize:           1400,
+		SizeRatio:            1400.0 / 1500,
+		ChunkDictBytes:       300,
+		CacheBytes:           800,
+		NewBlobBytes:         250,
+		EstimatedPullSavings: 1450,
+		Manifests: []ManifestReport{
+			{
+				SourceDigest:   sourceDesc.Digest,
+				TargetDigest:   targetDesc.Digest,
+				SourceSize:     1500,
+				TargetSize:     1400,
+				BootstrapSize:  50,
+				ChunkDictBytes: 300,
+				CacheBytes:     800,
+				NewBlobBytes:   250,
+				Layers: []LayerReport{
+					{
+						SourceDigest:     layer1,
+						SourceSize:       1000,
+						TargetDigest:     cacheBlob,
+						TargetSize:       800,
+						CompressionRatio: 0.8,
+					},
+					{
+						SourceDigest:     layer2,
+						SourceSize:       500,
+						TargetDigest:     newBlob,
+						TargetSize:       250,
+						CompressionRatio: 0.5,
+					},
+				},
+			},
+		},
+	}, rpt.report)
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, rpt.dump(path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Equal(t, rpt.report, report)
+}
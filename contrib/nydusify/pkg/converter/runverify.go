@@ -0,0 +1,204 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/pkg/snapshotters"
+	"github.com/containerd/containerd/platforms"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const (
+	defaultContainerdAddress = "/run/containerd/containerd.sock"
+	defaultRunSnapshotter    = "nydus"
+	defaultRunTimeout        = 30 * time.Second
+	// runOutputLimit is the size of the tail of container output kept for
+	// the report.
+	runOutputLimit = 4096
+)
+
+// RunVerification verifies the target image end to end after conversion by
+// starting a short-lived container from it via containerd with
+// nydus-snapshotter on the host. The image, container and snapshot are
+// removed after the run.
+type RunVerification struct {
+	// Address is the containerd socket, default to
+	// `/run/containerd/containerd.sock`.
+	Address string
+	// Snapshotter is the name of nydus-snapshotter in containerd, default
+	// to `nydus`.
+	Snapshotter string
+	// Command overrides the process of image. The explicit command must
+	// exit with zero in Timeout, while the default process of image passes
+	// as well if it's still running by then, e.g. a server.
+	Command []string
+	// Timeout is the time to wait for the container, default to 30s.
+	Timeout time.Duration
+}
+
+// ParseRunCommand parses the command in the exec form of JSON array like
+// `["sh", "-c", "nginx -t"]`, or in the whitespace separated words.
+func ParseRunCommand(command string) ([]string, error) {
+	command = strings.TrimSpace(command)
+	if !strings.HasPrefix(command, "[") {
+		return strings.Fields(command), nil
+	}
+	var args []string
+	if err := json.Unmarshal([]byte(command), &args); err != nil {
+		return nil, errors.Wrap(err, "parse command in JSON array")
+	}
+	return args, nil
+}
+
+// tailBuffer keeps the tail of container output, it's written by the
+// copiers of stdout and stderr concurrently.
+type tailBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.buf.Write(p)
+	if overflow := b.buf.Len() - runOutputLimit; overflow > 0 {
+		b.buf.Next(overflow)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return strings.TrimSpace(b.buf.String())
+}
+
+// runTarget runs the target image of host platform in a container, the
+// image is pulled by the resolver of provider with the credentials of
+// target registry.
+func runTarget(ctx context.Context, pvd *provider.Provider, opt Opt) error {
+	verify := *opt.VerifyRun
+	if verify.Address == "" {
+		verify.Address = defaultContainerdAddress
+	}
+	if verify.Snapshotter == "" {
+		verify.Snapshotter = defaultRunSnapshotter
+	}
+	if verify.Timeout <= 0 {
+		verify.Timeout = defaultRunTimeout
+	}
+
+	client, err := containerd.New(verify.Address)
+	if err != nil {
+		return errors.Wrap(err, "connect to containerd")
+	}
+	defer client.Close()
+	resolver, err := pvd.Resolver(opt.Target)
+	if err != nil {
+		return errors.Wrap(err, "create resolver")
+	}
+
+	logrus.Infof("running target image %s by containerd with %s snapshotter", opt.Target, verify.Snapshotter)
+	image, err := client.Pull(ctx, opt.Target,
+		containerd.WithResolver(resolver),
+		containerd.WithPlatformMatcher(platforms.Default()),
+		containerd.WithPullUnpack,
+		containerd.WithPullSnapshotter(verify.Snapshotter),
+		// The labels let nydus-snapshotter prepare the layers remotely.
+		containerd.WithImageHandlerWrapper(snapshotters.AppendInfoHandlerWrapper(opt.Target)),
+	)
+	if err != nil {
+		return errors.Wrap(err, "pull target image")
+	}
+	defer func() {
+		if err := client.ImageService().Delete(ctx, image.Name(), images.SynchronousDelete()); err != nil {
+			logrus.WithError(err).Warnf("failed to remove image %s from containerd", image.Name())
+		}
+	}()
+
+	id := fmt.Sprintf("nydusify-verify-%d", time.Now().UnixNano())
+	specOpts := []oci.SpecOpts{oci.WithImageConfig(image)}
+	if len(verify.Command) > 0 {
+		specOpts = append(specOpts, oci.WithProcessArgs(verify.Command...))
+	}
+	container, err := client.NewContainer(ctx, id,
+		containerd.WithSnapshotter(verify.Snapshotter),
+		containerd.WithNewSnapshot(id, image),
+		containerd.WithNewSpec(specOpts...),
+	)
+	if err != nil {
+		return errors.Wrap(err, "create container")
+	}
+	defer func() {
+		if err := container.Delete(ctx, containerd.WithSnapshotCleanup); err != nil {
+			logrus.WithError(err).Warnf("failed to remove container %s", id)
+		}
+	}()
+
+	var output tailBuffer
+	task, err := container.NewTask(ctx, cio.NewCreator(cio.WithStreams(nil, &output, &output)))
+	if err != nil {
+		return errors.Wrap(err, "create task")
+	}
+	defer func() {
+		if _, err := task.Delete(ctx, containerd.WithProcessKill); err != nil {
+			logrus.WithError(err).Warnf("failed to remove task of container %s", id)
+		}
+	}()
+	exitCh, err := task.Wait(ctx)
+	if err != nil {
+		return errors.Wrap(err, "wait task")
+	}
+	if err := task.Start(ctx); err != nil {
+		return errors.Wrap(err, "start task")
+	}
+
+	timer := time.NewTimer(verify.Timeout)
+	defer timer.Stop()
+	select {
+	case status := <-exitCh:
+		code, _, err := status.Result()
+		if err != nil {
+			return errors.Wrap(err, "get exit status")
+		}
+		if code != 0 {
+			err := fmt.Errorf("container of target image exited with code %d: %s", code, output.String())
+			return nydusifyUtils.WithExitCode(err, nydusifyUtils.ExitCheckMismatch)
+		}
+	case <-timer.C:
+		if err := task.Kill(ctx, syscall.SIGKILL); err != nil {
+			logrus.WithError(err).Warnf("failed to kill container %s", id)
+		}
+		<-exitCh
+		if len(verify.Command) > 0 {
+			err := fmt.Errorf("container of target image didn't exit in %s: %s", verify.Timeout, output.String())
+			return nydusifyUtils.WithExitCode(err, nydusifyUtils.ExitCheckMismatch)
+		}
+		logrus.Infof("container of target image is still running after %s", verify.Timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	logrus.Infof("verified target image %s by running container", opt.Target)
+	logrus.Debugf("output of container: %s", output.String())
+	return nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRunCommand(t *testing.T) {
+	for command, expected := range map[string][]string{
+		"":                                    {},
+		"  nginx -t ":                         {"nginx", "-t"},
+		`["sh", "-c", "nginx -t && echo ok"]`: {"sh", "-c", "nginx -t && echo ok"},
+	} {
+		args, err := ParseRunCommand(command)
+		require.NoError(t, err)
+		require.Equal(t, expected, args, command)
+	}
+	_, err := ParseRunCommand(`["sh", "-c"`)
+	require.Error(t, err)
+}
+
+func TestRunVerification(t *testing.T) {
+	var output tailBuffer
+	output.Write([]byte("starting\n"))
+	output.Write(bytes.Repeat([]byte("x"), runOutputLimit))
+	output.Write([]byte("\nready\n"))
+	require.Len(t, output.String(), runOutputLimit-1)
+	require.True(t, bytes.HasSuffix([]byte(output.String()), []byte("x\nready")))
+
+	opt := Opt{Source: "docker.io/library/nginx:latest", Target: "oci:./nginx-nydus", VerifyRun: &RunVerification{}}
+	_, _, err := parseTransports(&opt)
+	require.ErrorContains(t, err, "image in oci transport can't be run after conversion")
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	nydusConverter "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+// sharedLayerStore builds the source layer referenced by multiple manifests
+// once, e.g. the identical layers of different platforms which are converted
+// concurrently, or the repeated empty layers of an image. A layer is being
+// built while its source is read, the queries of its info wait for the build,
+// then the source layer is labeled with the built target digest, so that the
+// layer conversion of nydus-snapshotter skips building it again.
+type sharedLayerStore struct {
+	content.Store
+	mutex sync.Mutex
+	// building maps the source layers being read to the channels closed
+	// once they're done.
+	building map[digest.Digest]chan struct{}
+	// converted maps the source layers to the built target layers.
+	converted map[digest.Digest]digest.Digest
+}
+
+type sharedLayerReaderAt struct {
+	content.ReaderAt
+	once sync.Once
+	done func()
+}
+
+func (ra *sharedLayerReaderAt) Close() error {
+	ra.once.Do(ra.done)
+	return ra.ReaderAt.Close()
+}
+
+type sharedLayerWriter struct {
+	content.Writer
+	store  *sharedLayerStore
+	source digest.Digest
+}
+
+func (s *sharedLayerStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	s.mutex.Lock()
+	done := s.building[dgst]
+	s.mutex.Unlock()
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return content.Info{}, ctx.Err()
+		}
+	}
+
+	info, err := s.Store.Info(ctx, dgst)
+	if err != nil || info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest] != "" {
+		return info, err
+	}
+	s.mutex.Lock()
+	target := s.converted[dgst]
+	s.mutex.Unlock()
+	if target == "" {
+		return info, nil
+	}
+
+	labels := map[string]string{}
+	for key, value := range info.Labels {
+		labels[key] = value
+	}
+	labels[nydusConverter.LayerAnnotationNydusTargetDigest] = target.String()
+	info.Labels = labels
+	return info, nil
+}
+
+func (s *sharedLayerStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	if !isSourceLayer(desc) {
+		return s.Store.ReaderAt(ctx, desc)
+	}
+	s.mutex.Lock()
+	if _, ok := s.building[desc.Digest]; ok {
+		s.mutex.Unlock()
+		return s.Store.ReaderAt(ctx, desc)
+	}
+	done := make(chan struct{})
+	s.building[desc.Digest] = done
+	s.mutex.Unlock()
+
+	finish := func() {
+		s.mutex.Lock()
+		delete(s.building, desc.Digest)
+		s.mutex.Unlock()
+		close(done)
+	}
+	ra, err := s.Store.ReaderAt(ctx, desc)
+	if err != nil {
+		finish()
+		return nil, err
+	}
+	return &sharedLayerReaderAt{ReaderAt: ra, done: finish}, nil
+}
+
+func (s *sharedLayerStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	writer, err := s.Store.Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return writer, nil
+		}
+	}
+	source := digest.Digest(strings.TrimPrefix(wOpts.Ref, convertedLayerRefPrefix))
+	if !strings.HasPrefix(wOpts.Ref, convertedLayerRefPrefix) || source.Validate() != nil {
+		return writer, nil
+	}
+	return &sharedLayerWriter{Writer: writer, store: s, source: source}, nil
+}
+
+func (w *sharedLayerWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	err := w.Writer.Commit(ctx, size, expected, opts...)
+	if err != nil && !errdefs.IsAlreadyExists(err) {
+		return err
+	}
+	w.store.mutex.Lock()
+	w.store.converted[w.source] = w.Writer.Digest()
+	w.store.mutex.Unlock()
+	return err
+}
+
+// withSharedLayers runs the conversion fn with the source layers shared by
+// manifests built once, the built layers are only shared in the conversion
+// as they're bound to its build options.
+func withSharedLayers(pvd *provider.Provider, fn func() error) error {
+	store := pvd.ContentStore()
+	pvd.SetContentStore(&sharedLayerStore{
+		Store:     store,
+		building:  map[digest.Digest]chan struct{}{},
+		converted: map[digest.Digest]digest.Digest{},
+	})
+	defer pvd.SetContentStore(store)
+	return fn()
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	nydusConverter "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+func TestSharedLayerStore(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	store := pvd.ContentStore()
+	write := func(cs content.Store, ref string, data []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayerGzip,
+			Digest:    digest.FromBytes(data),
+			Size:      int64(len(data)),
+		}
+		require.NoError(t, content.WriteBlob(ctx, cs, ref, bytes.NewReader(data), desc))
+		return desc
+	}
+	source := write(store, "source", []byte("source layer"))
+
+	require.NoError(t, withSharedLayers(pvd, func() error {
+		cs := pvd.ContentStore()
+		require.NotEqual(t, store, cs)
+
+		// The info query waits for the layer being built.
+		ra, err := cs.ReaderAt(ctx, source)
+		require.NoError(t, err)
+		queried := make(chan content.Info, 1)
+		go func() {
+			info, _ := cs.Info(ctx, source.Digest)
+			queried <- info
+		}()
+		time.Sleep(50 * time.Millisecond)
+		require.Empty(t, queried)
+
+		target := write(cs, convertedLayerRefPrefix+source.Digest.String(), []byte("nydus layer"))
+		require.NoError(t, ra.Close())
+		info := <-queried
+		require.Equal(t, target.Digest.String(), info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest])
+
+		// The layer isn't labeled in content store.
+		info, err = store.Info(ctx, source.Digest)
+		require.NoError(t, err)
+		require.Empty(t, info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest])
+		return nil
+	}))
+	require.Equal(t, store, pvd.ContentStore())
+
+	// The built layers aren't shared with the next conversion.
+	require.NoError(t, withSharedLayers(pvd, func() error {
+		info, err := pvd.ContentStore().Info(ctx, source.Digest)
+		require.NoError(t, err)
+		require.Empty(t, info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest])
+		return nil
+	}))
+}
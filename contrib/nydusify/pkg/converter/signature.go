@@ -0,0 +1,242 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/reference/docker"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const defaultCosignPath = "cosign"
+
+// SourceVerification declares the cosign signature constraints of source
+// image, which is verified by either the public key or the certificate
+// identity and issuer of keyless signing.
+type SourceVerification struct {
+	// CosignPath is the path of cosign binary, default to search in PATH.
+	CosignPath string
+	// Key is the path or KMS URI of the public key.
+	Key string
+	// CertificateIdentity and CertificateOIDCIssuer are the regexps of the
+	// identity and OIDC issuer in certificate of keyless signing.
+	CertificateIdentity   string
+	CertificateOIDCIssuer string
+}
+
+// Validate checks the verification constraints specified by user.
+func (verification *SourceVerification) Validate() error {
+	if verification.Key != "" {
+		if verification.CertificateIdentity != "" || verification.CertificateOIDCIssuer != "" {
+			return fmt.Errorf("public key conflicts with certificate identity and issuer")
+		}
+		return nil
+	}
+	if verification.CertificateIdentity == "" || verification.CertificateOIDCIssuer == "" {
+		return fmt.Errorf("either public key or both certificate identity and issuer are required to verify source")
+	}
+	return nil
+}
+
+func (verification *SourceVerification) args(ref string, opt Opt) []string {
+	args := []string{"verify", "--output", "text"}
+	if verification.Key != "" {
+		args = append(args, "--key", verification.Key)
+	} else {
+		args = append(args,
+			"--certificate-identity-regexp", verification.CertificateIdentity,
+			"--certificate-oidc-issuer-regexp", verification.CertificateOIDCIssuer,
+		)
+	}
+	if opt.SourceInsecure || opt.SourcePlainHTTP {
+		args = append(args, "--allow-insecure-registry")
+	}
+	if opt.SourcePlainHTTP {
+		args = append(args, "--allow-http-registry")
+	}
+	return append(args, ref)
+}
+
+// verify runs cosign to verify the signatures of image reference.
+func (verification *SourceVerification) verify(ctx context.Context, ref string, opt Opt) error {
+	cosignPath := verification.CosignPath
+	if cosignPath == "" {
+		cosignPath = defaultCosignPath
+	}
+	logrus.Infof("verifying signatures of source image %s", ref)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cosignPath, verification.args(ref, opt)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			err := fmt.Errorf("source image %s is not signed as expected: %s", ref, strings.TrimSpace(stderr.String()))
+			return utils.WithExitCode(err, utils.ExitCheckMismatch)
+		}
+		return errors.Wrapf(err, "run %s", cosignPath)
+	}
+	return nil
+}
+
+// verifySource verifies the cosign signatures of source image, and pins the
+// source to the verified digest, so that the converted image is exactly the
+// one verified even if the tag is moved in the meantime.
+func verifySource(ctx context.Context, pvd *provider.Provider, opt *Opt) error {
+	dgst, err := resolveDigest(ctx, pvd, opt.Source)
+	if err != nil {
+		return errors.Wrap(err, "resolve source image")
+	}
+	named, err := docker.ParseDockerRef(opt.Source)
+	if err != nil {
+		return errors.Wrap(err, "parse source reference")
+	}
+	pinned := fmt.Sprintf("%s@%s", docker.TrimNamed(named).String(), dgst)
+
+	if err := opt.VerifySource.verify(ctx, pinned, *opt); err != nil {
+		return err
+	}
+
+	opt.Source = pinned
+	return nil
+}
+
+const defaultNotationPath = "notation"
+
+// TargetSigning declares how to sign the pushed target image by notation
+// (Notary Project), by a key configured in the notation key store, or a
+// key in remote KMS accessed by notation plugin.
+type TargetSigning struct {
+	// NotationPath is the path of notation binary, default to search in PATH.
+	NotationPath string
+	// Key is the name of signing key in notation key store.
+	Key string
+	// ID and Plugin are the key id and the name of notation plugin, for
+	// the key stored in remote KMS.
+	ID     string
+	Plugin string
+	// PluginConfig is the list of `key=value` passed to notation plugin.
+	PluginConfig []string
+	// SignatureFormat is the envelope format of signature, possible
+	// values: jws, cose, default to the one of notation.
+	SignatureFormat string
+}
+
+// Validate checks the signing options specified by user.
+func (signing *TargetSigning) Validate() error {
+	if signing.Key != "" {
+		if signing.ID != "" || signing.Plugin != "" {
+			return fmt.Errorf("key name conflicts with key id and plugin")
+		}
+	} else if signing.ID == "" || signing.Plugin == "" {
+		return fmt.Errorf("either key name or both key id and plugin are required to sign target")
+	}
+	for _, config := range signing.PluginConfig {
+		if !strings.Contains(config, "=") {
+			return fmt.Errorf("invalid plugin config %q, should be in the form of key=value", config)
+		}
+	}
+	switch signing.SignatureFormat {
+	case "", "jws", "cose":
+	default:
+		return fmt.Errorf("unsupported signature format %s", signing.SignatureFormat)
+	}
+	return nil
+}
+
+func (signing *TargetSigning) args(ref string, opt Opt) []string {
+	args := []string{"sign"}
+	if signing.Key != "" {
+		args = append(args, "--key", signing.Key)
+	} else {
+		args = append(args, "--id", signing.ID, "--plugin", signing.Plugin)
+	}
+	for _, config := range signing.PluginConfig {
+		args = append(args, "--plugin-config", config)
+	}
+	if signing.SignatureFormat != "" {
+		args = append(args, "--signature-format", signing.SignatureFormat)
+	}
+	if opt.TargetPlainHTTP {
+		args = append(args, "--insecure-registry")
+	}
+	return append(args, ref)
+}
+
+// sign runs notation to sign the image reference, which is pinned by digest.
+func (signing *TargetSigning) sign(ctx context.Context, ref string, opt Opt) error {
+	notationPath := signing.NotationPath
+	if notationPath == "" {
+		notationPath = defaultNotationPath
+	}
+	logrus.Infof("signing target image %s", ref)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, notationPath, signing.args(ref, opt)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("failed to sign target image %s: %s", ref, strings.TrimSpace(stderr.String()))
+		}
+		return errors.Wrapf(err, "run %s", notationPath)
+	}
+	return nil
+}
+
+// targetSigner records the descriptors of images pushed to the references,
+// which are signed after the conversion succeeds.
+type targetSigner struct {
+	mutex sync.Mutex
+	refs  []string
+	descs map[string]ocispec.Descriptor
+}
+
+func (signer *targetSigner) hook() provider.PushHook {
+	return provider.PushHook{
+		AfterPush: func(_ context.Context, desc ocispec.Descriptor, ref string) error {
+			signer.mutex.Lock()
+			defer signer.mutex.Unlock()
+			signer.descs[ref] = desc
+			return nil
+		},
+	}
+}
+
+// sign signs the images pushed to the tracked references by digest.
+func (signer *targetSigner) sign(ctx context.Context, opt Opt) error {
+	for _, ref := range signer.refs {
+		desc, ok := signer.descs[ref]
+		if !ok {
+			return fmt.Errorf("image %s to sign is not pushed", ref)
+		}
+		named, err := docker.ParseDockerRef(ref)
+		if err != nil {
+			return errors.Wrap(err, "parse target reference")
+		}
+		pinned := fmt.Sprintf("%s@%s", docker.TrimNamed(named).String(), desc.Digest)
+		if err := opt.SignTarget.sign(ctx, pinned, opt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTargetSigner tracks the images pushed to the references to be signed.
+func addTargetSigner(pvd *provider.Provider, refs ...string) *targetSigner {
+	signer := &targetSigner{
+		refs:  refs,
+		descs: map[string]ocispec.Descriptor{},
+	}
+	pvd.AddPushHook(signer.hook())
+	return signer
+}
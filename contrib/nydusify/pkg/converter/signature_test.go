@@ -0,0 +1,130 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceVerificationValidate(t *testing.T) {
+	require.NoError(t, (&SourceVerification{Key: "cosign.pub"}).Validate())
+	require.NoError(t, (&SourceVerification{
+		CertificateIdentity:   "^https://github.com/org/",
+		CertificateOIDCIssuer: "^https://token.actions.githubusercontent.com$",
+	}).Validate())
+	require.Error(t, (&SourceVerification{}).Validate())
+	require.Error(t, (&SourceVerification{CertificateIdentity: "me@example.com"}).Validate())
+	require.Error(t, (&SourceVerification{Key: "cosign.pub", CertificateIdentity: "me@example.com"}).Validate())
+}
+
+func TestSourceVerificationVerify(t *testing.T) {
+	dir := t.TempDir()
+	argsPath := filepath.Join(dir, "args")
+	cosignPath := filepath.Join(dir, "cosign")
+	// The fake cosign only accepts the images signed by `signed` key.
+	require.NoError(t, os.WriteFile(cosignPath, []byte(`#!/bin/sh
+echo "$@" > `+argsPath+`
+if [ "$4" = "--key" ] && [ "$5" = "signed.pub" ]; then
+	exit 0
+fi
+echo "Error: no matching signatures" >&2
+exit 1
+`), 0755))
+
+	ctx := context.Background()
+	ref := "localhost:5000/app@sha256:" + strings.Repeat("0", 64)
+
+	verification := &SourceVerification{CosignPath: cosignPath, Key: "signed.pub"}
+	require.NoError(t, verification.verify(ctx, ref, Opt{SourcePlainHTTP: true}))
+	args, err := os.ReadFile(argsPath)
+	require.NoError(t, err)
+	require.Equal(t, "verify --output text --key signed.pub --allow-insecure-registry --allow-http-registry "+ref+"\n", string(args))
+
+	verification = &SourceVerification{
+		CosignPath:            cosignPath,
+		CertificateIdentity:   "me@example.com",
+		CertificateOIDCIssuer: "https://accounts.google.com",
+	}
+	err = verification.verify(ctx, ref, Opt{})
+	require.ErrorContains(t, err, "not signed as expected: Error: no matching signatures")
+	args, err = os.ReadFile(argsPath)
+	require.NoError(t, err)
+	require.Equal(t, "verify --output text --certificate-identity-regexp me@example.com --certificate-oidc-issuer-regexp https://accounts.google.com "+ref+"\n", string(args))
+
+	verification = &SourceVerification{CosignPath: filepath.Join(dir, "missing"), Key: "signed.pub"}
+	require.Error(t, verification.verify(ctx, ref, Opt{}))
+}
+
+func TestTargetSigningValidate(t *testing.T) {
+	require.NoError(t, (&TargetSigning{Key: "release"}).Validate())
+	require.NoError(t, (&TargetSigning{
+		ID:              "arn:aws:signer:us-east-1:000000000000:/signing-profiles/release",
+		Plugin:          "com.amazonaws.signer.notation.plugin",
+		PluginConfig:    []string{"region=us-east-1"},
+		SignatureFormat: "cose",
+	}).Validate())
+	require.Error(t, (&TargetSigning{}).Validate())
+	require.Error(t, (&TargetSigning{ID: "key-id"}).Validate())
+	require.Error(t, (&TargetSigning{Key: "release", Plugin: "kms"}).Validate())
+	require.Error(t, (&TargetSigning{Key: "release", PluginConfig: []string{"region"}}).Validate())
+	require.Error(t, (&TargetSigning{Key: "release", SignatureFormat: "pgp"}).Validate())
+}
+
+func TestTargetSignerSign(t *testing.T) {
+	dir := t.TempDir()
+	argsPath := filepath.Join(dir, "args")
+	notationPath := filepath.Join(dir, "notation")
+	// The fake notation only knows the `release` key.
+	require.NoError(t, os.WriteFile(notationPath, []byte(`#!/bin/sh
+echo "$@" >> `+argsPath+`
+if [ "$2" = "--key" ] && [ "$3" = "release" ]; then
+	exit 0
+fi
+echo "Error: signing key not found" >&2
+exit 1
+`), 0755))
+
+	ctx := context.Background()
+	signer := &targetSigner{
+		refs:  []string{"localhost:5000/app:nydus", "localhost:5000/app:nydus-v5"},
+		descs: map[string]ocispec.Descriptor{},
+	}
+	hook := signer.hook()
+	dgst := digest.FromString("index")
+	compatDgst := digest.FromString("compat")
+	require.NoError(t, hook.AfterPush(ctx, ocispec.Descriptor{Digest: dgst}, "localhost:5000/app:nydus"))
+
+	opt := Opt{TargetPlainHTTP: true, SignTarget: &TargetSigning{NotationPath: notationPath, Key: "release"}}
+	require.ErrorContains(t, signer.sign(ctx, opt), "localhost:5000/app:nydus-v5 to sign is not pushed")
+
+	require.NoError(t, hook.AfterPush(ctx, ocispec.Descriptor{Digest: compatDgst}, "localhost:5000/app:nydus-v5"))
+	require.NoError(t, os.Remove(argsPath))
+	require.NoError(t, signer.sign(ctx, opt))
+	args, err := os.ReadFile(argsPath)
+	require.NoError(t, err)
+	require.Equal(t, "sign --key release --insecure-registry localhost:5000/app@"+dgst.String()+"\n"+
+		"sign --key release --insecure-registry localhost:5000/app@"+compatDgst.String()+"\n", string(args))
+
+	opt = Opt{SignTarget: &TargetSigning{
+		NotationPath:    notationPath,
+		ID:              "key-id",
+		Plugin:          "kms",
+		PluginConfig:    []string{"region=us-east-1"},
+		SignatureFormat: "cose",
+	}}
+	require.NoError(t, os.Remove(argsPath))
+	require.ErrorContains(t, signer.sign(ctx, opt), "failed to sign target image localhost:5000/app@"+dgst.String()+": Error: signing key not found")
+	args, err = os.ReadFile(argsPath)
+	require.NoError(t, err)
+	require.Equal(t, "sign --id key-id --plugin kms --plugin-config region=us-east-1 --signature-format cose localhost:5000/app@"+dgst.String()+"\n", string(args))
+}
@@ -0,0 +1,251 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference/docker"
+	nydusConverter "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// sourceLayerStore records the source layer each Nydus blob is converted
+// from, either built by nydus-snapshotter or reused from local cache,
+// previous target or build cache, i.e. the source layer is labeled with
+// the target digest when its info is queried.
+type sourceLayerStore struct {
+	content.Store
+	mutex sync.Mutex
+	// sources maps the Nydus blobs to the source layers, a blob may be
+	// converted from different source layers, e.g. the empty layers
+	// compressed in different ways.
+	sources map[digest.Digest][]digest.Digest
+}
+
+type sourceLayerWriter struct {
+	content.Writer
+	store  *sourceLayerStore
+	source digest.Digest
+}
+
+func (s *sourceLayerStore) record(target, source digest.Digest) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, existing := range s.sources[target] {
+		if existing == source {
+			return
+		}
+	}
+	s.sources[target] = append(s.sources[target], source)
+}
+
+// source returns the source layer of target blob, the one in the layers
+// of source manifest is preferred if there are multiple.
+func (s *sourceLayerStore) source(target digest.Digest, layers map[digest.Digest]bool) digest.Digest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sources := s.sources[target]
+	for _, source := range sources {
+		if layers[source] {
+			return source
+		}
+	}
+	if len(sources) == 1 && layers == nil {
+		return sources[0]
+	}
+	return ""
+}
+
+func (s *sourceLayerStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	info, err := s.Store.Info(ctx, dgst)
+	if err != nil {
+		return info, err
+	}
+	if target := digest.Digest(info.Labels[nydusConverter.LayerAnnotationNydusTargetDigest]); target.Validate() == nil {
+		s.record(target, dgst)
+	}
+	return info, nil
+}
+
+func (s *sourceLayerStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	writer, err := s.Store.Writer(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return writer, nil
+		}
+	}
+	source := digest.Digest(strings.TrimPrefix(wOpts.Ref, convertedLayerRefPrefix))
+	if !strings.HasPrefix(wOpts.Ref, convertedLayerRefPrefix) || source.Validate() != nil {
+		return writer, nil
+	}
+	return &sourceLayerWriter{Writer: writer, store: s, source: source}, nil
+}
+
+func (w *sourceLayerWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	err := w.Writer.Commit(ctx, size, expected, opts...)
+	if err != nil && !errdefs.IsAlreadyExists(err) {
+		return err
+	}
+	w.store.record(w.Writer.Digest(), w.source)
+	return err
+}
+
+// sourceLayerAnnotator annotates the Nydus blob layers of converted image
+// with the digests of source layers they're converted from before it's
+// pushed to target, the chunk dict blobs are left as is.
+type sourceLayerAnnotator struct {
+	pvd   *provider.Provider
+	store *sourceLayerStore
+	refs  map[string]bool
+}
+
+func (annotator *sourceLayerAnnotator) hook() provider.PushHook {
+	return provider.PushHook{
+		BeforePush: annotator.beforePush,
+	}
+}
+
+func (annotator *sourceLayerAnnotator) beforePush(ctx context.Context, desc ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+	if !annotator.refs[ref] {
+		return &desc, nil
+	}
+	return annotator.annotate(ctx, desc)
+}
+
+func (annotator *sourceLayerAnnotator) annotate(ctx context.Context, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	cs := annotator.pvd.ContentStore()
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return nil, errors.Wrap(err, "read index json")
+		}
+		changed := false
+		for idx := range index.Manifests {
+			newDesc, err := annotator.annotate(ctx, index.Manifests[idx])
+			if err != nil {
+				return nil, err
+			}
+			if newDesc.Digest != index.Manifests[idx].Digest {
+				index.Manifests[idx] = *newDesc
+				changed = true
+			}
+		}
+		if !changed {
+			return &desc, nil
+		}
+		return annotator.pvd.WriteJSON(ctx, index, desc)
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+			return nil, errors.Wrap(err, "read manifest json")
+		}
+		layers := annotator.sourceManifestLayers(ctx, manifest)
+		changed := false
+		for idx, layer := range manifest.Layers {
+			if layer.Annotations[nydusifyUtils.LayerAnnotationNydusBlob] != "true" ||
+				layer.Annotations[nydusifyUtils.LayerAnnotationNydusSourceDigest] != "" {
+				continue
+			}
+			source := annotator.store.source(layer.Digest, layers)
+			if source == "" {
+				continue
+			}
+			manifest.Layers[idx].Annotations[nydusifyUtils.LayerAnnotationNydusSourceDigest] = source.String()
+			changed = true
+		}
+		if !changed {
+			return &desc, nil
+		}
+		newDesc, err := annotator.pvd.WriteJSON(ctx, manifest, desc)
+		if err != nil {
+			return nil, errors.Wrap(err, "write manifest json")
+		}
+		return newDesc, nil
+
+	default:
+		return &desc, nil
+	}
+}
+
+// sourceManifestLayers returns the layers of source manifest which the
+// Nydus manifest is converted from, nil if it's unavailable.
+func (annotator *sourceLayerAnnotator) sourceManifestLayers(ctx context.Context, manifest ocispec.Manifest) map[digest.Digest]bool {
+	cs := annotator.pvd.ContentStore()
+	sourceDigest := digest.Digest(manifest.Annotations[annotationSourceDigest])
+	if sourceDigest.Validate() != nil {
+		return nil
+	}
+	sourceDesc, err := localDescriptor(ctx, cs, sourceDigest)
+	if err != nil {
+		return nil
+	}
+	var sourceManifest ocispec.Manifest
+	if _, err := utils.ReadJSON(ctx, cs, &sourceManifest, *sourceDesc); err != nil {
+		return nil
+	}
+	layers := map[digest.Digest]bool{}
+	for _, layer := range sourceManifest.Layers {
+		layers[layer.Digest] = true
+	}
+	return layers
+}
+
+// addSourceLayerAnnotator records the source layers of Nydus blobs built
+// or reused by conversion, and annotates the blob layers of images pushed
+// to refs with them. It must be added after the content stores reusing
+// converted layers, so that their labels are seen.
+func addSourceLayerAnnotator(pvd *provider.Provider, refs ...string) error {
+	store := &sourceLayerStore{
+		Store:   pvd.ContentStore(),
+		sources: map[digest.Digest][]digest.Digest{},
+	}
+	annotator := &sourceLayerAnnotator{
+		pvd:   pvd,
+		store: store,
+		refs:  map[string]bool{},
+	}
+	for _, ref := range refs {
+		named, err := docker.ParseDockerRef(ref)
+		if err != nil {
+			return errors.Wrap(err, "parse reference")
+		}
+		annotator.refs[named.String()] = true
+	}
+	pvd.SetContentStore(store)
+	pvd.AddPushHook(annotator.hook())
+	return nil
+}
+
+// sourceLayers maps the Nydus blob layers of manifest to the source layers
+// recorded in their annotations.
+func sourceLayers(manifest ocispec.Manifest) map[digest.Digest]digest.Digest {
+	layers := map[digest.Digest]digest.Digest{}
+	for _, layer := range manifest.Layers {
+		source := digest.Digest(layer.Annotations[nydusifyUtils.LayerAnnotationNydusSourceDigest])
+		if source.Validate() == nil {
+			layers[layer.Digest] = source
+		}
+	}
+	return layers
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	nydusConverter "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestSourceLayerAnnotator(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	localCache, err := addLocalCache(pvd, Opt{LocalCacheDir: t.TempDir()}, "")
+	require.NoError(t, err)
+	require.NoError(t, addSourceLayerAnnotator(pvd, "nydus/test:latest"))
+	require.NoError(t, localCache.addSeeder(pvd, "nydus/test:latest"))
+	cs := pvd.ContentStore()
+
+	write := func(ref string, data []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+		require.NoError(t, content.WriteBlob(ctx, cs, ref, bytes.NewReader(data), desc))
+		return desc
+	}
+	blobDesc := func(desc ocispec.Descriptor) ocispec.Descriptor {
+		desc.MediaType = nydusifyUtils.MediaTypeNydusBlob
+		desc.Annotations = map[string]string{nydusifyUtils.LayerAnnotationNydusBlob: "true"}
+		return desc
+	}
+
+	// The built layer is recorded by the writer.
+	built := write("source-built", []byte("built source layer"))
+	builtBlob := blobDesc(write(convertedLayerRefPrefix+built.Digest.String(), []byte("built blob")))
+	// The reused layer is recorded by the label of source layer.
+	reused := write("source-reused", []byte("reused source layer"))
+	reusedBlob := blobDesc(write("previous-target", []byte("reused blob")))
+	info, err := cs.Info(ctx, reused.Digest)
+	require.NoError(t, err)
+	info.Labels = map[string]string{nydusConverter.LayerAnnotationNydusTargetDigest: reusedBlob.Digest.String()}
+	_, err = cs.Update(ctx, info, "labels."+nydusConverter.LayerAnnotationNydusTargetDigest)
+	require.NoError(t, err)
+	_, err = cs.Info(ctx, reused.Digest)
+	require.NoError(t, err)
+	// The identical blob converted from another source layer.
+	other := write("source-other", []byte("other source layer"))
+	write(convertedLayerRefPrefix+other.Digest.String(), []byte("built blob"))
+	dictBlob := blobDesc(write("dict", []byte("dict blob")))
+
+	sourceManifest, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers:    []ocispec.Descriptor{built, reused},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	manifestDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers:    []ocispec.Descriptor{dictBlob, builtBlob, reusedBlob},
+		Annotations: map[string]string{
+			annotationSourceDigest: sourceManifest.Digest.String(),
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+
+	push := func(ref string) ocispec.Descriptor {
+		var pushed ocispec.Descriptor
+		pvd.SetExporter(ref, func(_ context.Context, desc ocispec.Descriptor) error {
+			pushed = desc
+			return nil
+		})
+		require.NoError(t, pvd.Push(ctx, *manifestDesc, ref))
+		return pushed
+	}
+	// Other references are ignored.
+	require.Equal(t, *manifestDesc, push("docker.io/nydus/cache:latest"))
+	record, err := localCache.cache.Get(reused.Digest, localCache.options)
+	require.NoError(t, err)
+	require.Nil(t, record)
+
+	var manifest ocispec.Manifest
+	_, err = utils.ReadJSON(ctx, cs, &manifest, push("docker.io/nydus/test:latest"))
+	require.NoError(t, err)
+	require.Equal(t, map[digest.Digest]digest.Digest{
+		builtBlob.Digest:  built.Digest,
+		reusedBlob.Digest: reused.Digest,
+	}, sourceLayers(manifest))
+
+	// The reused layer is saved into local cache by the annotation.
+	record, err = localCache.cache.Get(reused.Digest, localCache.options)
+	require.NoError(t, err)
+	require.Equal(t, reusedBlob.Digest, record.Target)
+}
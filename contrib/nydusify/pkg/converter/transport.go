@@ -0,0 +1,115 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/reference/docker"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/transport"
+)
+
+const (
+	localSourceReference = "localhost/nydusify/source:latest"
+	localTargetReference = "localhost/nydusify/target:latest"
+)
+
+// localReference returns the registry style reference used in provider for
+// the image in transport, the image in containers storage keeps its name.
+func localReference(ref *transport.Reference, fallback string) (string, error) {
+	name := fallback
+	switch ref.Transport {
+	case transport.Docker:
+		return ref.Name, nil
+	case transport.ContainersStorage:
+		name = ref.Name
+	}
+	named, err := docker.ParseDockerRef(name)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse image name %s", name)
+	}
+	return named.String(), nil
+}
+
+// parseTransports parses the source and target references in transport
+// syntax, and replaces them with the references used in provider.
+func parseTransports(opt *Opt) (*transport.Reference, *transport.Reference, error) {
+	source, err := transport.Parse(opt.Source)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse source reference")
+	}
+	target, err := transport.Parse(opt.Target)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse target reference")
+	}
+	if !source.IsRegistry() && opt.VerifySource != nil {
+		return nil, nil, fmt.Errorf("signatures of source image in %s transport can't be verified", source.Transport)
+	}
+	if opt.Verify != nil {
+		for _, ref := range []*transport.Reference{source, target} {
+			if !ref.IsRegistry() {
+				return nil, nil, fmt.Errorf("image in %s transport can't be verified after conversion", ref.Transport)
+			}
+		}
+	}
+	if opt.VerifyRun != nil && !target.IsRegistry() {
+		return nil, nil, fmt.Errorf("image in %s transport can't be run after conversion", target.Transport)
+	}
+	if !target.IsRegistry() {
+		if opt.CompatFsVersion != "" {
+			return nil, nil, fmt.Errorf("compatible image can't be output to %s transport", target.Transport)
+		}
+		if opt.BootstrapPlacement != "" && opt.BootstrapPlacement != BootstrapPlacementLayer {
+			return nil, nil, fmt.Errorf("bootstrap placement %s can't be used with %s transport", opt.BootstrapPlacement, target.Transport)
+		}
+		if opt.Provenance {
+			return nil, nil, fmt.Errorf("provenance attestation can't be output to %s transport", target.Transport)
+		}
+		if opt.BackendObjectLayout != "" {
+			return nil, nil, fmt.Errorf("object layout can't be resolved for %s transport", target.Transport)
+		}
+	}
+
+	if opt.Source, err = localReference(source, localSourceReference); err != nil {
+		return nil, nil, err
+	}
+	if opt.Target, err = localReference(target, localTargetReference); err != nil {
+		return nil, nil, err
+	}
+
+	return source, target, nil
+}
+
+// loadSource loads the source image in local transport into provider.
+func loadSource(ctx context.Context, pvd *provider.Provider, source *transport.Reference, ref, workDir string) error {
+	if source.IsRegistry() {
+		return nil
+	}
+	desc, err := transport.Load(ctx, pvd.ContentStore(), source, workDir)
+	if err != nil {
+		return errors.Wrapf(err, "load source image %s", source)
+	}
+	pvd.AddImage(ref, *desc)
+	return nil
+}
+
+// setTargetExporter makes the target image in local transport saved instead
+// of pushed to registry.
+func setTargetExporter(pvd *provider.Provider, target *transport.Reference, ref, workDir string) {
+	if target.IsRegistry() {
+		return
+	}
+	pvd.SetExporter(ref, func(ctx context.Context, desc ocispec.Descriptor) error {
+		if err := transport.Save(ctx, pvd.ContentStore(), target, desc, workDir); err != nil {
+			return errors.Wrapf(err, "save target image %s", target)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"os"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// filterDigest returns the digest identifying the unpack filter, which is
+// recorded in the manifest annotations, empty if the filter can't be
+// identified.
+func filterDigest(filter nydusifyUtils.UnpackFilter) string {
+	if identified, ok := filter.(interface{ Digest() digest.Digest }); ok {
+		return identified.Digest().String()
+	}
+	return ""
+}
+
+// filteredStore filters the source layers before they're built. The layer
+// is streamed into `nydus-image create` by acceleration-service, so the
+// filtered tar is staged in a temp file of work directory, which is
+// removed once the layer is built.
+type filteredStore struct {
+	content.Store
+	filter nydusifyUtils.UnpackFilter
+	dir    string
+}
+
+type fileReaderAt struct {
+	*os.File
+	size int64
+}
+
+func (ra *fileReaderAt) Size() int64 {
+	return ra.size
+}
+
+func (ra *fileReaderAt) Close() error {
+	err := ra.File.Close()
+	os.Remove(ra.File.Name())
+	return err
+}
+
+func (s *filteredStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	if !isSourceLayer(desc) {
+		return s.Store.ReaderAt(ctx, desc)
+	}
+	ra, err := s.Store.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer ra.Close()
+	reader, err := DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return nil, errors.Wrapf(err, "decompress source layer %s", desc.Digest)
+	}
+	defer reader.Close()
+
+	file, err := os.CreateTemp(s.dir, "filtered-layer-*.tar")
+	if err != nil {
+		return nil, errors.Wrap(err, "create filtered layer file")
+	}
+	dropped, err := nydusifyUtils.FilterTar(reader, file, s.filter)
+	if err == nil {
+		err = file.Sync()
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = file.Stat()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, errors.Wrapf(err, "filter source layer %s", desc.Digest)
+	}
+	logrus.Debugf("filtered source layer %s, dropped %d entries", desc.Digest, dropped)
+
+	return &fileReaderAt{File: file, size: info.Size()}, nil
+}
+
+// addUnpackFilter filters the source layers by filter, the filtered layers
+// are staged in dir.
+func addUnpackFilter(pvd *provider.Provider, filter nydusifyUtils.UnpackFilter, dir string) {
+	if filter == nil {
+		return
+	}
+	pvd.SetContentStore(&filteredStore{
+		Store:  pvd.ContentStore(),
+		filter: filter,
+		dir:    dir,
+	})
+}
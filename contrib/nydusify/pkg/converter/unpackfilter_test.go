@@ -0,0 +1,83 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestFilteredStore(t *testing.T) {
+	ctx := context.Background()
+	base, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	layerData := &bytes.Buffer{}
+	gw := gzip.NewWriter(layerData)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{"app/main", "var/log/app.log"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(name))}))
+		_, err := tw.Write([]byte(name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	write := func(mediaType string, data []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+		require.NoError(t, content.WriteBlob(ctx, base, desc.Digest.String(), bytes.NewReader(data), desc))
+		return desc
+	}
+	layer := write(ocispec.MediaTypeImageLayerGzip, layerData.Bytes())
+	config := write(ocispec.MediaTypeImageConfig, []byte("{}"))
+
+	dir := t.TempDir()
+	rules := &nydusifyUtils.FilterRules{Drop: []string{"*.log"}}
+	store := &filteredStore{Store: base, filter: rules, dir: dir}
+
+	// The config isn't filtered.
+	ra, err := store.ReaderAt(ctx, config)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), ra.Size())
+	require.NoError(t, ra.Close())
+
+	ra, err = store.ReaderAt(ctx, layer)
+	require.NoError(t, err)
+	names := []string{}
+	tr := tar.NewReader(content.NewReader(ra))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	require.Equal(t, []string{"app/main"}, names)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// The staged layer is removed once closed.
+	require.NoError(t, ra.Close())
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.Equal(t, rules.Digest().String(), optionAnnotations(Opt{UnpackFilter: rules}, "")[nydusifyUtils.ManifestNydusUnpackFilter])
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// Verification verifies the target image against the source image by the
+// checker after conversion, the filesystem of target image is mounted by
+// nydusd (or unpacked by nydus-image in native mode) and compared with the
+// unpacked source layers, including file metadata, xattrs and data hashes.
+type Verification struct {
+	NydusdPath string
+	// Native reads the target image by `nydus-image unpack` instead of
+	// mounting it by nydusd, without FUSE.
+	Native bool
+	// Report is the file to save the discrepancies found in JSON, the
+	// platform is appended to the file name for multi-platform image,
+	// e.g. `report.json.linux-arm64`.
+	Report string
+}
+
+// verifyArches returns the architectures of the converted platforms, which
+// are verified one by one.
+func verifyArches(ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, opt Opt) ([]string, error) {
+	index, err := sourceIndex(ctx, pvd, opt.Source)
+	if err != nil {
+		return nil, err
+	}
+	if index == nil {
+		// The arch is ignored by checker for the single manifest image.
+		return []string{runtime.GOARCH}, nil
+	}
+	arches := []string{}
+	seen := map[string]bool{}
+	for _, manifest := range index.Manifests {
+		if manifest.Platform == nil || !images.IsManifestType(manifest.MediaType) {
+			continue
+		}
+		platform := *manifest.Platform
+		if !platformMC.Match(platform) || seen[platform.Architecture] {
+			continue
+		}
+		seen[platform.Architecture] = true
+		if platform.OS != "linux" || !nydusifyUtils.IsSupportedArch(platform.Architecture) {
+			logrus.Warnf("skip verifying unsupported platform %s", platforms.Format(platform))
+			continue
+		}
+		arches = append(arches, platform.Architecture)
+	}
+	return arches, nil
+}
+
+// verifyTarget verifies the target image of each converted platform, the
+// check outputs are saved in workDir.
+func verifyTarget(ctx context.Context, pvd *provider.Provider, platformMC platforms.MatchComparer, opt Opt, nydusImagePath, workDir string) error {
+	arches, err := verifyArches(ctx, pvd, platformMC, opt)
+	if err != nil {
+		return errors.Wrap(err, "get platforms to verify")
+	}
+	for _, arch := range arches {
+		report := opt.Verify.Report
+		if report != "" && len(arches) > 1 {
+			report = fmt.Sprintf("%s.linux-%s", report, arch)
+		}
+		logrus.Infof("verifying target image %s of platform linux/%s", opt.Target, arch)
+		ck, err := checker.New(checker.Opt{
+			WorkDir:          filepath.Join(workDir, "verify-"+arch),
+			Source:           opt.Source,
+			Target:           opt.Target,
+			SourceInsecure:   opt.SourceInsecure,
+			TargetInsecure:   opt.TargetInsecure,
+			NydusImagePath:   nydusImagePath,
+			NydusdPath:       opt.Verify.NydusdPath,
+			BackendType:      opt.BackendType,
+			BackendConfig:    opt.BackendConfig,
+			ExpectedArch:     arch,
+			Native:           opt.Verify.Native,
+			UnpackFilter:     opt.UnpackFilter,
+			FilesystemReport: report,
+		})
+		if err != nil {
+			return errors.Wrap(err, "create checker")
+		}
+		if err := ck.Check(ctx); err != nil {
+			return errors.Wrapf(err, "verify target image of platform linux/%s", arch)
+		}
+	}
+	return nil
+}
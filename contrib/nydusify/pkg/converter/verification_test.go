@@ -0,0 +1,72 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+func TestVerifyArches(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	manifests := []ocispec.Descriptor{}
+	for _, platform := range []ocispec.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		{OS: "linux", Architecture: "mips64le"},
+		{OS: "unknown", Architecture: "unknown"},
+	} {
+		desc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+		}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+		require.NoError(t, err)
+		platform := platform
+		desc.Platform = &platform
+		manifests = append(manifests, *desc)
+	}
+	indexDesc, err := utils.WriteJSON(ctx, cs, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: manifests,
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}, "", nil)
+	require.NoError(t, err)
+	pvd.AddImage("localhost/app:latest", *indexDesc)
+
+	opt := Opt{Source: "localhost/app:latest"}
+	arches, err := verifyArches(ctx, pvd, platforms.All, opt)
+	require.NoError(t, err)
+	require.Equal(t, []string{"amd64", "arm64"}, arches)
+	arches, err = verifyArches(ctx, pvd, platforms.Only(ocispec.Platform{OS: "linux", Architecture: "arm64"}), opt)
+	require.NoError(t, err)
+	require.Equal(t, []string{"arm64"}, arches)
+
+	pvd.AddImage("localhost/app:latest", manifests[0])
+	arches, err = verifyArches(ctx, pvd, platforms.All, opt)
+	require.NoError(t, err)
+	require.Equal(t, []string{runtime.GOARCH}, arches)
+
+	// The image out of registry can't be verified.
+	_, _, err = parseTransports(&Opt{
+		Source: "docker-archive:/tmp/app.tar",
+		Target: "localhost/app:latest-nydus",
+		Verify: &Verification{},
+	})
+	require.ErrorContains(t, err, "image in docker-archive transport can't be verified after conversion")
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// pushVerifier fetches the pushed manifests back by digest to verify the
+// registry stored exactly what was sent, as some registries rewrite the
+// manifests, for example converting the media type.
+type pushVerifier struct {
+	pvd *provider.Provider
+}
+
+func (verifier *pushVerifier) hook() provider.PushHook {
+	return provider.PushHook{
+		AfterPush: verifier.afterPush,
+	}
+}
+
+func (verifier *pushVerifier) afterPush(ctx context.Context, desc ocispec.Descriptor, ref string) error {
+	resolver, err := verifier.pvd.Resolver(ref)
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(ref, "@") {
+		_, resolved, err := resolver.Resolve(ctx, ref)
+		if err != nil {
+			return errors.Wrapf(err, "resolve pushed image %s", ref)
+		}
+		if resolved.Digest != desc.Digest {
+			return nydusifyUtils.WithExitCode(fmt.Errorf(
+				"registry rewrote the pushed image %s: pushed %s (%s), but the tag resolves to %s (%s), please check whether the registry supports the media type",
+				ref, desc.Digest, desc.MediaType, resolved.Digest, resolved.MediaType,
+			), nydusifyUtils.ExitPush)
+		}
+	}
+
+	fetcher, err := resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return errors.Wrap(err, "create fetcher")
+	}
+	if err := verifier.verify(ctx, fetcher, desc, ref); err != nil {
+		return err
+	}
+	logrus.Infof("verified pushed image %s@%s", ref, desc.Digest)
+
+	return nil
+}
+
+func (verifier *pushVerifier) verify(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, ref string) error {
+	reader, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return errors.Wrapf(err, "fetch pushed manifest %s of %s", desc.Digest, ref)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return errors.Wrapf(err, "read pushed manifest %s of %s", desc.Digest, ref)
+	}
+	if fetched := desc.Digest.Algorithm().FromBytes(data); fetched != desc.Digest || int64(len(data)) != desc.Size {
+		return nydusifyUtils.WithExitCode(fmt.Errorf(
+			"registry rewrote the pushed manifest of %s: pushed %s (%d bytes), but fetched %s (%d bytes), please check whether the registry supports the media type %s",
+			ref, desc.Digest, desc.Size, fetched, len(data), desc.MediaType,
+		), nydusifyUtils.ExitPush)
+	}
+
+	if !images.IsIndexType(desc.MediaType) {
+		return nil
+	}
+	var index ocispec.Index
+	if _, err := utils.ReadJSON(ctx, verifier.pvd.ContentStore(), &index, desc); err != nil {
+		return errors.Wrap(err, "read index json")
+	}
+	for _, manifest := range index.Manifests {
+		if err := verifier.verify(ctx, fetcher, manifest, ref); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package converter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+)
+
+type fakeManifest struct {
+	mediaType string
+	data      []byte
+}
+
+func TestPushVerifier(t *testing.T) {
+	manifests := map[string]fakeManifest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		manifest, ok := manifests[strings.TrimPrefix(r.URL.Path, "/v2/nydus/test/manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", manifest.mediaType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest.data)))
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest.data).String())
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(manifest.data)
+		}
+	}))
+	defer server.Close()
+	ref := strings.TrimPrefix(server.URL, "http://") + "/nydus/test:latest"
+
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), func(string) (remote.CredentialFunc, bool, error) {
+		return func(string) (string, string, error) { return "", "", nil }, false, nil
+	}, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	cs := pvd.ContentStore()
+
+	manifestDesc, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	indexDesc, err := utils.WriteJSON(ctx, cs, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{*manifestDesc},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex}, "", nil)
+	require.NoError(t, err)
+	for _, desc := range []*ocispec.Descriptor{manifestDesc, indexDesc} {
+		data, err := content.ReadBlob(ctx, cs, *desc)
+		require.NoError(t, err)
+		manifests[desc.Digest.String()] = fakeManifest{mediaType: desc.MediaType, data: data}
+	}
+	manifests["latest"] = manifests[indexDesc.Digest.String()]
+
+	verifier := &pushVerifier{pvd: pvd}
+	require.NoError(t, verifier.afterPush(ctx, *indexDesc, ref))
+
+	// The manifest is rewritten by registry.
+	manifests[manifestDesc.Digest.String()] = fakeManifest{
+		mediaType: images.MediaTypeDockerSchema2Manifest,
+		data:      []byte(`{"schemaVersion":2}`),
+	}
+	err = verifier.afterPush(ctx, *indexDesc, ref)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "registry rewrote the pushed manifest")
+
+	// The tag points to another image.
+	manifests["latest"] = manifests[manifestDesc.Digest.String()]
+	err = verifier.afterPush(ctx, *indexDesc, ref)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "registry rewrote the pushed image")
+}
@@ -0,0 +1,258 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package copier
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/dustin/go-humanize"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// blobOpener opens the blob to upload to target backend, with its size.
+type blobOpener func(blobID string) (io.ReadCloser, int64, error)
+
+func isNydusBlob(desc ocispec.Descriptor) bool {
+	return desc.MediaType == converter.MediaTypeNydusBlob || desc.Annotations[converter.LayerAnnotationNydusBlob] == "true"
+}
+
+// uploadBlob copies the blob to target backend, the blob is staged in
+// stageDir as backend uploads blob from file, which must be kept until the
+// backend is finalized. The blob existing in target backend is skipped unless
+// force push, the uploaded size is returned, 0 if it's skipped.
+func uploadBlob(ctx context.Context, bkd backend.Backend, blobID string, open blobOpener, stageDir string, opt Opt) (int64, error) {
+	if !opt.TargetBackendForcePush {
+		exist, err := bkd.Check(blobID)
+		if err != nil {
+			return 0, errors.Wrapf(err, "check blob %s in target backend", blobID)
+		}
+		if exist {
+			logrus.WithField("blob", blobID).Infof("skip blob existed in target backend")
+			return 0, nil
+		}
+	}
+
+	rc, size, err := open(blobID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "open blob %s", blobID)
+	}
+	defer rc.Close()
+
+	file, err := os.Create(filepath.Join(stageDir, blobID))
+	if err != nil {
+		return 0, errors.Wrap(err, "create blob file")
+	}
+	defer file.Close()
+
+	logrus.WithField("blob", blobID).WithField("size", humanize.Bytes(uint64(size))).Infof("pushing blob to target backend")
+	digester := digest.SHA256.Digester()
+	if _, err := io.Copy(io.MultiWriter(file, digester.Hash()), rc); err != nil {
+		return 0, errors.Wrapf(err, "download blob %s", blobID)
+	}
+	if err := file.Close(); err != nil {
+		return 0, errors.Wrapf(err, "write blob %s", blobID)
+	}
+	// The blob meta artifact isn't named by its digest.
+	if !strings.HasSuffix(blobID, nydusifyUtils.BlobMetaSuffix) && digester.Digest().Encoded() != blobID {
+		return 0, fmt.Errorf("digest %s of blob mismatches blob id %s", digester.Digest(), blobID)
+	}
+	if _, err := backend.Upload(ctx, bkd, blobID, file.Name(), size, true); err != nil {
+		return 0, errors.Wrapf(err, "upload blob %s", blobID)
+	}
+	logrus.WithField("blob", blobID).WithField("size", humanize.Bytes(uint64(size))).Infof("pushed blob to target backend")
+
+	return size, nil
+}
+
+// pushBlobToBackend uploads the blobs referenced by the nydus manifest src
+// to target backend, from the source backend if any, otherwise from the blob
+// layers of the manifest. The target manifest only references the bootstrap
+// layer like the image converted with storage backend, it's nil if src isn't
+// a nydus manifest.
+func pushBlobToBackend(
+	ctx context.Context, pvd *provider.Provider, sourceBackend backend.Backend, src ocispec.Descriptor, opt Opt,
+) (*ocispec.Descriptor, error) {
+	if src.MediaType != ocispec.MediaTypeImageManifest && src.MediaType != images.MediaTypeDockerSchema2Manifest {
+		return nil, fmt.Errorf("unsupported media type %s", src.MediaType)
+	}
+	cs := pvd.ContentStore()
+	manifest := ocispec.Manifest{}
+	if _, err := utils.ReadJSON(ctx, cs, &manifest, src); err != nil {
+		return nil, errors.Wrap(err, "read manifest from store")
+	}
+	bootstrapDesc := parser.FindNydusBootstrapDesc(&manifest)
+	if bootstrapDesc == nil {
+		return nil, nil
+	}
+
+	// The backend is created per manifest, so that the uploads are
+	// completed by Finalize before the manifest referencing them is pushed.
+	targetBackend, err := backend.NewBackend(opt.TargetBackendType, []byte(opt.TargetBackendConfig), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "new target backend")
+	}
+	stageDir, err := os.MkdirTemp(opt.WorkDir, "blobs-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create blob directory")
+	}
+	defer os.RemoveAll(stageDir)
+	finalized := false
+	defer func() {
+		if !finalized {
+			if err := targetBackend.Finalize(true); err != nil {
+				logrus.WithError(err).Warn("cancel uploads to target backend")
+			}
+		}
+	}()
+
+	var blobIDs []string
+	var open blobOpener
+	if sourceBackend != nil {
+		if blobIDs, err = readBlobIDs(ctx, pvd, *bootstrapDesc, opt); err != nil {
+			return nil, err
+		}
+		open = func(blobID string) (io.ReadCloser, int64, error) {
+			size, err := sourceBackend.Size(blobID)
+			if err != nil {
+				return nil, 0, err
+			}
+			rc, err := sourceBackend.Reader(blobID)
+			return rc, size, err
+		}
+	} else {
+		layers := map[string]ocispec.Descriptor{}
+		for _, layer := range manifest.Layers {
+			if isNydusBlob(layer) && layers[layer.Digest.Encoded()].Digest == "" {
+				layers[layer.Digest.Encoded()] = layer
+				blobIDs = append(blobIDs, layer.Digest.Encoded())
+			}
+		}
+		open = func(blobID string) (io.ReadCloser, int64, error) {
+			ra, err := cs.ReaderAt(ctx, layers[blobID])
+			if err != nil {
+				return nil, 0, err
+			}
+			return struct {
+				io.Reader
+				io.Closer
+			}{io.NewSectionReader(ra, 0, ra.Size()), ra}, ra.Size(), nil
+		}
+	}
+
+	sem := semaphore.NewWeighted(int64(provider.LayerConcurrentLimit))
+	limiter := pvd.AdaptiveLimiter()
+	if limiter != nil {
+		sem = limiter.Semaphore()
+	}
+	eg, egCtx := errgroup.WithContext(ctx)
+	for _, blobID := range blobIDs {
+		blobID := blobID
+		eg.Go(func() error {
+			if err := sem.Acquire(egCtx, 1); err != nil {
+				return err
+			}
+			defer sem.Release(1)
+
+			size, err := uploadBlob(egCtx, targetBackend, blobID, open, stageDir, opt)
+			if err != nil {
+				return err
+			}
+			if size > 0 && limiter != nil {
+				limiter.Observe(size)
+			}
+			// Keep the blob meta artifact alongside the blob, it's only
+			// available in source backend.
+			if sourceBackend != nil {
+				blobMetaID := blobID + nydusifyUtils.BlobMetaSuffix
+				exist, err := sourceBackend.Check(blobMetaID)
+				if err != nil {
+					return errors.Wrapf(err, "check blob meta %s", blobMetaID)
+				}
+				if exist {
+					if _, err := uploadBlob(egCtx, targetBackend, blobMetaID, open, stageDir, opt); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, errors.Wrap(err, "push blobs to target backend")
+	}
+
+	if err := targetBackend.Finalize(false); err != nil {
+		return nil, errors.Wrap(err, "finalize target backend")
+	}
+	finalized = true
+
+	return dropBlobLayers(ctx, pvd, manifest, src, opt)
+}
+
+// dropBlobLayers removes the blob layers from manifest and their diff IDs
+// from image config, as the blobs are read from storage backend by nydusd.
+func dropBlobLayers(ctx context.Context, pvd *provider.Provider, manifest ocispec.Manifest, src ocispec.Descriptor, opt Opt) (*ocispec.Descriptor, error) {
+	cs := pvd.ContentStore()
+	blobDigests := map[digest.Digest]bool{}
+	layers := []ocispec.Descriptor{}
+	for _, layer := range manifest.Layers {
+		if isNydusBlob(layer) {
+			blobDigests[layer.Digest] = true
+			continue
+		}
+		if layer.Annotations != nil {
+			// The annotation key is deprecated, but it still exists in some
+			// old nydus images, let's clean it up.
+			delete(layer.Annotations, "containerd.io/snapshot/nydus-blob-ids")
+		}
+		layers = append(layers, layer)
+	}
+	if len(blobDigests) == 0 {
+		return &src, nil
+	}
+	manifest.Layers = layers
+
+	config := ocispec.Image{}
+	if _, err := utils.ReadJSON(ctx, cs, &config, manifest.Config); err != nil {
+		return nil, errors.Wrap(err, "read config json")
+	}
+	diffIDs := []digest.Digest{}
+	for _, diffID := range config.RootFS.DiffIDs {
+		// The diff ID of nydus blob is the blob digest.
+		if !blobDigests[diffID] {
+			diffIDs = append(diffIDs, diffID)
+		}
+	}
+	config.RootFS.DiffIDs = diffIDs
+	configDesc, err := pvd.WriteJSON(ctx, config, manifest.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "write config json")
+	}
+	manifest.Config = *configDesc
+
+	target, err := pvd.WriteJSON(ctx, &manifest, src)
+	if err != nil {
+		return nil, errors.Wrap(err, "write manifest json")
+	}
+	return target, nil
+}
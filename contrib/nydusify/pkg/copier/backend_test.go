@@ -0,0 +1,113 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package copier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestPushBlobToBackend(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), nil, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	cs := pvd.ContentStore()
+
+	writeBlob := func(data []byte, desc ocispec.Descriptor) ocispec.Descriptor {
+		desc.Digest = digest.FromBytes(data)
+		desc.Size = int64(len(data))
+		require.NoError(t, content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(data), desc))
+		return desc
+	}
+	blobs := []ocispec.Descriptor{}
+	for _, data := range []string{"blob-1", "blob-2"} {
+		blobs = append(blobs, writeBlob([]byte(data), ocispec.Descriptor{
+			MediaType:   nydusifyUtils.MediaTypeNydusBlob,
+			Annotations: map[string]string{nydusifyUtils.LayerAnnotationNydusBlob: "true"},
+		}))
+	}
+	bootstrap := writeBlob([]byte("bootstrap"), ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageLayerGzip,
+		Annotations: map[string]string{nydusifyUtils.LayerAnnotationNydusBootstrap: "true"},
+	})
+	bootstrapDiffID := digest.FromString("bootstrap-diff")
+	config, err := utils.WriteJSON(ctx, cs, ocispec.Image{
+		RootFS: ocispec.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{blobs[0].Digest, blobs[1].Digest, bootstrapDiffID},
+		},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig}, "", nil)
+	require.NoError(t, err)
+	manifest, err := utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    *config,
+		Layers:    []ocispec.Descriptor{blobs[0], blobs[1], bootstrap},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+
+	backendDir := t.TempDir()
+	// The existing blob is skipped.
+	require.NoError(t, os.WriteFile(filepath.Join(backendDir, blobs[1].Digest.Encoded()), []byte("existed"), 0644))
+	opt := Opt{
+		WorkDir:             t.TempDir(),
+		TargetBackendType:   "localfs",
+		TargetBackendConfig: fmt.Sprintf(`{"dir": %q}`, backendDir),
+	}
+
+	target, err := pushBlobToBackend(ctx, pvd, nil, *manifest, opt)
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(backendDir, blobs[0].Digest.Encoded()))
+	require.NoError(t, err)
+	require.Equal(t, "blob-1", string(data))
+	data, err = os.ReadFile(filepath.Join(backendDir, blobs[1].Digest.Encoded()))
+	require.NoError(t, err)
+	require.Equal(t, "existed", string(data))
+
+	// Only the bootstrap layer is referenced by target manifest.
+	var targetManifest ocispec.Manifest
+	_, err = utils.ReadJSON(ctx, cs, &targetManifest, *target)
+	require.NoError(t, err)
+	require.Equal(t, []ocispec.Descriptor{bootstrap}, targetManifest.Layers)
+	var targetConfig ocispec.Image
+	_, err = utils.ReadJSON(ctx, cs, &targetConfig, targetManifest.Config)
+	require.NoError(t, err)
+	require.Equal(t, []digest.Digest{bootstrapDiffID}, targetConfig.RootFS.DiffIDs)
+
+	// The existing blob is overwritten by force push.
+	opt.TargetBackendForcePush = true
+	_, err = pushBlobToBackend(ctx, pvd, nil, *manifest, opt)
+	require.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(backendDir, blobs[1].Digest.Encoded()))
+	require.NoError(t, err)
+	require.Equal(t, "blob-2", string(data))
+
+	// The OCI manifest is skipped.
+	manifest, err = utils.WriteJSON(ctx, cs, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    *config,
+		Layers:    []ocispec.Descriptor{blobs[0]},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest}, "", nil)
+	require.NoError(t, err)
+	target, err = pushBlobToBackend(ctx, pvd, nil, *manifest, opt)
+	require.NoError(t, err)
+	require.Nil(t, target)
+}
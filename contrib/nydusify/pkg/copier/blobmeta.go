@@ -0,0 +1,93 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package copier
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// writeBlobMeta writes the blob meta object stored alongside the blob in
+// backend into content store, to be pushed in the blob meta artifact.
+func writeBlobMeta(ctx context.Context, pvd *provider.Provider, bkd backend.Backend, blobMetaID string, blobDigest digest.Digest) (*ocispec.Descriptor, error) {
+	rc, err := bkd.Reader(blobMetaID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get blob meta reader %s", blobMetaID)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read blob meta %s", blobMetaID)
+	}
+	desc, err := pvd.WriteBlob(ctx, data, ocispec.Descriptor{
+		MediaType: nydusifyUtils.MediaTypeNydusBlobMeta,
+		Annotations: map[string]string{
+			ocispec.AnnotationTitle:                      blobMetaID,
+			nydusifyUtils.LayerAnnotationNydusBlobDigest: blobDigest.String(),
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "write blob meta %s", blobMetaID)
+	}
+	return desc, nil
+}
+
+// pushBlobMetaArtifact pushes the blob metas copied from backend in the
+// artifact referring to the pushed image manifest by `subject` field, so
+// that they're kept by the garbage collection of registry along with the
+// image, the artifact pushed is returned.
+func pushBlobMetaArtifact(ctx context.Context, pvd *provider.Provider, blobMetas []ocispec.Descriptor, subject ocispec.Descriptor, target string) (*ocispec.Descriptor, error) {
+	emptyConfig := ocispec.DescriptorEmptyJSON
+	emptyConfig.Data = nil
+	configDesc, err := pvd.WriteBlob(ctx, ocispec.DescriptorEmptyJSON.Data, emptyConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "write empty config")
+	}
+	artifact := ocispec.Manifest{
+		Versioned: specs.Versioned{
+			SchemaVersion: 2,
+		},
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: nydusifyUtils.ArtifactTypeNydusBlobMeta,
+		Config:       *configDesc,
+		Layers:       blobMetas,
+		Subject: &ocispec.Descriptor{
+			MediaType: subject.MediaType,
+			Digest:    subject.Digest,
+			Size:      subject.Size,
+		},
+	}
+	artifactDesc, err := pvd.WriteJSON(ctx, artifact, ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: nydusifyUtils.ArtifactTypeNydusBlobMeta,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "write blob meta artifact")
+	}
+
+	named, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	// Push artifact by digest, avoid overwriting the target tag.
+	artifactRef := fmt.Sprintf("%s@%s", docker.TrimNamed(named).String(), artifactDesc.Digest)
+	logrus.Infof("pushing blob meta artifact %s", artifactRef)
+	if err := pvd.Push(ctx, *artifactDesc, artifactRef); err != nil {
+		return nil, errors.Wrapf(err, "push blob meta artifact %s", artifactRef)
+	}
+	return artifactDesc, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package copier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	accelRemote "github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestPushBlobMetaArtifact(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	registry.SetDigestAlgorithm(digest.SHA512)
+
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	pvd, err := provider.New(t.TempDir(), func(string) (accelRemote.CredentialFunc, bool, error) {
+		return func(string) (string, string, error) { return "", "", nil }, false, nil
+	}, 200, "v1", nil, 0)
+	require.NoError(t, err)
+	pvd.UsePlainHTTP()
+	pvd.SetDigestAlgorithm(digest.SHA512)
+
+	backendDir := t.TempDir()
+	bkd, err := backend.NewBackend("localfs", []byte(fmt.Sprintf(`{"dir": %q}`, backendDir)), nil)
+	require.NoError(t, err)
+	blobDigest := digest.FromString("blob")
+	blobMetaID := blobDigest.Encoded() + nydusifyUtils.BlobMetaSuffix
+	require.NoError(t, os.WriteFile(filepath.Join(backendDir, blobMetaID), []byte("blob meta"), 0644))
+	blobMeta, err := writeBlobMeta(ctx, pvd, bkd, blobMetaID, blobDigest)
+	require.NoError(t, err)
+	require.Equal(t, blobMetaID, blobMeta.Annotations[ocispec.AnnotationTitle])
+	_, err = writeBlobMeta(ctx, pvd, bkd, "missing"+nydusifyUtils.BlobMetaSuffix, blobDigest)
+	require.Error(t, err)
+
+	config, err := pvd.WriteJSON(ctx, ocispec.Image{}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig})
+	require.NoError(t, err)
+	manifest, err := pvd.WriteJSON(ctx, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    *config,
+		Layers:    []ocispec.Descriptor{},
+	}, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest})
+	require.NoError(t, err)
+	target := registry.Host() + "/app:v1"
+	subject, err := pvd.Redigest(ctx, *manifest)
+	require.NoError(t, err)
+	require.NoError(t, pvd.Push(ctx, *subject, target))
+
+	// The artifact refers to the manifest digested as pushed.
+	artifact, err := pushBlobMetaArtifact(ctx, pvd, []ocispec.Descriptor{*blobMeta}, *subject, target)
+	require.NoError(t, err)
+	_, _, ok := registry.Manifest("app", subject.Digest.String())
+	require.True(t, ok)
+	data, _, ok := registry.Manifest("app", artifact.Digest.String())
+	require.True(t, ok)
+	var artifactManifest ocispec.Manifest
+	require.NoError(t, json.Unmarshal(data, &artifactManifest))
+	require.Equal(t, nydusifyUtils.ArtifactTypeNydusBlobMeta, artifactManifest.ArtifactType)
+	require.Equal(t, subject.Digest, artifactManifest.Subject.Digest)
+	require.Len(t, artifactManifest.Layers, 1)
+	data, ok = registry.Blob("app", artifactManifest.Layers[0].Digest)
+	require.True(t, ok)
+	require.Equal(t, "blob meta", string(data))
+}
@@ -6,12 +6,12 @@ package copier
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/containerd/containerd/content"
 	containerdErrdefs "github.com/containerd/containerd/errdefs"
@@ -21,13 +21,14 @@ import (
 	"github.com/containerd/containerd/reference/docker"
 	"github.com/containerd/nydus-snapshotter/pkg/converter"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/build"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter/provider"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/transport"
 	nydusifyUtils "github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
 	"github.com/dustin/go-humanize"
 	"github.com/goharbor/acceleration-service/pkg/errdefs"
-	"github.com/goharbor/acceleration-service/pkg/platformutil"
 	"github.com/goharbor/acceleration-service/pkg/remote"
 	"github.com/goharbor/acceleration-service/pkg/utils"
 	"github.com/pkg/errors"
@@ -49,26 +50,67 @@ type Opt struct {
 	SourceInsecure bool
 	TargetInsecure bool
 
+	// The plain HTTP options make the registries accessed by plain HTTP,
+	// while the insecure options skip verifying certs for HTTPS.
+	SourcePlainHTTP bool
+	TargetPlainHTTP bool
+
 	SourceBackendType   string
 	SourceBackendConfig string
 
-	TargetBackendType   string
-	TargetBackendConfig string
+	// The blobs are uploaded to target backend instead of target registry
+	// if TargetBackendType is specified, the target manifest only references
+	// the bootstrap layer. The blobs existing in target backend are skipped
+	// unless TargetBackendForcePush.
+	TargetBackendType      string
+	TargetBackendConfig    string
+	TargetBackendForcePush bool
 
 	AllPlatforms bool
 	Platforms    string
 
 	PushChunkSize int64
+	// DigestAlgorithm digests the pushed index, manifests, configs and
+	// bootstrap layers, default to sha256.
+	DigestAlgorithm digest.Algorithm
+
+	// WorkDirGCAge removes the temp directories left by crashed runs in
+	// work directory, which are older than the age, 0 disables it.
+	WorkDirGCAge time.Duration
+
+	// AdaptiveConcurrency adjusts the concurrency of pulling and pushing
+	// layers at runtime in range [1, MaxConcurrency].
+	AdaptiveConcurrency bool
+	MaxConcurrency      int
+
+	// KeepGoing continues with the rest platforms after a platform fails,
+	// the target index only references the succeeded platforms.
+	KeepGoing bool
+
+	// Images are copied concurrently instead of Source and Target if set,
+	// at most ImageConcurrency images at a time, 0 means unlimited.
+	Images           []Image
+	ImageConcurrency int
 }
 
-type output struct {
-	Blobs []string
+// Image is a pair of source and target image references to copy.
+type Image struct {
+	Source string
+	Target string
 }
 
-func hosts(opt Opt) remote.HostFunc {
-	maps := map[string]bool{
-		opt.Source: opt.SourceInsecure,
-		opt.Target: opt.TargetInsecure,
+func hosts(jobs []*copyJob, opt Opt) remote.HostFunc {
+	maps := map[string]bool{}
+	for _, job := range jobs {
+		maps[job.source] = opt.SourceInsecure
+		maps[job.target] = opt.TargetInsecure
+		// The provider accesses the registries by normalized references.
+		if named, err := docker.ParseDockerRef(job.source); err == nil {
+			maps[named.String()] = opt.SourceInsecure
+		}
+		if named, err := docker.ParseDockerRef(job.target); err == nil {
+			maps[named.String()] = opt.TargetInsecure
+		}
 	}
 	return func(ref string) (remote.CredentialFunc, bool, error) {
 		return remote.NewDockerConfigCredFunc(), maps[ref], nil
@@ -98,59 +140,36 @@ func getPushWriter(ctx context.Context, pvd *provider.Provider, desc ocispec.Des
 	return writer, nil
 }
 
+// pushBlobFromBackend pushes the blobs in backend to registry, and returns
+// them with the blob metas found alongside them in backend, which are
+// written into content store to be pushed in blob meta artifact.
 func pushBlobFromBackend(
 	ctx context.Context, pvd *provider.Provider, backend backend.Backend, src ocispec.Descriptor, opt Opt,
-) ([]ocispec.Descriptor, *ocispec.Descriptor, error) {
+) ([]ocispec.Descriptor, []ocispec.Descriptor, *ocispec.Descriptor, error) {
 	if src.MediaType != ocispec.MediaTypeImageManifest && src.MediaType != images.MediaTypeDockerSchema2Manifest {
-		return nil, nil, fmt.Errorf("unsupported media type %s", src.MediaType)
+		return nil, nil, nil, fmt.Errorf("unsupported media type %s", src.MediaType)
 	}
 	manifest := ocispec.Manifest{}
 	if _, err := utils.ReadJSON(ctx, pvd.ContentStore(), &manifest, src); err != nil {
-		return nil, nil, errors.Wrap(err, "read manifest from store")
+		return nil, nil, nil, errors.Wrap(err, "read manifest from store")
 	}
 	bootstrapDesc := parser.FindNydusBootstrapDesc(&manifest)
 	if bootstrapDesc == nil {
-		return nil, nil, nil
-	}
-	ra, err := pvd.ContentStore().ReaderAt(ctx, *bootstrapDesc)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "prepare reading bootstrap")
-	}
-	bootstrapPath := filepath.Join(opt.WorkDir, "bootstrap.tgz")
-	if err := nydusifyUtils.UnpackFile(io.NewSectionReader(ra, 0, ra.Size()), nydusifyUtils.BootstrapFileNameInLayer, bootstrapPath); err != nil {
-		return nil, nil, errors.Wrap(err, "unpack bootstrap layer")
+		return nil, nil, nil, nil
 	}
-	outputPath := filepath.Join(opt.WorkDir, "output.json")
-	builder := tool.NewBuilder(opt.NydusImagePath)
-	if err := builder.Check(tool.BuilderOption{
-		BootstrapPath:   bootstrapPath,
-		DebugOutputPath: outputPath,
-	}); err != nil {
-		return nil, nil, errors.Wrap(err, "check bootstrap")
-	}
-	var out output
-	bytes, err := os.ReadFile(outputPath)
+	blobIDs, err := readBlobIDs(ctx, pvd, *bootstrapDesc, opt)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "read output file")
-	}
-	if err := json.Unmarshal(bytes, &out); err != nil {
-		return nil, nil, errors.Wrap(err, "unmarshal output json")
-	}
-
-	// Deduplicate the blobs for avoiding uploading repeatedly.
-	blobIDs := []string{}
-	blobIDMap := map[string]bool{}
-	for _, blobID := range out.Blobs {
-		if blobIDMap[blobID] {
-			continue
-		}
-		blobIDs = append(blobIDs, blobID)
-		blobIDMap[blobID] = true
+		return nil, nil, nil, err
 	}
 
 	sem := semaphore.NewWeighted(int64(provider.LayerConcurrentLimit))
+	limiter := pvd.AdaptiveLimiter()
+	if limiter != nil {
+		sem = limiter.Semaphore()
+	}
 	eg, ctx := errgroup.WithContext(ctx)
 	blobDescs := make([]ocispec.Descriptor, len(blobIDs))
+	blobMetaDescs := make([]*ocispec.Descriptor, len(blobIDs))
 	for idx := range blobIDs {
 		func(idx int) {
 			eg.Go(func() error {
@@ -179,6 +198,19 @@ func pushBlobFromBackend(
 						converter.LayerAnnotationNydusBlob: "true",
 					},
 				}
+				// Copy the blob meta if it's stored alongside the blob in
+				// backend, and keep it referenced.
+				blobMetaID := blobID + nydusifyUtils.BlobMetaSuffix
+				exist, err := backend.Check(blobMetaID)
+				if err != nil {
+					return errors.Wrapf(err, "check blob meta %s", blobMetaID)
+				}
+				if exist {
+					if blobMetaDescs[idx], err = writeBlobMeta(ctx, pvd, backend, blobMetaID, blobDigest); err != nil {
+						return err
+					}
+					blobDescs[idx].Annotations[nydusifyUtils.LayerAnnotationNydusBlobMeta] = blobMetaID
+				}
 				writer, err := getPushWriter(ctx, pvd, blobDescs[idx], opt)
 				if err != nil {
 					if errdefs.NeedsRetryWithHTTP(err) {
@@ -191,7 +223,13 @@ func pushBlobFromBackend(
 				}
 				if writer != nil {
 					defer writer.Close()
-					return content.Copy(ctx, writer, rc, blobSize, blobDigest)
+					if err := content.Copy(ctx, writer, rc, blobSize, blobDigest); err != nil {
+						return err
+					}
+					if limiter != nil {
+						limiter.Observe(blobSize)
+					}
+					return nil
 				}
 
 				logrus.WithField("digest", blobDigest).WithField("size", blobSizeStr).Infof("pushed blob from backend")
@@ -202,7 +240,13 @@ func pushBlobFromBackend(
 	}
 
 	if err := eg.Wait(); err != nil {
-		return nil, nil, errors.Wrap(err, "push blobs")
+		return nil, nil, nil, errors.Wrap(err, "push blobs")
+	}
+	blobMetas := []ocispec.Descriptor{}
+	for _, desc := range blobMetaDescs {
+		if desc != nil {
+			blobMetas = append(blobMetas, *desc)
+		}
 	}
 
 	// Update manifest layers
@@ -222,21 +266,67 @@ func pushBlobFromBackend(
 	}
 	config := ocispec.Image{}
 	if _, err := utils.ReadJSON(ctx, pvd.ContentStore(), &config, manifest.Config); err != nil {
-		return nil, nil, errors.Wrap(err, "read config json")
+		return nil, nil, nil, errors.Wrap(err, "read config json")
 	}
 	config.RootFS.DiffIDs = append(blobDigests, config.RootFS.DiffIDs...)
-	configDesc, err := utils.WriteJSON(ctx, pvd.ContentStore(), config, manifest.Config, opt.Target, nil)
+	configDesc, err := pvd.WriteJSON(ctx, config, manifest.Config)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "write config json")
+		return nil, nil, nil, errors.Wrap(err, "write config json")
 	}
 	manifest.Config = *configDesc
 
-	target, err := utils.WriteJSON(ctx, pvd.ContentStore(), &manifest, src, opt.Target, nil)
+	target, err := pvd.WriteJSON(ctx, &manifest, src)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "write manifest json")
+		return nil, nil, nil, errors.Wrap(err, "write manifest json")
+	}
+
+	return blobDescs, blobMetas, target, nil
+}
+
+// readBlobIDs returns the deduplicated blob IDs in the blob table of
+// bootstrap layer.
+func readBlobIDs(ctx context.Context, pvd *provider.Provider, bootstrapDesc ocispec.Descriptor, opt Opt) ([]string, error) {
+	ra, err := pvd.ContentStore().ReaderAt(ctx, bootstrapDesc)
+	if err != nil {
+		return nil, errors.Wrap(err, "prepare reading bootstrap")
+	}
+	defer ra.Close()
+	bootstrapPath := filepath.Join(opt.WorkDir, "bootstrap.tgz")
+	if err := nydusifyUtils.UnpackFile(io.NewSectionReader(ra, 0, ra.Size()), nydusifyUtils.BootstrapFileNameInLayer, bootstrapPath); err != nil {
+		return nil, errors.Wrap(err, "unpack bootstrap layer")
+	}
+	outputPath := filepath.Join(opt.WorkDir, "output.json")
+	builder := tool.NewBuilder(opt.NydusImagePath)
+	if err := builder.Check(tool.BuilderOption{
+		BootstrapPath:   bootstrapPath,
+		DebugOutputPath: outputPath,
+	}); err != nil {
+		return nil, errors.Wrap(err, "check bootstrap")
+	}
+	// Deduplicate the blobs for avoiding uploading repeatedly.
+	blobIDs := []string{}
+	blobIDMap := map[string]bool{}
+	if _, err := build.ParseOutputBlobs(outputPath, func(blobID string) error {
+		if !blobIDMap[blobID] {
+			blobIDs = append(blobIDs, blobID)
+			blobIDMap[blobID] = true
+		}
+		return nil
+	}); err != nil {
+		return nil, err
 	}
+	return blobIDs, nil
+}
 
-	return blobDescs, target, nil
+// localReference returns the registry style reference of the image in
+// transport, the image in containers storage keeps its name.
+func localReference(ref *transport.Reference, fallback string) string {
+	switch ref.Transport {
+	case transport.Docker, transport.ContainersStorage:
+		return ref.Name
+	default:
+		return fallback
+	}
 }
 
 func getPlatform(platform *ocispec.Platform) string {
@@ -246,15 +336,130 @@ func getPlatform(platform *ocispec.Platform) string {
 	return platforms.Format(*platform)
 }
 
+// copyManifest copies the image manifest of a platform to target, the
+// copied manifest is set to result.
+func copyManifest(
+	ctx context.Context, pvd *provider.Provider, bkd backend.Backend, remotes *store, sourceDesc ocispec.Descriptor,
+	result *ocispec.Descriptor, source, target string, opt Opt,
+) error {
+	targetDesc := &sourceDesc
+	var blobMetas []ocispec.Descriptor
+	if opt.TargetBackendType != "" {
+		_targetDesc, err := pushBlobToBackend(ctx, pvd, bkd, sourceDesc, opt)
+		if err != nil {
+			return err
+		}
+		if _targetDesc == nil {
+			logrus.WithField("platform", getPlatform(sourceDesc.Platform)).Warnf("%s is not a nydus image", source)
+		} else {
+			targetDesc = _targetDesc
+		}
+	} else if bkd != nil {
+		descs, _blobMetas, _targetDesc, err := pushBlobFromBackend(ctx, pvd, bkd, sourceDesc, opt)
+		if err != nil {
+			return errors.Wrap(err, "get resolver")
+		}
+		if _targetDesc == nil {
+			logrus.WithField("platform", getPlatform(sourceDesc.Platform)).Warnf("%s is not a nydus image", source)
+		} else {
+			targetDesc = _targetDesc
+			blobMetas = _blobMetas
+			remotes.add(descs)
+		}
+	}
+	if len(blobMetas) > 0 {
+		// The blob meta artifact refers to the manifest digested as pushed.
+		_targetDesc, err := pvd.Redigest(ctx, *targetDesc)
+		if err != nil {
+			return err
+		}
+		targetDesc = _targetDesc
+	}
+	*result = *targetDesc
+
+	logrus.WithField("platform", getPlatform(sourceDesc.Platform)).Infof("pushing target manifest %s", targetDesc.Digest)
+	if err := pvd.Push(ctx, *targetDesc, target); err != nil {
+		if errdefs.NeedsRetryWithHTTP(err) {
+			pvd.UsePlainHTTP()
+			if err := pvd.Push(ctx, *targetDesc, target); err != nil {
+				return errors.Wrap(err, "try to push image manifest")
+			}
+		} else {
+			return errors.Wrap(err, "push target image manifest")
+		}
+	}
+	logrus.WithField("platform", getPlatform(sourceDesc.Platform)).Infof("pushed target manifest %s", targetDesc.Digest)
+
+	if len(blobMetas) > 0 {
+		if _, err := pushBlobMetaArtifact(ctx, pvd, blobMetas, *targetDesc, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyJob is an image to copy, the references of local transports are
+// replaced by registry style references.
+type copyJob struct {
+	sourceRef *transport.Reference
+	targetRef *transport.Reference
+	source    string
+	target    string
+}
+
+func newCopyJob(image Image, idx, total int, opt Opt) (*copyJob, error) {
+	sourceRef, err := transport.Parse(image.Source)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse source reference")
+	}
+	targetRef, err := transport.Parse(image.Target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	if !targetRef.IsRegistry() && opt.SourceBackendType != "" && opt.TargetBackendType == "" {
+		return nil, fmt.Errorf("blobs in source backend can't be copied to %s transport", targetRef.Transport)
+	}
+	// The image in local transport is stored in provider with a registry
+	// style reference, as the provider requires, which is unique per image
+	// as the images share the provider.
+	localName := func(kind string) string {
+		if total == 1 {
+			return "localhost/nydusify/" + kind + ":latest"
+		}
+		return fmt.Sprintf("localhost/nydusify/%s-%d:latest", kind, idx)
+	}
+	return &copyJob{
+		sourceRef: sourceRef,
+		targetRef: targetRef,
+		source:    localReference(sourceRef, localName("source")),
+		target:    localReference(targetRef, localName("target")),
+	}, nil
+}
+
+// Copy copies the image from Source to Target, or the Images concurrently,
+// the images share the provider, so that the blobs shared by images are
+// pulled once and uploaded once per target repository.
 func Copy(ctx context.Context, opt Opt) error {
 	// Containerd image fetch requires a namespace context.
 	ctx = namespaces.WithNamespace(ctx, "nydusify")
 
-	platformMC, err := platformutil.ParsePlatforms(opt.AllPlatforms, opt.Platforms)
+	platformMC, err := nydusifyUtils.ParsePlatforms(opt.AllPlatforms, opt.Platforms)
 	if err != nil {
 		return err
 	}
 
+	toCopy := opt.Images
+	if len(toCopy) == 0 {
+		toCopy = []Image{{Source: opt.Source, Target: opt.Target}}
+	}
+	jobs := make([]*copyJob, len(toCopy))
+	for idx, image := range toCopy {
+		if jobs[idx], err = newCopyJob(image, idx, len(toCopy), opt); err != nil {
+			return errors.Wrapf(err, "copy image %s", image.Source)
+		}
+	}
+
 	var bkd backend.Backend
 	if opt.SourceBackendType != "" {
 		bkd, err = backend.NewBackend(opt.SourceBackendType, []byte(opt.SourceBackendConfig), nil)
@@ -262,6 +467,9 @@ func Copy(ctx context.Context, opt Opt) error {
 			return errors.Wrapf(err, "new backend")
 		}
 	}
+	if opt.TargetBackendType == "registry" {
+		return fmt.Errorf("registry can't be the target backend, blobs are pushed to target registry by default")
+	}
 
 	if _, err := os.Stat(opt.WorkDir); err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -275,16 +483,104 @@ func Copy(ctx context.Context, opt Opt) error {
 			return errors.Wrap(err, "stat work directory")
 		}
 	}
-	tmpDir, err := os.MkdirTemp(opt.WorkDir, "nydusify-")
+	if opt.WorkDirGCAge > 0 {
+		if err := nydusifyUtils.CleanStaleDirs(opt.WorkDir, opt.WorkDirGCAge); err != nil {
+			logrus.WithError(err).Warnf("failed to clean stale work directories in %s", opt.WorkDir)
+		}
+	}
+	tmpDir, err := nydusifyUtils.MkdirTemp(opt.WorkDir)
 	if err != nil {
 		return errors.Wrap(err, "create temp directory")
 	}
-	pvd, err := provider.New(tmpDir, hosts(opt), 200, "v1", platformMC, opt.PushChunkSize)
+	pvd, err := provider.New(tmpDir, hosts(jobs, opt), 200, "v1", platformMC, opt.PushChunkSize)
 	if err != nil {
 		return err
 	}
+	pvd.SetDigestAlgorithm(opt.DigestAlgorithm)
+	for _, job := range jobs {
+		if opt.SourcePlainHTTP {
+			if err := pvd.UsePlainHTTPFor(job.source); err != nil {
+				return err
+			}
+		}
+		if opt.TargetPlainHTTP {
+			if err := pvd.UsePlainHTTPFor(job.target); err != nil {
+				return err
+			}
+		}
+	}
 	defer os.RemoveAll(tmpDir)
 
+	if opt.AdaptiveConcurrency {
+		limiter := nydusifyUtils.NewAdaptiveLimiter(1, opt.MaxConcurrency)
+		pvd.SetAdaptiveLimiter(limiter)
+		limiterCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go limiter.Run(limiterCtx, nydusifyUtils.AdaptiveInterval)
+	}
+
+	// The blobs pushed from backend are referenced by the target manifests
+	// but not stored in the content store.
+	var remotes *store
+	if bkd != nil && opt.TargetBackendType == "" {
+		remotes = newStore(pvd.ContentStore(), nil)
+		pvd.SetContentStore(remotes)
+	}
+
+	if len(jobs) == 1 {
+		return copyImage(ctx, pvd, bkd, remotes, jobs[0], tmpDir, platformMC, opt)
+	}
+
+	var batchErr *nydusifyUtils.BatchError
+	if opt.KeepGoing {
+		batchErr = nydusifyUtils.NewBatchError(len(jobs))
+	}
+	eg, egCtx := errgroup.WithContext(ctx)
+	if opt.ImageConcurrency > 0 {
+		eg.SetLimit(opt.ImageConcurrency)
+	}
+	for _, job := range jobs {
+		job := job
+		eg.Go(func() error {
+			jobDir, err := os.MkdirTemp(tmpDir, "image-")
+			if err != nil {
+				return errors.Wrap(err, "create image directory")
+			}
+			defer os.RemoveAll(jobDir)
+
+			logrus.Infof("copying image %s to %s", job.sourceRef, job.targetRef)
+			if err := copyImage(egCtx, pvd, bkd, remotes, job, jobDir, platformMC, opt); err != nil {
+				if batchErr != nil {
+					batchErr.Add(job.sourceRef.String(), err)
+					return nil
+				}
+				return errors.Wrapf(err, "copy image %s", job.sourceRef)
+			}
+			logrus.Infof("copied image %s to %s", job.sourceRef, job.targetRef)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	if batchErr != nil {
+		return batchErr.ErrorOrNil()
+	}
+	return nil
+}
+
+// copyImage copies the image of job, the work directory is dedicated to
+// the image.
+func copyImage(
+	ctx context.Context, pvd *provider.Provider, bkd backend.Backend, remotes *store,
+	job *copyJob, workDir string, platformMC platforms.MatchComparer, opt Opt,
+) error {
+	opt.Source = job.source
+	opt.Target = job.target
+	opt.WorkDir = workDir
+	sourceRef, targetRef := job.sourceRef, job.targetRef
+
 	sourceNamed, err := docker.ParseDockerRef(opt.Source)
 	if err != nil {
 		return errors.Wrap(err, "parse source reference")
@@ -296,18 +592,32 @@ func Copy(ctx context.Context, opt Opt) error {
 	source := sourceNamed.String()
 	target := targetNamed.String()
 
-	logrus.Infof("pulling source image %s", source)
-	if err := pvd.Pull(ctx, source); err != nil {
-		if errdefs.NeedsRetryWithHTTP(err) {
-			pvd.UsePlainHTTP()
-			if err := pvd.Pull(ctx, source); err != nil {
-				return errors.Wrap(err, "try to pull image")
+	if !sourceRef.IsRegistry() {
+		logrus.Infof("loading source image %s", sourceRef)
+		desc, err := transport.Load(ctx, pvd.ContentStore(), sourceRef, workDir)
+		if err != nil {
+			return errors.Wrapf(err, "load source image %s", sourceRef)
+		}
+		pvd.AddImage(source, *desc)
+	} else {
+		logrus.Infof("pulling source image %s", source)
+		if err := pvd.Pull(ctx, source); err != nil {
+			if errdefs.NeedsRetryWithHTTP(err) {
+				pvd.UsePlainHTTP()
+				if err := pvd.Pull(ctx, source); err != nil {
+					return errors.Wrap(err, "try to pull image")
+				}
+			} else {
+				return errors.Wrap(err, "pull source image")
 			}
-		} else {
-			return errors.Wrap(err, "pull source image")
 		}
+		logrus.Infof("pulled source image %s", source)
+	}
+	if !targetRef.IsRegistry() {
+		pvd.SetExporter(target, func(ctx context.Context, desc ocispec.Descriptor) error {
+			return transport.Save(ctx, pvd.ContentStore(), targetRef, desc, workDir)
+		})
 	}
-	logrus.Infof("pulled source image %s", source)
 
 	sourceImage, err := pvd.Image(ctx, source)
 	if err != nil {
@@ -320,6 +630,11 @@ func Copy(ctx context.Context, opt Opt) error {
 	}
 	targetDescs := make([]ocispec.Descriptor, len(sourceDescs))
 
+	var batchErr *nydusifyUtils.BatchError
+	if opt.KeepGoing {
+		batchErr = nydusifyUtils.NewBatchError(len(sourceDescs))
+	}
+
 	sem := semaphore.NewWeighted(1)
 	eg := errgroup.Group{}
 	for idx := range sourceDescs {
@@ -328,37 +643,12 @@ func Copy(ctx context.Context, opt Opt) error {
 				sem.Acquire(context.Background(), 1)
 				defer sem.Release(1)
 
-				sourceDesc := sourceDescs[idx]
-				targetDesc := &sourceDesc
-				if bkd != nil {
-					descs, _targetDesc, err := pushBlobFromBackend(ctx, pvd, bkd, sourceDesc, opt)
-					if err != nil {
-						return errors.Wrap(err, "get resolver")
-					}
-					if _targetDesc == nil {
-						logrus.WithField("platform", getPlatform(sourceDesc.Platform)).Warnf("%s is not a nydus image", source)
-					} else {
-						targetDesc = _targetDesc
-						store := newStore(pvd.ContentStore(), descs)
-						pvd.SetContentStore(store)
-					}
+				err := copyManifest(ctx, pvd, bkd, remotes, sourceDescs[idx], &targetDescs[idx], source, target, opt)
+				if err != nil && batchErr != nil {
+					batchErr.Add(getPlatform(sourceDescs[idx].Platform), err)
+					return nil
 				}
-				targetDescs[idx] = *targetDesc
-
-				logrus.WithField("platform", getPlatform(sourceDesc.Platform)).Infof("pushing target manifest %s", targetDesc.Digest)
-				if err := pvd.Push(ctx, *targetDesc, target); err != nil {
-					if errdefs.NeedsRetryWithHTTP(err) {
-						pvd.UsePlainHTTP()
-						if err := pvd.Push(ctx, *targetDesc, target); err != nil {
-							return errors.Wrap(err, "try to push image manifest")
-						}
-					} else {
-						return errors.Wrap(err, "push target image manifest")
-					}
-				}
-				logrus.WithField("platform", getPlatform(sourceDesc.Platform)).Infof("pushed target manifest %s", targetDesc.Digest)
-
-				return nil
+				return err
 			})
 		}(idx)
 	}
@@ -366,7 +656,21 @@ func Copy(ctx context.Context, opt Opt) error {
 		return errors.Wrap(err, "push image manifests")
 	}
 
-	if len(targetDescs) > 1 && (sourceImage.MediaType == ocispec.MediaTypeImageIndex ||
+	if batchErr != nil {
+		if batchErr.AllFailed() {
+			return batchErr
+		}
+		// Only the succeeded platforms are referenced by the target index.
+		succeeded := []ocispec.Descriptor{}
+		for idx, desc := range sourceDescs {
+			if !batchErr.Failed(getPlatform(desc.Platform)) {
+				succeeded = append(succeeded, targetDescs[idx])
+			}
+		}
+		targetDescs = succeeded
+	}
+
+	if len(sourceDescs) > 1 && (sourceImage.MediaType == ocispec.MediaTypeImageIndex ||
 		sourceImage.MediaType == images.MediaTypeDockerSchema2ManifestList) {
 		targetIndex := ocispec.Index{}
 		if _, err := utils.ReadJSON(ctx, pvd.ContentStore(), &targetIndex, *sourceImage); err != nil {
@@ -374,7 +678,7 @@ func Copy(ctx context.Context, opt Opt) error {
 		}
 		targetIndex.Manifests = targetDescs
 
-		targetImage, err := utils.WriteJSON(ctx, pvd.ContentStore(), targetIndex, *sourceImage, target, nil)
+		targetImage, err := pvd.WriteJSON(ctx, targetIndex, *sourceImage)
 		if err != nil {
 			return errors.Wrap(err, "write target manifest list")
 		}
@@ -391,5 +695,8 @@ func Copy(ctx context.Context, opt Opt) error {
 		logrus.Infof("pushed image %s", target)
 	}
 
+	if batchErr != nil {
+		return batchErr.ErrorOrNil()
+	}
 	return nil
 }
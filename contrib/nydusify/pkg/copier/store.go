@@ -6,6 +6,7 @@ package copier
 
 import (
 	"context"
+	"sync"
 
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/errdefs"
@@ -13,8 +14,11 @@ import (
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// store reports the blobs pushed from backend as existing, which are
+// referenced by target manifests but not stored in the base store.
 type store struct {
 	content.Store
+	mutex   sync.RWMutex
 	remotes []ocispec.Descriptor
 }
 
@@ -25,12 +29,21 @@ func newStore(base content.Store, remotes []ocispec.Descriptor) *store {
 	}
 }
 
+// add records the blobs pushed from backend, it's safe for concurrent use.
+func (s *store) add(remotes []ocispec.Descriptor) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.remotes = append(s.remotes, remotes...)
+}
+
 func (s *store) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
 	info, err := s.Store.Info(ctx, dgst)
 	if err != nil {
 		if !errdefs.IsNotFound(err) {
 			return content.Info{}, err
 		}
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
 		for _, desc := range s.remotes {
 			if desc.Digest == dgst {
 				return content.Info{
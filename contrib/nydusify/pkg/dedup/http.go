@@ -0,0 +1,63 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// httpStore stores the values by `GET` and `PUT` requests to the path of
+// keys under base URL, and `404` means the key doesn't exist.
+type httpStore struct {
+	base   string
+	client *http.Client
+}
+
+func newHTTPStore(u *url.URL) *httpStore {
+	return &httpStore{
+		base:   strings.TrimSuffix(u.String(), "/"),
+		client: http.DefaultClient,
+	}
+}
+
+func (store *httpStore) do(ctx context.Context, method, key string, body io.Reader) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, store.base+"/"+key, body)
+	if err != nil {
+		return "", errors.Wrap(err, "create request")
+	}
+	resp, err := store.client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "%s %s", method, req.URL)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", errors.Wrap(err, "read response")
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("%s %s: unexpected status %s", method, req.URL, resp.Status)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (store *httpStore) Get(ctx context.Context, key string) (string, error) {
+	return store.do(ctx, http.MethodGet, key, nil)
+}
+
+func (store *httpStore) Set(ctx context.Context, key, value string) error {
+	_, err := store.do(ctx, http.MethodPut, key, strings.NewReader(value))
+	return err
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package dedup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const redisDialTimeout = 10 * time.Second
+
+// redisStore is a minimal Redis client speaking RESP, which only supports
+// the commands used by dedup database, a connection is dialed per command
+// since the lookups are rare.
+type redisStore struct {
+	addr     string
+	username string
+	password string
+	db       int
+}
+
+func newRedisStore(u *url.URL) (*redisStore, error) {
+	store := &redisStore{addr: u.Host}
+	if u.Port() == "" {
+		store.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		store.username = u.User.Username()
+		store.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		var err error
+		if store.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %s", db)
+		}
+	}
+	return store, nil
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (store *redisStore) connect(ctx context.Context) (*redisConn, error) {
+	dialer := net.Dialer{Timeout: redisDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", store.addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial redis %s", store.addr)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "set deadline")
+		}
+	}
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if store.password != "" {
+		args := []string{"AUTH", store.password}
+		if store.username != "" {
+			args = []string{"AUTH", store.username, store.password}
+		}
+		if _, _, err := rc.do(args...); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "auth redis")
+		}
+	}
+	if store.db != 0 {
+		if _, _, err := rc.do("SELECT", strconv.Itoa(store.db)); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "select redis database")
+		}
+	}
+	return rc, nil
+}
+
+// do sends the command and returns the reply, the boolean is false if
+// the reply is nil.
+func (rc *redisConn) do(args ...string) (string, bool, error) {
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(rc.conn, cmd.String()); err != nil {
+		return "", false, errors.Wrapf(err, "send %s command", args[0])
+	}
+
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return "", false, errors.Wrapf(err, "read %s reply", args[0])
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", false, fmt.Errorf("empty %s reply", args[0])
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], true, nil
+	case '-':
+		return "", false, fmt.Errorf("redis %s: %s", args[0], line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", false, fmt.Errorf("invalid %s reply %q", args[0], line)
+		}
+		if size < 0 {
+			return "", false, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rc.reader, data); err != nil {
+			return "", false, errors.Wrapf(err, "read %s reply", args[0])
+		}
+		return string(data[:size]), true, nil
+	default:
+		return "", false, fmt.Errorf("unexpected %s reply %q", args[0], line)
+	}
+}
+
+func (store *redisStore) Get(ctx context.Context, key string) (string, error) {
+	rc, err := store.connect(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer rc.conn.Close()
+
+	value, ok, err := rc.do("GET", key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (store *redisStore) Set(ctx context.Context, key, value string) error {
+	rc, err := store.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.conn.Close()
+
+	_, _, err = rc.do("SET", key, value)
+	return err
+}
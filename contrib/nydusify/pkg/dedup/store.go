@@ -0,0 +1,71 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package dedup implements the dedup database shared by converters over the
+// network, which records the chunk dict image of each dedup scope, so that
+// the dedup decisions are made fleet-wide rather than per host.
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned if the key doesn't exist in store.
+var ErrNotFound = errors.New("not found")
+
+// DefaultScope is the dedup scope used if not specified.
+const DefaultScope = "default"
+
+// Store is the key-value store of dedup database.
+type Store interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string) error
+}
+
+// NewStore creates the store by URL, possible schemes: http, https for a
+// simple HTTP service, redis for a Redis server.
+func NewStore(rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse dedup database url")
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return newHTTPStore(u), nil
+	case "redis":
+		return newRedisStore(u)
+	default:
+		return nil, fmt.Errorf("unsupported dedup database %s, possible schemes: http, https, redis", rawURL)
+	}
+}
+
+func chunkDictKey(scope string) string {
+	if scope == "" {
+		scope = DefaultScope
+	}
+	return "nydusify/chunk-dict/" + strings.Trim(scope, "/")
+}
+
+// GetChunkDict returns the chunk dict image reference of the scope, or an
+// empty string if not published.
+func GetChunkDict(ctx context.Context, store Store, scope string) (string, error) {
+	ref, err := store.Get(ctx, chunkDictKey(scope))
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "get chunk dict of scope %s", scope)
+	}
+	return ref, nil
+}
+
+// SetChunkDict publishes the chunk dict image reference of the scope.
+func SetChunkDict(ctx context.Context, store Store, scope, ref string) error {
+	return errors.Wrapf(store.Set(ctx, chunkDictKey(scope), ref), "set chunk dict of scope %s", scope)
+}
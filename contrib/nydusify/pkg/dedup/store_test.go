@@ -0,0 +1,158 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package dedup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStore(t *testing.T) {
+	_, err := NewStore("http://127.0.0.1:8080/dedup")
+	require.NoError(t, err)
+
+	store, err := NewStore("redis://:secret@127.0.0.1/2")
+	require.NoError(t, err)
+	require.Equal(t, &redisStore{addr: "127.0.0.1:6379", password: "secret", db: 2}, store)
+
+	_, err = NewStore("redis://127.0.0.1/db")
+	require.Error(t, err)
+	_, err = NewStore("etcd://127.0.0.1")
+	require.Error(t, err)
+}
+
+func TestHTTPStore(t *testing.T) {
+	var mutex sync.Mutex
+	values := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			value, ok := values[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, value)
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			values[r.URL.Path] = string(data)
+		}
+	}))
+	defer server.Close()
+
+	store, err := NewStore(server.URL + "/dedup/")
+	require.NoError(t, err)
+	testStore(t, store)
+	require.Contains(t, values, "/dedup/nydusify/chunk-dict/default")
+}
+
+// serveRedis serves the GET, SET, AUTH and SELECT commands of RESP.
+func serveRedis(t *testing.T, password string) (string, map[string]string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var mutex sync.Mutex
+	values := map[string]string{}
+	handle := func(conn net.Conn) {
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		authed := password == ""
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			args := make([]string, count)
+			for idx := range args {
+				if _, err := reader.ReadString('\n'); err != nil {
+					return
+				}
+				arg, _ := reader.ReadString('\n')
+				args[idx] = strings.TrimSuffix(arg, "\r\n")
+			}
+			mutex.Lock()
+			switch {
+			case args[0] == "AUTH":
+				authed = args[len(args)-1] == password
+				if authed {
+					fmt.Fprint(conn, "+OK\r\n")
+				} else {
+					fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+				}
+			case !authed:
+				fmt.Fprint(conn, "-NOAUTH Authentication required\r\n")
+			case args[0] == "SELECT":
+				fmt.Fprint(conn, "+OK\r\n")
+			case args[0] == "GET":
+				if value, ok := values[args[1]]; ok {
+					fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+				} else {
+					fmt.Fprint(conn, "$-1\r\n")
+				}
+			case args[0] == "SET":
+				values[args[1]] = args[2]
+				fmt.Fprint(conn, "+OK\r\n")
+			}
+			mutex.Unlock()
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return listener.Addr().String(), values
+}
+
+func TestRedisStore(t *testing.T) {
+	addr, values := serveRedis(t, "secret")
+
+	store, err := NewStore("redis://:secret@" + addr + "/1")
+	require.NoError(t, err)
+	testStore(t, store)
+	require.Contains(t, values, "nydusify/chunk-dict/default")
+
+	store, err = NewStore("redis://" + addr)
+	require.NoError(t, err)
+	_, err = GetChunkDict(context.Background(), store, "")
+	require.ErrorContains(t, err, "NOAUTH")
+}
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	ref, err := GetChunkDict(ctx, store, "")
+	require.NoError(t, err)
+	require.Empty(t, ref)
+
+	require.NoError(t, SetChunkDict(ctx, store, "", "localhost:5000/dict:default"))
+	require.NoError(t, SetChunkDict(ctx, store, "team-a", "localhost:5000/dict:team-a"))
+
+	ref, err = GetChunkDict(ctx, store, DefaultScope)
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/dict:default", ref)
+	ref, err = GetChunkDict(ctx, store, "team-a")
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/dict:team-a", ref)
+}
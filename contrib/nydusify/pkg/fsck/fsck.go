@@ -0,0 +1,364 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package fsck checks the availability of the blobs referenced by the
+// bootstrap of a deployed Nydus image in its storage backend, to find out
+// the missing or corrupted blobs before debugging nydusd errors on nodes.
+package fsck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/build"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// Status is the availability of a blob in storage backend.
+type Status string
+
+const (
+	StatusPresent Status = "present"
+	StatusMissing Status = "missing"
+	// StatusSizeMismatch means the size of stored blob differs from the
+	// size recorded in bootstrap or manifest, e.g. a truncated upload.
+	StatusSizeMismatch Status = "size_mismatch"
+	// StatusCorrupted means the digest of stored blob data mismatches the
+	// blob id, which is only detected by deep check.
+	StatusCorrupted Status = "corrupted"
+	// StatusError means the blob can't be checked, e.g. permission denied.
+	StatusError Status = "error"
+)
+
+type Opt struct {
+	WorkDir        string
+	Target         string
+	TargetInsecure bool
+	NydusImagePath string
+	// BackendType is the storage backend of blobs, the blobs are stored
+	// as the layers of image for empty or `registry` backend.
+	BackendType   string
+	BackendConfig string
+	ExpectedArch  string
+	// Deep reads the whole blobs to verify their digests, otherwise only
+	// the existence and size of blobs are checked.
+	Deep        bool
+	Concurrency int
+	OutputJSON  string
+}
+
+// Blob is the check result of a blob in the blob table of bootstrap.
+type Blob struct {
+	ID string `json:"id"`
+	// Size is the expected size of blob, zero if unknown.
+	Size int64 `json:"size,omitempty"`
+	// StoredSize is the size of blob in storage backend, zero if unknown.
+	StoredSize int64  `json:"stored_size,omitempty"`
+	Status     Status `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Result is the check result of image.
+type Result struct {
+	Target  string         `json:"target"`
+	Backend string         `json:"backend"`
+	Deep    bool           `json:"deep"`
+	Blobs   []Blob         `json:"blobs"`
+	Summary map[Status]int `json:"summary"`
+}
+
+// Healthy returns true if all blobs are present.
+func (result *Result) Healthy() bool {
+	return result.Summary[StatusPresent] == len(result.Blobs)
+}
+
+// storage reads the blobs from storage backend.
+type storage interface {
+	// size returns the size of blob in storage, or -1 if it's unknown
+	// without reading the blob, errdefs.ErrNotFound is returned if the
+	// blob doesn't exist.
+	size(ctx context.Context, blob *Blob) (int64, error)
+	reader(ctx context.Context, blob *Blob) (io.ReadCloser, error)
+}
+
+// registryStorage reads the blobs stored as the layers of image.
+type registryStorage struct {
+	remote *remote.Remote
+	layers map[string]ocispec.Descriptor
+}
+
+func (s *registryStorage) size(ctx context.Context, blob *Blob) (int64, error) {
+	reader, err := s.reader(ctx, blob)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	// The blob is opened lazily by the first read.
+	if _, err := reader.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return 0, err
+	}
+	return -1, nil
+}
+
+func (s *registryStorage) reader(ctx context.Context, blob *Blob) (io.ReadCloser, error) {
+	desc, ok := s.layers[blob.ID]
+	if !ok {
+		return nil, errors.Wrap(errdefs.ErrNotFound, "blob isn't a layer of manifest")
+	}
+	return s.remote.Pull(ctx, desc, true)
+}
+
+// backendStorage reads the blobs from the storage backend other than
+// registry.
+type backendStorage struct {
+	backend backend.Backend
+}
+
+func (s *backendStorage) size(_ context.Context, blob *Blob) (int64, error) {
+	exist, err := s.backend.Check(blob.ID)
+	if err != nil {
+		return 0, err
+	}
+	if !exist {
+		return 0, errdefs.ErrNotFound
+	}
+	return s.backend.Size(blob.ID)
+}
+
+func (s *backendStorage) reader(_ context.Context, blob *Blob) (io.ReadCloser, error) {
+	return s.backend.Reader(blob.ID)
+}
+
+// check checks the blob in storage and sets its status.
+func check(ctx context.Context, s storage, blob *Blob, deep bool) {
+	blob.Status = StatusError
+	size, err := s.size(ctx, blob)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			blob.Status = StatusMissing
+		}
+		blob.Error = err.Error()
+		return
+	}
+	if size >= 0 {
+		blob.StoredSize = size
+		if blob.Size > 0 && size != blob.Size {
+			blob.Status = StatusSizeMismatch
+			return
+		}
+	}
+	if !deep {
+		blob.Status = StatusPresent
+		return
+	}
+
+	reader, err := s.reader(ctx, blob)
+	if err != nil {
+		blob.Error = errors.Wrap(err, "read blob").Error()
+		return
+	}
+	defer reader.Close()
+	hasher := sha256.New()
+	read, err := io.Copy(hasher, reader)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			blob.Status = StatusMissing
+		}
+		blob.Error = errors.Wrap(err, "read blob").Error()
+		return
+	}
+	blob.StoredSize = read
+	if blob.Size > 0 && read != blob.Size {
+		blob.Status = StatusSizeMismatch
+		return
+	}
+	// The blob id is the sha256 hex of blob data, which is used to verify
+	// the data, the blob with other form of id is only checked by size.
+	if digest.NewDigestFromEncoded(digest.SHA256, blob.ID).Validate() == nil &&
+		hex.EncodeToString(hasher.Sum(nil)) != blob.ID {
+		blob.Status = StatusCorrupted
+		return
+	}
+	blob.Status = StatusPresent
+}
+
+// bootstrapBlobs lists the blobs in the blob table of bootstrap by
+// `nydus-image check`, with the compressed sizes from `nydus-image inspect`
+// if available.
+func bootstrapBlobs(nydusImagePath, bootstrapPath, debugOutputPath string) ([]Blob, error) {
+	if err := tool.NewBuilder(nydusImagePath).Check(tool.BuilderOption{
+		BootstrapPath:   bootstrapPath,
+		DebugOutputPath: debugOutputPath,
+	}); err != nil {
+		return nil, errors.Wrap(err, "invalid nydus bootstrap format")
+	}
+
+	sizes := map[string]int64{}
+	infos, err := tool.NewInspector(nydusImagePath).Inspect(tool.InspectOption{
+		Operation: tool.GetBlobs,
+		Bootstrap: bootstrapPath,
+	})
+	if err != nil {
+		logrus.WithError(err).Warn("failed to inspect blob sizes, skip checking them")
+	} else {
+		for _, info := range infos.(tool.BlobInfoList) {
+			sizes[info.BlobID] = int64(info.CompressedSize)
+		}
+	}
+
+	blobs := []Blob{}
+	if _, err := build.ParseOutputBlobs(debugOutputPath, func(id string) error {
+		blobs = append(blobs, Blob{ID: id, Size: sizes[id]})
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "parse bootstrap debug json")
+	}
+	return blobs, nil
+}
+
+func parse(ctx context.Context, targetParser *parser.Parser) (*parser.Parsed, error) {
+	parsed, err := targetParser.Parse(ctx)
+	if err != nil && utils.RetryWithHTTP(err) {
+		targetParser.Remote.MaybeWithHTTP(err)
+		parsed, err = targetParser.Parse(ctx)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "parse Nydus image")
+	}
+	if parsed.NydusImage == nil {
+		return nil, fmt.Errorf("no Nydus manifest is found in %s", targetParser.Remote.Ref)
+	}
+	return parsed, nil
+}
+
+// Fsck checks the blobs referenced by the bootstrap of Nydus image in
+// storage backend. The check is at blob level, as the chunk table of
+// bootstrap isn't exposed by nydus-image.
+func Fsck(ctx context.Context, opt Opt) (*Result, error) {
+	targetRemote, err := provider.DefaultRemote(opt.Target, opt.TargetInsecure)
+	if err != nil {
+		return nil, errors.Wrap(err, "init target image parser")
+	}
+	targetParser, err := parser.New(targetRemote, opt.ExpectedArch)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create parser")
+	}
+	parsed, err := parse(ctx, targetParser)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(opt.WorkDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create work directory")
+	}
+	bootstrapPath := filepath.Join(opt.WorkDir, "nydus_bootstrap")
+	bootstrapReader, err := targetParser.PullNydusBootstrap(ctx, parsed.NydusImage)
+	if err != nil {
+		return nil, errors.Wrap(err, "pull Nydus bootstrap layer")
+	}
+	defer bootstrapReader.Close()
+	if err := utils.UnpackFile(bootstrapReader, utils.BootstrapFileNameInLayer, bootstrapPath); err != nil {
+		return nil, errors.Wrap(err, "unpack Nydus bootstrap layer")
+	}
+	blobs, err := bootstrapBlobs(opt.NydusImagePath, bootstrapPath, filepath.Join(opt.WorkDir, "nydus_bootstrap_debug.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Target:  opt.Target,
+		Backend: opt.BackendType,
+		Deep:    opt.Deep,
+		Blobs:   blobs,
+		Summary: map[Status]int{},
+	}
+	var s storage
+	if opt.BackendType == "" || opt.BackendType == "registry" {
+		result.Backend = "registry"
+		layers := map[string]ocispec.Descriptor{}
+		for _, layer := range parsed.NydusImage.Manifest.Layers {
+			layers[layer.Digest.Encoded()] = layer
+		}
+		for idx := range blobs {
+			if layer, ok := layers[blobs[idx].ID]; ok && blobs[idx].Size == 0 {
+				blobs[idx].Size = layer.Size
+			}
+		}
+		s = &registryStorage{remote: targetRemote, layers: layers}
+	} else {
+		bkd, err := backend.NewBackend(opt.BackendType, []byte(opt.BackendConfig), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "create storage backend")
+		}
+		s = &backendStorage{backend: bkd}
+	}
+
+	logrus.Infof("Checking %d blobs of %s in %s backend", len(blobs), opt.Target, result.Backend)
+	eg, egCtx := errgroup.WithContext(ctx)
+	if opt.Concurrency > 0 {
+		eg.SetLimit(opt.Concurrency)
+	}
+	for idx := range blobs {
+		blob := &blobs[idx]
+		eg.Go(func() error {
+			check(egCtx, s, blob, opt.Deep)
+			if blob.Status != StatusPresent {
+				logrus.Warnf("blob %s is %s: %s", blob.ID, blob.Status, blob.Error)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, blob := range blobs {
+		result.Summary[blob.Status]++
+	}
+
+	if opt.OutputJSON != "" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal fsck result")
+		}
+		if err := os.WriteFile(opt.OutputJSON, data, 0644); err != nil {
+			return nil, errors.Wrap(err, "write fsck result")
+		}
+	}
+
+	return result, nil
+}
+
+// Print prints the check result as table, one row per blob.
+func (result *Result) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "BLOB\tSIZE\tSTORED SIZE\tSTATUS\tERROR")
+	for _, blob := range result.Blobs {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", blob.ID, blob.Size, blob.StoredSize, blob.Status, blob.Error)
+	}
+	fmt.Fprintf(tw, "\n%d of %d blobs of %s are present in %s backend\n",
+		result.Summary[StatusPresent], len(result.Blobs), result.Target, result.Backend)
+	return tw.Flush()
+}
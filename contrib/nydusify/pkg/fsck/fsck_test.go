@@ -0,0 +1,106 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package fsck
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func bootstrapLayer(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	data := []byte("bootstrap")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: utils.BootstrapFileNameInLayer, Mode: 0644, Size: int64(len(data))}))
+	_, err := tw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestFsck(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+
+	present := registry.PutBlob("app", utils.MediaTypeNydusBlob, []byte("present blob"))
+	lost := digest.FromString("lost blob")
+	unreferenced := digest.FromString("unreferenced blob")
+	bootstrap := registry.PutBlob("app", ocispec.MediaTypeImageLayerGzip, bootstrapLayer(t))
+	bootstrap.Annotations = map[string]string{utils.LayerAnnotationNydusBootstrap: "true"}
+	_, err := registry.PutManifest("app", "v1-nydus", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    registry.PutBlob("app", ocispec.MediaTypeImageConfig, []byte("{}")),
+		Layers: []ocispec.Descriptor{
+			present,
+			{MediaType: utils.MediaTypeNydusBlob, Digest: lost, Size: 9},
+			bootstrap,
+		},
+	})
+	require.NoError(t, err)
+
+	stub, err := testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{
+		Blobs: []string{present.Digest.Encoded(), lost.Encoded(), unreferenced.Encoded()},
+	})
+	require.NoError(t, err)
+	opt := Opt{
+		WorkDir:        t.TempDir(),
+		Target:         registry.Host() + "/app:v1-nydus",
+		NydusImagePath: stub.Path,
+		ExpectedArch:   "amd64",
+		Deep:           true,
+		Concurrency:    2,
+		OutputJSON:     filepath.Join(t.TempDir(), "fsck.json"),
+	}
+
+	// The blobs are stored as the layers of image.
+	result, err := Fsck(context.Background(), opt)
+	require.NoError(t, err)
+	require.False(t, result.Healthy())
+	require.Equal(t, "registry", result.Backend)
+	require.Equal(t, Blob{ID: present.Digest.Encoded(), Size: present.Size, StoredSize: present.Size, Status: StatusPresent}, result.Blobs[0])
+	require.Equal(t, StatusMissing, result.Blobs[1].Status)
+	require.Equal(t, StatusMissing, result.Blobs[2].Status)
+	require.Contains(t, result.Blobs[2].Error, "isn't a layer of manifest")
+	require.Equal(t, map[Status]int{StatusPresent: 1, StatusMissing: 2}, result.Summary)
+	require.FileExists(t, opt.OutputJSON)
+
+	// The blobs are stored in localfs backend.
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, present.Digest.Encoded()), []byte("present blob"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, lost.Encoded()), []byte("corrupted"), 0644))
+	opt.BackendType = "localfs"
+	opt.BackendConfig = fmt.Sprintf(`{"dir": %q}`, dir)
+	result, err = Fsck(context.Background(), opt)
+	require.NoError(t, err)
+	require.Equal(t, "localfs", result.Backend)
+	require.Equal(t, StatusPresent, result.Blobs[0].Status)
+	require.Equal(t, StatusCorrupted, result.Blobs[1].Status)
+	require.Equal(t, StatusMissing, result.Blobs[2].Status)
+
+	// The corrupted blob isn't detected without reading data.
+	opt.Deep = false
+	result, err = Fsck(context.Background(), opt)
+	require.NoError(t, err)
+	require.Equal(t, StatusPresent, result.Blobs[1].Status)
+	require.Equal(t, int64(9), result.Blobs[1].StoredSize)
+
+	var buf bytes.Buffer
+	require.NoError(t, result.Print(&buf))
+	require.Contains(t, buf.String(), "2 of 3 blobs")
+}
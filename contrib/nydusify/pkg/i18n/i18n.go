@@ -0,0 +1,91 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package i18n translates the user-facing messages of nydusify by the
+// message catalogs. The English message itself is the key of catalog, the
+// message without translation is left in English.
+package i18n
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultLanguage is the language of messages in source code.
+const DefaultLanguage = "en"
+
+// catalogs are the translations of messages keyed by language tag.
+var catalogs = map[string]map[string]string{
+	"zh-CN": zhCN,
+}
+
+var (
+	mutex    sync.RWMutex
+	language = DefaultLanguage
+)
+
+// Normalize returns the supported language tag of the language name, like
+// `zh_CN.UTF-8` in the LANG environment variable, DefaultLanguage is
+// returned for the unsupported ones.
+func Normalize(name string) string {
+	name, _, _ = strings.Cut(name, ".")
+	name, _, _ = strings.Cut(name, "@")
+	name = strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+	switch name {
+	case "zh", "zh-cn", "zh-sg", "zh-hans", "zh-hans-cn":
+		return "zh-CN"
+	}
+	return DefaultLanguage
+}
+
+// SetLanguage sets the language of messages by the name, see Normalize.
+func SetLanguage(name string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	language = Normalize(name)
+}
+
+// Language returns the language of messages.
+func Language() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return language
+}
+
+// Translate returns the translation of message in the language, the
+// message itself is returned if it isn't translated.
+func Translate(lang, message string) string {
+	if translated, ok := catalogs[lang][message]; ok {
+		return translated
+	}
+	return message
+}
+
+// Translated checks whether the message is translated in the language.
+func Translated(lang, message string) bool {
+	_, ok := catalogs[lang][message]
+	return ok
+}
+
+// T translates the message into the current language.
+func T(message string) string {
+	return Translate(Language(), message)
+}
+
+// Errorf formats the error with the translated format.
+func Errorf(format string, args ...interface{}) error {
+	return errors.Errorf(T(format), args...)
+}
+
+// Wrap annotates err with the translated message.
+func Wrap(err error, message string) error {
+	return errors.Wrap(err, T(message))
+}
+
+// Wrapf annotates err with the translated format.
+func Wrapf(err error, format string, args ...interface{}) error {
+	return errors.Wrapf(err, T(format), args...)
+}
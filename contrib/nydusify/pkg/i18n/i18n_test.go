@@ -0,0 +1,51 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package i18n
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+var verbPattern = regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]+)?[a-zA-Z%]`)
+
+func TestCatalogVerbs(t *testing.T) {
+	for lang, catalog := range catalogs {
+		for message, translated := range catalog {
+			require.Equal(t, verbPattern.FindAllString(message, -1), verbPattern.FindAllString(translated, -1), "%s: %s", lang, message)
+			require.NotEmpty(t, translated)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	for name, expected := range map[string]string{
+		"":            DefaultLanguage,
+		"C":           DefaultLanguage,
+		"en_US.UTF-8": DefaultLanguage,
+		"fr_FR":       DefaultLanguage,
+		"zh_CN.UTF-8": "zh-CN",
+		"zh-CN":       "zh-CN",
+		"zh_CN@utf8":  "zh-CN",
+		"zh":          "zh-CN",
+	} {
+		require.Equal(t, expected, Normalize(name), name)
+	}
+
+	defer SetLanguage(DefaultLanguage)
+	SetLanguage("zh_CN.UTF-8")
+	require.Equal(t, "zh-CN", Language())
+	require.EqualError(t, Errorf("--fs-version should be one of %v", []string{"5", "6"}), "--fs-version 应为 [5 6] 之一")
+	require.EqualError(t, Wrap(errors.New("EOF"), "read source file"), "读取源镜像列表文件: EOF")
+	require.EqualError(t, Errorf("untranslated %s", "message"), "untranslated message")
+
+	SetLanguage("en_US.UTF-8")
+	require.EqualError(t, Wrapf(errors.New("EOF"), "invalid --%s option", "blob-size"), "invalid --blob-size option: EOF")
+	require.True(t, Translated("zh-CN", "read source file"))
+	require.False(t, Translated(DefaultLanguage, "read source file"))
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package i18n
+
+// zhCN is the Simplified Chinese catalog, the format verbs of translation
+// must be the same as the message in order.
+var zhCN = map[string]string{
+	// The failure types of exit codes.
+	"invalid options or configuration": "选项或配置无效",
+	"authentication failed":            "认证失败",
+	"source not found":                 "源镜像不存在",
+	"builder failed":                   "构建工具执行失败",
+	"push failed":                      "推送失败",
+	"check failed":                     "检查未通过",
+
+	// The global options.
+	"--unpack-workers can't be negative":                              "--unpack-workers 不能为负数",
+	"--http-record and --http-replay can't be used together":          "--http-record 和 --http-replay 不能同时使用",
+	"--pull-retry and --push-retry can't be negative":                 "--pull-retry 和 --push-retry 不能为负数",
+	"invalid --output-progress %s, should be auto, bar, json or none": "无效的 --output-progress %s，应为 auto、bar、json 或 none",
+	"invalid --%s option":                                             "无效的 --%s 选项",
+
+	// The storage backend options.
+	"--backend-config conflicts with --backend-config-file":                      "--backend-config 与 --backend-config-file 冲突",
+	"parse backend config file":                                                  "解析存储后端配置文件",
+	"backend type is empty, please specify option '--%sbackend-type'":            "存储后端类型为空，请指定选项 '--%sbackend-type'",
+	"--%sbackend-type should be one of %v":                                       "--%sbackend-type 应为 %v 之一",
+	"backend configuration is empty, please specify option '--%sbackend-config'": "存储后端配置为空，请指定选项 '--%sbackend-config'",
+	"failed to parse backend-config '%s', err = %v":                              "解析 backend-config '%s' 失败，错误：%v",
+	"--repo is required for registry backend":                                    "registry 存储后端需要指定 --repo",
+
+	// The image references.
+	"invalid source image reference: %s":                              "无效的源镜像引用：%s",
+	"unsupported digested image reference: %s":                        "不支持带摘要的镜像引用：%s",
+	"invalid target image reference: %s":                              "无效的目标镜像引用：%s",
+	"invalid target image reference %s rendered from template: %s":    "由模板生成的目标镜像引用 %s 无效：%s",
+	"--target conflicts with --target-suffix":                         "--target 与 --target-suffix 冲突",
+	"--target-template conflicts with --target and --target-suffix":   "--target-template 与 --target 和 --target-suffix 冲突",
+	"--target-template can't be used with source in %s transport":     "--target-template 不能用于 %s 传输方式的源镜像",
+	"--target or --target-suffix is required":                         "需要指定 --target 或 --target-suffix",
+	"--target-suffix can't be used with source in %s transport":       "--target-suffix 不能用于 %s 传输方式的源镜像",
+	"--sources and --source-file conflict with --source and --target": "--sources 和 --source-file 与 --source 和 --target 冲突",
+	"--source, --sources or --source-file is required":                "需要指定 --source、--sources 或 --source-file",
+	"read source file": "读取源镜像列表文件",
+	"invalid line %d in %s, should be in the form of 'SOURCE [TARGET]'": "第 %d 行无效（%s），格式应为 'SOURCE [TARGET]'",
+	"no image to copy in %s":                                  "%s 中没有需要复制的镜像",
+	"--target-template is required to copy %s without target": "复制未指定目标的 %s 需要 --target-template",
+
+	// The conversion options.
+	"--build-cache conflicts with --build-cache-tag":                                                         "--build-cache 与 --build-cache-tag 冲突",
+	"--build-cache-tag can't be used with target in %s transport":                                            "--build-cache-tag 不能用于 %s 传输方式的目标镜像",
+	"--build-cache-max-records should be greater than 0":                                                     "--build-cache-max-records 应大于 0",
+	"--build-cache-max-records should not be greater than %d":                                                "--build-cache-max-records 不应大于 %d",
+	"--fs-version should be one of %v":                                                                       "--fs-version 应为 %v 之一",
+	"--compat-fs-version should be one of %v":                                                                "--compat-fs-version 应为 %v 之一",
+	"--compat-fs-version should be different from --fs-version":                                              "--compat-fs-version 应与 --fs-version 不同",
+	"--prefetch-dir conflicts with --prefetch-patterns":                                                      "--prefetch-dir 与 --prefetch-patterns 冲突",
+	"parse chunk dict arguments":                                                                             "解析 chunk dict 参数",
+	"invalid --verify-source options":                                                                        "无效的 --verify-source 选项",
+	"invalid --sign-target options":                                                                          "无效的 --sign-target 选项",
+	"invalid --verify-run-command option":                                                                    "无效的 --verify-run-command 选项",
+	"invalid tenant quota %s, should be in the form of tenant=N":                                             "无效的租户配额 %s，格式应为 tenant=N",
+	"invalid tenant quota %s, should be a non-negative integer":                                              "无效的租户配额 %s，应为非负整数",
+	"invalid --push-chunk-size option":                                                                       "无效的 --push-chunk-size 选项",
+	"parse digest algorithm":                                                                                 "解析摘要算法",
+	"--verify-key conflicts with --insecure-skip-verify":                                                     "--verify-key 与 --insecure-skip-verify 冲突",
+	"--verify-key is required to verify the bundle signature, or specify --insecure-skip-verify to skip it":  "验证镜像包签名需要 --verify-key，或指定 --insecure-skip-verify 跳过验证",
+	"--verify-key is required to verify the release signature, or specify --insecure-skip-verify to skip it": "验证发布包签名需要 --verify-key，或指定 --insecure-skip-verify 跳过验证",
+
+	// The storage backend tools.
+	"invalid --blob-size %s":                    "无效的 --blob-size %s",
+	"invalid --chunk-size %s":                   "无效的 --chunk-size %s",
+	"nothing to prune, please specify --mounts": "没有需要清理的内容，请指定 --mounts",
+
+	// The node seeding.
+	"read node file":                    "读取节点列表文件",
+	"--node or --node-file is required": "需要指定 --node 或 --node-file",
+	"no file to prefetch in %s":         "%s 中没有需要预取的文件",
+
+	// The registry credentials.
+	"--credential-audit requires --credential-config": "--credential-audit 需要同时指定 --credential-config",
+	"open credential audit file":                      "打开凭据审计文件",
+
+	// The check results.
+	"%d of %d blobs are unavailable":                      "%d 个 blob 不可用（共 %d 个）",
+	"image %s is incompatible with the specified version": "镜像 %s 与指定版本不兼容",
+}
@@ -0,0 +1,243 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package lister enumerates the tags in a repository and reports which
+// ones are Nydus images, for auditing the migration progress.
+package lister
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// RemoteFunc creates the remote of image reference.
+type RemoteFunc func(ref string) (*remote.Remote, error)
+
+type Opt struct {
+	// Repo is the repository to list, e.g. `docker.io/library/nginx`.
+	Repo        string
+	Concurrency int
+	OutputJSON  string
+}
+
+// Manifest is an image manifest of tag, the platform is only available
+// for the manifest in index.
+type Manifest struct {
+	Digest    digest.Digest `json:"digest"`
+	Platform  string        `json:"platform,omitempty"`
+	Nydus     bool          `json:"nydus"`
+	FsVersion string        `json:"fs_version,omitempty"`
+	// Options are the conversion options recorded in Nydus manifest.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Tag is a tag in repository.
+type Tag struct {
+	Tag       string        `json:"tag"`
+	Digest    digest.Digest `json:"digest,omitempty"`
+	MediaType string        `json:"media_type,omitempty"`
+	Nydus     bool          `json:"nydus"`
+	Manifests []Manifest    `json:"manifests,omitempty"`
+	// Error is the reason why the tag can't be inspected.
+	Error string `json:"error,omitempty"`
+}
+
+// Result is the listing result of repository.
+type Result struct {
+	Repo       string `json:"repo"`
+	Total      int    `json:"total"`
+	NydusTotal int    `json:"nydus_total"`
+	Tags       []Tag  `json:"tags"`
+}
+
+var optionAnnotations = map[string]string{
+	utils.ManifestNydusCompressor:         "compressor",
+	utils.ManifestNydusChunkSize:          "chunk_size",
+	utils.ManifestNydusBatchSize:          "batch_size",
+	utils.ManifestNydusFsAlignChunk:       "fs_align_chunk",
+	utils.ManifestNydusChunkDictReference: "chunk_dict",
+	utils.ManifestNydusUnpackFilter:       "unpack_filter",
+}
+
+// isNydusManifest checks if it's a Nydus manifest, the bootstrap may be
+// placed as layer or separate artifact.
+func isNydusManifest(manifest *ocispec.Manifest) bool {
+	if parser.FindNydusBootstrapDesc(manifest) != nil || manifest.Annotations[utils.ManifestNydusBootstrap] != "" {
+		return true
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == utils.MediaTypeNydusBlob {
+			return true
+		}
+	}
+	return false
+}
+
+func inspectManifest(manifest *ocispec.Manifest, desc ocispec.Descriptor) Manifest {
+	result := Manifest{
+		Digest: desc.Digest,
+		Nydus:  isNydusManifest(manifest),
+	}
+	if desc.Platform != nil {
+		result.Platform = platforms.Format(*desc.Platform)
+	}
+	if !result.Nydus {
+		return result
+	}
+	if bootstrap := parser.FindNydusBootstrapDesc(manifest); bootstrap != nil {
+		result.FsVersion = bootstrap.Annotations[utils.LayerAnnotationNydusFsVersion]
+	}
+	for key, name := range optionAnnotations {
+		if value, ok := manifest.Annotations[key]; ok {
+			if result.Options == nil {
+				result.Options = map[string]string{}
+			}
+			result.Options[name] = value
+		}
+	}
+	return result
+}
+
+func inspectTag(ctx context.Context, rmt *remote.Remote, tag string) (Tag, error) {
+	result := Tag{Tag: tag}
+	desc, err := rmt.WalkManifests(ctx, func(desc ocispec.Descriptor, manifest *ocispec.Manifest) error {
+		result.Manifests = append(result.Manifests, inspectManifest(manifest, desc))
+		return nil
+	})
+	if desc != nil {
+		result.Digest = desc.Digest
+		result.MediaType = desc.MediaType
+	}
+	if err != nil {
+		return result, err
+	}
+
+	for _, manifest := range result.Manifests {
+		result.Nydus = result.Nydus || manifest.Nydus
+	}
+	return result, nil
+}
+
+// List enumerates the tags in repository and inspects each of them, the
+// tags failed to inspect are reported with error instead of failing the
+// whole listing.
+func List(ctx context.Context, opt Opt, remoteFunc RemoteFunc) (*Result, error) {
+	named, err := reference.ParseNormalizedNamed(opt.Repo)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse repository")
+	}
+	if _, ok := named.(reference.Tagged); ok {
+		return nil, fmt.Errorf("repository %s should not contain tag", opt.Repo)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return nil, fmt.Errorf("repository %s should not contain digest", opt.Repo)
+	}
+	repo := named.Name()
+
+	rmt, err := remoteFunc(repo)
+	if err != nil {
+		return nil, errors.Wrap(err, "create remote")
+	}
+	tagNames, err := rmt.Tags(ctx)
+	if err != nil {
+		rmt.MaybeWithHTTP(err)
+		if !rmt.IsWithHTTP() {
+			return nil, errors.Wrapf(err, "list tags of %s", repo)
+		}
+		if tagNames, err = rmt.Tags(ctx); err != nil {
+			return nil, errors.Wrapf(err, "list tags of %s", repo)
+		}
+	}
+	sort.Strings(tagNames)
+
+	result := &Result{
+		Repo: repo,
+		Tags: make([]Tag, len(tagNames)),
+	}
+	eg, egCtx := errgroup.WithContext(ctx)
+	if opt.Concurrency > 0 {
+		eg.SetLimit(opt.Concurrency)
+	}
+	for idx, tagName := range tagNames {
+		idx, tagName := idx, tagName
+		eg.Go(func() error {
+			tagRemote, err := remoteFunc(repo + ":" + tagName)
+			if err != nil {
+				return errors.Wrap(err, "create remote")
+			}
+			if rmt.IsWithHTTP() {
+				tagRemote.WithHTTP()
+			}
+			tag, err := inspectTag(egCtx, tagRemote, tagName)
+			if err != nil {
+				logrus.WithError(err).Warnf("failed to inspect tag %s", tagName)
+				tag.Error = err.Error()
+			}
+			result.Tags[idx] = tag
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	result.Total = len(result.Tags)
+	for _, tag := range result.Tags {
+		if tag.Nydus {
+			result.NydusTotal++
+		}
+	}
+
+	if opt.OutputJSON != "" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal listing result")
+		}
+		if err := os.WriteFile(opt.OutputJSON, data, 0644); err != nil {
+			return nil, errors.Wrap(err, "write listing result")
+		}
+	}
+
+	return result, nil
+}
+
+// Print prints the listing result as table, one row per manifest.
+func (result *Result) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TAG\tDIGEST\tPLATFORM\tNYDUS\tFS VERSION\tOPTIONS")
+	for _, tag := range result.Tags {
+		if tag.Error != "" {
+			fmt.Fprintf(tw, "%s\t%s\t\t\t\terror: %s\n", tag.Tag, tag.Digest, tag.Error)
+			continue
+		}
+		for _, manifest := range tag.Manifests {
+			options := []string{}
+			for key, value := range manifest.Options {
+				options = append(options, key+"="+value)
+			}
+			sort.Strings(options)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\t%s\n", tag.Tag, manifest.Digest, manifest.Platform, manifest.Nydus, manifest.FsVersion, strings.Join(options, ","))
+		}
+	}
+	fmt.Fprintf(tw, "\n%d of %d tags in %s are nydus images\n", result.NydusTotal, result.Total, result.Repo)
+	return tw.Flush()
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package lister
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+type blob struct {
+	mediaType string
+	data      []byte
+}
+
+// fakeRegistry serves the tags list in pages of 2 tags, and the manifests
+// by tag or digest.
+type fakeRegistry struct {
+	tags  map[string]digest.Digest
+	blobs map[digest.Digest]blob
+}
+
+func (registry *fakeRegistry) add(t *testing.T, tag string, mediaType string, v interface{}) ocispec.Descriptor {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	dgst := digest.FromBytes(data)
+	registry.blobs[dgst] = blob{mediaType: mediaType, data: data}
+	if tag != "" {
+		registry.tags[tag] = dgst
+	}
+	return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+}
+
+func (registry *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const prefix = "/v2/app/"
+	switch {
+	case r.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case r.URL.Path == prefix+"tags/list":
+		tags := []string{"v1", "v1-nydus", "v2"}
+		if r.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/app/tags/list?n=2&last=v1-nydus>; rel="next"`)
+			tags = tags[:2]
+		} else {
+			tags = tags[2:]
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": "app", "tags": tags})
+	case strings.HasPrefix(r.URL.Path, prefix+"manifests/"):
+		ref := strings.TrimPrefix(r.URL.Path, prefix+"manifests/")
+		dgst, ok := registry.tags[ref]
+		if !ok {
+			dgst = digest.Digest(ref)
+		}
+		b, ok := registry.blobs[dgst]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", b.mediaType)
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.Header().Set("Content-Length", fmt.Sprint(len(b.data)))
+		if r.Method == http.MethodGet {
+			w.Write(b.data)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestList(t *testing.T) {
+	registry := &fakeRegistry{tags: map[string]digest.Digest{}, blobs: map[digest.Digest]blob{}}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	ociManifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers:    []ocispec.Descriptor{{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("layer")}},
+	}
+	ociDesc := registry.add(t, "v1", ocispec.MediaTypeImageManifest, ociManifest)
+	nydusDesc := registry.add(t, "", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{
+			{MediaType: utils.MediaTypeNydusBlob, Digest: digest.FromString("blob")},
+			{
+				MediaType: ocispec.MediaTypeImageLayerGzip,
+				Digest:    digest.FromString("bootstrap"),
+				Annotations: map[string]string{
+					utils.LayerAnnotationNydusBootstrap: "true",
+					utils.LayerAnnotationNydusFsVersion: "6",
+				},
+			},
+		},
+		Annotations: map[string]string{utils.ManifestNydusCompressor: "zstd"},
+	})
+	ociDesc.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	nydusDesc.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64", OSFeatures: []string{utils.ManifestOSFeatureNydus}}
+	registry.add(t, "v1-nydus", ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{ociDesc, nydusDesc},
+	})
+
+	result, err := List(context.Background(), Opt{Repo: host + "/app", Concurrency: 2}, func(ref string) (*remote.Remote, error) {
+		return provider.DefaultRemote(ref, false)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, result.Total)
+	require.Equal(t, 1, result.NydusTotal)
+
+	require.Equal(t, "v1", result.Tags[0].Tag)
+	require.False(t, result.Tags[0].Nydus)
+	require.Equal(t, "v1-nydus", result.Tags[1].Tag)
+	require.True(t, result.Tags[1].Nydus)
+	require.Equal(t, Manifest{
+		Digest:    nydusDesc.Digest,
+		Platform:  "linux/amd64",
+		Nydus:     true,
+		FsVersion: "6",
+		Options:   map[string]string{"compressor": "zstd"},
+	}, result.Tags[1].Manifests[1])
+	// The tag v2 doesn't exist.
+	require.NotEmpty(t, result.Tags[2].Error)
+
+	var buf bytes.Buffer
+	require.NoError(t, result.Print(&buf))
+	require.Contains(t, buf.String(), "1 of 3 tags in "+host+"/app are nydus images")
+
+	_, err = List(context.Background(), Opt{Repo: host + "/app:v1"}, nil)
+	require.Error(t, err)
+}
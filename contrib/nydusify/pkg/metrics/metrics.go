@@ -5,11 +5,16 @@
 package metrics
 
 import (
+	"net"
+	"net/http"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
 )
 
 type Exporter interface {
@@ -65,6 +70,36 @@ var (
 		},
 		[]string{"source_reference"},
 	)
+
+	layersConverted = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "layers_converted_total",
+			Help:      "The total count of layers built into nydus blobs.",
+		},
+	)
+
+	blobPushedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "blob_pushed_bytes_total",
+			Help:      "The total bytes of blobs pushed. Broken down by target, i.e. registry or the type of storage backend.",
+		},
+		[]string{"target"},
+	)
+
+	buildDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "build_duration_seconds",
+			Help:      "The duration of running nydus-image. Broken down by subcommands.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+		},
+		[]string{"command"},
+	)
 )
 
 var register sync.Once
@@ -79,7 +114,10 @@ func sinceInSeconds(start time.Time) float64 {
 func Register(exp Exporter) {
 	register.Do(func() {
 		Registry = prometheus.NewRegistry()
-		Registry.MustRegister(convertDuration, convertSuccessCount, convertFailureCount, storeCacheDuration)
+		Registry.MustRegister(
+			convertDuration, convertSuccessCount, convertFailureCount, storeCacheDuration,
+			layersConverted, blobPushedBytes, buildDuration,
+		)
 		exporter = exp
 	})
 }
@@ -106,3 +144,37 @@ func ConversionFailureCount(ref string, reason string) {
 func StoreCacheDuration(ref string, start time.Time) {
 	storeCacheDuration.WithLabelValues(ref).Add(sinceInSeconds(start))
 }
+
+func LayerConverted() {
+	layersConverted.Inc()
+}
+
+func BlobPushed(target string, size int64) {
+	blobPushedBytes.WithLabelValues(target).Add(float64(size))
+}
+
+func BuildDuration(command string, start time.Time) {
+	buildDuration.WithLabelValues(command).Observe(sinceInSeconds(start))
+}
+
+// Serve exposes the registered metrics on addr at `/metrics` for scraping
+// in background, the returned server should be closed once it's done.
+func Serve(addr string) (*http.Server, error) {
+	if Registry == nil {
+		return nil, errors.New("metrics are not registered")
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listen on %s", addr)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).Warn("failed to serve metrics")
+		}
+	}()
+	logrus.Infof("serving metrics on http://%s/metrics", listener.Addr())
+	return server, nil
+}
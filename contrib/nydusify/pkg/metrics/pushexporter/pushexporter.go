@@ -0,0 +1,35 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package pushexporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/metrics"
+)
+
+// PushExporter pushes the metrics to Prometheus pushgateway, which is used
+// by the one-shot runs, e.g. batch jobs on ephemeral runners, not living
+// long enough to be scraped.
+type PushExporter struct {
+	url string
+	job string
+}
+
+// New creates the exporter pushing to the pushgateway of url, the metrics
+// are grouped by job, and the group is replaced by each push.
+func New(url, job string) *PushExporter {
+	return &PushExporter{
+		url: url,
+		job: job,
+	}
+}
+
+func (exp *PushExporter) Export() {
+	if err := push.New(exp.url, exp.job).Gatherer(metrics.Registry).Push(); err != nil {
+		logrus.WithError(err).Warnf("failed to push metrics to %s", exp.url)
+	}
+}
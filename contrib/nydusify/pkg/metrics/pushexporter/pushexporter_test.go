@@ -0,0 +1,45 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package pushexporter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/metrics"
+)
+
+func TestPushExporter(t *testing.T) {
+	var mutex sync.Mutex
+	pushes := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		pushes[r.Method+" "+r.URL.Path] = string(body)
+		mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	metrics.Register(New(server.URL, "convert"))
+	metrics.ConversionDuration("docker.io/library/nginx:latest", 3, time.Now())
+	metrics.ConversionSuccessCount("docker.io/library/nginx:latest")
+	metrics.Export()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	body, ok := pushes["PUT /metrics/job/convert"]
+	require.True(t, ok, "pushes: %v", pushes)
+	require.Contains(t, body, "nydusify_convert_convert_success_count_key")
+
+	// The failure of push isn't fatal.
+	New("http://127.0.0.1:0", "convert").Export()
+}
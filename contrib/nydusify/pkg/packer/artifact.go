@@ -40,6 +40,10 @@ func (a Artifact) blobFilePath(imageName string, isDigest bool) string {
 	return filepath.Join(a.OutputDir, imageName+".blob")
 }
 
+func (a Artifact) blobMetaPath(blobID string) string {
+	return filepath.Join(a.OutputDir, blobID+utils.BlobMetaSuffix)
+}
+
 func (a Artifact) outputJSONPath() string {
 	return filepath.Join(a.OutputDir, "output.json")
 }
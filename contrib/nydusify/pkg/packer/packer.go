@@ -2,16 +2,21 @@ package packer
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/containerd/containerd/content/local"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/build"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/compactor"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
 
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -27,6 +32,35 @@ var (
 	ErrNoSupport                = errors.New("invalid chunk-dict type")
 )
 
+// BlobTransfer is the way the blob built by nydus-image is transferred to
+// the backend uploader.
+type BlobTransfer string
+
+const (
+	// BlobTransferFile stages the blob in a file of output directory and
+	// uploads it after build, which needs disk space for the whole blob.
+	BlobTransferFile BlobTransfer = "file"
+	// BlobTransferFifo uploads the blob while nydus-image is writing it
+	// through a fifo, so that nydus-image is blocked by slow uploads.
+	BlobTransferFifo BlobTransfer = "fifo"
+	// BlobTransferMemory drains the fifo into memory, spilled to a temp
+	// file over BlobMemoryLimit, and uploads the blob after build, so that
+	// nydus-image is never blocked by the uploader.
+	BlobTransferMemory BlobTransfer = "memory"
+)
+
+// DefaultBlobMemoryLimit is the default memory limit of BlobTransferMemory.
+const DefaultBlobMemoryLimit = 256 << 20
+
+// ParseBlobTransfer parses the blob transfer specified by user.
+func ParseBlobTransfer(transfer string) (BlobTransfer, error) {
+	switch BlobTransfer(transfer) {
+	case BlobTransferFile, BlobTransferFifo, BlobTransferMemory:
+		return BlobTransfer(transfer), nil
+	}
+	return "", fmt.Errorf("invalid blob transfer %s, possible values: %s, %s, %s", transfer, BlobTransferFile, BlobTransferFifo, BlobTransferMemory)
+}
+
 type Opt struct {
 	LogLevel       logrus.Level
 	NydusImagePath string
@@ -35,7 +69,7 @@ type Opt struct {
 }
 
 type Builder interface {
-	Run(option build.BuilderOption) error
+	RunWithContext(ctx context.Context, option build.BuilderOption) (*build.BuildResult, error)
 }
 
 type Packer struct {
@@ -47,10 +81,6 @@ type Packer struct {
 	Artifact
 }
 
-type BlobManifest struct {
-	Blobs []string `json:"blobs,omitempty"`
-}
-
 type PackRequest struct {
 	SourceDir    string
 	ImageName    string
@@ -58,16 +88,39 @@ type PackRequest struct {
 	Compressor   string
 	ChunkSize    string
 	PushToRemote bool
+	// WithBlobMeta generates the blob meta (TOC) artifact alongside the
+	// blob and pushes it to backend, so that nydusd can lazily verify
+	// chunks against object storage backends.
+	WithBlobMeta bool
+	// Streaming uploads the blob to backend while nydus-image is writing
+	// it through a fifo, it's the same as BlobTransferFifo.
+	Streaming bool
+	// BlobTransfer is the way to transfer blob to backend, default to
+	// BlobTransferFile. It falls back to BlobTransferFile if the backend
+	// doesn't support streaming, or the blob isn't pushed or is needed
+	// locally.
+	BlobTransfer BlobTransfer
+	// BlobMemoryLimit is the memory to buffer blob for BlobTransferMemory,
+	// default to DefaultBlobMemoryLimit.
+	BlobMemoryLimit int64
+	// MinCompressionRatio builds the blob uncompressed if the estimated
+	// compression ratio of SourceDir is under it, 0 disables it.
+	MinCompressionRatio float64
 
 	ChunkDict         string
 	Parent            string
 	TryCompact        bool
 	CompactConfigPath string
+
+	// BuildTimeout kills nydus-image if the image isn't built in time,
+	// zero means no timeout.
+	BuildTimeout time.Duration
 }
 
 type PackResult struct {
-	Meta string
-	Blob string
+	Meta     string
+	Blob     string
+	BlobMeta string
 }
 
 func New(opt Opt) (*Packer, error) {
@@ -115,7 +168,7 @@ func (p *Packer) getBlobsFromBootstrap(bootstrap string) ([]string, error) {
 			return []string{}, err
 		}
 		blobsInfo, _ := item.(tool.BlobInfoList)
-		p.logger.Infof("get blob list from bootstrap '%s': %v", bootstrap, blobsInfo)
+		p.logger.Infof("get %d blobs from bootstrap '%s'", len(blobsInfo), bootstrap)
 		for _, blobInfo := range blobsInfo {
 			blobs = append(blobs, blobInfo.BlobID)
 		}
@@ -140,30 +193,22 @@ func (p *Packer) getChunkDictBlobs(chunkDict string) ([]string, error) {
 	}
 }
 
-// getBlobHash will get blobs hash from output.json, the hash will be
+// getNewBlobsHash will get blobs hash from build result, the hash will be
 // used oss key as blob
 // ignore blobs already exist
-func (p *Packer) getNewBlobsHash(exists []string) (string, error) {
+func getNewBlobsHash(result *build.BuildResult, exists []string) string {
 	// build tmp lookup map
 	m := make(map[string]bool)
 	for _, blob := range exists {
 		m[blob] = true
 	}
-	content, err := os.ReadFile(p.outputJSONPath())
-	if err != nil {
-		return "", err
-	}
-	var manifest BlobManifest
-	if err = json.Unmarshal(content, &manifest); err != nil {
-		return "", err
-	}
-	for _, blob := range manifest.Blobs {
+	for _, blob := range result.Blobs {
 		if _, ok := m[blob]; !ok {
-			return blob, nil
+			return blob
 		}
 	}
 	// return the latest blob hash
-	return "", nil
+	return ""
 }
 
 func (p *Packer) dumpBlobBackendConfig(filePath string) (func(), error) {
@@ -189,7 +234,7 @@ func (p *Packer) dumpBlobBackendConfig(filePath string) (func(), error) {
 	}, nil
 }
 
-func (p *Packer) tryCompactParent(req *PackRequest) error {
+func (p *Packer) tryCompactParent(ctx context.Context, req *PackRequest) error {
 	if !req.TryCompact || req.Parent == "" {
 		return nil
 	}
@@ -209,7 +254,7 @@ func (p *Packer) tryCompactParent(req *PackRequest) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to new compactor")
 	}
-	outputBootstrap, err := c.Compact(req.Parent, req.ChunkDict, p.BackendConfig.backendType(), backendConfigPath)
+	outputBootstrap, err := c.CompactWithContext(ctx, req.Parent, req.ChunkDict, p.BackendConfig.backendType(), backendConfigPath)
 	if err != nil {
 		return errors.Wrap(err, "failed to compact parent")
 	}
@@ -227,9 +272,9 @@ func (p *Packer) tryCompactParent(req *PackRequest) error {
 	return nil
 }
 
-func (p *Packer) Pack(_ context.Context, req PackRequest) (PackResult, error) {
+func (p *Packer) Pack(ctx context.Context, req PackRequest) (PackResult, error) {
 	p.logger.Infof("start to build image from source directory %q", req.SourceDir)
-	if err := p.tryCompactParent(&req); err != nil {
+	if err := p.tryCompactParent(ctx, &req); err != nil {
 		return PackResult{}, err
 	}
 	parentBlobs, err := p.getBlobsFromBootstrap(req.Parent)
@@ -242,7 +287,24 @@ func (p *Packer) Pack(_ context.Context, req PackRequest) (PackResult, error) {
 	}
 	blobPath := p.blobFilePath(req.ImageName, false)
 	bootstrapPath := p.bootstrapPath(req.ImageName)
-	if err = p.builder.Run(build.BuilderOption{
+	var features []string
+	if req.WithBlobMeta {
+		features = append(features, "blob-toc")
+	}
+	var stream *blobStream
+	if streamBackend := p.streamBackend(req); streamBackend != nil {
+		// The blob is buffered in memory only for BlobTransferMemory.
+		bufferLimit := int64(-1)
+		if req.blobTransfer() == BlobTransferMemory {
+			if bufferLimit = req.BlobMemoryLimit; bufferLimit <= 0 {
+				bufferLimit = DefaultBlobMemoryLimit
+			}
+		}
+		if stream, err = startBlobStream(ctx, streamBackend, blobPath, bufferLimit); err != nil {
+			return PackResult{}, errors.Wrap(err, "failed to start streaming blob")
+		}
+	}
+	result, err := p.builder.RunWithContext(ctx, build.BuilderOption{
 		ParentBootstrapPath: req.Parent,
 		ChunkDict:           req.ChunkDict,
 		BootstrapPath:       bootstrapPath,
@@ -251,18 +313,29 @@ func (p *Packer) Pack(_ context.Context, req PackRequest) (PackResult, error) {
 		RootfsPath:          req.SourceDir,
 		WhiteoutSpec:        "oci",
 		Compressor:          req.Compressor,
+		MinCompressionRatio: req.MinCompressionRatio,
 		ChunkSize:           req.ChunkSize,
 		FsVersion:           req.FsVersion,
-	}); err != nil {
+		Features:            features,
+		Timeout:             req.BuildTimeout,
+	})
+	if err != nil {
+		if stream != nil {
+			stream.finish(ctx, "")
+		}
 		return PackResult{}, errors.Wrapf(err, "failed to build image from directory %s", req.SourceDir)
 	}
-	newBlobHash, err := p.getNewBlobsHash(append(parentBlobs, chunkDictBlobs...))
-	if err != nil {
-		return PackResult{}, errors.Wrap(err, "failed to get hash value of Nydus blob")
+	newBlobHash := getNewBlobsHash(result, append(parentBlobs, chunkDictBlobs...))
+	var streamedBlob *ocispec.Descriptor
+	if stream != nil {
+		if streamedBlob, err = stream.finish(ctx, newBlobHash); err != nil {
+			return PackResult{}, errors.Wrap(err, "failed to stream blob")
+		}
 	}
+	blobMetaPath := ""
 	if newBlobHash == "" {
 		blobPath = ""
-	} else {
+	} else if stream == nil {
 		if req.Parent != "" || req.PushToRemote {
 			p.logger.Infof("rename blob file into sha256 csum")
 			newBlobName := p.blobFilePath(newBlobHash, true)
@@ -271,12 +344,19 @@ func (p *Packer) Pack(_ context.Context, req PackRequest) (PackResult, error) {
 			}
 			blobPath = newBlobName
 		}
+		if req.WithBlobMeta {
+			blobMetaPath = p.blobMetaPath(newBlobHash)
+			if err := p.dumpBlobMeta(blobPath, blobMetaPath); err != nil {
+				return PackResult{}, errors.Wrap(err, "failed to dump blob meta")
+			}
+		}
 	}
 	if !req.PushToRemote {
 		// if we don't need to push meta and blob to remote, just return the local build artifact
 		return PackResult{
-			Meta: bootstrapPath,
-			Blob: blobPath,
+			Meta:     bootstrapPath,
+			Blob:     blobPath,
+			BlobMeta: blobMetaPath,
 		}, nil
 	}
 
@@ -285,19 +365,73 @@ func (p *Packer) Pack(_ context.Context, req PackRequest) (PackResult, error) {
 		return PackResult{}, errors.New("can not push image to remote due to lack of backend configuration")
 	}
 	pushResult, err := p.pusher.Push(PushRequest{
-		Meta:        req.ImageName,
-		Blob:        newBlobHash,
-		ParentBlobs: parentBlobs,
+		Meta:         req.ImageName,
+		Blob:         newBlobHash,
+		BlobMeta:     blobMetaPath != "",
+		ParentBlobs:  parentBlobs,
+		StreamedBlob: streamedBlob,
 	})
 	if err != nil {
 		return PackResult{}, errors.Wrap(err, "failed to push pack result to remote")
 	}
 	return PackResult{
-		Meta: pushResult.RemoteMeta,
-		Blob: pushResult.RemoteBlob,
+		Meta:     pushResult.RemoteMeta,
+		Blob:     pushResult.RemoteBlob,
+		BlobMeta: pushResult.RemoteBlobMeta,
 	}, nil
 }
 
+func (req *PackRequest) blobTransfer() BlobTransfer {
+	if req.BlobTransfer == "" && req.Streaming {
+		return BlobTransferFifo
+	}
+	return req.BlobTransfer
+}
+
+// streamBackend returns the blob backend to stream the blob of req, nil is
+// returned if the blob is transferred by file.
+func (p *Packer) streamBackend(req PackRequest) backend.StreamBackend {
+	transfer := req.blobTransfer()
+	if transfer == "" || transfer == BlobTransferFile {
+		return nil
+	}
+	var reason string
+	switch {
+	case !req.PushToRemote || p.pusher == nil:
+		reason = "blob isn't pushed to backend"
+	case req.WithBlobMeta:
+		reason = "blob meta is extracted from local blob"
+	default:
+		if streamBackend, ok := p.pusher.blobBackend.(backend.StreamBackend); ok {
+			return streamBackend
+		}
+		reason = "backend requires blob of known size"
+	}
+	p.logger.Warnf("transfer blob by file instead of %s as %s", transfer, reason)
+	return nil
+}
+
+// dumpBlobMeta extracts the blob meta entry from the TOC of nydus blob.
+func (p *Packer) dumpBlobMeta(blobPath, blobMetaPath string) error {
+	ra, err := local.OpenReader(blobPath)
+	if err != nil {
+		return errors.Wrapf(err, "open blob %s", blobPath)
+	}
+	defer ra.Close()
+
+	file, err := os.Create(blobMetaPath)
+	if err != nil {
+		return errors.Wrapf(err, "create blob meta %s", blobMetaPath)
+	}
+	defer file.Close()
+
+	if err := utils.UnpackEntry(ra, utils.EntryBlobMeta, file); err != nil {
+		return errors.Wrap(err, "unpack blob meta from blob")
+	}
+
+	return nil
+}
+
 // ensureNydusImagePath ensure nydus-image binary exists, the Precedence for nydus-image is as follow
 // 1. if nydusImagePath is specified try nydusImagePath first
 // 2. if nydusImagePath not exists, try to find nydus-image from $PATH
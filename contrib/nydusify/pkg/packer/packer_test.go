@@ -7,13 +7,16 @@ package packer
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"testing"
 
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/build"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/mock"
@@ -24,9 +27,23 @@ type mockBuilder struct {
 	mock.Mock
 }
 
-func (m *mockBuilder) Run(option build.BuilderOption) error {
-	args := m.Called(option)
-	return args.Error(0)
+func (m *mockBuilder) RunWithContext(ctx context.Context, option build.BuilderOption) (*build.BuildResult, error) {
+	args := m.Called(ctx, option)
+	result, _ := args.Get(0).(*build.BuildResult)
+	return result, args.Error(1)
+}
+
+// blobWriter writes the blob as nydus-image.
+type blobWriter struct {
+	data  []byte
+	blobs []string
+}
+
+func (w *blobWriter) RunWithContext(_ context.Context, option build.BuilderOption) (*build.BuildResult, error) {
+	if err := os.WriteFile(option.BlobPath, w.data, 0644); err != nil {
+		return nil, err
+	}
+	return &build.BuildResult{Output: build.Output{Blobs: w.blobs}}, nil
 }
 
 func TestNew(t *testing.T) {
@@ -135,7 +152,9 @@ func TestPack(t *testing.T) {
 
 	builder := &mockBuilder{}
 	p.builder = builder
-	builder.On("Run", mock.Anything).Return(nil)
+	builder.On("RunWithContext", mock.Anything, mock.Anything).Return(&build.BuildResult{
+		Output: build.Output{Blobs: []string{"3093776c78a21e47f0a8b4c80a1f019b1e838fc1ade274209332af1ca5f57090"}},
+	}, nil)
 	res, err := p.Pack(context.Background(), PackRequest{
 		SourceDir:    tmpDir,
 		ImageName:    "test.meta",
@@ -149,7 +168,7 @@ func TestPack(t *testing.T) {
 
 	errBuilder := &mockBuilder{}
 	p.builder = errBuilder
-	errBuilder.On("Run", mock.Anything).Return(errors.New("test"))
+	errBuilder.On("RunWithContext", mock.Anything, mock.Anything).Return(nil, errors.New("test"))
 	res, err = p.Pack(context.Background(), PackRequest{
 		SourceDir:    tmpDir,
 		ImageName:    "test.meta",
@@ -207,16 +226,144 @@ func TestPack(t *testing.T) {
 	}, res)
 }
 
-func TestPusher_getBlobHash(t *testing.T) {
-	artifact, err := NewArtifact("testdata")
+func TestPackStreaming(t *testing.T) {
+	tmpDir, tearDown := setUpTmpDir(t)
+	defer tearDown()
+	p, err := New(Opt{
+		LogLevel:       logrus.InfoLevel,
+		OutputDir:      tmpDir,
+		NydusImagePath: filepath.Join(tmpDir, "nydus-image"),
+	})
 	require.NoError(t, err)
-	pusher := Packer{
-		Artifact: artifact,
-		logger:   logrus.New(),
+	os.Create(filepath.Join(tmpDir, "test.meta"))
+
+	blobDir := t.TempDir()
+	blobBackend, err := backend.NewBackend("localfs", []byte(fmt.Sprintf(`{"dir": %q}`, blobDir)), nil)
+	require.NoError(t, err)
+	mp := &mockBackend{}
+	p.pusher = &Pusher{
+		Artifact:    p.Artifact,
+		cfg:         &OssBackendConfig{},
+		logger:      logrus.New(),
+		metaBackend: mp,
+		blobBackend: blobBackend,
+	}
+	mp.On("Upload", mock.Anything, "test.meta", mock.Anything, mock.Anything, mock.Anything).Return(&ocispec.Descriptor{
+		URLs: []string{"oss://testbucket/testmetaprefix/test.meta"},
+	}, nil)
+
+	data := []byte("streamed blob")
+	blobID := digest.FromBytes(data).Encoded()
+	pack := func(blobs ...string) (PackResult, error) {
+		p.builder = &blobWriter{data: data, blobs: blobs}
+		return p.Pack(context.Background(), PackRequest{
+			SourceDir:    tmpDir,
+			ImageName:    "test.meta",
+			PushToRemote: true,
+			Streaming:    true,
+		})
+	}
+
+	// The blob is uploaded while it's written to fifo.
+	res, err := pack(blobID)
+	require.NoError(t, err)
+	require.Equal(t, "oss://testbucket/testmetaprefix/test.meta", res.Meta)
+	stored, err := os.ReadFile(filepath.Join(blobDir, blobID))
+	require.NoError(t, err)
+	require.Equal(t, data, stored)
+	_, err = os.Stat(filepath.Join(tmpDir, "test.blob"))
+	require.True(t, os.IsNotExist(err))
+
+	// The streamed blob mismatching the build output is discarded.
+	_, err = pack(digest.FromString("other").Encoded())
+	require.ErrorContains(t, err, "mismatches blob")
+	entries, err := os.ReadDir(blobDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestPackBlobTransfer(t *testing.T) {
+	tmpDir, tearDown := setUpTmpDir(t)
+	defer tearDown()
+	p, err := New(Opt{
+		LogLevel:       logrus.InfoLevel,
+		OutputDir:      tmpDir,
+		NydusImagePath: filepath.Join(tmpDir, "nydus-image"),
+	})
+	require.NoError(t, err)
+	os.Create(filepath.Join(tmpDir, "test.meta"))
+
+	blobDir := t.TempDir()
+	blobBackend, err := backend.NewBackend("localfs", []byte(fmt.Sprintf(`{"dir": %q}`, blobDir)), nil)
+	require.NoError(t, err)
+	mp := &mockBackend{}
+	p.pusher = &Pusher{
+		Artifact:    p.Artifact,
+		cfg:         &OssBackendConfig{},
+		logger:      logrus.New(),
+		metaBackend: mp,
+		blobBackend: blobBackend,
+	}
+	mp.On("Upload", mock.Anything, "test.meta", mock.Anything, mock.Anything, mock.Anything).Return(&ocispec.Descriptor{
+		URLs: []string{"oss://testbucket/testmetaprefix/test.meta"},
+	}, nil)
+
+	for _, tc := range []struct {
+		transfer string
+		limit    int64
+	}{
+		{"memory", 0},
+		// The blob exceeding limit is spilled to temp file.
+		{"memory", 4},
+		{"fifo", 0},
+	} {
+		transfer, err := ParseBlobTransfer(tc.transfer)
+		require.NoError(t, err)
+		data := []byte(fmt.Sprintf("blob transferred by %s with limit %d", tc.transfer, tc.limit))
+		blobID := digest.FromBytes(data).Encoded()
+		p.builder = &blobWriter{data: data, blobs: []string{blobID}}
+		_, err = p.Pack(context.Background(), PackRequest{
+			SourceDir:       tmpDir,
+			ImageName:       "test.meta",
+			PushToRemote:    true,
+			BlobTransfer:    transfer,
+			BlobMemoryLimit: tc.limit,
+		})
+		require.NoError(t, err)
+		stored, err := os.ReadFile(filepath.Join(blobDir, blobID))
+		require.NoError(t, err)
+		require.Equal(t, data, stored)
+
+		// Neither the blob file nor the spill file is left.
+		entries, err := os.ReadDir(tmpDir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			require.NotContains(t, entry.Name(), "blob")
+		}
 	}
-	hash, err := pusher.getNewBlobsHash(nil)
+
+	// The buffered blob mismatching the build output isn't uploaded.
+	p.builder = &blobWriter{data: []byte("buffered blob"), blobs: []string{digest.FromString("other").Encoded()}}
+	_, err = p.Pack(context.Background(), PackRequest{
+		SourceDir:    tmpDir,
+		ImageName:    "test.meta",
+		PushToRemote: true,
+		BlobTransfer: BlobTransferMemory,
+	})
+	require.ErrorContains(t, err, "mismatches blob")
+	entries, err := os.ReadDir(blobDir)
 	require.NoError(t, err)
-	require.Equal(t, "3093776c78a21e47f0a8b4c80a1f019b1e838fc1ade274209332af1ca5f57090", hash)
+	require.Len(t, entries, 3)
+
+	_, err = ParseBlobTransfer("pipe")
+	require.ErrorContains(t, err, "invalid blob transfer pipe, possible values: file, fifo, memory")
+}
+
+func TestGetNewBlobsHash(t *testing.T) {
+	result := &build.BuildResult{Output: build.Output{Blobs: []string{"parent", "3093776c78a21e47f0a8b4c80a1f019b1e838fc1ade274209332af1ca5f57090"}}}
+	require.Equal(t, "parent", getNewBlobsHash(result, nil))
+	require.Equal(t, "3093776c78a21e47f0a8b4c80a1f019b1e838fc1ade274209332af1ca5f57090", getNewBlobsHash(result, []string{"parent"}))
+	require.Empty(t, getNewBlobsHash(result, result.Blobs))
 }
 
 func setUpTmpDir(t *testing.T) (string, func()) {
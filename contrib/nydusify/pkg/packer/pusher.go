@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
@@ -25,13 +26,19 @@ type Pusher struct {
 type PushRequest struct {
 	Meta string
 	Blob string
+	// BlobMeta pushes the blob meta artifact of Blob if it's true.
+	BlobMeta bool
 
 	ParentBlobs []string
+	// StreamedBlob is the descriptor of Blob already streamed to backend
+	// during build, the blob isn't uploaded again if it's specified.
+	StreamedBlob *ocispec.Descriptor
 }
 
 type PushResult struct {
-	RemoteMeta string
-	RemoteBlob string
+	RemoteMeta     string
+	RemoteBlob     string
+	RemoteBlobMeta string
 }
 
 type NewPusherOpt struct {
@@ -89,26 +96,41 @@ func (p *Pusher) Push(req PushRequest) (pushResult PushResult, retErr error) {
 
 	for _, blob := range req.ParentBlobs {
 		// try push parent blobs
-		if _, err := p.blobBackend.Upload(ctx, blob, p.blobFilePath(blob, true), 0, false); err != nil {
+		if _, err := backend.Upload(ctx, p.blobBackend, blob, p.blobFilePath(blob, true), 0, false); err != nil {
 			return PushResult{}, errors.Wrap(err, "failed to put blobfile to remote")
 		}
 	}
 
 	p.logger.Infof("push blob %s", req.Blob)
-	if req.Blob != "" {
-		desc, err := p.blobBackend.Upload(ctx, req.Blob, p.blobFilePath(req.Blob, true), 0, false)
+	if req.StreamedBlob != nil {
+		if len(req.StreamedBlob.URLs) > 0 {
+			pushResult.RemoteBlob = req.StreamedBlob.URLs[0]
+		}
+	} else if req.Blob != "" {
+		desc, err := backend.Upload(ctx, p.blobBackend, req.Blob, p.blobFilePath(req.Blob, true), 0, false)
 		if err != nil {
 			return PushResult{}, errors.Wrap(err, "failed to put blobfile to remote")
 		}
 		if len(desc.URLs) > 0 {
 			pushResult.RemoteBlob = desc.URLs[0]
 		}
+		if req.BlobMeta {
+			blobMetaID := req.Blob + utils.BlobMetaSuffix
+			p.logger.Infof("push blob meta %s", blobMetaID)
+			desc, err := backend.Upload(ctx, p.blobBackend, blobMetaID, p.blobMetaPath(req.Blob), 0, false)
+			if err != nil {
+				return PushResult{}, errors.Wrap(err, "failed to put blob meta file to remote")
+			}
+			if len(desc.URLs) > 0 {
+				pushResult.RemoteBlobMeta = desc.URLs[0]
+			}
+		}
 	}
 	if retErr = p.blobBackend.Finalize(false); retErr != nil {
 		return PushResult{}, errors.Wrap(retErr, "Finalize blob backend upload")
 	}
 
-	desc, retErr := p.metaBackend.Upload(ctx, req.Meta, p.bootstrapPath(req.Meta), 0, true)
+	desc, retErr := backend.Upload(ctx, p.metaBackend, req.Meta, p.bootstrapPath(req.Meta), 0, true)
 	if retErr != nil {
 		return PushResult{}, errors.Wrapf(retErr, "failed to put metafile to remote")
 	}
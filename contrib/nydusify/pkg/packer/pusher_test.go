@@ -44,6 +44,10 @@ func (m *mockBackend) Reader(_ string) (io.ReadCloser, error) {
 	panic("not implemented")
 }
 
+func (m *mockBackend) RangeReader(_ string, _, _ int64) (io.ReadCloser, error) {
+	panic("not implemented")
+}
+
 func (m *mockBackend) Size(_ string) (int64, error) {
 	panic("not implemented")
 }
@@ -0,0 +1,224 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/metrics"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/progress"
+)
+
+// blobStream uploads the blob to backend while nydus-image is writing it to
+// fifo, so that the blob isn't staged on local disk. If buffer is set, the
+// fifo is drained into buffer and the blob is uploaded after nydus-image
+// exits instead.
+type blobStream struct {
+	backend  backend.StreamBackend
+	fifoPath string
+	// key is the temporary object of stream, the blob ID is only known
+	// after nydus-image exits.
+	key string
+	// keeper is the write end of fifo held until nydus-image exits, so
+	// that the upload doesn't see EOF if nydus-image hasn't opened the
+	// fifo or doesn't write blob at all.
+	keeper   *os.File
+	buffer   *spillBuffer
+	digester digest.Digester
+	size     int64
+	done     chan error
+	// uploaded is whether the temporary object may exist in backend.
+	uploaded bool
+}
+
+// countWriter counts the bytes written.
+type countWriter struct {
+	size *int64
+}
+
+func (w countWriter) Write(p []byte) (int, error) {
+	*w.size += int64(len(p))
+	return len(p), nil
+}
+
+// spillBuffer keeps the data in memory up to limit, and spills the rest to
+// a temp file in dir.
+type spillBuffer struct {
+	limit  int64
+	dir    string
+	memory bytes.Buffer
+	file   *os.File
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.file == nil {
+		if int64(b.memory.Len()+len(p)) <= b.limit {
+			return b.memory.Write(p)
+		}
+		file, err := os.CreateTemp(b.dir, ".blob-spill-*")
+		if err != nil {
+			return 0, errors.Wrap(err, "create spill file")
+		}
+		logrus.Debugf("spill blob exceeding %d bytes memory to %s", b.limit, file.Name())
+		b.file = file
+	}
+	return b.file.Write(p)
+}
+
+// reader returns the reader of all data written.
+func (b *spillBuffer) reader() (io.Reader, error) {
+	memory := bytes.NewReader(b.memory.Bytes())
+	if b.file == nil {
+		return memory, nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "seek spill file")
+	}
+	return io.MultiReader(memory, b.file), nil
+}
+
+func (b *spillBuffer) close() {
+	if b.file != nil {
+		b.file.Close()
+		os.Remove(b.file.Name())
+	}
+}
+
+// startBlobStream starts reading the blob from fifo of fifoPath, it's
+// buffered up to bufferLimit bytes in memory if bufferLimit isn't negative.
+func startBlobStream(ctx context.Context, bkd backend.StreamBackend, fifoPath string, bufferLimit int64) (*blobStream, error) {
+	if err := os.Remove(fifoPath); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "remove blob file %s", fifoPath)
+	}
+	if err := mkfifo(fifoPath); err != nil {
+		return nil, errors.Wrapf(err, "create fifo %s", fifoPath)
+	}
+	// Opening fifo for reading blocks until a writer opens it, so it's
+	// opened without blocking before the keeper.
+	reader, err := os.OpenFile(fifoPath, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		os.Remove(fifoPath)
+		return nil, errors.Wrapf(err, "open fifo %s", fifoPath)
+	}
+	keeper, err := os.OpenFile(fifoPath, os.O_WRONLY, 0)
+	if err != nil {
+		reader.Close()
+		os.Remove(fifoPath)
+		return nil, errors.Wrapf(err, "open fifo %s", fifoPath)
+	}
+
+	stream := &blobStream{
+		backend:  bkd,
+		fifoPath: fifoPath,
+		key:      ".stream-" + uuid.NewString(),
+		keeper:   keeper,
+		digester: digest.SHA256.Digester(),
+		done:     make(chan error, 1),
+	}
+	if bufferLimit >= 0 {
+		stream.buffer = &spillBuffer{limit: bufferLimit, dir: filepath.Dir(fifoPath)}
+	}
+	stream.uploaded = stream.buffer == nil
+	go func() {
+		defer reader.Close()
+		tee := io.TeeReader(reader, io.MultiWriter(stream.digester.Hash(), countWriter{size: &stream.size}))
+		if stream.buffer != nil {
+			_, err := io.Copy(stream.buffer, tee)
+			if err != nil {
+				io.Copy(io.Discard, reader)
+			}
+			stream.done <- errors.Wrap(err, "buffer blob")
+			return
+		}
+		// The size of blob is unknown until nydus-image exits.
+		task := progress.Start(stream.key, progress.PhaseUploading, 0)
+		err := bkd.UploadStream(ctx, stream.key, task.Reader(tee))
+		task.Done(err)
+		if err != nil {
+			// Drain the fifo, otherwise nydus-image is blocked on
+			// writing forever.
+			io.Copy(io.Discard, reader)
+		} else {
+			metrics.BlobPushed(backend.TypeName(bkd.Type()), stream.size)
+		}
+		stream.done <- err
+	}()
+
+	return stream, nil
+}
+
+// finish waits for the upload after nydus-image exits, and commits the
+// uploaded stream as the blob of blobID if it matches the digest of stream.
+// The stream is aborted if blobID is empty, i.e. no blob is built or the
+// build fails.
+func (stream *blobStream) finish(ctx context.Context, blobID string) (*ocispec.Descriptor, error) {
+	defer os.Remove(stream.fifoPath)
+	if stream.buffer != nil {
+		defer stream.buffer.close()
+	}
+	stream.keeper.Close()
+	if err := <-stream.done; err != nil {
+		stream.abort(ctx)
+		return nil, errors.Wrap(err, "stream blob to backend")
+	}
+	if blobID == "" {
+		stream.abort(ctx)
+		return nil, nil
+	}
+	if streamed := stream.digester.Digest().Encoded(); streamed != blobID {
+		stream.abort(ctx)
+		return nil, errors.Errorf("digest %s of streamed blob mismatches blob %s in build output", streamed, blobID)
+	}
+	if stream.buffer != nil {
+		if err := stream.upload(ctx); err != nil {
+			stream.abort(ctx)
+			return nil, errors.Wrap(err, "upload buffered blob to backend")
+		}
+	}
+	desc, err := stream.backend.CommitStream(ctx, stream.key, blobID, stream.size)
+	if err != nil {
+		stream.abort(ctx)
+		return nil, errors.Wrapf(err, "commit streamed blob %s", blobID)
+	}
+	return desc, nil
+}
+
+// upload uploads the buffered blob after nydus-image exits.
+func (stream *blobStream) upload(ctx context.Context) error {
+	reader, err := stream.buffer.reader()
+	if err != nil {
+		return err
+	}
+	stream.uploaded = true
+	task := progress.Start(stream.key, progress.PhaseUploading, stream.size)
+	err = stream.backend.UploadStream(ctx, stream.key, task.Reader(reader))
+	task.Done(err)
+	if err != nil {
+		return err
+	}
+	metrics.BlobPushed(backend.TypeName(stream.backend.Type()), stream.size)
+	return nil
+}
+
+func (stream *blobStream) abort(ctx context.Context) {
+	if !stream.uploaded {
+		return
+	}
+	if err := stream.backend.AbortStream(ctx, stream.key); err != nil {
+		logrus.WithError(err).Warnf("abort streamed blob %s", stream.key)
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package packer
+
+import "github.com/pkg/errors"
+
+// mkfifo fails as the blob is streamed to backend through a named pipe of
+// Unix.
+func mkfifo(_ string) error {
+	return errors.New("streaming blob to backend is not supported on Windows")
+}
@@ -59,14 +59,25 @@ func New(remote *remote.Remote, interestedArch string) (*Parser, error) {
 	}, nil
 }
 
+// isBootstrapMediaType checks if the media type is a tar layer, the
+// bootstrap layer may be compressed with gzip or zstd, or uncompressed.
+func isBootstrapMediaType(mediaType string) bool {
+	switch mediaType {
+	case ocispec.MediaTypeImageLayerGzip, images.MediaTypeDockerSchema2LayerGzip,
+		ocispec.MediaTypeImageLayerZstd,
+		ocispec.MediaTypeImageLayer, images.MediaTypeDockerSchema2Layer:
+		return true
+	}
+	return false
+}
+
 // Try to find the topmost layer in Nydus manifest, it should
 // be a Nydus bootstrap layer, see examples/manifest/manifest.json
 func FindNydusBootstrapDesc(manifest *ocispec.Manifest) *ocispec.Descriptor {
 	layers := manifest.Layers
 	if len(layers) != 0 {
 		desc := &layers[len(layers)-1]
-		if (desc.MediaType == ocispec.MediaTypeImageLayerGzip ||
-			desc.MediaType == images.MediaTypeDockerSchema2LayerGzip) &&
+		if isBootstrapMediaType(desc.MediaType) &&
 			desc.Annotations[utils.LayerAnnotationNydusBootstrap] == "true" {
 			return desc
 		}
@@ -209,6 +220,9 @@ func (parser *Parser) Parse(ctx context.Context) (*Parsed, error) {
 		if err != nil {
 			return nil, err
 		}
+		if artifactType := utils.ArtifactType(onlyManifest); artifactType != "" {
+			return nil, &utils.ArtifactError{Ref: parser.Remote.Ref, ArtifactType: artifactType}
+		}
 
 		bootstrapDesc := FindNydusBootstrapDesc(onlyManifest)
 		if bootstrapDesc != nil {
@@ -231,6 +245,14 @@ func (parser *Parser) Parse(ctx context.Context) (*Parsed, error) {
 
 		for idx := range index.Manifests {
 			desc := index.Manifests[idx]
+			if desc.ArtifactType != "" {
+				// Skip the artifacts attached to image, like signatures.
+				continue
+			}
+			if desc.Annotations[utils.ManifestNydusCompatImage] != "" {
+				// Skip the compatible image built with another RAFS version.
+				continue
+			}
 			if desc.Platform != nil {
 				// Currently, parser only finds one interested image.
 				if parser.matchImagePlatform(&desc) {
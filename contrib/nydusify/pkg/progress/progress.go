@@ -0,0 +1,148 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package progress reports the progress of the long running tasks in
+// conversion, i.e. pulling source layers, building nydus layers and
+// uploading blobs, as progress bars on terminal or line-delimited JSON
+// events. The reporting is disabled until a sink is set.
+package progress
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Phase string
+
+const (
+	PhasePulling   Phase = "pulling"
+	PhaseBuilding  Phase = "building"
+	PhaseUploading Phase = "uploading"
+)
+
+// Event is the progress of a task.
+type Event struct {
+	Time time.Time `json:"time"`
+	// ID identifies the task in phase, e.g. the digest of layer.
+	ID      string `json:"id"`
+	Phase   Phase  `json:"phase"`
+	Current int64  `json:"current"`
+	// Total is the total bytes of task, 0 if unknown.
+	Total int64 `json:"total,omitempty"`
+	// Done is true once the task finishes, Error is set if it fails.
+	Done  bool   `json:"done,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Sink receives the events of tasks, it must be safe for concurrent use.
+type Sink interface {
+	Handle(Event)
+	Close() error
+}
+
+var (
+	sinkLock sync.RWMutex
+	sink     Sink
+)
+
+// SetSink reports the progress to s, nil disables reporting. The previous
+// sink is returned.
+func SetSink(s Sink) Sink {
+	sinkLock.Lock()
+	defer sinkLock.Unlock()
+	prev := sink
+	sink = s
+	return prev
+}
+
+func currentSink() Sink {
+	sinkLock.RLock()
+	defer sinkLock.RUnlock()
+	return sink
+}
+
+// Task is a tracked task, the methods of nil task are no-op, so that the
+// callers don't check whether the reporting is enabled.
+type Task struct {
+	sink    Sink
+	id      string
+	phase   Phase
+	total   int64
+	current int64
+	done    int32
+}
+
+// Start starts tracking the task id in phase with total bytes, 0 if
+// unknown. It returns nil if the reporting is disabled.
+func Start(id string, phase Phase, total int64) *Task {
+	s := currentSink()
+	if s == nil {
+		return nil
+	}
+	task := &Task{sink: s, id: id, phase: phase, total: total}
+	task.emit(false, nil)
+	return task
+}
+
+func (task *Task) emit(done bool, err error) {
+	event := Event{
+		Time:    time.Now(),
+		ID:      task.id,
+		Phase:   task.phase,
+		Current: atomic.LoadInt64(&task.current),
+		Total:   task.total,
+		Done:    done,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	task.sink.Handle(event)
+}
+
+// Add adds n bytes to the progress.
+func (task *Task) Add(n int64) {
+	if task == nil || n == 0 || atomic.LoadInt32(&task.done) != 0 {
+		return
+	}
+	atomic.AddInt64(&task.current, n)
+	task.emit(false, nil)
+}
+
+// Current returns the bytes done.
+func (task *Task) Current() int64 {
+	if task == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&task.current)
+}
+
+// Done finishes the task, it fails if err isn't nil. Only the first call
+// takes effect.
+func (task *Task) Done(err error) {
+	if task == nil || !atomic.CompareAndSwapInt32(&task.done, 0, 1) {
+		return
+	}
+	task.emit(true, err)
+}
+
+// Reader counts the bytes read from reader into the progress.
+func (task *Task) Reader(reader io.Reader) io.Reader {
+	if task == nil {
+		return reader
+	}
+	return &taskReader{reader: reader, task: task}
+}
+
+type taskReader struct {
+	reader io.Reader
+	task   *Task
+}
+
+func (r *taskReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.task.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package progress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNilTask(t *testing.T) {
+	SetSink(nil)
+	task := Start("sha256:aaa", PhasePulling, 10)
+	require.Nil(t, task)
+	task.Add(1)
+	task.Done(nil)
+	require.Equal(t, int64(0), task.Current())
+	reader := strings.NewReader("data")
+	require.Equal(t, io.Reader(reader), task.Reader(reader))
+}
+
+func TestJSONSink(t *testing.T) {
+	defer func(interval time.Duration) {
+		Interval = interval
+	}(Interval)
+	Interval = time.Hour
+
+	var buf bytes.Buffer
+	prev := SetSink(NewJSONSink(&buf))
+	defer SetSink(prev)
+
+	pull := Start("sha256:aaa", PhasePulling, 8)
+	data, err := io.ReadAll(pull.Reader(strings.NewReader("abcd")))
+	require.NoError(t, err)
+	require.Equal(t, "abcd", string(data))
+	pull.Add(4)
+	require.Equal(t, int64(8), pull.Current())
+	pull.Done(nil)
+	// Only the first call of Done takes effect.
+	pull.Done(errors.New("failed"))
+	pull.Add(1)
+
+	build := Start("sha256:aaa", PhaseBuilding, 0)
+	build.Done(errors.New("failed"))
+
+	events := []Event{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		event.Time = time.Time{}
+		events = append(events, event)
+	}
+	// The progress between start and end of task is throttled.
+	require.Equal(t, []Event{
+		{ID: "sha256:aaa", Phase: PhasePulling, Total: 8},
+		{ID: "sha256:aaa", Phase: PhasePulling, Current: 8, Total: 8, Done: true},
+		{ID: "sha256:aaa", Phase: PhaseBuilding},
+		{ID: "sha256:aaa", Phase: PhaseBuilding, Done: true, Error: "failed"},
+	}, events)
+}
+
+func TestBarSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewBarSink(&buf)
+	prev := SetSink(sink)
+	defer SetSink(prev)
+
+	task := Start("sha256:0123456789abcdef", PhaseUploading, 4)
+	task.Add(2)
+	_, err := sink.(io.Writer).Write([]byte("log\n"))
+	require.NoError(t, err)
+	task.Done(nil)
+	require.NoError(t, sink.Close())
+
+	output := buf.String()
+	require.Contains(t, output, "uploading 0123456789ab [===============>              ]  50.0% 2 B/4 B\n")
+	require.Contains(t, output, "log\n")
+	require.Contains(t, output, "uploading 0123456789ab done 2 B\n")
+}
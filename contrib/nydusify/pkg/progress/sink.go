@@ -0,0 +1,198 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/term"
+)
+
+// Interval throttles the events of a task written by JSON sink, and the
+// redraw of progress bars.
+var Interval = time.Second
+
+type taskKey struct {
+	id    string
+	phase Phase
+}
+
+// jsonSink writes the events as line-delimited JSON, the events of a task
+// between its start and end are written at most once per Interval.
+type jsonSink struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	last    map[taskKey]time.Time
+}
+
+// NewJSONSink creates the sink writing line-delimited JSON events to out,
+// for CI systems to consume.
+func NewJSONSink(out io.Writer) Sink {
+	return &jsonSink{
+		encoder: json.NewEncoder(out),
+		last:    map[taskKey]time.Time{},
+	}
+}
+
+func (s *jsonSink) Handle(event Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := taskKey{event.ID, event.Phase}
+	last, started := s.last[key]
+	if started && !event.Done && event.Time.Sub(last) < Interval {
+		return
+	}
+	if event.Done {
+		delete(s.last, key)
+	} else {
+		s.last[key] = event.Time
+	}
+	s.encoder.Encode(event)
+}
+
+func (s *jsonSink) Close() error {
+	return nil
+}
+
+// barSink draws a progress bar for each running task at the bottom of
+// terminal, the finished tasks are printed above the bars. The logs must be
+// written through the sink, see Writer, so that they don't break the bars.
+type barSink struct {
+	mutex sync.Mutex
+	out   io.Writer
+	tasks []*Event
+	// lines is the count of bar lines drawn.
+	lines int
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// barWidth is the width of progress bar in characters.
+const barWidth = 30
+
+// NewBarSink creates the sink drawing progress bars on terminal out.
+func NewBarSink(out io.Writer) Sink {
+	s := &barSink{out: out, stop: make(chan struct{})}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(Interval / 5)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.mutex.Lock()
+				s.redraw()
+				s.mutex.Unlock()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// IsTerminal returns true if file is a terminal.
+func IsTerminal(file *os.File) bool {
+	return term.IsTerminal(int(file.Fd()))
+}
+
+// shortID shortens the digest for display.
+func shortID(id string) string {
+	if idx := strings.Index(id, ":"); idx >= 0 && len(id) > idx+13 {
+		return id[idx+1 : idx+13]
+	}
+	return id
+}
+
+func formatEvent(event *Event) string {
+	line := fmt.Sprintf("%-9s %-12s ", event.Phase, shortID(event.ID))
+	current := humanize.IBytes(uint64(event.Current))
+	switch {
+	case event.Error != "":
+		return line + "failed: " + event.Error
+	case event.Done:
+		return line + "done " + current
+	case event.Total > 0:
+		ratio := float64(event.Current) / float64(event.Total)
+		if ratio > 1 {
+			ratio = 1
+		}
+		filled := int(ratio * barWidth)
+		bar := strings.Repeat("=", filled)
+		if filled < barWidth {
+			bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+		}
+		return line + fmt.Sprintf("[%s] %5.1f%% %s/%s", bar, ratio*100, current, humanize.IBytes(uint64(event.Total)))
+	default:
+		return line + current
+	}
+}
+
+// clear erases the bars drawn, the cursor is left at the first bar line.
+func (s *barSink) clear() {
+	if s.lines > 0 {
+		fmt.Fprintf(s.out, "\x1b[%dA\x1b[J", s.lines)
+		s.lines = 0
+	}
+}
+
+func (s *barSink) redraw() {
+	s.clear()
+	for _, event := range s.tasks {
+		fmt.Fprintln(s.out, formatEvent(event))
+	}
+	s.lines = len(s.tasks)
+}
+
+func (s *barSink) Handle(event Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := taskKey{event.ID, event.Phase}
+	for idx, task := range s.tasks {
+		if (taskKey{task.ID, task.Phase}) != key {
+			continue
+		}
+		if !event.Done {
+			*task = event
+			return
+		}
+		s.tasks = append(s.tasks[:idx], s.tasks[idx+1:]...)
+		break
+	}
+	if !event.Done {
+		s.tasks = append(s.tasks, &event)
+		return
+	}
+	s.clear()
+	fmt.Fprintln(s.out, formatEvent(&event))
+	s.redraw()
+}
+
+// Write writes the log p above the bars.
+func (s *barSink) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clear()
+	n, err := s.out.Write(p)
+	s.redraw()
+	return n, err
+}
+
+func (s *barSink) Close() error {
+	close(s.stop)
+	s.wg.Wait()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clear()
+	return nil
+}
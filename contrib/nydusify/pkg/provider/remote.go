@@ -19,11 +19,12 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
 )
 
 func newDefaultClient(skipTLSVerify bool) *http.Client {
 	return &http.Client{
-		Transport: &http.Transport{
+		Transport: utils.WrapTransport(&http.Transport{
 			Proxy: http.ProxyFromEnvironment,
 			DialContext: (&net.Dialer{
 				Timeout:   30 * time.Second,
@@ -36,10 +37,8 @@ func newDefaultClient(skipTLSVerify bool) *http.Client {
 			ExpectContinueTimeout: 5 * time.Second,
 			DisableKeepAlives:     true,
 			TLSNextProto:          make(map[string]func(authority string, c *tls.Conn) http.RoundTripper),
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: skipTLSVerify,
-			},
-		},
+			TLSClientConfig:       utils.NewTLSConfig(skipTLSVerify),
+		}),
 	}
 }
 
@@ -50,8 +49,8 @@ type withCredentialFunc = func(string) (string, string, error)
 // withRemote creates an remote instance, it uses the implemention of containerd
 // docker remote to access image from remote registry.
 func withRemote(ref string, insecure bool, credFunc withCredentialFunc) (*remote.Remote, error) {
-	resolverFunc := func(retryWithHTTP bool) remotes.Resolver {
-		registryHosts := docker.ConfigureDefaultRegistries(
+	hostsFunc := func(retryWithHTTP bool) docker.RegistryHosts {
+		return docker.ConfigureDefaultRegistries(
 			docker.WithAuthorizer(
 				docker.NewDockerAuthorizer(
 					docker.WithAuthClient(newDefaultClient(insecure)),
@@ -63,13 +62,19 @@ func withRemote(ref string, insecure bool, credFunc withCredentialFunc) (*remote
 				return retryWithHTTP, nil
 			}),
 		)
-
+	}
+	resolverFunc := func(retryWithHTTP bool) remotes.Resolver {
 		return docker.NewResolver(docker.ResolverOptions{
-			Hosts: registryHosts,
+			Hosts: hostsFunc(retryWithHTTP),
 		})
 	}
 
-	return remote.New(ref, resolverFunc)
+	r, err := remote.New(ref, resolverFunc)
+	if err != nil {
+		return nil, err
+	}
+	r.HostsFunc = hostsFunc
+	return r, nil
 }
 
 // DefaultRemote creates an remote instance, it attempts to read docker auth config
@@ -113,3 +118,17 @@ func DefaultRemoteWithAuth(ref string, insecure bool, auth string) (*remote.Remo
 		return ary[0], ary[1], nil
 	})
 }
+
+// DefaultRemoteWithCredential creates an remote instance with the username
+// and password to communicate with remote registry.
+func DefaultRemoteWithCredential(ref string, insecure bool, username, password string) (*remote.Remote, error) {
+	return withRemote(ref, insecure, func(_ string) (string, string, error) {
+		return username, password, nil
+	})
+}
+
+// DefaultRemoteWithCredFunc creates an remote instance with the credential
+// function looking up the username and password by registry host.
+func DefaultRemoteWithCredFunc(ref string, insecure bool, credFunc func(string) (string, string, error)) (*remote.Remote, error) {
+	return withRemote(ref, insecure, credFunc)
+}
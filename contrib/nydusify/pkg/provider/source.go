@@ -58,6 +58,9 @@ type defaultSourceLayer struct {
 	desc          ocispec.Descriptor
 	chainID       digest.Digest
 	parentChainID *digest.Digest
+	// index is the 1-based index of layer in total layers.
+	index int
+	total int
 }
 
 func (sp *defaultSourceProvider) Manifest(_ context.Context) (*ocispec.Descriptor, error) {
@@ -88,6 +91,8 @@ func (sp *defaultSourceProvider) Layers(_ context.Context) ([]SourceLayer, error
 			desc:          desc,
 			chainID:       chainID,
 			parentChainID: parentChainID,
+			index:         i + 1,
+			total:         len(layers),
 		}
 		sourceLayers = append(sourceLayers, layer)
 		parentChainID = &chainID
@@ -108,7 +113,8 @@ func (sl *defaultSourceLayer) Mount(ctx context.Context) ([]mount.Mount, func()
 		defer reader.Close()
 
 		// Decompress layer from source stream
-		if err := utils.UnpackTargz(ctx, sl.mountDir, reader, false); err != nil {
+		progress := utils.NewUnpackProgress(sl.index, sl.total)
+		if err := utils.UnpackTargzWithProgress(ctx, sl.mountDir, reader, false, progress); err != nil {
 			return errors.Wrap(err, fmt.Sprintf("Decompress source layer %s", digestStr))
 		}
 
@@ -0,0 +1,151 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+)
+
+const redacted = "<redacted>"
+
+// JobCredentials are the credentials of a job submitted by API, which are
+// used instead of the proxy's, so that the teams sharing one proxy don't
+// share the registry credentials. They're kept in memory only until the
+// job finishes, and neither persisted into the job state file nor returned
+// by API.
+type JobCredentials struct {
+	Source *converter.Credential `json:"source,omitempty"`
+	Target *converter.Credential `json:"target,omitempty"`
+	// BackendConfig replaces the storage backend config of proxy, the
+	// backend type is unchanged.
+	BackendConfig json.RawMessage `json:"backend_config,omitempty"`
+}
+
+func (creds *JobCredentials) String() string {
+	return redacted
+}
+
+func (creds *JobCredentials) GoString() string {
+	return redacted
+}
+
+func (creds *JobCredentials) validate(opt converter.Opt) error {
+	if creds == nil || creds.BackendConfig == nil {
+		return nil
+	}
+	if opt.BackendType == "" || opt.BackendType == "registry" {
+		return errors.New("backend config is specified but no storage backend is configured")
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(creds.BackendConfig, &config); err != nil {
+		return errors.New("backend config should be a JSON object")
+	}
+	return nil
+}
+
+// identity returns the HMAC of credentials keyed by key, which tells the
+// jobs run with different credentials apart without revealing them.
+func (creds *JobCredentials) identity(key []byte) string {
+	data, _ := json.Marshal(creds)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// callerCredential returns the credential of source registry sent by the
+// caller in basic auth, nil means the caller is anonymous and acts as the
+// proxy.
+func callerCredential(r *http.Request) *converter.Credential {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+	return &converter.Credential{Username: username, Password: password}
+}
+
+// apply sets the credentials to conversion options.
+func (creds *JobCredentials) apply(opt *converter.Opt) {
+	if creds == nil {
+		return
+	}
+	opt.SourceCredential = creds.Source
+	opt.TargetCredential = creds.Target
+	if creds.BackendConfig != nil {
+		opt.BackendConfig = string(creds.BackendConfig)
+	}
+}
+
+// secrets returns the passwords and the secret values of backend config,
+// the longer ones come first so that they're redacted before the values
+// they contain.
+func (creds *JobCredentials) secrets() []string {
+	secrets := []string{}
+	for _, cred := range []*converter.Credential{creds.Source, creds.Target} {
+		if cred != nil && cred.Password != "" {
+			secrets = append(secrets, cred.Password)
+		}
+	}
+	var config map[string]interface{}
+	if json.Unmarshal(creds.BackendConfig, &config) == nil {
+		for key, value := range config {
+			key = strings.ToLower(key)
+			value, ok := value.(string)
+			if !ok || value == "" {
+				continue
+			}
+			for _, word := range []string{"secret", "password", "token", "key"} {
+				if strings.Contains(key, word) {
+					secrets = append(secrets, value)
+					break
+				}
+			}
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	return secrets
+}
+
+// redact replaces the secrets in the error message, the error is returned
+// as is if it contains no secret.
+func (creds *JobCredentials) redact(err error) error {
+	if creds == nil || err == nil {
+		return err
+	}
+	message := err.Error()
+	for _, secret := range creds.secrets() {
+		message = strings.ReplaceAll(message, secret, redacted)
+	}
+	if message == err.Error() {
+		return err
+	}
+	return errors.New(message)
+}
+
+// newRemote creates the remote of ref with the credential to pull it, the
+// credential manager of conversion or the docker config is used if cred is
+// nil.
+func newRemote(ref string, insecure bool, cred *converter.Credential, manager *converter.CredentialManager) (*remote.Remote, error) {
+	if manager != nil {
+		return provider.DefaultRemoteWithCredFunc(ref, insecure, manager.CredFunc(ref, converter.OperationPull, cred))
+	}
+	if cred == nil {
+		return provider.DefaultRemote(ref, insecure)
+	}
+	return provider.DefaultRemoteWithCredential(ref, insecure, cred.Username, cred.Password)
+}
@@ -0,0 +1,195 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+)
+
+func TestJobCredentials(t *testing.T) {
+	var creds *JobCredentials
+	err := errors.New("failed")
+	require.Equal(t, err, creds.redact(err))
+	require.NoError(t, creds.validate(converter.Opt{}))
+
+	creds = &JobCredentials{
+		Source:        &converter.Credential{Username: "team-a", Password: "source-pass"},
+		Target:        &converter.Credential{Username: "team-a", Password: "pass"},
+		BackendConfig: []byte(`{"bucket_name": "team-a", "access_key_secret": "backend-secret"}`),
+	}
+	require.Equal(t, redacted, creds.Source.String())
+	require.NotContains(t, fmt.Sprintf("%v %+v %#v %s", *creds.Source, *creds.Source, creds, creds), "pass")
+	require.Equal(t, err, creds.redact(err))
+	require.Equal(t, "auth team-a with <redacted> and <redacted>, bucket team-a",
+		creds.redact(errors.New("auth team-a with source-pass and backend-secret, bucket team-a")).Error())
+
+	require.Error(t, creds.validate(converter.Opt{}))
+	require.NoError(t, creds.validate(converter.Opt{BackendType: "oss"}))
+	creds.BackendConfig = []byte(`"invalid"`)
+	require.Error(t, creds.validate(converter.Opt{BackendType: "oss"}))
+}
+
+func TestRunWithCredentials(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "jobs.json")
+	pxy, err := New(Opt{
+		TargetRegistry: "localhost:5000",
+		Convert: converter.Opt{
+			WorkDir:       filepath.Join(t.TempDir(), "work"),
+			BackendType:   "oss",
+			BackendConfig: `{"access_key_secret": "proxy-secret"}`,
+		},
+		JobStateFile: stateFile,
+	})
+	require.NoError(t, err)
+	var checked *converter.Credential
+	pxy.check = func(_ context.Context, _ string, cred *converter.Credential) error {
+		checked = cred
+		return nil
+	}
+	var converted converter.Opt
+	pxy.convert = func(_ context.Context, opt converter.Opt) error {
+		converted = opt
+		return fmt.Errorf("unauthorized: %s", opt.SourceCredential.Password)
+	}
+
+	creds := &JobCredentials{
+		Source:        &converter.Credential{Username: "team-a", Password: "source-pass"},
+		BackendConfig: []byte(`{"access_key_secret": "team-secret"}`),
+	}
+	err = pxy.run(context.Background(), "docker.io/library/nginx:latest", "localhost:5000/library/nginx:latest", "team-a", 0, creds, true)
+	require.EqualError(t, err, "unauthorized: <redacted>")
+	require.Equal(t, creds.Source, checked)
+	require.Equal(t, creds.Source, converted.SourceCredential)
+	require.Nil(t, converted.TargetCredential)
+	require.Equal(t, `{"access_key_secret": "team-secret"}`, converted.BackendConfig)
+
+	jobs := pxy.Jobs()
+	require.Len(t, jobs, 1)
+	require.True(t, jobs[0].Credentials)
+	require.Equal(t, "unauthorized: <redacted>", jobs[0].Error)
+	state, err := os.ReadFile(stateFile)
+	require.NoError(t, err)
+	require.NotContains(t, string(state), "source-pass")
+	require.NotContains(t, string(state), "team-secret")
+
+	// The job with credentials is failed on resume.
+	require.NoError(t, pxy.jobs.update("localhost:5000/library/redis:latest#id", "docker.io/library/redis:latest", "localhost:5000/library/redis:latest", func(job *Job) {
+		job.State = JobRunning
+		job.Credentials = true
+	}))
+	pxy.Resume()
+	jobs = pxy.Jobs()
+	require.Equal(t, JobFailed, jobs[1].State)
+	require.Contains(t, jobs[1].Error, "credentials of job are lost")
+
+	// The backend config can't be specified without storage backend.
+	pxy.opt.Convert.BackendType = ""
+	rec := httptest.NewRecorder()
+	pxy.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, jobsPath, strings.NewReader(
+		`{"name": "library/nginx", "reference": "latest", "credentials": {"backend_config": {"access_key_secret": "team-secret"}}}`,
+	)))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.NotContains(t, rec.Body.String(), "team-secret")
+}
+
+func TestTenantIsolation(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+
+	pxy, err := New(Opt{
+		TargetRegistry: registry.Host(),
+		Convert:        converter.Opt{WorkDir: filepath.Join(t.TempDir(), "work")},
+	})
+	require.NoError(t, err)
+	// Only team-a can access the source.
+	pxy.access = func(_ context.Context, _ string, cred *converter.Credential) error {
+		if cred == nil || cred.Username != "team-a" {
+			return errors.New("unauthorized")
+		}
+		return nil
+	}
+	pxy.check = func(context.Context, string, *converter.Credential) error { return nil }
+	var converted []string
+	pxy.convert = func(_ context.Context, opt converter.Opt) error {
+		converted = append(converted, opt.SourceCredential.Username)
+		return nil
+	}
+
+	// The jobs of same target with different credentials are not shared.
+	source, target := "docker.io/team/app:latest", registry.Host()+"/team/app:latest"
+	teamA := &JobCredentials{Source: &converter.Credential{Username: "team-a", Password: "pass-a"}}
+	teamB := &JobCredentials{Source: &converter.Credential{Username: "team-b", Password: "pass-b"}}
+	require.Equal(t, target, pxy.jobID(target, nil))
+	require.Equal(t, pxy.jobID(target, teamA), pxy.jobID(target, teamA))
+	require.NotEqual(t, pxy.jobID(target, teamA), pxy.jobID(target, teamB))
+	require.NotContains(t, pxy.jobID(target, teamA), "pass-a")
+	require.NoError(t, pxy.run(context.Background(), source, target, "team-a", 0, teamA, true))
+	require.NoError(t, pxy.run(context.Background(), source, target, "team-b", 0, teamB, true))
+	require.Equal(t, []string{"team-a", "team-b"}, converted)
+	require.Len(t, pxy.Jobs(), 2)
+
+	request := func(method, path string, cred *converter.Credential) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if cred != nil {
+			req.SetBasicAuth(cred.Username, cred.Password)
+		}
+		rec := httptest.NewRecorder()
+		pxy.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The jobs run with credentials are only listed to the caller allowed
+	// to access the source.
+	for _, tc := range []struct {
+		cred *converter.Credential
+		jobs int
+	}{{nil, 0}, {teamB.Source, 0}, {teamA.Source, 2}} {
+		rec := request(http.MethodGet, jobsPath, tc.cred)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var jobs []Job
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&jobs))
+		require.Len(t, jobs, tc.jobs)
+	}
+	rec := request(http.MethodGet, jobLogsPath+"?id="+pxy.jobID(target, teamA), nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = request(http.MethodGet, jobLogsPath+"?id="+pxy.jobID(target, teamA), teamA.Source)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "event: end")
+
+	// The converted image is only served to the caller allowed to access
+	// the source.
+	config := registry.PutBlob("team/app", ocispec.MediaTypeImageConfig, []byte("{}"))
+	_, err = registry.PutManifest("team/app", "latest", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{},
+	})
+	require.NoError(t, err)
+	for _, cred := range []*converter.Credential{nil, teamB.Source} {
+		rec = request(http.MethodGet, "/v2/team/app/manifests/latest", cred)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Equal(t, `Basic realm="nydusify"`, rec.Header().Get("WWW-Authenticate"))
+	}
+	rec = request(http.MethodGet, "/v2/team/app/manifests/latest", teamA.Source)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, converted, 2)
+}
@@ -0,0 +1,190 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jobRetention is how long the finished jobs are kept in state file.
+const jobRetention = 24 * time.Hour
+
+// JobState is the state of conversion job.
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// Job is the conversion of a source image, identified by the target and
+// the credentials of job.
+type Job struct {
+	// ID is the target for the job without credentials, or the target
+	// with the identity of credentials, so that the jobs run with different
+	// credentials are never shared.
+	ID     string   `json:"id"`
+	Source string   `json:"source"`
+	Target string   `json:"target"`
+	State  JobState `json:"state"`
+	Error  string   `json:"error,omitempty"`
+	// Tenant and Priority are used to schedule the job.
+	Tenant   string `json:"tenant,omitempty"`
+	Priority int    `json:"priority"`
+	// Credentials is true if the job runs with the credentials of job,
+	// which are never persisted.
+	Credentials bool `json:"credentials,omitempty"`
+	// Attempts is the times the job has been started, it's more than 1 if
+	// the job was interrupted by restart and resumed.
+	Attempts   int       `json:"attempts"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+func (job *Job) finished() bool {
+	return job.State == JobSucceeded || job.State == JobFailed
+}
+
+// jobStore keeps the jobs in memory and persists them to a JSON file on
+// each change if path is specified, so that the queued and running jobs
+// survive restart.
+type jobStore struct {
+	mutex sync.Mutex
+	path  string
+	jobs  map[string]*Job
+}
+
+func openJobStore(path string) (*jobStore, error) {
+	store := &jobStore{
+		path: path,
+		jobs: map[string]*Job{},
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, errors.Wrap(err, "read job state file")
+	}
+	var jobs []*Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, errors.Wrap(err, "unmarshal job state file")
+	}
+	for _, job := range jobs {
+		if job.finished() && time.Since(job.FinishedAt) > jobRetention {
+			continue
+		}
+		// The state file written before job ID was introduced.
+		if job.ID == "" {
+			job.ID = job.Target
+		}
+		store.jobs[job.ID] = job
+	}
+
+	return store, nil
+}
+
+// save writes the jobs to a temp file then renames it, so that the state
+// file is never partially written. The caller must hold the mutex.
+func (store *jobStore) save() error {
+	if store.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(store.list(), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal jobs")
+	}
+	if err := os.MkdirAll(filepath.Dir(store.path), 0755); err != nil {
+		return errors.Wrap(err, "create job state directory")
+	}
+	tmpPath := store.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return errors.Wrap(err, "write job state file")
+	}
+	return errors.Wrap(os.Rename(tmpPath, store.path), "rename job state file")
+}
+
+// list returns the jobs sorted by creation time. The caller must hold the
+// mutex.
+func (store *jobStore) list() []*Job {
+	jobs := make([]*Job, 0, len(store.jobs))
+	for _, job := range store.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// Jobs returns a snapshot of the jobs sorted by creation time.
+func (store *jobStore) Jobs() []Job {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	jobs := []Job{}
+	for _, job := range store.list() {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// unfinished returns the jobs queued or running when the state was saved.
+func (store *jobStore) unfinished() []Job {
+	jobs := []Job{}
+	for _, job := range store.Jobs() {
+		if !job.finished() {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// active returns true if the job of id is queued or running.
+func (store *jobStore) active(id string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	job := store.jobs[id]
+	return job != nil && !job.finished()
+}
+
+// get returns the job of id.
+func (store *jobStore) get(id string) (Job, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	job := store.jobs[id]
+	if job == nil {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// update applies fn to the job of id, the job is created if not exists.
+func (store *jobStore) update(id, source, target string, fn func(job *Job)) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	job := store.jobs[id]
+	if job == nil {
+		job = &Job{ID: id, Source: source, Target: target, CreatedAt: time.Now()}
+		store.jobs[id] = job
+	}
+	fn(job)
+
+	return store.save()
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter"
+)
+
+func TestJobStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "jobs.json")
+	store, err := openJobStore(path)
+	require.NoError(t, err)
+
+	require.NoError(t, store.update("b:latest", "a:latest", "b:latest", func(job *Job) {
+		job.State = JobRunning
+	}))
+	require.NoError(t, store.update("d:latest", "c:latest", "d:latest", func(job *Job) {
+		job.State = JobSucceeded
+		job.FinishedAt = time.Now().Add(-2 * jobRetention)
+	}))
+	require.FileExists(t, path)
+
+	// The expired finished job is dropped on reopen.
+	store, err = openJobStore(path)
+	require.NoError(t, err)
+	jobs := store.unfinished()
+	require.Len(t, jobs, 1)
+	require.Equal(t, "a:latest", jobs[0].Source)
+	require.Equal(t, JobRunning, jobs[0].State)
+	require.Len(t, store.Jobs(), 1)
+
+	require.NoError(t, os.WriteFile(path, []byte("invalid"), 0644))
+	_, err = openJobStore(path)
+	require.Error(t, err)
+}
+
+func TestResume(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "jobs.json")
+	store, err := openJobStore(stateFile)
+	require.NoError(t, err)
+	require.NoError(t, store.update("localhost:5000/library/nginx:latest", "docker.io/library/nginx:latest", "localhost:5000/library/nginx:latest", func(job *Job) {
+		job.State = JobRunning
+		job.Attempts = 1
+	}))
+
+	pxy, err := New(Opt{
+		TargetRegistry: "localhost:5000",
+		Convert:        converter.Opt{WorkDir: filepath.Join(t.TempDir(), "work")},
+		JobStateFile:   stateFile,
+		MaxJobs:        1,
+	})
+	require.NoError(t, err)
+	pxy.check = func(context.Context, string, *converter.Credential) error { return nil }
+	converted := make(chan converter.Opt, 1)
+	pxy.convert = func(_ context.Context, opt converter.Opt) error {
+		converted <- opt
+		return nil
+	}
+	require.NoError(t, pxy.run(context.Background(), "docker.io/library/busybox:latest", "localhost:5000/library/busybox:latest", "", 0, nil, true))
+	<-converted
+
+	pxy.Resume()
+	opt := <-converted
+	require.Equal(t, "docker.io/library/nginx:latest", opt.Source)
+	require.Eventually(t, func() bool {
+		jobs := pxy.Jobs()
+		return jobs[0].State == JobSucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	pxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, jobsPath, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var jobs []Job
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&jobs))
+	require.Len(t, jobs, 2)
+	require.Equal(t, 2, jobs[0].Attempts)
+}
+
+func TestSubmitJob(t *testing.T) {
+	pxy, err := New(Opt{
+		TargetRegistry: "localhost:5000",
+		Convert:        converter.Opt{WorkDir: filepath.Join(t.TempDir(), "work")},
+	})
+	require.NoError(t, err)
+
+	for _, body := range []string{
+		"invalid",
+		`{"name": "library/nginx"}`,
+		`{"name": "library/nginx", "reference": "latest", "source_registry": "Invalid Registry"}`,
+	} {
+		rec := httptest.NewRecorder()
+		pxy.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, jobsPath, strings.NewReader(body)))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v2/library/nginx/manifests/latest", nil)
+	req.Header.Set(priorityHeader, "high")
+	pxy.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	pxy.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, jobsPath, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
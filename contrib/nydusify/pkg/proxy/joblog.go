@@ -0,0 +1,284 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// jobLogsPath is the API path to stream the logs of a job.
+const jobLogsPath = jobsPath + "/logs"
+
+// maxJobLogEntries limits the log entries kept for each job, the earlier
+// entries are dropped.
+const maxJobLogEntries = 1000
+
+// LogEntry is a structured log entry of job.
+type LogEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+type jobLog struct {
+	source string
+	target string
+	// secrets of job credentials are removed from the entries of all jobs,
+	// as an entry may be attributed to the jobs of same target run with
+	// different credentials.
+	secrets []string
+	// dropped is the count of entries dropped from the head of entries.
+	dropped int
+	entries []LogEntry
+	// active is true once the job gets a slot to run.
+	active     bool
+	finished   bool
+	finishedAt time.Time
+	// changed is closed and replaced once an entry is added or the job
+	// finishes, to wake up the streams.
+	changed chan struct{}
+}
+
+func (log *jobLog) notify() {
+	close(log.changed)
+	log.changed = make(chan struct{})
+}
+
+func (log *jobLog) add(entry LogEntry) {
+	log.entries = append(log.entries, entry)
+	if len(log.entries) > maxJobLogEntries {
+		log.entries = log.entries[1:]
+		log.dropped++
+	}
+	log.notify()
+}
+
+// jobLogs is the logrus hook collecting the log entries of running jobs.
+// The converter logs by the global logger without job context, so that an
+// entry is attributed to a job if its `job` field is the job target, or
+// its message refers to the job source or target, or it has no `job` field
+// while the job is the only active one. The other entries are not streamed, so that the logs of a
+// job are never leaked to the clients of other jobs.
+type jobLogs struct {
+	mutex sync.Mutex
+	logs  map[string]*jobLog
+}
+
+func newJobLogs() *jobLogs {
+	return &jobLogs{logs: map[string]*jobLog{}}
+}
+
+func (logs *jobLogs) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (logs *jobLogs) Fire(entry *logrus.Entry) error {
+	logs.mutex.Lock()
+	defer logs.mutex.Unlock()
+
+	active := []*jobLog{}
+	attributed := []*jobLog{}
+	for _, log := range logs.logs {
+		if log.finished {
+			continue
+		}
+		if log.active {
+			active = append(active, log)
+		}
+		if entry.Data["job"] == log.target ||
+			strings.Contains(entry.Message, log.source) || strings.Contains(entry.Message, log.target) {
+			attributed = append(attributed, log)
+		}
+	}
+	if _, ok := entry.Data["job"]; !ok && len(attributed) == 0 && len(active) == 1 {
+		attributed = active
+	}
+
+	secrets := []string{}
+	for _, log := range logs.logs {
+		if !log.finished {
+			secrets = append(secrets, log.secrets...)
+		}
+	}
+	redact := func(str string) string {
+		for _, secret := range secrets {
+			str = strings.ReplaceAll(str, secret, redacted)
+		}
+		return str
+	}
+
+	for _, log := range attributed {
+		fields := map[string]interface{}{}
+		for key, value := range entry.Data {
+			if key == "job" {
+				continue
+			}
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			if str, ok := value.(string); ok {
+				value = redact(str)
+			}
+			fields[key] = value
+		}
+		log.add(LogEntry{
+			Time:    entry.Time,
+			Level:   entry.Level.String(),
+			Message: redact(entry.Message),
+			Fields:  fields,
+		})
+	}
+	return nil
+}
+
+// start begins collecting the logs of job id, the logs of previous run are
+// discarded, and the logs of the jobs finished for jobRetention are pruned.
+func (logs *jobLogs) start(id, source, target string, creds *JobCredentials) {
+	logs.mutex.Lock()
+	defer logs.mutex.Unlock()
+
+	for key, log := range logs.logs {
+		if log.finished && time.Since(log.finishedAt) > jobRetention {
+			delete(logs.logs, key)
+		}
+	}
+	secrets := []string{}
+	if creds != nil {
+		secrets = creds.secrets()
+	}
+	log := logs.logs[id]
+	if log != nil && !log.finished {
+		return
+	}
+	if log != nil {
+		log.notify()
+	}
+	logs.logs[id] = &jobLog{
+		source:  source,
+		target:  target,
+		secrets: secrets,
+		changed: make(chan struct{}),
+	}
+}
+
+func (logs *jobLogs) activate(id string) {
+	logs.mutex.Lock()
+	defer logs.mutex.Unlock()
+
+	if log := logs.logs[id]; log != nil {
+		log.active = true
+	}
+}
+
+// finish stops collecting the logs of job, the result of job is added as
+// the last entry, err should have been redacted.
+func (logs *jobLogs) finish(id string, err error) {
+	logs.mutex.Lock()
+	defer logs.mutex.Unlock()
+
+	log := logs.logs[id]
+	if log == nil {
+		return
+	}
+	entry := LogEntry{Time: time.Now(), Level: logrus.InfoLevel.String(), Message: "job succeeded"}
+	if err != nil {
+		entry.Level = logrus.ErrorLevel.String()
+		entry.Message = "job failed"
+		entry.Fields = map[string]interface{}{logrus.ErrorKey: err.Error()}
+	}
+	log.finished = true
+	log.finishedAt = time.Now()
+	log.add(entry)
+}
+
+// read returns the entries from the sequence number next and the sequence
+// number to read next, the dropped entries are skipped, and the entries are
+// read from the beginning if the job is started again. The returned channel
+// is closed on next change.
+func (logs *jobLogs) read(id string, next int) ([]LogEntry, int, bool, <-chan struct{}, bool) {
+	logs.mutex.Lock()
+	defer logs.mutex.Unlock()
+
+	log := logs.logs[id]
+	if log == nil {
+		return nil, 0, false, nil, false
+	}
+	if next < log.dropped || next > log.dropped+len(log.entries) {
+		next = log.dropped
+	}
+	entries := append([]LogEntry{}, log.entries[next-log.dropped:]...)
+	return entries, next + len(entries), log.finished, log.changed, true
+}
+
+// serveJobLogs streams the log entries of the job by server-sent events,
+// each entry is sent as a JSON `data` event, and an `end` event is sent
+// once the job finishes. The job is specified by `id`, or by `target` for
+// the job without credentials, and the logs of the job with credentials
+// are only streamed to the caller allowed to access its source.
+func (proxy *Proxy) serveJobLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		id = r.URL.Query().Get("target")
+	}
+	job, ok := proxy.jobs.get(id)
+	if _, _, _, _, found := proxy.logs.read(id, 0); !ok || !found {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", fmt.Errorf("no logs of job %s", id))
+		return
+	}
+	if job.Credentials {
+		if err := proxy.authorize(r.Context(), job.Source, callerCredential(r)); err != nil {
+			writeUnauthorized(w, err)
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "UNSUPPORTED", fmt.Errorf("streaming is unsupported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	next := 0
+	for {
+		entries, seq, finished, changed, ok := proxy.logs.read(id, next)
+		if !ok {
+			return
+		}
+		for idx, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", seq-len(entries)+idx, data)
+		}
+		next = seq
+		if finished {
+			fmt.Fprint(w, "event: end\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter"
+)
+
+func TestJobLogsAttribution(t *testing.T) {
+	logs := newJobLogs()
+	fire := func(message string, fields logrus.Fields) {
+		require.NoError(t, logs.Fire(&logrus.Entry{Message: message, Data: fields, Level: logrus.InfoLevel}))
+	}
+	messages := func(target string) []string {
+		entries, _, _, _, ok := logs.read(target, 0)
+		require.True(t, ok)
+		result := []string{}
+		for _, entry := range entries {
+			result = append(result, entry.Message)
+		}
+		return result
+	}
+
+	logs.start("localhost/a:latest", "docker.io/library/a:latest", "localhost/a:latest", nil)
+	logs.start("localhost/b:latest", "docker.io/library/b:latest", "localhost/b:latest", &JobCredentials{
+		Source: &converter.Credential{Username: "user", Password: "secret"},
+	})
+	logs.activate("localhost/a:latest")
+	fire("only a is active", nil)
+	logs.activate("localhost/b:latest")
+	fire("pulling image docker.io/library/b:latest with secret", nil)
+	fire("unknown job", nil)
+	fire("job field", logrus.Fields{"job": "localhost/a:latest", "error": errors.New("secret")})
+
+	require.Equal(t, []string{"only a is active", "job field"}, messages("localhost/a:latest"))
+	require.Equal(t, []string{"pulling image docker.io/library/b:latest with <redacted>"}, messages("localhost/b:latest"))
+
+	logs.finish("localhost/b:latest", errors.New("failed"))
+	entries, next, finished, _, _ := logs.read("localhost/b:latest", 1)
+	require.True(t, finished)
+	require.Equal(t, 2, next)
+	require.Equal(t, "job failed", entries[0].Message)
+	require.Equal(t, "failed", entries[0].Fields[logrus.ErrorKey])
+	fire("after finish", logrus.Fields{"job": "localhost/b:latest"})
+	require.Len(t, messages("localhost/b:latest"), 2)
+
+	for idx := 0; idx < maxJobLogEntries+10; idx++ {
+		fire("flood", logrus.Fields{"job": "localhost/a:latest"})
+	}
+	entries, next, _, _, _ = logs.read("localhost/a:latest", 0)
+	require.Len(t, entries, maxJobLogEntries)
+	require.Equal(t, maxJobLogEntries+12, next)
+}
+
+func TestStreamJobLogs(t *testing.T) {
+	pxy, err := New(Opt{
+		TargetRegistry: "localhost:5000",
+		Convert:        converter.Opt{WorkDir: filepath.Join(t.TempDir(), "work")},
+	})
+	require.NoError(t, err)
+	pxy.check = func(context.Context, string, *converter.Credential) error { return nil }
+	release := make(chan struct{})
+	pxy.convert = func(_ context.Context, opt converter.Opt) error {
+		logrus.Infof("pulling image %s", opt.Source)
+		<-release
+		return nil
+	}
+	server := httptest.NewServer(pxy)
+	defer server.Close()
+
+	target := "localhost:5000/library/nginx:latest"
+	resp, err := http.Get(server.URL + jobLogsPath + "?target=" + url.QueryEscape(target))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	done := make(chan error)
+	go func() {
+		done <- pxy.run(context.Background(), "docker.io/library/nginx:latest", target, "", 0, nil, true)
+	}()
+	require.Eventually(t, func() bool {
+		_, next, _, _, ok := pxy.logs.read(target, 0)
+		return ok && next >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	resp, err = http.Get(server.URL + jobLogsPath + "?target=" + url.QueryEscape(target))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	close(release)
+	require.NoError(t, <-done)
+
+	messages := []string{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "event: end" {
+			break
+		}
+		if strings.HasPrefix(line, "data: ") {
+			var entry LogEntry
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &entry))
+			messages = append(messages, entry.Message)
+		}
+	}
+	require.Equal(t, []string{
+		"converting docker.io/library/nginx:latest to " + target,
+		"pulling image docker.io/library/nginx:latest",
+		"converted docker.io/library/nginx:latest to " + target,
+		"job succeeded",
+	}, messages)
+}
@@ -0,0 +1,294 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// The API paths to preview the converted image in target registry, i.e.
+// download its bootstrap and list its files.
+const (
+	bootstrapPath = "/api/v1/bootstrap"
+	filesPath     = "/api/v1/files"
+)
+
+// maxPreviews limits the previews cached in work directory, the least
+// recently used ones are removed.
+const maxPreviews = 64
+
+const (
+	previewBootstrapFile = "bootstrap"
+	previewFilesFile     = "files.json"
+)
+
+// Files is the file listing of converted image.
+type Files struct {
+	// Image is the reference of converted image in target registry.
+	Image    string `json:"image"`
+	Platform string `json:"platform"`
+	// Bootstrap is the digest of bootstrap layer.
+	Bootstrap string          `json:"bootstrap"`
+	Files     []tool.FileInfo `json:"files"`
+}
+
+// preview is the bootstrap and file listing of converted image cached in
+// work directory.
+type preview struct {
+	ref       string
+	platform  string
+	bootstrap ocispec.Descriptor
+	dir       string
+}
+
+// servePreview serves the bootstrap with range requests supported, or the
+// file listing in JSON, of the converted image specified by `name`,
+// `reference` and optional `platform` query parameters.
+func (proxy *Proxy) servePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	query := r.URL.Query()
+	req, err := parseRequest(fmt.Sprintf("/v2/%s/manifests/%s", query.Get("name"), query.Get("reference")))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "UNSUPPORTED", fmt.Errorf("invalid image %s:%s", query.Get("name"), query.Get("reference")))
+		return
+	}
+	platform := platforms.DefaultSpec()
+	if value := query.Get("platform"); value != "" {
+		if platform, err = platforms.Parse(value); err != nil {
+			writeError(w, http.StatusBadRequest, "UNSUPPORTED", errors.Wrapf(err, "invalid platform %s", value))
+			return
+		}
+	}
+
+	pv, err := proxy.preview(r.Context(), req.ref(proxy.opt.TargetRegistry), platform)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errdefs.IsNotFound(err) {
+			status = http.StatusNotFound
+		} else {
+			logrus.WithError(err).Errorf("failed to preview %s", req.ref(proxy.opt.TargetRegistry))
+		}
+		writeError(w, status, "MANIFEST_UNKNOWN", err)
+		return
+	}
+
+	name, contentType := previewBootstrapFile, "application/octet-stream"
+	if r.URL.Path == filesPath {
+		name, contentType = previewFilesFile, "application/json"
+	}
+	file, err := os.Open(filepath.Join(pv.dir, name))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", errors.Wrap(err, "open preview"))
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", errors.Wrap(err, "stat preview"))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Docker-Content-Digest", pv.bootstrap.Digest.String())
+	// The previews of the same bootstrap are identical.
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%s"`, name, pv.bootstrap.Digest.Encoded()))
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+// preview resolves the nydus manifest of platform for the converted image
+// ref, and prepares its preview in work directory if it isn't cached, the
+// concurrent requests of the same bootstrap share one preparation.
+func (proxy *Proxy) preview(ctx context.Context, ref string, platform ocispec.Platform) (*preview, error) {
+	remoter, desc, err := proxy.resolve(ctx, ref, nil)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := findNydusManifest(ctx, remoter, *desc, platforms.Only(platform))
+	if err != nil {
+		return nil, err
+	}
+	bootstrap := parser.FindNydusBootstrapDesc(manifest)
+	if bootstrap == nil {
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "nydus image of platform %s in %s", platforms.Format(platform), ref)
+	}
+
+	pv := &preview{
+		ref:       ref,
+		platform:  platforms.Format(platform),
+		bootstrap: *bootstrap,
+		dir:       filepath.Join(proxy.opt.Convert.WorkDir, "preview", bootstrap.Digest.Encoded()),
+	}
+	if _, err := os.Stat(filepath.Join(pv.dir, previewFilesFile)); err == nil {
+		// Refresh the modification time for LRU eviction.
+		now := time.Now()
+		if err := os.Chtimes(pv.dir, now, now); err != nil {
+			logrus.WithError(err).Warnf("failed to touch preview %s", pv.dir)
+		}
+		return pv, nil
+	}
+
+	ch := proxy.previews.DoChan(pv.dir, func() (interface{}, error) {
+		// Don't cancel the preparation shared by other requests when the
+		// client disconnects.
+		return nil, proxy.preparePreview(context.Background(), remoter, pv)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return pv, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// findNydusManifest returns the manifest of desc, or the manifest matching
+// platform with nydus bootstrap if desc is an index.
+func findNydusManifest(ctx context.Context, remoter *remote.Remote, desc ocispec.Descriptor, matcher platforms.Matcher) (*ocispec.Manifest, error) {
+	pull := func(desc ocispec.Descriptor, v interface{}) error {
+		reader, err := remoter.Pull(ctx, desc, true)
+		if err != nil {
+			return errors.Wrapf(err, "pull %s", desc.Digest)
+		}
+		defer reader.Close()
+		return errors.Wrapf(json.NewDecoder(reader).Decode(v), "decode %s", desc.Digest)
+	}
+
+	if !images.IsIndexType(desc.MediaType) {
+		var manifest ocispec.Manifest
+		if err := pull(desc, &manifest); err != nil {
+			return nil, err
+		}
+		return &manifest, nil
+	}
+
+	var index ocispec.Index
+	if err := pull(desc, &index); err != nil {
+		return nil, err
+	}
+	var found *ocispec.Manifest
+	for _, desc := range index.Manifests {
+		if desc.Platform == nil || !matcher.Match(*desc.Platform) {
+			continue
+		}
+		// The index may reference both OCI and nydus manifests of the same
+		// platform, e.g. merged by `--merge-platform`.
+		var manifest ocispec.Manifest
+		if err := pull(desc, &manifest); err != nil {
+			return nil, err
+		}
+		found = &manifest
+		if parser.FindNydusBootstrapDesc(&manifest) != nil {
+			break
+		}
+	}
+	if found == nil {
+		return nil, errors.Wrap(errdefs.ErrNotFound, "no manifest matching platform")
+	}
+	return found, nil
+}
+
+// preparePreview pulls the bootstrap layer and lists its files into the
+// preview directory, which is renamed from a temporary directory once it's
+// completed, so the cached previews are always complete.
+func (proxy *Proxy) preparePreview(ctx context.Context, remoter *remote.Remote, pv *preview) error {
+	if err := os.MkdirAll(filepath.Dir(pv.dir), 0755); err != nil {
+		return errors.Wrap(err, "create preview directory")
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(pv.dir), ".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "create temp preview directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	reader, err := remoter.Pull(ctx, pv.bootstrap, true)
+	if err != nil {
+		return errors.Wrap(err, "pull bootstrap layer")
+	}
+	defer reader.Close()
+	bootstrapFile := filepath.Join(tmpDir, previewBootstrapFile)
+	if err := utils.UnpackFile(reader, utils.BootstrapFileNameInLayer, bootstrapFile); err != nil {
+		return errors.Wrap(err, "unpack bootstrap layer")
+	}
+
+	files, err := tool.NewBuilder(proxy.opt.Convert.NydusImagePath).List(bootstrapFile)
+	if err != nil {
+		return errors.Wrap(err, "list files in bootstrap")
+	}
+	data, err := json.Marshal(Files{
+		Image:     pv.ref,
+		Platform:  pv.platform,
+		Bootstrap: pv.bootstrap.Digest.String(),
+		Files:     files,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal files")
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, previewFilesFile), data, 0644); err != nil {
+		return errors.Wrap(err, "write files")
+	}
+
+	if err := os.Rename(tmpDir, pv.dir); err != nil && !os.IsExist(err) {
+		return errors.Wrap(err, "save preview")
+	}
+	proxy.evictPreviews(filepath.Dir(pv.dir))
+	return nil
+}
+
+// evictPreviews removes the least recently used previews beyond maxPreviews,
+// the preview being served is still readable by its opened file.
+func (proxy *Proxy) evictPreviews(base string) {
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to read preview directory")
+		return
+	}
+	type cached struct {
+		path    string
+		modTime time.Time
+	}
+	previews := []cached{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() || entry.Name()[0] == '.' {
+			continue
+		}
+		previews = append(previews, cached{filepath.Join(base, entry.Name()), info.ModTime()})
+	}
+	if len(previews) <= maxPreviews {
+		return
+	}
+	sort.Slice(previews, func(i, j int) bool {
+		return previews[i].modTime.After(previews[j].modTime)
+	})
+	for _, pv := range previews[maxPreviews:] {
+		if err := os.RemoveAll(pv.path); err != nil {
+			logrus.WithError(err).Warnf("failed to remove preview %s", pv.path)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestServePreview(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	data := []byte("bootstrap")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: utils.BootstrapFileNameInLayer, Mode: 0644, Size: int64(len(data))}))
+	_, err := tw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	bootstrap := registry.PutBlob("app", ocispec.MediaTypeImageLayer, buf.Bytes())
+	bootstrap.Annotations = map[string]string{utils.LayerAnnotationNydusBootstrap: "true"}
+
+	config := registry.PutBlob("app", ocispec.MediaTypeImageConfig, []byte("{}"))
+	oci, err := registry.PutManifest("app", "v1-oci", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{registry.PutBlob("app", ocispec.MediaTypeImageLayer, []byte("layer"))},
+	})
+	require.NoError(t, err)
+	nydus, err := registry.PutManifest("app", "v1-nydus", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{bootstrap},
+	})
+	require.NoError(t, err)
+	// The OCI and nydus manifests are merged into one index.
+	oci.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	nydus.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64", OSFeatures: []string{"nydus.remoteimage.v1"}}
+	_, err = registry.PutManifest("app", "v1", ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{oci, nydus},
+	})
+	require.NoError(t, err)
+
+	stub, err := testutil.NewNydusImage(t.TempDir(), testutil.NydusImageOption{
+		Stdout: `inode: dir "/": index 1 ino 1 real_ino 1 child_index 2 child_count 1 i_nlink 2 i_size 4096 i_blocks 8 i_name_size 0 i_symlink_size 0 has_xattr false link None i_mtime 100 i_mtime_nsec 0
+inode: file "/hello": index 2 ino 2 real_ino 2 child_index 0 child_count 1 i_nlink 1 i_size 5 i_blocks 8 i_name_size 5 i_symlink_size 0 has_xattr false link None i_mtime 200 i_mtime_nsec 0
+`,
+	})
+	require.NoError(t, err)
+	proxy, err := New(Opt{
+		TargetRegistry: registry.Host(),
+		Convert:        converter.Opt{WorkDir: t.TempDir(), NydusImagePath: stub.Path},
+	})
+	require.NoError(t, err)
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		proxy.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(filesPath+"?name=app&reference=v1&platform=linux/amd64", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var files Files
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &files))
+	require.Equal(t, Files{
+		Image:     registry.Host() + "/app:v1",
+		Platform:  "linux/amd64",
+		Bootstrap: bootstrap.Digest.String(),
+		Files: []tool.FileInfo{
+			{Path: "/", Type: "dir", Size: 4096, Mtime: 100},
+			{Path: "/hello", Type: "file", Size: 5, Mtime: 200},
+		},
+	}, files)
+
+	// The bootstrap is served by range from cache.
+	rec = get(bootstrapPath+"?name=app&reference=v1-nydus", http.Header{"Range": {"bytes=0-3"}})
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	require.Equal(t, "boot", rec.Body.String())
+	etag := rec.Header().Get("ETag")
+	rec = get(bootstrapPath+"?name=app&reference=v1-nydus", http.Header{"If-None-Match": {etag}})
+	require.Equal(t, http.StatusNotModified, rec.Code)
+	calls, err := stub.Calls()
+	require.NoError(t, err)
+	require.Len(t, calls, 1)
+
+	// The image isn't converted to nydus.
+	rec = get(filesPath+"?name=app&reference=v1-oci", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Contains(t, rec.Body.String(), "MANIFEST_UNKNOWN")
+	rec = get(filesPath+"?name=app&reference=v1&platform=linux/arm64", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = get(filesPath+"?name=app&reference=v2", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = get(filesPath+"?name=app", nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = get(filesPath+"?name=app&reference=v1&platform=invalid/platform/x/y", nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,713 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package proxy implements a pull-through registry proxy which serves the
+// converted Nydus images for requested OCI images, the images are converted
+// and cached in target registry on miss, so that clusters can adopt Nydus by
+// only changing their registry endpoint.
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const defaultSourceRegistry = "docker.io"
+
+// jobsPath is the API path to list and submit the conversion jobs.
+const jobsPath = "/api/v1/jobs"
+
+// queuePath is the API path to get the depth of conversion queue.
+const queuePath = "/api/v1/queue"
+
+// queueRetryAfter is the `Retry-After` seconds responded when the
+// conversion queue is full.
+const queueRetryAfter = 30
+
+// errUnauthorized is returned if the caller isn't allowed to access the
+// source of converted image.
+var errUnauthorized = errors.New("unauthorized to access source image")
+
+// The headers specifying the tenant and priority of the conversion job
+// triggered by pulling image.
+const (
+	tenantHeader   = "Nydusify-Tenant"
+	priorityHeader = "Nydusify-Priority"
+)
+
+type Opt struct {
+	// SourceRegistry is the registry (with optional namespace) to pull
+	// source images, the `ns` query parameter sent by containerd mirror
+	// is used if empty, default to docker.io.
+	SourceRegistry string
+	SourceInsecure bool
+	// TargetRegistry is the registry (with optional namespace) to push the
+	// converted images to and serve them from.
+	TargetRegistry string
+	TargetInsecure bool
+	// Convert is the template of conversion options, the source and target
+	// references are filled by proxy.
+	Convert converter.Opt
+
+	// JobStateFile persists the conversion jobs, so that the queued and
+	// running jobs are resumed after restart, jobs are kept in memory only
+	// if empty.
+	JobStateFile string
+	// MaxJobs limits the concurrent conversions, the rest jobs are queued,
+	// 0 means no limit.
+	MaxJobs int
+	// TenantQuotas limits the concurrent conversions of each tenant, and
+	// DefaultTenantQuota is used for the tenants not in TenantQuotas, 0
+	// means no limit.
+	TenantQuotas       map[string]int
+	DefaultTenantQuota int
+	// MaxQueuedJobs limits the jobs waiting for a free slot, the new job
+	// is rejected with 429 if the queue is full, 0 means no limit.
+	MaxQueuedJobs int
+}
+
+type Proxy struct {
+	opt   Opt
+	group singleflight.Group
+	jobs  *jobStore
+	sched *scheduler
+	logs  *jobLogs
+	// previews deduplicates the preparations of image previews.
+	previews singleflight.Group
+	// identityKey derives the identities of job credentials.
+	identityKey []byte
+	// access, check and convert are replaceable in test.
+	access  func(ctx context.Context, source string, cred *converter.Credential) error
+	check   func(ctx context.Context, source string, cred *converter.Credential) error
+	convert func(ctx context.Context, opt converter.Opt) error
+}
+
+func New(opt Opt) (*Proxy, error) {
+	if opt.TargetRegistry == "" {
+		return nil, errors.New("target registry is required")
+	}
+	// Create the work directory in advance, otherwise it's removed by the
+	// first finished conversion while others are still running.
+	if err := os.MkdirAll(opt.Convert.WorkDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "prepare work directory")
+	}
+	jobs, err := openJobStore(opt.JobStateFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "open job store")
+	}
+	identityKey := make([]byte, 32)
+	if _, err := rand.Read(identityKey); err != nil {
+		return nil, errors.Wrap(err, "generate identity key")
+	}
+	proxy := &Proxy{
+		opt:         opt,
+		jobs:        jobs,
+		sched:       newScheduler(opt.MaxJobs, opt.TenantQuotas, opt.DefaultTenantQuota, opt.MaxQueuedJobs),
+		logs:        newJobLogs(),
+		identityKey: identityKey,
+		convert:     converter.Convert,
+	}
+	proxy.access = proxy.accessSource
+	proxy.check = proxy.checkSource
+	logrus.AddHook(proxy.logs)
+	return proxy, nil
+}
+
+// Resume restarts the jobs which were queued or running when the proxy
+// exited, the interrupted conversions are started over regardless of the
+// queue limit. The jobs with credentials are failed, as the credentials are
+// lost on exit.
+func (proxy *Proxy) Resume() {
+	for _, job := range proxy.jobs.unfinished() {
+		if job.Credentials {
+			logrus.Warnf("failing %s job converting %s to %s, its credentials are lost on restart", job.State, job.Source, job.Target)
+			proxy.updateJob(job.ID, job.Source, job.Target, func(job *Job) {
+				job.State = JobFailed
+				job.Error = "credentials of job are lost on restart, please submit it again"
+				job.FinishedAt = time.Now()
+			})
+			continue
+		}
+		logrus.Infof("resuming %s job converting %s to %s", job.State, job.Source, job.Target)
+		proxy.submit(job.Source, job.Target, job.Tenant, job.Priority, nil, false)
+	}
+}
+
+// Jobs returns the conversion jobs sorted by creation time.
+func (proxy *Proxy) Jobs() []Job {
+	return proxy.jobs.Jobs()
+}
+
+// jobID returns the ID of job converting to target with the credentials.
+func (proxy *Proxy) jobID(target string, creds *JobCredentials) string {
+	if creds == nil {
+		return target
+	}
+	return target + "#" + creds.identity(proxy.identityKey)
+}
+
+// visibleJobs returns the jobs visible to the caller, the jobs run with
+// credentials are only visible to the caller allowed to access their
+// sources.
+func (proxy *Proxy) visibleJobs(ctx context.Context, cred *converter.Credential) []Job {
+	jobs := []Job{}
+	allowed := map[string]bool{}
+	for _, job := range proxy.Jobs() {
+		if job.Credentials {
+			if _, ok := allowed[job.Source]; !ok {
+				allowed[job.Source] = proxy.authorize(ctx, job.Source, cred) == nil
+			}
+			if !allowed[job.Source] {
+				continue
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// request is a parsed registry API request.
+type request struct {
+	name string
+	// kind is either `manifests` or `blobs`.
+	kind      string
+	reference string
+}
+
+// parseRequest parses path in the form of `/v2/<name>/<kind>/<reference>`.
+func parseRequest(path string) (*request, error) {
+	path = strings.TrimPrefix(path, "/v2/")
+	for _, kind := range []string{"manifests", "blobs"} {
+		idx := strings.LastIndex(path, "/"+kind+"/")
+		if idx <= 0 {
+			continue
+		}
+		req := &request{
+			name:      path[:idx],
+			kind:      kind,
+			reference: path[idx+len(kind)+2:],
+		}
+		if req.reference == "" || strings.Contains(req.reference, "/") {
+			break
+		}
+		if kind == "blobs" {
+			if _, err := digest.Parse(req.reference); err != nil {
+				return nil, errors.Wrapf(err, "invalid blob digest %s", req.reference)
+			}
+		}
+		return req, nil
+	}
+	return nil, fmt.Errorf("unsupported path %s", path)
+}
+
+// isDigest returns true if the manifest is requested by digest.
+func (req *request) isDigest() bool {
+	return strings.Contains(req.reference, ":")
+}
+
+func (req *request) ref(registry string) string {
+	if req.isDigest() {
+		return fmt.Sprintf("%s/%s@%s", registry, req.name, req.reference)
+	}
+	return fmt.Sprintf("%s/%s:%s", registry, req.name, req.reference)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{
+			{"code": code, "message": err.Error()},
+		},
+	})
+}
+
+// writeUnauthorized challenges the caller to send the credential of source
+// registry by basic auth.
+func writeUnauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="nydusify"`)
+	writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", err)
+}
+
+func (proxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == jobsPath {
+		proxy.serveJobs(w, r)
+		return
+	}
+	if r.URL.Path == queuePath {
+		proxy.serveQueue(w, r)
+		return
+	}
+	if r.URL.Path == jobLogsPath {
+		proxy.serveJobLogs(w, r)
+		return
+	}
+	if r.URL.Path == bootstrapPath || r.URL.Path == filesPath {
+		proxy.servePreview(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	if r.URL.Path == "/v2" || r.URL.Path == "/v2/" {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	req, err := parseRequest(r.URL.Path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", err)
+		return
+	}
+
+	ctx := r.Context()
+	if req.kind == "manifests" && !req.isDigest() {
+		// The manifests requested by digest are referenced by converted
+		// index, they are served from target registry directly.
+		source := proxy.opt.SourceRegistry
+		if source == "" {
+			source = r.URL.Query().Get("ns")
+		}
+		if source == "" {
+			source = defaultSourceRegistry
+		}
+		tenant, priority, err := jobClass(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "UNSUPPORTED", err)
+			return
+		}
+		if err := proxy.permit(req.ref(source), req.ref(proxy.opt.TargetRegistry)); err != nil {
+			writeError(w, http.StatusForbidden, "DENIED", err)
+			return
+		}
+		// The caller sending credential by basic auth converts the image
+		// with it, otherwise the credentials of proxy are used.
+		var creds *JobCredentials
+		if cred := callerCredential(r); cred != nil {
+			creds = &JobCredentials{Source: cred}
+		}
+		if err := proxy.ensure(ctx, req.ref(source), req.ref(proxy.opt.TargetRegistry), tenant, priority, creds); err != nil {
+			if errors.Is(err, ErrQueueFull) {
+				writeQueueFull(w)
+				return
+			}
+			if errors.Is(err, errUnauthorized) {
+				writeUnauthorized(w, err)
+				return
+			}
+			if artifactErr, ok := utils.IsArtifactError(err); ok {
+				utils.WarnArtifact(artifactErr)
+			} else {
+				logrus.WithError(err).Errorf("failed to convert %s", req.ref(source))
+			}
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", err)
+			return
+		}
+	}
+
+	if err := proxy.serve(ctx, w, r.Method == http.MethodHead, req.ref(proxy.opt.TargetRegistry)); err != nil {
+		code := "MANIFEST_UNKNOWN"
+		if req.kind == "blobs" {
+			code = "BLOB_UNKNOWN"
+		}
+		status := http.StatusInternalServerError
+		if errdefs.IsNotFound(err) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, code, err)
+	}
+}
+
+// jobClass returns the tenant and priority of the job from request headers,
+// which can be configured in the hosts.toml of containerd registry mirror.
+func jobClass(r *http.Request) (string, int, error) {
+	tenant := r.Header.Get(tenantHeader)
+	priority := 0
+	if value := r.Header.Get(priorityHeader); value != "" {
+		var err error
+		if priority, err = strconv.Atoi(value); err != nil {
+			return "", 0, errors.Wrapf(err, "invalid %s header", priorityHeader)
+		}
+	}
+	return tenant, priority, nil
+}
+
+// JobRequest is the request to submit a conversion job by API.
+type JobRequest struct {
+	// Name is the repository name relative to source registry, for
+	// example `library/nginx`.
+	Name      string `json:"name"`
+	Reference string `json:"reference"`
+	// SourceRegistry overrides the source registry of proxy.
+	SourceRegistry string `json:"source_registry,omitempty"`
+	Tenant         string `json:"tenant,omitempty"`
+	// Priority is the job priority, higher runs first, default to 0.
+	Priority int `json:"priority,omitempty"`
+	// Credentials are used instead of the proxy's to run the job.
+	Credentials *JobCredentials `json:"credentials,omitempty"`
+}
+
+// serveJobs lists the jobs visible to the caller on GET, and submits a job
+// on POST, the job is converted in background.
+func (proxy *Proxy) serveJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proxy.visibleJobs(r.Context(), callerCredential(r)))
+
+	case http.MethodPost:
+		var jobReq JobRequest
+		if err := json.NewDecoder(r.Body).Decode(&jobReq); err != nil {
+			writeError(w, http.StatusBadRequest, "UNSUPPORTED", errors.Wrap(err, "decode job request"))
+			return
+		}
+		req, err := parseRequest(fmt.Sprintf("/v2/%s/manifests/%s", jobReq.Name, jobReq.Reference))
+		if err != nil || req.isDigest() {
+			writeError(w, http.StatusBadRequest, "UNSUPPORTED", fmt.Errorf("invalid image %s:%s", jobReq.Name, jobReq.Reference))
+			return
+		}
+		source := jobReq.SourceRegistry
+		if source == "" {
+			source = proxy.opt.SourceRegistry
+		}
+		if source == "" {
+			source = defaultSourceRegistry
+		}
+		if err := ValidateRegistry(source); err != nil {
+			writeError(w, http.StatusBadRequest, "UNSUPPORTED", err)
+			return
+		}
+		if err := jobReq.Credentials.validate(proxy.opt.Convert); err != nil {
+			writeError(w, http.StatusBadRequest, "UNSUPPORTED", err)
+			return
+		}
+		// The credential sent by basic auth is used to pull source if not
+		// specified in job request.
+		if cred := callerCredential(r); cred != nil {
+			if jobReq.Credentials == nil {
+				jobReq.Credentials = &JobCredentials{}
+			}
+			if jobReq.Credentials.Source == nil {
+				jobReq.Credentials.Source = cred
+			}
+		}
+		sourceRef, targetRef := req.ref(source), req.ref(proxy.opt.TargetRegistry)
+		if err := proxy.permit(sourceRef, targetRef); err != nil {
+			writeError(w, http.StatusForbidden, "DENIED", err)
+			return
+		}
+		// The request joining an active job doesn't grow the queue.
+		if proxy.sched.full() && !proxy.jobs.active(proxy.jobID(targetRef, jobReq.Credentials)) {
+			writeQueueFull(w)
+			return
+		}
+		go func() {
+			if err := proxy.ensure(context.Background(), sourceRef, targetRef, jobReq.Tenant, jobReq.Priority, jobReq.Credentials); err != nil {
+				logrus.WithError(err).Errorf("failed to convert %s", sourceRef)
+			}
+		}()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"id":     proxy.jobID(targetRef, jobReq.Credentials),
+			"source": sourceRef,
+			"target": targetRef,
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", fmt.Errorf("unsupported method %s", r.Method))
+	}
+}
+
+// writeQueueFull rejects the request as the conversion queue is full, the
+// client is expected to retry after a while.
+func writeQueueFull(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(queueRetryAfter))
+	writeError(w, http.StatusTooManyRequests, "TOOMANYREQUESTS", ErrQueueFull)
+}
+
+// QueueDepth is the depth of conversion queue returned by API.
+type QueueDepth struct {
+	Running int `json:"running"`
+	Queued  int `json:"queued"`
+	// MaxJobs and MaxQueued are the limits of running and queued jobs, 0
+	// means no limit.
+	MaxJobs   int `json:"max_jobs"`
+	MaxQueued int `json:"max_queued"`
+}
+
+// serveQueue returns the depth of conversion queue on GET, so that the
+// event sources can slow down before the jobs are rejected.
+func (proxy *Proxy) serveQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	running, queued := proxy.sched.depth()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(QueueDepth{
+		Running:   running,
+		Queued:    queued,
+		MaxJobs:   proxy.opt.MaxJobs,
+		MaxQueued: proxy.opt.MaxQueuedJobs,
+	})
+}
+
+// permit refuses the images denied by the allowlist or denylist of policy
+// before the job is queued.
+func (proxy *Proxy) permit(refs ...string) error {
+	for _, ref := range refs {
+		if err := proxy.opt.Convert.Policy.Permit(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensure converts the source image to target if the target doesn't exist,
+// the concurrent requests of the same image with the same credentials share
+// one conversion. The existing target is only returned to the caller allowed
+// to access the source, as it may be converted with the credentials of
+// others.
+func (proxy *Proxy) ensure(ctx context.Context, source, target, tenant string, priority int, creds *JobCredentials) error {
+	var sourceCred, targetCred *converter.Credential
+	if creds != nil {
+		sourceCred, targetCred = creds.Source, creds.Target
+	}
+	if _, _, err := proxy.resolve(ctx, target, targetCred); err == nil {
+		return proxy.authorize(ctx, source, sourceCred)
+	} else if !errdefs.IsNotFound(err) {
+		return creds.redact(err)
+	}
+
+	ch := proxy.submit(source, target, tenant, priority, creds, true)
+
+	select {
+	case res := <-ch:
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// submit starts the job converting source to target, the same job is only
+// run once at a time, the job still waiting is raised to the priority. The
+// job is rejected with ErrQueueFull if bounded and the queue is full.
+func (proxy *Proxy) submit(source, target, tenant string, priority int, creds *JobCredentials, bounded bool) <-chan singleflight.Result {
+	id := proxy.jobID(target, creds)
+	if proxy.sched.raise(id, priority) {
+		proxy.updateJob(id, source, target, func(job *Job) {
+			if priority > job.Priority {
+				job.Priority = priority
+			}
+		})
+	}
+	return proxy.group.DoChan(id, func() (interface{}, error) {
+		// Don't cancel the conversion shared by other requests when the
+		// client disconnects.
+		return nil, proxy.run(context.Background(), source, target, tenant, priority, creds, bounded)
+	})
+}
+
+func (proxy *Proxy) updateJob(id, source, target string, fn func(job *Job)) {
+	if err := proxy.jobs.update(id, source, target, fn); err != nil {
+		logrus.WithError(err).Warnf("failed to persist job state of %s", target)
+	}
+}
+
+// run waits for a free slot and converts the image, the job state is
+// recorded at each step, and the secrets of credentials are redacted from
+// the error.
+func (proxy *Proxy) run(ctx context.Context, source, target, tenant string, priority int, creds *JobCredentials, bounded bool) error {
+	// The rejected job isn't recorded.
+	id := proxy.jobID(target, creds)
+	w, err := proxy.sched.enqueue(id, tenant, priority, bounded)
+	if err != nil {
+		return err
+	}
+	proxy.logs.start(id, source, target, creds)
+	proxy.updateJob(id, source, target, func(job *Job) {
+		job.State = JobQueued
+		job.Error = ""
+		job.Tenant = tenant
+		job.Priority = priority
+		job.Credentials = creds != nil
+	})
+	if err := proxy.sched.wait(ctx, w); err != nil {
+		proxy.logs.finish(id, err)
+		return err
+	}
+	defer proxy.sched.release(tenant)
+	proxy.logs.activate(id)
+	proxy.updateJob(id, source, target, func(job *Job) {
+		job.State = JobRunning
+		job.Attempts++
+		job.StartedAt = time.Now()
+	})
+
+	err = creds.redact(proxy.doConvert(ctx, source, target, creds))
+
+	proxy.updateJob(id, source, target, func(job *Job) {
+		job.State = JobSucceeded
+		if err != nil {
+			job.State = JobFailed
+			job.Error = err.Error()
+		}
+		job.FinishedAt = time.Now()
+	})
+	proxy.logs.finish(id, err)
+	return err
+}
+
+func (proxy *Proxy) doConvert(ctx context.Context, source, target string, creds *JobCredentials) error {
+	opt := proxy.opt.Convert
+	opt.Source = source
+	opt.SourceInsecure = proxy.opt.SourceInsecure
+	opt.Target = target
+	opt.TargetInsecure = proxy.opt.TargetInsecure
+	creds.apply(&opt)
+	if err := proxy.check(ctx, source, opt.SourceCredential); err != nil {
+		return err
+	}
+	logger := logrus.WithField("job", target)
+	logger.Infof("converting %s to %s", source, target)
+	if err := proxy.convert(ctx, opt); err != nil {
+		return err
+	}
+	logger.Infof("converted %s to %s", source, target)
+	return nil
+}
+
+// authorize returns errUnauthorized if the caller with cred isn't allowed to
+// access the source, the credentials of proxy are used if cred is nil.
+func (proxy *Proxy) authorize(ctx context.Context, source string, cred *converter.Credential) error {
+	if err := proxy.access(ctx, source, cred); err != nil {
+		logrus.WithError(err).Debugf("denied access to %s", source)
+		return errors.Wrapf(errUnauthorized, "access %s", source)
+	}
+	return nil
+}
+
+// accessSource resolves the source with cred to check it's accessible.
+func (proxy *Proxy) accessSource(ctx context.Context, source string, cred *converter.Credential) error {
+	remoter, err := newRemote(source, proxy.opt.SourceInsecure, cred, proxy.opt.Convert.Credentials)
+	if err != nil {
+		return errors.Wrap(err, "create remote")
+	}
+	_, err = remoter.Resolve(ctx)
+	if err != nil && utils.RetryWithHTTP(err) {
+		remoter.MaybeWithHTTP(err)
+		_, err = remoter.Resolve(ctx)
+	}
+	return err
+}
+
+// checkSource returns ArtifactError if the source is a non-image artifact,
+// which can't be converted.
+func (proxy *Proxy) checkSource(ctx context.Context, source string, cred *converter.Credential) error {
+	remoter, err := newRemote(source, proxy.opt.SourceInsecure, cred, proxy.opt.Convert.Credentials)
+	if err != nil {
+		return errors.Wrap(err, "create remote")
+	}
+	desc, err := remoter.Resolve(ctx)
+	if err != nil && utils.RetryWithHTTP(err) {
+		remoter.MaybeWithHTTP(err)
+		desc, err = remoter.Resolve(ctx)
+	}
+	if err != nil {
+		return errors.Wrap(err, "resolve source image")
+	}
+	if desc.MediaType != ocispec.MediaTypeImageManifest {
+		return nil
+	}
+
+	reader, err := remoter.Pull(ctx, *desc, true)
+	if err != nil {
+		return errors.Wrap(err, "pull source manifest")
+	}
+	defer reader.Close()
+	var manifest ocispec.Manifest
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+		return errors.Wrap(err, "decode source manifest")
+	}
+	if artifactType := utils.ArtifactType(&manifest); artifactType != "" {
+		return &utils.ArtifactError{Ref: source, ArtifactType: artifactType}
+	}
+	return nil
+}
+
+func (proxy *Proxy) resolve(ctx context.Context, ref string, cred *converter.Credential) (*remote.Remote, *ocispec.Descriptor, error) {
+	remoter, err := newRemote(ref, proxy.opt.TargetInsecure, cred, proxy.opt.Convert.Credentials)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create remote")
+	}
+	desc, err := remoter.Resolve(ctx)
+	if err != nil && utils.RetryWithHTTP(err) {
+		remoter.MaybeWithHTTP(err)
+		desc, err = remoter.Resolve(ctx)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return remoter, desc, nil
+}
+
+// serve writes the manifest or blob in target registry to response.
+func (proxy *Proxy) serve(ctx context.Context, w http.ResponseWriter, head bool, ref string) error {
+	remoter, desc, err := proxy.resolve(ctx, ref, nil)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", desc.MediaType)
+	w.Header().Set("Content-Length", strconv.FormatInt(desc.Size, 10))
+	w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+	if head {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	reader, err := remoter.Pull(ctx, *desc, true)
+	if err != nil {
+		return errors.Wrap(err, "pull from target registry")
+	}
+	defer reader.Close()
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, reader); err != nil {
+		// The response has been started, it's too late to report error.
+		logrus.WithError(err).Warnf("failed to serve %s", ref)
+	}
+	return nil
+}
+
+// ValidateRegistry checks the registry (with optional namespace) is valid.
+func ValidateRegistry(registry string) error {
+	if _, err := reference.ParseNormalizedNamed(registry + "/image"); err != nil {
+		return errors.Wrapf(err, "invalid registry %s", registry)
+	}
+	return nil
+}
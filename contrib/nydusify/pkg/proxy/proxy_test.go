@@ -0,0 +1,146 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/converter"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+)
+
+func TestParseRequest(t *testing.T) {
+	req, err := parseRequest("/v2/library/nginx/manifests/latest")
+	require.NoError(t, err)
+	require.Equal(t, &request{name: "library/nginx", kind: "manifests", reference: "latest"}, req)
+	require.False(t, req.isDigest())
+	require.Equal(t, "localhost:5000/nydus/library/nginx:latest", req.ref("localhost:5000/nydus"))
+
+	dgst := digest.FromString("manifest")
+	req, err = parseRequest("/v2/library/nginx/manifests/" + dgst.String())
+	require.NoError(t, err)
+	require.True(t, req.isDigest())
+	require.Equal(t, "localhost:5000/library/nginx@"+dgst.String(), req.ref("localhost:5000"))
+
+	req, err = parseRequest("/v2/a/blobs/b/blobs/" + dgst.String())
+	require.NoError(t, err)
+	require.Equal(t, &request{name: "a/blobs/b", kind: "blobs", reference: dgst.String()}, req)
+
+	_, err = parseRequest("/v2/library/nginx/blobs/latest")
+	require.Error(t, err)
+	_, err = parseRequest("/v2/library/nginx/tags/list")
+	require.Error(t, err)
+	_, err = parseRequest("/v2/manifests/latest")
+	require.Error(t, err)
+}
+
+func TestServeHTTP(t *testing.T) {
+	_, err := New(Opt{})
+	require.Error(t, err)
+
+	workDir := filepath.Join(t.TempDir(), "work")
+	proxy, err := New(Opt{
+		TargetRegistry: "localhost:5000",
+		Convert:        converter.Opt{WorkDir: workDir},
+	})
+	require.NoError(t, err)
+	require.DirExists(t, workDir)
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "registry/2.0", rec.Header().Get("Docker-Distribution-API-Version"))
+
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/v2/library/nginx/manifests/latest", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/_catalog", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Contains(t, rec.Body.String(), "NAME_UNKNOWN")
+
+	// The image denied by policy is refused before conversion.
+	proxy.opt.Convert.Policy = &converter.Policy{Deny: []string{"docker.io/secret/**"}}
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/secret/app/manifests/latest", nil))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Contains(t, rec.Body.String(), "DENIED")
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, jobsPath, strings.NewReader(`{"name": "secret/app", "reference": "latest"}`)))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, proxy.Jobs())
+}
+
+func TestQueueFull(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+
+	pxy, err := New(Opt{
+		TargetRegistry: registry.Host(),
+		Convert:        converter.Opt{WorkDir: filepath.Join(t.TempDir(), "work")},
+		MaxJobs:        1,
+		MaxQueuedJobs:  1,
+	})
+	require.NoError(t, err)
+	pxy.check = func(context.Context, string, *converter.Credential) error { return nil }
+	release := make(chan struct{})
+	pxy.convert = func(context.Context, converter.Opt) error {
+		<-release
+		return nil
+	}
+
+	done := make(chan error, 2)
+	for _, name := range []string{"running", "queued"} {
+		go func(name string) {
+			done <- pxy.run(context.Background(), "docker.io/library/"+name+":latest", registry.Host()+"/library/"+name+":latest", "", 0, nil, true)
+		}(name)
+	}
+	require.Eventually(t, func() bool {
+		running, queued := pxy.sched.depth()
+		return running == 1 && queued == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	pxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queuePath, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var depth QueueDepth
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&depth))
+	require.Equal(t, QueueDepth{Running: 1, Queued: 1, MaxJobs: 1, MaxQueued: 1}, depth)
+
+	// The new jobs are rejected rather than queued.
+	rec = httptest.NewRecorder()
+	pxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/library/nginx/manifests/latest", nil))
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "30", rec.Header().Get("Retry-After"))
+	require.Contains(t, rec.Body.String(), "TOOMANYREQUESTS")
+	rec = httptest.NewRecorder()
+	pxy.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, jobsPath, strings.NewReader(`{"name": "library/nginx", "reference": "latest"}`)))
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Len(t, pxy.Jobs(), 2)
+	rec = httptest.NewRecorder()
+	pxy.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, jobsPath, strings.NewReader(`{"name": "library/queued", "reference": "latest", "priority": 10}`)))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	close(release)
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+}
+
+func TestValidateRegistry(t *testing.T) {
+	require.NoError(t, ValidateRegistry("docker.io"))
+	require.NoError(t, ValidateRegistry("localhost:5000/nydus"))
+	require.Error(t, ValidateRegistry("Invalid Registry"))
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrQueueFull is returned if the job can't run immediately and the waiting
+// queue is full.
+var ErrQueueFull = errors.New("conversion queue is full")
+
+// waiter is a job waiting for a slot to run.
+type waiter struct {
+	key      string
+	tenant   string
+	priority int
+	// seq keeps FIFO order for the jobs with same priority.
+	seq   uint64
+	ready chan struct{}
+}
+
+// scheduler limits the running jobs in total and per tenant, the waiting
+// job with higher priority runs first, so that the urgent conversions are
+// not stuck behind the bulk backfills.
+type scheduler struct {
+	mutex sync.Mutex
+	// maxJobs limits the running jobs in total, 0 means no limit.
+	maxJobs int
+	// quotas limits the running jobs of each tenant, defaultQuota is used
+	// for the tenants not in quotas, 0 means no limit.
+	quotas       map[string]int
+	defaultQuota int
+	// maxQueued limits the waiting jobs, 0 means no limit.
+	maxQueued int
+
+	running  int
+	tenants  map[string]int
+	waiters  []*waiter
+	sequence uint64
+}
+
+func newScheduler(maxJobs int, quotas map[string]int, defaultQuota, maxQueued int) *scheduler {
+	return &scheduler{
+		maxJobs:      maxJobs,
+		quotas:       quotas,
+		defaultQuota: defaultQuota,
+		maxQueued:    maxQueued,
+		tenants:      map[string]int{},
+	}
+}
+
+func (sched *scheduler) quota(tenant string) int {
+	if quota, ok := sched.quotas[tenant]; ok {
+		return quota
+	}
+	return sched.defaultQuota
+}
+
+// dispatch starts the waiting jobs as long as there are free slots, the
+// caller must hold the mutex.
+func (sched *scheduler) dispatch() {
+	for sched.maxJobs <= 0 || sched.running < sched.maxJobs {
+		var next *waiter
+		nextIdx := -1
+		for idx, w := range sched.waiters {
+			if quota := sched.quota(w.tenant); quota > 0 && sched.tenants[w.tenant] >= quota {
+				continue
+			}
+			if next == nil || w.priority > next.priority ||
+				(w.priority == next.priority && w.seq < next.seq) {
+				next, nextIdx = w, idx
+			}
+		}
+		if next == nil {
+			return
+		}
+		sched.waiters = append(sched.waiters[:nextIdx], sched.waiters[nextIdx+1:]...)
+		sched.running++
+		sched.tenants[next.tenant]++
+		close(next.ready)
+	}
+}
+
+// acquire waits until the job of tenant can run, release must be called
+// after the job finishes if no error returned.
+func (sched *scheduler) acquire(ctx context.Context, key, tenant string, priority int) error {
+	w, err := sched.enqueue(key, tenant, priority, false)
+	if err != nil {
+		return err
+	}
+	return sched.wait(ctx, w)
+}
+
+// enqueue queues the job of tenant, it returns ErrQueueFull if bounded and
+// the job would exceed the limit of waiting jobs, wait must be called for
+// the returned waiter.
+func (sched *scheduler) enqueue(key, tenant string, priority int, bounded bool) (*waiter, error) {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	sched.sequence++
+	w := &waiter{
+		key:      key,
+		tenant:   tenant,
+		priority: priority,
+		seq:      sched.sequence,
+		ready:    make(chan struct{}),
+	}
+	sched.waiters = append(sched.waiters, w)
+	sched.dispatch()
+	if bounded && sched.maxQueued > 0 && len(sched.waiters) > sched.maxQueued {
+		// The job isn't dispatched, otherwise it's not waiting anymore.
+		for idx := range sched.waiters {
+			if sched.waiters[idx] == w {
+				sched.waiters = append(sched.waiters[:idx], sched.waiters[idx+1:]...)
+				return nil, ErrQueueFull
+			}
+		}
+	}
+	return w, nil
+}
+
+// wait waits until the queued job can run.
+func (sched *scheduler) wait(ctx context.Context, w *waiter) error {
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		sched.mutex.Lock()
+		defer sched.mutex.Unlock()
+		for idx := range sched.waiters {
+			if sched.waiters[idx] == w {
+				sched.waiters = append(sched.waiters[:idx], sched.waiters[idx+1:]...)
+				return ctx.Err()
+			}
+		}
+		// The job has been dispatched in the meantime.
+		sched.releaseLocked(w.tenant)
+		return ctx.Err()
+	}
+}
+
+func (sched *scheduler) releaseLocked(tenant string) {
+	sched.running--
+	sched.tenants[tenant]--
+	if sched.tenants[tenant] <= 0 {
+		delete(sched.tenants, tenant)
+	}
+	sched.dispatch()
+}
+
+// release frees the slot of the finished job.
+func (sched *scheduler) release(tenant string) {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	sched.releaseLocked(tenant)
+}
+
+// full returns true if the waiting queue is full.
+func (sched *scheduler) full() bool {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	return sched.maxQueued > 0 && len(sched.waiters) >= sched.maxQueued
+}
+
+// depth returns the number of running and waiting jobs.
+func (sched *scheduler) depth() (int, int) {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	return sched.running, len(sched.waiters)
+}
+
+// raise increases the priority of the waiting job, it returns false if
+// the job is not waiting.
+func (sched *scheduler) raise(key string, priority int) bool {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	for _, w := range sched.waiters {
+		if w.key == key {
+			if priority > w.priority {
+				w.priority = priority
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// waitFor waits until count jobs are queued in scheduler.
+func waitFor(t *testing.T, sched *scheduler, count int) {
+	require.Eventually(t, func() bool {
+		sched.mutex.Lock()
+		defer sched.mutex.Unlock()
+		return len(sched.waiters) == count
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	sched := newScheduler(1, nil, 0, 0)
+	ctx := context.Background()
+	require.NoError(t, sched.acquire(ctx, "running", "", 0))
+
+	started := make(chan string, 3)
+	for idx, job := range []struct {
+		key      string
+		priority int
+	}{{"backfill-1", -1}, {"backfill-2", -1}, {"urgent", 10}} {
+		go func(key string, priority int) {
+			require.NoError(t, sched.acquire(ctx, key, "", priority))
+			started <- key
+		}(job.key, job.priority)
+		// Queue the jobs in order.
+		waitFor(t, sched, idx+1)
+	}
+	require.True(t, sched.raise("backfill-2", 0))
+	require.False(t, sched.raise("unknown", 0))
+
+	for _, expected := range []string{"urgent", "backfill-2", "backfill-1"} {
+		sched.release("")
+		require.Equal(t, expected, <-started)
+	}
+}
+
+func TestSchedulerQuota(t *testing.T) {
+	sched := newScheduler(0, map[string]int{"prod": 2}, 1, 0)
+	ctx := context.Background()
+
+	require.NoError(t, sched.acquire(ctx, "prod-1", "prod", 0))
+	require.NoError(t, sched.acquire(ctx, "prod-2", "prod", 0))
+	require.NoError(t, sched.acquire(ctx, "batch-1", "batch", 0))
+
+	// The tenant batch is over quota, while others are not affected.
+	started := make(chan string, 1)
+	go func() {
+		require.NoError(t, sched.acquire(ctx, "batch-2", "batch", 100))
+		started <- "batch-2"
+	}()
+	waitFor(t, sched, 1)
+	require.NoError(t, sched.acquire(ctx, "other-1", "other", 0))
+
+	sched.release("batch")
+	require.Equal(t, "batch-2", <-started)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, sched.acquire(timeoutCtx, "batch-3", "batch", 0), context.DeadlineExceeded)
+	waitFor(t, sched, 0)
+}
+
+func TestSchedulerQueueLimit(t *testing.T) {
+	sched := newScheduler(1, nil, 0, 1)
+	ctx := context.Background()
+
+	w, err := sched.enqueue("running", "", 0, true)
+	require.NoError(t, err)
+	require.NoError(t, sched.wait(ctx, w))
+	require.False(t, sched.full())
+	queued, err := sched.enqueue("queued", "", 0, true)
+	require.NoError(t, err)
+	require.True(t, sched.full())
+
+	_, err = sched.enqueue("rejected", "", 0, true)
+	require.ErrorIs(t, err, ErrQueueFull)
+	running, waiting := sched.depth()
+	require.Equal(t, 1, running)
+	require.Equal(t, 1, waiting)
+
+	// The unbounded job, e.g. resumed one, is always queued.
+	resumed, err := sched.enqueue("resumed", "", 0, false)
+	require.NoError(t, err)
+	waitFor(t, sched, 2)
+
+	sched.release("")
+	require.NoError(t, sched.wait(ctx, queued))
+	sched.release("")
+	require.NoError(t, sched.wait(ctx, resumed))
+	running, waiting = sched.depth()
+	require.Equal(t, 1, running)
+	require.Equal(t, 0, waiting)
+}
@@ -0,0 +1,441 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package recompressor rewrites the blobs of an existing Nydus image with
+// another compressor or chunk size, without pulling the original OCI image.
+// Each Nydus blob layer is unpacked to an OCI tar stream by `nydus-image
+// unpack`, and packed again with the new options, then the bootstraps are
+// merged to the new image.
+package recompressor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/dustin/go-humanize"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/compat"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// Opt defines recompression options, an empty compressor or chunk size
+// keeps the one of source image.
+type Opt struct {
+	WorkDir        string
+	NydusImagePath string
+	ExpectedArch   string
+
+	Source         string
+	SourceInsecure bool
+	Target         string
+	TargetInsecure bool
+
+	Compressor string
+	ChunkSize  string
+}
+
+// Recompressor rewrites the blobs of Nydus image.
+type Recompressor struct {
+	Opt
+	sourceParser *parser.Parser
+	targetRemote *remote.Remote
+}
+
+// Blob is a Nydus blob rebuilt in work directory.
+type Blob struct {
+	Path string
+	Desc ocispec.Descriptor
+}
+
+// New creates Recompressor instance.
+func New(opt Opt) (*Recompressor, error) {
+	if opt.Compressor == "" && opt.ChunkSize == "" {
+		return nil, errors.New("at least one of compressor and chunk size should be specified")
+	}
+	sourceRemote, err := provider.DefaultRemote(opt.Source, opt.SourceInsecure)
+	if err != nil {
+		return nil, errors.Wrap(err, "create source image provider")
+	}
+	sourceParser, err := parser.New(sourceRemote, opt.ExpectedArch)
+	if err != nil {
+		return nil, errors.Wrap(err, "create source image parser")
+	}
+	targetRemote, err := provider.DefaultRemote(opt.Target, opt.TargetInsecure)
+	if err != nil {
+		return nil, errors.Wrap(err, "create target image provider")
+	}
+	return &Recompressor{
+		Opt:          opt,
+		sourceParser: sourceParser,
+		targetRemote: targetRemote,
+	}, nil
+}
+
+func (rc *Recompressor) parse(ctx context.Context) (*parser.Image, error) {
+	parsed, err := rc.sourceParser.Parse(ctx)
+	if err != nil {
+		if !utils.RetryWithHTTP(err) {
+			return nil, errors.Wrap(err, "parse source image")
+		}
+		rc.sourceParser.Remote.MaybeWithHTTP(err)
+		if parsed, err = rc.sourceParser.Parse(ctx); err != nil {
+			return nil, errors.Wrap(err, "parse source image")
+		}
+	}
+	if parsed.NydusImage == nil {
+		return nil, fmt.Errorf("not a nydus image: %s", rc.Source)
+	}
+
+	// The blobs can only be rebuilt from their own data, the chunks
+	// referenced from chunk dict or OCI layers can't be unpacked.
+	features := compat.DetectFeatures(&parsed.NydusImage.Manifest, nil)
+	if features.ChunkDict || features.OCIRef || features.Encrypted {
+		return nil, fmt.Errorf(
+			"recompressing nydus image with chunk dict, OCI ref or encryption is unsupported: %s", rc.Source,
+		)
+	}
+
+	return parsed.NydusImage, nil
+}
+
+// Recompress rewrites the blobs of source image, and pushes the new image
+// to target.
+func (rc *Recompressor) Recompress(ctx context.Context) error {
+	start := time.Now()
+	image, err := rc.parse(ctx)
+	if err != nil {
+		return err
+	}
+	bootstrapDesc := parser.FindNydusBootstrapDesc(&image.Manifest)
+	if bootstrapDesc == nil {
+		return fmt.Errorf("not found nydus bootstrap layer in %s", rc.Source)
+	}
+	fsVersion := "5"
+	if utils.GetNydusFsVersionOrDefault(bootstrapDesc.Annotations, utils.V5) == utils.V6 {
+		fsVersion = "6"
+	}
+
+	if err := os.MkdirAll(rc.WorkDir, 0755); err != nil {
+		return errors.Wrap(err, "create work directory")
+	}
+	workDir, err := os.MkdirTemp(rc.WorkDir, "nydusify-recompress-")
+	if err != nil {
+		return errors.Wrap(err, "create temp directory")
+	}
+	defer os.RemoveAll(workDir)
+
+	blobs := []Blob{}
+	for _, layer := range image.Manifest.Layers {
+		if layer.MediaType != utils.MediaTypeNydusBlob {
+			continue
+		}
+		blob, err := rc.rebuildBlob(ctx, workDir, layer, fsVersion)
+		if err != nil {
+			return errors.Wrapf(err, "rebuild blob %s", layer.Digest)
+		}
+		blobs = append(blobs, *blob)
+	}
+
+	bootstrapPath := filepath.Join(workDir, "bootstrap.tar")
+	blobDigests, err := rc.mergeBootstrap(ctx, workDir, blobs, bootstrapPath, fsVersion)
+	if err != nil {
+		return errors.Wrap(err, "merge bootstraps")
+	}
+
+	// The empty blobs are not referenced by merged bootstrap.
+	referenced := map[digest.Digest]bool{}
+	for _, dgst := range blobDigests {
+		referenced[dgst] = true
+	}
+	blobDescs := []ocispec.Descriptor{}
+	for _, blob := range blobs {
+		if !referenced[blob.Desc.Digest] {
+			continue
+		}
+		if err := rc.pushFile(ctx, blob.Desc, blob.Path); err != nil {
+			return errors.Wrapf(err, "push blob %s", blob.Desc.Digest)
+		}
+		blobDescs = append(blobDescs, blob.Desc)
+	}
+
+	newBootstrapDesc, bootstrapDiffID, err := rc.pushBootstrap(ctx, bootstrapPath, fsVersion)
+	if err != nil {
+		return errors.Wrap(err, "push bootstrap layer")
+	}
+
+	config, manifest := rc.makeImage(image, blobDescs, *newBootstrapDesc, bootstrapDiffID)
+	configBytes, configDesc, err := makeDesc(config, image.Manifest.Config)
+	if err != nil {
+		return errors.Wrap(err, "make config desc")
+	}
+	if err := rc.push(ctx, *configDesc, true, func() io.Reader { return bytes.NewReader(configBytes) }); err != nil {
+		return errors.Wrap(err, "push image config")
+	}
+	manifest.Config = *configDesc
+	manifestBytes, manifestDesc, err := makeDesc(manifest, image.Desc)
+	if err != nil {
+		return errors.Wrap(err, "make manifest desc")
+	}
+	if err := rc.push(ctx, *manifestDesc, false, func() io.Reader { return bytes.NewReader(manifestBytes) }); err != nil {
+		return errors.Wrap(err, "push image manifest")
+	}
+
+	logrus.Infof("Recompressed image %s to %s, elapsed: %s", rc.Source, rc.Target, time.Since(start))
+
+	return nil
+}
+
+// rebuildBlob unpacks the Nydus blob layer to an OCI tar stream and packs
+// it again with the new compressor and chunk size.
+func (rc *Recompressor) rebuildBlob(ctx context.Context, workDir string, layer ocispec.Descriptor, fsVersion string) (*Blob, error) {
+	start := time.Now()
+	logrus.Infof("Rebuilding blob %s", layer.Digest)
+
+	sourcePath := filepath.Join(workDir, layer.Digest.Encoded()+".source")
+	if err := rc.pullFile(ctx, layer, sourcePath); err != nil {
+		return nil, errors.Wrap(err, "pull blob")
+	}
+	defer os.Remove(sourcePath)
+	ra, err := local.OpenReader(sourcePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open reader for source blob")
+	}
+	defer ra.Close()
+
+	blobPath := filepath.Join(workDir, layer.Digest.Encoded()+".blob")
+	file, err := os.Create(blobPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "create blob file")
+	}
+	defer file.Close()
+
+	digester := digest.SHA256.Digester()
+	tw, err := converter.Pack(ctx, io.MultiWriter(file, digester.Hash()), converter.PackOption{
+		WorkDir:     workDir,
+		BuilderPath: rc.NydusImagePath,
+		FsVersion:   fsVersion,
+		Compressor:  rc.Compressor,
+		ChunkSize:   rc.ChunkSize,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "initialize pack to blob")
+	}
+	if err := converter.Unpack(ctx, ra, tw, converter.UnpackOption{
+		WorkDir:     workDir,
+		BuilderPath: rc.NydusImagePath,
+	}); err != nil {
+		tw.Close()
+		return nil, errors.Wrap(err, "unpack blob to tar")
+	}
+	if err := tw.Close(); err != nil {
+		return nil, errors.Wrap(err, "pack tar to blob")
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "stat blob file")
+	}
+	blobDigest := digester.Digest()
+	logrus.Infof(
+		"Rebuilt blob %s to %s, size: %s -> %s, elapsed: %s", layer.Digest, blobDigest,
+		humanize.Bytes(uint64(layer.Size)), humanize.Bytes(uint64(info.Size())), time.Since(start),
+	)
+
+	return &Blob{
+		Path: blobPath,
+		Desc: ocispec.Descriptor{
+			Digest:    blobDigest,
+			Size:      info.Size(),
+			MediaType: utils.MediaTypeNydusBlob,
+			Annotations: map[string]string{
+				utils.LayerAnnotationUncompressed: blobDigest.String(),
+				utils.LayerAnnotationNydusBlob:    "true",
+			},
+		},
+	}, nil
+}
+
+func (rc *Recompressor) mergeBootstrap(
+	ctx context.Context, workDir string, blobs []Blob, bootstrapPath, fsVersion string,
+) ([]digest.Digest, error) {
+	layers := []converter.Layer{}
+	for _, blob := range blobs {
+		ra, err := local.OpenReader(blob.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, "open reader for blob")
+		}
+		defer ra.Close()
+		layers = append(layers, converter.Layer{
+			Digest:   blob.Desc.Digest,
+			ReaderAt: ra,
+		})
+	}
+
+	bootstrap, err := os.Create(bootstrapPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "create bootstrap file")
+	}
+	defer bootstrap.Close()
+
+	return converter.Merge(ctx, layers, bootstrap, converter.MergeOption{
+		WorkDir:     workDir,
+		BuilderPath: rc.NydusImagePath,
+		FsVersion:   fsVersion,
+		WithTar:     true,
+	})
+}
+
+// pushBootstrap compresses the bootstrap tar to tar.gz and pushes it, the
+// digest of uncompressed bootstrap tar is returned as the diff id.
+func (rc *Recompressor) pushBootstrap(ctx context.Context, bootstrapPath, fsVersion string) (*ocispec.Descriptor, digest.Digest, error) {
+	bootstrap, err := os.Open(bootstrapPath)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "open bootstrap tar file")
+	}
+	defer bootstrap.Close()
+
+	gzPath := bootstrapPath + ".gz"
+	gzFile, err := os.Create(gzPath)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "create bootstrap tar.gz file")
+	}
+	defer gzFile.Close()
+
+	diffIDDigester := digest.SHA256.Digester()
+	digester := digest.SHA256.Digester()
+	gzWriter := gzip.NewWriter(io.MultiWriter(gzFile, digester.Hash()))
+	if _, err := io.Copy(io.MultiWriter(gzWriter, diffIDDigester.Hash()), bootstrap); err != nil {
+		return nil, "", errors.Wrap(err, "compress bootstrap tar to tar.gz")
+	}
+	if err := gzWriter.Close(); err != nil {
+		return nil, "", errors.Wrap(err, "close gzip writer")
+	}
+	info, err := gzFile.Stat()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "stat bootstrap tar.gz file")
+	}
+
+	desc := ocispec.Descriptor{
+		Digest:    digester.Digest(),
+		Size:      info.Size(),
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Annotations: map[string]string{
+			utils.LayerAnnotationNydusFsVersion: fsVersion,
+			utils.LayerAnnotationNydusBootstrap: "true",
+		},
+	}
+	if err := rc.pushFile(ctx, desc, gzPath); err != nil {
+		return nil, "", err
+	}
+
+	return &desc, diffIDDigester.Digest(), nil
+}
+
+// makeImage makes the config and manifest of new image from the source
+// image, the history and other config fields are kept.
+func (rc *Recompressor) makeImage(
+	image *parser.Image, blobDescs []ocispec.Descriptor, bootstrapDesc ocispec.Descriptor, bootstrapDiffID digest.Digest,
+) (ocispec.Image, ocispec.Manifest) {
+	config := image.Config
+	config.RootFS.DiffIDs = []digest.Digest{}
+	for _, desc := range blobDescs {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, desc.Digest)
+	}
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, bootstrapDiffID)
+
+	manifest := image.Manifest
+	manifest.Layers = append(append([]ocispec.Descriptor{}, blobDescs...), bootstrapDesc)
+	manifest.Annotations = map[string]string{}
+	for key, value := range image.Manifest.Annotations {
+		manifest.Annotations[key] = value
+	}
+	if _, ok := manifest.Annotations[utils.ManifestNydusBootstrap]; ok {
+		manifest.Annotations[utils.ManifestNydusBootstrap] = bootstrapDesc.Digest.String()
+	}
+	if rc.Compressor != "" {
+		manifest.Annotations[utils.ManifestNydusCompressor] = rc.Compressor
+	}
+	if rc.ChunkSize != "" {
+		manifest.Annotations[utils.ManifestNydusChunkSize] = rc.ChunkSize
+	}
+
+	return config, manifest
+}
+
+func makeDesc(x interface{}, oldDesc ocispec.Descriptor) ([]byte, *ocispec.Descriptor, error) {
+	data, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "json marshal")
+	}
+
+	newDesc := oldDesc
+	newDesc.Size = int64(len(data))
+	newDesc.Digest = digest.FromBytes(data)
+
+	return data, &newDesc, nil
+}
+
+func (rc *Recompressor) pullFile(ctx context.Context, desc ocispec.Descriptor, path string) error {
+	reader, err := rc.sourceParser.Remote.Pull(ctx, desc, true)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "create file")
+	}
+	defer file.Close()
+
+	verifier := desc.Digest.Verifier()
+	if _, err := io.Copy(io.MultiWriter(file, verifier), reader); err != nil {
+		return errors.Wrap(err, "copy blob")
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("digest mismatch for blob %s", desc.Digest)
+	}
+
+	return nil
+}
+
+func (rc *Recompressor) pushFile(ctx context.Context, desc ocispec.Descriptor, path string) error {
+	ra, err := local.OpenReader(path)
+	if err != nil {
+		return errors.Wrapf(err, "open reader for %s", path)
+	}
+	defer ra.Close()
+	return rc.push(ctx, desc, true, func() io.Reader {
+		return io.NewSectionReader(ra, 0, ra.Size())
+	})
+}
+
+// push pushes the content to target registry, retries with plain HTTP
+// if the registry doesn't support HTTPS.
+func (rc *Recompressor) push(ctx context.Context, desc ocispec.Descriptor, byDigest bool, reader func() io.Reader) error {
+	if err := rc.targetRemote.Push(ctx, desc, byDigest, reader()); err != nil {
+		if !utils.RetryWithHTTP(err) {
+			return err
+		}
+		rc.targetRemote.MaybeWithHTTP(err)
+		return rc.targetRemote.Push(ctx, desc, byDigest, reader())
+	}
+	return nil
+}
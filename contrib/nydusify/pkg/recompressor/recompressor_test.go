@@ -0,0 +1,66 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package recompressor
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(Opt{Source: "localhost/app:nydus", Target: "localhost/app:zstd"})
+	require.ErrorContains(t, err, "at least one of compressor and chunk size")
+
+	rc, err := New(Opt{Source: "localhost/app:nydus", Target: "localhost/app:zstd", ExpectedArch: "amd64", Compressor: "zstd"})
+	require.NoError(t, err)
+	require.Equal(t, "zstd", rc.Compressor)
+}
+
+func TestMakeImage(t *testing.T) {
+	oldBlob := ocispec.Descriptor{MediaType: utils.MediaTypeNydusBlob, Digest: digest.FromString("old-blob")}
+	oldBootstrap := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("old-bootstrap")}
+	image := &parser.Image{
+		Manifest: ocispec.Manifest{
+			Layers: []ocispec.Descriptor{oldBlob, oldBootstrap},
+			Annotations: map[string]string{
+				utils.ManifestNydusCompressor: "lz4_block",
+				utils.ManifestNydusChunkSize:  "0x100000",
+				utils.ManifestNydusBootstrap:  oldBootstrap.Digest.String(),
+			},
+		},
+		Config: ocispec.Image{
+			Config: ocispec.ImageConfig{Cmd: []string{"sh"}},
+			RootFS: ocispec.RootFS{
+				Type:    "layers",
+				DiffIDs: []digest.Digest{oldBlob.Digest, digest.FromString("old-bootstrap-tar")},
+			},
+		},
+	}
+
+	rc := &Recompressor{Opt: Opt{Compressor: "zstd"}}
+	newBlob := ocispec.Descriptor{MediaType: utils.MediaTypeNydusBlob, Digest: digest.FromString("new-blob")}
+	newBootstrap := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("new-bootstrap")}
+	bootstrapDiffID := digest.FromString("new-bootstrap-tar")
+	config, manifest := rc.makeImage(image, []ocispec.Descriptor{newBlob}, newBootstrap, bootstrapDiffID)
+
+	require.Equal(t, []string{"sh"}, config.Config.Cmd)
+	require.Equal(t, []digest.Digest{newBlob.Digest, bootstrapDiffID}, config.RootFS.DiffIDs)
+	require.Equal(t, []ocispec.Descriptor{newBlob, newBootstrap}, manifest.Layers)
+	require.Equal(t, map[string]string{
+		utils.ManifestNydusCompressor: "zstd",
+		utils.ManifestNydusChunkSize:  "0x100000",
+		utils.ManifestNydusBootstrap:  newBootstrap.Digest.String(),
+	}, manifest.Annotations)
+
+	// The source image is untouched.
+	require.Equal(t, "lz4_block", image.Manifest.Annotations[utils.ManifestNydusCompressor])
+	require.Equal(t, oldBlob.Digest, image.Config.RootFS.DiffIDs[0])
+}
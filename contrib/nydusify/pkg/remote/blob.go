@@ -0,0 +1,69 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	distreference "github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// blobRequest sends the request of blob in the repository of remote
+// reference by digest.
+func (remote *Remote) blobRequest(ctx context.Context, method string, dgst digest.Digest, header http.Header, expected ...int) (*http.Response, error) {
+	if remote.HostsFunc == nil {
+		return nil, fmt.Errorf("requesting blob is not supported by remote")
+	}
+	ctx, host, err := remote.host(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	blobURL := &url.URL{
+		Scheme: host.Scheme,
+		Host:   host.Host,
+		Path:   fmt.Sprintf("%s/%s/blobs/%s", host.Path, distreference.Path(remote.parsed), dgst),
+	}
+	return doWithAuth(ctx, host, method, blobURL.String(), header, expected...)
+}
+
+// BlobSize returns the size of blob in the repository of remote reference.
+func (remote *Remote) BlobSize(ctx context.Context, dgst digest.Digest) (int64, error) {
+	resp, err := remote.blobRequest(ctx, http.MethodHead, dgst, nil, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("unknown size of blob %s", dgst)
+	}
+	return resp.ContentLength, nil
+}
+
+// PullRange reads size bytes of blob from offset with a ranged request,
+// like nydusd reading chunks from registry backend.
+func (remote *Remote) PullRange(ctx context.Context, dgst digest.Digest, offset, size int64) (io.ReadCloser, error) {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)}}
+	resp, err := remote.blobRequest(ctx, http.MethodGet, dgst, header, http.StatusPartialContent, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		// The registry ignoring range responds the whole blob.
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, errors.Wrapf(err, "skip to offset %d of blob %s", offset, dgst)
+		}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, size), resp.Body}, nil
+}
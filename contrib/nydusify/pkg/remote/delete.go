@@ -0,0 +1,40 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	distreference "github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// DeleteManifest deletes the manifest or index of digest from the
+// repository of remote reference, which untags all the tags pointing to
+// it. The blobs are left to the garbage collection of registry, and the
+// registry may refuse the deletion if it's disabled.
+func (remote *Remote) DeleteManifest(ctx context.Context, dgst digest.Digest) error {
+	if remote.HostsFunc == nil {
+		return fmt.Errorf("deleting manifest is not supported by remote")
+	}
+	ctx, host, err := remote.host(ctx, true)
+	if err != nil {
+		return err
+	}
+	manifestURL := &url.URL{
+		Scheme: host.Scheme,
+		Host:   host.Host,
+		Path:   fmt.Sprintf("%s/%s/manifests/%s", host.Path, distreference.Path(remote.parsed), dgst),
+	}
+	resp, err := doWithAuth(ctx, host, http.MethodDelete, manifestURL.String(), nil, http.StatusAccepted, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// PullJSON pulls the content of descriptor by digest and unmarshals it
+// into v.
+func (remote *Remote) PullJSON(ctx context.Context, desc ocispec.Descriptor, v interface{}) error {
+	reader, err := remote.Pull(ctx, desc, true)
+	if err != nil {
+		return errors.Wrapf(err, "pull %s", desc.Digest)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return errors.Wrapf(err, "read %s", desc.Digest)
+	}
+	return errors.Wrapf(json.Unmarshal(data, v), "unmarshal %s", desc.Digest)
+}
+
+// WalkManifests resolves the remote reference and calls fn with each image
+// manifest of it, the manifest itself or the manifests listed by index,
+// the entries of index which aren't manifests are skipped. The resolved
+// descriptor is returned.
+func (remote *Remote) WalkManifests(ctx context.Context, fn func(desc ocispec.Descriptor, manifest *ocispec.Manifest) error) (*ocispec.Descriptor, error) {
+	desc, err := remote.Resolve(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve tag")
+	}
+
+	visit := func(desc ocispec.Descriptor) error {
+		var manifest ocispec.Manifest
+		if err := remote.PullJSON(ctx, desc, &manifest); err != nil {
+			return err
+		}
+		return fn(desc, &manifest)
+	}
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var index ocispec.Index
+		if err := remote.PullJSON(ctx, *desc, &index); err != nil {
+			return desc, err
+		}
+		for _, manifestDesc := range index.Manifests {
+			if !images.IsManifestType(manifestDesc.MediaType) {
+				continue
+			}
+			if err := visit(manifestDesc); err != nil {
+				return desc, err
+			}
+		}
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		if err := visit(*desc); err != nil {
+			return desc, err
+		}
+	default:
+		return desc, fmt.Errorf("unsupported media type %s", desc.MediaType)
+	}
+	return desc, nil
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
 	"github.com/distribution/reference"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
@@ -29,7 +30,10 @@ type Remote struct {
 	// the resolver does not re-apply for a new token, so it's better to create a
 	// new resolver instance using resolverFunc for each request.
 	resolverFunc func(insecure bool) remotes.Resolver
-	pushed       sync.Map
+	// HostsFunc returns the registry hosts for the requests not supported
+	// by resolver, like listing tags, it's optional.
+	HostsFunc func(retryWithHTTP bool) docker.RegistryHosts
+	pushed    sync.Map
 
 	retryWithHTTP bool
 }
@@ -60,6 +64,11 @@ func (remote *Remote) MaybeWithHTTP(err error) {
 	}
 }
 
+// WithHTTP makes the later requests use plain HTTP.
+func (remote *Remote) WithHTTP() {
+	remote.retryWithHTTP = true
+}
+
 func (remote *Remote) IsWithHTTP() bool {
 	return remote.retryWithHTTP
 }
@@ -0,0 +1,137 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	distreference "github.com/distribution/reference"
+	"github.com/pkg/errors"
+)
+
+// tagsPageSize is the number of tags requested per page.
+const tagsPageSize = 1000
+
+var nextLinkPattern = regexp.MustCompile(`<([^>]+)>;\s*rel="?next"?`)
+
+// Tags lists all tags in the repository of remote reference, following
+// the pagination of registry.
+func (remote *Remote) Tags(ctx context.Context) ([]string, error) {
+	if remote.HostsFunc == nil {
+		return nil, fmt.Errorf("listing tags is not supported by remote")
+	}
+	ctx, host, err := remote.host(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	next := &url.URL{
+		Scheme:   host.Scheme,
+		Host:     host.Host,
+		Path:     fmt.Sprintf("%s/%s/tags/list", host.Path, distreference.Path(remote.parsed)),
+		RawQuery: fmt.Sprintf("n=%d", tagsPageSize),
+	}
+	tags := []string{}
+	for next != nil {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		resp, err := doWithAuth(ctx, host, http.MethodGet, next.String(), http.Header{"Accept": {"application/json"}}, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "decode tags")
+		}
+		tags = append(tags, page.Tags...)
+
+		link := nextLinkPattern.FindStringSubmatch(resp.Header.Get("Link"))
+		if link == nil {
+			break
+		}
+		linkURL, err := url.Parse(link[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse link %s", link[1])
+		}
+		next = next.ResolveReference(linkURL)
+	}
+
+	return tags, nil
+}
+
+// host returns the first registry host of remote reference, and the context
+// with the pull scope, or push scope as well, of its repository for
+// authorization.
+func (remote *Remote) host(ctx context.Context, push bool) (context.Context, docker.RegistryHost, error) {
+	hosts, err := remote.HostsFunc(remote.retryWithHTTP)(distreference.Domain(remote.parsed))
+	if err != nil {
+		return nil, docker.RegistryHost{}, errors.Wrap(err, "get registry hosts")
+	}
+	if len(hosts) == 0 {
+		return nil, docker.RegistryHost{}, fmt.Errorf("no registry host for %s", remote.Ref)
+	}
+
+	refspec, err := reference.Parse(remote.parsed.Name())
+	if err != nil {
+		return nil, docker.RegistryHost{}, errors.Wrap(err, "parse reference")
+	}
+	if ctx, err = docker.ContextWithRepositoryScope(ctx, refspec, push); err != nil {
+		return nil, docker.RegistryHost{}, errors.Wrap(err, "set repository scope")
+	}
+
+	return ctx, hosts[0], nil
+}
+
+// doWithAuth sends request to registry, and retries once with the token
+// obtained from the challenge of unauthorized response, the response of
+// other status than the expected ones is an error.
+func doWithAuth(ctx context.Context, host docker.RegistryHost, method, rawURL string, header http.Header, expected ...int) (*http.Response, error) {
+	client := host.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	for retried := false; ; retried = true {
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "create request")
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if host.Authorizer != nil {
+			if err := host.Authorizer.Authorize(ctx, req); err != nil {
+				return nil, errors.Wrap(err, "authorize request")
+			}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s %s", method, rawURL)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && !retried && host.Authorizer != nil {
+			resp.Body.Close()
+			if err := host.Authorizer.AddResponses(ctx, []*http.Response{resp}); err != nil {
+				return nil, errors.Wrap(err, "authenticate")
+			}
+			continue
+		}
+		for _, status := range expected {
+			if resp.StatusCode == status {
+				return resp, nil
+			}
+		}
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: unexpected status %s", method, rawURL, resp.Status)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package retagger promotes a converted image to a new tag without running
+// the conversion again. The manifests are copied byte for byte so that the
+// digests are kept, and the blobs are mounted from the source repository
+// if it's in the same registry as target, or streamed otherwise.
+package retagger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/labels"
+	"github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// RemoteFunc creates the remote of image reference.
+type RemoteFunc func(ref string, insecure bool) (*remote.Remote, error)
+
+type Opt struct {
+	Source         string
+	SourceInsecure bool
+	Target         string
+	TargetInsecure bool
+}
+
+type retagger struct {
+	source     *remote.Remote
+	target     *remote.Remote
+	sourceHost string
+	sourceRepo string
+	sameRepo   bool
+}
+
+// lazyReader pulls the blob from source on the first read, so that nothing
+// is pulled if the blob is mounted or already exists in target.
+type lazyReader struct {
+	open   func() (io.ReadCloser, error)
+	reader io.ReadCloser
+}
+
+func (r *lazyReader) Read(p []byte) (int, error) {
+	if r.reader == nil {
+		reader, err := r.open()
+		if err != nil {
+			return 0, err
+		}
+		r.reader = reader
+	}
+	return r.reader.Read(p)
+}
+
+func (r *lazyReader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	return r.reader.Close()
+}
+
+func (rt *retagger) pull(ctx context.Context, desc ocispec.Descriptor) ([]byte, error) {
+	reader, err := rt.source.Pull(ctx, desc, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pull %s", desc.Digest)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func (rt *retagger) push(ctx context.Context, desc ocispec.Descriptor, byDigest bool, reader io.Reader) error {
+	if err := rt.target.Push(ctx, desc, byDigest, reader); err != nil {
+		return errors.Wrapf(err, "push %s", desc.Digest)
+	}
+	return nil
+}
+
+// copyBlob mounts the blob from source repository, the containerd pusher
+// falls back to upload from reader if the mount isn't available.
+func (rt *retagger) copyBlob(ctx context.Context, desc ocispec.Descriptor) error {
+	if rt.sameRepo {
+		return nil
+	}
+	desc.Annotations = map[string]string{
+		fmt.Sprintf("%s.%s", labels.LabelDistributionSource, rt.sourceHost): rt.sourceRepo,
+	}
+	reader := &lazyReader{open: func() (io.ReadCloser, error) {
+		return rt.source.Pull(ctx, desc, true)
+	}}
+	defer reader.Close()
+	logrus.Debugf("copying blob %s", desc.Digest)
+	return rt.push(ctx, desc, true, reader)
+}
+
+// copyManifest copies the blobs and child manifests referenced by the
+// manifest or index, then pushes it byte for byte.
+func (rt *retagger) copyManifest(ctx context.Context, desc ocispec.Descriptor, byDigest bool) error {
+	data, err := rt.pull(ctx, desc)
+	if err != nil {
+		return err
+	}
+
+	if !rt.sameRepo {
+		switch desc.MediaType {
+		case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+			var index ocispec.Index
+			if err := json.Unmarshal(data, &index); err != nil {
+				return errors.Wrapf(err, "unmarshal index %s", desc.Digest)
+			}
+			for _, manifest := range index.Manifests {
+				if !images.IsManifestType(manifest.MediaType) && !images.IsIndexType(manifest.MediaType) {
+					if err := rt.copyBlob(ctx, manifest); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := rt.copyManifest(ctx, manifest, true); err != nil {
+					return err
+				}
+			}
+		case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+			var manifest ocispec.Manifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				return errors.Wrapf(err, "unmarshal manifest %s", desc.Digest)
+			}
+			for _, blob := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+				if err := rt.copyBlob(ctx, blob); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported media type %s", desc.MediaType)
+		}
+	}
+
+	return rt.push(ctx, desc, byDigest, bytes.NewReader(data))
+}
+
+func (rt *retagger) retag(ctx context.Context) (*ocispec.Descriptor, error) {
+	desc, err := rt.source.Resolve(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve source image")
+	}
+	if err := rt.copyManifest(ctx, *desc, false); err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+// Retag copies the manifest of source image to target tag, the blobs are
+// copied first if the target is in another repository. The descriptor of
+// the promoted manifest (index) is returned, which is identical to source.
+func Retag(ctx context.Context, opt Opt, remoteFunc RemoteFunc) (*ocispec.Descriptor, error) {
+	sourceNamed, err := reference.ParseDockerRef(opt.Source)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse source reference")
+	}
+	targetNamed, err := reference.ParseDockerRef(opt.Target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	if _, ok := targetNamed.(reference.Digested); ok {
+		return nil, fmt.Errorf("target reference %s should not contain digest", opt.Target)
+	}
+
+	sourceRemote, err := remoteFunc(opt.Source, opt.SourceInsecure)
+	if err != nil {
+		return nil, errors.Wrap(err, "create source remote")
+	}
+	targetRemote, err := remoteFunc(opt.Target, opt.TargetInsecure)
+	if err != nil {
+		return nil, errors.Wrap(err, "create target remote")
+	}
+
+	rt := &retagger{
+		source: sourceRemote,
+		target: targetRemote,
+		// The mount source is keyed by the host without port by containerd.
+		sourceHost: (&url.URL{Host: reference.Domain(sourceNamed)}).Hostname(),
+		sourceRepo: reference.Path(sourceNamed),
+		sameRepo:   sourceNamed.Name() == targetNamed.Name(),
+	}
+
+	desc, err := rt.retag(ctx)
+	if err != nil && utils.RetryWithHTTP(err) {
+		sourceRemote.MaybeWithHTTP(err)
+		targetRemote.MaybeWithHTTP(err)
+		desc, err = rt.retag(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Retagged %s to %s, digest: %s", opt.Source, opt.Target, desc.Digest)
+
+	return desc, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package retagger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestRetag(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	host := registry.Host()
+
+	config := registry.PutBlob("staging/app", ocispec.MediaTypeImageConfig, []byte("{}"))
+	blob := registry.PutBlob("staging/app", utils.MediaTypeNydusBlob, []byte("nydus blob"))
+	bootstrap := registry.PutBlob("staging/app", ocispec.MediaTypeImageLayerGzip, []byte("bootstrap"))
+	manifestDesc, err := registry.PutManifest("staging/app", "", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{blob, bootstrap},
+	})
+	require.NoError(t, err)
+	manifestDesc.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	indexDesc, err := registry.PutManifest("staging/app", "v1-nydus", ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifestDesc},
+	})
+	require.NoError(t, err)
+	// The bootstrap exists in target, it's neither mounted nor uploaded.
+	registry.PutBlob("release/app", ocispec.MediaTypeImageLayerGzip, []byte("bootstrap"))
+
+	remoteFunc := func(ref string, insecure bool) (*remote.Remote, error) {
+		rmt, err := provider.DefaultRemote(ref, insecure)
+		if err != nil {
+			return nil, err
+		}
+		rmt.WithHTTP()
+		return rmt, nil
+	}
+
+	// Retag in the same repository only pushes the index.
+	desc, err := Retag(context.Background(), Opt{
+		Source: host + "/staging/app:v1-nydus",
+		Target: host + "/staging/app:latest-nydus",
+	}, remoteFunc)
+	require.NoError(t, err)
+	require.Equal(t, indexDesc.Digest, desc.Digest)
+	source, _, _ := registry.Manifest("staging/app", "v1-nydus")
+	target, mediaType, ok := registry.Manifest("staging/app", "latest-nydus")
+	require.True(t, ok)
+	require.Equal(t, source, target)
+	require.Equal(t, ocispec.MediaTypeImageIndex, mediaType)
+	require.Empty(t, registry.Mounts())
+
+	// Promote to another repository by mounting the blobs.
+	desc, err = Retag(context.Background(), Opt{
+		Source: host + "/staging/app:v1-nydus",
+		Target: host + "/release/app:v1",
+	}, remoteFunc)
+	require.NoError(t, err)
+	require.Equal(t, indexDesc.Digest, desc.Digest)
+	target, _, _ = registry.Manifest("release/app", "v1")
+	require.Equal(t, source, target)
+	_, _, ok = registry.Manifest("release/app", manifestDesc.Digest.String())
+	require.True(t, ok)
+	require.ElementsMatch(t, []digest.Digest{config.Digest, blob.Digest}, registry.Mounts())
+	require.Empty(t, registry.Uploads())
+	data, _ := registry.Blob("release/app", blob.Digest)
+	require.Equal(t, []byte("nydus blob"), data)
+
+	_, err = Retag(context.Background(), Opt{
+		Source: host + "/staging/app:v1-nydus",
+		Target: host + "/release/app@" + indexDesc.Digest.String(),
+	}, remoteFunc)
+	require.ErrorContains(t, err, "should not contain digest")
+}
@@ -0,0 +1,440 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package retention deletes the old converted tags in a repository of
+// accelerated images by the retention policy per source tag, together with
+// their blobs in storage backend not referenced by the rest, to stop the
+// repository growing forever.
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// DefaultTagPattern matches the tags converted with `--target-suffix`
+// starting with `-nydus`, e.g. `v1-nydus` and `v1-nydus-20231001`, the
+// source tag is `v1`.
+const DefaultTagPattern = `^(.+?)-nydus.*$`
+
+// RemoteFunc creates the remote of image reference.
+type RemoteFunc func(ref string) (*remote.Remote, error)
+
+type Opt struct {
+	// Repo is the repository of converted images, e.g.
+	// `docker.io/library/nginx`.
+	Repo string
+	// TagPattern is the regexp matching the converted tags, whose first
+	// capture group is the source tag to group the converted tags by, the
+	// other tags are never deleted.
+	TagPattern string
+	// KeepLast is the number of the newest converted tags kept per source
+	// tag, by the creation time of image config.
+	KeepLast int
+	// KeepWithin keeps the converted tags created within the duration
+	// regardless of KeepLast, 0 means no such tags.
+	KeepWithin time.Duration
+	// Backend is the storage backend of Nydus blobs, the blobs referenced
+	// by deleted tags exclusively are removed from it if it's not nil.
+	Backend     backend.Backend
+	DryRun      bool
+	Concurrency int
+	OutputJSON  string
+}
+
+// Tag is a tag in repository inspected for the retention.
+type Tag struct {
+	Tag    string        `json:"tag"`
+	Digest digest.Digest `json:"digest"`
+	// Source is the source tag captured by the tag pattern, it's empty if
+	// the tag isn't a converted one.
+	Source  string    `json:"source,omitempty"`
+	Created time.Time `json:"created,omitempty"`
+	Deleted bool      `json:"deleted"`
+	// Reason is why the converted tag is kept or deleted.
+	Reason string `json:"reason,omitempty"`
+
+	blobs []string
+	// sources maps the blobs to the source layers they're converted from,
+	// recorded in the annotations of blob layers.
+	sources map[string]digest.Digest
+	// chunkDicts are the chunk dict images referenced by Nydus manifests,
+	// whose blobs are protected.
+	chunkDicts []string
+}
+
+// Result is the result of retention.
+type Result struct {
+	Repo   string `json:"repo"`
+	DryRun bool   `json:"dry_run"`
+	// Tags are the converted tags grouped by source tag, in the order from
+	// the newest to the oldest in group.
+	Tags []Tag `json:"tags"`
+	// DeletedManifests are the digests of manifests or indexes deleted.
+	DeletedManifests []digest.Digest `json:"deleted_manifests"`
+	// DeletedBlobs are the blob IDs removed from storage backend.
+	DeletedBlobs []string `json:"deleted_blobs"`
+	// BlobSources maps the deleted blobs to the source layers they're
+	// converted from, the blobs without the annotation are omitted.
+	BlobSources map[string]digest.Digest `json:"blob_sources,omitempty"`
+}
+
+// deleteBlob deletes the blob and the blob meta stored alongside it from
+// backend, the blob metas of the kept blobs are never deleted.
+func deleteBlob(ctx context.Context, bkd backend.Backend, blobID string) error {
+	if err := backend.DeleteBlob(ctx, bkd, blobID); err != nil {
+		return err
+	}
+	blobMetaID := blobID + utils.BlobMetaSuffix
+	exist, err := bkd.Check(blobMetaID)
+	if err != nil {
+		return errors.Wrapf(err, "check blob meta %s", blobMetaID)
+	}
+	if !exist {
+		return nil
+	}
+	return errors.Wrapf(backend.DeleteBlob(ctx, bkd, blobMetaID), "delete blob meta %s", blobMetaID)
+}
+
+// inspectManifest collects the Nydus blobs, chunk dict images and creation
+// time of image manifest into tag.
+func inspectManifest(ctx context.Context, rmt *remote.Remote, manifest *ocispec.Manifest, tag *Tag) error {
+	nydus := false
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == utils.MediaTypeNydusBlob {
+			tag.blobs = append(tag.blobs, layer.Digest.Encoded())
+			if source := digest.Digest(layer.Annotations[utils.LayerAnnotationNydusSourceDigest]); source.Validate() == nil {
+				if tag.sources == nil {
+					tag.sources = map[string]digest.Digest{}
+				}
+				tag.sources[layer.Digest.Encoded()] = source
+			}
+			nydus = true
+		}
+	}
+	if chunkDict := manifest.Annotations[utils.ManifestNydusChunkDictReference]; chunkDict != "" {
+		tag.chunkDicts = append(tag.chunkDicts, chunkDict)
+	}
+
+	// The manifests of a tag are converted at the same time.
+	if tag.Created.IsZero() && (nydus || manifest.Annotations[utils.ManifestNydusBootstrap] != "") {
+		var config ocispec.Image
+		if err := rmt.PullJSON(ctx, manifest.Config, &config); err != nil {
+			return err
+		}
+		if config.Created != nil {
+			tag.Created = *config.Created
+		}
+	}
+	return nil
+}
+
+func inspectTag(ctx context.Context, rmt *remote.Remote, tag *Tag) error {
+	desc, err := rmt.WalkManifests(ctx, func(_ ocispec.Descriptor, manifest *ocispec.Manifest) error {
+		return inspectManifest(ctx, rmt, manifest, tag)
+	})
+	if desc != nil {
+		tag.Digest = desc.Digest
+	}
+	return err
+}
+
+// chunkDictBlobs returns the Nydus blobs of chunk dict image.
+func chunkDictBlobs(ctx context.Context, remoteFunc RemoteFunc, withHTTP bool, ref string) ([]string, error) {
+	rmt, err := remoteFunc(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "create remote")
+	}
+	if withHTTP {
+		rmt.WithHTTP()
+	}
+	tag := Tag{Tag: ref}
+	if err := inspectTag(ctx, rmt, &tag); err != nil {
+		return nil, err
+	}
+	return tag.blobs, nil
+}
+
+// plan decides the converted tags to delete, the converted tags of each
+// source are kept from the newest until KeepLast of them are kept, the ones
+// created within KeepWithin are kept too. The tags pointing to the digest
+// of a kept tag can't be deleted without untagging it, so they're kept.
+func plan(opt Opt, pattern *regexp.Regexp, tags []Tag, now time.Time) []Tag {
+	groups := map[string][]Tag{}
+	keptDigests := map[digest.Digest]string{}
+	for _, tag := range tags {
+		match := pattern.FindStringSubmatch(tag.Tag)
+		if match == nil || match[1] == "" {
+			keptDigests[tag.Digest] = tag.Tag
+			continue
+		}
+		tag.Source = match[1]
+		groups[tag.Source] = append(groups[tag.Source], tag)
+	}
+
+	sources := make([]string, 0, len(groups))
+	for source := range groups {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	result := []Tag{}
+	for _, source := range sources {
+		group := groups[source]
+		sort.SliceStable(group, func(i, j int) bool {
+			if !group[i].Created.Equal(group[j].Created) {
+				return group[i].Created.After(group[j].Created)
+			}
+			return group[i].Tag > group[j].Tag
+		})
+		for idx := range group {
+			tag := &group[idx]
+			switch {
+			case idx < opt.KeepLast:
+				tag.Reason = fmt.Sprintf("one of the last %d of %s", opt.KeepLast, source)
+			case opt.KeepWithin > 0 && now.Sub(tag.Created) < opt.KeepWithin:
+				tag.Reason = fmt.Sprintf("created within %s", opt.KeepWithin)
+			default:
+				tag.Deleted = true
+				tag.Reason = fmt.Sprintf("older than the last %d of %s", opt.KeepLast, source)
+			}
+			if !tag.Deleted {
+				keptDigests[tag.Digest] = tag.Tag
+			}
+		}
+		result = append(result, group...)
+	}
+
+	for idx := range result {
+		tag := &result[idx]
+		if kept, ok := keptDigests[tag.Digest]; ok && tag.Deleted {
+			tag.Deleted = false
+			tag.Reason = fmt.Sprintf("same digest as kept tag %s", kept)
+		}
+	}
+	return result
+}
+
+// Run inspects all tags in repository, deletes the old converted tags by
+// the retention policy, then removes their blobs from storage backend which
+// aren't referenced by the kept tags or the chunk dict images. It fails
+// before deleting anything if any tag can't be inspected.
+func Run(ctx context.Context, opt Opt, remoteFunc RemoteFunc) (*Result, error) {
+	named, err := reference.ParseNormalizedNamed(opt.Repo)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse repository")
+	}
+	if !reference.IsNameOnly(named) {
+		return nil, fmt.Errorf("repository %s should not contain tag or digest", opt.Repo)
+	}
+	if opt.KeepLast < 1 {
+		return nil, fmt.Errorf("invalid number %d of tags to keep, should be at least 1", opt.KeepLast)
+	}
+	if opt.TagPattern == "" {
+		opt.TagPattern = DefaultTagPattern
+	}
+	pattern, err := regexp.Compile(opt.TagPattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid tag pattern %s", opt.TagPattern)
+	}
+	if pattern.NumSubexp() < 1 {
+		return nil, fmt.Errorf("tag pattern %s should capture the source tag", opt.TagPattern)
+	}
+	if opt.Backend != nil && opt.Backend.Type() == backend.RegistryBackend {
+		return nil, errors.New("blobs in registry backend are left to the garbage collection of registry")
+	}
+	repo := named.Name()
+
+	rmt, err := remoteFunc(repo)
+	if err != nil {
+		return nil, errors.Wrap(err, "create remote")
+	}
+	tagNames, err := rmt.Tags(ctx)
+	if err != nil {
+		rmt.MaybeWithHTTP(err)
+		if !rmt.IsWithHTTP() {
+			return nil, errors.Wrapf(err, "list tags of %s", repo)
+		}
+		if tagNames, err = rmt.Tags(ctx); err != nil {
+			return nil, errors.Wrapf(err, "list tags of %s", repo)
+		}
+	}
+
+	// All tags are inspected, as the other tags may share the digests and
+	// blobs with the converted tags.
+	tags := make([]Tag, len(tagNames))
+	eg, egCtx := errgroup.WithContext(ctx)
+	if opt.Concurrency > 0 {
+		eg.SetLimit(opt.Concurrency)
+	}
+	for idx, tagName := range tagNames {
+		idx, tagName := idx, tagName
+		eg.Go(func() error {
+			tagRemote, err := remoteFunc(repo + ":" + tagName)
+			if err != nil {
+				return errors.Wrap(err, "create remote")
+			}
+			if rmt.IsWithHTTP() {
+				tagRemote.WithHTTP()
+			}
+			tags[idx].Tag = tagName
+			return errors.Wrapf(inspectTag(egCtx, tagRemote, &tags[idx]), "inspect tag %s", tagName)
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Repo:             repo,
+		DryRun:           opt.DryRun,
+		Tags:             plan(opt, pattern, tags, time.Now()),
+		DeletedManifests: []digest.Digest{},
+		DeletedBlobs:     []string{},
+	}
+	deletedDigests := map[digest.Digest]bool{}
+	deletedBlobs := map[string]bool{}
+	sources := map[string]digest.Digest{}
+	for _, tag := range result.Tags {
+		if !tag.Deleted {
+			continue
+		}
+		if !deletedDigests[tag.Digest] {
+			deletedDigests[tag.Digest] = true
+			result.DeletedManifests = append(result.DeletedManifests, tag.Digest)
+		}
+		for _, blob := range tag.blobs {
+			deletedBlobs[blob] = true
+		}
+		for blob, source := range tag.sources {
+			sources[blob] = source
+		}
+	}
+
+	if opt.Backend != nil && len(deletedBlobs) > 0 {
+		keptBlobs := map[string]bool{}
+		chunkDicts := map[string]bool{}
+		for _, tag := range tags {
+			if !deletedDigests[tag.Digest] {
+				for _, blob := range tag.blobs {
+					keptBlobs[blob] = true
+				}
+			}
+			for _, chunkDict := range tag.chunkDicts {
+				chunkDicts[chunkDict] = true
+			}
+		}
+		for chunkDict := range chunkDicts {
+			blobs, err := chunkDictBlobs(ctx, remoteFunc, rmt.IsWithHTTP(), chunkDict)
+			if err != nil {
+				return nil, errors.Wrapf(err, "inspect chunk dict image %s", chunkDict)
+			}
+			for _, blob := range blobs {
+				keptBlobs[blob] = true
+			}
+		}
+		for blob := range deletedBlobs {
+			if !keptBlobs[blob] {
+				result.DeletedBlobs = append(result.DeletedBlobs, blob)
+				if source, ok := sources[blob]; ok {
+					if result.BlobSources == nil {
+						result.BlobSources = map[string]digest.Digest{}
+					}
+					result.BlobSources[blob] = source
+				}
+			}
+		}
+		sort.Strings(result.DeletedBlobs)
+	}
+
+	if !opt.DryRun {
+		// The manifests are deleted before blobs, so that no kept image
+		// refers to the missing blobs if it's interrupted.
+		for _, dgst := range result.DeletedManifests {
+			if err := rmt.DeleteManifest(ctx, dgst); err != nil {
+				return nil, errors.Wrapf(err, "delete manifest %s", dgst)
+			}
+			logrus.Infof("Deleted manifest %s from %s", dgst, repo)
+		}
+		var mutex sync.Mutex
+		failed := []string{}
+		eg, egCtx := errgroup.WithContext(ctx)
+		if opt.Concurrency > 0 {
+			eg.SetLimit(opt.Concurrency)
+		}
+		for _, blob := range result.DeletedBlobs {
+			blob := blob
+			eg.Go(func() error {
+				if err := deleteBlob(egCtx, opt.Backend, blob); err != nil {
+					logrus.WithError(err).Warnf("failed to delete blob %s", blob)
+					mutex.Lock()
+					failed = append(failed, blob)
+					mutex.Unlock()
+				} else if source, ok := result.BlobSources[blob]; ok {
+					logrus.Infof("Deleted blob %s converted from source layer %s", blob, source)
+				}
+				return nil
+			})
+		}
+		eg.Wait()
+		if len(failed) > 0 {
+			sort.Strings(failed)
+			return nil, fmt.Errorf("failed to delete %d blobs from backend: %s", len(failed), strings.Join(failed, ", "))
+		}
+	}
+
+	if opt.OutputJSON != "" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal retention result")
+		}
+		if err := os.WriteFile(opt.OutputJSON, data, 0644); err != nil {
+			return nil, errors.Wrap(err, "write retention result")
+		}
+	}
+
+	return result, nil
+}
+
+// Print prints the converted tags kept or deleted, and the summary.
+func (result *Result) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tTAG\tCREATED\tACTION\tREASON")
+	for _, tag := range result.Tags {
+		action := "keep"
+		if tag.Deleted {
+			action = "delete"
+		}
+		created := ""
+		if !tag.Created.IsZero() {
+			created = tag.Created.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", tag.Source, tag.Tag, created, action, tag.Reason)
+	}
+	verb := "Deleted"
+	if result.DryRun {
+		verb = "Would delete"
+	}
+	fmt.Fprintf(tw, "\n%s %d manifests from %s and %d blobs from backend\n", verb, len(result.DeletedManifests), result.Repo, len(result.DeletedBlobs))
+	return tw.Flush()
+}
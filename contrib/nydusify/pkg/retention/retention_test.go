@@ -0,0 +1,167 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package retention
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/backend"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/remote"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/testutil"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func blobID(name string) string {
+	return digest.FromString(name).Encoded()
+}
+
+func putImage(t *testing.T, registry *testutil.Registry, repo, tag string, created time.Time, blobs []string, annotations map[string]string) digest.Digest {
+	config, err := json.Marshal(ocispec.Image{Created: &created})
+	require.NoError(t, err)
+	manifest := ocispec.Manifest{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Config:      registry.PutBlob(repo, ocispec.MediaTypeImageConfig, config),
+		Annotations: annotations,
+	}
+	for _, blob := range blobs {
+		manifest.Layers = append(manifest.Layers, ocispec.Descriptor{
+			MediaType: utils.MediaTypeNydusBlob,
+			Digest:    digest.NewDigestFromEncoded(digest.SHA256, blobID(blob)),
+			Size:      1,
+			Annotations: map[string]string{
+				utils.LayerAnnotationNydusSourceDigest: digest.FromString("source of " + blob).String(),
+			},
+		})
+	}
+	desc, err := registry.PutManifest(repo, tag, ocispec.MediaTypeImageManifest, manifest)
+	require.NoError(t, err)
+	return desc.Digest
+}
+
+func TestRun(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	host := registry.Host()
+	now := time.Now()
+
+	putImage(t, registry, "dict", "v1", now, []string{"dict"}, nil)
+	putImage(t, registry, "app", "v1", now.Add(-72*time.Hour), nil, nil)
+	oldDigest := putImage(t, registry, "app", "v1-nydus-1", now.Add(-72*time.Hour), []string{"b1", "b2", "dict"}, map[string]string{
+		utils.ManifestNydusChunkDictReference: host + "/dict:v1",
+	})
+	putImage(t, registry, "app", "v1-nydus-2", now.Add(-48*time.Hour), []string{"b2", "b3"}, nil)
+	sharedDigest := putImage(t, registry, "app", "v2-nydus-1", now.Add(-48*time.Hour), []string{"b4"}, nil)
+	putImage(t, registry, "app", "latest", now.Add(-48*time.Hour), []string{"b4"}, nil)
+	putImage(t, registry, "app", "v2-nydus-2", now.Add(-24*time.Hour), []string{"b5"}, nil)
+
+	dir := t.TempDir()
+	for _, blob := range []string{"b1", "b2", "b3", "b4", "b5", "dict"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, blobID(blob)), []byte(blob), 0644))
+	}
+	// The blob metas are deleted along with their blobs only.
+	for _, blob := range []string{"b1", "b2"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, blobID(blob)+utils.BlobMetaSuffix), []byte(blob), 0644))
+	}
+	bkd, err := backend.NewBackend("localfs", []byte(`{"dir": "`+dir+`"}`), nil)
+	require.NoError(t, err)
+	remoteFunc := func(ref string) (*remote.Remote, error) {
+		return provider.DefaultRemote(ref, false)
+	}
+
+	// Nothing is deleted in dry run.
+	opt := Opt{
+		Repo:       host + "/app",
+		TagPattern: `^(.+)-nydus-\d+$`,
+		KeepLast:   1,
+		Backend:    bkd,
+		DryRun:     true,
+	}
+	result, err := Run(context.Background(), opt, remoteFunc)
+	require.NoError(t, err)
+	require.Equal(t, []digest.Digest{oldDigest}, result.DeletedManifests)
+	require.Equal(t, []string{blobID("b1")}, result.DeletedBlobs)
+	require.Equal(t, map[string]digest.Digest{blobID("b1"): digest.FromString("source of b1")}, result.BlobSources)
+	_, _, ok := registry.Manifest("app", "v1-nydus-1")
+	require.True(t, ok)
+
+	actions := map[string]bool{}
+	for _, tag := range result.Tags {
+		actions[tag.Tag] = tag.Deleted
+	}
+	require.Equal(t, map[string]bool{
+		"v1-nydus-1": true,
+		"v1-nydus-2": false,
+		"v2-nydus-1": false,
+		"v2-nydus-2": false,
+	}, actions)
+	require.Equal(t, "v2-nydus-2", result.Tags[2].Tag)
+	require.Equal(t, "same digest as kept tag latest", result.Tags[3].Reason)
+	require.Equal(t, sharedDigest, result.Tags[3].Digest)
+	var buf bytes.Buffer
+	require.NoError(t, result.Print(&buf))
+	require.Contains(t, buf.String(), "Would delete 1 manifests from "+host+"/app and 1 blobs from backend")
+
+	// The tags created within the duration are kept.
+	opt.KeepWithin = 96 * time.Hour
+	result, err = Run(context.Background(), opt, remoteFunc)
+	require.NoError(t, err)
+	require.Empty(t, result.DeletedManifests)
+
+	opt.KeepWithin = 0
+	opt.DryRun = false
+	result, err = Run(context.Background(), opt, remoteFunc)
+	require.NoError(t, err)
+	require.Equal(t, []digest.Digest{oldDigest}, result.DeletedManifests)
+	_, _, ok = registry.Manifest("app", "v1-nydus-1")
+	require.False(t, ok)
+	_, _, ok = registry.Manifest("app", "v1-nydus-2")
+	require.True(t, ok)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 6)
+	_, err = os.Stat(filepath.Join(dir, blobID("b1")))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, blobID("b1")+utils.BlobMetaSuffix))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, blobID("b2")+utils.BlobMetaSuffix))
+	require.NoError(t, err)
+
+	_, err = Run(context.Background(), Opt{Repo: host + "/app:v1", KeepLast: 1}, remoteFunc)
+	require.ErrorContains(t, err, "should not contain tag or digest")
+	_, err = Run(context.Background(), Opt{Repo: host + "/app", TagPattern: "-nydus$", KeepLast: 1}, remoteFunc)
+	require.ErrorContains(t, err, "should capture the source tag")
+}
+
+func TestPlan(t *testing.T) {
+	now := time.Now()
+	pattern := `^(.+?)-nydus.*$`
+	tags := []Tag{
+		{Tag: "v1-nydus", Digest: "sha256:1", Created: now.Add(-3 * time.Hour)},
+		{Tag: "v1-nydus-rebuild", Digest: "sha256:2", Created: now.Add(-2 * time.Hour)},
+		{Tag: "v1-nydus-rebuild2", Digest: "sha256:3", Created: now.Add(-time.Hour)},
+		{Tag: "v1", Digest: "sha256:4", Created: now.Add(-3 * time.Hour)},
+	}
+	result := plan(Opt{KeepLast: 2}, regexp.MustCompile(pattern), tags, now)
+	require.Len(t, result, 3)
+	require.Equal(t, "v1-nydus-rebuild2", result[0].Tag)
+	require.Equal(t, "v1", result[0].Source)
+	require.False(t, result[1].Deleted)
+	require.True(t, result[2].Deleted)
+	require.Equal(t, "older than the last 2 of v1", result[2].Reason)
+}
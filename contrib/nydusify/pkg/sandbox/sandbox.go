@@ -0,0 +1,113 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package sandbox runs the builder subprocess with no network, a restricted
+// seccomp profile, and a read-only view of the filesystem except the work
+// directory, limiting the blast radius of converting untrusted images.
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+const (
+	// Command is the hidden nydusify subcommand to enter sandbox.
+	Command = "sandbox-exec"
+	// wrapperName is the name of builder wrapper script.
+	wrapperName = "nydus-image-sandbox"
+	// envInit marks the process is the init process in sandbox namespaces.
+	envInit = "_NYDUSIFY_SANDBOX_INIT"
+)
+
+// within checks whether path is dir or in dir.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// PrivateDir creates a new temporary directory out of the writable paths
+// of sandbox, for the wrapper scripts running out of sandbox, so that the
+// sandboxed builder can't tamper with them. The directory should be
+// removed after use.
+func PrivateDir(pattern string, writable ...string) (string, error) {
+	dir, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return "", errors.Wrap(err, "create wrapper directory")
+	}
+	for _, path := range writable {
+		path, err := filepath.Abs(path)
+		if err == nil && within(dir, path) {
+			err = fmt.Errorf("wrapper directory %s is writable by builder in %s", dir, path)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// Wrap writes a wrapper script into a new temporary directory, which runs
+// the builder by nydusify sandbox, the returned script path can be used in
+// place of the builder path, and its directory should be removed after use.
+// The work directory and the extra paths are the only writable paths in
+// sandbox, the script is written out of them so that the builder can't
+// tamper with it.
+func Wrap(builderPath, workDir string, extraWritable ...string) (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", errors.New("sandbox is only supported on Linux")
+	}
+	builder, err := exec.LookPath(builderPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "find builder %s", builderPath)
+	}
+	builder, err = filepath.Abs(builder)
+	if err != nil {
+		return "", errors.Wrap(err, "get absolute builder path")
+	}
+	workDir, err = filepath.Abs(workDir)
+	if err != nil {
+		return "", errors.Wrap(err, "get absolute work directory")
+	}
+	writablePaths := []string{workDir}
+	writable := "--writable " + utils.ShellQuote(workDir)
+	for _, path := range extraWritable {
+		path, err = filepath.Abs(path)
+		if err != nil {
+			return "", errors.Wrap(err, "get absolute writable path")
+		}
+		writablePaths = append(writablePaths, path)
+		writable += " --writable " + utils.ShellQuote(path)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return "", errors.Wrap(err, "get nydusify executable")
+	}
+
+	wrapperDir, err := PrivateDir("nydusify-sandbox-", writablePaths...)
+	if err != nil {
+		return "", errors.Wrap(err, "prepare sandbox wrapper directory")
+	}
+
+	script := fmt.Sprintf(
+		"#!/bin/sh\nexec %s %s %s -- %s \"$@\"\n",
+		utils.ShellQuote(self), Command, writable, utils.ShellQuote(builder),
+	)
+	wrapperPath := filepath.Join(wrapperDir, wrapperName)
+	if err := os.WriteFile(wrapperPath, []byte(script), 0755); err != nil {
+		os.RemoveAll(wrapperDir)
+		return "", errors.Wrap(err, "write sandbox wrapper")
+	}
+
+	return wrapperPath, nil
+}
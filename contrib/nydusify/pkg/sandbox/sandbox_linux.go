@@ -0,0 +1,110 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Exec runs the command in sandbox, it doesn't return on success.
+//
+// The current process respawns itself in new mount, network (and user if
+// not root) namespaces, the respawned process sets up the read-only mounts
+// and seccomp filter, then replaces itself by the command.
+func Exec(writable []string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing command to run in sandbox")
+	}
+	if os.Getenv(envInit) == "" {
+		return respawn()
+	}
+
+	// The seccomp filter and no_new_privs are per-thread attributes, they
+	// are inherited by the command executed from the same thread.
+	runtime.LockOSThread()
+
+	if err := setupMounts(writable); err != nil {
+		return errors.Wrap(err, "setup sandbox mounts")
+	}
+	command, err := exec.LookPath(args[0])
+	if err != nil {
+		return errors.Wrapf(err, "find command %s", args[0])
+	}
+	if err := installSeccomp(); err != nil {
+		return errors.Wrap(err, "install seccomp filter")
+	}
+
+	env := []string{}
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, envInit+"=") {
+			env = append(env, e)
+		}
+	}
+
+	return syscall.Exec(command, args, env)
+}
+
+func respawn() error {
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), envInit+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		Pdeathsig:  syscall.SIGKILL,
+	}
+	if uid, gid := os.Getuid(), os.Getgid(); uid != 0 {
+		// Map the current user to itself, so that the files created in
+		// work directory are owned by the user.
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+	}
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		return errors.Wrap(err, "run sandbox")
+	}
+	os.Exit(0)
+	return nil
+}
+
+// setupMounts makes all mounts read-only except the writable paths.
+func setupMounts(writable []string) error {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return errors.Wrap(err, "make mounts private")
+	}
+	// Bind writable paths to themselves, so that they are separated
+	// mounts and can be set writable after root is set read-only.
+	for _, path := range writable {
+		if err := unix.Mount(path, path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return errors.Wrapf(err, "bind mount %s", path)
+		}
+	}
+	if err := unix.MountSetattr(unix.AT_FDCWD, "/", unix.AT_RECURSIVE, &unix.MountAttr{
+		Attr_set: unix.MOUNT_ATTR_RDONLY,
+	}); err != nil {
+		return errors.Wrap(err, "set root read-only")
+	}
+	for _, path := range writable {
+		if err := unix.MountSetattr(unix.AT_FDCWD, path, unix.AT_RECURSIVE, &unix.MountAttr{
+			Attr_clr: unix.MOUNT_ATTR_RDONLY,
+		}); err != nil {
+			return errors.Wrapf(err, "set %s writable", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package sandbox
+
+import "github.com/pkg/errors"
+
+// Exec fails as the sandbox relies on Linux namespaces and seccomp.
+func Exec(_ []string, _ []string) error {
+	return errors.New("sandbox is only supported on Linux")
+}
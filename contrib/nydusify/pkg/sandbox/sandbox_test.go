@@ -0,0 +1,58 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+func TestWrap(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("sandbox is only supported on Linux")
+	}
+	workDir := t.TempDir()
+	wrapperPath, err := Wrap("/bin/sh", workDir)
+	require.NoError(t, err)
+	defer os.RemoveAll(filepath.Dir(wrapperPath))
+	require.Equal(t, wrapperName, filepath.Base(wrapperPath))
+	// The wrapper is not writable in sandbox.
+	require.False(t, within(wrapperPath, workDir))
+
+	script, err := os.ReadFile(wrapperPath)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(script), "#!/bin/sh\nexec "))
+	require.Contains(t, string(script), Command+" --writable "+utils.ShellQuote(workDir)+" -- '/bin/sh' \"$@\"")
+
+	wrapperPath, err = Wrap("/bin/sh", workDir, "/tmp/unpack")
+	require.NoError(t, err)
+	defer os.RemoveAll(filepath.Dir(wrapperPath))
+	script, err = os.ReadFile(wrapperPath)
+	require.NoError(t, err)
+	require.Contains(t, string(script), " --writable "+utils.ShellQuote(workDir)+" --writable '/tmp/unpack' -- ")
+
+	_, err = Wrap("not-exist-builder", workDir)
+	require.Error(t, err)
+	_, err = Wrap("/bin/sh", os.TempDir())
+	require.ErrorContains(t, err, "is writable by builder")
+
+	dir, err := PrivateDir("nydusify-test-", workDir)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.False(t, within(dir, workDir))
+	_, err = PrivateDir("nydusify-test-", os.TempDir())
+	require.ErrorContains(t, err, "is writable by builder")
+
+	require.True(t, within("/a/b", "/a"))
+	require.True(t, within("/a", "/a"))
+	require.False(t, within("/ab", "/a"))
+}
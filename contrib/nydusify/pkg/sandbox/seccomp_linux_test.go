@@ -0,0 +1,32 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSeccompFilter(t *testing.T) {
+	filter := seccompFilter(unix.AUDIT_ARCH_X86_64)
+	require.Len(t, filter, 5+len(deniedSyscalls)+2)
+
+	errnoIdx := len(filter) - 1
+	// The syscalls of x32 ABI are rejected right after loading the number.
+	require.Equal(t, uint32(seccompDataNr), filter[3].K)
+	require.Equal(t, uint16(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K), filter[4].Code)
+	require.Equal(t, uint32(x32SyscallBit), filter[4].K)
+	require.Equal(t, errnoIdx, 4+1+int(filter[4].Jt))
+	require.Equal(t, uint8(0), filter[4].Jf)
+
+	for idx := range deniedSyscalls {
+		pos := 5 + idx
+		require.Equal(t, errnoIdx, pos+1+int(filter[pos].Jt))
+	}
+	require.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), filter[errnoIdx-1].K)
+	require.Equal(t, uint32(unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)), filter[errnoIdx].K)
+}
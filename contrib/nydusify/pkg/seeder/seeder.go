@@ -0,0 +1,324 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package seeder warms up the nodes running nydus-snapshotter for a
+// converted Nydus image, by registering the hot files of image as the
+// prefetch list through the system controller API of snapshotter, so that
+// nydusd prefetches them as soon as the image is started on the nodes.
+package seeder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/parser"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/provider"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// DefaultSystemSocket is the default unix socket of the system controller
+// of nydus-snapshotter.
+const DefaultSystemSocket = "/run/containerd-nydus/system.sock"
+
+// prefetchAPI is the endpoint of snapshotter to set the prefetch list of
+// images, which is passed to nydusd by `--prefetch-files` when the daemon
+// of image is started.
+const prefetchAPI = "/api/v1/prefetch"
+
+type Opt struct {
+	WorkDir        string
+	Target         string
+	TargetInsecure bool
+	NydusImagePath string
+	ExpectedArch   string
+	// Nodes are the system controller endpoints of snapshotters, in the
+	// form of `unix:///path/to/system.sock`, `/path/to/system.sock` or
+	// `http(s)://host:port`.
+	Nodes []string
+	// Files are the hot files to prefetch, the prefetch table of the
+	// image bootstrap is used if empty.
+	Files []string
+	// ListDir is the directory to save the prefetch list file, the same
+	// path is sent to snapshotters so it must be accessible on the nodes.
+	ListDir     string
+	Concurrency int
+	// Timeout is the timeout of request to each node.
+	Timeout time.Duration
+}
+
+// Node is the seeding result of a node.
+type Node struct {
+	Endpoint string `json:"endpoint"`
+	Error    string `json:"error,omitempty"`
+}
+
+type Result struct {
+	// Image is the normalized image reference registered in snapshotters.
+	Image    string `json:"image"`
+	ListPath string `json:"list_path"`
+	Files    int    `json:"files"`
+	Nodes    []Node `json:"nodes"`
+}
+
+// Seed registers the prefetch list of image in the snapshotters of nodes.
+// The failures of nodes are recorded in the result and returned as
+// utils.BatchError, the other nodes are seeded anyway.
+func Seed(ctx context.Context, opt Opt) (*Result, error) {
+	named, err := docker.ParseDockerRef(opt.Target)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse image reference %s", opt.Target)
+	}
+	clients := make([]*nodeClient, 0, len(opt.Nodes))
+	for _, endpoint := range opt.Nodes {
+		client, err := newNodeClient(endpoint, opt.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	files := opt.Files
+	if len(files) == 0 {
+		if files, err = prefetchTable(ctx, opt); err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no prefetch table in the bootstrap of %s, please specify the hot files", opt.Target)
+		}
+	}
+
+	result := &Result{
+		Image:    named.String(),
+		ListPath: listPath(opt.ListDir, named.String()),
+		Files:    len(files),
+	}
+	if err := writeList(result.ListPath, files); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Seeding %d files of %s to %d nodes", len(files), result.Image, len(clients))
+	result.Nodes = make([]Node, len(clients))
+	batchErr := utils.NewBatchError(len(clients))
+	eg, egCtx := errgroup.WithContext(ctx)
+	if opt.Concurrency > 0 {
+		eg.SetLimit(opt.Concurrency)
+	}
+	for idx, client := range clients {
+		idx, client := idx, client
+		eg.Go(func() error {
+			result.Nodes[idx].Endpoint = client.endpoint
+			if err := client.prefetch(egCtx, result.Image, result.ListPath); err != nil {
+				result.Nodes[idx].Error = err.Error()
+				batchErr.Add(client.endpoint, err)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, batchErr.ErrorOrNil()
+}
+
+// Print prints the seeding result as table, one row per node.
+func (result *Result) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tSTATUS\tERROR")
+	seeded := 0
+	for _, node := range result.Nodes {
+		status := "seeded"
+		if node.Error != "" {
+			status = "failed"
+		} else {
+			seeded++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", node.Endpoint, status, node.Error)
+	}
+	fmt.Fprintf(tw, "\n%d of %d nodes are seeded with %d files of %s in %s\n",
+		seeded, len(result.Nodes), result.Files, result.Image, result.ListPath)
+	return tw.Flush()
+}
+
+// ReadFiles reads the hot files from the list file, one path per line, the
+// empty lines and the lines starting with `#` are ignored.
+func ReadFiles(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read prefetch file")
+	}
+	files := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			return nil, fmt.Errorf("prefetch file %s should be absolute path in image", line)
+		}
+		files = append(files, line)
+	}
+	return files, nil
+}
+
+// prefetchTable pulls the bootstrap of image and lists the files in its
+// prefetch table, which are specified by `--prefetch-patterns` on
+// conversion.
+func prefetchTable(ctx context.Context, opt Opt) ([]string, error) {
+	targetRemote, err := provider.DefaultRemote(opt.Target, opt.TargetInsecure)
+	if err != nil {
+		return nil, errors.Wrap(err, "init target image parser")
+	}
+	targetParser, err := parser.New(targetRemote, opt.ExpectedArch)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create parser")
+	}
+	parsed, err := targetParser.Parse(ctx)
+	if err != nil && utils.RetryWithHTTP(err) {
+		targetParser.Remote.MaybeWithHTTP(err)
+		parsed, err = targetParser.Parse(ctx)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "parse Nydus image")
+	}
+	if parsed.NydusImage == nil {
+		return nil, fmt.Errorf("no Nydus manifest is found in %s", opt.Target)
+	}
+
+	if err := os.MkdirAll(opt.WorkDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create work directory")
+	}
+	bootstrapPath := filepath.Join(opt.WorkDir, "nydus_bootstrap")
+	bootstrapReader, err := targetParser.PullNydusBootstrap(ctx, parsed.NydusImage)
+	if err != nil {
+		return nil, errors.Wrap(err, "pull Nydus bootstrap layer")
+	}
+	defer bootstrapReader.Close()
+	if err := utils.UnpackFile(bootstrapReader, utils.BootstrapFileNameInLayer, bootstrapPath); err != nil {
+		return nil, errors.Wrap(err, "unpack Nydus bootstrap layer")
+	}
+
+	infos, err := tool.NewInspector(opt.NydusImagePath).Inspect(tool.InspectOption{
+		Operation: tool.GetPrefetch,
+		Bootstrap: bootstrapPath,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "inspect prefetch table")
+	}
+	return prefetchFiles(infos.(tool.PrefetchFileList)), nil
+}
+
+// prefetchFiles returns the sorted and deduplicated paths of the files in
+// prefetch table.
+func prefetchFiles(infos tool.PrefetchFileList) []string {
+	set := map[string]bool{}
+	for _, info := range infos {
+		for _, path := range info.Path {
+			set[path] = true
+		}
+	}
+	files := make([]string, 0, len(set))
+	for path := range set {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// listPath returns the path of prefetch list file of image, it's named by
+// the digest of image reference to be stable across seedings.
+func listPath(dir, image string) string {
+	sum := sha256.Sum256([]byte(image))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])[:16]+".list")
+}
+
+func writeList(path string, files []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "create prefetch list directory")
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(files, "\n")+"\n"), 0644); err != nil {
+		return errors.Wrap(err, "write prefetch list")
+	}
+	return nil
+}
+
+// nodeClient requests the system controller API of a snapshotter.
+type nodeClient struct {
+	endpoint string
+	url      string
+	client   *http.Client
+}
+
+func newNodeClient(endpoint string, timeout time.Duration) (*nodeClient, error) {
+	node := &nodeClient{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: timeout},
+	}
+	switch {
+	case strings.HasPrefix(endpoint, "http://"), strings.HasPrefix(endpoint, "https://"):
+		node.url = strings.TrimSuffix(endpoint, "/") + prefetchAPI
+	case strings.HasPrefix(endpoint, "unix://"), strings.HasPrefix(endpoint, "/"):
+		socket := strings.TrimPrefix(endpoint, "unix://")
+		if socket == "" {
+			return nil, fmt.Errorf("invalid node endpoint %s", endpoint)
+		}
+		node.url = "http://unix" + prefetchAPI
+		node.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+	default:
+		return nil, fmt.Errorf("invalid node endpoint %s, should be unix socket or http(s) url", endpoint)
+	}
+	return node, nil
+}
+
+func (node *nodeClient) prefetch(ctx context.Context, image, listPath string) error {
+	data, err := json.Marshal([]struct {
+		Image    string `json:"image"`
+		Prefetch string `json:"prefetch"`
+	}{{Image: image, Prefetch: listPath}})
+	if err != nil {
+		return errors.Wrap(err, "marshal prefetch request")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, node.url, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := node.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request snapshotter")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("request snapshotter: status %s, %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package seeder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/checker/tool"
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+type prefetchRequest struct {
+	Image    string `json:"image"`
+	Prefetch string `json:"prefetch"`
+}
+
+type snapshotter struct {
+	mutex    sync.Mutex
+	requests []prefetchRequest
+}
+
+func (s *snapshotter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut || r.URL.Path != prefetchAPI {
+		http.NotFound(w, r)
+		return
+	}
+	var requests []prefetchRequest
+	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mutex.Lock()
+	s.requests = append(s.requests, requests...)
+	s.mutex.Unlock()
+}
+
+func TestSeed(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	httpNode := &snapshotter{}
+	httpServer := httptest.NewServer(httpNode)
+	defer httpServer.Close()
+
+	unixNode := &snapshotter{}
+	socket := filepath.Join(tmpDir, "system.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	unixServer := &http.Server{Handler: unixNode}
+	go unixServer.Serve(listener)
+	defer unixServer.Close()
+
+	brokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "snapshotter is busy", http.StatusServiceUnavailable)
+	}))
+	defer brokenServer.Close()
+
+	listDir := filepath.Join(tmpDir, "prefetch")
+	opt := Opt{
+		Target:  "nginx:latest-nydus",
+		Nodes:   []string{httpServer.URL, "unix://" + socket, socket},
+		Files:   []string{"/usr/sbin/nginx", "/etc/nginx/nginx.conf"},
+		ListDir: listDir,
+		Timeout: 5 * time.Second,
+	}
+	result, err := Seed(context.Background(), opt)
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/library/nginx:latest-nydus", result.Image)
+	require.Equal(t, listPath(listDir, result.Image), result.ListPath)
+	require.Equal(t, 2, result.Files)
+	require.Len(t, result.Nodes, 3)
+
+	data, err := os.ReadFile(result.ListPath)
+	require.NoError(t, err)
+	require.Equal(t, "/usr/sbin/nginx\n/etc/nginx/nginx.conf\n", string(data))
+	expected := prefetchRequest{Image: result.Image, Prefetch: result.ListPath}
+	require.Equal(t, []prefetchRequest{expected}, httpNode.requests)
+	require.Equal(t, []prefetchRequest{expected, expected}, unixNode.requests)
+
+	// The failed node doesn't stop seeding the others.
+	opt.Nodes = []string{brokenServer.URL, httpServer.URL}
+	result, err = Seed(context.Background(), opt)
+	var batchErr *utils.BatchError
+	require.True(t, errors.As(err, &batchErr))
+	require.Len(t, batchErr.Failures, 1)
+	require.Equal(t, brokenServer.URL, batchErr.Failures[0].Item)
+	require.Contains(t, result.Nodes[0].Error, "snapshotter is busy")
+	require.Empty(t, result.Nodes[1].Error)
+	require.Len(t, httpNode.requests, 2)
+
+	var output bytes.Buffer
+	require.NoError(t, result.Print(&output))
+	require.Contains(t, output.String(), "1 of 2 nodes are seeded with 2 files of docker.io/library/nginx:latest-nydus")
+
+	opt.Nodes = []string{"tcp://127.0.0.1:8080"}
+	_, err = Seed(context.Background(), opt)
+	require.ErrorContains(t, err, "invalid node endpoint")
+}
+
+func TestReadFiles(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hot.list")
+	require.NoError(t, os.WriteFile(file, []byte("# hot files\n/usr/sbin/nginx\n\n  /etc/nginx/nginx.conf  \n"), 0644))
+	files, err := ReadFiles(file)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/usr/sbin/nginx", "/etc/nginx/nginx.conf"}, files)
+
+	require.NoError(t, os.WriteFile(file, []byte("usr/sbin/nginx\n"), 0644))
+	_, err = ReadFiles(file)
+	require.ErrorContains(t, err, "should be absolute path")
+}
+
+func TestPrefetchFiles(t *testing.T) {
+	require.Equal(t, []string{"/bin/ls", "/etc/hosts", "/usr/bin/ls"}, prefetchFiles(tool.PrefetchFileList{
+		{Inode: 3, Path: []string{"/usr/bin/ls", "/bin/ls"}},
+		{Inode: 2, Path: []string{"/etc/hosts"}},
+		{Inode: 3, Path: []string{"/bin/ls"}},
+	}))
+}
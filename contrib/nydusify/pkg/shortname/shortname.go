@@ -0,0 +1,206 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package shortname resolves the short image references without registry,
+// like `ubuntu:22.04`, by the site policy of unqualified search registries,
+// aliases and rewriting rules, in the spirit of containers-registries.conf.
+package shortname
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Rewrite replaces the prefix of fully qualified image references with the
+// location, e.g. `docker.io/library` with `mirror.example.com/library`.
+type Rewrite struct {
+	// Prefix matches the image names equal to it or under it at path
+	// boundary, the longest matched prefix wins.
+	Prefix   string `json:"prefix"`
+	Location string `json:"location"`
+}
+
+// Config is the site policy of resolving short image references, the
+// rewrites apply to the fully qualified references too.
+type Config struct {
+	// UnqualifiedSearchRegistries are tried in order for a short image
+	// reference, the first registry having the image wins, docker.io is
+	// used if empty.
+	UnqualifiedSearchRegistries []string `json:"unqualified_search_registries,omitempty"`
+	// Aliases map the short names without tag or digest to the fully
+	// qualified names, which take precedence over search registries.
+	Aliases  map[string]string `json:"aliases,omitempty"`
+	Rewrites []Rewrite         `json:"rewrites,omitempty"`
+}
+
+// Load loads the short name config from JSON file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read short name config")
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrap(err, "unmarshal short name config")
+	}
+	if err := config.validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid short name config %s", path)
+	}
+	return &config, nil
+}
+
+func (config *Config) validate() error {
+	for _, registry := range config.UnqualifiedSearchRegistries {
+		if registry == "" || strings.ContainsAny(registry, "/@") {
+			return fmt.Errorf("invalid unqualified search registry %q, should be a registry host", registry)
+		}
+	}
+	for name, location := range config.Aliases {
+		if !IsShortName(name) || strings.ContainsAny(name, ":@") {
+			return fmt.Errorf("invalid alias %q, should be a short name without tag or digest", name)
+		}
+		if _, err := reference.ParseNamed(location); err != nil || strings.ContainsAny(location, "@") {
+			return fmt.Errorf("invalid location %q of alias %s, should be a fully qualified name without tag or digest", location, name)
+		}
+	}
+	for idx, rewrite := range config.Rewrites {
+		if !isDomain(strings.SplitN(rewrite.Prefix, "/", 2)[0]) {
+			return fmt.Errorf("invalid prefix %q of rewrite %d, should be fully qualified", rewrite.Prefix, idx)
+		}
+		if !isDomain(strings.SplitN(rewrite.Location, "/", 2)[0]) {
+			return fmt.Errorf("invalid location %q of rewrite %d, should be fully qualified", rewrite.Location, idx)
+		}
+	}
+	return nil
+}
+
+// isDomain returns true if the path component is a registry host with dot
+// or port, or localhost.
+func isDomain(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// IsShortName returns true if the image reference has no registry, i.e.
+// its first path component isn't a registry host.
+func IsShortName(ref string) bool {
+	idx := strings.Index(ref, "/")
+	return idx < 0 || !isDomain(ref[:idx])
+}
+
+// splitName splits the short reference into the name and the suffix of
+// tag or digest.
+func splitName(ref string) (string, string) {
+	if idx := strings.Index(ref, "@"); idx >= 0 {
+		return ref[:idx], ref[idx:]
+	}
+	if idx := strings.LastIndex(ref, ":"); idx > strings.LastIndex(ref, "/") {
+		return ref[:idx], ref[idx:]
+	}
+	return ref, ""
+}
+
+// rewrite applies the rewrite of the longest matched prefix to the fully
+// qualified reference, the reference is returned as is if none matches.
+func (config *Config) rewrite(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid image reference %s", ref)
+	}
+	var matched *Rewrite
+	for idx := range config.Rewrites {
+		rewrite := &config.Rewrites[idx]
+		if named.Name() != rewrite.Prefix && !strings.HasPrefix(named.Name(), rewrite.Prefix+"/") {
+			continue
+		}
+		if matched == nil || len(rewrite.Prefix) > len(matched.Prefix) {
+			matched = rewrite
+		}
+	}
+	if matched == nil {
+		return ref, nil
+	}
+
+	rewritten := matched.Location + strings.TrimPrefix(named.String(), matched.Prefix)
+	if _, err := reference.ParseNormalizedNamed(rewritten); err != nil {
+		return "", errors.Wrapf(err, "invalid image reference %s rewritten from %s", rewritten, ref)
+	}
+	return rewritten, nil
+}
+
+// Candidates returns the fully qualified image references of ref in the
+// order of search registries, a single one is returned for the fully
+// qualified reference, alias or the config without search registries.
+func (config *Config) Candidates(ref string) ([]string, error) {
+	if IsShortName(ref) {
+		name, suffix := splitName(ref)
+		if location, ok := config.Aliases[name]; ok {
+			ref = location + suffix
+		} else if len(config.UnqualifiedSearchRegistries) > 0 {
+			candidates := []string{}
+			for _, registry := range config.UnqualifiedSearchRegistries {
+				candidate, err := config.rewrite(registry + "/" + ref)
+				if err != nil {
+					return nil, err
+				}
+				candidates = append(candidates, candidate)
+			}
+			return candidates, nil
+		}
+	}
+	candidate, err := config.rewrite(ref)
+	if err != nil {
+		return nil, err
+	}
+	return []string{candidate}, nil
+}
+
+// Resolve resolves ref to the first candidate existing according to the
+// exists function, which is only called for multiple candidates.
+func (config *Config) Resolve(ctx context.Context, ref string, exists func(context.Context, string) (bool, error)) (string, error) {
+	candidates, err := config.Candidates(ref)
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 1 {
+		if candidates[0] != ref {
+			logrus.Infof("Resolved image reference %s to %s", ref, candidates[0])
+		}
+		return candidates[0], nil
+	}
+
+	failures := []string{}
+	for _, candidate := range candidates {
+		found, err := exists(ctx, candidate)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", candidate, err))
+			continue
+		}
+		if found {
+			logrus.Infof("Resolved short name %s to %s", ref, candidate)
+			return candidate, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: not found", candidate))
+	}
+	return "", fmt.Errorf("short name %s isn't found in unqualified search registries: %s", ref, strings.Join(failures, "; "))
+}
+
+// First resolves ref to the first candidate without checking existence,
+// it's for the images to be pushed.
+func (config *Config) First(ref string) (string, error) {
+	candidates, err := config.Candidates(ref)
+	if err != nil {
+		return "", err
+	}
+	if candidates[0] != ref {
+		logrus.Infof("Resolved image reference %s to %s", ref, candidates[0])
+	}
+	return candidates[0], nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package shortname
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCandidates(t *testing.T) {
+	config := &Config{
+		UnqualifiedSearchRegistries: []string{"registry.example.com", "docker.io"},
+		Aliases: map[string]string{
+			"busybox": "quay.io/prometheus/busybox",
+		},
+		Rewrites: []Rewrite{
+			{Prefix: "docker.io/library", Location: "mirror.example.com/dockerhub"},
+			{Prefix: "docker.io/library/nginx", Location: "mirror.example.com/nginx"},
+		},
+	}
+	for _, tc := range []struct {
+		ref        string
+		candidates []string
+	}{
+		{"ubuntu:22.04", []string{"registry.example.com/ubuntu:22.04", "mirror.example.com/dockerhub/ubuntu:22.04"}},
+		{"library/ubuntu", []string{"registry.example.com/library/ubuntu", "mirror.example.com/dockerhub/ubuntu"}},
+		{"nginx:1.25", []string{"registry.example.com/nginx:1.25", "mirror.example.com/nginx:1.25"}},
+		{"busybox:1.36", []string{"quay.io/prometheus/busybox:1.36"}},
+		{"localhost/ubuntu:22.04", []string{"localhost/ubuntu:22.04"}},
+		{"docker.io/library/ubuntu@sha256:0000000000000000000000000000000000000000000000000000000000000000", []string{"mirror.example.com/dockerhub/ubuntu@sha256:0000000000000000000000000000000000000000000000000000000000000000"}},
+		{"docker.io/libraryx/ubuntu", []string{"docker.io/libraryx/ubuntu"}},
+		{"quay.io/app:v1", []string{"quay.io/app:v1"}},
+	} {
+		candidates, err := config.Candidates(tc.ref)
+		require.NoError(t, err, tc.ref)
+		require.Equal(t, tc.candidates, candidates, tc.ref)
+	}
+
+	// The short name is kept without search registries.
+	candidates, err := (&Config{}).Candidates("ubuntu:22.04")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ubuntu:22.04"}, candidates)
+}
+
+func TestResolve(t *testing.T) {
+	config := &Config{UnqualifiedSearchRegistries: []string{"a.example.com", "b.example.com", "c.example.com"}}
+	checked := []string{}
+	ref, err := config.Resolve(context.Background(), "app:v1", func(_ context.Context, ref string) (bool, error) {
+		checked = append(checked, ref)
+		if ref == "a.example.com/app:v1" {
+			return false, errors.New("unauthorized")
+		}
+		return ref == "b.example.com/app:v1", nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "b.example.com/app:v1", ref)
+	require.Equal(t, []string{"a.example.com/app:v1", "b.example.com/app:v1"}, checked)
+
+	_, err = config.Resolve(context.Background(), "app:v2", func(_ context.Context, ref string) (bool, error) {
+		return false, nil
+	})
+	require.ErrorContains(t, err, "short name app:v2 isn't found in unqualified search registries: a.example.com/app:v2: not found")
+
+	// The single candidate isn't checked.
+	ref, err = config.Resolve(context.Background(), "quay.io/app:v1", nil)
+	require.NoError(t, err)
+	require.Equal(t, "quay.io/app:v1", ref)
+	ref, err = config.First("app:v1")
+	require.NoError(t, err)
+	require.Equal(t, "a.example.com/app:v1", ref)
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-names.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"unqualified_search_registries": ["registry.example.com"],
+		"aliases": {"ubuntu": "docker.io/library/ubuntu"},
+		"rewrites": [{"prefix": "docker.io", "location": "mirror.example.com"}]
+	}`), 0644))
+	config, err := Load(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"registry.example.com"}, config.UnqualifiedSearchRegistries)
+	candidates, err := config.Candidates("ubuntu:22.04")
+	require.NoError(t, err)
+	require.Equal(t, []string{"mirror.example.com/library/ubuntu:22.04"}, candidates)
+
+	for _, invalid := range []string{
+		`{"unqualified_search_registries": ["example.com/ns"]}`,
+		`{"aliases": {"ubuntu:22.04": "docker.io/library/ubuntu"}}`,
+		`{"aliases": {"ubuntu": "ubuntu"}}`,
+		`{"rewrites": [{"prefix": "library", "location": "mirror.example.com"}]}`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(invalid), 0644))
+		_, err := Load(path)
+		require.ErrorContains(t, err, "invalid short name config", invalid)
+	}
+}
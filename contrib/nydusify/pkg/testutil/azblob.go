@@ -0,0 +1,132 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package testutil
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+func writeAzblobError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("X-Ms-Error-Code", code)
+	writeError(w, r, status, code)
+}
+
+// serveAzblob serves the Azure Blob APIs of block blob: put blob, put block,
+// put block list, get with range, head, set tier and set tags.
+func (storage *ObjectStorage) serveAzblob(w http.ResponseWriter, r *http.Request, name string, query url.Values) {
+	var data []byte
+	if r.Method == http.MethodPut {
+		var err error
+		if data, err = io.ReadAll(r.Body); err != nil {
+			writeAzblobError(w, r, http.StatusBadRequest, "InvalidInput")
+			return
+		}
+		if expected := r.Header.Get("Content-Md5"); expected != "" {
+			sum := md5.Sum(data)
+			if base64.StdEncoding.EncodeToString(sum[:]) != expected {
+				writeAzblobError(w, r, http.StatusBadRequest, "Md5Mismatch")
+				return
+			}
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		if storage.blocks[name] == nil {
+			storage.blocks[name] = map[string][]byte{}
+		}
+		storage.blocks[name][query.Get("blockid")] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var blockList struct {
+			Blocks []string `xml:"Latest"`
+		}
+		if err := xml.Unmarshal(data, &blockList); err != nil {
+			writeAzblobError(w, r, http.StatusBadRequest, "InvalidXmlDocument")
+			return
+		}
+		object := Object{Data: []byte{}, StorageClass: r.Header.Get("X-Ms-Access-Tier"), Tags: parseTagging(r.Header.Get("X-Ms-Tags"))}
+		for _, id := range blockList.Blocks {
+			block, ok := storage.blocks[name][id]
+			if !ok {
+				writeAzblobError(w, r, http.StatusBadRequest, "InvalidBlockList")
+				return
+			}
+			object.Data = append(object.Data, block...)
+		}
+		delete(storage.blocks, name)
+		storage.objects[name] = object
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "tier":
+		object, ok := storage.objects[name]
+		if !ok {
+			writeAzblobError(w, r, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		object.StorageClass = r.Header.Get("X-Ms-Access-Tier")
+		storage.objects[name] = object
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && query.Get("comp") == "tags":
+		object, ok := storage.objects[name]
+		if !ok {
+			writeAzblobError(w, r, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		var t tagging
+		if err := xml.Unmarshal(data, &t); err != nil {
+			writeAzblobError(w, r, http.StatusBadRequest, "InvalidXmlDocument")
+			return
+		}
+		object.Tags = map[string]string{}
+		for _, tag := range t.Tags {
+			object.Tags[tag.Key] = tag.Value
+		}
+		storage.objects[name] = object
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("X-Ms-Blob-Type") == "BlockBlob":
+		storage.objects[name] = Object{Data: data, StorageClass: r.Header.Get("X-Ms-Access-Tier"), Tags: parseTagging(r.Header.Get("X-Ms-Tags"))}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := storage.objects[name]
+		if !ok {
+			writeAzblobError(w, r, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		data := object.Data
+		w.Header().Set("X-Ms-Blob-Type", "BlockBlob")
+		if object.StorageClass != "" {
+			w.Header().Set("X-Ms-Access-Tier", object.StorageClass)
+		}
+		if r.Method == http.MethodGet && r.Header.Get("X-Ms-Range") != "" {
+			var start, end int
+			if _, err := fmt.Sscanf(r.Header.Get("X-Ms-Range"), "bytes=%d-%d", &start, &end); err != nil || start > end || start >= len(data) {
+				writeAzblobError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+				return
+			}
+			if end >= len(data) {
+				end = len(data) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[start : end+1])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	default:
+		writeAzblobError(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
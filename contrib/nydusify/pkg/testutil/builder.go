@@ -0,0 +1,119 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NydusImageOption scripts the behavior of the nydus-image stub.
+type NydusImageOption struct {
+	// Blobs are the blob ids written into the file of `--output-json`.
+	Blobs []string
+	// Bootstrap is written into the file of `--bootstrap` for `create` and
+	// `merge`, and of `--output-bootstrap`, Blob is written into the file
+	// of `--blob`.
+	Bootstrap []byte
+	Blob      []byte
+	// Stdout is printed to stdout, e.g. the inodes of `check --verbose`.
+	Stdout string
+	// Stderr is printed to stderr, and ExitCode is the exit code of stub,
+	// which are used to simulate the failure of nydus-image.
+	Stderr   string
+	ExitCode int
+	// Version is printed by `--version`, default to v2.2.0.
+	Version string
+}
+
+// NydusImage is a shell script standing in for the nydus-image binary, it
+// records the arguments of each invocation except `--version` and writes
+// the scripted outputs to the paths from its arguments, without parsing or
+// building anything.
+type NydusImage struct {
+	// Path is the executable path to be used as the builder path.
+	Path string
+	dir  string
+}
+
+const nydusImageScript = `#!/bin/sh
+DIR=%q
+if [ "$1" = --version ]; then
+	printf ' \rVersion: \t%%s\nGit Commit: \tstub\n' "$(cat "$DIR/version")"
+	exit 0
+fi
+echo "$*" >> "$DIR/calls"
+CMD="$1"
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--bootstrap) { [ "$CMD" = create ] || [ "$CMD" = merge ]; } && cp "$DIR/bootstrap.data" "$2"; shift ;;
+	--output-bootstrap) cp "$DIR/bootstrap.data" "$2"; shift ;;
+	--blob) cp "$DIR/blob.data" "$2"; shift ;;
+	--output-json) cp "$DIR/output.json" "$2"; shift ;;
+	esac
+	shift
+done
+cat "$DIR/stdout"
+cat "$DIR/stderr" >&2
+exit %d
+`
+
+// NewNydusImage writes the nydus-image stub into dir.
+func NewNydusImage(dir string, option NydusImageOption) (*NydusImage, error) {
+	if option.Version == "" {
+		option.Version = "v2.2.0"
+	}
+	if option.Blobs == nil {
+		option.Blobs = []string{}
+	}
+	output, err := json.Marshal(map[string]interface{}{
+		"version": option.Version,
+		"blobs":   option.Blobs,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal output json")
+	}
+	files := map[string][]byte{
+		"output.json":    output,
+		"bootstrap.data": option.Bootstrap,
+		"blob.data":      option.Blob,
+		"stdout":         []byte(option.Stdout),
+		"stderr":         []byte(option.Stderr),
+		"version":        []byte(option.Version),
+		"calls":          {},
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return nil, errors.Wrapf(err, "write %s", name)
+		}
+	}
+
+	path := filepath.Join(dir, "nydus-image")
+	script := fmt.Sprintf(nydusImageScript, dir, option.ExitCode)
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		return nil, errors.Wrap(err, "write nydus-image stub")
+	}
+	return &NydusImage{Path: path, dir: dir}, nil
+}
+
+// Calls returns the arguments of each invocation in order.
+func (stub *NydusImage) Calls() ([][]string, error) {
+	data, err := os.ReadFile(filepath.Join(stub.dir, "calls"))
+	if err != nil {
+		return nil, errors.Wrap(err, "read calls")
+	}
+	calls := [][]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line != "" {
+			calls = append(calls, strings.Fields(line))
+		}
+	}
+	return calls, nil
+}
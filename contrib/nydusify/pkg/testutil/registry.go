@@ -0,0 +1,328 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package testutil provides the fake servers to write integration tests for
+// the nydusify packages without real infrastructure: an in-memory OCI
+// registry, a fake OSS/S3 object storage, and a scripted nydus-image stub.
+package testutil
+
+import (
+	// Register the hash implementations of the supported digest algorithms.
+	_ "crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type manifest struct {
+	mediaType string
+	data      []byte
+	digest    digest.Digest
+}
+
+// Registry is an in-memory OCI distribution registry served by plain HTTP,
+// it supports pulling, pushing and deleting manifests, pulling and pushing
+// blobs, cross repository blob mount and tag listing, without
+// authentication.
+type Registry struct {
+	server *httptest.Server
+
+	mutex     sync.Mutex
+	manifests map[string]manifest
+	tags      map[string]map[string]bool
+	blobs     map[string][]byte
+	uploads   map[string][]byte
+	mounts    []digest.Digest
+	pushed    []digest.Digest
+	uploadSeq int
+	// digestAlgorithm digests the manifests pushed by tag.
+	digestAlgorithm digest.Algorithm
+}
+
+// NewRegistry starts an in-memory registry, it should be closed by Close.
+func NewRegistry() *Registry {
+	registry := &Registry{
+		manifests: map[string]manifest{},
+		tags:      map[string]map[string]bool{},
+		blobs:     map[string][]byte{},
+		uploads:   map[string][]byte{},
+	}
+	registry.server = httptest.NewServer(registry)
+	return registry
+}
+
+// SetDigestAlgorithm makes the manifests pushed by tag digested by alg as
+// the registries supporting other algorithms than sha256 do, the manifests
+// pushed by digest are always digested by the algorithm of the digest.
+func (registry *Registry) SetDigestAlgorithm(alg digest.Algorithm) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.digestAlgorithm = alg
+}
+
+// Host returns the address of registry, which is used as the domain of
+// image references, for example `<host>/library/nginx:latest`.
+func (registry *Registry) Host() string {
+	return strings.TrimPrefix(registry.server.URL, "http://")
+}
+
+// Close shuts down the registry.
+func (registry *Registry) Close() {
+	registry.server.Close()
+}
+
+// PutBlob stores the blob in repository and returns its descriptor.
+func (registry *Registry) PutBlob(repo, mediaType string, data []byte) ocispec.Descriptor {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	dgst := digest.FromBytes(data)
+	registry.blobs[repo+"@"+dgst.String()] = data
+	return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+}
+
+// PutManifest stores the manifest or index in repository, tags it if tag
+// isn't empty, and returns its descriptor.
+func (registry *Registry) PutManifest(repo, tag, mediaType string, v interface{}) (ocispec.Descriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	return registry.putManifest(repo, tag, mediaType, data), nil
+}
+
+func (registry *Registry) putManifest(repo, reference, mediaType string, data []byte) ocispec.Descriptor {
+	alg := digest.Canonical
+	if registry.digestAlgorithm != "" {
+		alg = registry.digestAlgorithm
+	}
+	if dgst, err := digest.Parse(reference); err == nil {
+		alg = dgst.Algorithm()
+	}
+	dgst := alg.FromBytes(data)
+	m := manifest{mediaType: mediaType, data: data, digest: dgst}
+	registry.manifests[repo+"@"+dgst.String()] = m
+	if reference != "" && reference != dgst.String() {
+		registry.manifests[repo+":"+reference] = m
+		if registry.tags[repo] == nil {
+			registry.tags[repo] = map[string]bool{}
+		}
+		registry.tags[repo][reference] = true
+	}
+	return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+}
+
+// Manifest returns the manifest data and media type by tag or digest.
+func (registry *Registry) Manifest(repo, reference string) ([]byte, string, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	m, ok := registry.manifests[registry.manifestKey(repo, reference)]
+	return m.data, m.mediaType, ok
+}
+
+// Blob returns the blob data in repository.
+func (registry *Registry) Blob(repo string, dgst digest.Digest) ([]byte, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	data, ok := registry.blobs[repo+"@"+dgst.String()]
+	return data, ok
+}
+
+// Mounts returns the blobs mounted from other repositories.
+func (registry *Registry) Mounts() []digest.Digest {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	return append([]digest.Digest{}, registry.mounts...)
+}
+
+// Uploads returns the blobs uploaded by clients.
+func (registry *Registry) Uploads() []digest.Digest {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	return append([]digest.Digest{}, registry.pushed...)
+}
+
+func (registry *Registry) manifestKey(repo, reference string) string {
+	if _, err := digest.Parse(reference); err == nil {
+		return repo + "@" + reference
+	}
+	return repo + ":" + reference
+}
+
+func (registry *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case r.URL.Path == "/v2/" || r.URL.Path == "/v2":
+		w.WriteHeader(http.StatusOK)
+	case strings.HasSuffix(path, "/tags/list"):
+		registry.serveTags(w, strings.TrimSuffix(path, "/tags/list"))
+	case strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		registry.serveManifest(w, r, parts[0], parts[1])
+	case strings.Contains(path, "/blobs/uploads/"):
+		parts := strings.SplitN(path, "/blobs/uploads/", 2)
+		registry.serveUpload(w, r, parts[0], parts[1])
+	case strings.Contains(path, "/blobs/"):
+		parts := strings.SplitN(path, "/blobs/", 2)
+		registry.serveBlob(w, r, parts[0], parts[1])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (registry *Registry) serveTags(w http.ResponseWriter, repo string) {
+	tags := []string{}
+	for tag := range registry.tags[repo] {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": repo, "tags": tags})
+}
+
+func (registry *Registry) serveManifest(w http.ResponseWriter, r *http.Request, repo, reference string) {
+	if r.Method == http.MethodPut {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		desc := registry.putManifest(repo, reference, r.Header.Get("Content-Type"), data)
+		w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", repo, desc.Digest))
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		registry.deleteManifest(w, repo, reference)
+		return
+	}
+	m, ok := registry.manifests[registry.manifestKey(repo, reference)]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", m.mediaType)
+	w.Header().Set("Docker-Content-Digest", m.digest.String())
+	w.Header().Set("Content-Length", strconv.Itoa(len(m.data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(m.data)
+	}
+}
+
+// deleteManifest deletes the manifest of digest and untags all the tags
+// pointing to it as distribution does.
+func (registry *Registry) deleteManifest(w http.ResponseWriter, repo, reference string) {
+	if _, err := digest.Parse(reference); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if _, ok := registry.manifests[repo+"@"+reference]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	delete(registry.manifests, repo+"@"+reference)
+	for tag := range registry.tags[repo] {
+		if registry.manifests[repo+":"+tag].digest.String() == reference {
+			delete(registry.manifests, repo+":"+tag)
+			delete(registry.tags[repo], tag)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (registry *Registry) serveBlob(w http.ResponseWriter, r *http.Request, repo, dgst string) {
+	data, ok := registry.blobs[repo+"@"+dgst]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Docker-Content-Digest", dgst)
+	if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil && start <= end && end < len(data) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[start : end+1])
+			return
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func (registry *Registry) serveUpload(w http.ResponseWriter, r *http.Request, repo, session string) {
+	switch r.Method {
+	case http.MethodPost:
+		query := r.URL.Query()
+		if from, mount := query.Get("from"), query.Get("mount"); from != "" && mount != "" {
+			if data, ok := registry.blobs[from+"@"+mount]; ok {
+				registry.blobs[repo+"@"+mount] = data
+				registry.mounts = append(registry.mounts, digest.Digest(mount))
+				w.Header().Set("Docker-Content-Digest", mount)
+				w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, mount))
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		registry.uploadSeq++
+		session = strconv.Itoa(registry.uploadSeq)
+		registry.uploads[session] = []byte{}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, session))
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+
+	case http.MethodPatch, http.MethodPut:
+		data, ok := registry.uploads[session]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data = append(data, body...)
+		if r.Method == http.MethodPatch {
+			registry.uploads[session] = data
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, session))
+			w.Header().Set("Range", fmt.Sprintf("0-%d", len(data)-1))
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		delete(registry.uploads, session)
+		expected := r.URL.Query().Get("digest")
+		if dgst, err := digest.Parse(expected); err != nil || dgst.Algorithm().FromBytes(data) != dgst {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		registry.blobs[repo+"@"+expected] = data
+		registry.pushed = append(registry.pushed, digest.Digest(expected))
+		w.Header().Set("Docker-Content-Digest", expected)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, expected))
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
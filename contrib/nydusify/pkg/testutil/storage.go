@@ -0,0 +1,447 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package testutil
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object is an object stored in the fake object storage.
+type Object struct {
+	Data         []byte
+	StorageClass string
+	Tags         map[string]string
+}
+
+type multipartUpload struct {
+	bucket string
+	key    string
+	object Object
+	parts  map[int][]byte
+}
+
+// ObjectStorage is an in-memory object storage speaking the subset of
+// OSS and S3 APIs used by the storage backends: object put, get with range,
+// head, copy, tagging and multipart upload. The buckets are addressed in
+// path-style, that is `http://<endpoint>/<bucket>/<key>`, which is used by
+// the S3 backend and by the OSS SDK for an IP endpoint. The Azure Blob
+// requests, identified by `x-ms-version` header, are served as well with
+// the container as bucket. Requests are not authenticated.
+type ObjectStorage struct {
+	server *httptest.Server
+
+	mutex     sync.Mutex
+	objects   map[string]Object
+	uploads   map[string]*multipartUpload
+	blocks    map[string]map[string][]byte
+	uploadSeq int
+}
+
+// NewObjectStorage starts a fake object storage, it should be closed by
+// Close.
+func NewObjectStorage() *ObjectStorage {
+	storage := &ObjectStorage{
+		objects: map[string]Object{},
+		uploads: map[string]*multipartUpload{},
+		blocks:  map[string]map[string][]byte{},
+	}
+	storage.server = httptest.NewServer(storage)
+	return storage
+}
+
+// Endpoint returns the address of storage without scheme, which is used as
+// the `endpoint` of S3 backend config with `http` scheme.
+func (storage *ObjectStorage) Endpoint() string {
+	return strings.TrimPrefix(storage.server.URL, "http://")
+}
+
+// URL returns the address of storage with scheme, which is used as the
+// `endpoint` of OSS backend config.
+func (storage *ObjectStorage) URL() string {
+	return storage.server.URL
+}
+
+// Close shuts down the storage.
+func (storage *ObjectStorage) Close() {
+	storage.server.Close()
+}
+
+// PutObject stores the object data in bucket.
+func (storage *ObjectStorage) PutObject(bucket, key string, data []byte) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.objects[bucket+"/"+key] = Object{Data: data, Tags: map[string]string{}}
+}
+
+// Object returns the object in bucket.
+func (storage *ObjectStorage) Object(bucket, key string) (Object, bool) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	object, ok := storage.objects[bucket+"/"+key]
+	return object, ok
+}
+
+// Keys returns the sorted object keys in bucket.
+func (storage *ObjectStorage) Keys(bucket string) []string {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	keys := []string{}
+	for name := range storage.objects {
+		if strings.HasPrefix(name, bucket+"/") {
+			keys = append(keys, strings.TrimPrefix(name, bucket+"/"))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// header returns the header with either OSS or S3 prefix.
+func header(r *http.Request, name string) string {
+	if value := r.Header.Get("X-Oss-" + name); value != "" {
+		return value
+	}
+	return r.Header.Get("X-Amz-" + name)
+}
+
+func parseTagging(query string) map[string]string {
+	tags := map[string]string{}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return tags
+	}
+	for key := range values {
+		tags[key] = values.Get(key)
+	}
+	return tags
+}
+
+type tagging struct {
+	Tags []struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	} `xml:"TagSet>Tag"`
+}
+
+// readBody reads request body, and decodes it if it's in aws-chunked
+// encoding used by S3 SDK for trailing checksum.
+func readBody(r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") &&
+		!strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return data, nil
+	}
+	reader := bufio.NewReader(bytes.NewReader(data))
+	decoded := []byte{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("invalid aws-chunked body: %v", err)
+		}
+		sizeHex := strings.SplitN(strings.TrimSpace(line), ";", 2)[0]
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid aws-chunked size %q", sizeHex)
+		}
+		if size == 0 {
+			// The trailers including checksum are ignored.
+			return decoded, nil
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, fmt.Errorf("invalid aws-chunked chunk: %v", err)
+		}
+		decoded = append(decoded, chunk[:size]...)
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func crc64ECMA(data []byte) string {
+	return strconv.FormatUint(crc64.Checksum(data, crc64.MakeTable(crc64.ECMA)), 10)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+	}
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
+
+func (storage *ObjectStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented")
+		return
+	}
+	bucket, key := parts[0], parts[1]
+	query := r.URL.Query()
+	if r.Header.Get("X-Ms-Version") != "" {
+		storage.serveAzblob(w, r, bucket+"/"+key, query)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		storage.initiateUpload(w, r, bucket, key)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		storage.uploadPart(w, r, query)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		storage.completeUpload(w, r, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(storage.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && query.Has("tagging"):
+		storage.putTagging(w, r, bucket+"/"+key)
+	case r.Method == http.MethodPut && header(r, "Copy-Source") != "":
+		storage.copyObject(w, r, bucket+"/"+key)
+	case r.Method == http.MethodPut:
+		storage.putObject(w, r, bucket+"/"+key)
+	case r.Method == http.MethodGet && query.Has("attributes"):
+		storage.objectAttributes(w, r, bucket+"/"+key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		storage.getObject(w, r, bucket+"/"+key)
+	case r.Method == http.MethodDelete:
+		delete(storage.objects, bucket+"/"+key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func newObject(r *http.Request, data []byte) Object {
+	return Object{
+		Data:         data,
+		StorageClass: header(r, "Storage-Class"),
+		Tags:         parseTagging(header(r, "Tagging")),
+	}
+}
+
+func (storage *ObjectStorage) putObject(w http.ResponseWriter, r *http.Request, name string) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+	storage.objects[name] = newObject(r, data)
+	w.Header().Set("ETag", etag(data))
+	w.Header().Set("X-Oss-Hash-Crc64ecma", crc64ECMA(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (storage *ObjectStorage) getObject(w http.ResponseWriter, r *http.Request, name string) {
+	object, ok := storage.objects[name]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	data := object.Data
+	w.Header().Set("ETag", etag(object.Data))
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	if object.StorageClass != "" {
+		w.Header().Set("X-Oss-Storage-Class", object.StorageClass)
+		w.Header().Set("X-Amz-Storage-Class", object.StorageClass)
+	}
+	if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil || start > end || start >= len(data) {
+			writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		if end >= len(data) {
+			end = len(data) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[start : end+1])
+		return
+	}
+	w.Header().Set("X-Oss-Hash-Crc64ecma", crc64ECMA(data))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func (storage *ObjectStorage) objectAttributes(w http.ResponseWriter, r *http.Request, name string) {
+	object, ok := storage.objects[name]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	writeXML(w, struct {
+		XMLName      xml.Name `xml:"GetObjectAttributesOutput"`
+		ObjectSize   int      `xml:"ObjectSize"`
+		StorageClass string   `xml:"StorageClass,omitempty"`
+	}{ObjectSize: len(object.Data), StorageClass: object.StorageClass})
+}
+
+func (storage *ObjectStorage) copyObject(w http.ResponseWriter, r *http.Request, name string) {
+	source, err := url.PathUnescape(strings.TrimPrefix(header(r, "Copy-Source"), "/"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	object, ok := storage.objects[source]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	copied := Object{Data: object.Data, StorageClass: object.StorageClass, Tags: object.Tags}
+	if class := header(r, "Storage-Class"); class != "" {
+		copied.StorageClass = class
+	}
+	if strings.EqualFold(header(r, "Tagging-Directive"), "REPLACE") {
+		copied.Tags = parseTagging(header(r, "Tagging"))
+	}
+	storage.objects[name] = copied
+	writeXML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		LastModified string   `xml:"LastModified"`
+		ETag         string   `xml:"ETag"`
+	}{LastModified: time.Now().UTC().Format(time.RFC3339), ETag: etag(object.Data)})
+}
+
+func (storage *ObjectStorage) putTagging(w http.ResponseWriter, r *http.Request, name string) {
+	object, ok := storage.objects[name]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+	var t tagging
+	if err := xml.Unmarshal(data, &t); err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	object.Tags = map[string]string{}
+	for _, tag := range t.Tags {
+		object.Tags[tag.Key] = tag.Value
+	}
+	storage.objects[name] = object
+	w.WriteHeader(http.StatusOK)
+}
+
+func (storage *ObjectStorage) initiateUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	storage.uploadSeq++
+	uploadID := fmt.Sprintf("upload-%d", storage.uploadSeq)
+	storage.uploads[uploadID] = &multipartUpload{
+		bucket: bucket,
+		key:    key,
+		object: newObject(r, nil),
+		parts:  map[int][]byte{},
+	}
+	writeXML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}{Bucket: bucket, Key: key, UploadID: uploadID})
+}
+
+func (storage *ObjectStorage) uploadPart(w http.ResponseWriter, r *http.Request, query url.Values) {
+	upload, ok := storage.uploads[query.Get("uploadId")]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+	upload.parts[number] = data
+	w.Header().Set("ETag", etag(data))
+	w.Header().Set("X-Oss-Hash-Crc64ecma", crc64ECMA(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (storage *ObjectStorage) completeUpload(w http.ResponseWriter, r *http.Request, uploadID string) {
+	upload, ok := storage.uploads[uploadID]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+	var complete struct {
+		Parts []struct {
+			PartNumber int `xml:"PartNumber"`
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &complete); err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	sort.Slice(complete.Parts, func(i, j int) bool {
+		return complete.Parts[i].PartNumber < complete.Parts[j].PartNumber
+	})
+	data := []byte{}
+	for _, part := range complete.Parts {
+		partData, ok := upload.parts[part.PartNumber]
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		data = append(data, partData...)
+	}
+	delete(storage.uploads, uploadID)
+
+	object := upload.object
+	object.Data = data
+	storage.objects[upload.bucket+"/"+upload.key] = object
+	w.Header().Set("X-Oss-Hash-Crc64ecma", crc64ECMA(data))
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string   `xml:"Bucket"`
+		Key     string   `xml:"Key"`
+		ETag    string   `xml:"ETag"`
+	}{Bucket: upload.bucket, Key: upload.key, ETag: etag(data)})
+}
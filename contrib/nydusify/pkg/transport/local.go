@@ -0,0 +1,440 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/archive"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	dirManifestFile   = "manifest.json"
+	dirVersionFile    = "version"
+	dirVersionPrefix  = "Directory Transport Version: "
+	dirVersion        = dirVersionPrefix + "1.1\n"
+	dirVersionMaxSize = 1024
+)
+
+var podmanBinary = "podman"
+
+// Load reads the image in local transport into content store, and returns
+// the descriptor of image manifest or index.
+func Load(ctx context.Context, store content.Store, ref *Reference, workDir string) (*ocispec.Descriptor, error) {
+	switch ref.Transport {
+	case OCI:
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(tarDir(ref.Path, writer))
+		}()
+		defer reader.Close()
+		return importArchive(ctx, store, reader, ref.Name)
+
+	case OCIArchive, DockerArchive:
+		file, err := os.Open(ref.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, "open archive")
+		}
+		defer file.Close()
+		return importArchive(ctx, store, file, ref.Name)
+
+	case ContainersStorage:
+		archivePath := filepath.Join(workDir, "containers-storage.tar")
+		defer os.Remove(archivePath)
+
+		logrus.Infof("exporting image %s from containers storage", ref.Name)
+		if err := runPodman(ctx, "image", "save", "--format", "oci-archive", "--output", archivePath, ref.Name); err != nil {
+			return nil, errors.Wrapf(err, "export image %s", ref.Name)
+		}
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, errors.Wrap(err, "open exported archive")
+		}
+		defer file.Close()
+		return importArchive(ctx, store, file, "")
+
+	case Dir:
+		return loadDir(ctx, store, ref.Path)
+
+	default:
+		return nil, fmt.Errorf("can't load image from %s transport", ref.Transport)
+	}
+}
+
+// Save writes the image in content store into local transport.
+func Save(ctx context.Context, store content.Store, ref *Reference, desc ocispec.Descriptor, workDir string) error {
+	switch ref.Transport {
+	case OCI:
+		return saveOCIDir(ctx, store, ref, desc)
+
+	case OCIArchive, DockerArchive:
+		file, err := os.Create(ref.Path)
+		if err != nil {
+			return errors.Wrap(err, "create archive")
+		}
+		defer file.Close()
+		return exportArchive(ctx, store, file, ref, desc)
+
+	case ContainersStorage:
+		archivePath := filepath.Join(workDir, "containers-storage.tar")
+		defer os.Remove(archivePath)
+
+		file, err := os.Create(archivePath)
+		if err != nil {
+			return errors.Wrap(err, "create archive")
+		}
+		defer file.Close()
+		if err := exportArchive(ctx, store, file, &Reference{Transport: OCIArchive, Name: ref.Name}, desc); err != nil {
+			return err
+		}
+		logrus.Infof("importing image %s into containers storage", ref.Name)
+		if err := runPodman(ctx, "image", "load", "--input", archivePath); err != nil {
+			return errors.Wrapf(err, "import image %s", ref.Name)
+		}
+		return nil
+
+	case Dir:
+		return saveDir(ctx, store, ref.Path, desc)
+
+	default:
+		return fmt.Errorf("can't save image to %s transport", ref.Transport)
+	}
+}
+
+func runPodman(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, podmanBinary, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return errors.Wrapf(cmd.Run(), "run %s", podmanBinary)
+}
+
+// tarDir writes the files in directory into tar stream.
+func tarDir(dir string, writer io.Writer) error {
+	tw := tar.NewWriter(writer)
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filepath.ToSlash(name),
+			Mode:     0644,
+			Size:     info.Size(),
+		}); err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	}); err != nil {
+		return errors.Wrapf(err, "read directory %s", dir)
+	}
+	return tw.Close()
+}
+
+// matchName checks if the image name annotated in archive index matches.
+func matchName(desc ocispec.Descriptor, name string) bool {
+	for _, key := range []string{ocispec.AnnotationRefName, images.AnnotationImageName} {
+		annotated := desc.Annotations[key]
+		if annotated == "" {
+			continue
+		}
+		if annotated == name {
+			return true
+		}
+		// The docker archive records the image name in short form.
+		annotatedNamed, err1 := docker.ParseDockerRef(annotated)
+		named, err2 := docker.ParseDockerRef(name)
+		if err1 == nil && err2 == nil && annotatedNamed.String() == named.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// importArchive imports the OCI or docker archive, and returns the image
+// descriptor matching the name, the archive should contain only one image
+// if the name is empty.
+func importArchive(ctx context.Context, store content.Store, reader io.Reader, name string) (*ocispec.Descriptor, error) {
+	indexDesc, err := archive.ImportIndex(ctx, store, reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "import archive")
+	}
+
+	var index ocispec.Index
+	if _, err := utils.ReadJSON(ctx, store, &index, indexDesc); err != nil {
+		return nil, errors.Wrap(err, "read index json")
+	}
+
+	var found *ocispec.Descriptor
+	if name == "" {
+		if len(index.Manifests) != 1 {
+			return nil, fmt.Errorf("archive should contain exactly one image, but found %d", len(index.Manifests))
+		}
+		found = &index.Manifests[0]
+	} else {
+		for idx := range index.Manifests {
+			if matchName(index.Manifests[idx], name) {
+				found = &index.Manifests[idx]
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("image %s is not found in archive", name)
+		}
+	}
+
+	// The annotations are added by importer to record the image name.
+	desc := *found
+	desc.Annotations = nil
+
+	return &desc, nil
+}
+
+func exportArchive(ctx context.Context, store content.Store, writer io.Writer, ref *Reference, desc ocispec.Descriptor) error {
+	opts := []archive.ExportOpt{archive.WithAllPlatforms()}
+	if ref.Name != "" {
+		opts = append(opts, archive.WithManifest(desc, ref.Name))
+	} else {
+		opts = append(opts, archive.WithManifest(desc))
+	}
+	if ref.Transport != DockerArchive {
+		opts = append(opts, archive.WithSkipDockerManifest())
+	}
+	if err := archive.Export(ctx, store, writer, opts...); err != nil {
+		return errors.Wrap(err, "export archive")
+	}
+	return nil
+}
+
+// walk calls fn for the image and all its children.
+func walk(ctx context.Context, store content.Store, desc ocispec.Descriptor, fn func(desc ocispec.Descriptor) error) error {
+	return images.Walk(ctx, images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if err := fn(desc); err != nil {
+			return nil, err
+		}
+		return images.Children(ctx, store, desc)
+	}), desc)
+}
+
+func writeBlob(ctx context.Context, store content.Store, desc ocispec.Descriptor, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	ra, err := store.ReaderAt(ctx, desc)
+	if err != nil {
+		return errors.Wrapf(err, "get reader of %s", desc.Digest)
+	}
+	defer ra.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, io.NewSectionReader(ra, 0, ra.Size()))
+	return err
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// saveOCIDir writes the image into OCI image layout directory, the image
+// replaces the existing one with the same name in index.
+func saveOCIDir(ctx context.Context, store content.Store, ref *Reference, desc ocispec.Descriptor) error {
+	if err := walk(ctx, store, desc, func(desc ocispec.Descriptor) error {
+		return writeBlob(ctx, store, desc, filepath.Join(ref.Path, ocispec.ImageBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+	}); err != nil {
+		return errors.Wrap(err, "write blobs")
+	}
+
+	if err := writeJSON(filepath.Join(ref.Path, ocispec.ImageLayoutFile), ocispec.ImageLayout{
+		Version: ocispec.ImageLayoutVersion,
+	}); err != nil {
+		return errors.Wrap(err, "write oci layout")
+	}
+
+	indexPath := filepath.Join(ref.Path, "index.json")
+	index := ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+	}
+	index.SchemaVersion = 2
+	if data, err := os.ReadFile(indexPath); err == nil {
+		if err := json.Unmarshal(data, &index); err != nil {
+			return errors.Wrap(err, "parse existing index json")
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "read existing index json")
+	}
+
+	manifests := []ocispec.Descriptor{}
+	for _, manifest := range index.Manifests {
+		if ref.Name == "" || manifest.Annotations[ocispec.AnnotationRefName] != ref.Name {
+			manifests = append(manifests, manifest)
+		}
+	}
+	if ref.Name != "" {
+		desc.Annotations = map[string]string{ocispec.AnnotationRefName: ref.Name}
+	}
+	index.Manifests = append(manifests, desc)
+
+	return errors.Wrap(writeJSON(indexPath, index), "write index json")
+}
+
+// saveDir writes the image into directory as skopeo `dir:` transport, the
+// manifests of index are named by digest with `.manifest.json` suffix.
+func saveDir(ctx context.Context, store content.Store, dir string, desc ocispec.Descriptor) error {
+	if err := walk(ctx, store, desc, func(child ocispec.Descriptor) error {
+		name := child.Digest.Encoded()
+		if child.Digest == desc.Digest {
+			name = dirManifestFile
+		} else if images.IsManifestType(child.MediaType) || images.IsIndexType(child.MediaType) {
+			name += "." + dirManifestFile
+		}
+		return writeBlob(ctx, store, child, filepath.Join(dir, name))
+	}); err != nil {
+		return errors.Wrap(err, "write blobs")
+	}
+	return errors.Wrap(os.WriteFile(filepath.Join(dir, dirVersionFile), []byte(dirVersion), 0644), "write version")
+}
+
+// dirManifest is the common part of manifest and index to detect the type.
+type dirManifest struct {
+	MediaType string               `json:"mediaType"`
+	Config    *ocispec.Descriptor  `json:"config"`
+	Layers    []ocispec.Descriptor `json:"layers"`
+	Manifests []ocispec.Descriptor `json:"manifests"`
+}
+
+// isDirLayout checks whether the directory is in skopeo `dir:` transport,
+// which has both the manifest and the version file written by skopeo, so
+// that a rootfs merely containing a `/manifest.json` isn't misread.
+func isDirLayout(dir string) (bool, error) {
+	for _, name := range []string{dirManifestFile, dirVersionFile} {
+		info, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, errors.Wrapf(err, "stat %s", name)
+		}
+		if !info.Mode().IsRegular() {
+			return false, nil
+		}
+	}
+	file, err := os.Open(filepath.Join(dir, dirVersionFile))
+	if err != nil {
+		return false, errors.Wrap(err, "open version")
+	}
+	defer file.Close()
+	version, err := io.ReadAll(io.LimitReader(file, dirVersionMaxSize))
+	if err != nil {
+		return false, errors.Wrap(err, "read version")
+	}
+	return bytes.HasPrefix(version, []byte(dirVersionPrefix)), nil
+}
+
+// loadDir reads the image from directory in skopeo `dir:` transport, or
+// packs the directory as a single-layer image if it's a plain rootfs.
+func loadDir(ctx context.Context, store content.Store, dir string) (*ocispec.Descriptor, error) {
+	layout, err := isDirLayout(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !layout {
+		return loadRootfs(ctx, store, dir)
+	}
+	return loadDirManifest(ctx, store, dir, filepath.Join(dir, dirManifestFile), "")
+}
+
+func loadDirManifest(ctx context.Context, store content.Store, dir, path, mediaType string) (*ocispec.Descriptor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read manifest")
+	}
+	var manifest dirManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrapf(err, "parse manifest %s", path)
+	}
+	if manifest.MediaType != "" {
+		mediaType = manifest.MediaType
+	}
+	if mediaType == "" {
+		if manifest.Manifests != nil {
+			mediaType = ocispec.MediaTypeImageIndex
+		} else {
+			mediaType = ocispec.MediaTypeImageManifest
+		}
+	}
+
+	blobs := manifest.Layers
+	if manifest.Config != nil {
+		blobs = append(blobs, *manifest.Config)
+	}
+	for _, blob := range blobs {
+		if err := loadDirBlob(ctx, store, blob, filepath.Join(dir, blob.Digest.Encoded())); err != nil {
+			return nil, err
+		}
+	}
+	for _, child := range manifest.Manifests {
+		if _, err := loadDirManifest(ctx, store, dir, filepath.Join(dir, child.Digest.Encoded()+"."+dirManifestFile), child.MediaType); err != nil {
+			return nil, err
+		}
+	}
+
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	if err := content.WriteBlob(ctx, store, desc.Digest.String(), bytes.NewReader(data), desc); err != nil {
+		return nil, errors.Wrapf(err, "write manifest %s", desc.Digest)
+	}
+
+	return &desc, nil
+}
+
+func loadDirBlob(ctx context.Context, store content.Store, desc ocispec.Descriptor, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "open blob %s", desc.Digest)
+	}
+	defer file.Close()
+	if err := content.WriteBlob(ctx, store, desc.Digest.String(), file, desc); err != nil {
+		return errors.Wrapf(err, "write blob %s", desc.Digest)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"runtime"
+
+	"github.com/containerd/containerd/archive"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// labelUncompressed is the diff id of layer in content store.
+const labelUncompressed = "containerd.io/uncompressed"
+
+// writeLayer packs the rootfs directory into a gzip compressed layer in
+// content store, and returns the layer descriptor and diff id.
+func writeLayer(ctx context.Context, store content.Store, dir string) (*ocispec.Descriptor, digest.Digest, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		// The rootfs is packed as the diff from an empty directory, with
+		// the ownership, links and xattrs of files kept.
+		writer.CloseWithError(archive.WriteDiff(ctx, writer, "", dir))
+	}()
+	defer reader.Close()
+
+	cw, err := content.OpenWriter(ctx, store, content.WithRef("rootfs-"+dir))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "open layer writer")
+	}
+	defer cw.Close()
+	if err := cw.Truncate(0); err != nil {
+		return nil, "", errors.Wrap(err, "truncate layer writer")
+	}
+
+	counter := &countWriter{writer: cw}
+	gw := gzip.NewWriter(counter)
+	diffID := digest.Canonical.Digester()
+	if _, err := io.Copy(io.MultiWriter(gw, diffID.Hash()), reader); err != nil {
+		return nil, "", errors.Wrapf(err, "pack rootfs %s", dir)
+	}
+	if err := gw.Close(); err != nil {
+		return nil, "", errors.Wrap(err, "compress layer")
+	}
+
+	layerDigest := cw.Digest()
+	if err := cw.Commit(ctx, counter.size, layerDigest, content.WithLabels(map[string]string{
+		labelUncompressed: diffID.Digest().String(),
+	})); err != nil && !errdefs.IsAlreadyExists(err) {
+		return nil, "", errors.Wrap(err, "commit layer")
+	}
+
+	return &ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    layerDigest,
+		Size:      counter.size,
+	}, diffID.Digest(), nil
+}
+
+// loadRootfs packs the plain rootfs directory as a single-layer image for
+// the host architecture, with a generated config.
+func loadRootfs(ctx context.Context, store content.Store, dir string) (*ocispec.Descriptor, error) {
+	logrus.Infof("packing rootfs directory %s as a single-layer image", dir)
+
+	layer, diffID, err := writeLayer(ctx, store, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	config := ocispec.Image{
+		Platform: ocispec.Platform{
+			OS:           "linux",
+			Architecture: runtime.GOARCH,
+		},
+		RootFS: ocispec.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{diffID},
+		},
+		History: []ocispec.History{{
+			CreatedBy: "nydusify: dir:" + dir,
+		}},
+	}
+	configDesc, err := writeContent(ctx, store, ocispec.MediaTypeImageConfig, config, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "write config")
+	}
+
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    *configDesc,
+		Layers:    []ocispec.Descriptor{*layer},
+	}
+	// The GC labels keep the children of manifest in content store.
+	desc, err := writeContent(ctx, store, ocispec.MediaTypeImageManifest, manifest, map[string]string{
+		"containerd.io/gc.ref.content.config": configDesc.Digest.String(),
+		"containerd.io/gc.ref.content.l.0":    layer.Digest.String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "write manifest")
+	}
+	desc.Platform = &config.Platform
+
+	return desc, nil
+}
+
+func writeContent(ctx context.Context, store content.Store, mediaType string, v interface{}, contentLabels map[string]string) (*ocispec.Descriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	if err := content.WriteBlob(ctx, store, desc.Digest.String(), bytes.NewReader(data), desc, content.WithLabels(contentLabels)); err != nil {
+		return nil, err
+	}
+	return &desc, nil
+}
+
+type countWriter struct {
+	writer io.Writer
+	size   int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transport parses the image references in skopeo style transport
+// syntax, and loads or saves the images in local transports.
+package transport
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Docker is the image in registry, in the form of `docker://ref`.
+	Docker = "docker"
+	// OCI is the OCI image layout directory, in the form of
+	// `oci:path[:name]`.
+	OCI = "oci"
+	// OCIArchive is the tar archive of OCI image layout, in the form of
+	// `oci-archive:path[:name]`.
+	OCIArchive = "oci-archive"
+	// DockerArchive is the tar archive created by `docker save`, in the
+	// form of `docker-archive:path[:ref]`.
+	DockerArchive = "docker-archive"
+	// ContainersStorage is the local storage of podman or buildah, in the
+	// form of `containers-storage:ref`.
+	ContainersStorage = "containers-storage"
+	// Dir is the directory containing manifest and blobs named by digest
+	// as skopeo does, in the form of `dir:path`.
+	Dir = "dir"
+)
+
+var transports = []string{Docker, OCI, OCIArchive, DockerArchive, ContainersStorage, Dir}
+
+// Reference is a parsed image reference with transport.
+type Reference struct {
+	Transport string
+	// Path is the local path of oci, oci-archive, docker-archive and dir
+	// transports.
+	Path string
+	// Name is the image reference of docker and containers-storage
+	// transports, or the optional image name in oci, oci-archive and
+	// docker-archive transports.
+	Name string
+}
+
+// Parse parses the image reference, the reference without transport prefix
+// is treated as docker transport for compatibility.
+func Parse(ref string) (*Reference, error) {
+	transport, value := Docker, ref
+	for _, t := range transports {
+		if strings.HasPrefix(ref, t+":") {
+			transport, value = t, strings.TrimPrefix(ref, t+":")
+			break
+		}
+	}
+
+	switch transport {
+	case Docker:
+		if strings.HasPrefix(ref, Docker+":") {
+			if !strings.HasPrefix(value, "//") {
+				return nil, fmt.Errorf("invalid reference %s, should be in the form of docker://ref", ref)
+			}
+			value = strings.TrimPrefix(value, "//")
+		}
+		if _, err := docker.ParseDockerRef(value); err != nil {
+			return nil, errors.Wrapf(err, "invalid reference %s", ref)
+		}
+		return &Reference{Transport: Docker, Name: value}, nil
+
+	case ContainersStorage:
+		if _, err := docker.ParseDockerRef(value); err != nil {
+			return nil, errors.Wrapf(err, "invalid reference %s", ref)
+		}
+		return &Reference{Transport: ContainersStorage, Name: value}, nil
+
+	case Dir:
+		if value == "" {
+			return nil, fmt.Errorf("invalid reference %s, path is required", ref)
+		}
+		return &Reference{Transport: Dir, Path: value}, nil
+
+	default:
+		// The path can't contain colon, the rest is the image name.
+		parts := strings.SplitN(value, ":", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("invalid reference %s, path is required", ref)
+		}
+		parsed := &Reference{Transport: transport, Path: parts[0]}
+		if len(parts) == 2 {
+			parsed.Name = parts[1]
+		}
+		return parsed, nil
+	}
+}
+
+// IsRegistry returns true if the image is in registry.
+func (ref *Reference) IsRegistry() bool {
+	return ref.Transport == Docker
+}
+
+func (ref *Reference) String() string {
+	switch ref.Transport {
+	case Docker:
+		return "docker://" + ref.Name
+	case ContainersStorage:
+		return ref.Transport + ":" + ref.Name
+	case Dir:
+		return ref.Transport + ":" + ref.Path
+	default:
+		if ref.Name != "" {
+			return ref.Transport + ":" + ref.Path + ":" + ref.Name
+		}
+		return ref.Transport + ":" + ref.Path
+	}
+}
+
+// RegistryReference returns the registry image reference, or an error if
+// the reference is not in docker transport.
+func RegistryReference(ref string) (string, error) {
+	parsed, err := Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if !parsed.IsRegistry() {
+		return "", fmt.Errorf("%s transport is not supported for %s, only registry image is supported", parsed.Transport, ref)
+	}
+	return parsed.Name, nil
+}
@@ -0,0 +1,237 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transport
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		ref      string
+		expected *Reference
+	}{
+		{"nginx:latest", &Reference{Transport: Docker, Name: "nginx:latest"}},
+		{"docker://localhost:5000/nginx", &Reference{Transport: Docker, Name: "localhost:5000/nginx"}},
+		{"oci:/tmp/layout", &Reference{Transport: OCI, Path: "/tmp/layout"}},
+		{"oci:/tmp/layout:v1", &Reference{Transport: OCI, Path: "/tmp/layout", Name: "v1"}},
+		{"oci-archive:image.tar:nginx", &Reference{Transport: OCIArchive, Path: "image.tar", Name: "nginx"}},
+		{"docker-archive:image.tar", &Reference{Transport: DockerArchive, Path: "image.tar"}},
+		{"containers-storage:localhost/app:v1", &Reference{Transport: ContainersStorage, Name: "localhost/app:v1"}},
+		{"dir:/tmp/image", &Reference{Transport: Dir, Path: "/tmp/image"}},
+	} {
+		parsed, err := Parse(tc.ref)
+		require.NoError(t, err, tc.ref)
+		require.Equal(t, tc.expected, parsed, tc.ref)
+	}
+
+	for _, ref := range []string{"docker:nginx", "oci:", "dir:", "containers-storage:Invalid", "Invalid"} {
+		_, err := Parse(ref)
+		require.Error(t, err, ref)
+	}
+
+	name, err := RegistryReference("docker://nginx")
+	require.NoError(t, err)
+	require.Equal(t, "nginx", name)
+	_, err = RegistryReference("oci:/tmp/layout")
+	require.Error(t, err)
+}
+
+// makeOCIArchive returns an OCI layout archive containing the manifests.
+func makeOCIArchive(t *testing.T, manifests int) []byte {
+	buf := bytes.Buffer{}
+	tw := tar.NewWriter(&buf)
+	writeFile := func(name string, data []byte) {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	writeJSON := func(name string, v interface{}) ocispec.Descriptor {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		dgst := digest.FromBytes(data)
+		if name == "" {
+			name = "blobs/sha256/" + dgst.Encoded()
+		}
+		writeFile(name, data)
+		return ocispec.Descriptor{Digest: dgst, Size: int64(len(data))}
+	}
+
+	writeFile(ocispec.ImageLayoutFile, []byte(`{"imageLayoutVersion":"1.0.0"}`))
+	index := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+	}
+	for idx := 0; idx < manifests; idx++ {
+		config := writeJSON("", ocispec.Image{Author: string(rune('a' + idx))})
+		config.MediaType = ocispec.MediaTypeImageConfig
+		manifest := writeJSON("", ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    []ocispec.Descriptor{},
+		})
+		manifest.MediaType = ocispec.MediaTypeImageManifest
+		manifest.Annotations = map[string]string{ocispec.AnnotationRefName: string(rune('a' + idx))}
+		index.Manifests = append(index.Manifests, manifest)
+	}
+	writeJSON("index.json", index)
+	require.NoError(t, tw.Close())
+
+	return buf.Bytes()
+}
+
+func TestLoadSave(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	root := t.TempDir()
+	store, err := local.NewStore(filepath.Join(root, "content"))
+	require.NoError(t, err)
+
+	archivePath := filepath.Join(root, "multi.tar")
+	require.NoError(t, os.WriteFile(archivePath, makeOCIArchive(t, 2), 0644))
+
+	// The archive with multiple images requires the image name.
+	_, err = Load(ctx, store, &Reference{Transport: OCIArchive, Path: archivePath}, root)
+	require.Error(t, err)
+	_, err = Load(ctx, store, &Reference{Transport: OCIArchive, Path: archivePath, Name: "c"}, root)
+	require.Error(t, err)
+	desc, err := Load(ctx, store, &Reference{Transport: OCIArchive, Path: archivePath, Name: "b"}, root)
+	require.NoError(t, err)
+	require.Equal(t, ocispec.MediaTypeImageManifest, desc.MediaType)
+	require.Nil(t, desc.Annotations)
+
+	for _, ref := range []*Reference{
+		{Transport: OCI, Path: filepath.Join(root, "layout"), Name: "v1"},
+		{Transport: OCIArchive, Path: filepath.Join(root, "oci.tar")},
+		{Transport: Dir, Path: filepath.Join(root, "dir")},
+	} {
+		require.NoError(t, Save(ctx, store, ref, *desc, root), ref.String())
+		loaded, err := Load(ctx, store, ref, root)
+		require.NoError(t, err, ref.String())
+		require.Equal(t, desc.Digest, loaded.Digest, ref.String())
+	}
+}
+
+func TestLoadRootfs(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	root := t.TempDir()
+	store, err := local.NewStore(filepath.Join(root, "content"))
+	require.NoError(t, err)
+
+	rootfs := filepath.Join(root, "rootfs")
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "etc"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(rootfs, "etc", "os-release"), []byte("ID=appliance\n"), 0644))
+	require.NoError(t, os.Symlink("etc/os-release", filepath.Join(rootfs, "os-release")))
+
+	desc, err := Load(ctx, store, &Reference{Transport: Dir, Path: rootfs}, root)
+	require.NoError(t, err)
+	require.Equal(t, ocispec.MediaTypeImageManifest, desc.MediaType)
+
+	var manifest ocispec.Manifest
+	data, err := content.ReadBlob(ctx, store, *desc)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	require.Len(t, manifest.Layers, 1)
+	require.Equal(t, ocispec.MediaTypeImageLayerGzip, manifest.Layers[0].MediaType)
+
+	var config ocispec.Image
+	data, err = content.ReadBlob(ctx, store, manifest.Config)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &config))
+	require.Equal(t, "linux", config.OS)
+	require.Equal(t, runtime.GOARCH, config.Architecture)
+
+	// The diff id matches the uncompressed layer, which contains the files.
+	ra, err := store.ReaderAt(ctx, manifest.Layers[0])
+	require.NoError(t, err)
+	defer ra.Close()
+	gr, err := gzip.NewReader(content.NewReader(ra))
+	require.NoError(t, err)
+	tarData, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	require.Equal(t, []digest.Digest{digest.FromBytes(tarData)}, config.RootFS.DiffIDs)
+
+	entries := map[string]*tar.Header{}
+	tr := tar.NewReader(bytes.NewReader(tarData))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		entries[hdr.Name] = hdr
+	}
+	require.Contains(t, entries, "etc/os-release")
+	require.Equal(t, byte(tar.TypeSymlink), entries["os-release"].Typeflag)
+	require.Equal(t, "etc/os-release", entries["os-release"].Linkname)
+}
+
+func TestLoadRootfsWithManifest(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "nydusify")
+	root := t.TempDir()
+	store, err := local.NewStore(filepath.Join(root, "content"))
+	require.NoError(t, err)
+
+	// A rootfs containing `/manifest.json` and `/version` of its own isn't a
+	// skopeo layout.
+	rootfs := filepath.Join(root, "rootfs")
+	require.NoError(t, os.MkdirAll(rootfs, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(rootfs, "manifest.json"), []byte(`{"name": "app"}`), 0644))
+	layout, err := isDirLayout(rootfs)
+	require.NoError(t, err)
+	require.False(t, layout)
+	require.NoError(t, os.WriteFile(filepath.Join(rootfs, "version"), []byte("1.0.0\n"), 0644))
+	layout, err = isDirLayout(rootfs)
+	require.NoError(t, err)
+	require.False(t, layout)
+
+	desc, err := Load(ctx, store, &Reference{Transport: Dir, Path: rootfs}, root)
+	require.NoError(t, err)
+	var manifest ocispec.Manifest
+	data, err := content.ReadBlob(ctx, store, *desc)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	require.Len(t, manifest.Layers, 1)
+
+	ra, err := store.ReaderAt(ctx, manifest.Layers[0])
+	require.NoError(t, err)
+	defer ra.Close()
+	gr, err := gzip.NewReader(content.NewReader(ra))
+	require.NoError(t, err)
+	names := []string{}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	require.Contains(t, names, "manifest.json")
+	require.Contains(t, names, "version")
+
+	require.NoError(t, os.WriteFile(filepath.Join(rootfs, "version"), []byte(dirVersion), 0644))
+	layout, err = isDirLayout(rootfs)
+	require.NoError(t, err)
+	require.True(t, layout)
+}
@@ -0,0 +1,320 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package updater updates the running nydusify binary to the latest release
+// of the release channel, the release tarball is verified by its checksum
+// and signature before replacing the binary atomically.
+package updater
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dragonflyoss/nydus/contrib/nydusify/pkg/utils"
+)
+
+// DefaultChannelURL is the latest release of nydus in GitHub, a mirror of
+// release channel serves the same JSON with the assets in its own storage.
+const DefaultChannelURL = "https://api.github.com/repos/dragonflyoss/nydus/releases/latest"
+
+// binaryInTarball is the path of nydusify in the release tarball.
+const binaryInTarball = "nydus-static/nydusify"
+
+type Opt struct {
+	// ChannelURL is the URL of latest release in the JSON format of GitHub
+	// release API.
+	ChannelURL     string
+	CurrentVersion string
+	// Executable is the path of binary to replace, default to the running
+	// nydusify.
+	Executable string
+	// CosignPath is the path of cosign binary, default to search in PATH.
+	CosignPath string
+	// VerifyKey is the path or KMS URI of the cosign public key to verify
+	// the signature of release tarball.
+	VerifyKey string
+	// InsecureSkipVerify skips verifying the signature, the tarball is still
+	// verified by its checksum.
+	InsecureSkipVerify bool
+	// Force updates even if the current version is the latest one.
+	Force bool
+	// CheckOnly checks the latest version without updating.
+	CheckOnly bool
+	Retries   int
+}
+
+type Result struct {
+	Current string `json:"current"`
+	Latest  string `json:"latest"`
+	// Available is whether the latest version differs from the current.
+	Available bool `json:"available"`
+	Updated   bool `json:"updated"`
+}
+
+type asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+type release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []asset `json:"assets"`
+}
+
+func (r *release) asset(name string) *asset {
+	for idx := range r.Assets {
+		if r.Assets[idx].Name == name {
+			return &r.Assets[idx]
+		}
+	}
+	return nil
+}
+
+type updater struct {
+	opt    Opt
+	client *http.Client
+}
+
+// Update checks the release channel and replaces the executable with the
+// nydusify in the latest release tarball.
+func Update(ctx context.Context, opt Opt) (*Result, error) {
+	if opt.VerifyKey == "" && !opt.InsecureSkipVerify {
+		return nil, errors.New("verify key is required to verify the release signature")
+	}
+	if opt.ChannelURL == "" {
+		opt.ChannelURL = DefaultChannelURL
+	}
+	u := &updater{
+		opt: opt,
+		client: &http.Client{
+			Transport: &utils.RetryTransport{
+				Transport: http.DefaultTransport,
+				Policy:    utils.NewRetryPolicy(opt.Retries),
+			},
+		},
+	}
+
+	var latest release
+	if err := u.getJSON(ctx, opt.ChannelURL, &latest); err != nil {
+		return nil, errors.Wrap(err, "check release channel")
+	}
+	if latest.TagName == "" {
+		return nil, fmt.Errorf("no release version in %s", opt.ChannelURL)
+	}
+	result := &Result{
+		Current:   opt.CurrentVersion,
+		Latest:    latest.TagName,
+		Available: latest.TagName != opt.CurrentVersion,
+	}
+	if opt.CheckOnly || (!result.Available && !opt.Force) {
+		return result, nil
+	}
+
+	executable := opt.Executable
+	if executable == "" {
+		var err error
+		if executable, err = os.Executable(); err != nil {
+			return nil, errors.Wrap(err, "find executable")
+		}
+	}
+	executable, err := filepath.EvalSymlinks(executable)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve executable")
+	}
+	if err := u.replace(ctx, &latest, executable); err != nil {
+		return nil, err
+	}
+	result.Updated = true
+	logrus.Infof("Updated %s from %s to %s", executable, result.Current, result.Latest)
+	return result, nil
+}
+
+// replace downloads and verifies the release tarball beside executable,
+// and renames the nydusify in it to executable, so that the binary is
+// either the old or the new one on failure.
+func (u *updater) replace(ctx context.Context, latest *release, executable string) error {
+	tarballName := fmt.Sprintf("nydus-static-%s-%s-%s.tgz", latest.TagName, runtime.GOOS, runtime.GOARCH)
+	tarball := latest.asset(tarballName)
+	if tarball == nil {
+		return fmt.Errorf("no %s in release %s", tarballName, latest.TagName)
+	}
+	checksum := latest.asset(tarballName + ".sha256sum")
+	if checksum == nil {
+		return fmt.Errorf("no checksum of %s in release %s", tarballName, latest.TagName)
+	}
+	signature := latest.asset(tarballName + ".sig")
+	if signature == nil && !u.opt.InsecureSkipVerify {
+		return fmt.Errorf("no signature of %s in release %s", tarballName, latest.TagName)
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(executable), ".nydusify-update-")
+	if err != nil {
+		return errors.Wrap(err, "create temp directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tarballPath := filepath.Join(tmpDir, tarballName)
+	logrus.Infof("Downloading %s", tarball.URL)
+	if err := u.download(ctx, tarball.URL, tarballPath); err != nil {
+		return errors.Wrapf(err, "download %s", tarballName)
+	}
+	if err := u.verifyChecksum(ctx, checksum.URL, tarballPath); err != nil {
+		return err
+	}
+	if signature != nil && !u.opt.InsecureSkipVerify {
+		sigPath := tarballPath + ".sig"
+		if err := u.download(ctx, signature.URL, sigPath); err != nil {
+			return errors.Wrapf(err, "download signature of %s", tarballName)
+		}
+		if err := u.verifySignature(ctx, tarballPath, sigPath); err != nil {
+			return err
+		}
+	}
+
+	binaryPath := filepath.Join(tmpDir, "nydusify")
+	file, err := os.Open(tarballPath)
+	if err != nil {
+		return errors.Wrap(err, "open release tarball")
+	}
+	defer file.Close()
+	if err := utils.UnpackFile(file, binaryInTarball, binaryPath); err != nil {
+		return errors.Wrap(err, "unpack nydusify from release tarball")
+	}
+	info, err := os.Stat(executable)
+	if err != nil {
+		return errors.Wrap(err, "stat executable")
+	}
+	if err := os.Chmod(binaryPath, info.Mode().Perm()|0111); err != nil {
+		return errors.Wrap(err, "chmod new binary")
+	}
+
+	// The new binary must run on this host and report the expected version,
+	// before it replaces the working one.
+	output, err := exec.CommandContext(ctx, binaryPath, "--version").CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "run new binary: %s", strings.TrimSpace(string(output)))
+	}
+	if !strings.Contains(string(output), latest.TagName) {
+		return fmt.Errorf("new binary doesn't report version %s: %s", latest.TagName, strings.TrimSpace(string(output)))
+	}
+
+	if err := os.Rename(binaryPath, executable); err != nil {
+		return errors.Wrap(err, "replace executable")
+	}
+	return nil
+}
+
+func (u *updater) get(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("get %s: status %s, %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.Body, nil
+}
+
+func (u *updater) getJSON(ctx context.Context, url string, v interface{}) error {
+	body, err := u.get(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return errors.Wrapf(err, "decode %s", url)
+	}
+	return nil
+}
+
+func (u *updater) download(ctx context.Context, url, path string) error {
+	body, err := u.get(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, body); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// verifyChecksum verifies the file by the checksum file in the output
+// format of sha256sum.
+func (u *updater) verifyChecksum(ctx context.Context, url, path string) error {
+	body, err := u.get(ctx, url)
+	if err != nil {
+		return errors.Wrap(err, "download checksum")
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, 4096))
+	if err != nil {
+		return errors.Wrap(err, "download checksum")
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return fmt.Errorf("empty checksum of %s", filepath.Base(path))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return errors.Wrap(err, "calculate checksum")
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != fields[0] {
+		return fmt.Errorf("checksum of %s mismatches, expected %s, actual %s", filepath.Base(path), fields[0], actual)
+	}
+	return nil
+}
+
+// verifySignature verifies the signature of file by cosign, the release is
+// verified without access to the transparency log as a mirror of release
+// channel may be in the isolated network.
+func (u *updater) verifySignature(ctx context.Context, path, sigPath string) error {
+	cosignPath := u.opt.CosignPath
+	if cosignPath == "" {
+		cosignPath = "cosign"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cosignPath,
+		"verify-blob", "--insecure-ignore-tlog=true", "--key", u.opt.VerifyKey, "--signature", sigPath, path,
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("release is not signed as expected: %s", strings.TrimSpace(stderr.String()))
+		}
+		return errors.Wrapf(err, "run %s", cosignPath)
+	}
+	return nil
+}
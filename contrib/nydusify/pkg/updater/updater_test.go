@@ -0,0 +1,163 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package updater
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The fake cosign only accepts the signature of the private key paired
+// with the public key.
+const cosignScript = `#!/bin/sh
+if [ "$(cat "$6")" = "signed by ${4%.pub}.key" ]; then
+	exit 0
+fi
+echo "Error: invalid signature" >&2
+exit 1
+`
+
+func releaseTarball(t *testing.T, script string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "nydus-static/", Typeflag: tar.TypeDir, Mode: 0755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: binaryInTarball, Mode: 0755, Size: int64(len(script))}))
+	_, err := tw.Write([]byte(script))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+type channel struct {
+	server  *httptest.Server
+	version string
+	files   map[string][]byte
+}
+
+func newChannel(t *testing.T, version string) *channel {
+	ch := &channel{version: version, files: map[string][]byte{}}
+	ch.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest" {
+			rel := release{TagName: ch.version}
+			for name := range ch.files {
+				rel.Assets = append(rel.Assets, asset{Name: name, URL: ch.server.URL + "/download/" + name})
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(rel))
+			return
+		}
+		data, ok := ch.files[strings.TrimPrefix(r.URL.Path, "/download/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(ch.server.Close)
+	ch.add(t, version, version)
+	return ch
+}
+
+// add adds the release assets of version, the nydusify in tarball reports
+// the reported version.
+func (ch *channel) add(t *testing.T, version, reported string) {
+	name := fmt.Sprintf("nydus-static-%s-%s-%s.tgz", version, runtime.GOOS, runtime.GOARCH)
+	tarball := releaseTarball(t, fmt.Sprintf("#!/bin/sh\necho 'Version	: %s'\n", reported))
+	sum := sha256.Sum256(tarball)
+	ch.files[name] = tarball
+	ch.files[name+".sha256sum"] = []byte(hex.EncodeToString(sum[:]) + "  " + name + "\n")
+	ch.files[name+".sig"] = []byte("signed by cosign.key\n")
+}
+
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	cosignPath := filepath.Join(dir, "cosign")
+	require.NoError(t, os.WriteFile(cosignPath, []byte(cosignScript), 0755))
+	executable := filepath.Join(dir, "nydusify")
+	require.NoError(t, os.WriteFile(executable, []byte("#!/bin/sh\necho 'Version	: v2.2.0'\n"), 0755))
+
+	ch := newChannel(t, "v2.3.0")
+	name := fmt.Sprintf("nydus-static-v2.3.0-%s-%s.tgz", runtime.GOOS, runtime.GOARCH)
+	opt := Opt{
+		ChannelURL:     ch.server.URL + "/latest",
+		CurrentVersion: "v2.2.0",
+		Executable:     executable,
+		CosignPath:     cosignPath,
+		VerifyKey:      "other.pub",
+	}
+	ctx := context.Background()
+
+	result, err := Update(ctx, Opt{ChannelURL: opt.ChannelURL, CurrentVersion: "v2.2.0", CheckOnly: true, InsecureSkipVerify: true})
+	require.NoError(t, err)
+	require.Equal(t, &Result{Current: "v2.2.0", Latest: "v2.3.0", Available: true}, result)
+
+	// The executable is untouched on verification failures.
+	_, err = Update(ctx, opt)
+	require.ErrorContains(t, err, "release is not signed as expected: Error: invalid signature")
+	opt.VerifyKey = "cosign.pub"
+	tarball := ch.files[name]
+	ch.files[name] = append([]byte{}, tarball[:len(tarball)-1]...)
+	_, err = Update(ctx, opt)
+	require.ErrorContains(t, err, "checksum of "+name+" mismatches")
+	output, err := exec.Command(executable).Output()
+	require.NoError(t, err)
+	require.Contains(t, string(output), "v2.2.0")
+
+	ch.files[name] = tarball
+	result, err = Update(ctx, opt)
+	require.NoError(t, err)
+	require.True(t, result.Updated)
+	output, err = exec.Command(executable).Output()
+	require.NoError(t, err)
+	require.Contains(t, string(output), "v2.3.0")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// Nothing to do for the latest version.
+	opt.CurrentVersion = "v2.3.0"
+	result, err = Update(ctx, opt)
+	require.NoError(t, err)
+	require.Equal(t, &Result{Current: "v2.3.0", Latest: "v2.3.0"}, result)
+
+	// The signature is required unless skipped explicitly.
+	delete(ch.files, name+".sig")
+	opt.Force = true
+	_, err = Update(ctx, opt)
+	require.ErrorContains(t, err, "no signature of "+name)
+	opt.VerifyKey = ""
+	opt.InsecureSkipVerify = true
+	result, err = Update(ctx, opt)
+	require.NoError(t, err)
+	require.True(t, result.Updated)
+
+	// The binary reporting another version isn't installed.
+	ch.version = "v2.4.0"
+	_, err = Update(ctx, opt)
+	require.ErrorContains(t, err, "no nydus-static-v2.4.0")
+	ch.add(t, "v2.4.0", "v2.3.0")
+	_, err = Update(ctx, opt)
+	require.ErrorContains(t, err, "new binary doesn't report version v2.4.0")
+	output, err = exec.Command(executable).Output()
+	require.NoError(t, err)
+	require.Contains(t, string(output), "v2.3.0")
+}
@@ -0,0 +1,238 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	// AdaptiveInterval is the interval to adjust concurrency.
+	AdaptiveInterval = 2 * time.Second
+	// cpuSaturation is the threshold of 1-minute load average per CPU.
+	cpuSaturation = 1.5
+	// memoryPressure is the threshold of available memory ratio.
+	memoryPressure = 0.1
+	// throughputTolerance ignores the small fluctuation of throughput.
+	throughputTolerance = 0.95
+)
+
+// SystemLoad presents the CPU and memory usage of host.
+type SystemLoad struct {
+	// CPU is the 1-minute load average divided by CPU count.
+	CPU float64
+	// MemoryAvailable is the ratio of available memory.
+	MemoryAvailable float64
+}
+
+// AdaptiveLimiter adjusts the concurrency of layer transfer at runtime,
+// it probes for a higher concurrency while the observed throughput keeps
+// increasing, and backs off if throughput drops or the host is under CPU
+// or memory pressure.
+//
+// The semaphore is created with maximum capacity, and the concurrency is
+// lowered by holding the unused slots.
+type AdaptiveLimiter struct {
+	sem      *semaphore.Weighted
+	min      int64
+	max      int64
+	observed int64
+
+	mutex    sync.Mutex
+	limit    int64
+	held     int64
+	pending  int64
+	lastRate float64
+	growing  bool
+
+	sample func() (*SystemLoad, error)
+}
+
+// NewAdaptiveLimiter creates an adaptive limiter with concurrency range
+// [min, max], the concurrency starts at min. A zero max means twice the
+// CPU count.
+func NewAdaptiveLimiter(min, max int) *AdaptiveLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if max <= 0 {
+		max = 2 * runtime.NumCPU()
+	}
+	if max < min {
+		max = min
+	}
+	limiter := &AdaptiveLimiter{
+		sem:     semaphore.NewWeighted(int64(max)),
+		min:     int64(min),
+		max:     int64(max),
+		limit:   int64(min),
+		held:    int64(max - min),
+		growing: true,
+		sample:  readSystemLoad,
+	}
+	limiter.sem.TryAcquire(limiter.held)
+	return limiter
+}
+
+// Semaphore returns the semaphore to limit concurrent transfers.
+func (limiter *AdaptiveLimiter) Semaphore() *semaphore.Weighted {
+	return limiter.sem
+}
+
+// Observe records the bytes transferred.
+func (limiter *AdaptiveLimiter) Observe(size int64) {
+	atomic.AddInt64(&limiter.observed, size)
+}
+
+// Limit returns the current concurrency.
+func (limiter *AdaptiveLimiter) Limit() int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return int(limiter.limit)
+}
+
+// Run adjusts the concurrency on every interval until ctx is done, the
+// pending shrinking also stops along with ctx.
+func (limiter *AdaptiveLimiter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			limiter.adjust(ctx, interval)
+		}
+	}
+}
+
+func (limiter *AdaptiveLimiter) adjust(ctx context.Context, interval time.Duration) {
+	rate := float64(atomic.SwapInt64(&limiter.observed, 0)) / interval.Seconds()
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limit := limiter.limit
+	load, err := limiter.sample()
+	if err != nil {
+		logrus.Debugf("failed to sample system load: %s", err)
+	}
+	if load != nil && (load.CPU > cpuSaturation || load.MemoryAvailable < memoryPressure) {
+		limit = limit / 2
+		limiter.growing = false
+	} else {
+		if rate < limiter.lastRate*throughputTolerance {
+			limiter.growing = !limiter.growing
+		}
+		if limiter.growing {
+			limit++
+		} else {
+			limit--
+		}
+	}
+	limiter.lastRate = rate
+
+	limiter.setLimit(ctx, limit)
+}
+
+// setLimit must be called with mutex held.
+func (limiter *AdaptiveLimiter) setLimit(ctx context.Context, limit int64) {
+	if limit < limiter.min {
+		limit = limiter.min
+	}
+	if limit > limiter.max {
+		limit = limiter.max
+	}
+	if limit != limiter.limit {
+		logrus.Debugf("adjust concurrency from %d to %d", limiter.limit, limit)
+	}
+	limiter.limit = limit
+
+	want := limiter.max - limit
+	if want < limiter.held {
+		limiter.sem.Release(limiter.held - want)
+		limiter.held = want
+	}
+	if grow := want - limiter.held - limiter.pending; grow > 0 {
+		// Wait for the running transfers to release the slots.
+		limiter.pending += grow
+		go func() {
+			err := limiter.sem.Acquire(ctx, grow)
+			limiter.mutex.Lock()
+			defer limiter.mutex.Unlock()
+			limiter.pending -= grow
+			if err != nil {
+				return
+			}
+			limiter.held += grow
+			limiter.setLimit(ctx, limiter.limit)
+		}()
+	}
+}
+
+// readSystemLoad reads the system load from procfs.
+func readSystemLoad() (*SystemLoad, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, errors.Wrap(err, "read loadavg")
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return nil, errors.New("invalid loadavg")
+	}
+	load1, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse loadavg")
+	}
+
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, errors.Wrap(err, "open meminfo")
+	}
+	defer file.Close()
+
+	var total, available float64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = value
+		case "MemAvailable:":
+			available = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read meminfo")
+	}
+	if total == 0 {
+		return nil, errors.New("invalid meminfo")
+	}
+
+	return &SystemLoad{
+		CPU:             load1 / float64(runtime.NumCPU()),
+		MemoryAvailable: available / total,
+	}, nil
+}
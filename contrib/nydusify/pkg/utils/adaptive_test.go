@@ -0,0 +1,97 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewAdaptiveLimiter(1, 4)
+	load := &SystemLoad{CPU: 0.5, MemoryAvailable: 0.5}
+	limiter.sample = func() (*SystemLoad, error) {
+		return load, nil
+	}
+	require.Equal(t, 1, limiter.Limit())
+
+	// Only one slot is available.
+	sem := limiter.Semaphore()
+	require.True(t, sem.TryAcquire(1))
+	require.False(t, sem.TryAcquire(1))
+	sem.Release(1)
+
+	// Grow while throughput keeps increasing.
+	for size := int64(1); size <= 3; size++ {
+		limiter.Observe(size * 100)
+		limiter.adjust(ctx, time.Second)
+	}
+	require.Equal(t, 4, limiter.Limit())
+	require.True(t, sem.TryAcquire(4))
+	sem.Release(4)
+
+	// Back off if throughput drops.
+	limiter.Observe(100)
+	limiter.adjust(ctx, time.Second)
+	require.Equal(t, 3, limiter.Limit())
+
+	// Back off under memory pressure.
+	load.MemoryAvailable = 0.05
+	limiter.adjust(ctx, time.Second)
+	require.Equal(t, 1, limiter.Limit())
+
+	// Shrinking waits for the running transfers.
+	require.True(t, sem.TryAcquire(1))
+	limiter.mutex.Lock()
+	limiter.setLimit(ctx, 4)
+	limiter.mutex.Unlock()
+	require.True(t, sem.TryAcquire(3))
+	limiter.mutex.Lock()
+	limiter.setLimit(ctx, 2)
+	limiter.mutex.Unlock()
+	sem.Release(4)
+	require.Eventually(t, func() bool {
+		if !sem.TryAcquire(2) {
+			return false
+		}
+		defer sem.Release(2)
+		if sem.TryAcquire(1) {
+			sem.Release(1)
+			return false
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter.Run(ctx, time.Millisecond)
+}
+
+func TestAdaptiveLimiterCancel(t *testing.T) {
+	limiter := NewAdaptiveLimiter(1, 4)
+	sem := limiter.Semaphore()
+	limiter.mutex.Lock()
+	limiter.setLimit(context.Background(), 4)
+	limiter.mutex.Unlock()
+	require.True(t, sem.TryAcquire(4))
+	defer sem.Release(4)
+
+	// The shrinking waiting for the running transfers stops with ctx.
+	ctx, cancel := context.WithCancel(context.Background())
+	limiter.mutex.Lock()
+	limiter.setLimit(ctx, 1)
+	require.Equal(t, int64(3), limiter.pending)
+	limiter.mutex.Unlock()
+	cancel()
+	require.Eventually(t, func() bool {
+		limiter.mutex.Lock()
+		defer limiter.mutex.Unlock()
+		return limiter.pending == 0 && limiter.held == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -12,10 +12,9 @@ import (
 	"os"
 	"path/filepath"
 
-	"golang.org/x/sys/unix"
-
 	"github.com/containerd/containerd/archive"
 	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/continuity/fs"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -130,43 +129,162 @@ func PackTargzInfo(src, name string, compress bool) (digest.Digest, int64, error
 	return hash, <-chanSize, <-chanErr
 }
 
-// UnpackTargz unpacks .tar(.gz) stream, and write to dst path
+// UnpackWorkers is the number of workers writing the small files of layers
+// being unpacked, 0 disables the optimized unpacker in favor of the applier
+// of containerd. The optimized unpacker is only used on Linux.
+var UnpackWorkers = 8
+
+// whiteoutMode specifies how the whiteout files of layer are applied.
+type whiteoutMode int
+
+const (
+	// whiteoutKeep unpacks the whiteout files as regular files.
+	whiteoutKeep whiteoutMode = iota
+	// whiteoutOverlay converts the whiteout files into the overlayfs
+	// form, i.e. character devices and opaque xattrs.
+	whiteoutOverlay
+	// whiteoutRemove removes the files of lower layers in the directory.
+	whiteoutRemove
+)
+
+// UnpackTargz unpacks .tar(.gz) stream, and write to dst path.
+//
+// Hardlinks are recreated as links to the same inode, sparse files are
+// restored with holes rather than being fully allocated on disk, so that
+// the builder sees the same file layout as the original layer.
 func UnpackTargz(ctx context.Context, dst string, r io.Reader, overlay bool) error {
+	return UnpackTargzWithProgress(ctx, dst, r, overlay, nil)
+}
+
+// UnpackTargzWithProgress is UnpackTargz, which reports the unpacked files
+// and bytes by progress if it's not nil.
+func UnpackTargzWithProgress(ctx context.Context, dst string, r io.Reader, overlay bool, progress *UnpackProgress) error {
+	return UnpackTargzWithFilter(ctx, dst, r, overlay, progress, nil)
+}
+
+// UnpackTargzWithFilter is UnpackTargzWithProgress, which filters the tar
+// entries by unpackFilter if it's not nil.
+func UnpackTargzWithFilter(
+	ctx context.Context, dst string, r io.Reader, overlay bool, progress *UnpackProgress, unpackFilter UnpackFilter,
+) (retErr error) {
 	ds, err := compression.DecompressStream(r)
 	if err != nil {
 		return err
 	}
 	defer ds.Close()
 
+	var stream io.Reader = ds
+	if progress != nil {
+		stream = progress.Reader(ds)
+		progress.Start()
+		defer func() {
+			progress.Done(retErr)
+		}()
+	}
+
 	// Guarantee that umask won't affect file/directory creation
-	mask := unix.Umask(0)
-	defer unix.Umask(mask)
+	mask := Umask(0)
+	defer Umask(mask)
 
 	if err := os.MkdirAll(dst, 0755); err != nil {
 		return err
 	}
 
+	// Collect the sparse files, the tar reader expands their holes into
+	// zeros, so we punch them back after the layer is applied.
+	sparseFiles := map[string][]SparseHole{}
+	filter := func(hdr *tar.Header) (bool, error) {
+		if progress != nil {
+			progress.AddFile()
+		}
+		sparse, holes := sparseHoles(hdr)
+		// The tar reader exposes the data of old GNU sparse files
+		// transparently, but keeps the type flag, which is unknown
+		// to the applier.
+		if hdr.Typeflag == tar.TypeGNUSparse {
+			hdr.Typeflag = tar.TypeReg
+		}
+		NormalizeHeader(hdr)
+		if unpackFilter != nil {
+			if keep, err := unpackFilter.Filter(hdr); err != nil || !keep {
+				return false, err
+			}
+		}
+		if sparse {
+			sparseFiles[hdr.Name] = holes
+		} else {
+			// A later entry of the same name replaces the sparse file.
+			delete(sparseFiles, hdr.Name)
+		}
+		return true, nil
+	}
+
+	whiteout := whiteoutKeep
 	if overlay {
-		_, err = archive.Apply(
-			ctx,
-			dst,
-			ds,
-			archive.WithConvertWhiteout(archive.OverlayConvertWhiteout),
-		)
-	} else {
-		_, err = archive.Apply(
-			ctx,
-			dst,
-			ds,
-			archive.WithConvertWhiteout(func(_ *tar.Header, _ string) (bool, error) {
-				return true, nil
-			}),
-		)
+		whiteout = whiteoutOverlay
+	}
+	if err := applyTar(ctx, dst, stream, filter, whiteout); err != nil {
+		return err
 	}
 
+	for name, holes := range sparseFiles {
+		path, err := fs.RootPath(dst, name)
+		if err != nil {
+			return err
+		}
+		// The file may be removed by a later entry.
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if err := PunchHoles(path, holes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyTargz applies the .tar(.gz) stream of layer on dst, which may contain
+// the lower layers, the whiteout files remove the files of lower layers in
+// the same way as the overlay mount does.
+func ApplyTargz(ctx context.Context, dst string, r io.Reader) error {
+	ds, err := compression.DecompressStream(r)
 	if err != nil {
 		return err
 	}
+	defer ds.Close()
 
-	return nil
+	// Guarantee that umask won't affect file/directory creation
+	mask := Umask(0)
+	defer Umask(mask)
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	return applyTar(ctx, dst, ds, nil, whiteoutRemove)
+}
+
+// applyTar applies the tar stream on dst by the optimized unpacker if it's
+// enabled, otherwise by the applier of containerd.
+func applyTar(ctx context.Context, dst string, r io.Reader, filter func(*tar.Header) (bool, error), whiteout whiteoutMode) error {
+	if fastUnpackSupported && UnpackWorkers > 0 {
+		return unpackLayer(ctx, dst, r, filter, whiteout, UnpackWorkers)
+	}
+
+	opts := []archive.ApplyOpt{}
+	if filter != nil {
+		opts = append(opts, archive.WithFilter(filter))
+	}
+	switch whiteout {
+	case whiteoutKeep:
+		opts = append(opts, archive.WithConvertWhiteout(func(_ *tar.Header, _ string) (bool, error) {
+			return true, nil
+		}))
+	case whiteoutOverlay:
+		opts = append(opts, archive.WithConvertWhiteout(overlayConvertWhiteout))
+	}
+	_, err := archive.Apply(ctx, dst, r, opts...)
+	return err
 }
@@ -0,0 +1,11 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import "github.com/containerd/containerd/archive"
+
+// overlayConvertWhiteout converts the whiteouts of layer into the overlayfs
+// form, i.e. character devices and opaque xattrs.
+var overlayConvertWhiteout = archive.OverlayConvertWhiteout
@@ -0,0 +1,141 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sparseTar writes the tar of a sparse file in GNU PAX 0.1 format, whose
+// data fragments are the sparse map of offset and length pairs.
+func sparseTar(t *testing.T, tw *tar.Writer, hdr *tar.Header, sparseMap []int64, data []byte) {
+	fields := []string{}
+	for _, field := range sparseMap {
+		fields = append(fields, strconv.FormatInt(field, 10))
+	}
+	// The tar writer drops the GNU sparse records, they are written with
+	// another prefix of the same length and renamed in place.
+	hdr.PAXRecords = map[string]string{
+		"XXX.sparse.major":     "0",
+		"XXX.sparse.minor":     "1",
+		"XXX.sparse.numblocks": strconv.Itoa(len(sparseMap) / 2),
+		"XXX.sparse.map":       strings.Join(fields, ","),
+		"XXX.sparse.realsize":  strconv.FormatInt(hdr.Size, 10),
+	}
+	hdr.Format = tar.FormatPAX
+	hdr.Size = int64(len(data))
+	assert.Nil(t, tw.WriteHeader(hdr))
+	_, err := tw.Write(data)
+	assert.Nil(t, err)
+}
+
+func TestUnpackTargzSparseAndHardlink(t *testing.T) {
+	// The zeros in data fragment are kept, the hole in the middle and at
+	// the end are punched.
+	size := int64(5 * sparseBlockSize)
+	fragment1 := make([]byte, 2*sparseBlockSize)
+	copy(fragment1[sparseBlockSize:], []byte("head"))
+	fragment2 := bytes.Repeat([]byte("a"), sparseBlockSize)
+	data := make([]byte, size)
+	copy(data, fragment1)
+	copy(data[3*sparseBlockSize:], fragment2)
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	buf := bytes.Buffer{}
+	tw := tar.NewWriter(&buf)
+	sparseTar(t, tw, &tar.Header{
+		Name:     "sparse",
+		Mode:     0444,
+		Size:     size,
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}, []int64{0, 2 * sparseBlockSize, 3 * sparseBlockSize, sparseBlockSize}, append(fragment1, fragment2...))
+	assert.Nil(t, tw.WriteHeader(&tar.Header{
+		Name:     "link",
+		Mode:     0444,
+		ModTime:  modTime,
+		Linkname: "sparse",
+		Typeflag: tar.TypeLink,
+	}))
+	// The zeros of regular file are not holes.
+	assert.Nil(t, tw.WriteHeader(&tar.Header{
+		Name:     "zero",
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}))
+	_, err := tw.Write(make([]byte, size))
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+	archive := bytes.ReplaceAll(buf.Bytes(), []byte("XXX.sparse."), []byte("GNU.sparse."))
+
+	dst := t.TempDir()
+	assert.Nil(t, UnpackTargz(context.Background(), dst, bytes.NewReader(archive), false))
+
+	content, err := os.ReadFile(filepath.Join(dst, "sparse"))
+	assert.Nil(t, err)
+	assert.Equal(t, data, content)
+
+	sparseInfo, err := os.Stat(filepath.Join(dst, "sparse"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0444), sparseInfo.Mode())
+	assert.True(t, modTime.Equal(sparseInfo.ModTime()))
+	linkInfo, err := os.Stat(filepath.Join(dst, "link"))
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(sparseInfo, linkInfo))
+
+	zeroInfo, err := os.Stat(filepath.Join(dst, "zero"))
+	assert.Nil(t, err)
+	assert.True(t, modTime.Equal(zeroInfo.ModTime()))
+	zeroStat := zeroInfo.Sys().(*syscall.Stat_t)
+	if zeroStat.Blocks*512 < size {
+		t.Skip("the zeros are not allocated by the underlying filesystem")
+	}
+
+	stat := sparseInfo.Sys().(*syscall.Stat_t)
+	if stat.Blocks*512 >= size {
+		t.Skip("hole punching is not supported by the underlying filesystem")
+	}
+	assert.GreaterOrEqual(t, stat.Blocks*512, int64(3*sparseBlockSize))
+	assert.Less(t, stat.Blocks*512, int64(4*sparseBlockSize))
+}
+
+func TestSparseHoles(t *testing.T) {
+	sparse, holes := sparseHoles(&tar.Header{Typeflag: tar.TypeReg, Size: 100})
+	assert.False(t, sparse)
+	assert.Nil(t, holes)
+
+	sparse, holes = sparseHoles(&tar.Header{Typeflag: tar.TypeGNUSparse, Size: 100})
+	assert.True(t, sparse)
+	assert.Nil(t, holes)
+
+	sparse, holes = sparseHoles(&tar.Header{
+		Typeflag:   tar.TypeReg,
+		Size:       100,
+		PAXRecords: map[string]string{"GNU.sparse.major": "1", "GNU.sparse.minor": "0"},
+	})
+	assert.True(t, sparse)
+	assert.Nil(t, holes)
+
+	sparse, holes = sparseHoles(&tar.Header{
+		Typeflag:   tar.TypeReg,
+		Size:       100,
+		PAXRecords: map[string]string{"GNU.sparse.map": "10,20,30,0"},
+	})
+	assert.True(t, sparse)
+	assert.Equal(t, []SparseHole{{Offset: 0, Length: 10}, {Offset: 30, Length: 70}}, holes)
+}
@@ -0,0 +1,19 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package utils
+
+import (
+	"archive/tar"
+
+	"github.com/pkg/errors"
+)
+
+// overlayConvertWhiteout fails as the overlayfs whiteouts can't be created
+// outside Linux.
+func overlayConvertWhiteout(_ *tar.Header, _ string) (bool, error) {
+	return false, errors.New("unpacking layer in overlayfs form is only supported on Linux")
+}
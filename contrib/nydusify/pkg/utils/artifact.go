@@ -0,0 +1,57 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ArtifactError means the reference is an OCI artifact (for example Helm
+// chart or WASM module) rather than a runnable container image.
+type ArtifactError struct {
+	Ref          string
+	ArtifactType string
+}
+
+func (err *ArtifactError) Error() string {
+	return fmt.Sprintf("%s is a non-image artifact of type %s", err.Ref, err.ArtifactType)
+}
+
+// IsArtifactError returns the ArtifactError if err is caused by it.
+func IsArtifactError(err error) (*ArtifactError, bool) {
+	var artifactErr *ArtifactError
+	if errors.As(err, &artifactErr) {
+		return artifactErr, true
+	}
+	return nil, false
+}
+
+// WarnArtifact logs a structured warning for skipping the artifact.
+func WarnArtifact(err *ArtifactError) {
+	logrus.WithFields(logrus.Fields{
+		"reference":     err.Ref,
+		"artifact_type": err.ArtifactType,
+	}).Warn("skip non-image artifact")
+}
+
+// ArtifactType returns the type of manifest if it's an OCI artifact, which
+// is detected by the artifactType field or the media type of config, or
+// empty string if it's a container image.
+func ArtifactType(manifest *ocispec.Manifest) string {
+	if manifest.ArtifactType != "" {
+		return manifest.ArtifactType
+	}
+	switch manifest.Config.MediaType {
+	case ocispec.MediaTypeImageConfig, images.MediaTypeDockerSchema2Config:
+		return ""
+	default:
+		return manifest.Config.MediaType
+	}
+}
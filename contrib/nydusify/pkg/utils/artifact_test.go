@@ -0,0 +1,38 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactType(t *testing.T) {
+	for _, tc := range []struct {
+		manifest ocispec.Manifest
+		expected string
+	}{
+		{ocispec.Manifest{Config: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig}}, ""},
+		{ocispec.Manifest{Config: ocispec.Descriptor{MediaType: images.MediaTypeDockerSchema2Config}}, ""},
+		{ocispec.Manifest{Config: ocispec.Descriptor{MediaType: "application/vnd.cncf.helm.config.v1+json"}}, "application/vnd.cncf.helm.config.v1+json"},
+		{ocispec.Manifest{
+			ArtifactType: "application/vnd.wasm.content.layer.v1+wasm",
+			Config:       ocispec.Descriptor{MediaType: ocispec.MediaTypeEmptyJSON},
+		}, "application/vnd.wasm.content.layer.v1+wasm"},
+	} {
+		require.Equal(t, tc.expected, ArtifactType(&tc.manifest))
+	}
+
+	err := errors.Wrap(&ArtifactError{Ref: "docker.io/library/chart:v1", ArtifactType: "helm"}, "parse image")
+	artifactErr, ok := IsArtifactError(err)
+	require.True(t, ok)
+	require.Equal(t, "helm", artifactErr.ArtifactType)
+	_, ok = IsArtifactError(errors.New("other"))
+	require.False(t, ok)
+}
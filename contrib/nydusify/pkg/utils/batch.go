@@ -0,0 +1,81 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ItemFailure is the failure of an item in batch operation.
+type ItemFailure struct {
+	Item string
+	Err  error
+}
+
+// BatchError collects the item failures in keep-going mode, where the batch
+// operation continues with the rest items after an item fails, instead of
+// aborting at the first error.
+type BatchError struct {
+	mutex    sync.Mutex
+	total    int
+	Failures []ItemFailure
+}
+
+// NewBatchError creates BatchError for the batch of total items.
+func NewBatchError(total int) *BatchError {
+	return &BatchError{total: total}
+}
+
+// Add records the failure of item, it's safe for concurrent use.
+func (e *BatchError) Add(item string, err error) {
+	logrus.WithError(err).WithField("item", item).Error("failed, keep going")
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.Failures = append(e.Failures, ItemFailure{Item: item, Err: err})
+}
+
+// Failed returns true if the item has been recorded as failed.
+func (e *BatchError) Failed(item string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, failure := range e.Failures {
+		if failure.Item == item {
+			return true
+		}
+	}
+	return false
+}
+
+// AllFailed returns true if all items in the batch failed.
+func (e *BatchError) AllFailed() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.Failures) >= e.total
+}
+
+// ErrorOrNil returns nil if no item failed.
+func (e *BatchError) ErrorOrNil() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if len(e.Failures) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Error summarizes the failed items.
+func (e *BatchError) Error() string {
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "%d of %d items failed:", len(e.Failures), e.total)
+	for _, failure := range e.Failures {
+		fmt.Fprintf(&summary, "\n  %s: %s", failure.Item, failure.Err)
+	}
+	return summary.String()
+}
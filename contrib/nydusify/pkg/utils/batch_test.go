@@ -0,0 +1,28 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchError(t *testing.T) {
+	batchErr := NewBatchError(3)
+	require.NoError(t, batchErr.ErrorOrNil())
+	require.False(t, batchErr.AllFailed())
+
+	batchErr.Add("linux/arm64", fmt.Errorf("build failed"))
+	require.True(t, batchErr.Failed("linux/arm64"))
+	require.False(t, batchErr.Failed("linux/amd64"))
+	require.False(t, batchErr.AllFailed())
+	require.EqualError(t, batchErr.ErrorOrNil(), "1 of 3 items failed:\n  linux/arm64: build failed")
+
+	batchErr.Add("linux/amd64", fmt.Errorf("pull failed"))
+	batchErr.Add("linux/s390x", fmt.Errorf("push failed"))
+	require.True(t, batchErr.AllFailed())
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// cpuPeriod is the period in microseconds of `cpu.max`.
+	cpuPeriod = 100000
+	// cgroupWrapperName is the name of the wrapper script moving the
+	// command into cgroup.
+	cgroupWrapperName = "nydus-image-cgroup"
+)
+
+// CgroupLimit is the resource limits of the processes in a cgroup v2
+// directory.
+type CgroupLimit struct {
+	// CPU is the CPU time in cores, for example 1.5, 0 means no limit.
+	CPU float64
+	// Memory is the memory limit in bytes, the processes are killed by
+	// OOM killer once exceeded, 0 means no limit.
+	Memory int64
+}
+
+// Enabled returns true if any limit is set.
+func (limit CgroupLimit) Enabled() bool {
+	return limit.CPU > 0 || limit.Memory > 0
+}
+
+// Validate checks the limits.
+func (limit CgroupLimit) Validate() error {
+	if limit.CPU < 0 || (limit.CPU > 0 && limit.CPU*cpuPeriod < 1000) {
+		return fmt.Errorf("invalid CPU limit %v, should be 0 or at least 0.01", limit.CPU)
+	}
+	if limit.Memory < 0 {
+		return fmt.Errorf("invalid memory limit %d, should not be negative", limit.Memory)
+	}
+	return nil
+}
+
+// controllers returns the cgroup controllers required by limits.
+func (limit CgroupLimit) controllers() []string {
+	controllers := []string{}
+	if limit.CPU > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if limit.Memory > 0 {
+		controllers = append(controllers, "memory")
+	}
+	return controllers
+}
+
+// cgroupDir resolves the relative cgroup path under /sys/fs/cgroup.
+func cgroupDir(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(cgroupMountPoint, path)
+}
+
+// CreateCgroup creates the cgroup of name under parent with the limits, a
+// relative parent is resolved under /sys/fs/cgroup. The required
+// controllers are enabled for the children of parent, so parent must be a
+// delegated cgroup v2 directory without processes.
+func CreateCgroup(parent, name string, limit CgroupLimit) (string, error) {
+	if err := limit.Validate(); err != nil {
+		return "", err
+	}
+	parent = cgroupDir(parent)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", errors.Wrapf(err, "create parent cgroup %s", parent)
+	}
+	subtreeControl := ""
+	for _, controller := range limit.controllers() {
+		subtreeControl += " +" + controller
+	}
+	if subtreeControl != "" {
+		path := filepath.Join(parent, "cgroup.subtree_control")
+		if err := os.WriteFile(path, []byte(strings.TrimSpace(subtreeControl)), 0644); err != nil {
+			return "", errors.Wrapf(err, "enable controllers %s of cgroup %s", strings.TrimSpace(subtreeControl), parent)
+		}
+	}
+
+	cgroup := filepath.Join(parent, name)
+	if err := os.Mkdir(cgroup, 0755); err != nil {
+		return "", errors.Wrapf(err, "create cgroup %s", cgroup)
+	}
+	if limit.CPU > 0 {
+		value := fmt.Sprintf("%d %d", int64(limit.CPU*cpuPeriod), cpuPeriod)
+		if err := os.WriteFile(filepath.Join(cgroup, "cpu.max"), []byte(value), 0644); err != nil {
+			RemoveCgroup(cgroup)
+			return "", errors.Wrapf(err, "set CPU limit of cgroup %s", cgroup)
+		}
+	}
+	if limit.Memory > 0 {
+		value := fmt.Sprintf("%d", limit.Memory)
+		if err := os.WriteFile(filepath.Join(cgroup, "memory.max"), []byte(value), 0644); err != nil {
+			RemoveCgroup(cgroup)
+			return "", errors.Wrapf(err, "set memory limit of cgroup %s", cgroup)
+		}
+	}
+
+	return cgroup, nil
+}
+
+// RemoveCgroup kills the processes left in cgroup and removes it, the
+// killed processes are waited for a while to exit.
+func RemoveCgroup(cgroup string) error {
+	// The `cgroup.kill` is supported since Linux 5.14.
+	os.WriteFile(filepath.Join(cgroup, "cgroup.kill"), []byte("1"), 0644)
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		if err = os.Remove(cgroup); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return errors.Wrapf(err, "remove cgroup %s", cgroup)
+}
+
+// WrapCgroup writes a wrapper script into dir, which moves itself into the
+// cgroup then replaces itself by the command, the returned script path can
+// be used in place of the command path.
+func WrapCgroup(command, cgroup, dir string) (string, error) {
+	command, err := exec.LookPath(command)
+	if err != nil {
+		return "", errors.Wrapf(err, "find command %s", command)
+	}
+	command, err = filepath.Abs(command)
+	if err != nil {
+		return "", errors.Wrap(err, "get absolute command path")
+	}
+
+	script := fmt.Sprintf(
+		"#!/bin/sh\necho $$ > %s || exit 1\nexec %s \"$@\"\n",
+		ShellQuote(filepath.Join(cgroup, cgroupProcsFile)), ShellQuote(command),
+	)
+	wrapperPath := filepath.Join(dir, cgroupWrapperName)
+	if err := os.WriteFile(wrapperPath, []byte(script), 0755); err != nil {
+		return "", errors.Wrap(err, "write cgroup wrapper")
+	}
+
+	return wrapperPath, nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroupLimit(t *testing.T) {
+	require.False(t, CgroupLimit{}.Enabled())
+	require.True(t, CgroupLimit{Memory: 1 << 30}.Enabled())
+	require.NoError(t, CgroupLimit{CPU: 0.5, Memory: 1 << 30}.Validate())
+	require.Error(t, CgroupLimit{CPU: -1}.Validate())
+	require.Error(t, CgroupLimit{CPU: 0.001}.Validate())
+	require.Error(t, CgroupLimit{Memory: -1}.Validate())
+
+	// A plain directory stands for the cgroup v2 hierarchy.
+	parent := filepath.Join(t.TempDir(), "nydusify")
+	cgroup, err := CreateCgroup(parent, "job", CgroupLimit{CPU: 1.5, Memory: 1 << 30})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(parent, "job"), cgroup)
+	for file, expected := range map[string]string{
+		filepath.Join(parent, "cgroup.subtree_control"): "+cpu +memory",
+		filepath.Join(cgroup, "cpu.max"):                "150000 100000",
+		filepath.Join(cgroup, "memory.max"):             "1073741824",
+	} {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data))
+	}
+	_, err = CreateCgroup(parent, "job", CgroupLimit{CPU: 1})
+	require.Error(t, err)
+
+	// The wrapper moves the command into cgroup.
+	wrapperPath, err := WrapCgroup("sh", cgroup, t.TempDir())
+	require.NoError(t, err)
+	output, err := exec.Command(wrapperPath, "-c", "echo $$").Output()
+	require.NoError(t, err)
+	procs, err := os.ReadFile(filepath.Join(cgroup, cgroupProcsFile))
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(procs)))
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(pid), strings.TrimSpace(string(output)))
+
+	_, err = WrapCgroup("not-exist-command", cgroup, t.TempDir())
+	require.Error(t, err)
+}
@@ -8,15 +8,49 @@ const (
 	ManifestOSFeatureNydus   = "nydus.remoteimage.v1"
 	MediaTypeNydusBlob       = "application/vnd.oci.image.layer.nydus.blob.v1"
 	BootstrapFileNameInLayer = "image/image.boot"
+	// The entry names in the tar of nydus blob.
+	EntryBootstrap = "image.boot"
+	EntryBlobMeta  = "blob.meta"
+	// BlobMetaSuffix is appended to blob id as the name of blob meta (TOC)
+	// artifact stored alongside the blob in backend.
+	BlobMetaSuffix = ".blob.meta"
 
 	ManifestNydusCache = "containerd.io/snapshot/nydus-cache"
+	// ManifestNydusBootstrap records the bootstrap layer digest in image
+	// manifest when the bootstrap is pushed as a separate artifact.
+	ManifestNydusBootstrap = "containerd.io/snapshot/nydus-bootstrap-digest"
+
+	ArtifactTypeNydusBootstrap = "application/vnd.nydus.bootstrap.v1"
+	// ArtifactTypeNydusBlobMeta is the artifact referring to image manifest,
+	// which carries the blob meta copied from storage backend to registry.
+	ArtifactTypeNydusBlobMeta = "application/vnd.nydus.blob.meta.v1"
+	MediaTypeNydusBlobMeta    = "application/vnd.oci.image.layer.nydus.blob.meta.v1"
+
+	// ManifestNydusCompatImage marks the manifest of compatible image built
+	// with another RAFS version in the index of target image, its value is
+	// the RAFS version.
+	ManifestNydusCompatImage = "containerd.io/snapshot/nydus-compat-image"
+
+	// The conversion options recorded in Nydus manifest, so that the image
+	// can be reproduced or audited later.
+	ManifestNydusCompressor         = "containerd.io/snapshot/nydus-compressor"
+	ManifestNydusChunkSize          = "containerd.io/snapshot/nydus-chunk-size"
+	ManifestNydusBatchSize          = "containerd.io/snapshot/nydus-batch-size"
+	ManifestNydusFsAlignChunk       = "containerd.io/snapshot/nydus-fs-align-chunk"
+	ManifestNydusChunkDictReference = "containerd.io/snapshot/nydus-chunk-dict-reference"
+	ManifestNydusChunkDictDigest    = "containerd.io/snapshot/nydus-chunk-dict-digest"
+	ManifestNydusUnpackFilter       = "containerd.io/snapshot/nydus-unpack-filter"
 
 	LayerAnnotationNydusBlob          = "containerd.io/snapshot/nydus-blob"
 	LayerAnnotationNydusBlobDigest    = "containerd.io/snapshot/nydus-blob-digest"
 	LayerAnnotationNydusBlobSize      = "containerd.io/snapshot/nydus-blob-size"
+	LayerAnnotationNydusBlobMeta      = "containerd.io/snapshot/nydus-blob-meta"
 	LayerAnnotationNydusBootstrap     = "containerd.io/snapshot/nydus-bootstrap"
 	LayerAnnotationNydusFsVersion     = "containerd.io/snapshot/nydus-fs-version"
 	LayerAnnotationNydusSourceChainID = "containerd.io/snapshot/nydus-source-chainid"
+	LayerAnnotationNydusEncryptedBlob = "containerd.io/snapshot/nydus-encrypted-blob"
+	LayerAnnotationNydusRefLayer      = "containerd.io/snapshot/nydus-ref"
+	LayerAnnotationNydusSourceDigest  = "containerd.io/snapshot/nydus-source-digest"
 
 	LayerAnnotationNydusReferenceBlobIDs = "containerd.io/snapshot/nydus-reference-blob-ids"
 
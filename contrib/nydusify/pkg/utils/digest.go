@@ -0,0 +1,77 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	// Register the hash implementations required by go-digest.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/json"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// SupportedDigestAlgorithms lists the digest algorithms which can be used
+// for the manifests, configs and bootstrap layers generated by nydusify.
+var SupportedDigestAlgorithms = []digest.Algorithm{
+	digest.SHA256,
+	digest.SHA384,
+	digest.SHA512,
+}
+
+// ParseDigestAlgorithm validates the digest algorithm name specified by
+// user, an empty name means the default canonical algorithm (sha256).
+func ParseDigestAlgorithm(name string) (digest.Algorithm, error) {
+	if name == "" {
+		return digest.Canonical, nil
+	}
+	for _, alg := range SupportedDigestAlgorithms {
+		if string(alg) == name {
+			if !alg.Available() {
+				return "", fmt.Errorf("digest algorithm %s is not available", name)
+			}
+			if err := CheckFIPSDigestAlgorithm(alg); err != nil {
+				return "", err
+			}
+			return alg, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported digest algorithm %s, possible values: %v", name, SupportedDigestAlgorithms)
+}
+
+// DigestFromEncoded restores the digest from its hex encoded part, for
+// example a nydus blob id, the algorithm is inferred from the length of
+// the encoded string and falls back to sha256.
+func DigestFromEncoded(encoded string) digest.Digest {
+	for _, alg := range SupportedDigestAlgorithms {
+		if alg.Size()*2 == len(encoded) {
+			return digest.NewDigestFromEncoded(alg, encoded)
+		}
+	}
+	return digest.NewDigestFromEncoded(digest.SHA256, encoded)
+}
+
+// MarshalToDescWithAlgorithm is like MarshalToDesc, but calculates the
+// descriptor digest with the specified algorithm.
+func MarshalToDescWithAlgorithm(data interface{}, mediaType string, alg digest.Algorithm) (*ocispec.Descriptor, []byte, error) {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if alg == "" {
+		alg = digest.Canonical
+	}
+
+	desc := ocispec.Descriptor{
+		Digest:    alg.FromBytes(bytes),
+		Size:      int64(len(bytes)),
+		MediaType: mediaType,
+	}
+
+	return &desc, bytes, nil
+}
@@ -0,0 +1,20 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package utils
+
+import (
+	"io"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+)
+
+// UnpackEntry writes the entry named name in the nydus blob of ra to target.
+func UnpackEntry(ra content.ReaderAt, name string, target io.Writer) error {
+	_, err := converter.UnpackEntry(ra, name, target)
+	return err
+}
@@ -0,0 +1,17 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"io"
+
+	"github.com/containerd/containerd/content"
+	"github.com/pkg/errors"
+)
+
+// UnpackEntry fails as the nydus blob is only unpacked on Unix.
+func UnpackEntry(_ content.ReaderAt, _ string, _ io.Writer) error {
+	return errors.New("unpacking nydus blob is not supported on Windows")
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"net/http"
+	"os/exec"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes/docker"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/pkg/errors"
+)
+
+// The exit codes of nydusify commands by failure type, so that the shell
+// pipelines and CI steps can branch on them, see docs/nydusify.md.
+const (
+	ExitSuccess = 0
+	// ExitFailure is the failure not classified by the other codes.
+	ExitFailure = 1
+	// ExitConfig is the invalid flags, arguments or configuration files.
+	ExitConfig = 2
+	// ExitAuth is the authentication or authorization failure of registry
+	// or storage backend.
+	ExitAuth = 3
+	// ExitSourceMissing is the image or content to read isn't found.
+	ExitSourceMissing = 4
+	// ExitBuilder is the failure of builder nydus-image.
+	ExitBuilder = 5
+	// ExitPush is the failure pushing to registry or storage backend.
+	ExitPush = 6
+	// ExitCheckMismatch is the image failing the checks or verification.
+	ExitCheckMismatch = 7
+	// ExitDeadline is the conversion exceeding the deadline, which pushed
+	// partial result or was rolled back.
+	ExitDeadline = 8
+)
+
+// ExitError attaches the exit code of failure type to the error.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// WithExitCode attaches the exit code to err, nil is returned if err is nil.
+func WithExitCode(err error, code int) error {
+	if err == nil {
+		return nil
+	}
+	return &ExitError{Code: code, Err: err}
+}
+
+// ExitCode returns the exit code of err. The authentication failure takes
+// precedence as it's the cause of other failures, then the innermost code
+// attached by WithExitCode, which is the most specific one, otherwise the
+// code is inferred from the error.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	if IsAuthError(err) {
+		return ExitAuth
+	}
+
+	code := 0
+	for cur := err; cur != nil; cur = errors.Unwrap(cur) {
+		if exitErr, ok := cur.(*ExitError); ok {
+			code = exitErr.Code
+		}
+	}
+	if code != 0 {
+		return code
+	}
+
+	var execErr *exec.ExitError
+	switch {
+	case errdefs.IsNotFound(err):
+		return ExitSourceMissing
+	case errors.As(err, &execErr):
+		return ExitBuilder
+	}
+	return ExitFailure
+}
+
+// IsAuthError checks whether err is caused by the 401 Unauthorized or 403
+// Forbidden response of registry or storage backend.
+func IsAuthError(err error) bool {
+	if errors.Is(err, docker.ErrInvalidAuthorization) {
+		return true
+	}
+	isAuthStatus := func(status int) bool {
+		return status == http.StatusUnauthorized || status == http.StatusForbidden
+	}
+
+	var statusErr remoteserrors.ErrUnexpectedStatus
+	if errors.As(err, &statusErr) && isAuthStatus(statusErr.StatusCode) {
+		return true
+	}
+	var ossErr oss.ServiceError
+	if errors.As(err, &ossErr) && isAuthStatus(ossErr.StatusCode) {
+		return true
+	}
+	// The response errors of S3 SDK.
+	var httpErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &httpErr) && isAuthStatus(httpErr.HTTPStatusCode()) {
+		return true
+	}
+	return false
+}
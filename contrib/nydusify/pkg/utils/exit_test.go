@@ -0,0 +1,68 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes/docker"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type httpStatusError int
+
+func (e httpStatusError) Error() string {
+	return fmt.Sprintf("status %d", int(e))
+}
+
+func (e httpStatusError) HTTPStatusCode() int {
+	return int(e)
+}
+
+func TestExitCode(t *testing.T) {
+	require.Equal(t, ExitSuccess, ExitCode(nil))
+	require.Nil(t, WithExitCode(nil, ExitConfig))
+	require.Equal(t, ExitFailure, ExitCode(errors.New("failed")))
+
+	// The innermost code is the most specific one.
+	err := WithExitCode(errors.New("invalid --platform"), ExitConfig)
+	require.Equal(t, ExitConfig, ExitCode(err))
+	require.Equal(t, "invalid --platform", err.Error())
+	err = WithExitCode(errors.Wrap(WithExitCode(errors.New("mismatch"), ExitCheckMismatch), "check"), ExitFailure)
+	require.Equal(t, ExitCheckMismatch, ExitCode(err))
+	require.Equal(t, "check: mismatch", err.Error())
+
+	// The inferred codes.
+	require.Equal(t, ExitSourceMissing, ExitCode(errors.Wrap(errdefs.ErrNotFound, "resolve source image")))
+	require.Equal(t, ExitBuilder, ExitCode(errors.Wrap(&exec.ExitError{}, "run builder")))
+	pushErr := WithExitCode(errors.Wrap(errdefs.ErrNotFound, "repository"), ExitPush)
+	require.Equal(t, ExitPush, ExitCode(errors.Wrap(pushErr, "push target image")))
+
+	// The authentication failure takes precedence.
+	for _, authErr := range []error{
+		fmt.Errorf("%w: no basic auth credentials", docker.ErrInvalidAuthorization),
+		remoteserrors.ErrUnexpectedStatus{StatusCode: 401},
+		remoteserrors.ErrUnexpectedStatus{StatusCode: 403},
+		oss.ServiceError{StatusCode: 403},
+		httpStatusError(401),
+	} {
+		require.True(t, IsAuthError(authErr))
+		require.Equal(t, ExitAuth, ExitCode(WithExitCode(errors.Wrap(authErr, "push blob"), ExitPush)))
+	}
+	for _, otherErr := range []error{
+		remoteserrors.ErrUnexpectedStatus{StatusCode: 500},
+		oss.ServiceError{StatusCode: 404},
+		httpStatusError(503),
+	} {
+		require.False(t, IsAuthError(otherErr))
+		require.Equal(t, ExitPush, ExitCode(WithExitCode(otherErr, ExitPush)))
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// UnpackFilter filters or transforms the tar entries of layers during
+// unpack, the header may be modified in place, and the entry is dropped if
+// false is returned.
+type UnpackFilter interface {
+	Filter(hdr *tar.Header) (bool, error)
+}
+
+// RewriteRule moves the entries under From directory to To directory, both
+// are relative to root.
+type RewriteRule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// FilterRules is the UnpackFilter declared in rules file, which slims the
+// image as part of conversion.
+type FilterRules struct {
+	// Drop removes the entries matching the patterns, in the syntax of
+	// path.Match against the path relative to root, e.g. `var/log/*.log`,
+	// the pattern without `/` matches the base name, e.g. `*.pyc`, and the
+	// trailing `/**` matches the directory and all entries under it, e.g.
+	// `var/cache/**`. The hardlinks to dropped files are dropped as well.
+	Drop []string `json:"drop,omitempty"`
+	// StripSetuid clears the setuid and setgid bits of files.
+	StripSetuid bool `json:"strip_setuid,omitempty"`
+	// Rewrite rules are matched in order, the first matched rule applies.
+	Rewrite []RewriteRule `json:"rewrite,omitempty"`
+}
+
+func cleanRelPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// LoadFilterRules loads the unpack filter rules from json file.
+func LoadFilterRules(file string) (*FilterRules, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read unpack filter rules")
+	}
+	var rules FilterRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, errors.Wrap(err, "unmarshal unpack filter rules")
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// Validate checks the patterns and rewrite rules.
+func (rules *FilterRules) Validate() error {
+	for _, pattern := range rules.Drop {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid drop pattern %q", pattern)
+		}
+	}
+	for idx := range rules.Rewrite {
+		rule := &rules.Rewrite[idx]
+		from, to := cleanRelPath(rule.From), cleanRelPath(rule.To)
+		if from == "" || to == "" || escapesRoot(rule.From) || escapesRoot(rule.To) {
+			return fmt.Errorf("invalid rewrite rule from %q to %q", rule.From, rule.To)
+		}
+		rule.From, rule.To = from, to
+	}
+	return nil
+}
+
+// Digest identifies the rules, which is recorded in the annotations of
+// converted image.
+func (rules *FilterRules) Digest() digest.Digest {
+	data, _ := json.Marshal(rules)
+	return digest.FromBytes(data)
+}
+
+func escapesRoot(p string) bool {
+	for _, component := range strings.Split(p, "/") {
+		if component == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func (rules *FilterRules) dropped(name string) bool {
+	for _, pattern := range rules.Drop {
+		if prefix := strings.TrimSuffix(pattern, "/**"); prefix != pattern {
+			if name == prefix || strings.HasPrefix(name, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (rules *FilterRules) rewrite(name string) string {
+	for _, rule := range rules.Rewrite {
+		if name == rule.From {
+			return rule.To
+		}
+		if strings.HasPrefix(name, rule.From+"/") {
+			return rule.To + strings.TrimPrefix(name, rule.From)
+		}
+	}
+	return name
+}
+
+// Filter implements UnpackFilter.
+func (rules *FilterRules) Filter(hdr *tar.Header) (bool, error) {
+	name := cleanRelPath(hdr.Name)
+	if name == "" {
+		return true, nil
+	}
+	if rules.dropped(name) {
+		return false, nil
+	}
+	if hdr.Typeflag == tar.TypeLink {
+		linkname := cleanRelPath(hdr.Linkname)
+		if rules.dropped(linkname) {
+			return false, nil
+		}
+		hdr.Linkname = rules.rewrite(linkname)
+	}
+	if rewritten := rules.rewrite(name); rewritten != name {
+		if strings.HasSuffix(hdr.Name, "/") {
+			rewritten += "/"
+		}
+		hdr.Name = rewritten
+	}
+	if rules.StripSetuid {
+		hdr.Mode &^= 0o6000
+	}
+	return true, nil
+}
+
+// FilterTar copies the uncompressed tar stream from reader to writer with
+// the entries filtered, it returns the number of dropped entries.
+func FilterTar(reader io.Reader, writer io.Writer, filter UnpackFilter) (int, error) {
+	dropped := 0
+	tr := tar.NewReader(reader)
+	tw := tar.NewWriter(writer)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return dropped, errors.Wrap(err, "read tar")
+		}
+		// The data of sparse files is exposed in full, and written back
+		// as regular files, the sparse PAX records are dropped as well.
+		if hdr.Typeflag == tar.TypeGNUSparse {
+			hdr.Typeflag = tar.TypeReg
+		}
+		NormalizeHeader(hdr)
+		keep, err := filter.Filter(hdr)
+		if err != nil {
+			return dropped, errors.Wrapf(err, "filter entry %s", hdr.Name)
+		}
+		if !keep {
+			dropped++
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return dropped, errors.Wrapf(err, "write header of %s", hdr.Name)
+		}
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			if _, err := io.Copy(tw, tr); err != nil {
+				return dropped, errors.Wrapf(err, "copy data of %s", hdr.Name)
+			}
+		}
+	}
+	return dropped, errors.Wrap(tw.Close(), "close tar")
+}
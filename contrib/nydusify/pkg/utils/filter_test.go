@@ -0,0 +1,117 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestTar(t *testing.T, headers []*tar.Header) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range headers {
+		data := []byte{}
+		if hdr.Typeflag == tar.TypeReg {
+			data = []byte(hdr.Name)
+			hdr.Size = int64(len(data))
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf
+}
+
+func TestFilterRules(t *testing.T) {
+	rulesPath := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(rulesPath, []byte(`{
+		"drop": ["var/cache/**", "*.pyc", "var/log/*.log"],
+		"strip_setuid": true,
+		"rewrite": [{"from": "/opt/app/", "to": "app"}]
+	}`), 0644))
+	rules, err := LoadFilterRules(rulesPath)
+	require.NoError(t, err)
+	require.Equal(t, []RewriteRule{{From: "opt/app", To: "app"}}, rules.Rewrite)
+
+	input := writeTestTar(t, []*tar.Header{
+		{Name: "var/cache/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "var/cache/apt/pkgcache.bin", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "var/log/dpkg.log", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "var/log/sub/keep.log", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "./usr/lib/mod.pyc", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "usr/bin/sudo", Typeflag: tar.TypeReg, Mode: 04755},
+		{Name: "usr/bin/cached", Typeflag: tar.TypeLink, Linkname: "var/cache/apt/pkgcache.bin"},
+		{Name: "opt/app/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "opt/app/main", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "opt/app/link", Typeflag: tar.TypeLink, Linkname: "opt/app/main"},
+		{Name: "opt/application", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	output := &bytes.Buffer{}
+	dropped, err := FilterTar(input, output, rules)
+	require.NoError(t, err)
+	require.Equal(t, 5, dropped)
+
+	entries := map[string]*tar.Header{}
+	tr := tar.NewReader(output)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		if hdr.Typeflag == tar.TypeReg {
+			require.Equal(t, int64(len(data)), hdr.Size)
+		}
+		entries[hdr.Name] = hdr
+	}
+	require.Len(t, entries, 6)
+	require.Contains(t, entries, "var/log/sub/keep.log")
+	require.Contains(t, entries, "opt/application")
+	require.Contains(t, entries, "app/")
+	require.Contains(t, entries, "app/main")
+	require.Equal(t, "app/main", entries["app/link"].Linkname)
+	require.Equal(t, int64(0755), entries["usr/bin/sudo"].Mode)
+
+	require.Equal(t, rules.Digest(), rules.Digest())
+	require.NotEqual(t, rules.Digest(), (&FilterRules{}).Digest())
+
+	for _, invalid := range []FilterRules{
+		{Drop: []string{"[a-"}},
+		{Drop: []string{""}},
+		{Rewrite: []RewriteRule{{From: "/", To: "app"}}},
+		{Rewrite: []RewriteRule{{From: "opt", To: "../app"}}},
+	} {
+		require.Error(t, invalid.Validate())
+	}
+}
+
+func TestUnpackTargzWithFilter(t *testing.T) {
+	input := writeTestTar(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/app.conf", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "tmp/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "tmp/cache", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	dst := t.TempDir()
+	rules := &FilterRules{Drop: []string{"tmp/**"}}
+	require.NoError(t, UnpackTargzWithFilter(context.Background(), dst, input, false, nil, rules))
+
+	data, err := os.ReadFile(filepath.Join(dst, "etc/app.conf"))
+	require.NoError(t, err)
+	require.Equal(t, "etc/app.conf", string(data))
+	_, err = os.Stat(filepath.Join(dst, "tmp"))
+	require.True(t, os.IsNotExist(err))
+}
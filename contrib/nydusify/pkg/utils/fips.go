@@ -0,0 +1,67 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// fipsMode restricts nydusify to FIPS 140 approved hash and TLS
+// implementations, it's enabled by the global `--fips` option.
+var fipsMode bool
+
+// EnableFIPSMode turns on the FIPS mode, it requires the Go runtime to
+// run in FIPS 140 mode, that is nydusify is built with `GOFIPS140` or
+// started with `GODEBUG=fips140=on`, so that the crypto packages only
+// use the validated module.
+func EnableFIPSMode() error {
+	if !fips140Enabled() {
+		return errors.New("FIPS mode requires nydusify to be built with GOFIPS140 (see `make fips`) or run with GODEBUG=fips140=on")
+	}
+	fipsMode = true
+	return nil
+}
+
+// IsFIPSMode returns whether the FIPS mode is enabled.
+func IsFIPSMode() bool {
+	return fipsMode
+}
+
+// CheckFIPSDigestAlgorithm refuses the digest algorithm not approved by
+// FIPS 140 in FIPS mode.
+func CheckFIPSDigestAlgorithm(alg digest.Algorithm) error {
+	if !fipsMode {
+		return nil
+	}
+	switch alg {
+	case digest.SHA256, digest.SHA384, digest.SHA512:
+		return nil
+	default:
+		return fmt.Errorf("digest algorithm %s is not allowed in FIPS mode", alg)
+	}
+}
+
+// NewTLSConfig creates the TLS client config used to access registry,
+// only TLS 1.2+ with approved cipher suites is allowed in FIPS mode.
+func NewTLSConfig(skipVerify bool) *tls.Config {
+	config := &tls.Config{
+		InsecureSkipVerify: skipVerify,
+	}
+	if fipsMode {
+		config.MinVersion = tls.VersionTLS12
+		config.CipherSuites = []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		}
+		config.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+	}
+	return config
+}
@@ -0,0 +1,13 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build go1.24
+
+package utils
+
+import "crypto/fips140"
+
+func fips140Enabled() bool {
+	return fips140.Enabled()
+}
@@ -0,0 +1,12 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !go1.24
+
+package utils
+
+// The Go runtime provides the FIPS 140 mode since go1.24.
+func fips140Enabled() bool {
+	return false
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"archive/tar"
+	"strings"
+	"time"
+)
+
+// The PAX records of extended attributes, which are kept by NormalizeHeader.
+var paxXattrPrefixes = []string{"SCHILY.xattr.", "LIBARCHIVE.xattr."}
+
+// NormalizeHeader drops the tar header fields depending on the build host or
+// the tar producer rather than the file itself, so that the layer unpacked
+// or rebuilt from the same files is byte-stable across hosts:
+//
+//   - uname and gname, which are looked up in the user database of host by
+//     some producers, the ownership is kept by uid and gid;
+//   - access and change time, and the sub-second part of mtime, which depend
+//     on the timestamp granularity of producer;
+//   - PAX records other than extended attributes, e.g. charset or comment;
+//   - the file type bits of mode, and device numbers of non-device files;
+//   - the tar format, so that the writer chooses the same format for the
+//     same header.
+func NormalizeHeader(hdr *tar.Header) {
+	hdr.Uname = ""
+	hdr.Gname = ""
+	hdr.ModTime = hdr.ModTime.Truncate(time.Second).UTC()
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	hdr.Mode &= 0o7777
+	if hdr.Typeflag != tar.TypeChar && hdr.Typeflag != tar.TypeBlock {
+		hdr.Devmajor = 0
+		hdr.Devminor = 0
+	}
+	for key := range hdr.PAXRecords {
+		if !isPAXXattr(key) {
+			delete(hdr.PAXRecords, key)
+		}
+	}
+	if len(hdr.PAXRecords) == 0 {
+		hdr.PAXRecords = nil
+	}
+	hdr.Format = tar.FormatUnknown
+}
+
+func isPAXXattr(key string) bool {
+	for _, prefix := range paxXattrPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeHeader(t *testing.T) {
+	mtime := time.Date(2023, 6, 1, 8, 0, 0, 0, time.UTC)
+	// The same files archived by different producers on different hosts.
+	rebuild := func(uname string, format tar.Format, zone *time.Location, nsec int) []byte {
+		input := writeTestTar(t, []*tar.Header{
+			{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755, Uname: uname, Format: format, ModTime: mtime.In(zone)},
+			{
+				Name: "etc/app.conf", Typeflag: tar.TypeReg, Mode: 0644, Uid: 1000, Uname: uname, Gname: uname, Format: format,
+				ModTime:    mtime.Add(time.Duration(nsec)).In(zone),
+				AccessTime: time.Now(),
+				PAXRecords: map[string]string{"SCHILY.xattr.user.key": "value"},
+			},
+		})
+		output := &bytes.Buffer{}
+		_, err := FilterTar(input, output, &FilterRules{})
+		require.NoError(t, err)
+		return output.Bytes()
+	}
+	expected := rebuild("", tar.FormatUnknown, time.UTC, 0)
+	require.Equal(t, expected, rebuild("root", tar.FormatPAX, time.Local, 0))
+	require.Equal(t, expected, rebuild("admin", tar.FormatPAX, time.FixedZone("CST", 8*3600), 500))
+
+	// The extended attributes are kept.
+	tr := tar.NewReader(bytes.NewReader(expected))
+	_, err := tr.Next()
+	require.NoError(t, err)
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "value", hdr.PAXRecords["SCHILY.xattr.user.key"])
+	require.Equal(t, 1000, hdr.Uid)
+	require.Empty(t, hdr.Uname)
+	require.Equal(t, mtime.Unix(), hdr.ModTime.Unix())
+}
@@ -0,0 +1,139 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// AllPlatforms is the platform selector matching all platforms.
+const AllPlatforms = "all"
+
+// platformSelector matches the platform, the variant is ignored if it's
+// not specified, for example `linux/arm` matches both `linux/arm/v6` and
+// `linux/arm/v7`, while `linux/arm/v7` only matches `linux/arm/v7`.
+type platformSelector struct {
+	platform    ocispec.Platform
+	withVariant bool
+}
+
+func (selector platformSelector) match(platform ocispec.Platform) bool {
+	platform = platforms.Normalize(platform)
+	if platform.OS != selector.platform.OS || platform.Architecture != selector.platform.Architecture {
+		return false
+	}
+	return !selector.withVariant || platform.Variant == selector.platform.Variant
+}
+
+// platformMatcher matches any of the selectors, the platform matching the
+// former selector is preferred.
+type platformMatcher []platformSelector
+
+func (matcher platformMatcher) index(platform ocispec.Platform) int {
+	for idx, selector := range matcher {
+		if selector.match(platform) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (matcher platformMatcher) Match(platform ocispec.Platform) bool {
+	return matcher.index(platform) >= 0
+}
+
+func (matcher platformMatcher) Less(p1, p2 ocispec.Platform) bool {
+	idx1, idx2 := matcher.index(p1), matcher.index(p2)
+	if idx1 >= 0 && idx2 >= 0 {
+		return idx1 < idx2
+	}
+	return idx1 >= 0
+}
+
+// ParsePlatforms parses the platform selectors split by comma, for example
+// `linux/amd64,linux/arm64/v8`, `all` selects all platforms, the default
+// platform is selected if empty.
+func ParsePlatforms(all bool, value string) (platforms.MatchComparer, error) {
+	if all {
+		return platforms.All, nil
+	}
+
+	matcher := platformMatcher{}
+	seen := map[string]bool{}
+	for _, specifier := range strings.Split(value, ",") {
+		specifier = strings.TrimSpace(specifier)
+		if specifier == "" || seen[specifier] {
+			continue
+		}
+		seen[specifier] = true
+		if strings.EqualFold(specifier, AllPlatforms) {
+			return platforms.All, nil
+		}
+		platform, err := platforms.Parse(specifier)
+		if err != nil {
+			return nil, fmt.Errorf("invalid platform %q", specifier)
+		}
+		// The variant may be filled or cleared by normalization, e.g. `v8`
+		// for arm64, so check whether it's specified by the components.
+		matcher = append(matcher, platformSelector{
+			platform:    platforms.Normalize(platform),
+			withVariant: strings.Count(specifier, "/") >= 2,
+		})
+	}
+	if len(matcher) == 0 {
+		return platforms.DefaultStrict(), nil
+	}
+
+	return matcher, nil
+}
+
+// UnmatchedPlatforms returns the platform selectors split by comma, which
+// match none of the available platforms, the default platform is checked
+// if value is empty. Nothing is returned if all platforms are selected.
+func UnmatchedPlatforms(all bool, value string, available []ocispec.Platform) ([]string, error) {
+	if all {
+		return nil, nil
+	}
+
+	specifiers := []string{}
+	seen := map[string]bool{}
+	for _, specifier := range strings.Split(value, ",") {
+		specifier = strings.TrimSpace(specifier)
+		if specifier == "" || seen[specifier] {
+			continue
+		}
+		seen[specifier] = true
+		specifiers = append(specifiers, specifier)
+	}
+	if len(specifiers) == 0 {
+		specifiers = append(specifiers, platforms.DefaultString())
+	}
+
+	unmatched := []string{}
+	for _, specifier := range specifiers {
+		matcher, err := ParsePlatforms(false, specifier)
+		if err != nil {
+			return nil, err
+		}
+		if matcher == platforms.All {
+			return nil, nil
+		}
+		found := false
+		for _, platform := range available {
+			if matcher.Match(platform) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, specifier)
+		}
+	}
+	return unmatched, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	armv6 := ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}
+	armv7 := ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	windows := ocispec.Platform{OS: "windows", Architecture: "amd64"}
+
+	for _, value := range []string{"all", "linux/amd64,ALL"} {
+		matcher, err := ParsePlatforms(false, value)
+		require.NoError(t, err)
+		require.True(t, matcher.Match(windows))
+	}
+
+	matcher, err := ParsePlatforms(true, "linux/amd64")
+	require.NoError(t, err)
+	require.True(t, matcher.Match(armv6))
+
+	matcher, err = ParsePlatforms(false, "linux/arm64, linux/amd64")
+	require.NoError(t, err)
+	require.True(t, matcher.Match(amd64))
+	require.True(t, matcher.Match(arm64))
+	require.True(t, matcher.Match(ocispec.Platform{OS: "linux", Architecture: "arm64"}))
+	require.False(t, matcher.Match(windows))
+	require.True(t, matcher.Less(arm64, amd64))
+	require.False(t, matcher.Less(amd64, arm64))
+	require.True(t, matcher.Less(amd64, windows))
+
+	// The variant is ignored if not specified.
+	matcher, err = ParsePlatforms(false, "linux/arm")
+	require.NoError(t, err)
+	require.True(t, matcher.Match(armv6))
+	require.True(t, matcher.Match(armv7))
+	require.False(t, matcher.Match(arm64))
+
+	matcher, err = ParsePlatforms(false, "linux/arm/v7,linux/arm64/v8")
+	require.NoError(t, err)
+	require.False(t, matcher.Match(armv6))
+	require.True(t, matcher.Match(armv7))
+	require.True(t, matcher.Match(arm64))
+
+	_, err = ParsePlatforms(false, "linux/amd64,invalid/platform/x/y")
+	require.Error(t, err)
+}
+
+func TestUnmatchedPlatforms(t *testing.T) {
+	available := []ocispec.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+	}
+
+	unmatched, err := UnmatchedPlatforms(false, "linux/amd64, linux/arm, linux/arm64,linux/arm/v6", available)
+	require.NoError(t, err)
+	require.Equal(t, []string{"linux/arm64", "linux/arm/v6"}, unmatched)
+
+	unmatched, err = UnmatchedPlatforms(false, "linux/s390x,all", available)
+	require.NoError(t, err)
+	require.Empty(t, unmatched)
+	unmatched, err = UnmatchedPlatforms(true, "linux/s390x", available)
+	require.NoError(t, err)
+	require.Empty(t, unmatched)
+
+	_, err = UnmatchedPlatforms(false, "invalid/platform/x/y", available)
+	require.Error(t, err)
+}
@@ -0,0 +1,434 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"debug/elf"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// LayerOpener opens the uncompressed tar stream of layer.
+type LayerOpener func() (io.ReadCloser, error)
+
+const (
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	// The files larger than this are not inspected for interpreter and
+	// linked libraries, as they're read into memory.
+	maxInspectSize = 128 << 20
+	maxSymlinks    = 40
+)
+
+// prefetchConfigPaths are the files commonly read by processes on startup,
+// which are prefetched if exist.
+var prefetchConfigPaths = []string{
+	"/etc/ld.so.cache",
+	"/etc/ld.so.preload",
+	"/etc/nsswitch.conf",
+	"/etc/passwd",
+	"/etc/group",
+	"/etc/hosts",
+	"/etc/host.conf",
+	"/etc/localtime",
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/ssl/cert.pem",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+}
+
+var defaultLibraryDirs = []string{"/lib", "/usr/lib", "/lib64", "/usr/lib64", "/usr/local/lib"}
+
+var shells = map[string]bool{"sh": true, "bash": true, "ash": true, "dash": true, "zsh": true}
+
+type indexEntry struct {
+	layer    int
+	typeflag byte
+	linkname string
+}
+
+// layerIndex is the merged view of the entries in layers, whiteouts
+// applied, without file data.
+type layerIndex struct {
+	entries map[string]*indexEntry
+	layers  []LayerOpener
+}
+
+func cleanAbsPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+func newLayerIndex(layers []LayerOpener) (*layerIndex, error) {
+	index := &layerIndex{entries: map[string]*indexEntry{}, layers: layers}
+	for idx, open := range layers {
+		if err := index.add(idx, open); err != nil {
+			return nil, errors.Wrapf(err, "index layer %d", idx)
+		}
+	}
+	return index, nil
+}
+
+// remove removes the entries of lower layers under dir, and dir itself if
+// self is true.
+func (index *layerIndex) remove(dir string, self bool, below int) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for name, entry := range index.entries {
+		if entry.layer < below && ((self && name == dir) || strings.HasPrefix(name, prefix)) {
+			delete(index.entries, name)
+		}
+	}
+}
+
+func (index *layerIndex) add(idx int, open LayerOpener) error {
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read tar")
+		}
+		name := cleanAbsPath(hdr.Name)
+		dir, base := path.Split(name)
+		dir = path.Clean(dir)
+		if base == ".wh..wh..opq" {
+			index.remove(dir, false, idx)
+			continue
+		}
+		if strings.HasPrefix(base, ".wh.") {
+			index.remove(path.Join(dir, strings.TrimPrefix(base, ".wh.")), true, idx)
+			continue
+		}
+		entry := &indexEntry{layer: idx, typeflag: hdr.Typeflag, linkname: hdr.Linkname}
+		if hdr.Typeflag == tar.TypeLink {
+			entry.linkname = cleanAbsPath(hdr.Linkname)
+		}
+		index.entries[name] = entry
+		// The parent directories may be omitted in tar.
+		for parent := path.Dir(name); parent != "/"; parent = path.Dir(parent) {
+			if _, ok := index.entries[parent]; ok {
+				break
+			}
+			index.entries[parent] = &indexEntry{layer: idx, typeflag: tar.TypeDir}
+		}
+	}
+}
+
+// resolve follows the symlinks in path, it returns the real path of file
+// and whether it exists.
+func (index *layerIndex) resolve(p string) (string, bool) {
+	components := strings.Split(strings.TrimPrefix(cleanAbsPath(p), "/"), "/")
+	resolved := "/"
+	hops := 0
+	for len(components) > 0 {
+		component := components[0]
+		components = components[1:]
+		if component == "" || component == "." {
+			continue
+		}
+		if component == ".." {
+			resolved = path.Dir(resolved)
+			continue
+		}
+		current := path.Join(resolved, component)
+		entry, ok := index.entries[current]
+		if !ok {
+			return "", false
+		}
+		if entry.typeflag != tar.TypeSymlink {
+			resolved = current
+			continue
+		}
+		if hops++; hops > maxSymlinks {
+			return "", false
+		}
+		target := entry.linkname
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		components = append(strings.Split(strings.Trim(target, "/"), "/"), components...)
+	}
+	return resolved, true
+}
+
+// regular returns the real path of regular file, and whether it exists.
+func (index *layerIndex) regular(p string) (string, bool) {
+	resolved, ok := index.resolve(p)
+	if !ok {
+		return "", false
+	}
+	entry := index.entries[resolved]
+	if entry == nil || (entry.typeflag != tar.TypeReg && entry.typeflag != tar.TypeRegA && entry.typeflag != tar.TypeLink) {
+		return "", false
+	}
+	return resolved, true
+}
+
+// readFiles reads the data of regular files by their real paths, the data
+// of hardlinks are read from their link targets.
+func (index *layerIndex) readFiles(paths []string) (map[string][]byte, error) {
+	// The wanted entry paths in tar of each layer.
+	wanted := map[int]map[string][]string{}
+	for _, p := range paths {
+		entry := index.entries[p]
+		name := p
+		if entry.typeflag == tar.TypeLink {
+			name = entry.linkname
+		}
+		if wanted[entry.layer] == nil {
+			wanted[entry.layer] = map[string][]string{}
+		}
+		wanted[entry.layer][name] = append(wanted[entry.layer][name], p)
+	}
+
+	files := map[string][]byte{}
+	for idx, names := range wanted {
+		if err := index.readLayer(idx, names, files); err != nil {
+			return nil, errors.Wrapf(err, "read layer %d", idx)
+		}
+	}
+	return files, nil
+}
+
+func (index *layerIndex) readLayer(idx int, names map[string][]string, files map[string][]byte) error {
+	reader, err := index.layers[idx]()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read tar")
+		}
+		paths, ok := names[cleanAbsPath(hdr.Name)]
+		if !ok || hdr.Typeflag == tar.TypeLink {
+			continue
+		}
+		if hdr.Size > maxInspectSize {
+			logrus.Debugf("skip inspecting large file %s", hdr.Name)
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return errors.Wrapf(err, "read file %s", hdr.Name)
+		}
+		for _, p := range paths {
+			files[p] = data
+		}
+	}
+}
+
+// libraryDirs returns the library search directories of image, including
+// the multiarch ones like `/usr/lib/x86_64-linux-gnu`.
+func (index *layerIndex) libraryDirs() []string {
+	dirs := append([]string{}, defaultLibraryDirs...)
+	multiarch := []string{}
+	for name, entry := range index.entries {
+		if entry.typeflag != tar.TypeDir && entry.typeflag != tar.TypeSymlink {
+			continue
+		}
+		dir := path.Dir(name)
+		if (dir == "/lib" || dir == "/usr/lib") && strings.Contains(path.Base(name), "-linux-") {
+			multiarch = append(multiarch, name)
+		}
+	}
+	sort.Strings(multiarch)
+	return append(multiarch, dirs...)
+}
+
+// programs returns the candidate paths of the programs started by the
+// entrypoint of image config.
+func programs(config ocispec.ImageConfig) []string {
+	args := append(append([]string{}, config.Entrypoint...), config.Cmd...)
+	if len(args) == 0 {
+		return nil
+	}
+	progs := []string{args[0]}
+	// The command of shell form, like `sh -c "exec nginx -g 'daemon off;'"`.
+	if shells[path.Base(args[0])] && len(args) > 2 && args[1] == "-c" {
+		fields := strings.Fields(args[2])
+		if len(fields) > 0 && fields[0] == "exec" {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 {
+			progs = append(progs, fields[0])
+		}
+	}
+	return progs
+}
+
+// lookPath returns the candidate paths of program as searched by shell in
+// the PATH of image config.
+func lookPath(config ocispec.ImageConfig, prog string) []string {
+	if path.IsAbs(prog) {
+		return []string{prog}
+	}
+	if strings.Contains(prog, "/") {
+		return []string{path.Join("/", config.WorkingDir, prog)}
+	}
+	searchPath := defaultPath
+	for _, env := range config.Env {
+		if strings.HasPrefix(env, "PATH=") {
+			searchPath = strings.TrimPrefix(env, "PATH=")
+		}
+	}
+	candidates := []string{}
+	for _, dir := range strings.Split(searchPath, ":") {
+		if path.IsAbs(dir) {
+			candidates = append(candidates, path.Join(dir, prog))
+		}
+	}
+	return candidates
+}
+
+// inspect returns the interpreter and linked libraries of ELF, or the
+// interpreter of script, and the runpath of ELF for library searching.
+func inspect(p string, data []byte) ([]string, []string, []string) {
+	if bytes.HasPrefix(data, []byte("#!")) {
+		line := strings.SplitN(string(data[2:]), "\n", 2)[0]
+		if fields := strings.Fields(line); len(fields) > 0 {
+			interps := []string{fields[0]}
+			// `#!/usr/bin/env python3` runs the program in PATH.
+			if path.Base(fields[0]) == "env" && len(fields) > 1 {
+				interps = append(interps, fields[1])
+			}
+			return interps, nil, nil
+		}
+		return nil, nil, nil
+	}
+
+	file, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, nil
+	}
+	defer file.Close()
+
+	interps := []string{}
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		interp, err := io.ReadAll(prog.Open())
+		if err == nil {
+			interps = append(interps, strings.TrimRight(string(interp), "\x00"))
+		}
+	}
+	libs, _ := file.ImportedLibraries()
+	runpaths := []string{}
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		values, _ := file.DynString(tag)
+		for _, value := range values {
+			for _, dir := range strings.Split(value, ":") {
+				dir = strings.ReplaceAll(dir, "$ORIGIN", path.Dir(p))
+				dir = strings.ReplaceAll(dir, "${ORIGIN}", path.Dir(p))
+				if path.IsAbs(dir) {
+					runpaths = append(runpaths, dir)
+				}
+			}
+		}
+	}
+	return interps, libs, runpaths
+}
+
+// InferPrefetchPatterns infers the files to be prefetched on container
+// startup by heuristics, from the image config and the uncompressed layers
+// ordered from bottom to top: the entrypoint program, its ELF interpreter
+// and linked libraries recursively, and the commonly read config files.
+// The returned paths are real paths of existing files, sorted.
+func InferPrefetchPatterns(config ocispec.ImageConfig, layers []LayerOpener) ([]string, error) {
+	index, err := newLayerIndex(layers)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+	pending := []string{}
+	addFile := func(p string) {
+		if resolved, ok := index.regular(p); ok && !found[resolved] {
+			found[resolved] = true
+			pending = append(pending, resolved)
+		}
+	}
+	addProgram := func(prog string) {
+		for _, candidate := range lookPath(config, prog) {
+			if _, ok := index.regular(candidate); ok {
+				addFile(candidate)
+				return
+			}
+		}
+	}
+
+	for _, prog := range programs(config) {
+		addProgram(prog)
+	}
+
+	var libraryDirs []string
+	for len(pending) > 0 {
+		files, err := index.readFiles(pending)
+		if err != nil {
+			return nil, err
+		}
+		pending = nil
+		// Inspect in order for the stable result.
+		inspected := make([]string, 0, len(files))
+		for p := range files {
+			inspected = append(inspected, p)
+		}
+		sort.Strings(inspected)
+		for _, p := range inspected {
+			interps, libs, runpaths := inspect(p, files[p])
+			for _, interp := range interps {
+				addProgram(interp)
+			}
+			if len(libs) > 0 && libraryDirs == nil {
+				libraryDirs = index.libraryDirs()
+			}
+			for _, lib := range libs {
+				if strings.Contains(lib, "/") {
+					addFile(lib)
+					continue
+				}
+				for _, dir := range append(runpaths, libraryDirs...) {
+					if _, ok := index.regular(path.Join(dir, lib)); ok {
+						addFile(path.Join(dir, lib))
+						break
+					}
+				}
+			}
+		}
+	}
+
+	for _, p := range prefetchConfigPaths {
+		if resolved, ok := index.regular(p); ok {
+			found[resolved] = true
+		}
+	}
+
+	paths := make([]string, 0, len(found))
+	for p := range found {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
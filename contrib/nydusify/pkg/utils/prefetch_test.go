@@ -0,0 +1,119 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"debug/elf"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+type testEntry struct {
+	hdr  tar.Header
+	data []byte
+}
+
+func testLayer(t *testing.T, entries []testEntry) LayerOpener {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		hdr := entry.hdr
+		hdr.Size = int64(len(entry.data))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write(entry.data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	}
+}
+
+func TestInferPrefetchPatternsScript(t *testing.T) {
+	lower := testLayer(t, []testEntry{
+		{hdr: tar.Header{Name: "bin", Typeflag: tar.TypeSymlink, Linkname: "usr/bin"}},
+		{hdr: tar.Header{Name: "usr/bin/", Typeflag: tar.TypeDir}},
+		{hdr: tar.Header{Name: "usr/bin/python3", Typeflag: tar.TypeReg}, data: []byte("not elf")},
+		{hdr: tar.Header{Name: "usr/bin/env", Typeflag: tar.TypeReg}, data: []byte("not elf")},
+		{hdr: tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg}, data: []byte("root:x:0:0")},
+		{hdr: tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: "app/old.py", Typeflag: tar.TypeReg}},
+	})
+	upper := testLayer(t, []testEntry{
+		{hdr: tar.Header{Name: "etc/.wh.hosts", Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: "app/.wh..wh..opq", Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: "app/run", Typeflag: tar.TypeReg}, data: []byte("#!/usr/bin/env python3\nprint()\n")},
+		{hdr: tar.Header{Name: "app/start", Typeflag: tar.TypeLink, Linkname: "app/run"}},
+	})
+
+	paths, err := InferPrefetchPatterns(ocispec.ImageConfig{
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Cmd:        []string{"exec ./start --debug"},
+		WorkingDir: "/app",
+		Env:        []string{"PATH=/bin"},
+	}, []LayerOpener{lower, upper})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/app/start", "/etc/passwd", "/usr/bin/env", "/usr/bin/python3"}, paths)
+
+	paths, err = InferPrefetchPatterns(ocispec.ImageConfig{}, []LayerOpener{lower})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/etc/hosts", "/etc/passwd"}, paths)
+}
+
+func TestInferPrefetchPatternsELF(t *testing.T) {
+	binPath, err := exec.LookPath("ls")
+	if err != nil {
+		t.Skip("ls is not found")
+	}
+	data, err := os.ReadFile(binPath)
+	require.NoError(t, err)
+	file, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Skip("ls is not an ELF")
+	}
+	interps, libs, _ := inspect("/bin/ls", data)
+	file.Close()
+	if len(interps) == 0 || len(libs) == 0 {
+		t.Skip("ls is not dynamically linked")
+	}
+
+	// The libraries are placed in the multiarch directory under `/usr/lib`,
+	// which is linked from `/lib` like merged-usr distributions.
+	entries := []testEntry{
+		{hdr: tar.Header{Name: "lib", Typeflag: tar.TypeSymlink, Linkname: "usr/lib"}},
+		{hdr: tar.Header{Name: "usr/lib/x86_64-linux-gnu/", Typeflag: tar.TypeDir}},
+		{hdr: tar.Header{Name: "usr/bin/ls", Typeflag: tar.TypeReg}, data: data},
+		{hdr: tar.Header{Name: "usr/lib/x86_64-linux-gnu/unused.so", Typeflag: tar.TypeReg}},
+	}
+	expected := []string{"/usr/bin/ls"}
+	interp := path.Join("/usr/lib/x86_64-linux-gnu", path.Base(interps[0]))
+	entries = append(entries,
+		testEntry{hdr: tar.Header{Name: interp, Typeflag: tar.TypeReg}},
+		testEntry{hdr: tar.Header{Name: path.Dir(interps[0]) + "/" + path.Base(interps[0]), Typeflag: tar.TypeSymlink, Linkname: interp}},
+	)
+	expected = append(expected, interp)
+	for _, lib := range libs {
+		name := path.Join("/usr/lib/x86_64-linux-gnu", path.Base(lib))
+		if name == interp {
+			continue
+		}
+		entries = append(entries, testEntry{hdr: tar.Header{Name: name, Typeflag: tar.TypeReg}})
+		expected = append(expected, name)
+	}
+
+	paths, err := InferPrefetchPatterns(ocispec.ImageConfig{
+		Cmd: []string{"ls", "-l"},
+	}, []LayerOpener{testLayer(t, entries)})
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, paths)
+}
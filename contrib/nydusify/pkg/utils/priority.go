@@ -0,0 +1,126 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cgroupMountPoint = "/sys/fs/cgroup"
+	cgroupProcsFile  = "cgroup.procs"
+	// priorityWrapperName is the name of the wrapper script applying the
+	// priority to the command.
+	priorityWrapperName = "nydus-image-priority"
+)
+
+var ioprioClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// PriorityOpt specifies the CPU and IO priority of the spawned nydus-image
+// processes, so that conversions running on shared nodes don't degrade
+// colocated workloads, nydusify itself keeps its own priority.
+type PriorityOpt struct {
+	// Nice is the CPU nice value in range [-20, 19], 0 keeps unchanged.
+	Nice int
+	// IOClass is the IO scheduling class, possible values: realtime,
+	// best-effort, idle, empty keeps unchanged.
+	IOClass string
+	// IOLevel is the IO priority level in range [0, 7], it's ignored by
+	// idle class.
+	IOLevel int
+	// Cgroup is a cgroup v2 directory to move the processes into, a
+	// relative path is resolved under /sys/fs/cgroup.
+	Cgroup string
+}
+
+// Validate checks the priority options.
+func (opt PriorityOpt) Validate() error {
+	if opt.Nice < -20 || opt.Nice > 19 {
+		return fmt.Errorf("invalid nice value %d, should be in range [-20, 19]", opt.Nice)
+	}
+	if opt.IOClass != "" {
+		if _, ok := ioprioClasses[opt.IOClass]; !ok {
+			return fmt.Errorf("invalid io class %s, possible values: realtime, best-effort, idle", opt.IOClass)
+		}
+		if opt.IOLevel < 0 || opt.IOLevel > 7 {
+			return fmt.Errorf("invalid io level %d, should be in range [0, 7]", opt.IOLevel)
+		}
+	}
+	return nil
+}
+
+// Enabled returns true if any priority option is set.
+func (opt PriorityOpt) Enabled() bool {
+	return opt.Nice != 0 || opt.IOClass != "" || opt.Cgroup != ""
+}
+
+// WrapPriority writes a wrapper script into dir, which moves itself into
+// the cgroup, then replaces itself by the command with the nice value and
+// io priority set by `nice` and `ionice`, the returned script path can be
+// used in place of the command path.
+func WrapPriority(command string, opt PriorityOpt, dir string) (string, error) {
+	if err := opt.Validate(); err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "linux" {
+		return "", errors.New("nice value, io priority and cgroup are only supported on Linux")
+	}
+	command, err := exec.LookPath(command)
+	if err != nil {
+		return "", errors.Wrapf(err, "find command %s", command)
+	}
+	command, err = filepath.Abs(command)
+	if err != nil {
+		return "", errors.Wrap(err, "get absolute command path")
+	}
+
+	script := "#!/bin/sh\n"
+	if opt.Cgroup != "" {
+		cgroup := cgroupDir(opt.Cgroup)
+		if _, err := os.Stat(filepath.Join(cgroup, cgroupProcsFile)); err != nil {
+			return "", errors.Wrapf(err, "check cgroup %s", cgroup)
+		}
+		script += fmt.Sprintf("echo $$ > %s || exit 1\n", ShellQuote(filepath.Join(cgroup, cgroupProcsFile)))
+	}
+	args := []string{}
+	if opt.Nice != 0 {
+		nice, err := exec.LookPath("nice")
+		if err != nil {
+			return "", errors.Wrap(err, "find nice")
+		}
+		// The `nice -n` adjusts the nice value relatively.
+		args = append(args, ShellQuote(nice), "-n", fmt.Sprintf("$((%d - $(%s)))", opt.Nice, ShellQuote(nice)))
+	}
+	if opt.IOClass != "" {
+		ionice, err := exec.LookPath("ionice")
+		if err != nil {
+			return "", errors.Wrap(err, "find ionice")
+		}
+		args = append(args, ShellQuote(ionice), "-c", fmt.Sprintf("%d", ioprioClasses[opt.IOClass]))
+		if opt.IOClass != "idle" {
+			args = append(args, "-n", fmt.Sprintf("%d", opt.IOLevel))
+		}
+	}
+	args = append(args, ShellQuote(command), `"$@"`)
+	script += "exec " + strings.Join(args, " ") + "\n"
+
+	wrapperPath := filepath.Join(dir, priorityWrapperName)
+	if err := os.WriteFile(wrapperPath, []byte(script), 0755); err != nil {
+		return "", errors.Wrap(err, "write priority wrapper")
+	}
+
+	return wrapperPath, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityOpt(t *testing.T) {
+	require.NoError(t, PriorityOpt{Nice: 10, IOClass: "idle"}.Validate())
+	require.NoError(t, PriorityOpt{IOClass: "best-effort", IOLevel: 7}.Validate())
+	require.Error(t, PriorityOpt{Nice: 20}.Validate())
+	require.Error(t, PriorityOpt{IOClass: "unknown"}.Validate())
+	require.Error(t, PriorityOpt{IOClass: "best-effort", IOLevel: 8}.Validate())
+	require.False(t, PriorityOpt{IOLevel: 4}.Enabled())
+	require.True(t, PriorityOpt{Cgroup: "nydusify"}.Enabled())
+}
+
+func TestWrapPriority(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("priority is only supported on Linux")
+	}
+	for _, tool := range []string{"nice", "ionice"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	dir := t.TempDir()
+	command := filepath.Join(dir, "command")
+	require.NoError(t, os.WriteFile(command, []byte("#!/bin/sh\nnice\nionice\necho \"$@\"\n"), 0755))
+
+	// The priority is applied to the command only.
+	wrapperPath, err := WrapPriority(command, PriorityOpt{Nice: 10, IOClass: "idle"}, dir)
+	require.NoError(t, err)
+	output, err := exec.Command(wrapperPath, "--arg", "it's").Output()
+	require.NoError(t, err)
+	require.Equal(t, []string{"10", "idle", "--arg it's"}, strings.Split(strings.TrimSpace(string(output)), "\n"))
+	output, err = exec.Command("nice").Output()
+	require.NoError(t, err)
+	require.NotEqual(t, "10", strings.TrimSpace(string(output)))
+
+	_, err = WrapPriority(command, PriorityOpt{Cgroup: filepath.Join(dir, "not-exist")}, dir)
+	require.Error(t, err)
+	_, err = WrapPriority(command, PriorityOpt{Nice: 20}, dir)
+	require.Error(t, err)
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/sirupsen/logrus"
+)
+
+// UnpackProgressInterval is the interval to report the progress of layer
+// unpack.
+var UnpackProgressInterval = 5 * time.Second
+
+// UnpackStat is a snapshot of the progress of layer unpack.
+type UnpackStat struct {
+	// Index is the 1-based index of layer, and Total is the layer count.
+	Index   int
+	Total   int
+	Files   int64
+	Bytes   int64
+	Elapsed time.Duration
+	Done    bool
+}
+
+// Throughput returns the average unpacked bytes per second.
+func (stat UnpackStat) Throughput() float64 {
+	if stat.Elapsed <= 0 {
+		return 0
+	}
+	return float64(stat.Bytes) / stat.Elapsed.Seconds()
+}
+
+func formatCount(count int64) string {
+	switch {
+	case count >= 1000000:
+		return fmt.Sprintf("%.1fM", float64(count)/1000000)
+	case count >= 1000:
+		return fmt.Sprintf("%.1fk", float64(count)/1000)
+	default:
+		return fmt.Sprintf("%d", count)
+	}
+}
+
+func (stat UnpackStat) String() string {
+	verb := "unpacking"
+	if stat.Done {
+		verb = "unpacked"
+	}
+	return fmt.Sprintf(
+		"%s layer %d/%d: %s files, %s, %s/s", verb, stat.Index, stat.Total, formatCount(stat.Files),
+		humanize.IBytes(uint64(stat.Bytes)), humanize.IBytes(uint64(stat.Throughput())),
+	)
+}
+
+// UnpackProgress counts the files and bytes unpacked from a layer, and
+// reports them periodically, so that a long unpack isn't mistaken for a
+// hang.
+type UnpackProgress struct {
+	index int
+	total int
+	files int64
+	bytes int64
+	start time.Time
+
+	// Report is called with the progress every UnpackProgressInterval and
+	// once after the unpack is done, it logs the progress by default.
+	Report func(UnpackStat)
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewUnpackProgress creates the progress of layer `index` (1-based) in
+// `total` layers.
+func NewUnpackProgress(index, total int) *UnpackProgress {
+	return &UnpackProgress{
+		index: index,
+		total: total,
+		Report: func(stat UnpackStat) {
+			logrus.Info(stat.String())
+		},
+	}
+}
+
+// Stat returns the current progress.
+func (progress *UnpackProgress) Stat() UnpackStat {
+	return UnpackStat{
+		Index:   progress.index,
+		Total:   progress.total,
+		Files:   atomic.LoadInt64(&progress.files),
+		Bytes:   atomic.LoadInt64(&progress.bytes),
+		Elapsed: time.Since(progress.start),
+	}
+}
+
+// AddFile counts an unpacked tar entry.
+func (progress *UnpackProgress) AddFile() {
+	atomic.AddInt64(&progress.files, 1)
+}
+
+// Reader counts the bytes read from the uncompressed tar stream.
+func (progress *UnpackProgress) Reader(reader io.Reader) io.Reader {
+	return &progressReader{reader: reader, progress: progress}
+}
+
+// Start starts reporting the progress periodically.
+func (progress *UnpackProgress) Start() {
+	progress.start = time.Now()
+	progress.stop = make(chan struct{})
+	progress.wg.Add(1)
+	go func() {
+		defer progress.wg.Done()
+		ticker := time.NewTicker(UnpackProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				progress.Report(progress.Stat())
+			case <-progress.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Done stops the periodical report and reports the final progress if the
+// unpack is succeeded.
+func (progress *UnpackProgress) Done(err error) {
+	close(progress.stop)
+	progress.wg.Wait()
+	if err == nil {
+		stat := progress.Stat()
+		stat.Done = true
+		progress.Report(stat)
+	}
+}
+
+type progressReader struct {
+	reader   io.Reader
+	progress *UnpackProgress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	atomic.AddInt64(&r.progress.bytes, int64(n))
+	return n, err
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnpackStat(t *testing.T) {
+	stat := UnpackStat{Index: 3, Total: 12, Files: 45000, Bytes: 2 << 30, Elapsed: 2 * time.Second}
+	require.Equal(t, float64(1<<30), stat.Throughput())
+	require.Equal(t, "unpacking layer 3/12: 45.0k files, 2.0 GiB, 1.0 GiB/s", stat.String())
+
+	stat = UnpackStat{Index: 1, Total: 1, Files: 12, Done: true}
+	require.Equal(t, "unpacked layer 1/1: 12 files, 0 B, 0 B/s", stat.String())
+}
+
+func TestUnpackTargzWithProgress(t *testing.T) {
+	buf := bytes.Buffer{}
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir", Mode: 0755, Typeflag: tar.TypeDir}))
+	for idx := 0; idx < 3; idx++ {
+		data := []byte(fmt.Sprintf("file-%d", idx))
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     fmt.Sprintf("dir/file-%d", idx),
+			Mode:     0644,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	size := int64(buf.Len())
+
+	stats := []UnpackStat{}
+	progress := NewUnpackProgress(2, 5)
+	progress.Report = func(stat UnpackStat) {
+		stats = append(stats, stat)
+	}
+	require.NoError(t, UnpackTargzWithProgress(context.Background(), t.TempDir(), &buf, false, progress))
+
+	require.NotEmpty(t, stats)
+	last := stats[len(stats)-1]
+	require.True(t, last.Done)
+	require.Equal(t, 2, last.Index)
+	require.Equal(t, 5, last.Total)
+	require.Equal(t, int64(4), last.Files)
+	require.Equal(t, size, last.Bytes)
+
+	// The final progress isn't reported on failure.
+	stats = []UnpackStat{}
+	progress = NewUnpackProgress(1, 1)
+	progress.Report = func(stat UnpackStat) {
+		stats = append(stats, stat)
+	}
+	progress.Start()
+	progress.Done(errors.New("unpack failed"))
+	require.Empty(t, stats)
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = 30 * time.Second
+	// maxRetryAfter bounds the delay requested by the Retry-After header,
+	// so that a misbehaving registry can't stall the conversion.
+	maxRetryAfter = 5 * time.Minute
+)
+
+// RetryPolicy is the policy retrying the requests failed by transient
+// errors, like 429 Too Many Requests, 5xx responses or network errors.
+type RetryPolicy struct {
+	// Retries is the max number of retries after the first attempt, 0
+	// disables the retry.
+	Retries int
+	// Backoff is the delay before the first retry, which is doubled for
+	// each following retry up to MaxBackoff, then up to half of the delay
+	// is randomized as jitter. The Retry-After header of response takes
+	// precedence if it requests a longer delay.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// NewRetryPolicy returns the retry policy with default backoff.
+func NewRetryPolicy(retries int) RetryPolicy {
+	return RetryPolicy{
+		Retries:    retries,
+		Backoff:    defaultRetryBackoff,
+		MaxBackoff: defaultRetryMaxBackoff,
+	}
+}
+
+// Delay returns the delay before the retry after the attempt failed, which
+// starts from 0, the resp is the failed response if any.
+func (policy RetryPolicy) Delay(attempt int, resp *http.Response) time.Duration {
+	delay := policy.Backoff
+	for idx := 0; idx < attempt && (policy.MaxBackoff <= 0 || delay < policy.MaxBackoff); idx++ {
+		delay *= 2
+	}
+	if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	if delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+	if resp != nil {
+		if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After")); retryAfter > delay {
+			delay = retryAfter
+		}
+	}
+	return delay
+}
+
+// parseRetryAfter parses the Retry-After header in either seconds or HTTP
+// date, 0 is returned if it's invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	}
+	if delay < 0 {
+		return 0
+	}
+	if delay > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return delay
+}
+
+// RetryableStatus returns true if the request failed by the status code may
+// succeed by retrying.
+func RetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// SleepContext sleeps for the delay, it returns early with the error of
+// context once the context is done.
+func SleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RetryTransport is the HTTP transport retrying the requests by policy. The
+// request with body is retried only if the body can be replayed by GetBody,
+// e.g. the manifest, but not the blob streamed from pipe.
+type RetryTransport struct {
+	Transport http.RoundTripper
+	Policy    RetryPolicy
+}
+
+func (transport *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retries := transport.Policy.Retries
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		retries = 0
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := transport.Transport.RoundTrip(req)
+		if attempt >= retries || req.Context().Err() != nil {
+			return resp, err
+		}
+		if err == nil && !RetryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		delay := transport.Policy.Delay(attempt, resp)
+		entry := logrus.WithFields(logrus.Fields{
+			"method":  req.Method,
+			"url":     req.URL.Redacted(),
+			"attempt": attempt + 1,
+			"delay":   delay,
+		})
+		if err != nil {
+			entry.WithError(err).Warn("registry request failed, retrying")
+		} else {
+			entry.Warnf("registry request failed with status %s, retrying", resp.Status)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		if err := SleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
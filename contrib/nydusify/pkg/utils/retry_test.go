@@ -0,0 +1,82 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Retries: 3, Backoff: time.Second, MaxBackoff: 4 * time.Second}
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		delay := policy.Delay(attempt, nil)
+		require.True(t, delay >= max/2 && delay <= max)
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"10"}}}
+	require.Equal(t, 10*time.Second, policy.Delay(0, resp))
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	require.Equal(t, maxRetryAfter, policy.Delay(0, resp))
+	resp.Header.Set("Retry-After", "invalid")
+	require.True(t, policy.Delay(0, resp) <= time.Second)
+}
+
+func TestRetryTransport(t *testing.T) {
+	var requests [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, body)
+		switch len(requests) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &RetryTransport{
+		Transport: http.DefaultTransport,
+		Policy:    RetryPolicy{Retries: 3},
+	}}
+	// The replayable body is sent again on retry.
+	resp, err := client.Post(server.URL, "application/json", bytes.NewReader([]byte("manifest")))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, [][]byte{[]byte("manifest"), []byte("manifest"), []byte("manifest")}, requests)
+
+	// The streamed body can't be replayed.
+	requests = nil
+	reader, writer := io.Pipe()
+	go func() {
+		writer.Write([]byte("blob"))
+		writer.Close()
+	}()
+	resp, err = client.Post(server.URL, "application/octet-stream", reader)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Len(t, requests, 1)
+
+	// The retry is disabled.
+	requests = nil
+	client.Transport.(*RetryTransport).Policy.Retries = 0
+	resp, err = client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Len(t, requests, 1)
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"archive/tar"
+	"strconv"
+	"strings"
+)
+
+// sparseBlockSize is the granularity used to detect and punch holes,
+// it matches the page size used by most local filesystems.
+const sparseBlockSize = 4096
+
+// SparseHole is a hole of sparse file.
+type SparseHole struct {
+	Offset int64
+	Length int64
+}
+
+// sparseHoles returns whether hdr is the entry of a sparse file, and its
+// holes. The tar reader expands the holes into zeros, but only exposes the
+// sparse map of the GNU PAX 0.x formats, so the holes of the old GNU and
+// the GNU PAX 1.0 formats are nil, which are detected as all-zero blocks.
+func sparseHoles(hdr *tar.Header) (bool, []SparseHole) {
+	switch {
+	case hdr.Typeflag == tar.TypeGNUSparse:
+		return true, nil
+	case hdr.Typeflag != tar.TypeReg:
+		return false, nil
+	case hdr.PAXRecords["GNU.sparse.major"] == "1":
+		return true, nil
+	}
+	sparseMap, ok := hdr.PAXRecords["GNU.sparse.map"]
+	if !ok {
+		return false, nil
+	}
+
+	holes := []SparseHole{}
+	var offset int64
+	fields := strings.Split(sparseMap, ",")
+	for idx := 0; idx+1 < len(fields); idx += 2 {
+		dataOffset, err1 := strconv.ParseInt(fields[idx], 10, 64)
+		dataLength, err2 := strconv.ParseInt(fields[idx+1], 10, 64)
+		if err1 != nil || err2 != nil || dataOffset < offset {
+			// The tar reader has validated the map, it should never happen.
+			return true, nil
+		}
+		if dataOffset > offset {
+			holes = append(holes, SparseHole{Offset: offset, Length: dataOffset - offset})
+		}
+		offset = dataOffset + dataLength
+	}
+	if hdr.Size > offset {
+		holes = append(holes, SparseHole{Offset: offset, Length: hdr.Size - offset})
+	}
+	return true, holes
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// PunchHoles deallocates the holes of a regular file in place, the file
+// size and content are unchanged, but the holes no longer occupy disk
+// space. It's used to restore the holes of sparse files which are expanded
+// by the tar reader during unpack, so that large database or VM image
+// files don't balloon the work directory. The all-zero blocks are punched
+// if holes is nil.
+//
+// The mode and times of file are kept, and filesystems that don't support
+// hole punching are left untouched.
+func PunchHoles(path string, holes []SparseHole) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return errors.Wrapf(err, "stat file %s", path)
+	}
+	mode := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if mode&0200 == 0 {
+		// The read-only file is unpacked by non-root user.
+		if err := os.Chmod(path, mode|0200); err != nil {
+			return errors.Wrapf(err, "make file %s writable", path)
+		}
+	}
+
+	punchErr := punchHoles(path, holes)
+
+	// Punching hole removes the setuid bits and updates the mtime.
+	if err := os.Chmod(path, mode); err != nil && punchErr == nil {
+		punchErr = errors.Wrapf(err, "restore mode of file %s", path)
+	}
+	atime := info.ModTime()
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		atime = time.Unix(stat.Atim.Unix())
+	}
+	if err := os.Chtimes(path, atime, info.ModTime()); err != nil && punchErr == nil {
+		punchErr = errors.Wrapf(err, "restore times of file %s", path)
+	}
+	return punchErr
+}
+
+func punchHoles(path string, holes []SparseHole) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return errors.Wrapf(err, "open file %s", path)
+	}
+	defer file.Close()
+
+	if holes == nil {
+		if holes, err = zeroBlocks(file); err != nil {
+			return errors.Wrapf(err, "read file %s", path)
+		}
+	}
+	for _, hole := range holes {
+		if hole.Length <= 0 {
+			continue
+		}
+		if err := unix.Fallocate(
+			int(file.Fd()),
+			unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE,
+			hole.Offset,
+			hole.Length,
+		); err != nil {
+			if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+				return nil
+			}
+			return errors.Wrapf(err, "punch hole in file %s", path)
+		}
+	}
+	return nil
+}
+
+// zeroBlocks returns the all-zero blocks of file as holes.
+func zeroBlocks(file *os.File) ([]SparseHole, error) {
+	zero := make([]byte, sparseBlockSize)
+	buf := make([]byte, sparseBlockSize)
+
+	holes := []SparseHole{}
+	var offset int64
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 && bytes.Equal(buf[:n], zero[:n]) {
+			if last := len(holes) - 1; last >= 0 && holes[last].Offset+holes[last].Length == offset {
+				holes[last].Length += int64(n)
+			} else {
+				holes = append(holes, SparseHole{Offset: offset, Length: int64(n)})
+			}
+		}
+		offset += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return holes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
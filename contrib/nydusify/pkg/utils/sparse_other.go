@@ -0,0 +1,13 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package utils
+
+// PunchHoles leaves the file untouched, as hole punching by fallocate is
+// only supported on Linux.
+func PunchHoles(_ string, _ []SparseHole) error {
+	return nil
+}
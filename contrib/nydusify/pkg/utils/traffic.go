@@ -0,0 +1,323 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// redacted replaces the secrets in the recorded HTTP traffic.
+const redacted = "REDACTED"
+
+// maxRedactBodySize is the size limit of the JSON response body checked for
+// tokens, the larger one is an image blob rather than a token response.
+const maxRedactBodySize = 1 << 20
+
+// HTTPExchange is a request and its response recorded in HTTP traffic file,
+// the secrets in headers, query and token responses are redacted.
+type HTTPExchange struct {
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	RequestHeader http.Header `json:"request_header,omitempty"`
+	// Error is the transport error, e.g. connection refused, the response
+	// is absent if it's set.
+	Error          string      `json:"error,omitempty"`
+	StatusCode     int         `json:"status_code,omitempty"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ContentLength  int64       `json:"content_length,omitempty"`
+	ResponseBody   []byte      `json:"response_body,omitempty"`
+}
+
+// httpTraffic records or replays the HTTP traffic with registries and
+// storage backends, it's set by the global `--http-record` or
+// `--http-replay` option.
+var httpTraffic struct {
+	sync.Mutex
+	// file and encoder are set in record mode.
+	file    *os.File
+	encoder *json.Encoder
+	// err is the first error of recording, reported on close.
+	err error
+	// exchanges are the recorded ones not replayed yet by the key of
+	// method and URL, they are set in replay mode.
+	exchanges map[string][]HTTPExchange
+}
+
+// RecordHTTPTraffic records the HTTP traffic of the transports wrapped by
+// WrapTransport into the JSON lines file of path, the file should be closed
+// by CloseHTTPTraffic.
+func RecordHTTPTraffic(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "create HTTP traffic file")
+	}
+	httpTraffic.Lock()
+	defer httpTraffic.Unlock()
+	httpTraffic.file = file
+	httpTraffic.encoder = json.NewEncoder(file)
+	httpTraffic.err = nil
+	httpTraffic.exchanges = nil
+	return nil
+}
+
+// ReplayHTTPTraffic makes the transports wrapped by WrapTransport respond
+// with the exchanges recorded in the file of path instead of sending the
+// requests, the exchanges of the same method and URL are replayed in the
+// recorded order.
+func ReplayHTTPTraffic(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "open HTTP traffic file")
+	}
+	defer file.Close()
+
+	exchanges := make(map[string][]HTTPExchange)
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var exchange HTTPExchange
+		if err := decoder.Decode(&exchange); err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "decode HTTP traffic file %s", path)
+		}
+		key := exchange.Method + " " + exchange.URL
+		exchanges[key] = append(exchanges[key], exchange)
+	}
+
+	httpTraffic.Lock()
+	defer httpTraffic.Unlock()
+	httpTraffic.file = nil
+	httpTraffic.encoder = nil
+	httpTraffic.exchanges = exchanges
+	return nil
+}
+
+// CloseHTTPTraffic stops recording or replaying the HTTP traffic.
+func CloseHTTPTraffic() error {
+	httpTraffic.Lock()
+	defer httpTraffic.Unlock()
+	file, err := httpTraffic.file, httpTraffic.err
+	httpTraffic.file = nil
+	httpTraffic.encoder = nil
+	httpTraffic.err = nil
+	httpTraffic.exchanges = nil
+	if file == nil {
+		return nil
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrap(err, "record HTTP traffic")
+}
+
+// HTTPTrafficEnabled returns whether the HTTP traffic is recorded or
+// replayed.
+func HTTPTrafficEnabled() bool {
+	httpTraffic.Lock()
+	defer httpTraffic.Unlock()
+	return httpTraffic.encoder != nil || httpTraffic.exchanges != nil
+}
+
+// WrapTransport returns the transport recording or replaying the HTTP
+// traffic if enabled, otherwise the transport itself.
+func WrapTransport(transport http.RoundTripper) http.RoundTripper {
+	httpTraffic.Lock()
+	defer httpTraffic.Unlock()
+	switch {
+	case httpTraffic.encoder != nil:
+		return &recordTransport{transport: transport}
+	case httpTraffic.exchanges != nil:
+		return &replayTransport{}
+	default:
+		return transport
+	}
+}
+
+type recordTransport struct {
+	transport http.RoundTripper
+}
+
+func (transport *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange := HTTPExchange{
+		Method:        req.Method,
+		URL:           redactURL(req.URL),
+		RequestHeader: redactHeader(req.Header),
+	}
+	resp, err := transport.transport.RoundTrip(req)
+	if err != nil {
+		exchange.Error = err.Error()
+		recordExchange(exchange)
+		return nil, err
+	}
+	exchange.StatusCode = resp.StatusCode
+	exchange.ResponseHeader = redactHeader(resp.Header)
+	exchange.ContentLength = resp.ContentLength
+	// The exchange is recorded once the body is read or closed, so that
+	// the blob is streamed to caller as before.
+	resp.Body = &recordBody{ReadCloser: resp.Body, exchange: exchange}
+	return resp, nil
+}
+
+type recordBody struct {
+	io.ReadCloser
+	exchange HTTPExchange
+	buf      bytes.Buffer
+	once     sync.Once
+}
+
+func (body *recordBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.buf.Write(p[:n])
+	if err == io.EOF {
+		body.record()
+	}
+	return n, err
+}
+
+func (body *recordBody) Close() error {
+	body.record()
+	return body.ReadCloser.Close()
+}
+
+func (body *recordBody) record() {
+	body.once.Do(func() {
+		body.exchange.ResponseBody = redactBody(body.buf.Bytes())
+		recordExchange(body.exchange)
+	})
+}
+
+func recordExchange(exchange HTTPExchange) {
+	httpTraffic.Lock()
+	defer httpTraffic.Unlock()
+	if httpTraffic.encoder == nil {
+		return
+	}
+	if err := httpTraffic.encoder.Encode(exchange); err != nil && httpTraffic.err == nil {
+		httpTraffic.err = err
+	}
+}
+
+type replayTransport struct{}
+
+func (transport *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+
+	key := req.Method + " " + redactURL(req.URL)
+	httpTraffic.Lock()
+	exchanges := httpTraffic.exchanges[key]
+	if len(exchanges) > 0 {
+		httpTraffic.exchanges[key] = exchanges[1:]
+	}
+	httpTraffic.Unlock()
+	if len(exchanges) == 0 {
+		return nil, fmt.Errorf("no recorded response for %s", key)
+	}
+
+	exchange := exchanges[0]
+	if exchange.Error != "" {
+		return nil, errors.New(exchange.Error)
+	}
+	if exchange.ResponseHeader == nil {
+		exchange.ResponseHeader = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.StatusCode, http.StatusText(exchange.StatusCode)),
+		StatusCode:    exchange.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        exchange.ResponseHeader,
+		Body:          io.NopCloser(bytes.NewReader(exchange.ResponseBody)),
+		ContentLength: exchange.ContentLength,
+		Request:       req,
+	}, nil
+}
+
+// secretName returns true if the header or query parameter of name carries
+// secret, e.g. the registry token, the signature and credential of object
+// storages.
+func secretName(name string) bool {
+	name = strings.ToLower(name)
+	if name == "sig" {
+		return true
+	}
+	for _, pattern := range []string{"authorization", "cookie", "token", "signature", "credential", "secret", "accesskeyid"} {
+		if strings.Contains(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactHeader(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	result := header.Clone()
+	for name := range result {
+		if secretName(name) {
+			result[name] = []string{redacted}
+		}
+	}
+	return result
+}
+
+func redactURL(u *url.URL) string {
+	redactedURL := *u
+	redactedURL.User = nil
+	query := redactedURL.Query()
+	changed := false
+	for name := range query {
+		if secretName(name) {
+			query[name] = []string{redacted}
+			changed = true
+		}
+	}
+	if changed {
+		redactedURL.RawQuery = query.Encode()
+	}
+	return redactedURL.String()
+}
+
+// redactBody redacts the tokens in the JSON response body of registry token
+// server, the other bodies are returned as is.
+func redactBody(body []byte) []byte {
+	if len(body) == 0 || len(body) > maxRedactBodySize || body[0] != '{' {
+		return body
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		return body
+	}
+	changed := false
+	for name := range object {
+		if secretName(name) {
+			object[name] = json.RawMessage(`"` + redacted + `"`)
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	result, err := json.Marshal(object)
+	if err != nil {
+		return body
+	}
+	return result
+}
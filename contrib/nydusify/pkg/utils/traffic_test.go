@@ -0,0 +1,98 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPTraffic(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"token":"secret-token","expires_in":300}`))
+		case "/blob":
+			w.Header().Set("Docker-Content-Digest", "sha256:1234")
+			w.Write([]byte("blob data"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer CloseHTTPTraffic()
+
+	get := func(client *http.Client, path string) (*http.Response, []byte, error) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer secret-token")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, body, nil
+	}
+
+	path := filepath.Join(t.TempDir(), "traffic.json")
+	require.NoError(t, RecordHTTPTraffic(path))
+	require.True(t, HTTPTrafficEnabled())
+	client := &http.Client{Transport: WrapTransport(http.DefaultTransport)}
+	_, body, err := get(client, "/token")
+	require.NoError(t, err)
+	require.Equal(t, `{"token":"secret-token","expires_in":300}`, string(body))
+	_, body, err = get(client, "/blob?X-Amz-Signature=secret-signature&partNumber=1")
+	require.NoError(t, err)
+	require.Equal(t, "blob data", string(body))
+	resp, _, err := get(client, "/missing")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.NoError(t, CloseHTTPTraffic())
+	require.False(t, HTTPTrafficEnabled())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "secret-")
+	require.Contains(t, string(data), "REDACTED")
+
+	// The traffic is replayed without the server.
+	server.Close()
+	require.NoError(t, ReplayHTTPTraffic(path))
+	client = &http.Client{Transport: WrapTransport(http.DefaultTransport)}
+	_, body, err = get(client, "/token")
+	require.NoError(t, err)
+	require.Equal(t, `{"expires_in":300,"token":"REDACTED"}`, string(body))
+	resp, body, err = get(client, "/blob?partNumber=1&X-Amz-Signature=another-signature")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "sha256:1234", resp.Header.Get("Docker-Content-Digest"))
+	require.Equal(t, int64(len("blob data")), resp.ContentLength)
+	require.Equal(t, "blob data", string(body))
+	resp, _, err = get(client, "/missing")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_, _, err = get(client, "/missing")
+	require.ErrorContains(t, err, "no recorded response for GET "+server.URL+"/missing")
+	require.NoError(t, CloseHTTPTraffic())
+
+	// The transport error is recorded and replayed too.
+	require.NoError(t, RecordHTTPTraffic(path))
+	client = &http.Client{Transport: WrapTransport(http.DefaultTransport)}
+	_, _, err = get(client, "/blob")
+	require.Error(t, err)
+	require.NoError(t, CloseHTTPTraffic())
+	require.NoError(t, ReplayHTTPTraffic(path))
+	client = &http.Client{Transport: WrapTransport(http.DefaultTransport)}
+	_, _, err = get(client, "/blob")
+	require.ErrorContains(t, err, "connection refused")
+}
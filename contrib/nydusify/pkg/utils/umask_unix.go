@@ -0,0 +1,14 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package utils
+
+import "golang.org/x/sys/unix"
+
+// Umask sets the umask of process and returns the previous one.
+func Umask(mask int) int {
+	return unix.Umask(mask)
+}
@@ -0,0 +1,10 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+// Umask is a no-op as there is no umask on Windows.
+func Umask(_ int) int {
+	return 0
+}
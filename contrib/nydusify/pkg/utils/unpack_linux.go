@@ -0,0 +1,627 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/pkg/userns"
+	"github.com/containerd/continuity/fs"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const fastUnpackSupported = true
+
+// unpackBufferedFileSize is the max size of regular files buffered in
+// memory and written by the workers, the larger files are written by the
+// reading goroutine directly.
+const unpackBufferedFileSize = 256 << 10
+
+const (
+	whiteoutPrefix    = ".wh."
+	whiteoutOpaqueDir = whiteoutPrefix + whiteoutPrefix + ".opq"
+	paxSchilyXattr    = "SCHILY.xattr."
+)
+
+var (
+	minUnpackTime = time.Unix(0, 0)
+	maxUnpackTime = time.Unix(0, 1<<63-1)
+)
+
+// unpackDir is a resolved parent directory of the tar entries.
+type unpackDir struct {
+	path string
+	// setgid is set if the new entries in directory inherit its group
+	// rather than the one of process.
+	setgid bool
+}
+
+// unpackJob writes a regular file created by the reading goroutine and
+// applies its metadata, then closes it.
+type unpackJob struct {
+	fd    int
+	data  []byte
+	hdr   *tar.Header
+	chown bool
+}
+
+// unpackDirTime is the times of a directory applied after all entries are
+// unpacked, as creating entries in it changes the mtime.
+type unpackDirTime struct {
+	path string
+	hdr  *tar.Header
+	// resets is the resets count of cached directories when the directory
+	// is unpacked, its path is resolved again if any entry is replaced.
+	resets int
+}
+
+// layerUnpacker applies a layer tar stream on a directory in the same way
+// as the applier of containerd, but it's optimized for the layers with a
+// large number of small files, e.g. the node_modules or the datasets of
+// machine learning:
+//
+//   - the resolved parent directories are cached, so that the path of each
+//     entry isn't resolved against symlinks component by component;
+//   - the entries are created exclusively, the existing entry is looked up
+//     and replaced only if the creation fails, and the missing parents are
+//     created once for all entries in them;
+//   - the ownership is only changed if it differs from the one of newly
+//     created entries, and the times of directories are applied in a batch
+//     after all entries are unpacked;
+//   - the small files are buffered in memory and written by a pool of
+//     workers, so that the syscalls of different files overlap.
+type layerUnpacker struct {
+	root     string
+	filter   func(*tar.Header) (bool, error)
+	whiteout whiteoutMode
+
+	uid int
+	gid int
+	// dirs caches the resolved parent directories by the names in tar.
+	dirs    map[string]*unpackDir
+	rootDir *unpackDir
+	resets  int
+	// unpacked records the unpacked paths for the opaque whiteouts, only
+	// if the whiteouts are removing files.
+	unpacked map[string]struct{}
+	dirTimes []unpackDirTime
+	buf      []byte
+
+	jobs    chan *unpackJob
+	pending sync.WaitGroup
+	workers sync.WaitGroup
+	errLock sync.Mutex
+	jobErr  error
+}
+
+// unpackLayer applies the tar stream on root by layerUnpacker, with the
+// small files written by `workers` workers.
+func unpackLayer(ctx context.Context, root string, r io.Reader, filter func(*tar.Header) (bool, error), whiteout whiteoutMode, workers int) error {
+	root = filepath.Clean(root)
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+
+	unpacker := &layerUnpacker{
+		root:     root,
+		filter:   filter,
+		whiteout: whiteout,
+		uid:      os.Geteuid(),
+		gid:      os.Getegid(),
+		dirs:     map[string]*unpackDir{},
+		rootDir:  &unpackDir{path: root, setgid: info.Mode()&os.ModeSetgid != 0},
+		jobs:     make(chan *unpackJob, workers*4),
+	}
+	if whiteout == whiteoutRemove {
+		unpacker.unpacked = map[string]struct{}{}
+	}
+	for idx := 0; idx < workers; idx++ {
+		unpacker.workers.Add(1)
+		go unpacker.work()
+	}
+
+	err = unpacker.unpack(ctx, r)
+	close(unpacker.jobs)
+	unpacker.workers.Wait()
+	if err != nil {
+		return err
+	}
+	if err := unpacker.err(); err != nil {
+		return err
+	}
+
+	for _, dir := range unpacker.dirTimes {
+		path := dir.path
+		if dir.resets != unpacker.resets {
+			if path, err = fs.RootPath(root, dir.hdr.Name); err != nil {
+				return errors.Wrap(err, "get root path")
+			}
+		}
+		if err := setTimes(path, dir.hdr); err != nil && !errors.Is(err, unix.ENOENT) {
+			return errors.Wrapf(err, "set times of %s", path)
+		}
+	}
+
+	return nil
+}
+
+func (u *layerUnpacker) unpack(ctx context.Context, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if err := u.err(); err != nil {
+			return err
+		}
+
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Normalize name, for safety and for a simple is-root check
+		hdr.Name = filepath.Clean(hdr.Name)
+		if u.filter != nil {
+			accept, err := u.filter(hdr)
+			if err != nil {
+				return err
+			}
+			if !accept {
+				continue
+			}
+		}
+
+		if err := u.apply(hdr, tr); err != nil {
+			return errors.Wrapf(err, "unpack %s", hdr.Name)
+		}
+	}
+}
+
+func (u *layerUnpacker) apply(hdr *tar.Header, reader io.Reader) error {
+	switch hdr.Typeflag {
+	case tar.TypeXGlobalHeader:
+		return nil
+	case tar.TypeBlock, tar.TypeChar:
+		if userns.RunningInUserNS() {
+			logrus.Warnf("file %q ignored: device can't be created in user namespace", hdr.Name)
+			return nil
+		}
+	}
+
+	ppath, base := filepath.Split(hdr.Name)
+	parent, err := u.resolve(ppath)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(parent.path, filepath.Join("/", base))
+	if path == u.root {
+		return nil
+	}
+
+	if strings.HasPrefix(base, whiteoutPrefix) {
+		if base != whiteoutOpaqueDir && !strings.HasPrefix(filepath.Join(parent.path, base[len(whiteoutPrefix):]), parent.path+"/") {
+			return errors.Errorf("invalid whiteout name %s", base)
+		}
+		if u.whiteout != whiteoutKeep {
+			return u.applyWhiteout(hdr, parent, base)
+		}
+	}
+
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		fresh, err := u.create(parent, path, true, func() error {
+			return unix.Mkdir(path, mode&0777)
+		})
+		if err != nil {
+			return err
+		}
+		if err := u.setMetadata(hdr, path, u.needChown(hdr, parent, fresh)); err != nil {
+			return err
+		}
+		// The new directory inherits the setgid bit of parent, which is
+		// cleared by chmod to the mode in tar.
+		if !fresh || mode&^0777 != 0 || parent.setgid {
+			if err := unix.Chmod(path, mode); err != nil {
+				return err
+			}
+		}
+		u.dirs[hdr.Name+"/"] = &unpackDir{path: path, setgid: mode&unix.S_ISGID != 0}
+		u.dirTimes = append(u.dirTimes, unpackDirTime{path: path, hdr: hdr, resets: u.resets})
+
+	case tar.TypeReg:
+		var fd int
+		fresh, err := u.create(parent, path, false, func() (err error) {
+			fd, err = unix.Open(path, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_CLOEXEC, mode&0777)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		job := &unpackJob{fd: fd, hdr: hdr, chown: u.needChown(hdr, parent, fresh)}
+		if hdr.Size <= unpackBufferedFileSize {
+			job.data = make([]byte, hdr.Size)
+			_, err = io.ReadFull(reader, job.data)
+		} else {
+			err = u.copyFile(fd, reader)
+		}
+		if err != nil {
+			unix.Close(fd)
+			return err
+		}
+		u.pending.Add(1)
+		u.jobs <- job
+
+	case tar.TypeSymlink:
+		fresh, err := u.create(parent, path, false, func() error {
+			return unix.Symlink(hdr.Linkname, path)
+		})
+		if err != nil {
+			return err
+		}
+		if err := u.setMetadata(hdr, path, u.needChown(hdr, parent, fresh)); err != nil {
+			return err
+		}
+		if err := setTimes(path, hdr); err != nil {
+			return err
+		}
+
+	case tar.TypeLink:
+		target, err := u.linkTarget(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		// The metadata of link is applied to the same inode of target,
+		// so the pending writing of target must be done first.
+		u.pending.Wait()
+		if _, err := u.create(parent, path, false, func() error {
+			return unix.Link(target, path)
+		}); err != nil {
+			return err
+		}
+		if err := u.setMetadata(hdr, path, true); err != nil {
+			return err
+		}
+		var stat unix.Stat_t
+		if err := unix.Lstat(path, &stat); err != nil {
+			return err
+		}
+		if stat.Mode&unix.S_IFMT != unix.S_IFLNK {
+			if err := unix.Chmod(path, mode); err != nil {
+				return err
+			}
+		}
+		if err := setTimes(path, hdr); err != nil {
+			return err
+		}
+
+	case tar.TypeBlock, tar.TypeChar, tar.TypeFifo:
+		dev := int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))
+		fileType := map[byte]uint32{
+			tar.TypeBlock: unix.S_IFBLK,
+			tar.TypeChar:  unix.S_IFCHR,
+			tar.TypeFifo:  unix.S_IFIFO,
+		}[hdr.Typeflag]
+		fresh, err := u.create(parent, path, false, func() error {
+			return unix.Mknod(path, fileType|mode&0777, dev)
+		})
+		if err != nil {
+			return err
+		}
+		if err := u.setMetadata(hdr, path, u.needChown(hdr, parent, fresh)); err != nil {
+			return err
+		}
+		if mode&^0777 != 0 {
+			if err := unix.Chmod(path, mode); err != nil {
+				return err
+			}
+		}
+		if err := setTimes(path, hdr); err != nil {
+			return err
+		}
+
+	default:
+		return errors.Errorf("unhandled tar header type %d", hdr.Typeflag)
+	}
+
+	if u.unpacked != nil {
+		u.unpacked[path] = struct{}{}
+	}
+	return nil
+}
+
+// resolve returns the directory of name in root with symlinks resolved,
+// the name is a parent path split from the names in tar.
+func (u *layerUnpacker) resolve(name string) (*unpackDir, error) {
+	if name == "" {
+		return u.rootDir, nil
+	}
+	if dir, ok := u.dirs[name]; ok {
+		return dir, nil
+	}
+	path, err := fs.RootPath(u.root, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get root path")
+	}
+	dir := &unpackDir{path: path}
+	if info, err := os.Stat(path); err == nil {
+		dir.setgid = info.Mode()&os.ModeSetgid != 0
+	}
+	u.dirs[name] = dir
+	return dir, nil
+}
+
+// create creates an entry at path by op, the missing parent directories
+// are created and the existing entry is replaced if op fails for them. It
+// returns false if the directory is merged into an existing one.
+func (u *layerUnpacker) create(parent *unpackDir, path string, isDir bool, op func() error) (bool, error) {
+	err := op()
+	if errors.Is(err, unix.ENOENT) {
+		if err := os.MkdirAll(parent.path, 0755); err != nil {
+			return false, err
+		}
+		// The created parents inherit the setgid bit of ancestor.
+		info, statErr := os.Stat(parent.path)
+		if statErr != nil {
+			return false, statErr
+		}
+		parent.setgid = info.Mode()&os.ModeSetgid != 0
+		err = op()
+	}
+	if !errors.Is(err, unix.EEXIST) {
+		return err == nil, err
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false, err
+	}
+	if isDir && info.IsDir() {
+		return false, nil
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return false, err
+	}
+	// The cached directories may be under the replaced one.
+	u.resetDirs()
+	return true, op()
+}
+
+// needChown returns whether the ownership of entry is different from the
+// one it's created with.
+func (u *layerUnpacker) needChown(hdr *tar.Header, parent *unpackDir, fresh bool) bool {
+	return !fresh || parent.setgid || hdr.Uid != u.uid || hdr.Gid != u.gid
+}
+
+func (u *layerUnpacker) setMetadata(hdr *tar.Header, path string, chown bool) error {
+	if chown {
+		if err := unix.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+			return errors.Wrapf(err, "lchown for uid %d, gid %d", hdr.Uid, hdr.Gid)
+		}
+	}
+	return setXattrs(hdr, func(key string, value []byte) error {
+		return unix.Lsetxattr(path, key, value, 0)
+	})
+}
+
+func (u *layerUnpacker) linkTarget(name string) (string, error) {
+	ppath, base := filepath.Split(name)
+	parent, err := u.resolve(ppath)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(parent.path, base)
+	if !strings.HasPrefix(target, u.root) {
+		target = u.root
+	}
+	return target, nil
+}
+
+func (u *layerUnpacker) applyWhiteout(hdr *tar.Header, parent *unpackDir, base string) error {
+	if err := os.MkdirAll(parent.path, 0755); err != nil {
+		return err
+	}
+
+	if base == whiteoutOpaqueDir {
+		if u.whiteout == whiteoutOverlay {
+			return unix.Setxattr(parent.path, "trusted.overlay.opaque", []byte{'y'}, 0)
+		}
+		u.resetDirs()
+		return filepath.Walk(parent.path, func(path string, _ os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					// Parent was deleted
+					err = nil
+				}
+				return err
+			}
+			if path == parent.path {
+				return nil
+			}
+			if _, ok := u.unpacked[path]; !ok {
+				return os.RemoveAll(path)
+			}
+			return nil
+		})
+	}
+
+	path := filepath.Join(parent.path, base[len(whiteoutPrefix):])
+	if u.whiteout == whiteoutOverlay {
+		if err := unix.Mknod(path, unix.S_IFCHR, 0); err != nil {
+			return err
+		}
+		return os.Chown(path, hdr.Uid, hdr.Gid)
+	}
+	u.resetDirs()
+	return os.RemoveAll(path)
+}
+
+// copyFile writes the large file by the reading goroutine.
+func (u *layerUnpacker) copyFile(fd int, reader io.Reader) error {
+	if u.buf == nil {
+		u.buf = make([]byte, 1<<20)
+	}
+	for {
+		n, err := io.ReadFull(reader, u.buf)
+		if n > 0 {
+			if err := writeAll(fd, u.buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (u *layerUnpacker) resetDirs() {
+	u.resets++
+	if len(u.dirs) > 0 {
+		u.dirs = map[string]*unpackDir{}
+	}
+}
+
+func (u *layerUnpacker) work() {
+	defer u.workers.Done()
+	for job := range u.jobs {
+		if u.err() == nil {
+			if err := writeFile(job); err != nil {
+				u.setErr(errors.Wrapf(err, "unpack %s", job.hdr.Name))
+			}
+		}
+		unix.Close(job.fd)
+		u.pending.Done()
+	}
+}
+
+func (u *layerUnpacker) err() error {
+	u.errLock.Lock()
+	defer u.errLock.Unlock()
+	return u.jobErr
+}
+
+func (u *layerUnpacker) setErr(err error) {
+	u.errLock.Lock()
+	defer u.errLock.Unlock()
+	if u.jobErr == nil {
+		u.jobErr = err
+	}
+}
+
+// writeFile writes the buffered data of regular file, and applies the
+// metadata by the file descriptor.
+func writeFile(job *unpackJob) error {
+	if err := writeAll(job.fd, job.data); err != nil {
+		return err
+	}
+	hdr := job.hdr
+	if job.chown {
+		if err := unix.Fchown(job.fd, hdr.Uid, hdr.Gid); err != nil {
+			return errors.Wrapf(err, "fchown for uid %d, gid %d", hdr.Uid, hdr.Gid)
+		}
+	}
+	if err := setXattrs(hdr, func(key string, value []byte) error {
+		return unix.Fsetxattr(job.fd, key, value, 0)
+	}); err != nil {
+		return err
+	}
+	// Call fchmod after fchown since fchown clears the setuid bits.
+	if hdr.Mode&^0777 != 0 {
+		if err := unix.Fchmod(job.fd, uint32(hdr.Mode&07777)); err != nil {
+			return err
+		}
+	}
+	atime, mtime := unpackTimes(hdr)
+	return unix.Futimes(job.fd, []unix.Timeval{
+		unix.NsecToTimeval(atime.UnixNano()),
+		unix.NsecToTimeval(mtime.UnixNano()),
+	})
+}
+
+func writeAll(fd int, data []byte) error {
+	for len(data) > 0 {
+		n, err := unix.Write(fd, data)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// setXattrs sets the extended attributes in PAX records by set, the ones
+// unsupported by the filesystem are ignored as containerd does.
+func setXattrs(hdr *tar.Header, set func(key string, value []byte) error) error {
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, paxSchilyXattr) {
+			continue
+		}
+		key = key[len(paxSchilyXattr):]
+		// Do not set trusted attributes
+		var err error = unix.ENOTSUP
+		if !strings.HasPrefix(key, "trusted.") {
+			err = set(key, []byte(value))
+		}
+		if err == nil {
+			continue
+		}
+		// In the user.* namespace, only regular files and directories
+		// can have extended attributes.
+		if errors.Is(err, unix.ENOTSUP) || (errors.Is(err, unix.EPERM) && strings.HasPrefix(key, "user.") &&
+			hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir) {
+			logrus.WithError(err).Warnf("ignored xattr %s in archive", key)
+			continue
+		}
+		return errors.Wrapf(err, "set xattr %s", key)
+	}
+	return nil
+}
+
+func setTimes(path string, hdr *tar.Header) error {
+	atime, mtime := unpackTimes(hdr)
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		unix.NsecToTimespec(mtime.UnixNano()),
+	}, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// unpackTimes returns the access and modification times of entry, the
+// access time is never earlier than the modification time.
+func unpackTimes(hdr *tar.Header) (time.Time, time.Time) {
+	atime := hdr.AccessTime
+	if atime.Before(hdr.ModTime) {
+		atime = hdr.ModTime
+	}
+	return boundUnpackTime(atime), boundUnpackTime(hdr.ModTime)
+}
+
+func boundUnpackTime(t time.Time) time.Time {
+	if t.Before(minUnpackTime) || t.After(maxUnpackTime) {
+		return minUnpackTime
+	}
+	return t
+}
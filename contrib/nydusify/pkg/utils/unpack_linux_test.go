@@ -0,0 +1,190 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+type tarEntry struct {
+	hdr  tar.Header
+	data []byte
+}
+
+func buildTar(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		hdr := entry.hdr
+		hdr.Size = int64(len(entry.data))
+		if hdr.ModTime.IsZero() {
+			hdr.ModTime = time.Unix(1600000000, 0)
+		}
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write(entry.data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// snapshotDir describes the type, ownership, mode, mtime, xattrs and data
+// of all entries in dir, the atime is changed by reading.
+func snapshotDir(t *testing.T, dir string) []string {
+	lines := []string{}
+	require.NoError(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		require.NoError(t, err)
+		var stat unix.Stat_t
+		require.NoError(t, unix.Lstat(path, &stat))
+		line := fmt.Sprintf("%s mode=%o uid=%d gid=%d nlink=%d rdev=%d mtime=%d",
+			strings.TrimPrefix(path, dir), stat.Mode, stat.Uid, stat.Gid, stat.Nlink, stat.Rdev, stat.Mtim.Sec)
+		switch {
+		case info.Mode().IsRegular():
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			line += " data=" + digest.FromBytes(data).Encoded()[:12]
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			require.NoError(t, err)
+			line += " link=" + target
+		}
+		size, err := unix.Llistxattr(path, nil)
+		require.NoError(t, err)
+		if size > 0 {
+			buf := make([]byte, size)
+			size, err = unix.Llistxattr(path, buf)
+			require.NoError(t, err)
+			keys := strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00")
+			sort.Strings(keys)
+			for _, key := range keys {
+				value := make([]byte, 256)
+				n, err := unix.Lgetxattr(path, key, value)
+				require.NoError(t, err)
+				line += fmt.Sprintf(" %s=%s", key, value[:n])
+			}
+		}
+		lines = append(lines, line)
+		return nil
+	}))
+	return lines
+}
+
+// requireSameUnpack unpacks the layers by both the optimized unpacker and
+// the applier of containerd, and compares the results.
+func requireSameUnpack(t *testing.T, unpack func(dst string, layer []byte) error, layers ...[]byte) []string {
+	workers := UnpackWorkers
+	defer func() {
+		UnpackWorkers = workers
+	}()
+
+	snapshots := [][]string{}
+	for _, workers := range []int{0, 4} {
+		UnpackWorkers = workers
+		dst := filepath.Join(t.TempDir(), "rootfs")
+		for _, layer := range layers {
+			require.NoError(t, unpack(dst, layer))
+		}
+		snapshots = append(snapshots, snapshotDir(t, dst))
+	}
+	require.Equal(t, snapshots[0], snapshots[1])
+	return snapshots[1]
+}
+
+func TestUnpackLayer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("root is required to change ownership and create devices")
+	}
+
+	large := bytes.Repeat([]byte("large file"), unpackBufferedFileSize/5)
+	layer := buildTar(t, []tarEntry{
+		{hdr: tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: time.Unix(1500000000, 0)}},
+		{hdr: tar.Header{Name: "dir/small", Typeflag: tar.TypeReg, Mode: 0644, Uid: 1000, Gid: 1000}, data: []byte("small")},
+		{hdr: tar.Header{Name: "dir/large", Typeflag: tar.TypeReg, Mode: 0600}, data: large},
+		{hdr: tar.Header{Name: "dir/setuid", Typeflag: tar.TypeReg, Mode: 04755}, data: []byte("setuid")},
+		{hdr: tar.Header{Name: "dir/xattr", Typeflag: tar.TypeReg, Mode: 0644, PAXRecords: map[string]string{
+			"SCHILY.xattr.user.nydus": "value",
+		}}, data: []byte("xattr")},
+		{hdr: tar.Header{Name: "dir/symlink", Typeflag: tar.TypeSymlink, Linkname: "small", Uid: 1000, Gid: 1000}},
+		{hdr: tar.Header{Name: "dir/hardlink", Typeflag: tar.TypeLink, Linkname: "dir/small", Mode: 0600, Uid: 1000, Gid: 1000}},
+		{hdr: tar.Header{Name: "implicit/parents/file", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("implicit")},
+		{hdr: tar.Header{Name: "sgid/", Typeflag: tar.TypeDir, Mode: 02775, Gid: 50}},
+		{hdr: tar.Header{Name: "sgid/sub/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "sgid/file", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("sgid")},
+		{hdr: tar.Header{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0600}},
+		{hdr: tar.Header{Name: "null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3}},
+		// The entries replaced by later ones.
+		{hdr: tar.Header{Name: "replaced", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("file")},
+		{hdr: tar.Header{Name: "replaced/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "replaced/file", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("in dir")},
+		{hdr: tar.Header{Name: "dir/small", Typeflag: tar.TypeReg, Mode: 0640}, data: []byte("small again")},
+		{hdr: tar.Header{Name: "tolink/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "tolink/file", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("removed")},
+		{hdr: tar.Header{Name: "tolink", Typeflag: tar.TypeSymlink, Linkname: "dir"}},
+		{hdr: tar.Header{Name: "tolink/via-link", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("via link")},
+		// The symlinks are resolved in root.
+		{hdr: tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/"}},
+		{hdr: tar.Header{Name: "escape/etc/passwd", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("root")},
+		{hdr: tar.Header{Name: "../outside", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("outside")},
+		{hdr: tar.Header{Name: ".wh.kept", Typeflag: tar.TypeReg, Mode: 0644}},
+	})
+
+	snapshot := requireSameUnpack(t, func(dst string, layer []byte) error {
+		return UnpackTargz(context.Background(), dst, bytes.NewReader(layer), false)
+	}, layer)
+	require.Len(t, snapshot, 25)
+}
+
+func TestUnpackLayerWhiteout(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("root is required to change ownership and create devices")
+	}
+
+	lower := buildTar(t, []tarEntry{
+		{hdr: tar.Header{Name: "opaque/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "opaque/lower", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("lower")},
+		{hdr: tar.Header{Name: "opaque/sub/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "opaque/sub/lower", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("lower")},
+		{hdr: tar.Header{Name: "removed/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "removed/file", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("lower")},
+		{hdr: tar.Header{Name: "sgid/", Typeflag: tar.TypeDir, Mode: 02755, Gid: 50}},
+	})
+	upper := buildTar(t, []tarEntry{
+		{hdr: tar.Header{Name: "opaque/", Typeflag: tar.TypeDir, Mode: 0700}},
+		{hdr: tar.Header{Name: "opaque/upper", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("upper")},
+		{hdr: tar.Header{Name: "opaque/.wh..wh..opq", Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: ".wh.removed", Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: "removed/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "new/.wh.missing", Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: "sgid/file", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("upper")},
+		{hdr: tar.Header{Name: "sgid/dir/", Typeflag: tar.TypeDir, Mode: 0755}},
+	})
+
+	snapshot := requireSameUnpack(t, func(dst string, layer []byte) error {
+		return ApplyTargz(context.Background(), dst, bytes.NewReader(layer))
+	}, lower, upper)
+	require.Len(t, snapshot, 8)
+
+	snapshot = requireSameUnpack(t, func(dst string, layer []byte) error {
+		return UnpackTargz(context.Background(), dst, bytes.NewReader(layer), true)
+	}, upper)
+	require.Len(t, snapshot, 9)
+
+	// The invalid whiteout is refused.
+	invalid := buildTar(t, []tarEntry{{hdr: tar.Header{Name: "dir/.wh..", Typeflag: tar.TypeReg}}})
+	require.ErrorContains(t, UnpackTargz(context.Background(), t.TempDir(), bytes.NewReader(invalid), true), "invalid whiteout name")
+}
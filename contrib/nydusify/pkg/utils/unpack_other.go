@@ -0,0 +1,23 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package utils
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const fastUnpackSupported = false
+
+// unpackLayer fails as the optimized unpacker is only supported on Linux,
+// the applier of containerd is used instead.
+func unpackLayer(_ context.Context, _ string, _ io.Reader, _ func(*tar.Header) (bool, error), _ whiteoutMode, _ int) error {
+	return errors.New("optimized unpacker is only supported on Linux")
+}
@@ -6,7 +6,6 @@ package utils
 
 import (
 	"archive/tar"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -82,19 +81,7 @@ func RetryWithHTTP(err error) bool {
 }
 
 func MarshalToDesc(data interface{}, mediaType string) (*ocispec.Descriptor, []byte, error) {
-	bytes, err := json.Marshal(data)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	dataDigest := digest.FromBytes(bytes)
-	desc := ocispec.Descriptor{
-		Digest:    dataDigest,
-		Size:      int64(len(bytes)),
-		MediaType: mediaType,
-	}
-
-	return &desc, bytes, nil
+	return MarshalToDescWithAlgorithm(data, mediaType, digest.Canonical)
 }
 
 func IsNydusPlatform(platform *ocispec.Platform) bool {
@@ -178,7 +165,18 @@ func IsPathExists(path string) bool {
 	return false
 }
 
+// ShellQuote quotes s as a single word of POSIX shell, for the paths and
+// arguments written into the wrapper scripts of builder.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// HashFile calculates the blake3 hash of file data, it's refused in FIPS
+// mode as blake3 isn't an approved algorithm.
 func HashFile(path string) ([]byte, error) {
+	if fipsMode {
+		return nil, errors.New("hash algorithm blake3 of file data is not allowed in FIPS mode")
+	}
 	hasher := blake3.New(32, nil)
 
 	file, err := os.Open(path)
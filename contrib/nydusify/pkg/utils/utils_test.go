@@ -8,6 +8,7 @@ package utils
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/tls"
 	"io"
 	"net/http"
 	"os"
@@ -270,3 +271,53 @@ func TestGetNydusFsVersionOrDefault(t *testing.T) {
 	fsVersion = GetNydusFsVersionOrDefault(testAnnotations, V5)
 	require.Equal(t, fsVersion, V5)
 }
+
+func TestParseDigestAlgorithm(t *testing.T) {
+	alg, err := ParseDigestAlgorithm("")
+	require.NoError(t, err)
+	require.Equal(t, digest.SHA256, alg)
+
+	alg, err = ParseDigestAlgorithm("sha512")
+	require.NoError(t, err)
+	require.Equal(t, digest.SHA512, alg)
+
+	_, err = ParseDigestAlgorithm("md5")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported digest algorithm")
+}
+
+func TestDigestFromEncoded(t *testing.T) {
+	sha256Digest := digest.SHA256.FromString("nydus")
+	require.Equal(t, sha256Digest, DigestFromEncoded(sha256Digest.Encoded()))
+
+	sha512Digest := digest.SHA512.FromString("nydus")
+	require.Equal(t, sha512Digest, DigestFromEncoded(sha512Digest.Encoded()))
+
+	desc, _, err := MarshalToDescWithAlgorithm(map[string]string{}, "application/json", digest.SHA512)
+	require.NoError(t, err)
+	require.Equal(t, digest.SHA512, desc.Digest.Algorithm())
+}
+
+func TestFIPSMode(t *testing.T) {
+	require.False(t, IsFIPSMode())
+	require.NoError(t, CheckFIPSDigestAlgorithm("blake3"))
+	require.Empty(t, NewTLSConfig(false).CipherSuites)
+
+	fipsMode = true
+	defer func() {
+		fipsMode = false
+	}()
+	require.NoError(t, CheckFIPSDigestAlgorithm(digest.SHA512))
+	require.Error(t, CheckFIPSDigestAlgorithm("blake3"))
+	_, err := HashFile(os.DevNull)
+	require.ErrorContains(t, err, "blake3")
+	config := NewTLSConfig(true)
+	require.True(t, config.InsecureSkipVerify)
+	require.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+}
+
+func TestShellQuote(t *testing.T) {
+	require.Equal(t, `'/usr/bin/nydus-image'`, ShellQuote("/usr/bin/nydus-image"))
+	require.Equal(t, `'it'\''s'`, ShellQuote("it's"))
+	require.Equal(t, `''`, ShellQuote(""))
+}
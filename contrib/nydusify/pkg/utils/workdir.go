@@ -0,0 +1,121 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	workDirPrefix   = "nydusify-"
+	workDirMetaFile = ".nydusify-meta.json"
+)
+
+// workDirMeta records the owner of a temp directory in work directory, so
+// that the leftovers of crashed runs can be recognized and cleaned.
+type workDirMeta struct {
+	PID       int       `json:"pid"`
+	StartTime time.Time `json:"start_time"`
+	// Kept means the directory is kept on purpose for debugging, it's
+	// never removed as a leftover.
+	Kept bool `json:"kept,omitempty"`
+}
+
+// MkdirTemp creates a temp directory in base for current process, with the
+// metadata of owner PID and start time.
+func MkdirTemp(base string) (string, error) {
+	dir, err := os.MkdirTemp(base, workDirPrefix)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(workDirMeta{PID: os.Getpid(), StartTime: time.Now()})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, workDirMetaFile), data, 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", errors.Wrap(err, "write work directory metadata")
+	}
+	return dir, nil
+}
+
+// KeepTempDir marks the temp directory created by MkdirTemp to be kept, so
+// it isn't removed by CleanStaleDirs after the owner process exits.
+func KeepTempDir(dir string) error {
+	path := filepath.Join(dir, workDirMetaFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "read work directory metadata")
+	}
+	var meta workDirMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return errors.Wrap(err, "unmarshal work directory metadata")
+	}
+	meta.Kept = true
+	if data, err = json.Marshal(meta); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrap(err, "write work directory metadata")
+	}
+	return nil
+}
+
+// CleanStaleDirs removes the temp directories in base created longer than
+// maxAge ago whose owner process has exited. The directories without
+// metadata, created by old versions, are aged by modification time.
+func CleanStaleDirs(base string, maxAge time.Duration) error {
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "read directory %s", base)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), workDirPrefix) {
+			continue
+		}
+		dir := filepath.Join(base, entry.Name())
+
+		var meta workDirMeta
+		if data, err := os.ReadFile(filepath.Join(dir, workDirMetaFile)); err == nil {
+			if err := json.Unmarshal(data, &meta); err != nil {
+				logrus.WithError(err).Warnf("invalid metadata of work directory %s", dir)
+				continue
+			}
+			if meta.Kept || meta.PID == os.Getpid() || ProcessAlive(meta.PID) {
+				continue
+			}
+		} else {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			meta.StartTime = info.ModTime()
+		}
+
+		if age := time.Since(meta.StartTime); age > maxAge {
+			logrus.WithFields(logrus.Fields{
+				"dir": dir,
+				"pid": meta.PID,
+				"age": age.Round(time.Second),
+			}).Info("removing stale work directory")
+			if err := os.RemoveAll(dir); err != nil {
+				logrus.WithError(err).Warnf("failed to remove stale work directory %s", dir)
+			}
+		}
+	}
+
+	return nil
+}
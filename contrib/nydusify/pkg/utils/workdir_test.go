@@ -0,0 +1,57 @@
+// Copyright 2023 Nydus Developers. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanStaleDirs(t *testing.T) {
+	base := t.TempDir()
+
+	// Owned by current process.
+	own, err := MkdirTemp(base)
+	require.NoError(t, err)
+
+	writeMeta := func(name string, meta workDirMeta) string {
+		dir := filepath.Join(base, name)
+		require.NoError(t, os.Mkdir(dir, 0755))
+		data, err := json.Marshal(meta)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, workDirMetaFile), data, 0644))
+		return dir
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	// The PID of init process is always alive.
+	alive := writeMeta("nydusify-alive", workDirMeta{PID: 1, StartTime: old})
+	stale := writeMeta("nydusify-stale", workDirMeta{PID: 1 << 30, StartTime: old})
+	recent := writeMeta("nydusify-recent", workDirMeta{PID: 1 << 30, StartTime: time.Now()})
+	kept := writeMeta("nydusify-kept", workDirMeta{PID: 1 << 30, StartTime: old})
+	require.NoError(t, KeepTempDir(kept))
+
+	legacy := filepath.Join(base, "nydusify-legacy")
+	require.NoError(t, os.Mkdir(legacy, 0755))
+	require.NoError(t, os.Chtimes(legacy, old, old))
+	other := filepath.Join(base, "other")
+	require.NoError(t, os.Mkdir(other, 0755))
+	require.NoError(t, os.Chtimes(other, old, old))
+
+	require.NoError(t, CleanStaleDirs(base, time.Hour))
+	for _, dir := range []string{own, alive, recent, kept, other} {
+		require.DirExists(t, dir)
+	}
+	for _, dir := range []string{stale, legacy} {
+		_, err := os.Stat(dir)
+		require.True(t, os.IsNotExist(err), dir)
+	}
+
+	require.NoError(t, CleanStaleDirs(filepath.Join(base, "not-exist"), time.Hour))
+}
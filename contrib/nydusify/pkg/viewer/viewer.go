@@ -44,6 +44,7 @@ type FsViewer struct {
 	Opt
 	Parser       *parser.Parser
 	NydusdConfig tool.NydusdConfig
+	nydusd       *tool.Nydusd
 }
 
 // New creates fsViewer instance, Target is the Nydus image reference
@@ -143,6 +144,7 @@ func (fsViewer *FsViewer) MountImage() error {
 	if err := nydusd.Mount(); err != nil {
 		return errors.Wrap(err, "failed to mount Nydus image")
 	}
+	fsViewer.nydusd = nydusd
 
 	return nil
 }
@@ -203,6 +205,9 @@ func (fsViewer *FsViewer) view(ctx context.Context) error {
 
 	logrus.Infof("Please send signal SIGINT/SIGTERM to umount the file system")
 	<-done
+	if err := fsViewer.nydusd.Umount(false); err != nil {
+		return errors.Wrap(err, "failed to umount Nydus image")
+	}
 	if err := os.RemoveAll(fsViewer.WorkDir); err != nil {
 		return errors.Wrap(err, "failed to clean up working directory")
 	}